@@ -0,0 +1,175 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"context"
+	"time"
+)
+
+// defaultAutoMergeRatio is the AutoMergeRatio used when the option is zero.
+const defaultAutoMergeRatio = 0.5
+
+// autoMerge runs a pass of the auto merge every interval until the db is closed.
+func (db *DB) autoMerge(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	ctx, cancel := stopContext(stop)
+	defer cancel()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			// a failed pass is retried by the next tick.
+			_, _ = db.autoMergePass(ctx, stop)
+		}
+	}
+}
+
+// autoMergePass merges the data files picked by pickAutoMergeFiles as Merge does, the oldest first and each
+// in its own tx, and waits after each one as long as AutoMergeRate requires. It returns the number of files merged.
+//
+// Merge drops the deletes, which is only safe once no older file holds the keys they delete: merging
+// the oldest files in order keeps every file merged the oldest one.
+func (db *DB) autoMergePass(ctx context.Context, stop <-chan struct{}) (int, error) {
+	db.mergeMu.Lock()
+	defer db.mergeMu.Unlock()
+
+	lastFID, ok, err := db.pickAutoMergeFiles()
+	if err != nil || !ok {
+		return 0, err
+	}
+
+	merged := 0
+	for {
+		start := time.Now()
+		size, ok, err := db.mergeOldestFile(ctx, lastFID)
+		if err != nil || !ok {
+			return merged, err
+		}
+		merged++
+		if !db.waitAutoMergeRate(size, time.Since(start), stop) {
+			return merged, nil
+		}
+	}
+}
+
+// pickAutoMergeFiles returns the id of the newest data file of the longest run of the oldest sealed ones
+// of which at least AutoMergeRatio of the bytes are garbage.
+func (db *DB) pickAutoMergeFiles() (int64, bool, error) {
+	tx, err := db.Begin(true)
+	if err != nil {
+		return 0, false, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var (
+		ratio         = db.opt.autoMergeRatio()
+		now           = tx.db.timestamp()
+		garbage, size float64
+		lastFID       int64
+		ok            bool
+	)
+	_, dataFileIds := db.getMaxFileIDAndFileIDs()
+	for _, id := range dataFileIds {
+		fID := int64(id)
+		if fID == db.ActiveFile.fileID {
+			break
+		}
+
+		stat, err := db.loadFileStat(fID)
+		if err != nil {
+			return 0, false, err
+		}
+		size += float64(stat.size)
+		if stat.isExpired(now) {
+			garbage += float64(stat.size)
+		} else {
+			// each file of the run is the oldest one by the time it is merged.
+			garbage += stat.garbageRatio(true) * float64(stat.size)
+		}
+		if size > 0 && garbage >= ratio*size {
+			lastFID, ok = fID, true
+		}
+	}
+	return lastFID, ok, nil
+}
+
+// mergeOldestFile merges in a single tx the oldest data file when its id is at most lastFID, and returns its size.
+func (db *DB) mergeOldestFile(ctx context.Context, lastFID int64) (int64, bool, error) {
+	db.tombstoneMu.Lock()
+	defer db.tombstoneMu.Unlock()
+
+	tx, err := db.BeginWithContext(ctx, true)
+	if err != nil {
+		return 0, false, err
+	}
+
+	_, dataFileIds := db.getMaxFileIDAndFileIDs()
+	if len(dataFileIds) == 0 || int64(dataFileIds[0]) > lastFID || int64(dataFileIds[0]) == db.ActiveFile.fileID {
+		_ = tx.Rollback()
+		return 0, false, nil
+	}
+	fID := int64(dataFileIds[0])
+
+	stat, err := db.loadFileStat(fID)
+	if err == nil {
+		err = tx.mergeFile(ctx, fID, make(map[string]struct{}))
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, false, err
+	}
+
+	// the statistics of the data files are shared with the commits.
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return stat.size, true, db.removeDataFile(fID)
+}
+
+// waitAutoMergeRate waits after a data file of given size was merged in elapsed as long as AutoMergeRate
+// requires, it returns false once the db is closed.
+func (db *DB) waitAutoMergeRate(size int64, elapsed time.Duration, stop <-chan struct{}) bool {
+	var wait time.Duration
+	if rate := db.opt.AutoMergeRate; rate > 0 {
+		wait = time.Duration(float64(size)/float64(rate)*float64(time.Second)) - elapsed
+	}
+	if wait <= 0 {
+		select {
+		case <-stop:
+			return false
+		default:
+			return true
+		}
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-stop:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_AutoMergePass(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestautomerge", true)
+	opt.SegmentSize = 1024
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	bucket := "bucket"
+	key := func(prefix string, i int) []byte {
+		return []byte(fmt.Sprintf("%s_%d", prefix, i))
+	}
+	for i := 0; i < 20; i++ {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			if err := tx.Put(bucket, key("key", i), []byte("value"), Persistent); err != nil {
+				return err
+			}
+			return tx.SAdd("set", []byte("members"), key("member", i))
+		}))
+	}
+	for i := 0; i < 20; i++ {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			if err := tx.Delete(bucket, key("key", i)); err != nil {
+				return err
+			}
+			return tx.SRem("set", []byte("members"), key("member", i))
+		}))
+	}
+	for i := 0; i < 40; i++ {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.Put(bucket, key("live", i%10), []byte(fmt.Sprintf("value_%d", i)), Persistent)
+		}))
+	}
+
+	_, before := db.getMaxFileIDAndFileIDs()
+	require.True(t, len(before) > 2)
+
+	merged, err := db.autoMergePass(context.Background(), nil)
+	require.NoError(t, err)
+	assert.True(t, merged > 0)
+
+	_, after := db.getMaxFileIDAndFileIDs()
+	assert.True(t, len(after) < len(before))
+	assert.True(t, after[0] > before[merged-1])
+
+	// the next pass finds too little garbage left.
+	merged, err = db.autoMergePass(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, 0, merged)
+
+	check := func() {
+		require.NoError(t, db.View(func(tx *Tx) error {
+			for i := 0; i < 20; i++ {
+				_, err := tx.Get(bucket, key("key", i))
+				assert.Error(t, err)
+				ok, _ := tx.SIsMember("set", []byte("members"), key("member", i))
+				assert.False(t, ok)
+			}
+			for i := 0; i < 10; i++ {
+				e, err := tx.Get(bucket, key("live", i))
+				require.NoError(t, err)
+				assert.Equal(t, []byte(fmt.Sprintf("value_%d", 30+i)), e.Value)
+			}
+			return nil
+		}))
+	}
+	check()
+
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	check()
+	require.NoError(t, db.Close())
+}
+
+func TestDB_AutoMergeEnable(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestautomergeenable", true)
+	opt.SegmentSize = 1024
+	db, err = Open(opt, WithAutoMerge(10*time.Millisecond, 0.5), WithAutoMergeRate(1<<20))
+	require.NoError(t, err)
+
+	bucket := "bucket"
+	for i := 0; i < 100; i++ {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.Put(bucket, []byte("key"), []byte(fmt.Sprintf("value_%d", i)), Persistent)
+		}))
+	}
+
+	assert.Eventually(t, func() bool {
+		var n int
+		require.NoError(t, db.View(func(tx *Tx) error {
+			_, ids := db.getMaxFileIDAndFileIDs()
+			n = len(ids)
+			return nil
+		}))
+		return n <= 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, db.View(func(tx *Tx) error {
+		e, err := tx.Get(bucket, []byte("key"))
+		require.NoError(t, err)
+		assert.Equal(t, []byte("value_99"), e.Value)
+		return nil
+	}))
+	require.NoError(t, db.Close())
+}
+
+func TestDB_WaitAutoMergeRate(t *testing.T) {
+	db := &DB{opt: Options{AutoMergeRate: 1000}}
+
+	start := time.Now()
+	assert.True(t, db.waitAutoMergeRate(50, 0, nil))
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+
+	stop := make(chan struct{})
+	close(stop)
+	assert.False(t, db.waitAutoMergeRate(1000, 0, stop))
+	assert.False(t, db.waitAutoMergeRate(0, 0, stop))
+}
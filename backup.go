@@ -0,0 +1,394 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// backupOffsetPAXRecord is the PAX record of the archives giving the offset in the file the content is written at.
+const backupOffsetPAXRecord = "NUTSDB.offset"
+
+var (
+	// ErrBackupCursor is returned by IncrementalBackup when the entries appended since the cursor
+	// are no longer all in the data files, e.g. as the files were removed by Merge.
+	ErrBackupCursor = errors.New("the entries since the backup cursor are no longer in the data files")
+
+	// ErrBackupFileName is returned by RestoreBackup when a file of the archive is not in the directory.
+	ErrBackupFileName = errors.New("the backup file is not in the restored directory")
+
+	// ErrBackupCorrupted is returned by Restore when an entry of the backup fails its checksum.
+	ErrBackupCorrupted = errors.New("the backup is corrupted")
+
+	// ErrRestoreDirNotEmpty is returned by Restore when the directory of the options already holds files.
+	ErrRestoreDirNotEmpty = errors.New("the restore directory is not empty")
+)
+
+// BackupCursor is the position in the data files and in the value log up to which a backup has copied
+// the entries and the values. The zero BackupCursor is before all of them.
+type BackupCursor struct {
+	FileID int64
+	Offset int64
+
+	ValueLogFileID int64
+	ValueLogOffset int64
+}
+
+// backupFile is the part of a file of the db streamed by a backup.
+type backupFile struct {
+	name   string
+	fd     *os.File
+	info   os.FileInfo
+	offset int64
+	end    int64
+}
+
+// BackupTo writes to w a tar archive of a point-in-time snapshot of the database, which is opened
+// with Open once extracted into a directory. The writes are only held while the data files are opened
+// and the end of the active one is read, then they go on while the archive is written: the data files
+// before the active one are never written again, and the active one is only read up to that end.
+// The data files removed meanwhile by Merge are still read through the handles opened.
+// The checkpoints are left out, the backup is recovered by replaying all its data files.
+func (db *DB) BackupTo(w io.Writer) error {
+	_, err := db.IncrementalBackup(w, BackupCursor{})
+	return err
+}
+
+// IncrementalBackup writes to w a tar archive of the entries appended since the cursor, as BackupTo does
+// for all of them, and returns the cursor of the next backup. The zero cursor backs up the whole database.
+// The archives are applied in order to a directory by RestoreBackup.
+//
+// The files removed by Merge or CompactTombstones are kept by the restored directory, whose replay comes
+// to the same state. Once a file holding entries since the cursor was removed, ErrBackupCursor is returned
+// and a backup from the zero cursor must be taken into a new directory.
+func (db *DB) IncrementalBackup(w io.Writer, since BackupCursor) (BackupCursor, error) {
+	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return since, ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	files, cursor, err := db.openBackupFiles(since)
+	defer func() {
+		for _, f := range files {
+			_ = f.fd.Close()
+		}
+	}()
+	if err != nil {
+		return since, err
+	}
+
+	tw := tar.NewWriter(w)
+	for _, f := range files {
+		header, err := tar.FileInfoHeader(f.info, "")
+		if err != nil {
+			return since, err
+		}
+		header.Name = f.name
+		header.Size = f.end - f.offset
+		if f.offset > 0 {
+			header.PAXRecords = map[string]string{backupOffsetPAXRecord: strconv.FormatInt(f.offset, 10)}
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			return since, err
+		}
+		if _, err := io.Copy(tw, io.NewSectionReader(f.fd, f.offset, header.Size)); err != nil {
+			return since, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return since, err
+	}
+	return cursor, nil
+}
+
+// openBackupFiles opens the data files and the value log files from the cursor and the bucket ids file as of the last committed tx,
+// and returns them along with the cursor at the end of the active files.
+func (db *DB) openBackupFiles(since BackupCursor) (files []*backupFile, cursor BackupCursor, err error) {
+	err = db.View(func(tx *Tx) error {
+		_, dataFileIds := db.getMaxFileIDAndFileIDs()
+		cursor = BackupCursor{FileID: db.ActiveFile.fileID, Offset: db.ActiveFile.writeOff}
+		if since != (BackupCursor{}) && !isBackupCursorValid(since, cursor, dataFileIds) {
+			return ErrBackupCursor
+		}
+
+		for _, id := range dataFileIds {
+			fID := int64(id)
+			if fID < since.FileID {
+				continue
+			}
+
+			offset, end := int64(0), int64(-1)
+			if fID == since.FileID {
+				offset = since.Offset
+			}
+			if fID == cursor.FileID {
+				end = cursor.Offset
+			}
+
+			f, err := openBackupFile(db.getDataPath(fID), strconv.Itoa(id)+DataSuffix, offset, end)
+			if err != nil {
+				return err
+			}
+			if f.offset >= f.end {
+				_ = f.fd.Close()
+				continue
+			}
+			files = append(files, f)
+		}
+
+		// the value log files are only appended to under the write lock, the ones before the cursor are
+		// never written again. The ones removed by RunValueLogGC are skipped, the values still pointed to
+		// were appended again past the cursor.
+		cursor.ValueLogFileID, cursor.ValueLogOffset = since.ValueLogFileID, since.ValueLogOffset
+		for _, fID := range db.vlog.ids() {
+			if fID < since.ValueLogFileID {
+				continue
+			}
+
+			offset := int64(0)
+			if fID == since.ValueLogFileID {
+				offset = since.ValueLogOffset
+			}
+
+			f, err := openBackupFile(db.vlog.path(fID), strconv.FormatInt(fID, 10)+valueLogSuffix, offset, -1)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			cursor.ValueLogFileID, cursor.ValueLogOffset = fID, f.end
+			if f.offset >= f.end {
+				_ = f.fd.Close()
+				continue
+			}
+			files = append(files, f)
+		}
+
+		// the bucket ids and the data keys are only appended but by the key rotations under the write lock,
+		// the ids and the keys added since are unused by the snapshot.
+		for _, side := range []struct{ path, name string }{
+			{db.bucketIDs.path, bucketIDsFileName},
+			{db.keys.path, dataKeysFileName},
+		} {
+			f, err := openBackupFile(side.path, side.name, 0, -1)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			files = append(files, f)
+		}
+		return nil
+	})
+	return files, cursor, err
+}
+
+// isBackupCursorValid returns whether all the data files from the cursor since to the cursor
+// at the end of the active file are still there.
+func isBackupCursorValid(since, cursor BackupCursor, dataFileIds []int) bool {
+	if since.FileID > cursor.FileID || (since.FileID == cursor.FileID && since.Offset > cursor.Offset) {
+		return false
+	}
+
+	// the data files are created with consecutive ids.
+	var n int64
+	for _, id := range dataFileIds {
+		if int64(id) >= since.FileID {
+			n++
+		}
+	}
+	return n == cursor.FileID-since.FileID+1
+}
+
+// openBackupFile opens the file at path to be streamed from offset up to end, or up to its size when end is negative.
+func openBackupFile(path, name string, offset, end int64) (*backupFile, error) {
+	fd, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+	info, err := fd.Stat()
+	if err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+	if end < 0 || end > info.Size() {
+		end = info.Size()
+	}
+	return &backupFile{name: name, fd: fd, info: info, offset: offset, end: end}, nil
+}
+
+// RestoreBackup writes the files of an archive written by BackupTo or IncrementalBackup into dir,
+// which is then opened with Open. The archives of the incremental backups are restored in the order
+// they were taken, over the directory restored from the first one.
+func RestoreBackup(dir string, r io.Reader) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	return restoreArchive(dir, tar.NewReader(r))
+}
+
+// Restore rebuilds the database in the empty opts.Dir from a backup stream and opens it. The stream holds
+// an archive written by BackupTo, or by IncrementalBackup from the zero cursor, followed by the archives
+// of the next incremental backups in order, e.g. joined by io.MultiReader: the database is restored
+// as of the last one. The checksums of all the entries restored are checked before Open rebuilds
+// the indexes from them, the files restored are removed when they fail.
+func Restore(opts Options, r io.Reader) (*DB, error) {
+	dir := opts.Dir
+	if f, err := os.Open(filepath.Clean(dir)); err == nil {
+		_, err = f.Readdirnames(1)
+		_ = f.Close()
+		if err != io.EOF {
+			return nil, ErrRestoreDirNotEmpty
+		}
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	db, err := restore(opts, r)
+	if err != nil {
+		removeDirContents(dir)
+		return nil, err
+	}
+	return db, nil
+}
+
+func restore(opts Options, r io.Reader) (*DB, error) {
+	cr := &countingReader{r: r}
+	for {
+		read := cr.n
+		if err := restoreArchive(opts.Dir, tar.NewReader(cr)); err != nil {
+			return nil, err
+		}
+		if cr.n == read {
+			break
+		}
+	}
+
+	if err := checkBackupEntries(opts.Dir, opts.BufferSizeOfRecovery); err != nil {
+		return nil, err
+	}
+	return Open(opts)
+}
+
+// restoreArchive writes the files of the archive into dir.
+func restoreArchive(dir string, tr *tar.Reader) error {
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := filepath.Clean(header.Name)
+		if name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+			return ErrBackupFileName
+		}
+
+		var offset int64
+		if v, ok := header.PAXRecords[backupOffsetPAXRecord]; ok {
+			if offset, err = strconv.ParseInt(v, 10, 64); err != nil {
+				return err
+			}
+		}
+		if err := restoreBackupFile(filepath.Join(dir, name), tr, offset, header.FileInfo().Mode()); err != nil {
+			return err
+		}
+	}
+}
+
+// checkBackupEntries checks the checksums of the entries of the data files restored into dir.
+func checkBackupEntries(dir string, bufSize int) error {
+	names, err := filepath.Glob(filepath.Join(dir, "*"+DataSuffix))
+	if err != nil {
+		return err
+	}
+
+	for _, path := range names {
+		fr, err := newFileRecovery(path, bufSize)
+		if err != nil {
+			return err
+		}
+		for {
+			entry, err := fr.readEntry()
+			if err == io.EOF || err == io.ErrUnexpectedEOF || (err == nil && entry == nil) {
+				break
+			}
+			if err != nil {
+				_ = fr.release()
+				return fmt.Errorf("%w: %s: %s", ErrBackupCorrupted, filepath.Base(path), err)
+			}
+		}
+		if err := fr.release(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeDirContents removes the files and the directories in dir.
+func removeDirContents(dir string) {
+	names, _ := filepath.Glob(filepath.Join(dir, "*"))
+	for _, name := range names {
+		_ = os.RemoveAll(name)
+	}
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// restoreBackupFile writes the content read from r into the file at path from offset.
+func restoreBackupFile(path string, r io.Reader, offset int64, mode os.FileMode) error {
+	f, err := os.OpenFile(filepath.Clean(path), os.O_CREATE|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if _, err = io.Copy(f, r); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,209 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"archive/tar"
+	"bufio"
+	"io"
+	"strconv"
+	"time"
+)
+
+// BackupFilter selects the buckets backed up by BackupBucketsTo.
+type BackupFilter struct {
+	// Include lists the buckets backed up, empty means all of them.
+	Include []string
+
+	// Exclude lists the buckets left out, it takes precedence over Include.
+	Exclude []string
+}
+
+// match returns whether the bucket is backed up. The internal buckets always are.
+func (f BackupFilter) match(bucket string) bool {
+	if isInternalBucket(bucket) {
+		return true
+	}
+	for _, b := range f.Exclude {
+		if b == bucket {
+			return false
+		}
+	}
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, b := range f.Include {
+		if b == bucket {
+			return true
+		}
+	}
+	return false
+}
+
+// BackupBucketsTo writes to w a tar archive of a point-in-time snapshot of the buckets selected by the filter,
+// which is restored as the archives of BackupTo are. The entries of the committed transactions are copied
+// into new data files instead of copying the data files, each marked as committed on its own: a transaction
+// writing to buckets left out is restored with its writes to the buckets selected, which keeps the backup
+// consistent however the buckets are split. The internal buckets of the db are always backed up.
+func (db *DB) BackupBucketsTo(w io.Writer, filter BackupFilter) error {
+	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	files, _, err := db.openBackupFiles(BackupCursor{})
+	defer func() {
+		for _, f := range files {
+			_ = f.fd.Close()
+		}
+	}()
+	if err != nil {
+		return err
+	}
+
+	// the bucket ids file is left out, the entries are written with the names of their buckets,
+	// and the data keys file is written once the entries are, with the data keys they are encrypted with.
+	var dataFiles []*backupFile
+	for _, f := range files {
+		if f.name != bucketIDsFileName && f.name != dataKeysFileName {
+			dataFiles = append(dataFiles, f)
+		}
+	}
+
+	committed := make(map[uint64]struct{})
+	err = db.readBackupEntries(dataFiles, func(e *Entry) error {
+		if e.Meta.Status == Committed {
+			committed[e.Meta.TxID] = struct{}{}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	kept := func(e *Entry) bool {
+		_, ok := committed[e.Meta.TxID]
+		return ok && filter.match(string(e.Bucket))
+	}
+
+	// the sizes of the data files are needed by their headers before their entries are written.
+	var sizes []int64
+	err = db.readBackupEntries(dataFiles, func(e *Entry) error {
+		if !kept(e) {
+			return nil
+		}
+		size := backupEntry(e).Size()
+		if len(sizes) == 0 || sizes[len(sizes)-1]+size > db.opt.SegmentSize {
+			sizes = append(sizes, 0)
+		}
+		sizes[len(sizes)-1] += size
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	fID, written := -1, int64(0)
+	err = db.readBackupEntries(dataFiles, func(e *Entry) error {
+		if !kept(e) {
+			return nil
+		}
+		if fID < 0 || written == sizes[fID] {
+			fID++
+			written = 0
+			header := &tar.Header{
+				Typeflag: tar.TypeReg,
+				Name:     strconv.Itoa(fID) + DataSuffix,
+				Size:     sizes[fID],
+				Mode:     0644,
+				ModTime:  time.Now(),
+			}
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+		}
+		if e.Meta.sealed() {
+			if err := db.keys.prepare(string(e.Bucket)); err != nil {
+				return err
+			}
+		}
+		e.keys = db.keys
+		buf := backupEntry(e).Encode()
+		written += int64(len(buf))
+		_, err := tw.Write(buf)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := db.keys.save(); err != nil {
+		return err
+	}
+	keys, err := db.keys.snapshot()
+	if err != nil {
+		return err
+	}
+	if keys != nil {
+		header := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     dataKeysFileName,
+			Size:     int64(len(keys)),
+			Mode:     0600,
+			ModTime:  time.Now(),
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(keys); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// readBackupEntries calls fn with the entries of the data files in order.
+func (db *DB) readBackupEntries(files []*backupFile, fn func(e *Entry) error) error {
+	for _, f := range files {
+		fr := &fileRecovery{reader: bufio.NewReaderSize(io.NewSectionReader(f.fd, 0, f.end), calBufferSize(db.opt.BufferSizeOfRecovery)), size: f.end}
+		for {
+			e, err := fr.readEntry()
+			if err == io.EOF || err == io.ErrUnexpectedEOF || (err == nil && e == nil) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if err := decodeEntry(db.keys, db.bucketIDs, e); err != nil {
+				return err
+			}
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// backupEntry makes the entry written by BackupBucketsTo: it stores the name of its bucket
+// instead of its id, and it is marked as committed.
+func backupEntry(e *Entry) *Entry {
+	if e.Meta.hasBucketID() {
+		e.Meta.BucketSize = e.Meta.BucketSize&(bucketCodecMask|bucketValueKindMask) | uint32(len(e.Bucket))
+		e.bucketID = nil
+	}
+	e.Meta.Status = Committed
+	return e
+}
@@ -0,0 +1,369 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// hookWriter runs the hook before the first write.
+type hookWriter struct {
+	w    io.Writer
+	hook func()
+}
+
+func (w *hookWriter) Write(p []byte) (int, error) {
+	if w.hook != nil {
+		w.hook()
+		w.hook = nil
+	}
+	return w.w.Write(p)
+}
+
+func TestDB_BackupTo(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestbackupto", true)
+	db, err = Open(opt, WithSegmentSize(1024), WithCompactBucketIDs(true))
+	require.NoError(t, err)
+
+	bucket := "bucket"
+	for i := 0; i < 40; i++ {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.Put(bucket, []byte(fmt.Sprintf("key_%d", i)), []byte(fmt.Sprintf("value_%d", i)), Persistent)
+		}))
+	}
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		if err := tx.RPush("list", []byte("key"), []byte("a"), []byte("b")); err != nil {
+			return err
+		}
+		if err := tx.SAdd("set", []byte("key"), []byte("a")); err != nil {
+			return err
+		}
+		return tx.ZAdd("zset", []byte("key"), 1, []byte("a"))
+	}))
+	_, fIDs := db.getMaxFileIDAndFileIDs()
+	require.True(t, len(fIDs) > 2)
+
+	// the writes and the merge go on while the archive is written.
+	var buf bytes.Buffer
+	w := &hookWriter{w: &buf, hook: func() {
+		done := make(chan error, 1)
+		go func() {
+			err := db.Update(func(tx *Tx) error {
+				if err := tx.Put(bucket, []byte("after"), []byte("value"), Persistent); err != nil {
+					return err
+				}
+				return tx.Delete(bucket, []byte("key_0"))
+			})
+			if err == nil {
+				err = db.Merge()
+			}
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			require.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("the writes are blocked by the backup")
+		}
+	}}
+	require.NoError(t, db.BackupTo(w))
+	require.NoError(t, db.Close())
+
+	dir := "/tmp/nutsdbtestbackupto_restore"
+	require.NoError(t, os.RemoveAll(dir))
+	require.NoError(t, RestoreBackup(dir, &buf))
+	restored, err := Open(opt, WithDir(dir), WithSegmentSize(1024), WithCompactBucketIDs(true))
+	require.NoError(t, err)
+
+	require.NoError(t, restored.View(func(tx *Tx) error {
+		for i := 0; i < 40; i++ {
+			e, err := tx.Get(bucket, []byte(fmt.Sprintf("key_%d", i)))
+			require.NoError(t, err)
+			assert.Equal(t, fmt.Sprintf("value_%d", i), string(e.Value))
+		}
+		_, err := tx.Get(bucket, []byte("after"))
+		assert.Error(t, err)
+
+		items, err := tx.LRange("list", []byte("key"), 0, -1)
+		require.NoError(t, err)
+		assert.Equal(t, [][]byte{[]byte("a"), []byte("b")}, items)
+		ok, err := tx.SIsMember("set", []byte("key"), []byte("a"))
+		require.NoError(t, err)
+		assert.True(t, ok)
+		n, err := tx.ZCard("zset")
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+		return nil
+	}))
+	require.NoError(t, restored.Close())
+}
+
+func TestDB_BackupTo_SparseIdxMode(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestbackuptosparse", true)
+	db, err = Open(opt, WithEntryIdxMode(HintBPTSparseIdxMode))
+	require.NoError(t, err)
+	assert.Equal(t, ErrNotSupportHintBPTSparseIdxMode, db.BackupTo(ioutil.Discard))
+	require.NoError(t, db.Close())
+}
+
+func TestDB_IncrementalBackup(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestincrementalbackup", true)
+	db, err = Open(opt, WithSegmentSize(1024))
+	require.NoError(t, err)
+
+	bucket := "bucket"
+	put := func(from, to int) {
+		for i := from; i < to; i++ {
+			require.NoError(t, db.Update(func(tx *Tx) error {
+				return tx.Put(bucket, []byte(fmt.Sprintf("key_%d", i)), []byte(fmt.Sprintf("value_%d", i)), Persistent)
+			}))
+		}
+	}
+
+	dir := "/tmp/nutsdbtestincrementalbackup_restore"
+	require.NoError(t, os.RemoveAll(dir))
+	backup := func(since BackupCursor) (BackupCursor, int) {
+		var buf bytes.Buffer
+		cursor, err := db.IncrementalBackup(&buf, since)
+		require.NoError(t, err)
+		n := buf.Len()
+		require.NoError(t, RestoreBackup(dir, &buf))
+		return cursor, n
+	}
+
+	put(0, 40)
+	cursor, full := backup(BackupCursor{})
+
+	put(40, 45)
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.Delete(bucket, []byte("key_0"))
+	}))
+	cursor, delta := backup(cursor)
+	assert.True(t, delta < full)
+
+	// nothing was appended since.
+	next, _ := backup(cursor)
+	assert.Equal(t, cursor, next)
+
+	restored, err := Open(opt, WithDir(dir), WithSegmentSize(1024))
+	require.NoError(t, err)
+	require.NoError(t, restored.View(func(tx *Tx) error {
+		_, err := tx.Get(bucket, []byte("key_0"))
+		assert.Error(t, err)
+		for i := 1; i < 45; i++ {
+			e, err := tx.Get(bucket, []byte(fmt.Sprintf("key_%d", i)))
+			require.NoError(t, err)
+			assert.Equal(t, fmt.Sprintf("value_%d", i), string(e.Value))
+		}
+		return nil
+	}))
+	require.NoError(t, restored.Close())
+
+	// the merge removes the file of the cursor.
+	put(45, 60)
+	require.NoError(t, db.Merge())
+	_, err = db.IncrementalBackup(ioutil.Discard, cursor)
+	assert.Equal(t, ErrBackupCursor, err)
+	_, err = db.IncrementalBackup(ioutil.Discard, BackupCursor{FileID: cursor.FileID + 100})
+	assert.Equal(t, ErrBackupCursor, err)
+	require.NoError(t, db.Close())
+}
+
+func TestDB_IncrementalBackup_ValueLog(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestincrementalbackupvlog", true)
+	opt.SegmentSize = 8 * 1024
+	opt.ValueThreshold = 64
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	bucket := "blobs"
+	value := func(i int) []byte {
+		return bytes.Repeat([]byte(fmt.Sprintf("%02d;", i)), 100)
+	}
+	put := func(from, to int) {
+		for i := from; i < to; i++ {
+			require.NoError(t, db.Update(func(tx *Tx) error {
+				return tx.Put(bucket, []byte(fmt.Sprintf("blob_%02d", i)), value(i), Persistent)
+			}))
+		}
+	}
+
+	dir := "/tmp/nutsdbtestincrementalbackupvlog_restore"
+	require.NoError(t, os.RemoveAll(dir))
+	backup := func(since BackupCursor) (BackupCursor, int) {
+		var buf bytes.Buffer
+		cursor, err := db.IncrementalBackup(&buf, since)
+		require.NoError(t, err)
+		n := buf.Len()
+		require.NoError(t, RestoreBackup(dir, &buf))
+		return cursor, n
+	}
+
+	put(0, 40)
+	cursor, full := backup(BackupCursor{})
+	assert.True(t, cursor.ValueLogFileID > 0 || cursor.ValueLogOffset > 0)
+
+	// only the values appended since are copied.
+	put(40, 42)
+	next, delta := backup(cursor)
+	assert.True(t, next.ValueLogFileID > cursor.ValueLogFileID ||
+		(next.ValueLogFileID == cursor.ValueLogFileID && next.ValueLogOffset > cursor.ValueLogOffset))
+	assert.True(t, delta < full/2)
+
+	// nothing was appended since.
+	last, _ := backup(next)
+	assert.Equal(t, next, last)
+	require.NoError(t, db.Close())
+
+	restored, err := Open(opt, WithDir(dir))
+	require.NoError(t, err)
+	require.NoError(t, restored.View(func(tx *Tx) error {
+		for i := 0; i < 42; i++ {
+			e, err := tx.Get(bucket, []byte(fmt.Sprintf("blob_%02d", i)))
+			require.NoError(t, err)
+			assert.Equal(t, value(i), e.Value)
+		}
+		return nil
+	}))
+	require.NoError(t, restored.Close())
+}
+
+func TestRestore(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestrestore", true)
+	db, err = Open(opt, WithSegmentSize(1024))
+	require.NoError(t, err)
+
+	bucket := "bucket"
+	put := func(from, to int, value string) {
+		for i := from; i < to; i++ {
+			require.NoError(t, db.Update(func(tx *Tx) error {
+				return tx.Put(bucket, []byte(fmt.Sprintf("key_%d", i)), []byte(value), Persistent)
+			}))
+		}
+	}
+
+	var full, delta, empty bytes.Buffer
+	put(0, 30, "v1")
+	cursor, err := db.IncrementalBackup(&full, BackupCursor{})
+	require.NoError(t, err)
+	put(10, 40, "v2")
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.Delete(bucket, []byte("key_0"))
+	}))
+	cursor, err = db.IncrementalBackup(&delta, cursor)
+	require.NoError(t, err)
+	_, err = db.IncrementalBackup(&empty, cursor)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	corrupted := append([]byte(nil), full.Bytes()...)
+	dir := "/tmp/nutsdbtestrestore_restored"
+	require.NoError(t, os.RemoveAll(dir))
+	restoreOpt := opt
+	restoreOpt.Dir = dir
+
+	restored, err := Restore(restoreOpt, io.MultiReader(&full, &delta, &empty))
+	require.NoError(t, err)
+	require.NoError(t, restored.View(func(tx *Tx) error {
+		_, err := tx.Get(bucket, []byte("key_0"))
+		assert.Error(t, err)
+		for i := 1; i < 40; i++ {
+			want := "v2"
+			if i < 10 {
+				want = "v1"
+			}
+			e, err := tx.Get(bucket, []byte(fmt.Sprintf("key_%d", i)))
+			require.NoError(t, err)
+			assert.Equal(t, want, string(e.Value))
+		}
+		return nil
+	}))
+	require.NoError(t, restored.Close())
+
+	_, err = Restore(restoreOpt, bytes.NewReader(corrupted))
+	assert.Equal(t, ErrRestoreDirNotEmpty, err)
+
+	// the first entry of the first file follows the header of the archive.
+	require.NoError(t, os.RemoveAll(dir))
+	corrupted[512+DataEntryHeaderSize] ^= 0xff
+	_, err = Restore(restoreOpt, bytes.NewReader(corrupted))
+	assert.True(t, errors.Is(err, ErrBackupCorrupted))
+	names, _ := filepath.Glob(filepath.Join(dir, "*"))
+	assert.Empty(t, names)
+}
+
+func TestDB_BackupBucketsTo(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestbackupbuckets", true)
+	db, err = Open(opt, WithSegmentSize(1024), WithCompactBucketIDs(true))
+	require.NoError(t, err)
+
+	for i := 0; i < 30; i++ {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			if err := tx.Put("config", []byte(fmt.Sprintf("key_%d", i)), []byte("config"), Persistent); err != nil {
+				return err
+			}
+			return tx.Put("cache", []byte(fmt.Sprintf("key_%d", i)), []byte("cache"), Persistent)
+		}))
+	}
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.Delete("config", []byte("key_0"))
+	}))
+
+	var configs, caches bytes.Buffer
+	require.NoError(t, db.BackupBucketsTo(&configs, BackupFilter{Exclude: []string{"cache"}}))
+	require.NoError(t, db.BackupBucketsTo(&caches, BackupFilter{Include: []string{"cache", "config"}, Exclude: []string{"config"}}))
+	require.NoError(t, db.Close())
+
+	// the writes of the txs are restored, although their last entries are in the cache bucket.
+	restore := func(r io.Reader, bucket, value string, skipped string) {
+		dir := "/tmp/nutsdbtestbackupbuckets_restored"
+		require.NoError(t, os.RemoveAll(dir))
+		restoreOpt := opt
+		restoreOpt.Dir = dir
+		restored, err := Restore(restoreOpt, r)
+		require.NoError(t, err)
+
+		names, _ := filepath.Glob(filepath.Join(dir, "*"+DataSuffix))
+		assert.True(t, len(names) > 1)
+		require.NoError(t, restored.View(func(tx *Tx) error {
+			for i := 0; i < 30; i++ {
+				e, err := tx.Get(bucket, []byte(fmt.Sprintf("key_%d", i)))
+				if bucket == "config" && i == 0 {
+					assert.Error(t, err)
+					continue
+				}
+				require.NoError(t, err)
+				assert.Equal(t, value, string(e.Value))
+			}
+			_, err := tx.Get(skipped, []byte("key_1"))
+			assert.Equal(t, ErrNotFoundBucket, err)
+			return nil
+		}))
+		require.NoError(t, restored.Close())
+	}
+	restore(&configs, "config", "config", "cache")
+	restore(&caches, "cache", "cache", "config")
+}
@@ -0,0 +1,159 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bench replays the workload traces recorded by a nutsdb.WorkloadRecorder,
+// so that the performance of a build can be measured against production-shaped workloads.
+package bench
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/nutsdb/nutsdb"
+)
+
+// ReplayOptions represents how a trace is replayed.
+type ReplayOptions struct {
+	// Paced waits between the transactions as long as they were apart when recorded, divided by Speed.
+	// Otherwise the transactions are replayed back to back.
+	Paced bool
+
+	// Speed divides the waits of a paced replay, 1 if zero.
+	Speed float64
+}
+
+// ReplayResult reports the transactions replayed and their latencies.
+type ReplayResult struct {
+	Txs     int
+	Ops     int
+	Elapsed time.Duration
+
+	// Latencies holds the duration of every replayed transaction, from Begin to the end of Commit, sorted.
+	Latencies []time.Duration
+}
+
+// Percentile returns the latency under which fall p percent of the transactions, p in [0, 100].
+func (r ReplayResult) Percentile(p float64) time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+	i := int(p / 100 * float64(len(r.Latencies)-1))
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(r.Latencies) {
+		i = len(r.Latencies) - 1
+	}
+	return r.Latencies[i]
+}
+
+// Replay runs the transactions of the trace read from r against the db, one after the other in commit order.
+// The buckets and the keys are derived from their hashes and the values are zeroes of the recorded size.
+// The reads and the deletes of keys not found are not errors, since the db does not hold the recorded dataset.
+func Replay(db *nutsdb.DB, r io.Reader, opts ReplayOptions) (ReplayResult, error) {
+	if opts.Speed <= 0 {
+		opts.Speed = 1
+	}
+
+	var (
+		result ReplayResult
+		ops    []nutsdb.TraceRecord
+		values []byte
+		tr     = nutsdb.NewTraceReader(r)
+		start  = time.Now()
+		first  = time.Duration(-1)
+	)
+
+	for {
+		rec, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return result, err
+		}
+
+		if rec.Op != nutsdb.TraceOpCommit {
+			ops = append(ops, rec)
+			if int(rec.ValueSize) > len(values) {
+				values = make([]byte, rec.ValueSize)
+			}
+			continue
+		}
+
+		if opts.Paced {
+			if first < 0 {
+				first = rec.Offset
+			}
+			wait := time.Duration(float64(rec.Offset-first)/opts.Speed) - time.Since(start)
+			if wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+
+		txStart := time.Now()
+		fn := func(tx *nutsdb.Tx) error {
+			return replayOps(tx, ops, values)
+		}
+		if rec.Writable {
+			err = db.Update(fn)
+		} else {
+			err = db.View(fn)
+		}
+		if err != nil {
+			return result, err
+		}
+
+		result.Txs++
+		result.Ops += len(ops)
+		result.Latencies = append(result.Latencies, time.Since(txStart))
+		ops = ops[:0]
+	}
+
+	result.Elapsed = time.Since(start)
+	sort.Slice(result.Latencies, func(i, j int) bool {
+		return result.Latencies[i] < result.Latencies[j]
+	})
+	return result, nil
+}
+
+func replayOps(tx *nutsdb.Tx, ops []nutsdb.TraceRecord, values []byte) error {
+	for _, op := range ops {
+		bucket, key := replayBucket(op.BucketHash), replayKey(op.KeyHash)
+		switch op.Op {
+		case nutsdb.TraceOpGet:
+			_, _ = tx.Get(bucket, key)
+		case nutsdb.TraceOpPut:
+			if err := tx.Put(bucket, key, values[:op.ValueSize], nutsdb.Persistent); err != nil {
+				return err
+			}
+		case nutsdb.TraceOpDelete:
+			_ = tx.Delete(bucket, key)
+		}
+	}
+	return nil
+}
+
+func replayBucket(hash uint64) string {
+	return fmt.Sprintf("bucket_%016x", hash)
+}
+
+func replayKey(hash uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, hash)
+	return key
+}
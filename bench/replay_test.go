@@ -0,0 +1,92 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bench
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nutsdb/nutsdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openTestDB(t *testing.T, name string, ops ...nutsdb.Option) *nutsdb.DB {
+	dir := filepath.Join(os.TempDir(), name)
+	require.NoError(t, os.RemoveAll(dir))
+	db, err := nutsdb.Open(nutsdb.DefaultOptions, append([]nutsdb.Option{nutsdb.WithDir(dir)}, ops...)...)
+	require.NoError(t, err)
+	return db
+}
+
+func TestReplay(t *testing.T) {
+	var trace bytes.Buffer
+	recorder := nutsdb.NewWorkloadRecorder(&trace)
+
+	db := openTestDB(t, "nutsdbtestbenchrecord", nutsdb.WithWorkloadRecorder(recorder))
+	for i := 0; i < 10; i++ {
+		require.NoError(t, db.Update(func(tx *nutsdb.Tx) error {
+			return tx.Put("bucket", []byte(fmt.Sprintf("key_%d", i)), make([]byte, 100), nutsdb.Persistent)
+		}))
+	}
+	require.NoError(t, db.Update(func(tx *nutsdb.Tx) error {
+		return tx.Delete("bucket", []byte("key_0"))
+	}))
+	require.NoError(t, db.View(func(tx *nutsdb.Tx) error {
+		_, err := tx.Get("bucket", []byte("key_1"))
+		return err
+	}))
+	require.NoError(t, db.Close())
+	require.NoError(t, recorder.Flush())
+
+	replayDB := openTestDB(t, "nutsdbtestbenchreplay")
+	defer replayDB.Close()
+
+	result, err := Replay(replayDB, bytes.NewReader(trace.Bytes()), ReplayOptions{Paced: true, Speed: 10})
+	require.NoError(t, err)
+	assert.Equal(t, 12, result.Txs)
+	assert.Equal(t, 12, result.Ops)
+	assert.Len(t, result.Latencies, 12)
+	assert.True(t, result.Percentile(50) <= result.Percentile(99))
+	assert.True(t, result.Elapsed > 0)
+
+	// the keys written and not deleted are in the replayed db.
+	require.NoError(t, replayDB.View(func(tx *nutsdb.Tx) error {
+		var buckets []string
+		if err := tx.IterateBuckets(nutsdb.DataStructureBPTree, "*", func(bucket string) bool {
+			buckets = append(buckets, bucket)
+			return true
+		}); err != nil {
+			return err
+		}
+		require.Len(t, buckets, 1)
+
+		entries, err := tx.GetAll(buckets[0])
+		if err != nil {
+			return err
+		}
+		assert.Len(t, entries, 9)
+		for _, e := range entries {
+			assert.Len(t, e.Value, 100)
+		}
+		return nil
+	}))
+
+	assert.Equal(t, time.Duration(0), ReplayResult{}.Percentile(99))
+}
@@ -963,3 +963,131 @@ func insertIntoLeaf(leaf *Node, key []byte, pointer *Record) {
 	leaf.pointers[i] = pointer
 	leaf.KeysNum++
 }
+
+// BulkInsert inserts the records at given keys, sorted in ascending order and without duplicates,
+// as Insert does for each of them. An empty tree, or a tree holding fewer valid keys than inserted,
+// is built again bottom up from the sorted run instead of inserting the keys one by one.
+func (t *BPTree) BulkInsert(keys [][]byte, es []*Entry, hs []*Hint, countFlag bool) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if t.root != nil && len(keys) < t.ValidKeyCount {
+		for i, key := range keys {
+			if err := t.Insert(key, es[i], hs[i], countFlag); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	_, oldKeys, oldPointers := t.getAll()
+
+	mergedKeys := make([][]byte, 0, len(oldKeys)+len(keys))
+	records := make([]*Record, 0, len(oldKeys)+len(keys))
+	i := 0
+	for j, key := range keys {
+		for i < len(oldKeys) && compare(oldKeys[i], key) < 0 {
+			mergedKeys = append(mergedKeys, oldKeys[i])
+			records = append(records, oldPointers[i].(*Record))
+			i++
+		}
+
+		if i < len(oldKeys) && compare(oldKeys[i], key) == 0 {
+			r := oldPointers[i].(*Record)
+			if countFlag && hs[j].Meta.Flag == DataDeleteFlag && r.H.Meta.Flag != DataDeleteFlag && t.ValidKeyCount > 0 {
+				t.ValidKeyCount--
+			}
+			if countFlag && hs[j].Meta.Flag != DataDeleteFlag && r.H.Meta.Flag == DataDeleteFlag {
+				t.ValidKeyCount++
+			}
+			if err := r.UpdateRecord(hs[j], es[j]); err != nil {
+				return err
+			}
+			mergedKeys = append(mergedKeys, oldKeys[i])
+			records = append(records, r)
+			i++
+			continue
+		}
+
+		t.ValidKeyCount++
+		mergedKeys = append(mergedKeys, key)
+		records = append(records, &Record{H: hs[j], E: es[j]})
+	}
+	for ; i < len(oldKeys); i++ {
+		mergedKeys = append(mergedKeys, oldKeys[i])
+		records = append(records, oldPointers[i].(*Record))
+	}
+
+	t.build(mergedKeys, records)
+	return nil
+}
+
+// build replaces the nodes of the tree by nodes built bottom up from the records at given sorted keys.
+func (t *BPTree) build(keys [][]byte, records []*Record) {
+	t.LastAddress = 0
+
+	// the leaves, linked to their next and previous leaves.
+	var (
+		level   []*Node
+		minKeys [][]byte
+		prev    *Node
+	)
+	for _, size := range splitEvenly(len(keys), order-1) {
+		leaf := t.newLeaf()
+		for j := 0; j < size; j++ {
+			leaf.Keys[j] = keys[0]
+			leaf.pointers[j] = records[0]
+			keys, records = keys[1:], records[1:]
+		}
+		leaf.KeysNum = size
+		if prev != nil {
+			prev.pointers[order-1] = leaf
+			leaf.pointers[order] = prev
+		}
+		prev = leaf
+		level = append(level, leaf)
+		minKeys = append(minKeys, leaf.Keys[0])
+	}
+	t.FirstKey = minKeys[0]
+	t.LastKey = prev.Keys[prev.KeysNum-1]
+
+	// the intermediate nodes, up to the root. The key before a child is the least key below it.
+	for len(level) > 1 {
+		var (
+			parents    []*Node
+			parentKeys [][]byte
+		)
+		for _, size := range splitEvenly(len(level), order) {
+			node := t.newNode()
+			for j := 0; j < size; j++ {
+				if j > 0 {
+					node.Keys[j-1] = minKeys[j]
+				}
+				node.pointers[j] = level[j]
+				level[j].parent = node
+			}
+			node.KeysNum = size - 1
+			parents = append(parents, node)
+			parentKeys = append(parentKeys, minKeys[0])
+			level, minKeys = level[size:], minKeys[size:]
+		}
+		level, minKeys = parents, parentKeys
+	}
+
+	t.root = level[0]
+	t.root.parent = nil
+}
+
+// splitEvenly returns the sizes of the fewest groups of at most max items n items split into,
+// the sizes differing by one at most.
+func splitEvenly(n, max int) []int {
+	groups := (n + max - 1) / max
+	sizes := make([]int, groups)
+	for i := range sizes {
+		sizes[i] = n / groups
+		if i < n%groups {
+			sizes[i]++
+		}
+	}
+	return sizes
+}
@@ -544,3 +544,71 @@ func TestBPTree_WriteNode(t *testing.T) {
 	// remove the test file
 	_ = os.Remove(testFilename)
 }
+
+func TestBPTree_BulkInsert(t *testing.T) {
+	bulkRun := func(from, to int, format string) ([][]byte, []*Entry, []*Hint) {
+		var (
+			keys [][]byte
+			es   []*Entry
+			hs   []*Hint
+		)
+		for i := from; i < to; i++ {
+			key := []byte(fmt.Sprintf(keyFormat, i))
+			val := []byte(fmt.Sprintf(format, i))
+			keys = append(keys, key)
+			es = append(es, &Entry{Key: key, Value: val})
+			hs = append(hs, &Hint{Key: key, Meta: &MetaData{Flag: DataSetFlag}})
+		}
+		return keys, es, hs
+	}
+	checkTree := func(tree *BPTree, n int, value func(i int) string) {
+		records, err := tree.All()
+		require.NoError(t, err)
+		require.Len(t, records, n)
+		for i, r := range records {
+			assert.Equal(t, value(i), string(r.E.Value))
+		}
+		for i := 0; i < n; i++ {
+			r, err := tree.Find([]byte(fmt.Sprintf(keyFormat, i)))
+			require.NoError(t, err)
+			assert.Equal(t, value(i), string(r.E.Value))
+		}
+		records, err = tree.Range([]byte(fmt.Sprintf(keyFormat, 10)), []byte(fmt.Sprintf(keyFormat, 19)))
+		require.NoError(t, err)
+		assert.Len(t, records, 10)
+		assert.Equal(t, n, tree.ValidKeyCount)
+	}
+
+	for n := 20; n < 80; n++ {
+		tree := NewTree()
+		keys, es, hs := bulkRun(0, n, valFormat)
+		require.NoError(t, tree.BulkInsert(keys, es, hs, CountFlagEnabled))
+		checkTree(tree, n, func(i int) string { return fmt.Sprintf(valFormat, i) })
+	}
+
+	tree := NewTree()
+	keys, es, hs := bulkRun(0, 200, valFormat)
+	require.NoError(t, tree.BulkInsert(keys, es, hs, CountFlagEnabled))
+	checkTree(tree, 200, func(i int) string { return fmt.Sprintf(valFormat, i) })
+
+	// a larger run is merged with the records of the tree, updating the existing keys.
+	keys, es, hs = bulkRun(100, 400, "new_%03d")
+	require.NoError(t, tree.BulkInsert(keys, es, hs, CountFlagEnabled))
+	value := func(i int) string {
+		if i < 100 {
+			return fmt.Sprintf(valFormat, i)
+		}
+		return fmt.Sprintf("new_%03d", i)
+	}
+	checkTree(tree, 400, value)
+
+	// a smaller run is inserted key by key, and the tree built bottom up still splits.
+	keys, es, hs = bulkRun(400, 450, "new_%03d")
+	require.NoError(t, tree.BulkInsert(keys, es, hs, CountFlagEnabled))
+	checkTree(tree, 450, value)
+
+	key := []byte("key_000a")
+	require.NoError(t, tree.Insert(key, &Entry{Key: key}, &Hint{Key: key, Meta: &MetaData{Flag: DataSetFlag}}, CountFlagEnabled))
+	_, err := tree.Find(key)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,192 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// bucketIDsFileName is the name of the file mapping the bucket ids to the bucket names.
+const bucketIDsFileName = "bucket_ids"
+
+// bucketIDRecordHeaderSize is the size of the crc, the id and the name size of a record of the bucket ids file.
+const bucketIDRecordHeaderSize = 12
+
+// ErrUnknownBucketID is returned when an entry refers to a bucket id missing from the bucket ids file.
+var ErrUnknownBucketID = errors.New("unknown bucket id")
+
+// bucketID is an id assigned to a bucket name.
+type bucketID struct {
+	id      uint32
+	encoded []byte
+	saved   bool
+}
+
+// bucketIDTable maps the bucket names to the compact ids written in the entries in place
+// of the names when Options.CompactBucketIDs is set. The mapping is appended to the bucket ids
+// file before any entry using it is written, and never changes once assigned.
+type bucketIDTable struct {
+	mu     sync.RWMutex
+	path   string
+	ids    map[string]*bucketID
+	names  map[uint32][]byte
+	nextID uint32
+}
+
+func newBucketIDTable(dir string) *bucketIDTable {
+	return &bucketIDTable{
+		path:  filepath.Join(dir, bucketIDsFileName),
+		ids:   make(map[string]*bucketID),
+		names: make(map[uint32][]byte),
+	}
+}
+
+// load reads the bucket ids file. A torn record left by a crash is truncated,
+// it was never followed by an entry using its id.
+func (t *bucketIDTable) load() error {
+	buf, err := ioutil.ReadFile(filepath.Clean(t.path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	off := 0
+	for off+bucketIDRecordHeaderSize <= len(buf) {
+		id := binary.LittleEndian.Uint32(buf[off+4 : off+8])
+		size := int(binary.LittleEndian.Uint32(buf[off+8 : off+12]))
+		end := off + bucketIDRecordHeaderSize + size
+		if size < 0 || end > len(buf) || binary.LittleEndian.Uint32(buf[off:off+4]) != crc32.ChecksumIEEE(buf[off+4:end]) {
+			break
+		}
+		t.add(string(buf[off+bucketIDRecordHeaderSize:end]), id, true)
+		off = end
+	}
+
+	if off < len(buf) {
+		return os.Truncate(t.path, int64(off))
+	}
+	return nil
+}
+
+func (t *bucketIDTable) add(bucket string, id uint32, saved bool) *bucketID {
+	encoded := make([]byte, binary.MaxVarintLen32)
+	encoded = encoded[:binary.PutUvarint(encoded, uint64(id))]
+
+	b := &bucketID{id: id, encoded: encoded, saved: saved}
+	t.ids[bucket] = b
+	t.names[id] = []byte(bucket)
+	if id >= t.nextID {
+		t.nextID = id + 1
+	}
+	return b
+}
+
+// encodedID returns the encoded id of the bucket, assigning a new id to an unknown bucket.
+func (t *bucketIDTable) encodedID(bucket string) []byte {
+	t.mu.RLock()
+	b, ok := t.ids[bucket]
+	t.mu.RUnlock()
+	if ok {
+		return b.encoded
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if b, ok := t.ids[bucket]; ok {
+		return b.encoded
+	}
+	return t.add(bucket, t.nextID, false).encoded
+}
+
+// save appends the ids assigned since the last save to the bucket ids file and syncs it.
+func (t *bucketIDTable) save() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var (
+		buf     []byte
+		pending []*bucketID
+	)
+	for bucket, b := range t.ids {
+		if b.saved {
+			continue
+		}
+		record := make([]byte, bucketIDRecordHeaderSize+len(bucket))
+		binary.LittleEndian.PutUint32(record[4:8], b.id)
+		binary.LittleEndian.PutUint32(record[8:12], uint32(len(bucket)))
+		copy(record[bucketIDRecordHeaderSize:], bucket)
+		binary.LittleEndian.PutUint32(record[0:4], crc32.ChecksumIEEE(record[4:]))
+		buf = append(buf, record...)
+		pending = append(pending, b)
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	f, err := os.OpenFile(filepath.Clean(t.path), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	for _, b := range pending {
+		b.saved = true
+	}
+	return nil
+}
+
+// resolve replaces the encoded bucket id of the entry read from a data file by the bucket name.
+func (t *bucketIDTable) resolve(e *Entry) error {
+	if e == nil || !e.Meta.hasBucketID() {
+		return nil
+	}
+	if t == nil {
+		return ErrUnknownBucketID
+	}
+
+	id, n := binary.Uvarint(e.Bucket)
+	if n <= 0 {
+		return ErrUnknownBucketID
+	}
+
+	t.mu.RLock()
+	name, ok := t.names[uint32(id)]
+	t.mu.RUnlock()
+	if !ok {
+		return ErrUnknownBucketID
+	}
+
+	e.bucketID = e.Bucket
+	e.Bucket = name
+	return nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBucketIDTable_Load(t *testing.T) {
+	dir := "/tmp/nutsdbtestbucketidtable"
+	require.NoError(t, os.RemoveAll(dir))
+	require.NoError(t, os.MkdirAll(dir, os.ModePerm))
+
+	table := newBucketIDTable(dir)
+	id1 := table.encodedID("bucket_1")
+	id2 := table.encodedID("bucket_2")
+	assert.NotEqual(t, id1, id2)
+	assert.Equal(t, id1, table.encodedID("bucket_1"))
+	require.NoError(t, table.save())
+
+	// a torn record is truncated on load.
+	f, err := os.OpenFile(table.path, os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{1, 2, 3})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	loaded := newBucketIDTable(dir)
+	require.NoError(t, loaded.load())
+	assert.Equal(t, id1, loaded.encodedID("bucket_1"))
+	assert.Equal(t, id2, loaded.encodedID("bucket_2"))
+	assert.Len(t, loaded.names, 2)
+
+	buf, err := ioutil.ReadFile(table.path)
+	require.NoError(t, err)
+	assert.Len(t, buf, 2*(bucketIDRecordHeaderSize+len("bucket_1")))
+}
+
+func TestDB_CompactBucketIDs(t *testing.T) {
+	bucket := strings.Repeat("a_very_long_bucket_name_", 4)
+
+	write := func(compact bool) int64 {
+		InitOpt("/tmp/nutsdbtestcompactbucketids", true)
+		opt.EntryIdxMode = HintKeyAndRAMIdxMode
+		db, err = Open(opt, WithCompactBucketIDs(compact))
+		require.NoError(t, err)
+
+		err = db.Update(func(tx *Tx) error {
+			for i := 0; i < 10; i++ {
+				if err := tx.Put(bucket, []byte(fmt.Sprintf("key_%d", i)), []byte("value"), Persistent); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		s, err := db.Stats()
+		require.NoError(t, err)
+		require.NoError(t, db.Close())
+		return s.UserBytesWritten
+	}
+
+	assert.True(t, write(false) > write(true))
+
+	// the entries written with ids are read back without the option as well.
+	for _, compact := range []bool{false, true} {
+		db, err = Open(opt, WithCompactBucketIDs(compact))
+		require.NoError(t, err)
+		err = db.View(func(tx *Tx) error {
+			e, err := tx.Get(bucket, []byte("key_1"))
+			if assert.NoError(t, err) {
+				assert.Equal(t, []byte("value"), e.Value)
+				assert.Equal(t, []byte(bucket), e.Bucket)
+			}
+			entries, err := tx.GetAll(bucket)
+			assert.NoError(t, err)
+			assert.Len(t, entries, 10)
+			return nil
+		})
+		require.NoError(t, err)
+		require.NoError(t, db.Close())
+	}
+
+	require.NoError(t, os.Remove(opt.Dir+"/"+bucketIDsFileName))
+	_, err = Open(opt)
+	assert.Error(t, err)
+}
@@ -0,0 +1,69 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import "sync"
+
+// internedBucket is the canonical copy of a bucket name.
+type internedBucket struct {
+	name  string
+	bytes []byte
+}
+
+// bucketNames interns the bucket names, so that the entries and the records of
+// a bucket share a single copy of its name instead of carrying their own.
+// The interned bytes are shared and must never be modified.
+type bucketNames struct {
+	mu      sync.RWMutex
+	buckets map[string]*internedBucket
+}
+
+func newBucketNames() *bucketNames {
+	return &bucketNames{buckets: make(map[string]*internedBucket)}
+}
+
+// bytes returns the canonical bytes of the bucket name.
+func (b *bucketNames) bytes(bucket string) []byte {
+	return b.get(bucket).bytes
+}
+
+// name returns the canonical string of the bucket name.
+func (b *bucketNames) name(bucket []byte) string {
+	b.mu.RLock()
+	ib, ok := b.buckets[string(bucket)]
+	b.mu.RUnlock()
+	if ok {
+		return ib.name
+	}
+	return b.get(string(bucket)).name
+}
+
+func (b *bucketNames) get(bucket string) *internedBucket {
+	b.mu.RLock()
+	ib, ok := b.buckets[bucket]
+	b.mu.RUnlock()
+	if ok {
+		return ib
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ib, ok := b.buckets[bucket]; ok {
+		return ib
+	}
+	ib = &internedBucket{name: bucket, bytes: []byte(bucket)}
+	b.buckets[bucket] = ib
+	return ib
+}
@@ -0,0 +1,46 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBucketNames(t *testing.T) {
+	names := newBucketNames()
+
+	b1 := names.bytes("bucket")
+	b2 := names.bytes("bucket")
+	assert.Equal(t, []byte("bucket"), b1)
+	assert.True(t, &b1[0] == &b2[0])
+
+	assert.Equal(t, "bucket", names.name([]byte("bucket")))
+	assert.Equal(t, "other", names.name([]byte("other")))
+	assert.Len(t, names.buckets, 2)
+}
+
+func TestTx_PutInternsBucket(t *testing.T) {
+	withDefaultDB(t, func(t *testing.T, db *DB) {
+		tx, err := db.Begin(true)
+		require.NoError(t, err)
+		require.NoError(t, tx.Put("bucket", []byte("key_1"), []byte("value"), Persistent))
+		require.NoError(t, tx.Put("bucket", []byte("key_2"), []byte("value"), Persistent))
+		assert.True(t, &tx.pendingWrites[0].Bucket[0] == &tx.pendingWrites[1].Bucket[0])
+		require.NoError(t, tx.Commit())
+	})
+}
@@ -0,0 +1,272 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// defaultBulkLoadBufferSize is the BufferSize of the BulkLoadOptions used when it is zero.
+const defaultBulkLoadBufferSize = 4 << 20
+
+var (
+	// ErrBulkLoadNotSorted is returned by BulkLoad when the keys of a bucket are not in strictly ascending order.
+	ErrBulkLoadNotSorted = errors.New("the keys of the bulk load are not sorted in ascending order")
+
+	// ErrBulkLoadTokenizer is returned by BulkLoad for the entries of a bucket with a tokenizer,
+	// whose inverted index is only maintained by the transactions.
+	ErrBulkLoadTokenizer = errors.New("the bulk load cannot write to a bucket with a tokenizer")
+)
+
+// BulkLoadEntry is a key/value entry written by BulkLoad.
+type BulkLoadEntry struct {
+	Bucket string
+	Key    []byte
+	Value  []byte
+	TTL    uint32
+}
+
+// BulkLoadIterator yields the entries written by BulkLoad. The keys and the values yielded are kept
+// by the db, they must not be modified afterwards.
+type BulkLoadIterator interface {
+	// Next returns the next entry, or io.EOF after the last one.
+	Next() (BulkLoadEntry, error)
+}
+
+// BulkLoadOptions are the options of BulkLoad.
+type BulkLoadOptions struct {
+	// BufferSize is the size in bytes of the entries encoded before they are written to the data files.
+	// Zero means 4MB.
+	BufferSize int
+
+	// NoSync skips the fsync of the data files once all the entries are written.
+	NoSync bool
+}
+
+// bulkLoadBucket holds the index records of the entries loaded into a bucket, in the order of their keys.
+type bulkLoadBucket struct {
+	keys [][]byte
+	es   []*Entry
+	hs   []*Hint
+}
+
+// BulkLoad writes the entries yielded by the iterator as a single transaction, much faster than Update
+// for the initial imports: the entries are streamed into the data files without being held in memory
+// as pending writes, and the index of every bucket is built once at the end from its sorted keys
+// instead of being updated entry by entry. The keys of every bucket must be yielded in strictly ascending
+// order by bytes.Compare, the buckets may be interleaved. The data files are fsynced once, at the end.
+//
+// The write lock is held for the whole load. The load is atomic: when it fails, or the process stops
+// before it ends, none of the entries is seen, which the next Merge reclaims. The watchers are not notified.
+func (db *DB) BulkLoad(it BulkLoadIterator, opts BulkLoadOptions) error {
+	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return ErrNotSupportHintBPTSparseIdxMode
+	}
+	bufferSize := opts.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = defaultBulkLoadBufferSize
+	}
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		return err
+	}
+	tx.async = true
+
+	loaded, err := tx.bulkWrite(it, bufferSize)
+	if err != nil || len(loaded) == 0 {
+		_ = tx.Rollback()
+		return err
+	}
+
+	db.committedTxIds[tx.id] = struct{}{}
+	for bucket, b := range loaded {
+		if _, ok := db.BPTreeIdx[bucket]; !ok || db.BPTreeIdx[bucket] == nil {
+			db.BPTreeIdx[bucket] = NewTree()
+		}
+		idx := db.BPTreeIdx[bucket]
+		if idx.root != nil {
+			for _, key := range b.keys {
+				db.addDeadStat(idx, key)
+			}
+		}
+		_ = idx.BulkInsert(b.keys, b.es, b.hs, CountFlagEnabled)
+		db.KeyCount += len(b.keys)
+	}
+
+	tx.setStatusClosed()
+	tx.unlock()
+	tx.db = nil
+
+	if opts.NoSync {
+		return nil
+	}
+	f := newDurabilityFuture()
+	db.syncer.add(f)
+	return f.Wait()
+}
+
+// bulkWrite writes the entries yielded by the iterator into the data files and returns their index records
+// by bucket. The last entry is held back until the iterator ends, so that it is written marked as committed.
+func (tx *Tx) bulkWrite(it BulkLoadIterator, bufferSize int) (map[string]*bulkLoadBucket, error) {
+	var (
+		db     = tx.db
+		loaded = make(map[string]*bulkLoadBucket)
+		buff   bytes.Buffer
+		last   *Entry
+	)
+
+	write := func(e *Entry) error {
+		if err := db.bucketIDs.save(); err != nil {
+			return err
+		}
+		if err := db.keys.save(); err != nil {
+			return err
+		}
+		if _, err := tx.writeData(buff.Bytes()); err != nil {
+			return err
+		}
+		buff.Reset()
+		if e != nil && db.ActiveFile.ActualSize+e.Size() > db.opt.SegmentSize {
+			return tx.rotateActiveFile()
+		}
+		return nil
+	}
+	add := func(bucket string, e *Entry) error {
+		if db.ActiveFile.ActualSize+int64(buff.Len())+e.Size() > db.opt.SegmentSize || buff.Len() >= bufferSize {
+			if err := write(e); err != nil {
+				return err
+			}
+		}
+
+		fileID, offset := db.ActiveFile.fileID, db.ActiveFile.writeOff+int64(buff.Len())
+		db.addFileStat(fileID, e)
+		db.touchBucket(e)
+		if _, err := buff.Write(e.Encode()); err != nil {
+			return err
+		}
+
+		b, ok := loaded[bucket]
+		if !ok {
+			b = &bulkLoadBucket{}
+			loaded[bucket] = b
+		}
+		var ie *Entry
+		if db.opt.EntryIdxMode == HintKeyValAndRAMIdxMode {
+			ie = e
+		}
+		b.keys = append(b.keys, e.Key)
+		b.es = append(b.es, ie)
+		b.hs = append(b.hs, db.newHint(e.Key, fileID, e.Meta, uint64(offset)))
+		return nil
+	}
+
+	var (
+		lastKeys   = make(map[string][]byte)
+		lastBucket string
+		timestamp  = tx.db.timestamp()
+	)
+	for {
+		be, err := it.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if k, ok := lastKeys[be.Bucket]; ok && compare(k, be.Key) >= 0 {
+			return nil, ErrBulkLoadNotSorted
+		}
+		lastKeys[be.Bucket] = be.Key
+
+		e, err := tx.bulkEntry(be, timestamp)
+		if err != nil {
+			return nil, err
+		}
+		if last != nil {
+			if err := add(lastBucket, last); err != nil {
+				return nil, err
+			}
+		}
+		last, lastBucket = e, be.Bucket
+	}
+	if last == nil {
+		return nil, nil
+	}
+
+	last.Meta.Status = Committed
+	if err := add(lastBucket, last); err != nil {
+		return nil, err
+	}
+	if err := write(nil); err != nil {
+		return nil, err
+	}
+	return loaded, nil
+}
+
+// bulkEntry returns the entry written by BulkLoad for the key/value entry, checked as tx.put checks it.
+func (tx *Tx) bulkEntry(be BulkLoadEntry, timestamp uint64) (*Entry, error) {
+	if !tx.db.isBucketOpen(be.Bucket) {
+		return nil, ErrBucketNotOpen
+	}
+	if tx.isTempBucket(be.Bucket) {
+		return nil, ErrTempBucketDataStructure
+	}
+	if _, ok := tx.db.opt.Tokenizers[be.Bucket]; ok {
+		return nil, ErrBulkLoadTokenizer
+	}
+	if err := tx.checkImmutable(be.Bucket, be.Key, DataSetFlag, DataStructureBPTree); err != nil {
+		return nil, err
+	}
+
+	e := &Entry{
+		Key:    be.Key,
+		Value:  be.Value,
+		Bucket: tx.db.bucketNames.bytes(be.Bucket),
+		Meta: &MetaData{
+			KeySize:    uint32(len(be.Key)),
+			ValueSize:  uint32(len(be.Value)),
+			Timestamp:  timestamp,
+			Flag:       DataSetFlag,
+			TTL:        be.TTL,
+			BucketSize: uint32(len(be.Bucket)),
+			Status:     UnCommitted,
+			Ds:         DataStructureBPTree,
+			TxID:       tx.id,
+		},
+	}
+	if err := e.valid(); err != nil {
+		return nil, err
+	}
+
+	if tx.db.opt.CompactBucketIDs {
+		e.bucketID = tx.db.bucketIDs.encodedID(be.Bucket)
+		e.Meta.BucketSize = bucketIDFlag | uint32(len(e.bucketID))
+	}
+	if tx.db.keys.encrypt {
+		if err := tx.db.keys.prepare(be.Bucket); err != nil {
+			return nil, err
+		}
+		e.Meta.BucketSize |= bucketSealedFlag
+		e.keys = tx.db.keys
+	}
+	if e.Size() > tx.db.opt.SegmentSize {
+		return nil, ErrDataSizeExceed
+	}
+	return e, nil
+}
@@ -0,0 +1,123 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sliceBulkLoadIterator yields the entries of a slice, then err.
+type sliceBulkLoadIterator struct {
+	entries []BulkLoadEntry
+	err     error
+}
+
+func (it *sliceBulkLoadIterator) Next() (BulkLoadEntry, error) {
+	if len(it.entries) == 0 {
+		if it.err != nil {
+			return BulkLoadEntry{}, it.err
+		}
+		return BulkLoadEntry{}, io.EOF
+	}
+	e := it.entries[0]
+	it.entries = it.entries[1:]
+	return e, nil
+}
+
+func bulkLoadEntries(buckets []string, n int) []BulkLoadEntry {
+	var entries []BulkLoadEntry
+	for i := 0; i < n; i++ {
+		for _, bucket := range buckets {
+			entries = append(entries, BulkLoadEntry{
+				Bucket: bucket,
+				Key:    []byte(fmt.Sprintf("key_%06d", i)),
+				Value:  []byte(fmt.Sprintf("%s_value_%06d", bucket, i)),
+				TTL:    Persistent,
+			})
+		}
+	}
+	return entries
+}
+
+func TestDB_BulkLoad(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestbulkload", true)
+	opt.SegmentSize = 64 * 1024
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	buckets := []string{"bucket_bulk_1", "bucket_bulk_2"}
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.Put(buckets[0], []byte("key_000001"), []byte("old"), Persistent)
+	}))
+
+	// a failed load is not seen, neither are the unsorted loads.
+	assert.Equal(t, io.ErrClosedPipe, db.BulkLoad(&sliceBulkLoadIterator{entries: bulkLoadEntries(buckets, 100), err: io.ErrClosedPipe}, BulkLoadOptions{}))
+	unsorted := bulkLoadEntries(buckets, 10)
+	unsorted[2], unsorted[4] = unsorted[4], unsorted[2]
+	assert.Equal(t, ErrBulkLoadNotSorted, db.BulkLoad(&sliceBulkLoadIterator{entries: unsorted}, BulkLoadOptions{}))
+
+	check := func(n int) {
+		require.NoError(t, db.View(func(tx *Tx) error {
+			entries, err := tx.GetAll(buckets[1])
+			require.NoError(t, err)
+			assert.Len(t, entries, n)
+			e, err := tx.Get(buckets[1], []byte("key_000099"))
+			require.NoError(t, err)
+			assert.Equal(t, []byte("bucket_bulk_2_value_000099"), e.Value)
+			return nil
+		}))
+	}
+
+	require.NoError(t, db.View(func(tx *Tx) error {
+		e, err := tx.Get(buckets[0], []byte("key_000001"))
+		require.NoError(t, err)
+		assert.Equal(t, []byte("old"), e.Value)
+		_, err = tx.Get(buckets[1], []byte("key_000001"))
+		assert.Error(t, err)
+		return nil
+	}))
+
+	require.NoError(t, db.BulkLoad(&sliceBulkLoadIterator{entries: bulkLoadEntries(buckets, 5000)}, BulkLoadOptions{BufferSize: 4096}))
+	assert.True(t, db.MaxFileID > 1)
+	check(5000)
+
+	// the keys written after the load go to the index built by the load.
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.Put(buckets[0], []byte("key_999999"), []byte("after"), Persistent)
+	}))
+
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	check(5000)
+	require.NoError(t, db.View(func(tx *Tx) error {
+		entries, err := tx.GetAll(buckets[0])
+		require.NoError(t, err)
+		assert.Len(t, entries, 5001)
+		e, err := tx.Get(buckets[0], []byte("key_000001"))
+		require.NoError(t, err)
+		assert.Equal(t, []byte("bucket_bulk_1_value_000001"), e.Value)
+		e, err = tx.Get(buckets[0], []byte("key_999999"))
+		require.NoError(t, err)
+		assert.Equal(t, []byte("after"), e.Value)
+		return nil
+	}))
+	require.NoError(t, db.Close())
+}
@@ -0,0 +1,177 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+const (
+	changeFeedBucketKind = "changefeed"
+
+	// changeEventHeaderSize is the size of the header of an event recorded in the change feed.
+	changeEventHeaderSize = 25
+)
+
+// changeFeedBucket holds the events recorded by Options.ChangeFeedEnable keyed by their big-endian sequence number,
+// the numbers are handed out by the sequence of the same name.
+var changeFeedBucket = internalBucket(changeFeedBucketKind, "events")
+
+var (
+	// ErrChangeFeedDisabled is returned by WatchWithOptions for the WatchGlobalOrder when Options.ChangeFeedEnable is not set.
+	ErrChangeFeedDisabled = errors.New("the change feed is disabled")
+
+	// ErrChangeEvent is returned when an event of the change feed stored in the db cannot be decoded.
+	ErrChangeEvent = errors.New("invalid change feed event")
+)
+
+// recordChanges records in the change feed the events of the writes of the tx, numbered in the order of the writes,
+// so that they are committed along with the writes. The numbers are kept by the tx for the watchers.
+func (tx *Tx) recordChanges() error {
+	if !tx.db.opt.ChangeFeedEnable || tx.isMerge || tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return nil
+	}
+
+	writes := tx.resolveWrites(tx.pendingWrites)
+	for i, e := range writes {
+		bucket := string(e.Bucket)
+		if isInternalBucket(bucket) {
+			continue
+		}
+
+		seq, err := tx.NextSequence(changeFeedBucket)
+		if err != nil {
+			return err
+		}
+		event := encodeChangeEvent(newEvent(bucket, e))
+		if err := tx.put(changeFeedBucket, encodeChangeSeq(seq), event, Persistent, DataSetFlag, tx.db.timestamp(), DataStructureBPTree); err != nil {
+			return err
+		}
+		if tx.changeSeqs == nil {
+			tx.changeSeqs = make(map[int]uint64)
+		}
+		tx.changeSeqs[i] = seq
+	}
+	return nil
+}
+
+// changeEvents reads up to limit events of the change feed with a sequence number greater than afterSeq,
+// and returns the ones watched by w in order, along with the sequence number of the last one read,
+// afterSeq when there is none left.
+func (tx *Tx) changeEvents(w *watcher, afterSeq uint64, limit int) (events []Event, lastSeq uint64, err error) {
+	lastSeq = afterSeq
+	if afterSeq == math.MaxUint64 {
+		return nil, lastSeq, nil
+	}
+	if _, ok := tx.db.BPTreeIdx[changeFeedBucket]; !ok {
+		return nil, lastSeq, nil
+	}
+
+	it := tx.NewIterator(changeFeedBucket, IteratorOptions{})
+	if err := it.Seek(encodeChangeSeq(afterSeq + 1)); err != nil {
+		return nil, lastSeq, err
+	}
+	for n := 0; n < limit && it.Valid(); it.Next() {
+		e := it.Item()
+		event, err := decodeChangeEvent(e.Key, e.Value)
+		if err != nil {
+			return nil, lastSeq, err
+		}
+		lastSeq = event.Seq
+		if w.matchesEvent(&event) {
+			events = append(events, event)
+		}
+		n++
+	}
+	return events, lastSeq, it.Err()
+}
+
+// TrimChangeFeed removes the events of the change feed with a sequence number up to uptoSeq,
+// once all the watchers resuming from a sequence number processed them.
+func (db *DB) TrimChangeFeed(uptoSeq uint64) error {
+	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	return db.Update(func(tx *Tx) error {
+		idx, ok := tx.db.BPTreeIdx[changeFeedBucket]
+		if !ok {
+			return nil
+		}
+		records, err := idx.Range(encodeChangeSeq(0), encodeChangeSeq(uptoSeq))
+		if err != nil {
+			return nil
+		}
+		for _, r := range records {
+			if r.H.Meta.Flag == DataDeleteFlag {
+				continue
+			}
+			if err := tx.put(changeFeedBucket, r.H.Key, nil, Persistent, DataDeleteFlag, tx.db.timestamp(), DataStructureBPTree); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func encodeChangeSeq(seq uint64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, seq)
+	return buf
+}
+
+func encodeChangeEvent(event *Event) []byte {
+	buf := make([]byte, changeEventHeaderSize+len(event.Bucket)+len(event.Key)+len(event.Value))
+	buf[0] = byte(event.Type)
+	binary.BigEndian.PutUint16(buf[1:3], event.Ds)
+	binary.BigEndian.PutUint16(buf[3:5], event.Flag)
+	binary.BigEndian.PutUint32(buf[5:9], event.TTL)
+	binary.BigEndian.PutUint64(buf[9:17], event.Timestamp)
+	binary.BigEndian.PutUint32(buf[17:21], uint32(len(event.Bucket)))
+	binary.BigEndian.PutUint32(buf[21:25], uint32(len(event.Key)))
+	n := changeEventHeaderSize + copy(buf[changeEventHeaderSize:], event.Bucket)
+	n += copy(buf[n:], event.Key)
+	copy(buf[n:], event.Value)
+	return buf
+}
+
+func decodeChangeEvent(seq, value []byte) (Event, error) {
+	if len(seq) != 8 || len(value) < changeEventHeaderSize {
+		return Event{}, ErrChangeEvent
+	}
+	bucketSize := int(binary.BigEndian.Uint32(value[17:21]))
+	keySize := int(binary.BigEndian.Uint32(value[21:25]))
+	if len(value) < changeEventHeaderSize+bucketSize+keySize {
+		return Event{}, ErrChangeEvent
+	}
+
+	n := changeEventHeaderSize + bucketSize
+	event := Event{
+		Seq:       binary.BigEndian.Uint64(seq),
+		Type:      EventType(value[0]),
+		Bucket:    string(value[changeEventHeaderSize:n]),
+		Value:     append([]byte(nil), value[n+keySize:]...),
+		Ds:        binary.BigEndian.Uint16(value[1:3]),
+		Flag:      binary.BigEndian.Uint16(value[3:5]),
+		TTL:       binary.BigEndian.Uint32(value[5:9]),
+		Timestamp: binary.BigEndian.Uint64(value[9:17]),
+	}
+	if keySize > 0 {
+		event.Key = append([]byte(nil), value[n:n+keySize]...)
+	}
+	return event, nil
+}
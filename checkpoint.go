@@ -0,0 +1,750 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nutsdb/nutsdb/ds/list"
+	"github.com/nutsdb/nutsdb/ds/set"
+	"github.com/nutsdb/nutsdb/ds/zset"
+	"github.com/xujiajun/utils/strconv2"
+)
+
+const (
+	// checkpointDir is the directory of the checkpoint files under the db dir.
+	checkpointDir = "checkpoint"
+
+	// checkpointTmpDir is the directory the checkpoint files are written to before they are moved to the checkpointDir.
+	checkpointTmpDir = "checkpoint_tmp"
+
+	// CheckpointSuffix returns the checkpoint file suffix.
+	CheckpointSuffix = ".ckpt"
+
+	// checkpointHeaderSize is the size of the crc and the position stored at the head of a checkpoint file.
+	checkpointHeaderSize = 20
+)
+
+var (
+	// ErrCheckpointCorrupted is returned when a checkpoint file cannot be decoded.
+	ErrCheckpointCorrupted = errors.New("checkpoint corrupted")
+
+	// ErrCheckpointMismatch is returned when an entry of a checkpoint file does not match the entry
+	// at its position in the data files, see Options.CheckpointVerifySamples.
+	ErrCheckpointMismatch = errors.New("checkpoint does not match the data files")
+
+	// ErrCheckpointInterrupted is returned by Checkpoint when the data files were rewritten while it was
+	// writing the checkpoint files, which are discarded then.
+	ErrCheckpointInterrupted = errors.New("checkpoint interrupted by a rewrite of the data files")
+)
+
+// checkpointPos is the position in the data files up to which a checkpoint is authoritative.
+type checkpointPos struct {
+	fileID int64
+	off    int64
+}
+
+// covers returns if the entry at given fID and off was written before the position.
+func (p checkpointPos) covers(fID int64, off int64) bool {
+	return fID < p.fileID || fID == p.fileID && off < p.off
+}
+
+// isZero returns if the position is the one of an invalidated checkpoint, whose bucket is replayed
+// from the first data file, see invalidateCheckpoints.
+func (p checkpointPos) isZero() bool {
+	return p.fileID == 0 && p.off == 0
+}
+
+// checkpointRefs records by checkpoint file name the data files a checkpoint points to.
+type checkpointRefs map[string]map[int64]struct{}
+
+// add records that the checkpoint file of given name points to the data file at given fID.
+func (r checkpointRefs) add(name string, fID int64) {
+	if r[name] == nil {
+		r[name] = make(map[int64]struct{})
+	}
+	r[name][fID] = struct{}{}
+}
+
+// checkpoints records the positions of the checkpoints loaded when opening the db.
+type checkpoints struct {
+	// start is the oldest position of the checkpoints, the replay starts from it.
+	start   checkpointPos
+	buckets map[string]checkpointPos
+}
+
+// covers returns if the entry at given fID and off is already part of the loaded checkpoints.
+func (c *checkpoints) covers(entry *Entry, fID int64, off int64) bool {
+	if c == nil {
+		return false
+	}
+	pos, ok := c.buckets[checkpointName(entryDs(entry), string(entry.Bucket))]
+	if !ok {
+		pos = c.start
+	}
+	return pos.covers(fID, off)
+}
+
+// entryDs returns the data structure the entry applies to.
+func entryDs(entry *Entry) uint16 {
+	if entry.Meta.Ds != DataStructureNone {
+		return entry.Meta.Ds
+	}
+	switch entry.Meta.Flag {
+	case DataSetBucketDeleteFlag:
+		return DataStructureSet
+	case DataSortedSetBucketDeleteFlag:
+		return DataStructureSortedSet
+	case DataBPTreeBucketDeleteFlag:
+		return DataStructureBPTree
+	case DataListBucketDeleteFlag:
+		return DataStructureList
+	}
+	return DataStructureNone
+}
+
+// checkpointName returns the name of the checkpoint file of the bucket of the data structure ds.
+func checkpointName(ds uint16, bucket string) string {
+	return strconv2.IntToStr(int(ds)) + "_" + hex.EncodeToString([]byte(bucket)) + CheckpointSuffix
+}
+
+// parseCheckpointName returns the data structure and the bucket of the checkpoint file name.
+func parseCheckpointName(name string) (ds uint16, bucket string, err error) {
+	parts := strings.SplitN(strings.TrimSuffix(name, CheckpointSuffix), "_", 2)
+	if len(parts) != 2 {
+		return 0, "", ErrCheckpointCorrupted
+	}
+	d, err := strconv2.StrToInt(parts[0])
+	if err != nil {
+		return 0, "", ErrCheckpointCorrupted
+	}
+	b, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return 0, "", ErrCheckpointCorrupted
+	}
+	return uint16(d), string(b), nil
+}
+
+func (db *DB) getCheckpointDir() string {
+	return filepath.Join(db.opt.Dir, checkpointDir)
+}
+
+// Checkpoint persists the index of every bucket, so that the next Open only replays
+// the data written after the checkpoint. The checkpoints pointing to a data file rewritten
+// by Merge, the auto merge or CompactTombstones are invalidated when the file is removed.
+// The indexes are copied under the read lock, so that only the writes wait for the copy,
+// then encoded and written once it is released.
+func (db *DB) Checkpoint() error {
+	db.checkpointMu.Lock()
+	defer db.checkpointMu.Unlock()
+
+	db.mu.RLock()
+	pos, encoders, refs, gen, err := db.snapshotCheckpoints()
+	db.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	payloads := make(map[string][]byte, len(encoders))
+	for name, encode := range encoders {
+		payloads[name] = encode()
+	}
+
+	// the checkpoints must not cover entries the data files could lose on a crash, the data files
+	// before the active one are fsynced when they are released.
+	db.syncer.fileMu.Lock()
+	if db.ActiveFile != nil {
+		err = db.ActiveFile.rwManager.Sync()
+	}
+	db.syncer.fileMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// the files are written in a dir of their own, which a rewrite of the data files removing the checkpoints
+	// leaves alone, then moved to the checkpoint dir unless the data files were rewritten meanwhile.
+	tmpDir := filepath.Join(db.opt.Dir, checkpointTmpDir)
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+	if err := writeCheckpointFiles(tmpDir, pos, payloads); err != nil {
+		return err
+	}
+
+	// the rewrites of the data files invalidating the checkpoints hold the write lock, the read lock
+	// keeps them out while the files are moved, checkpointMu keeps out the other checkpoints.
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed {
+		return ErrDBClosed
+	}
+	if db.checkpointGen != gen {
+		return ErrCheckpointInterrupted
+	}
+	dir := db.getCheckpointDir()
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	// the data files the checkpoints point to are unknown until every file is moved.
+	db.checkpointRefs = nil
+	for name := range payloads {
+		if err := os.Rename(filepath.Join(tmpDir, name)+".tmp", filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+
+	db.checkpointRefs = refs
+
+	// drop the checkpoints of the buckets deleted since the previous checkpoint.
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if _, ok := payloads[f.Name()]; !ok {
+			if err := os.Remove(filepath.Join(dir, f.Name())); err != nil {
+				return err
+			}
+		}
+	}
+
+	// the checkpoint dir may have been created by this checkpoint.
+	if err := syncDir(dir); err != nil {
+		return err
+	}
+	return syncDir(db.opt.Dir)
+}
+
+// snapshotCheckpoints copies the indexes and returns by file name the functions encoding their checkpoints
+// from the copies, along with the position they are authoritative up to, the data files they point to and
+// the generation of the checkpoints. It is called with the read lock held, the encoders run without it.
+func (db *DB) snapshotCheckpoints() (pos checkpointPos, encoders map[string]func() []byte, refs checkpointRefs, gen uint64, err error) {
+	if db.closed {
+		return pos, nil, nil, 0, ErrDBClosed
+	}
+	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return pos, nil, nil, 0, ErrNotSupportHintBPTSparseIdxMode
+	}
+	if db.openBuckets != nil {
+		return pos, nil, nil, 0, ErrPartiallyOpen
+	}
+	// the checkpoints hold the keys and the values of the indexes in plain.
+	if db.keys.enabled() {
+		return pos, nil, nil, 0, ErrNotSupportEncryption
+	}
+
+	pos = checkpointPos{fileID: db.ActiveFile.fileID, off: db.ActiveFile.writeOff}
+	encoders = make(map[string]func() []byte)
+	refs = make(checkpointRefs)
+	hasValue := db.opt.EntryIdxMode == HintKeyValAndRAMIdxMode
+	for bucket, idx := range db.BPTreeIdx {
+		name := checkpointName(DataStructureBPTree, bucket)
+		records := copyBPTreeCheckpoint(idx, hasValue, name, refs)
+		encoders[name] = func() []byte {
+			return MarshalListItems(encodeBPTreeCheckpoint(records, hasValue))
+		}
+	}
+	for bucket, s := range db.SetIdx {
+		members := copySetCheckpoint(s)
+		encoders[checkpointName(DataStructureSet, bucket)] = func() []byte {
+			return MarshalListItems(encodeSetCheckpoint(members))
+		}
+	}
+	for bucket, ss := range db.SortedSetIdx {
+		members := copySortedSetCheckpoint(ss)
+		encoders[checkpointName(DataStructureSortedSet, bucket)] = func() []byte {
+			return MarshalListItems([][]byte{marshalSortedSetMembers(members)})
+		}
+	}
+	_ = db.Index.handleListBucket(func(bucket string) error {
+		l := copyListCheckpoint(db.Index.getList(bucket))
+		encoders[checkpointName(DataStructureList, bucket)] = func() []byte {
+			return MarshalListItems(encodeListCheckpoint(l))
+		}
+		return nil
+	})
+	for name := range encoders {
+		refs.add(name, pos.fileID)
+	}
+	return pos, encoders, refs, db.checkpointGen, nil
+}
+
+// snapshotIndexes checkpoints the indexes every interval until the db is closed.
+func (db *DB) snapshotIndexes(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			// a failed checkpoint leaves the previous one in place, the next tick retries.
+			_ = db.Checkpoint()
+		}
+	}
+}
+
+// writeCheckpointFiles writes the temporary files of the checkpoints of given payloads by file name in the dir.
+func writeCheckpointFiles(dir string, pos checkpointPos, payloads map[string][]byte) error {
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	for name, payload := range payloads {
+		if err := writeCheckpointFile(filepath.Join(dir, name)+".tmp", pos, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeCheckpointFile writes and fsyncs the checkpoint file at given path.
+func writeCheckpointFile(path string, pos checkpointPos, payload []byte) error {
+	buf := make([]byte, checkpointHeaderSize+len(payload))
+	binary.LittleEndian.PutUint64(buf[4:12], uint64(pos.fileID))
+	binary.LittleEndian.PutUint64(buf[12:20], uint64(pos.off))
+	copy(buf[checkpointHeaderSize:], payload)
+	binary.LittleEndian.PutUint32(buf[0:4], crc32.ChecksumIEEE(buf[4:]))
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// syncDir fsyncs the directory at given path, so that the files renamed or removed in it stay so after a crash.
+func syncDir(path string) error {
+	d, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		_ = d.Close()
+		return err
+	}
+	return d.Close()
+}
+
+// removeCheckpoints drops all the checkpoints, they are no longer valid once the data files are rewritten.
+// The checkpoint being written, if any, is not installed then, see Checkpoint.
+func (db *DB) removeCheckpoints() error {
+	db.checkpointGen++
+	db.checkpointRefs = make(checkpointRefs)
+	return os.RemoveAll(db.getCheckpointDir())
+}
+
+// invalidateCheckpoints replaces the checkpoints pointing to the data file at given fID, which is about
+// to be removed, by an empty one at the zero position, so that the next Open replays their buckets from
+// the first data file. The entries the file still held were rewritten after every checkpoint, so the
+// other checkpoints stay valid. It is called with the write lock held, the checkpoint being written,
+// if any, is not installed then, see Checkpoint.
+func (db *DB) invalidateCheckpoints(fID int64) error {
+	// the data files the checkpoints point to are unknown when they were not loaded.
+	if db.checkpointRefs == nil {
+		return db.removeCheckpoints()
+	}
+	db.checkpointGen++
+
+	dir := db.getCheckpointDir()
+	invalidated := false
+	for name, fIDs := range db.checkpointRefs {
+		if _, ok := fIDs[fID]; !ok {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if err := writeCheckpointFile(path+".tmp", checkpointPos{}, nil); err != nil {
+			return err
+		}
+		if err := os.Rename(path+".tmp", path); err != nil {
+			return err
+		}
+		delete(db.checkpointRefs, name)
+		invalidated = true
+	}
+	if !invalidated {
+		return nil
+	}
+	return syncDir(dir)
+}
+
+// checkpointRecord is the copy of a record of a BPTree index a checkpoint encodes,
+// the records are updated in place by the writes.
+type checkpointRecord struct {
+	fileID  int64
+	dataPos uint64
+	meta    MetaData
+	key     []byte
+	value   []byte
+}
+
+// copyBPTreeCheckpoint copies the records of the BPTree index, with their values when hasValue,
+// and records the data files they point to in refs under name.
+func copyBPTreeCheckpoint(idx *BPTree, hasValue bool, name string, refs checkpointRefs) []checkpointRecord {
+	all, err := idx.All()
+	if err != nil {
+		return nil
+	}
+	records := make([]checkpointRecord, len(all))
+	for i, r := range all {
+		records[i] = checkpointRecord{fileID: r.H.FileID, dataPos: r.H.DataPos, meta: *r.H.Meta, key: r.H.Key}
+		if hasValue && r.E != nil {
+			records[i].value = r.E.Value
+		}
+		refs.add(name, r.H.FileID)
+	}
+	return records
+}
+
+// encodeBPTreeCheckpoint encodes the records copied from a BPTree index as
+// the file id, the data position, the entry header, the key and the value.
+func encodeBPTreeCheckpoint(records []checkpointRecord, hasValue bool) [][]byte {
+	items := [][]byte{[]byte(strconv2.IntToStr(boolToInt(hasValue)))}
+	for i := range records {
+		r := &records[i]
+		header := make([]byte, DataEntryHeaderSize)
+		(&Entry{Meta: &r.meta}).setEntryHeaderBuf(header)
+		items = append(items,
+			[]byte(strconv2.Int64ToStr(r.fileID)),
+			[]byte(strconv2.Int64ToStr(int64(r.dataPos))),
+			header, r.key, r.value)
+	}
+	return items
+}
+
+// copySetCheckpoint copies the members of every set of the bucket by key.
+func copySetCheckpoint(s *set.Set) map[string][][]byte {
+	members := make(map[string][][]byte, len(s.M))
+	for key := range s.M {
+		members[key], _ = s.SMembers(key)
+	}
+	return members
+}
+
+// encodeSetCheckpoint encodes every set of the bucket as its key followed by its members.
+func encodeSetCheckpoint(members map[string][][]byte) [][]byte {
+	var items [][]byte
+	for key, m := range members {
+		items = append(items, []byte(key), MarshalListItems(m))
+	}
+	return items
+}
+
+// copySortedSetCheckpoint copies the members of the sorted set in order, the values are updated in place by the writes.
+func copySortedSetCheckpoint(ss *zset.SortedSet) []sortedSetMember {
+	nodes := ss.GetByRankRange(1, -1, false)
+	members := make([]sortedSetMember, len(nodes))
+	for i, n := range nodes {
+		members[i] = sortedSetMember{key: n.Key(), score: n.Score(), value: n.Value}
+	}
+	return members
+}
+
+// copyListCheckpoint copies the items, the ttl and the timestamp of every list of the bucket.
+func copyListCheckpoint(l *list.List) *list.List {
+	c := list.New()
+	for key, values := range l.Items {
+		c.Items[key] = append([][]byte(nil), values...)
+	}
+	for key, ttl := range l.TTL {
+		c.TTL[key] = ttl
+	}
+	for key, timestamp := range l.TimeStamp {
+		c.TimeStamp[key] = timestamp
+	}
+	return c
+}
+
+// encodeListCheckpoint encodes every list of the bucket as its key, its items, its ttl and its timestamp.
+func encodeListCheckpoint(l *list.List) [][]byte {
+	var items [][]byte
+	for key, values := range l.Items {
+		var ttl, timestamp []byte
+		if t, ok := l.TTL[key]; ok {
+			ttl = []byte(strconv2.Int64ToStr(int64(t)))
+			timestamp = []byte(strconv2.Int64ToStr(int64(l.TimeStamp[key])))
+		}
+		items = append(items, []byte(key), MarshalListItems(values), ttl, timestamp)
+	}
+	return items
+}
+
+// loadCheckpoints restores the indexes from the checkpoint files and records their positions,
+// so that parseDataFiles skips the entries they cover. If any checkpoint cannot be used,
+// none is loaded and the whole data files are replayed.
+func (db *DB) loadCheckpoints(dataFileIds []int) error {
+	files, err := ioutil.ReadDir(db.getCheckpointDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			db.checkpointRefs = make(checkpointRefs)
+			return nil
+		}
+		return err
+	}
+
+	fileIDs := make(map[int64]struct{}, len(dataFileIds))
+	for _, id := range dataFileIds {
+		fileIDs[int64(id)] = struct{}{}
+	}
+
+	c := &checkpoints{buckets: make(map[string]checkpointPos)}
+	refs := make(checkpointRefs)
+	var restores []func()
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), CheckpointSuffix) {
+			continue
+		}
+		pos, restore, err := db.readCheckpoint(filepath.Join(db.getCheckpointDir(), f.Name()), refs)
+		if err == ErrCheckpointMismatch {
+			// the checkpoints written along with the stale one cannot be trusted either.
+			return db.removeCheckpoints()
+		}
+		if err != nil {
+			return nil
+		}
+		if _, ok := fileIDs[pos.fileID]; !ok && !pos.isZero() {
+			return nil
+		}
+		if len(c.buckets) == 0 || c.start.covers(pos.fileID, pos.off) {
+			c.start = pos
+		}
+		c.buckets[f.Name()] = pos
+		if _, bucket, _ := parseCheckpointName(f.Name()); db.isBucketOpen(bucket) {
+			restores = append(restores, restore)
+		}
+	}
+	db.checkpointRefs = refs
+	if len(restores) == 0 {
+		return nil
+	}
+
+	for _, restore := range restores {
+		restore()
+	}
+	db.checkpoints = c
+	return nil
+}
+
+// readCheckpoint decodes the checkpoint file at given path and returns the function restoring its index,
+// the data files it points to are recorded in refs.
+func (db *DB) readCheckpoint(path string, refs checkpointRefs) (pos checkpointPos, restore func(), err error) {
+	name := filepath.Base(path)
+	ds, bucket, err := parseCheckpointName(name)
+	if err != nil {
+		return pos, nil, err
+	}
+	buf, err := ioutil.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return pos, nil, err
+	}
+	if len(buf) < checkpointHeaderSize || binary.LittleEndian.Uint32(buf[0:4]) != crc32.ChecksumIEEE(buf[4:]) {
+		return pos, nil, ErrCheckpointCorrupted
+	}
+	pos.fileID = int64(binary.LittleEndian.Uint64(buf[4:12]))
+	pos.off = int64(binary.LittleEndian.Uint64(buf[12:20]))
+	// an invalidated checkpoint restores nothing, its bucket is replayed.
+	if pos.isZero() && len(buf) == checkpointHeaderSize {
+		return pos, func() {}, nil
+	}
+	refs.add(name, pos.fileID)
+
+	items, err := UnmarshalListItems(buf[checkpointHeaderSize:])
+	if err != nil {
+		return pos, nil, err
+	}
+
+	switch ds {
+	case DataStructureBPTree:
+		restore, err = db.decodeBPTreeCheckpoint(bucket, items, name, refs)
+	case DataStructureSet:
+		restore, err = db.decodeSetCheckpoint(bucket, items)
+	case DataStructureSortedSet:
+		restore, err = db.decodeSortedSetCheckpoint(bucket, items)
+	case DataStructureList:
+		restore, err = db.decodeListCheckpoint(bucket, items)
+	default:
+		err = ErrCheckpointCorrupted
+	}
+	return pos, restore, err
+}
+
+func (db *DB) decodeBPTreeCheckpoint(bucket string, items [][]byte, name string, refs checkpointRefs) (func(), error) {
+	if len(items) == 0 || (len(items)-1)%5 != 0 {
+		return nil, ErrCheckpointCorrupted
+	}
+	hasValue := string(items[0]) == "1"
+	if db.opt.EntryIdxMode == HintKeyValAndRAMIdxMode && !hasValue {
+		return nil, ErrCheckpointCorrupted
+	}
+
+	var records []*Record
+	for i := 1; i < len(items); i += 5 {
+		fID, err := strconv2.StrToInt64(string(items[i]))
+		if err != nil {
+			return nil, ErrCheckpointCorrupted
+		}
+		dataPos, err := strconv2.StrToInt64(string(items[i+1]))
+		if err != nil || len(items[i+2]) != DataEntryHeaderSize {
+			return nil, ErrCheckpointCorrupted
+		}
+		e := &Entry{Key: items[i+3], Bucket: db.bucketNames.bytes(bucket)}
+		_ = e.ParseMeta(items[i+2])
+
+		r := &Record{
+			H:      &Hint{Key: e.Key, FileID: fID, Meta: e.Meta, DataPos: uint64(dataPos)},
+			Bucket: bucket,
+		}
+		refs.add(name, fID)
+		if db.opt.EntryIdxMode == HintKeyValAndRAMIdxMode {
+			e.Value = items[i+4]
+			r.E = e
+		}
+		records = append(records, r)
+	}
+	if err := db.verifyCheckpointRecords(bucket, records); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		idx := NewTree()
+		for _, r := range records {
+			h := db.newHint(r.H.Key, r.H.FileID, r.H.Meta, r.H.DataPos)
+			_ = idx.Insert(h.Key, r.E, h, CountFlagEnabled)
+			db.committedTxIds[r.H.Meta.TxID] = struct{}{}
+			db.KeyCount++
+		}
+		db.BPTreeIdx[bucket] = idx
+	}, nil
+}
+
+// verifyCheckpointRecords re-reads Options.CheckpointVerifySamples records of the BPTree checkpoint of the bucket,
+// spread over the records, from the data files and returns ErrCheckpointMismatch unless they match the entries
+// at their positions, e.g. when the checkpoint files were copied from another db.
+func (db *DB) verifyCheckpointRecords(bucket string, records []*Record) error {
+	samples := db.opt.CheckpointVerifySamples
+	if samples <= 0 || len(records) == 0 {
+		return nil
+	}
+	if samples > len(records) {
+		samples = len(records)
+	}
+
+	for i := 0; i < samples; i++ {
+		h := records[i*len(records)/samples].H
+		path := db.getDataPath(h.FileID)
+		if _, err := os.Stat(path); err != nil {
+			return ErrCheckpointMismatch
+		}
+		df, err := db.fm.getDataFile(path, db.opt.SegmentSize)
+		if err != nil {
+			return err
+		}
+		e, err := df.ReadAt(int(h.DataPos))
+		if releaseErr := df.rwManager.Release(); releaseErr != nil {
+			return releaseErr
+		}
+		if err != nil || e == nil || string(e.Bucket) != bucket || !bytes.Equal(e.Key, h.Key) ||
+			e.Meta.TxID != h.Meta.TxID || e.Meta.Timestamp != h.Meta.Timestamp || e.Meta.Flag != h.Meta.Flag {
+			return ErrCheckpointMismatch
+		}
+	}
+	return nil
+}
+
+func (db *DB) decodeSetCheckpoint(bucket string, items [][]byte) (func(), error) {
+	if len(items)%2 != 0 {
+		return nil, ErrCheckpointCorrupted
+	}
+	s := set.New()
+	for i := 0; i < len(items); i += 2 {
+		members, err := UnmarshalListItems(items[i+1])
+		if err != nil {
+			return nil, err
+		}
+		setMembers(s, string(items[i]), members)
+	}
+	return func() {
+		db.SetIdx[bucket] = s
+	}, nil
+}
+
+func (db *DB) decodeSortedSetCheckpoint(bucket string, items [][]byte) (func(), error) {
+	if len(items) != 1 {
+		return nil, ErrCheckpointCorrupted
+	}
+	ss, err := UnmarshalSortedSetNodes(items[0])
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		db.SortedSetIdx[bucket] = ss
+	}, nil
+}
+
+func (db *DB) decodeListCheckpoint(bucket string, items [][]byte) (func(), error) {
+	if len(items)%4 != 0 {
+		return nil, ErrCheckpointCorrupted
+	}
+	l := list.New()
+	l.Clock = db.Index.clock
+	for i := 0; i < len(items); i += 4 {
+		key := string(items[i])
+		values, err := UnmarshalListItems(items[i+1])
+		if err != nil {
+			return nil, err
+		}
+		setListItems(l, key, values)
+		if len(items[i+2]) > 0 {
+			ttl, err := strconv2.StrToInt64(string(items[i+2]))
+			if err != nil {
+				return nil, ErrCheckpointCorrupted
+			}
+			timestamp, err := strconv2.StrToInt64(string(items[i+3]))
+			if err != nil {
+				return nil, ErrCheckpointCorrupted
+			}
+			l.TTL[key] = uint32(ttl)
+			l.TimeStamp[key] = uint64(timestamp)
+		}
+	}
+	return func() {
+		db.Index.list[bucket] = l
+	}, nil
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
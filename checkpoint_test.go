@@ -0,0 +1,421 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xujiajun/utils/filesystem"
+)
+
+func TestDB_Checkpoint(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestcheckpoint", true)
+	opt.SegmentSize = 1024
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	err = db.Update(func(tx *Tx) error {
+		for i := 0; i < 10; i++ {
+			key := []byte(fmt.Sprintf("key_%d", i))
+			if err := tx.Put("bucket", key, []byte("value"), Persistent); err != nil {
+				return err
+			}
+			if err := tx.RPush("list", []byte("key"), key); err != nil {
+				return err
+			}
+			if err := tx.SAdd("set", []byte("key"), key); err != nil {
+				return err
+			}
+			if err := tx.ZAdd("zset", key, float64(i), []byte("value")); err != nil {
+				return err
+			}
+		}
+		return tx.Put("deleted", []byte("key"), []byte("value"), Persistent)
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Checkpoint())
+
+	err = db.Update(func(tx *Tx) error {
+		if err := tx.Put("bucket", []byte("key_0"), []byte("updated"), Persistent); err != nil {
+			return err
+		}
+		if _, err := tx.LPop("list", []byte("key")); err != nil {
+			return err
+		}
+		if err := tx.SRem("set", []byte("key"), []byte("key_0")); err != nil {
+			return err
+		}
+		if err := tx.ZRem("zset", "key_0"); err != nil {
+			return err
+		}
+		return tx.DeleteBucket(DataStructureBPTree, "deleted")
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	require.NotNil(t, db.checkpoints)
+
+	err = db.View(func(tx *Tx) error {
+		e, err := tx.Get("bucket", []byte("key_0"))
+		if assert.NoError(t, err) {
+			assert.Equal(t, []byte("updated"), e.Value)
+		}
+		e, err = tx.Get("bucket", []byte("key_9"))
+		if assert.NoError(t, err) {
+			assert.Equal(t, []byte("value"), e.Value)
+		}
+		_, err = tx.Get("deleted", []byte("key"))
+		assert.Error(t, err)
+
+		items, err := tx.LRange("list", []byte("key"), 0, -1)
+		if assert.NoError(t, err) {
+			assert.Len(t, items, 9)
+			assert.Equal(t, []byte("key_1"), items[0])
+		}
+		ok, _ := tx.SIsMember("set", []byte("key"), []byte("key_0"))
+		assert.False(t, ok)
+		members, err := tx.SMembers("set", []byte("key"))
+		assert.NoError(t, err)
+		assert.Len(t, members, 9)
+
+		card, err := tx.ZCard("zset")
+		assert.NoError(t, err)
+		assert.Equal(t, 9, card)
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+}
+
+func TestDB_Checkpoint_RemovedByMerge(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestcheckpointmerge", true)
+	opt.SegmentSize = 1024
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	for i := 0; i < 30; i++ {
+		err = db.Update(func(tx *Tx) error {
+			return tx.Put("bucket", []byte(fmt.Sprintf("key_%d", i)), []byte("value"), Persistent)
+		})
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.Checkpoint())
+	assert.True(t, filesystem.PathIsExist(db.getCheckpointDir()))
+
+	require.NoError(t, db.Merge())
+	require.NoError(t, db.Close())
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	require.NotNil(t, db.checkpoints)
+	assert.Equal(t, checkpointPos{}, db.checkpoints.start)
+	err = db.View(func(tx *Tx) error {
+		entries, err := tx.GetAll("bucket")
+		assert.NoError(t, err)
+		assert.Len(t, entries, 30)
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+}
+
+func TestDB_Checkpoint_InvalidatedByMerge(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestcheckpointinvalidated", true)
+	opt.SegmentSize = 1024
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	for i := 0; i < 30; i++ {
+		err = db.Update(func(tx *Tx) error {
+			return tx.Put("old", []byte(fmt.Sprintf("key_%d", i)), []byte("value"), Persistent)
+		})
+		require.NoError(t, err)
+	}
+	err = db.Update(func(tx *Tx) error {
+		if err := tx.Put("new", []byte("key"), []byte("value"), Persistent); err != nil {
+			return err
+		}
+		return tx.SAdd("set", []byte("key"), []byte("member"))
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Checkpoint())
+
+	_, dataFileIds := db.getMaxFileIDAndFileIDs()
+	require.True(t, len(dataFileIds) > 1)
+	_, merged, err := db.mergeOldestFile(context.Background(), int64(dataFileIds[0]))
+	require.NoError(t, err)
+	require.True(t, merged)
+	require.NoError(t, db.Close())
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	require.NotNil(t, db.checkpoints)
+	// only the checkpoint pointing to the merged file is replayed from the first data file.
+	assert.Equal(t, checkpointPos{}, db.checkpoints.buckets[checkpointName(DataStructureBPTree, "old")])
+	assert.NotEqual(t, checkpointPos{}, db.checkpoints.buckets[checkpointName(DataStructureBPTree, "new")])
+	assert.NotEqual(t, checkpointPos{}, db.checkpoints.buckets[checkpointName(DataStructureSet, "set")])
+
+	err = db.View(func(tx *Tx) error {
+		entries, err := tx.GetAll("old")
+		assert.NoError(t, err)
+		assert.Len(t, entries, 30)
+		e, err := tx.Get("new", []byte("key"))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("value"), e.Value)
+		ok, err := tx.SIsMember("set", []byte("key"), []byte("member"))
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+}
+
+func TestDB_Checkpoint_DuringRead(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestcheckpointread", true)
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	err = db.Update(func(tx *Tx) error {
+		return tx.Put("bucket", []byte("key"), []byte("value"), Persistent)
+	})
+	require.NoError(t, err)
+
+	// the indexes are copied under the read lock, the read txs are not waited for.
+	err = db.View(func(tx *Tx) error {
+		done := make(chan error, 1)
+		go func() {
+			done <- db.Checkpoint()
+		}()
+		select {
+		case err := <-done:
+			assert.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("checkpoint waits for the read tx")
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	require.NotNil(t, db.checkpoints)
+	require.NoError(t, db.Close())
+}
+
+func TestDB_Checkpoint_ConcurrentWrites(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestcheckpointconcurrent", true)
+	opt.SegmentSize = 1024
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	done := make(chan error)
+	go func() {
+		for i := 0; i < 100; i++ {
+			err := db.Update(func(tx *Tx) error {
+				return tx.Put("bucket", []byte(fmt.Sprintf("key_%d", i)), bytes.Repeat([]byte("v"), 100), Persistent)
+			})
+			if err == nil && i%30 == 29 {
+				err = db.Merge()
+			}
+			if err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	// the writes and the merges go on while the checkpoints are written.
+	for running := true; running; {
+		select {
+		case err := <-done:
+			require.NoError(t, err)
+			running = false
+		default:
+			if err := db.Checkpoint(); err != ErrCheckpointInterrupted {
+				require.NoError(t, err)
+			}
+		}
+	}
+	require.NoError(t, db.Checkpoint())
+
+	assert.False(t, filesystem.PathIsExist(filepath.Join(opt.Dir, checkpointTmpDir)))
+	require.NoError(t, db.Close())
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	require.NotNil(t, db.checkpoints)
+	err = db.View(func(tx *Tx) error {
+		entries, err := tx.GetAll("bucket")
+		assert.NoError(t, err)
+		assert.Len(t, entries, 100)
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+}
+
+func TestDB_Checkpoint_Corrupted(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestcheckpointcorrupted", true)
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	err = db.Update(func(tx *Tx) error {
+		return tx.Put("bucket", []byte("key"), []byte("value"), Persistent)
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Checkpoint())
+	require.NoError(t, db.Close())
+
+	path := db.getCheckpointDir() + "/" + checkpointName(DataStructureBPTree, "bucket")
+	require.NoError(t, writeCheckpointFile(path, checkpointPos{}, []byte{0xff}))
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	assert.Nil(t, db.checkpoints)
+	err = db.View(func(tx *Tx) error {
+		e, err := tx.Get("bucket", []byte("key"))
+		if assert.NoError(t, err) {
+			assert.Equal(t, []byte("value"), e.Value)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+}
+
+func TestDB_Checkpoint_CrcMismatch(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestcheckpointcrc", true)
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	err = db.Update(func(tx *Tx) error {
+		return tx.Put("bucket", []byte("key"), []byte("value"), Persistent)
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Checkpoint())
+	require.NoError(t, db.Close())
+
+	path := db.getCheckpointDir() + "/" + checkpointName(DataStructureBPTree, "bucket")
+	buf, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	buf[len(buf)-1] ^= 0xff
+	require.NoError(t, ioutil.WriteFile(path, buf, 0644))
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	assert.Nil(t, db.checkpoints)
+	err = db.View(func(tx *Tx) error {
+		e, err := tx.Get("bucket", []byte("key"))
+		if assert.NoError(t, err) {
+			assert.Equal(t, []byte("value"), e.Value)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+}
+
+func TestDB_IndexSnapshotInterval(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestindexsnapshot", true)
+	db, err = Open(opt, WithIndexSnapshotInterval(10*time.Millisecond))
+	require.NoError(t, err)
+
+	err = db.Update(func(tx *Tx) error {
+		return tx.Put("bucket", []byte("key"), []byte("value"), Persistent)
+	})
+	require.NoError(t, err)
+
+	path := db.getCheckpointDir() + "/" + checkpointName(DataStructureBPTree, "bucket")
+	assert.Eventually(t, func() bool {
+		return filesystem.PathIsExist(path)
+	}, time.Second, 10*time.Millisecond)
+	require.NoError(t, db.Close())
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	assert.NotNil(t, db.checkpoints)
+	err = db.View(func(tx *Tx) error {
+		e, err := tx.Get("bucket", []byte("key"))
+		if assert.NoError(t, err) {
+			assert.Equal(t, []byte("value"), e.Value)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+}
+
+func TestDB_CheckpointVerifySamples(t *testing.T) {
+	write := func(dir, prefix string) {
+		InitOpt(dir, true)
+		db, err = Open(opt)
+		require.NoError(t, err)
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			for i := 0; i < 10; i++ {
+				key := []byte(fmt.Sprintf("%s_%d", prefix, i))
+				if err := tx.Put("bucket", key, key, Persistent); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+		require.NoError(t, db.Checkpoint())
+		require.NoError(t, db.Close())
+	}
+	write("/tmp/nutsdbtestcheckpointother", "a")
+	write("/tmp/nutsdbtestcheckpointverify", "b")
+
+	// the checkpoints of another db copied over the ones of the db.
+	require.NoError(t, os.RemoveAll(filepath.Join(opt.Dir, checkpointDir)))
+	require.NoError(t, filesystem.CopyDir(filepath.Join("/tmp/nutsdbtestcheckpointother", checkpointDir), filepath.Join(opt.Dir, checkpointDir)))
+
+	db, err = Open(opt, WithCheckpointVerifySamples(3))
+	require.NoError(t, err)
+	assert.Nil(t, db.checkpoints)
+	_, err = os.Stat(filepath.Join(opt.Dir, checkpointDir))
+	assert.True(t, os.IsNotExist(err))
+	require.NoError(t, db.View(func(tx *Tx) error {
+		entries, err := tx.GetAll("bucket")
+		require.NoError(t, err)
+		require.Len(t, entries, 10)
+		for _, e := range entries {
+			assert.Equal(t, e.Key, e.Value)
+			assert.Equal(t, byte('b'), e.Key[0])
+		}
+		return nil
+	}))
+
+	// the checkpoints matching the data files are loaded.
+	require.NoError(t, db.Checkpoint())
+	require.NoError(t, db.Close())
+	db, err = Open(opt, WithCheckpointVerifySamples(3))
+	require.NoError(t, err)
+	assert.NotNil(t, db.checkpoints)
+	require.NoError(t, db.Close())
+}
@@ -0,0 +1,47 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import "time"
+
+// Clock is the source of the time of a db, see Options.Clock.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+}
+
+// now returns the time of the clock of the db. The nil db of a closed tx reads the wall clock,
+// the tx then fails with ErrTxClosed.
+func (db *DB) now() time.Time {
+	if db != nil && db.opt.Clock != nil {
+		return db.opt.Clock.Now()
+	}
+	return time.Now()
+}
+
+// timestamp returns the unix time of the clock of the db, the entries are written with.
+func (db *DB) timestamp() uint64 {
+	return uint64(db.now().Unix())
+}
+
+// listClock returns the unix time of the clock of the db, the expiry of the lists is checked at.
+func (db *DB) listClock() int64 {
+	return db.now().Unix()
+}
+
+// isTTLExpired returns whether the ttl of the entry written at the timestamp has passed on the clock of the db.
+func (db *DB) isTTLExpired(ttl uint32, timestamp uint64) bool {
+	return isExpiredAt(ttl, timestamp, db.timestamp())
+}
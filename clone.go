@@ -0,0 +1,122 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ErrCloneDirNotEmpty is returned by CloneTo when the target directory already holds files.
+var ErrCloneDirNotEmpty = errors.New("the clone directory is not empty")
+
+// CloneTo creates an independent database in dir from the current state of the DB.
+// The transactions never write the sealed data files again, so they are shared through hard links
+// and only copied when linking is not possible, e.g. across file systems.
+// The active data file and all the other files are copied.
+// Only the read repair writes into a sealed file, it restores the same bytes for both databases.
+// The clone is opened with Open like any other database.
+func (db *DB) CloneTo(dir string) error {
+	return db.View(func(tx *Tx) error {
+		src, err := filepath.Abs(db.opt.Dir)
+		if err != nil {
+			return err
+		}
+		dst, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		if src == dst {
+			return ErrCloneDirNotEmpty
+		}
+
+		if f, err := os.Open(dst); err == nil {
+			_, err = f.Readdirnames(1)
+			_ = f.Close()
+			if err != io.EOF {
+				return ErrCloneDirNotEmpty
+			}
+		}
+
+		return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			rel, err := filepath.Rel(src, path)
+			if err != nil {
+				return err
+			}
+			target := filepath.Join(dst, rel)
+
+			if info.IsDir() {
+				return os.MkdirAll(target, info.Mode())
+			}
+
+			if db.isSealedDataFile(rel) {
+				if err := os.Link(path, target); err == nil {
+					return nil
+				}
+			}
+
+			return copyFile(path, target, info.Mode())
+		})
+	})
+}
+
+// isSealedDataFile reports whether the file at the given path relative to the db dir is a data file
+// other than the active one.
+func (db *DB) isSealedDataFile(rel string) bool {
+	if filepath.Dir(rel) != "." || !strings.HasSuffix(rel, DataSuffix) {
+		return false
+	}
+
+	fID, err := strconv.ParseInt(strings.TrimSuffix(rel, DataSuffix), 10, 64)
+	if err != nil {
+		return false
+	}
+
+	return fID < db.MaxFileID
+}
+
+// copyFile copies the file at src to dst.
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(filepath.Clean(src))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(filepath.Clean(dst), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+
+	if err = out.Sync(); err != nil {
+		_ = out.Close()
+		return err
+	}
+
+	return out.Close()
+}
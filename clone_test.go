@@ -0,0 +1,94 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_CloneTo(t *testing.T) {
+	cloneDir := "/tmp/nutsdbtestclone"
+	require.NoError(t, os.RemoveAll(cloneDir))
+
+	InitOpt("/tmp/nutsdbtestclonesrc", true)
+	opt.SegmentSize = 8 * KB
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	bucket := "bucket"
+	for i := 0; i < 200; i++ {
+		err = db.Update(func(tx *Tx) error {
+			return tx.Put(bucket, []byte(fmt.Sprintf("key_%03d", i)), []byte("value"), Persistent)
+		})
+		require.NoError(t, err)
+	}
+	require.True(t, db.MaxFileID > 0)
+
+	require.NoError(t, db.CloneTo(cloneDir))
+	assert.Equal(t, ErrCloneDirNotEmpty, db.CloneTo(cloneDir))
+	assert.Equal(t, ErrCloneDirNotEmpty, db.CloneTo(opt.Dir))
+
+	srcInfo, err := os.Stat(db.getDataPath(0))
+	require.NoError(t, err)
+	dstInfo, err := os.Stat(cloneDir + "/0" + DataSuffix)
+	require.NoError(t, err)
+	assert.True(t, os.SameFile(srcInfo, dstInfo))
+
+	srcInfo, err = os.Stat(db.getDataPath(db.MaxFileID))
+	require.NoError(t, err)
+	dstInfo, err = os.Stat(fmt.Sprintf("%s/%d%s", cloneDir, db.MaxFileID, DataSuffix))
+	require.NoError(t, err)
+	assert.False(t, os.SameFile(srcInfo, dstInfo))
+
+	cloneOpt := opt
+	cloneOpt.Dir = cloneDir
+	clone, err := Open(cloneOpt)
+	require.NoError(t, err)
+
+	// the writes to either database are not seen by the other one.
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.Put(bucket, []byte("key_src"), []byte("value"), Persistent)
+	}))
+	require.NoError(t, clone.Update(func(tx *Tx) error {
+		if err := tx.Delete(bucket, []byte("key_000")); err != nil {
+			return err
+		}
+		return tx.Put(bucket, []byte("key_clone"), []byte("value"), Persistent)
+	}))
+
+	require.NoError(t, clone.View(func(tx *Tx) error {
+		entries, err := tx.GetAll(bucket)
+		assert.NoError(t, err)
+		assert.Len(t, entries, 200)
+		_, err = tx.Get(bucket, []byte("key_src"))
+		assert.Error(t, err)
+		return nil
+	}))
+	require.NoError(t, db.View(func(tx *Tx) error {
+		_, err := tx.Get(bucket, []byte("key_000"))
+		assert.NoError(t, err)
+		_, err = tx.Get(bucket, []byte("key_clone"))
+		assert.Error(t, err)
+		return nil
+	}))
+
+	require.NoError(t, clone.Close())
+	require.NoError(t, db.Close())
+}
@@ -0,0 +1,230 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cluster spreads the keys of the key/value buckets across several local nutsdb DBs,
+// e.g. one per disk or per core, so that the writes are not serialized by a single file and lock.
+// The keys are placed with consistent hashing, adding or removing a shard only moves a fraction of them.
+package cluster
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/nutsdb/nutsdb"
+)
+
+var (
+	// ErrNoShards is returned by Open when no shard directory is given.
+	ErrNoShards = errors.New("cluster has no shards")
+
+	// ErrShardExists is returned when adding a shard already in the cluster.
+	ErrShardExists = errors.New("shard already exists")
+
+	// ErrShardNotFound is returned when removing a shard not in the cluster.
+	ErrShardNotFound = errors.New("shard not found")
+
+	// ErrLastShard is returned when removing the only shard of the cluster.
+	ErrLastShard = errors.New("cannot remove the last shard")
+)
+
+type (
+	Option func(*Options)
+
+	Options struct {
+		// Dirs holds the directory of every shard, the directory identifies the shard on the hash ring
+		// so a shard keeps its keys only while it is opened from the same path.
+		Dirs []string
+
+		// VirtualNodes is the number of points of every shard on the hash ring,
+		// more points spread the keys more evenly.
+		VirtualNodes int
+
+		// DBOptions is used to open every shard, its Dir is replaced by the directory of the shard.
+		DBOptions nutsdb.Options
+
+		// Hasher hashes the keys and the virtual nodes, xxhash if nil.
+		Hasher Hasher
+	}
+
+	// Cluster shards the keys of the key/value buckets across several nutsdb DBs.
+	// The transactions run on a single shard, there is no atomicity across the shards.
+	Cluster struct {
+		mu     sync.RWMutex
+		opts   Options
+		ring   *ring
+		shards map[string]*nutsdb.DB
+	}
+)
+
+// DefaultOptions default options
+var DefaultOptions = func() Options {
+	return Options{
+		VirtualNodes: 128,
+		DBOptions:    nutsdb.DefaultOptions,
+	}
+}()
+
+func WithDirs(dirs ...string) Option {
+	return func(opt *Options) {
+		opt.Dirs = dirs
+	}
+}
+
+func WithVirtualNodes(virtualNodes int) Option {
+	return func(opt *Options) {
+		opt.VirtualNodes = virtualNodes
+	}
+}
+
+func WithDBOptions(options nutsdb.Options) Option {
+	return func(opt *Options) {
+		opt.DBOptions = options
+	}
+}
+
+func WithHasher(hasher Hasher) Option {
+	return func(opt *Options) {
+		opt.Hasher = hasher
+	}
+}
+
+func open(opts Options) (*Cluster, error) {
+	if len(opts.Dirs) == 0 {
+		return nil, ErrNoShards
+	}
+	if opts.VirtualNodes <= 0 {
+		opts.VirtualNodes = DefaultOptions.VirtualNodes
+	}
+	if opts.Hasher == nil {
+		opts.Hasher = newDefaultHasher()
+	}
+
+	c := &Cluster{
+		opts:   opts,
+		ring:   newRing(opts.Hasher, opts.VirtualNodes),
+		shards: make(map[string]*nutsdb.DB, len(opts.Dirs)),
+	}
+
+	for _, dir := range opts.Dirs {
+		if _, ok := c.shards[dir]; ok {
+			_ = c.Close()
+			return nil, ErrShardExists
+		}
+		db, err := c.openShard(dir)
+		if err != nil {
+			_ = c.Close()
+			return nil, err
+		}
+		c.shards[dir] = db
+		c.ring.add(dir)
+	}
+
+	return c, nil
+}
+
+// Open opens the DB of every shard and returns the cluster.
+func Open(options Options, ops ...Option) (*Cluster, error) {
+	opts := &options
+	for _, do := range ops {
+		do(opts)
+	}
+	return open(*opts)
+}
+
+func (c *Cluster) openShard(dir string) (*nutsdb.DB, error) {
+	return nutsdb.Open(c.opts.DBOptions, nutsdb.WithDir(dir))
+}
+
+// Close closes the DB of every shard and returns the first error met.
+func (c *Cluster) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var firstErr error
+	for dir, db := range c.shards {
+		if err := db.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(c.shards, dir)
+	}
+	return firstErr
+}
+
+// Shards returns the directories of the shards, sorted.
+func (c *Cluster) Shards() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	dirs := make([]string, 0, len(c.shards))
+	for dir := range c.shards {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// Shard returns the DB owning the key of the bucket.
+// The DB stops owning the key once a shard is added or removed.
+func (c *Cluster) Shard(bucket string, key []byte) *nutsdb.DB {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.shards[c.ring.locate(bucket, key)]
+}
+
+// Update runs fn in a read-write transaction of the shard owning the key of the bucket.
+// The shards cannot be added or removed while fn runs.
+func (c *Cluster) Update(bucket string, key []byte, fn func(tx *nutsdb.Tx) error) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.shards[c.ring.locate(bucket, key)].Update(fn)
+}
+
+// View runs fn in a read-only transaction of the shard owning the key of the bucket.
+// The shards cannot be added or removed while fn runs.
+func (c *Cluster) View(bucket string, key []byte, fn func(tx *nutsdb.Tx) error) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.shards[c.ring.locate(bucket, key)].View(fn)
+}
+
+// Put sets the value for the key in the bucket on the shard owning it.
+func (c *Cluster) Put(bucket string, key, value []byte, ttl uint32) error {
+	return c.Update(bucket, key, func(tx *nutsdb.Tx) error {
+		return tx.Put(bucket, key, value, ttl)
+	})
+}
+
+// Get returns a copy of the value for the key in the bucket from the shard owning it.
+func (c *Cluster) Get(bucket string, key []byte) (value []byte, err error) {
+	err = c.View(bucket, key, func(tx *nutsdb.Tx) error {
+		e, err := tx.Get(bucket, key)
+		if err != nil {
+			return err
+		}
+		value = append([]byte(nil), e.Value...)
+		return nil
+	})
+	return value, err
+}
+
+// Delete removes the key in the bucket from the shard owning it.
+func (c *Cluster) Delete(bucket string, key []byte) error {
+	return c.Update(bucket, key, func(tx *nutsdb.Tx) error {
+		return tx.Delete(bucket, key)
+	})
+}
@@ -0,0 +1,201 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nutsdb/nutsdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testBucket = "bucket_cluster"
+
+func testDirs(t *testing.T, n int) []string {
+	root := filepath.Join(os.TempDir(), "nutsdbtestcluster")
+	require.NoError(t, os.RemoveAll(root))
+
+	dirs := make([]string, n)
+	for i := range dirs {
+		dirs[i] = filepath.Join(root, fmt.Sprintf("shard_%d", i))
+	}
+	return dirs
+}
+
+func testKey(i int) []byte {
+	return []byte(fmt.Sprintf("key_%03d", i))
+}
+
+// keysPerShard returns the number of keys of the test bucket stored on every shard.
+func keysPerShard(t *testing.T, c *Cluster) map[string]int {
+	counts := make(map[string]int)
+	for _, dir := range c.Shards() {
+		require.NoError(t, c.shards[dir].View(func(tx *nutsdb.Tx) error {
+			entries, err := tx.GetAll(testBucket)
+			if err == nutsdb.ErrBucketEmpty {
+				return nil
+			}
+			counts[dir] = len(entries)
+			return err
+		}))
+	}
+	return counts
+}
+
+func TestCluster_PutGetDelete(t *testing.T) {
+	dirs := testDirs(t, 3)
+	c, err := Open(DefaultOptions, WithDirs(dirs...))
+	require.NoError(t, err)
+	defer c.Close()
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, c.Put(testBucket, testKey(i), testKey(i), nutsdb.Persistent))
+	}
+	for i := 0; i < 100; i++ {
+		value, err := c.Get(testBucket, testKey(i))
+		require.NoError(t, err)
+		assert.Equal(t, testKey(i), value)
+	}
+
+	counts := keysPerShard(t, c)
+	assert.Len(t, counts, 3)
+	for _, n := range counts {
+		assert.Greater(t, n, 10)
+	}
+
+	require.NoError(t, c.Delete(testBucket, testKey(0)))
+	_, err = c.Get(testBucket, testKey(0))
+	assert.Error(t, err)
+
+	_, err = Open(DefaultOptions)
+	assert.Equal(t, ErrNoShards, err)
+}
+
+func TestCluster_AddRemoveShard(t *testing.T) {
+	dirs := testDirs(t, 3)
+	c, err := Open(DefaultOptions, WithDirs(dirs[:2]...))
+	require.NoError(t, err)
+	defer c.Close()
+
+	for i := 0; i < 200; i++ {
+		require.NoError(t, c.Put(testBucket, testKey(i), testKey(i), nutsdb.Persistent))
+	}
+	before := keysPerShard(t, c)
+
+	require.NoError(t, c.AddShard(dirs[2]))
+	assert.Equal(t, ErrShardExists, c.AddShard(dirs[2]))
+	after := keysPerShard(t, c)
+	assert.Greater(t, after[dirs[2]], 0)
+	// the keys only move to the new shard.
+	for _, dir := range dirs[:2] {
+		assert.LessOrEqual(t, after[dir], before[dir])
+	}
+	assert.Equal(t, 200, after[dirs[0]]+after[dirs[1]]+after[dirs[2]])
+
+	require.NoError(t, c.RemoveShard(dirs[0]))
+	assert.Equal(t, ErrShardNotFound, c.RemoveShard(dirs[0]))
+	assert.Equal(t, []string{dirs[1], dirs[2]}, c.Shards())
+
+	for i := 0; i < 200; i++ {
+		value, err := c.Get(testBucket, testKey(i))
+		require.NoError(t, err)
+		assert.Equal(t, testKey(i), value)
+	}
+
+	require.NoError(t, c.RemoveShard(dirs[1]))
+	assert.Equal(t, ErrLastShard, c.RemoveShard(dirs[2]))
+	assert.Equal(t, 200, keysPerShard(t, c)[dirs[2]])
+}
+
+func TestCluster_Iterator(t *testing.T) {
+	dirs := testDirs(t, 3)
+	c, err := Open(DefaultOptions, WithDirs(dirs...))
+	require.NoError(t, err)
+	defer c.Close()
+
+	for i := 0; i < 50; i++ {
+		require.NoError(t, c.Put(testBucket, testKey(i), []byte("value"), nutsdb.Persistent))
+	}
+
+	// a stale copy left on another shard is skipped.
+	owner := c.Shard(testBucket, testKey(7))
+	for _, dir := range dirs {
+		if db := c.shards[dir]; db != owner {
+			require.NoError(t, db.Update(func(tx *nutsdb.Tx) error {
+				return tx.Put(testBucket, testKey(7), []byte("stale"), nutsdb.Persistent)
+			}))
+			break
+		}
+	}
+
+	for _, reverse := range []bool{false, true} {
+		it, err := c.NewIterator(testBucket, nutsdb.IteratorOptions{Reverse: reverse})
+		require.NoError(t, err)
+
+		var keys []string
+		for {
+			ok, err := it.SetNext()
+			require.NoError(t, err)
+			if !ok {
+				break
+			}
+			assert.Equal(t, []byte("value"), it.Entry().Value)
+			keys = append(keys, string(it.Entry().Key))
+		}
+		require.NoError(t, it.Close())
+
+		require.Len(t, keys, 50)
+		for i := range keys {
+			want := i
+			if reverse {
+				want = 49 - i
+			}
+			assert.Equal(t, string(testKey(want)), keys[i])
+		}
+	}
+
+	// the shards can be changed once the iterators are closed.
+	require.NoError(t, c.AddShard(filepath.Join(filepath.Dir(dirs[0]), "shard_new")))
+}
+
+func TestRing_Locate(t *testing.T) {
+	r := newRing(newDefaultHasher(), 64)
+	assert.Equal(t, "", r.locate(testBucket, testKey(0)))
+
+	r.add("a")
+	r.add("b")
+	owners := make(map[string]string)
+	for i := 0; i < 1000; i++ {
+		owners[string(testKey(i))] = r.locate(testBucket, testKey(i))
+	}
+
+	r.remove("a")
+	for key := range owners {
+		assert.Equal(t, "b", r.locate(testBucket, []byte(key)))
+	}
+
+	// only the keys taken by the new shard move.
+	r.add("a")
+	r.add("c")
+	for key, owner := range owners {
+		if now := r.locate(testBucket, []byte(key)); now != owner {
+			assert.Equal(t, "c", now)
+		}
+	}
+}
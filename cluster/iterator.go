@@ -0,0 +1,160 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/nutsdb/nutsdb"
+)
+
+// Iterator walks the keys of a bucket across all the shards, merged in key order.
+// It holds a read-only transaction on every shard, and the shards cannot be added or removed,
+// until it is closed.
+type Iterator struct {
+	c       *Cluster
+	bucket  string
+	reverse bool
+
+	dirs  []string
+	txs   []*nutsdb.Tx
+	its   []*nutsdb.Iterator
+	heads []*nutsdb.Entry
+
+	started bool
+	entry   *nutsdb.Entry
+}
+
+// NewIterator returns an iterator over the keys of the bucket on all the shards.
+func (c *Cluster) NewIterator(bucket string, options nutsdb.IteratorOptions) (*Iterator, error) {
+	c.mu.RLock()
+
+	it := &Iterator{c: c, bucket: bucket, reverse: options.Reverse}
+	for dir := range c.shards {
+		it.dirs = append(it.dirs, dir)
+	}
+	sort.Strings(it.dirs)
+
+	for _, dir := range it.dirs {
+		tx, err := c.shards[dir].Begin(false)
+		if err != nil {
+			_ = it.Close()
+			return nil, err
+		}
+		it.txs = append(it.txs, tx)
+		it.its = append(it.its, nutsdb.NewIterator(tx, bucket, options))
+	}
+	it.heads = make([]*nutsdb.Entry, len(it.its))
+
+	return it, nil
+}
+
+// SetNext would set the next Entry item, and would return (true, nil) if the next item is available
+// Otherwise if the next item is not available it would return (false, nil)
+// If it faces error it would return (false, err)
+// A key found on several shards, e.g. left behind by a rebalance, is returned once from its owner.
+func (it *Iterator) SetNext() (bool, error) {
+	if it.c == nil {
+		return false, nutsdb.ErrTxClosed
+	}
+
+	if !it.started {
+		it.started = true
+		for i := range it.its {
+			if err := it.advance(i); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	next := -1
+	for i, head := range it.heads {
+		if head == nil {
+			continue
+		}
+		if next == -1 || it.before(head.Key, it.heads[next].Key) {
+			next = i
+		}
+	}
+	if next == -1 {
+		it.entry = nil
+		return false, nil
+	}
+
+	key := it.heads[next].Key
+	owner := it.c.ring.locate(it.bucket, key)
+	it.entry = it.heads[next]
+	for i, head := range it.heads {
+		if head == nil || !bytes.Equal(head.Key, key) {
+			continue
+		}
+		if it.dirs[i] == owner {
+			it.entry = head
+		}
+		if err := it.advance(i); err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// Entry would return the current Entry item after calling SetNext
+func (it *Iterator) Entry() *nutsdb.Entry {
+	return it.entry
+}
+
+// Close ends the transactions of the iterator.
+func (it *Iterator) Close() error {
+	if it.c == nil {
+		return nil
+	}
+
+	var firstErr error
+	for _, tx := range it.txs {
+		if err := tx.Rollback(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	it.txs, it.its, it.heads, it.entry = nil, nil, nil, nil
+
+	it.c.mu.RUnlock()
+	it.c = nil
+
+	return firstErr
+}
+
+// advance moves the head of the shard i to its next entry.
+func (it *Iterator) advance(i int) error {
+	ok, err := it.its[i].SetNext()
+	if err != nil {
+		return err
+	}
+	if ok {
+		it.heads[i] = it.its[i].Entry()
+	} else {
+		it.heads[i] = nil
+	}
+	return nil
+}
+
+// before reports whether the key a comes before the key b in the iteration order.
+func (it *Iterator) before(a, b []byte) bool {
+	if it.reverse {
+		return bytes.Compare(a, b) > 0
+	}
+	return bytes.Compare(a, b) < 0
+}
@@ -0,0 +1,181 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"github.com/nutsdb/nutsdb"
+)
+
+// rebalanceBatchSize is the number of keys moved by a single pair of transactions.
+const rebalanceBatchSize = 256
+
+// movedKey is a key to copy to its new owner before it is deleted from its old one.
+type movedKey struct {
+	owner     string
+	key       []byte
+	value     []byte
+	ttl       uint32
+	timestamp uint64
+}
+
+// AddShard opens the DB in dir, places it on the hash ring and moves to it the keys it now owns.
+// The cluster is locked until the keys are moved.
+func (c *Cluster) AddShard(dir string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.shards[dir]; ok {
+		return ErrShardExists
+	}
+
+	db, err := c.openShard(dir)
+	if err != nil {
+		return err
+	}
+	c.shards[dir] = db
+	c.ring.add(dir)
+
+	return c.rebalance()
+}
+
+// RemoveShard moves the keys of the shard in dir to their new owners, then closes its DB.
+// The files of the shard are left in dir. The cluster is locked until the keys are moved.
+// When moving the keys fails the shard stays in the cluster, the keys moved already
+// are moved back by the next Rebalance.
+func (c *Cluster) RemoveShard(dir string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	db, ok := c.shards[dir]
+	if !ok {
+		return ErrShardNotFound
+	}
+	if len(c.shards) == 1 {
+		return ErrLastShard
+	}
+
+	c.ring.remove(dir)
+	if err := c.moveKeys(dir, db); err != nil {
+		c.ring.add(dir)
+		return err
+	}
+
+	delete(c.shards, dir)
+	return db.Close()
+}
+
+// Rebalance moves every key not stored on the shard owning it, e.g. after AddShard failed half way.
+func (c *Cluster) Rebalance() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.rebalance()
+}
+
+func (c *Cluster) rebalance() error {
+	for dir, db := range c.shards {
+		if err := c.moveKeys(dir, db); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveKeys moves the keys of the key/value buckets of the shard which belong to other shards.
+// A key is copied to its owner before it is deleted from the shard, so a crash in between leaves
+// a stale copy behind on the shard rather than losing the key.
+func (c *Cluster) moveKeys(dir string, db *nutsdb.DB) error {
+	var buckets []string
+	if err := db.View(func(tx *nutsdb.Tx) error {
+		return tx.IterateBuckets(nutsdb.DataStructureBPTree, "*", func(bucket string) bool {
+			buckets = append(buckets, bucket)
+			return true
+		})
+	}); err != nil {
+		return err
+	}
+
+	for _, bucket := range buckets {
+		var moved []movedKey
+		if err := db.View(func(tx *nutsdb.Tx) error {
+			entries, err := tx.GetAll(bucket)
+			if err == nutsdb.ErrBucketEmpty {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			for _, e := range entries {
+				owner := c.ring.locate(bucket, e.Key)
+				if owner == dir {
+					continue
+				}
+				moved = append(moved, movedKey{
+					owner:     owner,
+					key:       append([]byte(nil), e.Key...),
+					value:     append([]byte(nil), e.Value...),
+					ttl:       e.Meta.TTL,
+					timestamp: e.Meta.Timestamp,
+				})
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		for len(moved) > 0 {
+			n := rebalanceBatchSize
+			if n > len(moved) {
+				n = len(moved)
+			}
+			if err := c.moveBatch(db, bucket, moved[:n]); err != nil {
+				return err
+			}
+			moved = moved[n:]
+		}
+	}
+
+	return nil
+}
+
+// moveBatch copies the keys to their owners, then deletes them from the shard.
+func (c *Cluster) moveBatch(db *nutsdb.DB, bucket string, batch []movedKey) error {
+	byOwner := make(map[string][]movedKey)
+	for _, m := range batch {
+		byOwner[m.owner] = append(byOwner[m.owner], m)
+	}
+
+	for owner, keys := range byOwner {
+		if err := c.shards[owner].Update(func(tx *nutsdb.Tx) error {
+			for _, m := range keys {
+				if err := tx.PutWithTimestamp(bucket, m.key, m.value, m.ttl, m.timestamp); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	return db.Update(func(tx *nutsdb.Tx) error {
+		for _, m := range batch {
+			if err := tx.Delete(bucket, m.key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,96 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// Hasher is responsible for generating unsigned, 64 bit hash of provided string.
+type Hasher interface {
+	Sum64(string) uint64
+}
+
+func newDefaultHasher() Hasher {
+	return xxhasher{}
+}
+
+type xxhasher struct{}
+
+// Sum64 gets the string and returns its uint64 hash value.
+func (h xxhasher) Sum64(key string) uint64 {
+	return xxhash.Sum64String(key)
+}
+
+// ringPoint is a virtual node of a shard on the hash ring.
+type ringPoint struct {
+	hash  uint64
+	shard string
+}
+
+// ring is a consistent hash ring, a key belongs to the shard of the first point at or after its hash.
+// Adding or removing a shard only moves the keys between its points and the previous ones.
+type ring struct {
+	hasher       Hasher
+	virtualNodes int
+	points       []ringPoint
+}
+
+func newRing(hasher Hasher, virtualNodes int) *ring {
+	return &ring{hasher: hasher, virtualNodes: virtualNodes}
+}
+
+// add places the virtual nodes of the shard on the ring.
+func (r *ring) add(shard string) {
+	for i := 0; i < r.virtualNodes; i++ {
+		r.points = append(r.points, ringPoint{hash: r.hasher.Sum64(shard + "#" + strconv.Itoa(i)), shard: shard})
+	}
+	sort.Slice(r.points, func(i, j int) bool {
+		if r.points[i].hash != r.points[j].hash {
+			return r.points[i].hash < r.points[j].hash
+		}
+		return r.points[i].shard < r.points[j].shard
+	})
+}
+
+// remove takes the virtual nodes of the shard off the ring.
+func (r *ring) remove(shard string) {
+	points := r.points[:0]
+	for _, p := range r.points {
+		if p.shard != shard {
+			points = append(points, p)
+		}
+	}
+	r.points = points
+}
+
+// locate returns the shard owning the key of the bucket, or "" when the ring is empty.
+func (r *ring) locate(bucket string, key []byte) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+
+	hash := r.hasher.Sum64(bucket + "\x00" + string(key))
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i].hash >= hash
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.points[i].shard
+}
@@ -0,0 +1,62 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+// DurabilityFuture is returned by CommitAsync, it is resolved once the entries of the transaction are fsynced.
+type DurabilityFuture struct {
+	done chan struct{}
+	err  error
+}
+
+func newDurabilityFuture() *DurabilityFuture {
+	return &DurabilityFuture{done: make(chan struct{})}
+}
+
+// Done returns a channel closed once the future is resolved.
+func (f *DurabilityFuture) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait blocks until the entries are fsynced and returns the error of the fsync, if any.
+func (f *DurabilityFuture) Wait() error {
+	<-f.done
+	return f.err
+}
+
+func (f *DurabilityFuture) resolve(err error) {
+	f.err = err
+	close(f.done)
+}
+
+// CommitAsync commits the transaction like Commit, except that it does not wait for the entries to be fsynced.
+// The indexes are updated and the changes are visible to the next transactions straight away.
+// The returned future is resolved once the entries are durable, whatever Options.SyncEnable is.
+func (tx *Tx) CommitAsync() (*DurabilityFuture, error) {
+	db, written := tx.db, len(tx.pendingWrites) > 0
+	tx.async = true
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	f := newDurabilityFuture()
+	if !written {
+		f.resolve(nil)
+		return f, nil
+	}
+
+	db.syncer.add(f)
+	return f, nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTx_CommitAsync(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestcommitasync", true)
+	opt.SegmentSize = 1 * KB
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	bucket := "bucket_commit_async"
+	commit := func(i int) *DurabilityFuture {
+		tx, err := db.Begin(true)
+		require.NoError(t, err)
+		require.NoError(t, tx.Put(bucket, []byte(fmt.Sprintf("key_%03d", i)), []byte("value"), Persistent))
+		f, err := tx.CommitAsync()
+		require.NoError(t, err)
+		return f
+	}
+
+	var futures []*DurabilityFuture
+	// enough writes to rotate the active file.
+	for i := 0; i < 50; i++ {
+		futures = append(futures, commit(i))
+	}
+
+	// the changes are visible before they are durable.
+	require.NoError(t, db.View(func(tx *Tx) error {
+		entries, err := tx.GetAll(bucket)
+		assert.NoError(t, err)
+		assert.Len(t, entries, 50)
+		return nil
+	}))
+
+	for _, f := range futures {
+		assert.NoError(t, f.Wait())
+	}
+	assert.True(t, db.MaxFileID > 0)
+
+	tx, err := db.Begin(true)
+	require.NoError(t, err)
+	f, err := tx.CommitAsync()
+	require.NoError(t, err)
+	select {
+	case <-f.Done():
+	default:
+		t.Fatal("the future of a transaction without writes is not resolved")
+	}
+
+	// Close fsyncs the futures still pending.
+	f = commit(50)
+	require.NoError(t, db.Close())
+	assert.NoError(t, f.Wait())
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	require.NoError(t, db.View(func(tx *Tx) error {
+		entries, err := tx.GetAll(bucket)
+		assert.NoError(t, err)
+		assert.Len(t, entries, 51)
+		return nil
+	}))
+	require.NoError(t, db.Close())
+}
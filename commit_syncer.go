@@ -0,0 +1,134 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// commitSyncer fsyncs the active file in the background for the committed transactions, so that the fsync
+// happens without the write lock held and does not block the readers. A single fsync resolves all the futures
+// pending when it starts. The fileMu keeps the writers from releasing the active file during the fsync.
+type commitSyncer struct {
+	db       *DB
+	once     sync.Once
+	fileMu   sync.Mutex
+	mu       sync.Mutex
+	pending  []*DurabilityFuture
+	closed   bool
+	closeErr error
+	wake     chan struct{}
+	stop     chan struct{}
+	unsynced int32
+	waiting  int32 // the futures added and not resolved yet
+}
+
+func newCommitSyncer(db *DB) *commitSyncer {
+	return &commitSyncer{
+		db:   db,
+		wake: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+	}
+}
+
+// add registers the future of a transaction committed without fsync.
+func (s *commitSyncer) add(f *DurabilityFuture) {
+	s.mu.Lock()
+	if s.closed {
+		err := s.closeErr
+		s.mu.Unlock()
+		f.resolve(err)
+		return
+	}
+	s.pending = append(s.pending, f)
+	atomic.AddInt32(&s.waiting, 1)
+	s.mu.Unlock()
+
+	s.once.Do(func() {
+		go s.run()
+	})
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *commitSyncer) run() {
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-s.wake:
+		}
+
+		s.mu.Lock()
+		pending := s.pending
+		s.pending = nil
+		s.mu.Unlock()
+
+		var err error
+		s.fileMu.Lock()
+		s.mu.Lock()
+		closed := s.closed
+		err = s.closeErr
+		s.mu.Unlock()
+		if !closed {
+			atomic.StoreInt32(&s.unsynced, 0)
+			err = s.db.ActiveFile.rwManager.Sync()
+		}
+		s.fileMu.Unlock()
+
+		for _, f := range pending {
+			f.resolve(err)
+		}
+		atomic.AddInt32(&s.waiting, -int32(len(pending)))
+	}
+}
+
+// markUnsynced records that the active file holds entries not fsynced yet.
+func (s *commitSyncer) markUnsynced() {
+	atomic.StoreInt32(&s.unsynced, 1)
+}
+
+// syncBeforeRelease fsyncs the active file about to be released if it holds entries not fsynced yet.
+// It is called with the write lock and the fileMu held.
+func (s *commitSyncer) syncBeforeRelease() error {
+	if atomic.LoadInt32(&s.unsynced) == 0 {
+		return nil
+	}
+	if err := s.db.ActiveFile.rwManager.Sync(); err != nil {
+		return err
+	}
+	atomic.StoreInt32(&s.unsynced, 0)
+	return nil
+}
+
+// close stops the background fsync and resolves the futures with the error of the last fsync done by Close.
+func (s *commitSyncer) close(err error) {
+	s.mu.Lock()
+	s.closed = true
+	s.closeErr = err
+	pending := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+
+	close(s.stop)
+	for _, f := range pending {
+		f.resolve(err)
+	}
+	atomic.AddInt32(&s.waiting, -int32(len(pending)))
+}
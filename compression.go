@@ -0,0 +1,195 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io/ioutil"
+	"math"
+	"sync"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression is the codec compressing the values of the key/value entries, see Options.Compression.
+type Compression uint8
+
+const (
+	// CompressionNone stores the values as they are.
+	CompressionNone Compression = iota
+
+	// CompressionFlate compresses the values with compress/flate.
+	CompressionFlate
+
+	// CompressionSnappy compresses the values in the Snappy block format, faster than CompressionFlate
+	// though less tightly.
+	CompressionSnappy
+
+	// CompressionZstd compresses the values with Zstandard, about as tightly as CompressionFlate
+	// and faster to decompress.
+	CompressionZstd
+)
+
+// defaultCompressionThreshold is the CompressionThreshold used when the option is zero.
+const defaultCompressionThreshold = 64
+
+const (
+	// entropySampleSize is the number of bytes of a value sampled by isIncompressible, the shorter values
+	// are given to the compressor whatever their entropy.
+	entropySampleSize = 1024
+
+	// entropySampleChunks is the number of chunks, evenly spread over the value, sampled by isIncompressible.
+	entropySampleChunks = 8
+
+	// incompressibleEntropy is the entropy of the sample, in bits per byte, above which a value is taken
+	// as already compressed or encrypted. Uniform random bytes sample at about 7.8.
+	incompressibleEntropy = 7.5
+)
+
+// ErrUnknownCompression is returned when a value is stored with a codec this version does not know.
+var ErrUnknownCompression = errors.New("unknown compression codec")
+
+// compressValue returns the value to write for the key/value entry with the flag in the bucket and its codec:
+// a plain value longer than Options.CompressionThreshold is compressed with Options.Compression when it gets shorter.
+func (tx *Tx) compressValue(bucket string, value []byte, flag uint16, kind valueKind) ([]byte, Compression, error) {
+	codec := tx.db.opt.Compression
+	if codec == CompressionNone || flag != DataSetFlag || isInternalBucket(bucket) || kind != valueKindPlain ||
+		len(value) <= tx.db.opt.compressionThreshold() || isIncompressible(value) {
+		return value, CompressionNone, nil
+	}
+
+	compressed, err := compress(codec, value)
+	if err != nil {
+		return nil, CompressionNone, err
+	}
+	if len(compressed) >= len(value) {
+		return value, CompressionNone, nil
+	}
+	return compressed, codec, nil
+}
+
+// isIncompressible returns whether the value looks already compressed or encrypted, by the entropy of a sample of
+// its bytes, so that it is stored as it is without running the compressor over it.
+func isIncompressible(value []byte) bool {
+	if len(value) < entropySampleSize {
+		return false
+	}
+
+	var counts [256]int
+	chunk := entropySampleSize / entropySampleChunks
+	stride := (len(value) - chunk) / (entropySampleChunks - 1)
+	for i := 0; i < entropySampleChunks; i++ {
+		for _, b := range value[i*stride : i*stride+chunk] {
+			counts[b]++
+		}
+	}
+
+	var entropy float64
+	for _, n := range counts {
+		if n == 0 {
+			continue
+		}
+		p := float64(n) / entropySampleSize
+		entropy -= p * math.Log2(p)
+	}
+	return entropy > incompressibleEntropy
+}
+
+// decompressEntry returns the entry with its value decompressed when it is stored compressed.
+func decompressEntry(e *Entry) (*Entry, error) {
+	codec := e.Meta.codec()
+	if codec == CompressionNone {
+		return e, nil
+	}
+
+	value, err := decompress(codec, e.Value)
+	if err != nil {
+		return nil, err
+	}
+	meta := *e.Meta
+	meta.setCodec(CompressionNone)
+	meta.ValueSize = uint32(len(value))
+	return &Entry{Key: e.Key, Value: value, Bucket: e.Bucket, Meta: &meta, bucketID: e.bucketID}, nil
+}
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+// zstdCodec returns the Zstandard encoder and decoder shared by the dbs, both are safe for concurrent use.
+func zstdCodec() (*zstd.Encoder, *zstd.Decoder, error) {
+	zstdOnce.Do(func() {
+		if zstdEncoder, zstdErr = zstd.NewWriter(nil); zstdErr != nil {
+			return
+		}
+		zstdDecoder, zstdErr = zstd.NewReader(nil)
+	})
+	return zstdEncoder, zstdDecoder, zstdErr
+}
+
+func compress(codec Compression, value []byte) ([]byte, error) {
+	switch codec {
+	case CompressionSnappy:
+		return s2.EncodeSnappy(nil, value), nil
+	case CompressionZstd:
+		enc, _, err := zstdCodec()
+		if err != nil {
+			return nil, err
+		}
+		return enc.EncodeAll(value, nil), nil
+	case CompressionFlate:
+		var buf bytes.Buffer
+		w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(value); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, ErrUnknownCompression
+}
+
+func decompress(codec Compression, value []byte) ([]byte, error) {
+	switch codec {
+	case CompressionNone:
+		return value, nil
+	case CompressionFlate:
+		r := flate.NewReader(bytes.NewReader(value))
+		defer func() {
+			_ = r.Close()
+		}()
+		return ioutil.ReadAll(r)
+	case CompressionSnappy:
+		return s2.Decode(nil, value)
+	case CompressionZstd:
+		_, dec, err := zstdCodec()
+		if err != nil {
+			return nil, err
+		}
+		return dec.DecodeAll(value, nil)
+	}
+	return nil, ErrUnknownCompression
+}
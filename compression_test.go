@@ -0,0 +1,216 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTx_Compression(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestcompression", true)
+	opt.SegmentSize = 1024
+	db, err = Open(opt, WithCompression(CompressionFlate, 0))
+	require.NoError(t, err)
+
+	bucket := "docs"
+	key := func(i int) []byte {
+		return []byte(fmt.Sprintf("doc_%02d", i))
+	}
+	value := func(i int) []byte {
+		return bytes.Repeat([]byte(fmt.Sprintf("document %02d;", i)), 30)
+	}
+	codecOf := func(k []byte) Compression {
+		var codec Compression
+		require.NoError(t, db.View(func(tx *Tx) error {
+			e, err := tx.lookupStored(bucket, k)
+			require.NoError(t, err)
+			codec = e.Meta.codec()
+			return nil
+		}))
+		return codec
+	}
+	check := func() {
+		require.NoError(t, db.View(func(tx *Tx) error {
+			for i := 0; i < 20; i++ {
+				e, err := tx.Get(bucket, key(i))
+				require.NoError(t, err)
+				assert.Equal(t, value(i), e.Value)
+			}
+			es, _, err := tx.PrefixScan(bucket, []byte("doc_"), 0, 100)
+			require.NoError(t, err)
+			require.Len(t, es, 20)
+			assert.Equal(t, value(0), es[0].Value)
+
+			e, err := tx.Get(bucket, []byte("short"))
+			require.NoError(t, err)
+			assert.Equal(t, []byte("value"), e.Value)
+			return nil
+		}))
+	}
+
+	for i := 0; i < 20; i++ {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.Put(bucket, key(i), value(i), Persistent)
+		}))
+	}
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.Put(bucket, []byte("short"), []byte("value"), Persistent)
+	}))
+	assert.Equal(t, CompressionFlate, codecOf(key(0)))
+	assert.Equal(t, CompressionNone, codecOf([]byte("short")))
+	check()
+	require.NoError(t, db.Close())
+
+	// the values compressed stay readable once the compression is turned off, Merge writes them back as they are.
+	opt.Compression = CompressionNone
+	db, err = Open(opt)
+	require.NoError(t, err)
+	check()
+
+	require.NoError(t, db.Merge())
+	assert.Equal(t, CompressionNone, codecOf(key(0)))
+	check()
+	require.NoError(t, db.Close())
+}
+
+func TestCompress_Codecs(t *testing.T) {
+	value := bytes.Repeat([]byte("compressible value;"), 20)
+	for _, codec := range []Compression{CompressionFlate, CompressionSnappy, CompressionZstd} {
+		compressed, err := compress(codec, value)
+		require.NoError(t, err)
+		assert.True(t, len(compressed) < len(value), codec)
+
+		decompressed, err := decompress(codec, compressed)
+		require.NoError(t, err)
+		assert.Equal(t, value, decompressed, codec)
+	}
+
+	_, err := compress(Compression(7), value)
+	assert.Equal(t, ErrUnknownCompression, err)
+}
+
+func TestTx_Compression_MixedCodecs(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestcompressionmixed", true)
+	opt.SegmentSize = 1024
+	db, err = Open(opt, WithCompression(CompressionSnappy, 0))
+	require.NoError(t, err)
+
+	bucket := "docs"
+	value := func(i int) []byte {
+		return bytes.Repeat([]byte(fmt.Sprintf("document %02d;", i)), 30)
+	}
+	put := func(from, to int) {
+		for i := from; i < to; i++ {
+			require.NoError(t, db.Update(func(tx *Tx) error {
+				return tx.Put(bucket, []byte(fmt.Sprintf("doc_%02d", i)), value(i), Persistent)
+			}))
+		}
+	}
+	check := func(codecs map[int]Compression) {
+		require.NoError(t, db.View(func(tx *Tx) error {
+			for i, codec := range codecs {
+				key := []byte(fmt.Sprintf("doc_%02d", i))
+				stored, err := tx.lookupStored(bucket, key)
+				require.NoError(t, err)
+				assert.Equal(t, codec, stored.Meta.codec(), i)
+				e, err := tx.Get(bucket, key)
+				require.NoError(t, err)
+				assert.Equal(t, value(i), e.Value)
+			}
+			return nil
+		}))
+	}
+
+	put(0, 10)
+	require.NoError(t, db.Close())
+
+	opt.Compression = CompressionZstd
+	db, err = Open(opt)
+	require.NoError(t, err)
+	put(10, 20)
+	check(map[int]Compression{0: CompressionSnappy, 9: CompressionSnappy, 10: CompressionZstd, 19: CompressionZstd})
+
+	require.NoError(t, db.Merge())
+	check(map[int]Compression{0: CompressionZstd, 9: CompressionZstd, 10: CompressionZstd, 19: CompressionZstd})
+	require.NoError(t, db.Close())
+}
+
+func TestTx_Compression_ValueLog(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestcompressionvlog", true)
+	db, err = Open(opt, WithCompression(CompressionFlate, 0), WithValueThreshold(40))
+	require.NoError(t, err)
+
+	bucket := "docs"
+	var value []byte
+	for i := 0; i < 400; i++ {
+		value = append(value, fmt.Sprintf("%d,", i*i)...)
+	}
+	events, cancel := db.Watch(bucket, nil)
+	defer cancel()
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.Put(bucket, []byte("doc"), value, Persistent)
+	}))
+	received, _ := drainEvents(events)
+	require.Len(t, received, 1)
+	assert.Equal(t, value, received[0].Value)
+
+	require.NoError(t, db.View(func(tx *Tx) error {
+		stored, err := tx.lookupStored(bucket, []byte("doc"))
+		require.NoError(t, err)
+		require.True(t, isValuePointer(stored))
+		p := decodeValuePointer(stored.Value)
+		assert.Equal(t, CompressionFlate, p.codec)
+		assert.True(t, int(p.size) < len(value))
+
+		e, err := tx.Get(bucket, []byte("doc"))
+		require.NoError(t, err)
+		assert.Equal(t, value, e.Value)
+		return nil
+	}))
+	require.NoError(t, db.Close())
+}
+
+func TestIsIncompressible(t *testing.T) {
+	random := make([]byte, 4096)
+	_, err := rand.Read(random)
+	require.NoError(t, err)
+	assert.True(t, isIncompressible(random))
+
+	assert.False(t, isIncompressible(bytes.Repeat([]byte("compressible value;"), 200)))
+	assert.False(t, isIncompressible(random[:entropySampleSize-1]))
+
+	// the values sampled as incompressible are stored as they are.
+	InitOpt("/tmp/nutsdbtestcompressionentropy", true)
+	db, err = Open(opt, WithCompression(CompressionFlate, 0))
+	require.NoError(t, err)
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.Put("docs", []byte("random"), random, Persistent)
+	}))
+	require.NoError(t, db.View(func(tx *Tx) error {
+		stored, err := tx.lookupStored("docs", []byte("random"))
+		require.NoError(t, err)
+		assert.Equal(t, CompressionNone, stored.Meta.codec())
+		assert.Equal(t, random, stored.Value)
+		return nil
+	}))
+	require.NoError(t, db.Close())
+}
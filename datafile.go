@@ -44,6 +44,8 @@ type DataFile struct {
 	writeOff   int64
 	ActualSize int64
 	rwManager  RWManager
+	bucketIDs  *bucketIDTable
+	keys       *keyring
 }
 
 // NewDataFile will return a new DataFile Object.
@@ -93,6 +95,10 @@ func (df *DataFile) ReadAt(off int) (e *Entry, err error) {
 		return nil, ErrCrc
 	}
 
+	if err := decodeEntry(df.keys, df.bucketIDs, e); err != nil {
+		return nil, err
+	}
+
 	return
 }
 
@@ -105,6 +111,18 @@ func (df *DataFile) ReadRecord(off int, payloadSize int64) (e *Entry, err error)
 		return nil, err
 	}
 
+	e, err = decodeRecord(buf, payloadSize)
+	if err != nil {
+		return nil, err
+	}
+	if err := decodeEntry(df.keys, df.bucketIDs, e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// decodeRecord decodes the entry of given payloadSize stored in buf.
+func decodeRecord(buf []byte, payloadSize int64) (e *Entry, err error) {
 	e = new(Entry)
 	err = e.ParseMeta(buf)
 	if err != nil {
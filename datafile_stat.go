@@ -0,0 +1,221 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"math"
+	"sort"
+)
+
+// neverExpire is the expiry of a data file that holds an entry without ttl.
+const neverExpire = math.MaxUint64
+
+// dataFileStat records statistics about the entries stored in a data file.
+type dataFileStat struct {
+	// maxExpiry is the unix time at which the last entry of the file expires.
+	maxExpiry uint64
+
+	// size is the number of bytes of the entries of the file.
+	size int64
+
+	// highChurnSize is the number of bytes of the entries of the BucketHintHighChurn buckets.
+	highChurnSize int64
+
+	// bucketSizes is the number of bytes of the entries of every bucket.
+	bucketSizes map[string]int64
+
+	// tombstoneSize is the number of bytes of the deletes of keys of the DataStructureBPTree buckets.
+	tombstoneSize int64
+
+	// deadSize is the number of bytes of the entries of keys of the DataStructureBPTree buckets superseded
+	// by a later set or delete of the key since the db was opened.
+	deadSize int64
+
+	// otherSize is the number of bytes of the entries other than the sets and the deletes of
+	// keys of the DataStructureBPTree buckets, CompactTombstones leaves such files to Merge.
+	otherSize int64
+
+	// compactionSkipped is set once CompactTombstones found too little of the file reclaimable.
+	compactionSkipped bool
+}
+
+// add updates the statistics with the entry at given meta.
+func (s *dataFileStat) add(meta *MetaData) {
+	if meta.TTL == Persistent {
+		s.maxExpiry = neverExpire
+		return
+	}
+
+	if expiry := meta.Timestamp + uint64(meta.TTL); expiry > s.maxExpiry {
+		s.maxExpiry = expiry
+	}
+}
+
+// isExpired returns if all the entries of the file are expired at given now.
+func (s *dataFileStat) isExpired(now uint64) bool {
+	return s.maxExpiry != neverExpire && s.maxExpiry <= now
+}
+
+// highChurnRatio returns the share of the bytes of the file held by the BucketHintHighChurn buckets.
+func (s *dataFileStat) highChurnRatio() float64 {
+	if s.size == 0 {
+		return 0
+	}
+	return float64(s.highChurnSize) / float64(s.size)
+}
+
+// garbageRatio returns the share of the bytes of the file a rewrite is expected to reclaim: the superseded
+// entries, and the deletes too in the oldest file as no older file holds the keys they delete.
+func (s *dataFileStat) garbageRatio(oldest bool) float64 {
+	if s.size == 0 {
+		return 0
+	}
+	garbage := s.deadSize
+	if oldest {
+		garbage += s.tombstoneSize
+	}
+	if garbage > s.size {
+		garbage = s.size
+	}
+	return float64(garbage) / float64(s.size)
+}
+
+// addFileStat updates the statistics of the data file at given fID with the entry.
+func (db *DB) addFileStat(fID int64, entry *Entry) {
+	stat, ok := db.fileStats[fID]
+	if !ok {
+		stat = &dataFileStat{}
+		db.fileStats[fID] = stat
+	}
+	db.addEntryStat(stat, entry)
+}
+
+// addEntryStat updates the statistics with the entry.
+func (db *DB) addEntryStat(stat *dataFileStat, entry *Entry) {
+	stat.add(entry.Meta)
+
+	bucket := string(entry.Bucket)
+	size := entry.Size()
+	stat.size += size
+	if db.opt.BucketHints[bucket] == BucketHintHighChurn {
+		stat.highChurnSize += size
+	}
+	if stat.bucketSizes == nil {
+		stat.bucketSizes = make(map[string]int64)
+	}
+	stat.bucketSizes[bucket] += size
+
+	switch {
+	case entry.Meta.Ds == DataStructureBPTree && entry.Meta.Flag == DataDeleteFlag:
+		stat.tombstoneSize += size
+	case entry.Meta.Ds != DataStructureBPTree || entry.Meta.Flag != DataSetFlag:
+		stat.otherSize += size
+	}
+}
+
+// addDeadStat counts the entry of the key the index points to as superseded in the statistics of its data file.
+func (db *DB) addDeadStat(idx *BPTree, key []byte) {
+	r, err := idx.Find(key)
+	if err != nil || r == nil {
+		return
+	}
+	if stat, ok := db.fileStats[r.H.FileID]; ok {
+		stat.deadSize += DataEntryHeaderSize + r.H.Meta.PayloadSize()
+	}
+}
+
+// loadFileStat returns the statistics of the data file at given fID, which are read from the file
+// when it was skipped by the recovery as covered by the checkpoints.
+func (db *DB) loadFileStat(fID int64) (*dataFileStat, error) {
+	if stat, ok := db.fileStats[fID]; ok {
+		return stat, nil
+	}
+	stat, err := db.readFileStat(fID)
+	if err != nil {
+		return nil, err
+	}
+	db.fileStats[fID] = stat
+	return stat, nil
+}
+
+// isFileExpired returns if every entry in the data file at given fID is expired.
+func (db *DB) isFileExpired(fID int64, now uint64) bool {
+	stat, ok := db.fileStats[fID]
+	return ok && stat.isExpired(now)
+}
+
+// canDropExpiredFile returns if the data file at given fID can be removed without being rewritten: every
+// entry in it is expired, and no older data file holds an unexpired entry of one of its buckets, which the
+// recovery would apply again once the expired versions of the keys are gone.
+func (db *DB) canDropExpiredFile(fID int64, now uint64) (bool, error) {
+	if !db.isFileExpired(fID, now) {
+		return false, nil
+	}
+	stat := db.fileStats[fID]
+
+	_, fIDs := db.getMaxFileIDAndFileIDs()
+	for _, id := range fIDs {
+		if int64(id) >= fID {
+			continue
+		}
+		older, err := db.loadFileStat(int64(id))
+		if err != nil {
+			return false, err
+		}
+		if older.isExpired(now) {
+			continue
+		}
+		for bucket := range stat.bucketSizes {
+			if _, ok := older.bucketSizes[bucket]; ok {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+// loadCoveredFileStats reads the statistics of the data files the recovery skipped as covered by the checkpoints.
+func (db *DB) loadCoveredFileStats(dataFileIds []int) error {
+	if db.checkpoints == nil {
+		return nil
+	}
+	for _, id := range dataFileIds {
+		if int64(id) >= db.checkpoints.start.fileID {
+			break
+		}
+		if _, err := db.loadFileStat(int64(id)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sortMergeFileIDs orders the data files to merge by the share of their bytes held by the
+// BucketHintHighChurn buckets, the files without such bytes keep their file id order.
+func (db *DB) sortMergeFileIDs(fIDs []int) {
+	if len(db.opt.BucketHints) == 0 {
+		return
+	}
+
+	ratio := func(fID int) float64 {
+		if stat, ok := db.fileStats[int64(fID)]; ok {
+			return stat.highChurnRatio()
+		}
+		return 0
+	}
+	sort.SliceStable(fIDs, func(i, j int) bool {
+		return ratio(fIDs[i]) > ratio(fIDs[j])
+	})
+}
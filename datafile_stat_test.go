@@ -0,0 +1,191 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xujiajun/utils/filesystem"
+)
+
+func TestDataFileStat_IsExpired(t *testing.T) {
+	stat := &dataFileStat{}
+	stat.add(&MetaData{Timestamp: 100, TTL: 10})
+	stat.add(&MetaData{Timestamp: 105, TTL: 10})
+
+	assert.False(t, stat.isExpired(114))
+	assert.True(t, stat.isExpired(115))
+
+	stat.add(&MetaData{Timestamp: 100, TTL: Persistent})
+	assert.False(t, stat.isExpired(1000))
+}
+
+func TestDB_Merge_RemoveExpiredFile(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestmergeexpiredfile", true)
+	opt.SegmentSize = 120
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	bucket := "bucket_expired_file"
+	timestamp := uint64(time.Now().Add(-time.Hour).Unix())
+	for i := 0; i < 2; i++ {
+		err = db.Update(func(tx *Tx) error {
+			return tx.PutWithTimestamp(bucket, []byte(fmt.Sprintf("key_%d", i)), []byte("value"), 60, timestamp)
+		})
+		require.NoError(t, err)
+	}
+	err = db.Update(func(tx *Tx) error {
+		return tx.Put(bucket, []byte("persistent"), []byte("value"), Persistent)
+	})
+	require.NoError(t, err)
+
+	now := uint64(time.Now().Unix())
+	assert.True(t, db.isFileExpired(0, now))
+	assert.False(t, db.isFileExpired(db.ActiveFile.fileID, now))
+
+	require.NoError(t, db.Merge())
+	assert.False(t, filesystem.PathIsExist(db.getDataPath(0)))
+
+	err = db.View(func(tx *Tx) error {
+		_, err := tx.Get(bucket, []byte("key_0"))
+		assert.Error(t, err)
+		e, err := tx.Get(bucket, []byte("persistent"))
+		if assert.NoError(t, err) {
+			assert.Equal(t, []byte("value"), e.Value)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+}
+
+func TestDB_Merge_BucketHints(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestmergebuckethints", true)
+	opt.SegmentSize = 120
+	db, err = Open(opt, WithBucketHint("bucket_hot", BucketHintHighChurn))
+	require.NoError(t, err)
+
+	for _, bucket := range []string{"bucket_cold", "bucket_cold", "bucket_hot", "bucket_cold"} {
+		err = db.Update(func(tx *Tx) error {
+			return tx.Put(bucket, []byte("key"), []byte("value"), Persistent)
+		})
+		require.NoError(t, err)
+	}
+
+	_, fIDs := db.getMaxFileIDAndFileIDs()
+	require.True(t, len(fIDs) >= 4)
+	db.sortMergeFileIDs(fIDs)
+	assert.Equal(t, 2, fIDs[0])
+	assert.Equal(t, 0, fIDs[1])
+	assert.Equal(t, 1, fIDs[2])
+
+	require.NoError(t, db.Merge())
+	err = db.View(func(tx *Tx) error {
+		for _, bucket := range []string{"bucket_cold", "bucket_hot"} {
+			e, err := tx.Get(bucket, []byte("key"))
+			if assert.NoError(t, err) {
+				assert.Equal(t, []byte("value"), e.Value)
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+}
+
+func TestDB_Merge_KeepExpiredFileOverOlderVersions(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestmergeexpiredolder", true)
+	opt.SegmentSize = 150
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	bucket := "bucket_expired_older"
+	err = db.Update(func(tx *Tx) error {
+		return tx.Put(bucket, []byte("key"), []byte("old"), Persistent)
+	})
+	require.NoError(t, err)
+	err = db.Update(func(tx *Tx) error {
+		return tx.Put(bucket, []byte("other"), []byte("value"), Persistent)
+	})
+	require.NoError(t, err)
+
+	timestamp := uint64(time.Now().Add(-time.Hour).Unix())
+	for i := 0; i < 2; i++ {
+		err = db.Update(func(tx *Tx) error {
+			return tx.PutWithTimestamp(bucket, []byte("key"), []byte("value"), 60, timestamp)
+		})
+		require.NoError(t, err)
+	}
+	err = db.Update(func(tx *Tx) error {
+		return tx.Put("bucket_other", []byte("key"), []byte("value"), Persistent)
+	})
+	require.NoError(t, err)
+
+	now := uint64(time.Now().Unix())
+	require.True(t, db.isFileExpired(1, now))
+	drop, err := db.canDropExpiredFile(1, now)
+	require.NoError(t, err)
+	assert.False(t, drop)
+
+	require.NoError(t, db.Merge())
+	assert.False(t, filesystem.PathIsExist(db.getDataPath(1)))
+	require.NoError(t, db.Close())
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	err = db.View(func(tx *Tx) error {
+		_, err := tx.Get(bucket, []byte("key"))
+		assert.Error(t, err)
+		e, err := tx.Get(bucket, []byte("other"))
+		if assert.NoError(t, err) {
+			assert.Equal(t, []byte("value"), e.Value)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+}
+
+func TestDB_Checkpoint_LoadsCoveredFileStats(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestcheckpointfilestats", true)
+	opt.SegmentSize = 120
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	bucket := "bucket_checkpoint_stats"
+	timestamp := uint64(time.Now().Add(-time.Hour).Unix())
+	for i := 0; i < 2; i++ {
+		err = db.Update(func(tx *Tx) error {
+			return tx.PutWithTimestamp(bucket, []byte(fmt.Sprintf("key_%d", i)), []byte("value"), 60, timestamp)
+		})
+		require.NoError(t, err)
+	}
+	err = db.Update(func(tx *Tx) error {
+		return tx.Put(bucket, []byte("persistent"), []byte("value"), Persistent)
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Checkpoint())
+	require.NoError(t, db.Close())
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	require.NotNil(t, db.checkpoints)
+	assert.True(t, db.isFileExpired(0, uint64(time.Now().Unix())))
+	require.NoError(t, db.Close())
+}
@@ -15,6 +15,8 @@
 package nutsdb
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -22,10 +24,15 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"runtime/debug"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/bwmarrin/snowflake"
+	"github.com/nutsdb/nutsdb/ds/hnsw"
 	"github.com/nutsdb/nutsdb/ds/list"
 	"github.com/nutsdb/nutsdb/ds/set"
 	"github.com/nutsdb/nutsdb/ds/zset"
@@ -51,6 +58,12 @@ var (
 
 	// ErrNotSupportHintBPTSparseIdxMode is returned not support mode `HintBPTSparseIdxMode`
 	ErrNotSupportHintBPTSparseIdxMode = errors.New("not support mode `HintBPTSparseIdxMode`")
+
+	// ErrCompactionDecision is returned when the CompactionFilter returns an unknown decision.
+	ErrCompactionDecision = errors.New("unknown compaction decision")
+
+	// ErrEntryRewrite is returned when the EntryRewriter changes the bucket or the key of an entry.
+	ErrEntryRewrite = errors.New("entry rewriter must keep the bucket and the key")
 )
 
 const (
@@ -113,6 +126,45 @@ const (
 
 	// DataListBucketDeleteFlag represents that set ttl for the list
 	DataExpireListFlag
+
+	// DataLCompactFlag represents the snapshot of the whole content of a list
+	DataLCompactFlag
+
+	// DataSetSnapshotFlag represents the snapshot of the whole members of a set
+	DataSetSnapshotFlag
+
+	// DataZSetSnapshotFlag represents the snapshot of the whole members of a sorted set bucket
+	DataZSetSnapshotFlag
+
+	// DataSAddBatchFlag represents the members added to a set by a single SAddBatch
+	DataSAddBatchFlag
+
+	// DataZAddBatchFlag represents the members added to a sorted set bucket by a single ZAddBatch
+	DataZAddBatchFlag
+
+	// DataLPopRefFlag represents the data LPop flag, its value is the size of the list it pops from
+	DataLPopRefFlag
+
+	// DataRPopRefFlag represents the data RPop flag, its value is the size of the list it pops from
+	DataRPopRefFlag
+
+	// DataLInsertBeforeFlag represents the data LInsertBefore flag, its value holds the pivot and the inserted value
+	DataLInsertBeforeFlag
+
+	// DataLInsertAfterFlag represents the data LInsertAfter flag, its value holds the pivot and the inserted value
+	DataLInsertAfterFlag
+
+	// DataLPopNFlag represents the data LPopN flag, its value is the size of the list it pops from and the count
+	DataLPopNFlag
+
+	// DataRPopNFlag represents the data RPopN flag, its value is the size of the list it pops from and the count
+	DataRPopNFlag
+
+	// DataZRemRangeByScoreFlag represents the data ZRemRangeByScore flag, its key and value are the bounds of the scores
+	DataZRemRangeByScoreFlag
+
+	// DataZRemRangeByLexFlag represents the data ZRemRangeByLex flag, its key and value are the bounds of the keys
+	DataZRemRangeByLexFlag
 )
 
 const (
@@ -165,8 +217,48 @@ type (
 		mu                      sync.RWMutex
 		KeyCount                int // total key number ,include expired, deleted, repeated.
 		closed                  bool
-		isMerging               bool
 		fm                      *fileManager
+		fileStats               map[int64]*dataFileStat
+		checkpoints             *checkpoints
+		checkpointMu            sync.Mutex // held by Checkpoint
+		checkpointGen           uint64     // bumped by removeCheckpoints, see Checkpoint
+		checkpointRefs          checkpointRefs
+		snapshotStop            chan struct{}
+		reaperStop              chan struct{}
+		sweeperStop             chan struct{}
+		tombstoneStop           chan struct{}
+		tombstoneMu             sync.Mutex
+		autoMergeStop           chan struct{}
+		tieringStop             chan struct{}
+		tieringMu               sync.Mutex // held by FlushTiering
+		vlog                    *valueLog
+		keys                    *keyring
+		readRepairs             *readRepairQueue
+		skippedEntries          []SkippedEntry  // the unknown entries left out by Open, see UnknownEntrySkip
+		quarantined             []EntryPosition // the corrupt entries left out by Open, see Options.QuarantineCorruptEntries
+		mergeMu                 sync.Mutex      // held by Merge and by the passes of the auto merge
+		syncer                  *commitSyncer
+		hotKeys                 *hotKeys
+		readPath                []ReadStage
+		readCache               *readCache
+		readHits                *readPathHits
+		queuedWriters           int32 // the writable transactions waiting for the write lock
+		openBuckets             map[string]struct{}
+		listWaiters             *listWaiters
+		loads                   *loadCalls
+		watchers                *watchers
+		sequences               map[string]*sequence
+		userBytesWritten        int64 // bytes appended by the user transactions since open
+		mergeBytesWritten       int64 // bytes appended by merge since open
+		txIDNode                *snowflake.Node
+		txIDNodeErr             error
+		txIDNodeOnce            sync.Once
+		locks                   *lockGraph
+		keyArena                *keyArena
+		bucketNames             *bucketNames
+		bucketIDs               *bucketIDTable
+		bucketLastWrite         map[string]uint64      // unix time of the last entry written to each bucket
+		vectors                 map[string]*hnsw.Index // the vector indexes by bucket, see VAdd
 	}
 
 	// Entries represents entries
@@ -193,6 +285,46 @@ func open(opt Options) (*DB, error) {
 		ActiveCommittedTxIdsIdx: NewTree(),
 		Index:                   NewIndex(),
 		fm:                      newFileManager(opt.RWMode, opt.MaxFdNumsInCache, opt.CleanFdsCacheThreshold),
+		fileStats:               make(map[int64]*dataFileStat),
+		hotKeys:                 newHotKeys(opt.HotKeysCapacity),
+		readHits:                new(readPathHits),
+		locks:                   newLockGraph(),
+		keyArena:                newKeyArena(opt.IndexLayout),
+		bucketNames:             newBucketNames(),
+		bucketIDs:               newBucketIDTable(opt.Dir),
+		bucketLastWrite:         make(map[string]uint64),
+		listWaiters:             newListWaiters(),
+		loads:                   newLoadCalls(),
+		watchers:                newWatchers(opt.WatchBufferSize),
+		sequences:               make(map[string]*sequence),
+		vlog:                    newValueLog(opt.Dir, opt.SegmentSize),
+		readRepairs:             newReadRepairQueue(),
+	}
+	db.fm.bucketIDs = db.bucketIDs
+	db.Index.clock = db.listClock
+	db.fm.latency = opt.SimulatedLatency
+	db.syncer = newCommitSyncer(db)
+
+	if err := db.setOpenBuckets(opt.OpenBuckets); err != nil {
+		return nil, err
+	}
+
+	keys, err := newKeyring(opt.Dir, opt)
+	if err != nil {
+		return nil, err
+	}
+	db.keys = keys
+	db.fm.keys = keys
+
+	readPath, err := checkReadPath(opt)
+	if err != nil {
+		return nil, err
+	}
+	db.readPath = readPath
+	db.readCache = newReadCache(readPath, opt.ReadCacheCapacity)
+
+	if opt.Tiering != nil && opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return nil, ErrNotSupportHintBPTSparseIdxMode
 	}
 
 	if ok := filesystem.PathIsExist(db.opt.Dir); !ok {
@@ -205,6 +337,10 @@ func open(opt Options) (*DB, error) {
 		return nil, err
 	}
 
+	if err := db.bucketIDs.load(); err != nil {
+		return nil, err
+	}
+
 	if opt.EntryIdxMode == HintBPTSparseIdxMode {
 		bptRootIdxDir := db.opt.Dir + "/" + bptDir + "/root"
 		if ok := filesystem.PathIsExist(bptRootIdxDir); !ok {
@@ -229,7 +365,41 @@ func open(opt Options) (*DB, error) {
 	}
 
 	if err := db.buildIndexes(); err != nil {
-		return nil, fmt.Errorf("db.buildIndexes error: %s", err)
+		return nil, fmt.Errorf("db.buildIndexes error: %w", err)
+	}
+
+	if err := db.buildVectorIndexes(); err != nil {
+		return nil, err
+	}
+
+	if opt.IndexSnapshotInterval > 0 && opt.EntryIdxMode != HintBPTSparseIdxMode {
+		db.snapshotStop = make(chan struct{})
+		go db.snapshotIndexes(opt.IndexSnapshotInterval, db.snapshotStop)
+	}
+
+	if opt.expirationReapInterval() > 0 && opt.EntryIdxMode != HintBPTSparseIdxMode {
+		db.reaperStop = make(chan struct{})
+		go db.reapExpired(opt.expirationReapInterval(), db.reaperStop)
+	}
+
+	if opt.ExpiredDeleteType == ExpiredDeleteActive && opt.EntryIdxMode != HintBPTSparseIdxMode {
+		db.sweeperStop = make(chan struct{})
+		go db.sweepExpired(opt.expiredSweepInterval(), db.sweeperStop)
+	}
+
+	if opt.TombstoneCompactionInterval > 0 && opt.EntryIdxMode != HintBPTSparseIdxMode {
+		db.tombstoneStop = make(chan struct{})
+		go db.compactTombstones(opt.TombstoneCompactionInterval, db.tombstoneStop)
+	}
+
+	if opt.AutoMergeEnable && opt.EntryIdxMode != HintBPTSparseIdxMode && len(opt.OpenBuckets) == 0 {
+		db.autoMergeStop = make(chan struct{})
+		go db.autoMerge(opt.autoMergeInterval(), db.autoMergeStop)
+	}
+
+	if opt.Tiering != nil && opt.TieringMode == TieringWriteBack {
+		db.tieringStop = make(chan struct{})
+		go db.flushTiering(opt.tieringFlushInterval(), db.tieringStop)
 	}
 
 	return db, nil
@@ -282,130 +452,246 @@ func (db *DB) checkEntryIdxMode() error {
 
 // Update executes a function within a managed read/write transaction.
 func (db *DB) Update(fn func(tx *Tx) error) error {
+	return db.UpdateWithContext(context.Background(), fn)
+}
+
+// UpdateWithContext executes a function within a managed read/write transaction
+// carrying the ctx, the transaction is rolled back if the ctx is done before commit.
+func (db *DB) UpdateWithContext(ctx context.Context, fn func(tx *Tx) error) error {
 	if fn == nil {
 		return ErrFn
 	}
 
-	return db.managed(true, fn)
+	return db.managed(ctx, true, fn)
 }
 
 // View executes a function within a managed read-only transaction.
 func (db *DB) View(fn func(tx *Tx) error) error {
+	return db.ViewWithContext(context.Background(), fn)
+}
+
+// ViewWithContext executes a function within a managed read-only transaction carrying the ctx.
+func (db *DB) ViewWithContext(ctx context.Context, fn func(tx *Tx) error) error {
 	if fn == nil {
 		return ErrFn
 	}
 
-	return db.managed(false, fn)
+	return db.managed(ctx, false, fn)
+}
+
+// MergeProgress reports the progress of a merge to Options.OnMergeProgress.
+type MergeProgress struct {
+	// FileID is the id of the data file just merged.
+	FileID int64
+
+	// Merged is the number of data files merged so far, out of Total.
+	Merged int
+	Total  int
 }
 
 // Merge removes dirty data and reduce data redundancy,following these steps:
 //
-// 1. Filter delete or expired entry.
+// 1. Remove the sealed files whose entries are all expired without reading them.
+//
+// 2. Filter delete or expired entry.
 //
-// 2. Write entry to activeFile if the key not exist，if exist miss this write operation.
+// 3. Write entry to activeFile if the key not exist，if exist miss this write operation.
 //
-// 3. Filter the entry which is committed.
+// 4. Filter the entry which is committed.
 //
-// 4. At last remove the merged files.
+// 5. At last remove the merged files.
 //
-// Caveat: Merge is Called means starting multiple write transactions, and it
-// will affect the other write request. so execute it at the appropriate time.
+// Every data file is read, rewritten and removed in its own write transaction, so the reads and
+// the writes only wait for the file being merged and go on between the files.
+// Options.OnMergeProgress is called once each file is merged.
 func (db *DB) Merge() error {
-	var (
-		off                 int64
-		pendingMergeFIds    []int
-		pendingMergeEntries []*Entry
-	)
+	return db.MergeWithContext(context.Background())
+}
 
+// MergeWithContext is like Merge but passes the ctx to the CompactionFilter and the
+// EntryRewriter, and stops before merging the next file once the ctx is done.
+func (db *DB) MergeWithContext(ctx context.Context) error {
 	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
 		return ErrNotSupportHintBPTSparseIdxMode
 	}
+	if db.openBuckets != nil {
+		return ErrPartiallyOpen
+	}
 
-	db.isMerging = true
-
-	_, pendingMergeFIds = db.getMaxFileIDAndFileIDs()
+	db.mergeMu.Lock()
+	defer db.mergeMu.Unlock()
 
+	var pendingMergeFIds []int
+	if err := db.View(func(tx *Tx) error {
+		_, pendingMergeFIds = db.getMaxFileIDAndFileIDs()
+		db.sortMergeFileIDs(pendingMergeFIds)
+		return nil
+	}); err != nil {
+		return err
+	}
 	if len(pendingMergeFIds) < 2 {
-		db.isMerging = false
 		return errors.New("the number of files waiting to be merged is at least 2")
 	}
 
+	compacted := make(map[string]struct{})
+	progress := MergeProgress{Total: len(pendingMergeFIds)}
 	for _, pendingMergeFId := range pendingMergeFIds {
-		off = 0
-		path := db.getDataPath(int64(pendingMergeFId))
-		fr, err := newFileRecovery(path, db.opt.BufferSizeOfRecovery)
-		if err != nil {
-			db.isMerging = false
+		if err := ctx.Err(); err != nil {
 			return err
 		}
 
-		pendingMergeEntries = []*Entry{}
+		fID := int64(pendingMergeFId)
+		if err := db.mergeDataFile(ctx, fID, compacted); err != nil {
+			return err
+		}
 
-		for {
-			if entry, err := fr.readEntry(); err == nil {
-				if entry == nil {
-					break
-				}
+		progress.FileID = fID
+		progress.Merged++
+		if db.opt.OnMergeProgress != nil {
+			db.opt.OnMergeProgress(ctx, progress)
+		}
+	}
 
-				var skipEntry bool
+	return nil
+}
 
-				if entry.isFilter() {
-					skipEntry = true
-				}
+// mergeDataFile rewrites in a single tx the entries Merge keeps from the data file at given fID,
+// then removes the file. The file already removed, e.g. by CompactTombstones, is skipped.
+func (db *DB) mergeDataFile(ctx context.Context, fID int64, compacted map[string]struct{}) error {
+	db.tombstoneMu.Lock()
+	defer db.tombstoneMu.Unlock()
 
-				// check if we have a new entry with same key and bucket
-				if r, _ := db.getRecordFromKey(entry.Bucket, entry.Key); r != nil && !skipEntry {
-					if r.H.FileID > int64(pendingMergeFId) {
-						skipEntry = true
-					} else if r.H.FileID == int64(pendingMergeFId) && r.H.DataPos > uint64(off) {
-						skipEntry = true
-					}
-				}
+	tx, err := db.BeginWithContext(ctx, true)
+	if err != nil {
+		return err
+	}
 
-				if skipEntry {
-					off += entry.Size()
-					if off >= db.opt.SegmentSize {
-						break
-					}
-					continue
-				}
+	if _, err := os.Stat(db.getDataPath(fID)); os.IsNotExist(err) {
+		return tx.Rollback()
+	}
+	if err := tx.mergeFile(ctx, fID, compacted); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
 
-				pendingMergeEntries = db.getPendingMergeEntries(entry, pendingMergeEntries)
+	// the statistics of the data files are shared with the commits.
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.removeDataFile(fID)
+}
 
-				off += entry.Size()
-				if off >= db.opt.SegmentSize {
-					break
-				}
+// mergeFile writes to the tx the entries Merge rewrites from the data file at given fID,
+// nothing when the file is sealed and can be dropped as expired.
+func (tx *Tx) mergeFile(ctx context.Context, fID int64, compacted map[string]struct{}) error {
+	db := tx.db
 
-			} else {
-				if err == io.EOF {
-					break
-				}
-				if err == ErrIndexOutOfBound {
-					break
-				}
-				if err == io.ErrUnexpectedEOF {
-					break
-				}
-				return fmt.Errorf("when merge operation build hintIndex readAt err: %s", err)
+	if fID != db.ActiveFile.fileID {
+		drop, err := db.canDropExpiredFile(fID, db.timestamp())
+		if err != nil {
+			return err
+		}
+		if drop {
+			return nil
+		}
+	}
+
+	entries, err := db.readMergeEntries(fID, compacted)
+	if err != nil {
+		return err
+	}
+	// the active file is left for a new one even when nothing is rewritten, as it is removed next.
+	if len(entries) == 0 && fID != db.ActiveFile.fileID {
+		return nil
+	}
+	return tx.reWriteEntries(ctx, entries)
+}
+
+// readMergeEntries reads the data file at given fID and returns the entries Merge rewrites from it,
+// the lists, sets and sorted sets already in compacted are not snapshotted again.
+func (db *DB) readMergeEntries(fID int64, compacted map[string]struct{}) ([]*Entry, error) {
+	fr, err := newFileRecovery(db.getDataPath(fID), db.opt.BufferSizeOfRecovery)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		off                 int64
+		pendingMergeEntries []*Entry
+	)
+	for {
+		entry, err := fr.readEntry()
+		if err != nil {
+			if err == io.EOF || err == ErrIndexOutOfBound || err == io.ErrUnexpectedEOF {
+				break
 			}
+			_ = fr.release()
+			return nil, fmt.Errorf("when merge operation build hintIndex readAt err: %s", err)
+		}
+		if entry == nil {
+			break
 		}
 
-		if err := db.reWriteData(pendingMergeEntries); err != nil {
+		if err := decodeEntry(db.keys, db.bucketIDs, entry); err != nil {
 			_ = fr.release()
-			return err
+			return nil, err
+		}
+		if !isKnownEntry(entry.Meta) {
+			_ = fr.release()
+			return nil, unknownEntryError(fID, off, entry.Meta)
 		}
 
-		err = fr.release()
-		if err != nil {
-			return err
+		skipEntry := entry.isFilter(db.timestamp()) ||
+			(entry.Meta.Ds == DataStructureBPTree && db.isExpired(string(entry.Bucket), entry.Meta))
+
+		// check if we have a new entry with same key and bucket
+		if r, _ := db.getRecordFromKey(entry.Bucket, entry.Key); r != nil && !skipEntry {
+			if r.H.FileID > fID {
+				skipEntry = true
+			} else if r.H.FileID == fID && r.H.DataPos > uint64(off) {
+				skipEntry = true
+			}
 		}
-		if err := os.Remove(path); err != nil {
-			db.isMerging = false
-			return fmt.Errorf("when merge err: %s", err)
+
+		if !skipEntry {
+			pendingMergeEntries = db.getPendingMergeEntries(entry, pendingMergeEntries, compacted)
+		}
+
+		off += entry.Size()
+		if off >= db.opt.SegmentSize {
+			break
 		}
 	}
 
+	return pendingMergeEntries, fr.release()
+}
+
+// stopContext returns a context done once stop is closed, carried by the work of the background goroutines
+// of the db to the hooks it calls.
+func stopContext(stop <-chan struct{}) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// removeDataFile removes the data file at given fID, once rewritten, and forgets its statistics.
+// The checkpoints pointing to the file are invalidated first. It is called with the write lock held.
+func (db *DB) removeDataFile(fID int64) error {
+	if err := db.invalidateCheckpoints(fID); err != nil {
+		return err
+	}
+	if err := os.Remove(db.getDataPath(fID)); err != nil {
+		return err
+	}
+	delete(db.fileStats, fID)
 	return nil
 }
 
@@ -433,14 +719,49 @@ func (db *DB) Close() error {
 	}
 
 	db.closed = true
+	db.listWaiters.wakeAll()
+	db.watchers.closeAll()
+
+	if db.snapshotStop != nil {
+		close(db.snapshotStop)
+	}
+
+	if db.reaperStop != nil {
+		close(db.reaperStop)
+	}
+
+	if db.sweeperStop != nil {
+		close(db.sweeperStop)
+	}
+
+	if db.tombstoneStop != nil {
+		close(db.tombstoneStop)
+	}
 
-	err := db.ActiveFile.rwManager.Release()
+	if db.autoMergeStop != nil {
+		close(db.autoMergeStop)
+	}
+
+	if db.tieringStop != nil {
+		close(db.tieringStop)
+	}
+
+	db.writeReadRepairs(context.Background())
+
+	db.syncer.fileMu.Lock()
+	err := db.syncer.syncBeforeRelease()
+	db.syncer.close(err)
+	if err == nil {
+		err = db.ActiveFile.rwManager.Release()
+	}
+	if err == nil {
+		db.ActiveFile = nil
+	}
+	db.syncer.fileMu.Unlock()
 	if err != nil {
 		return err
 	}
 
-	db.ActiveFile = nil
-
 	db.BPTreeIdx = nil
 
 	err = db.fm.close()
@@ -449,6 +770,10 @@ func (db *DB) Close() error {
 		return err
 	}
 
+	if err = db.vlog.close(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -498,6 +823,10 @@ func (db *DB) getMaxFileIDAndFileIDs() (maxFileID int64, dataFileIds []int) {
 
 // getActiveFileWriteOff returns the write-offset of activeFile.
 func (db *DB) getActiveFileWriteOff() (off int64, err error) {
+	if db.opt.QuarantineCorruptEntries {
+		return db.getQuarantinedActiveFileWriteOff()
+	}
+
 	off = 0
 	for {
 		if item, err := db.ActiveFile.ReadAt(int(off)); err == nil {
@@ -516,7 +845,6 @@ func (db *DB) getActiveFileWriteOff() (off int64, err error) {
 			if err == ErrIndexOutOfBound {
 				break
 			}
-
 			return -1, fmt.Errorf("when build activeDataIndex readAt err: %s", err)
 		}
 	}
@@ -524,13 +852,63 @@ func (db *DB) getActiveFileWriteOff() (off int64, err error) {
 	return
 }
 
-func (db *DB) parseDataFiles(dataFileIds []int) (unconfirmedRecords []*Record, committedTxIds map[uint64]struct{}, err error) {
-	var (
-		off int64
-		e   *Entry
-	)
+// getQuarantinedActiveFileWriteOff returns the write-offset of activeFile, skipping the damaged entries
+// the way parseDataFiles does under the Options.QuarantineCorruptEntries.
+func (db *DB) getQuarantinedActiveFileWriteOff() (int64, error) {
+	path := db.getDataPath(db.ActiveFile.fileID)
+	f, err := newFileRecovery(path, db.opt.BufferSizeOfRecovery)
+	if err != nil {
+		return -1, err
+	}
+	defer func() {
+		_ = f.release()
+	}()
 
-	committedTxIds = make(map[uint64]struct{})
+	var off int64
+	for {
+		entry, err := f.readEntry()
+		if err == nil {
+			if entry == nil {
+				break
+			}
+			off += entry.Size()
+			continue
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != ErrCrc && err != io.ErrUnexpectedEOF {
+			return -1, fmt.Errorf("when build activeDataIndex readAt err: %s", err)
+		}
+
+		next, found, resyncErr := nextEntryOffset(path, off)
+		if resyncErr != nil {
+			return -1, resyncErr
+		}
+		if err == io.ErrUnexpectedEOF && !found {
+			break
+		}
+		if err := f.seek(next); err != nil {
+			return -1, err
+		}
+		off = next
+	}
+
+	db.ActiveFile.ActualSize = off
+	return off, nil
+}
+
+// parseDataFiles reads the entries of the data files and applies them to the indexes
+// as soon as their transaction is known to be committed.
+func (db *DB) parseDataFiles(dataFileIds []int) (err error) {
+	var off int64
+
+	rs := newRecoveryStream(db)
+	defer func() {
+		if closeErr := rs.close(); err == nil {
+			err = closeErr
+		}
+	}()
 
 	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
 		dataFileIds = dataFileIds[len(dataFileIds)-1:]
@@ -539,10 +917,13 @@ func (db *DB) parseDataFiles(dataFileIds []int) (unconfirmedRecords []*Record, c
 	for _, dataID := range dataFileIds {
 		off = 0
 		fID := int64(dataID)
+		if db.checkpoints != nil && fID < db.checkpoints.start.fileID {
+			continue
+		}
 		path := db.getDataPath(fID)
 		f, err := newFileRecovery(path, db.opt.BufferSizeOfRecovery)
 		if err != nil {
-			return nil, nil, err
+			return err
 		}
 
 		for {
@@ -551,32 +932,44 @@ func (db *DB) parseDataFiles(dataFileIds []int) (unconfirmedRecords []*Record, c
 					break
 				}
 
-				e = nil
-				if db.opt.EntryIdxMode == HintKeyValAndRAMIdxMode {
-					e = &Entry{
-						Key:    entry.Key,
-						Bucket: entry.Bucket,
-						Value:  entry.Value,
-						Meta:   entry.Meta,
-					}
+				if err := decodeEntry(db.keys, db.bucketIDs, entry); err != nil {
+					_ = f.release()
+					return err
+				}
+
+				db.addFileStat(fID, entry)
+				db.touchBucket(entry)
+
+				if db.checkpoints.covers(entry, fID, off) {
+					off += entry.Size()
+					continue
 				}
 
 				if entry.Meta.Status == Committed {
-					committedTxIds[entry.Meta.TxID] = struct{}{}
 					db.ActiveCommittedTxIdsIdx.Insert([]byte(strconv2.Int64ToStr(int64(entry.Meta.TxID))), nil,
 						&Hint{Meta: &MetaData{Flag: DataSetFlag}}, CountFlagEnabled)
 				}
 
-				unconfirmedRecords = append(unconfirmedRecords, &Record{
-					H: &Hint{
-						Key:     entry.Key,
-						FileID:  fID,
-						Meta:    entry.Meta,
-						DataPos: uint64(off),
-					},
-					E:      e,
-					Bucket: string(entry.Bucket),
-				})
+				// the other entries of the transaction of an unknown entry skipped are still applied.
+				if !isKnownEntry(entry.Meta) {
+					if err := db.skipUnknownEntry(entry, fID, off); err != nil {
+						_ = f.release()
+						return err
+					}
+					if entry.Meta.Status == Committed {
+						if err := rs.commit(entry.Meta.TxID); err != nil {
+							_ = f.release()
+							return err
+						}
+					}
+					off += entry.Size()
+					continue
+				}
+
+				if err := rs.add(db.newRecoveryRecord(entry, fID, off)); err != nil {
+					_ = f.release()
+					return err
+				}
 
 				if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
 					db.BPTreeKeyEntryPosMap[string(getNewKey(string(entry.Bucket), entry.Key))] = off
@@ -584,6 +977,24 @@ func (db *DB) parseDataFiles(dataFileIds []int) (unconfirmedRecords []*Record, c
 
 				off += entry.Size()
 
+			} else if db.opt.QuarantineCorruptEntries && (err == ErrCrc || err == io.ErrUnexpectedEOF) {
+				// a damaged header gives no size to skip the entry by, the next entry passing its crc check is
+				// looked for. Without one a short read is taken as the torn tail of the file.
+				next, found, resyncErr := nextEntryOffset(path, off)
+				if resyncErr != nil {
+					_ = f.release()
+					return resyncErr
+				}
+				if err == io.ErrUnexpectedEOF && !found {
+					_ = f.release()
+					break
+				}
+				db.quarantined = append(db.quarantined, EntryPosition{FileID: fID, Offset: off})
+				if err := f.seek(next); err != nil {
+					_ = f.release()
+					return err
+				}
+				off = next
 			} else {
 				// whatever which logic branch it will choose, we will release the fd.
 				_ = f.release()
@@ -600,18 +1011,72 @@ func (db *DB) parseDataFiles(dataFileIds []int) (unconfirmedRecords []*Record, c
 					break
 				}
 				if err != nil {
-					return nil, nil, err
+					return err
 				}
-				return nil, nil, fmt.Errorf("when build hintIndex readAt err: %s", err)
+				return fmt.Errorf("when build hintIndex readAt err: %s", err)
 			}
 		}
+	}
 
-		if err != nil {
-			return nil, nil, err
+	return nil
+}
+
+// newRecoveryRecord returns the record of the entry read at the given position when opening the DB.
+func (db *DB) newRecoveryRecord(entry *Entry, fID int64, off int64) *Record {
+	var e *Entry
+	if db.opt.EntryIdxMode == HintKeyValAndRAMIdxMode {
+		e = &Entry{
+			Key:    entry.Key,
+			Bucket: entry.Bucket,
+			Value:  entry.Value,
+			Meta:   entry.Meta,
 		}
 	}
 
-	return
+	return &Record{
+		H: &Hint{
+			Key:     entry.Key,
+			FileID:  fID,
+			Meta:    entry.Meta,
+			DataPos: uint64(off),
+		},
+		E:      e,
+		Bucket: db.bucketNames.name(entry.Bucket),
+	}
+}
+
+// applyRecord applies the record of a committed transaction to the indexes.
+func (db *DB) applyRecord(r *Record) (err error) {
+	bucket := r.Bucket
+	if !db.isBucketOpen(bucket) {
+		return nil
+	}
+
+	if r.H.Meta.Ds == DataStructureBPTree {
+		r.H.Meta.Status = Committed
+
+		if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+			if err = db.buildActiveBPTreeIdx(r); err != nil {
+				return err
+			}
+		} else {
+			if err = db.buildBPTreeIdx(bucket, r); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err = db.buildOtherIdxes(bucket, r); err != nil {
+		return err
+	}
+
+	if r.H.Meta.Ds == DataStructureNone {
+		db.buildNotDSIdxes(bucket, r)
+	}
+
+	db.KeyCount++
+
+	return nil
 }
 
 func (db *DB) buildBPTreeRootIdxes(dataFileIds []int) error {
@@ -660,7 +1125,9 @@ func (db *DB) buildBPTreeIdx(bucket string, r *Record) error {
 		db.BPTreeIdx[bucket] = NewTree()
 	}
 
-	if err := db.BPTreeIdx[bucket].Insert(r.H.Key, r.E, r.H, CountFlagEnabled); err != nil {
+	db.addDeadStat(db.BPTreeIdx[bucket], r.H.Key)
+	h := db.newHint(r.H.Key, r.H.FileID, r.H.Meta, r.H.DataPos)
+	if err := db.BPTreeIdx[bucket].Insert(h.Key, r.E, h, CountFlagEnabled); err != nil {
 		return fmt.Errorf("when build BPTreeIdx insert index err: %s", err)
 	}
 
@@ -733,49 +1200,22 @@ func (db *DB) buildOtherIdxes(bucket string, r *Record) error {
 
 // buildHintIdx builds the Hint Indexes.
 func (db *DB) buildHintIdx(dataFileIds []int) error {
-	unconfirmedRecords, committedTxIds, err := db.parseDataFiles(dataFileIds)
-	db.committedTxIds = committedTxIds
-
-	if err != nil {
-		return err
+	if db.opt.EntryIdxMode != HintBPTSparseIdxMode {
+		if err := db.loadCheckpoints(dataFileIds); err != nil {
+			return err
+		}
 	}
 
-	if len(unconfirmedRecords) == 0 {
-		return nil
+	if err := db.parseDataFiles(dataFileIds); err != nil {
+		return err
 	}
 
-	for _, r := range unconfirmedRecords {
-		if _, ok := db.committedTxIds[r.H.Meta.TxID]; ok {
-			bucket := r.Bucket
-
-			if r.H.Meta.Ds == DataStructureBPTree {
-				r.H.Meta.Status = Committed
-
-				if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
-					if err = db.buildActiveBPTreeIdx(r); err != nil {
-						return err
-					}
-				} else {
-					if err = db.buildBPTreeIdx(bucket, r); err != nil {
-						return err
-					}
-				}
-			}
-
-			if err = db.buildOtherIdxes(bucket, r); err != nil {
-				return err
-			}
-
-			if r.H.Meta.Ds == DataStructureNone {
-				db.buildNotDSIdxes(bucket, r)
-			}
-
-			db.KeyCount++
-		}
+	if err := db.loadCoveredFileStats(dataFileIds); err != nil {
+		return err
 	}
 
 	if HintBPTSparseIdxMode == db.opt.EntryIdxMode {
-		if err = db.buildBPTreeRootIdxes(dataFileIds); err != nil {
+		if err := db.buildBPTreeRootIdxes(dataFileIds); err != nil {
 			return err
 		}
 	}
@@ -835,6 +1275,24 @@ func (db *DB) buildSetIdx(bucket string, r *Record) error {
 		}
 	}
 
+	if r.H.Meta.Flag == DataSetSnapshotFlag {
+		members, err := UnmarshalListItems(r.E.Value)
+		if err != nil {
+			return fmt.Errorf("when build SetIdx snapshot index err: %s", err)
+		}
+		setMembers(db.SetIdx[bucket], string(r.E.Key), members)
+	}
+
+	if r.H.Meta.Flag == DataSAddBatchFlag {
+		members, err := UnmarshalListItems(r.E.Value)
+		if err != nil {
+			return fmt.Errorf("when build SetIdx batch index err: %s", err)
+		}
+		if err := db.SetIdx[bucket].SAdd(string(r.E.Key), members...); err != nil {
+			return fmt.Errorf("when build SetIdx SAdd index err: %s", err)
+		}
+	}
+
 	return nil
 }
 
@@ -863,12 +1321,40 @@ func (db *DB) buildSortedSetIdx(bucket string, r *Record) error {
 		end, _ := strconv2.StrToInt(string(r.E.Value))
 		_ = db.SortedSetIdx[bucket].GetByRankRange(start, end, true)
 	}
+	if r.H.Meta.Flag == DataZRemRangeByScoreFlag || r.H.Meta.Flag == DataZRemRangeByLexFlag {
+		if r.E == nil {
+			return ErrEntryIdxModeOpt
+		}
+		db.SortedSetIdx[bucket] = applySortedSetEntry(db.SortedSetIdx[bucket], r.E)
+	}
 	if r.H.Meta.Flag == DataZPopMaxFlag {
 		_ = db.SortedSetIdx[bucket].PopMax()
 	}
 	if r.H.Meta.Flag == DataZPopMinFlag {
 		_ = db.SortedSetIdx[bucket].PopMin()
 	}
+	if r.H.Meta.Flag == DataZSetSnapshotFlag {
+		if r.E == nil {
+			return ErrEntryIdxModeOpt
+		}
+		ss, err := UnmarshalSortedSetNodes(r.E.Value)
+		if err != nil {
+			return fmt.Errorf("when build SortedSetIdx snapshot index err: %s", err)
+		}
+		db.SortedSetIdx[bucket] = ss
+	}
+	if r.H.Meta.Flag == DataZAddBatchFlag {
+		if r.E == nil {
+			return ErrEntryIdxModeOpt
+		}
+		members, err := unmarshalZMembers(r.E.Value)
+		if err != nil {
+			return fmt.Errorf("when build SortedSetIdx batch index err: %s", err)
+		}
+		for _, m := range members {
+			_ = db.SortedSetIdx[bucket].Put(string(m.Key), zset.SCORE(m.Score), m.Value)
+		}
+	}
 
 	return nil
 }
@@ -884,9 +1370,16 @@ func (db *DB) buildListIdx(bucket string, r *Record) error {
 	if r.E == nil {
 		return ErrEntryIdxModeOpt
 	}
-	if IsExpired(r.E.Meta.TTL, r.E.Meta.Timestamp) {
+	if db.isTTLExpired(r.E.Meta.TTL, r.E.Meta.Timestamp) {
 		return nil
 	}
+
+	// the expiry of the list is checked at the time of the record, so that the replay
+	// drops an expired list where the writes did and does not depend on when it runs.
+	l.Clock = recordClock(r.E.Meta.Timestamp)
+	defer func() { l.Clock = db.Index.clock }()
+	dropExpiredList(l, r.E)
+
 	switch r.H.Meta.Flag {
 	case DataExpireListFlag:
 		t, err := strconv2.StrToInt64(string(r.E.Value))
@@ -916,6 +1409,8 @@ func (db *DB) buildListIdx(bucket string, r *Record) error {
 		if _, err := l.RPop(string(r.E.Key)); err != nil {
 			return ErrWhenBuildListIdx(err)
 		}
+	case DataLPopRefFlag, DataRPopRefFlag, DataLPopNFlag, DataRPopNFlag:
+		applyListPopRef(l, r.E)
 	case DataLSetFlag:
 		keyAndIndex := strings.Split(string(r.E.Key), SeparatorForListKey)
 		newKey := keyAndIndex[0]
@@ -939,6 +1434,16 @@ func (db *DB) buildListIdx(bucket string, r *Record) error {
 		if _, err := l.LRemByIndex(string(r.E.Key), indexes); err != nil {
 			return ErrWhenBuildListIdx(err)
 		}
+	case DataLCompactFlag:
+		items, err := UnmarshalListItems(r.E.Value)
+		if err != nil {
+			return ErrWhenBuildListIdx(err)
+		}
+		setListItems(l, string(r.E.Key), items)
+	case DataLInsertBeforeFlag, DataLInsertAfterFlag:
+		if err := applyListInsert(l, r.E); err != nil {
+			return ErrWhenBuildListIdx(err)
+		}
 	}
 
 	return nil
@@ -982,29 +1487,48 @@ func (db *DB) buildIndexes() (err error) {
 	return db.buildHintIdx(dataFileIds)
 }
 
+// PanicError is returned by Update and View when the function passed panics and Options.PanicAsError is set.
+type PanicError struct {
+	// Value is the value passed to panic.
+	Value interface{}
+
+	// Stack is the stack trace of the panicking goroutine.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("transaction panicked: %v", e.Value)
+}
+
 // managed calls a block of code that is fully contained in a transaction.
-func (db *DB) managed(writable bool, fn func(tx *Tx) error) (err error) {
+func (db *DB) managed(ctx context.Context, writable bool, fn func(tx *Tx) error) (err error) {
 	var tx *Tx
 
-	tx, err = db.Begin(writable)
+	tx, err = db.BeginWithContext(ctx, writable)
 	if err != nil {
 		return err
 	}
 	defer func() {
-		var panicked bool
-		if r := recover(); r != nil {
-			// resume normal execution
-			panicked = true
-		}
-		if panicked || err != nil {
-			if errRollback := tx.Rollback(); errRollback != nil {
+		r := recover()
+		// the tx closed by Commit has nothing to roll back, its error may report it committed.
+		if (r != nil || err != nil) && tx.db != nil {
+			// the rollback releases the lock, so a panicking fn never leaves the db locked.
+			if errRollback := tx.Rollback(); errRollback != nil && r == nil {
 				err = errRollback
 			}
 		}
+		if r != nil {
+			if !db.opt.PanicAsError {
+				panic(r)
+			}
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
 	}()
 
 	if err = fn(tx); err == nil {
-		err = tx.Commit()
+		if err = ctx.Err(); err == nil {
+			err = tx.Commit()
+		}
 	}
 	return err
 }
@@ -1057,7 +1581,7 @@ func (db *DB) getBPTRootTxIDPath(fID int64) string {
 	return db.getBPTDir() + separator + "txid" + separator + strconv2.Int64ToStr(fID) + BPTRootTxIDIndexSuffix
 }
 
-func (db *DB) getPendingMergeEntries(entry *Entry, pendingMergeEntries []*Entry) []*Entry {
+func (db *DB) getPendingMergeEntries(entry *Entry, pendingMergeEntries []*Entry, compacted map[string]struct{}) []*Entry {
 	if entry.Meta.Ds == DataStructureBPTree {
 		bptIdx, exist := db.BPTreeIdx[string(entry.Bucket)]
 		if exist {
@@ -1069,82 +1593,276 @@ func (db *DB) getPendingMergeEntries(entry *Entry, pendingMergeEntries []*Entry)
 	}
 
 	if entry.Meta.Ds == DataStructureSet {
-		setIdx, exist := db.SetIdx[string(entry.Bucket)]
-		if exist {
-			if setIdx.SIsMember(string(entry.Key), entry.Value) {
-				pendingMergeEntries = append(pendingMergeEntries, entry)
-			}
-		}
+		pendingMergeEntries = db.getPendingSetEntries(entry, pendingMergeEntries, compacted)
 	}
 
 	if entry.Meta.Ds == DataStructureSortedSet {
-		keyAndScore := strings.Split(string(entry.Key), SeparatorForZSetKey)
-		if len(keyAndScore) == 2 {
-			key := keyAndScore[0]
-			sortedSetIdx, exist := db.SortedSetIdx[string(entry.Bucket)]
-			if exist {
-				n := sortedSetIdx.GetByKey(key)
-				if n != nil {
-					pendingMergeEntries = append(pendingMergeEntries, entry)
-				}
-			}
-		}
+		pendingMergeEntries = db.getPendingSortedSetEntries(entry, pendingMergeEntries, compacted)
 	}
 
 	if entry.Meta.Ds == DataStructureList {
-		//check the key of list is expired or not
-		//if expired, it will clear the items of index
-		//so that nutsdb can clear entry of expiring list in the function getPendingMergeEntries
-		db.checkListExpired()
-		listIdx := db.Index.getList(string(entry.Bucket))
-
-		if listIdx != nil {
-			items, _ := listIdx.LRange(string(entry.Key), 0, -1)
-			ok := false
-			if entry.Meta.Flag == DataRPushFlag || entry.Meta.Flag == DataLPushFlag {
-				for _, item := range items {
-					if string(entry.Value) == string(item) {
-						ok = true
-						break
-					}
-				}
-				if ok {
-					pendingMergeEntries = append(pendingMergeEntries, entry)
-				}
-			}
+		pendingMergeEntries = db.getPendingListEntries(entry, pendingMergeEntries, compacted)
+	}
+
+	return pendingMergeEntries
+}
+
+// getPendingListEntries replaces all the records of the list the entry belongs to by a snapshot
+// of the current content of the list followed by its ttl, the first time the list is met by the merge.
+func (db *DB) getPendingListEntries(entry *Entry, pendingMergeEntries []*Entry, compacted map[string]struct{}) []*Entry {
+	bucket, key := string(entry.Bucket), listKeyOfEntry(entry)
+	if !markCompacted(compacted, DataStructureList, bucket, key) {
+		return pendingMergeEntries
+	}
+
+	//check the key of list is expired or not
+	//if expired, it will clear the items of index
+	//so that the records of the expired list are dropped
+	db.checkListExpired()
+	l := db.Index.getList(bucket)
+	if l == nil || len(l.Items[key]) == 0 {
+		return pendingMergeEntries
+	}
+
+	now := db.timestamp()
+	snapshot := newPersistentEntry(bucket, key, MarshalListItems(l.Items[key]), DataLCompactFlag, now, DataStructureList)
+	if snapshot.Size() <= db.opt.SegmentSize {
+		pendingMergeEntries = append(pendingMergeEntries, snapshot)
+	} else {
+		// the snapshot does not fit in a data file, reset the list and push back the items one by one.
+		pendingMergeEntries = append(pendingMergeEntries, newPersistentEntry(bucket, key, nil, DataLCompactFlag, now, DataStructureList))
+		for _, item := range l.Items[key] {
+			pendingMergeEntries = append(pendingMergeEntries, newPersistentEntry(bucket, key, item, DataRPushFlag, now, DataStructureList))
 		}
 	}
 
+	if ttl, ok := l.TTL[key]; ok {
+		ttls := strconv2.Int64ToStr(int64(ttl))
+		pendingMergeEntries = append(pendingMergeEntries, newPersistentEntry(bucket, key, []byte(ttls), DataExpireListFlag, l.TimeStamp[key], DataStructureList))
+	}
+
 	return pendingMergeEntries
 }
 
-func (db *DB) reWriteData(pendingMergeEntries []*Entry) error {
-	if len(pendingMergeEntries) == 0 {
-		return nil
+// getPendingSetEntries replaces all the records of the set the entry belongs to by a snapshot
+// of its current members, the first time the set is met by the merge.
+func (db *DB) getPendingSetEntries(entry *Entry, pendingMergeEntries []*Entry, compacted map[string]struct{}) []*Entry {
+	bucket, key := string(entry.Bucket), string(entry.Key)
+	if !markCompacted(compacted, DataStructureSet, bucket, key) {
+		return pendingMergeEntries
 	}
-	tx, err := db.Begin(true)
-	if err != nil {
-		db.isMerging = false
+
+	setIdx, ok := db.SetIdx[bucket]
+	if !ok || len(setIdx.M[key]) == 0 {
+		return pendingMergeEntries
+	}
+	members, _ := setIdx.SMembers(key)
+
+	now := db.timestamp()
+	snapshot := newPersistentEntry(bucket, key, MarshalListItems(members), DataSetSnapshotFlag, now, DataStructureSet)
+	if snapshot.Size() <= db.opt.SegmentSize {
+		return append(pendingMergeEntries, snapshot)
+	}
+
+	// the snapshot does not fit in a data file, reset the set and add back the members one by one.
+	pendingMergeEntries = append(pendingMergeEntries, newPersistentEntry(bucket, key, nil, DataSetSnapshotFlag, now, DataStructureSet))
+	for _, member := range members {
+		pendingMergeEntries = append(pendingMergeEntries, newPersistentEntry(bucket, key, member, DataSetFlag, now, DataStructureSet))
+	}
+	return pendingMergeEntries
+}
+
+// getPendingSortedSetEntries replaces all the records of the sorted set bucket the entry belongs to
+// by a snapshot of its current members, the first time the bucket is met by the merge.
+func (db *DB) getPendingSortedSetEntries(entry *Entry, pendingMergeEntries []*Entry, compacted map[string]struct{}) []*Entry {
+	bucket := string(entry.Bucket)
+	if !markCompacted(compacted, DataStructureSortedSet, bucket, "") {
+		return pendingMergeEntries
+	}
+
+	sortedSetIdx, ok := db.SortedSetIdx[bucket]
+	if !ok || sortedSetIdx.Size() == 0 {
+		return pendingMergeEntries
+	}
+	nodes := sortedSetIdx.GetByRankRange(1, -1, false)
+
+	now := db.timestamp()
+	snapshot := newPersistentEntry(bucket, zsetSnapshotKey, MarshalSortedSetNodes(nodes), DataZSetSnapshotFlag, now, DataStructureSortedSet)
+	if snapshot.Size() <= db.opt.SegmentSize {
+		return append(pendingMergeEntries, snapshot)
+	}
+
+	// the snapshot does not fit in a data file, reset the bucket and add back the members one by one.
+	pendingMergeEntries = append(pendingMergeEntries, newPersistentEntry(bucket, zsetSnapshotKey, nil, DataZSetSnapshotFlag, now, DataStructureSortedSet))
+	for _, n := range nodes {
+		key := n.Key() + SeparatorForZSetKey + strconv.FormatFloat(float64(n.Score()), 'f', -1, 64)
+		pendingMergeEntries = append(pendingMergeEntries, newPersistentEntry(bucket, key, n.Value, DataZAddFlag, now, DataStructureSortedSet))
+	}
+	return pendingMergeEntries
+}
+
+// markCompacted marks the key of the bucket of the data structure ds as compacted by the merge,
+// it returns false if it was already marked.
+func markCompacted(compacted map[string]struct{}, ds uint16, bucket, key string) bool {
+	id := strconv2.IntToStr(int(ds)) + "\x00" + bucket + "\x00" + key
+	if _, ok := compacted[id]; ok {
+		return false
+	}
+	compacted[id] = struct{}{}
+	return true
+}
+
+// newPersistentEntry returns a persistent entry of the data structure ds.
+func newPersistentEntry(bucket, key string, value []byte, flag uint16, timestamp uint64, ds uint16) *Entry {
+	return &Entry{
+		Key:    []byte(key),
+		Value:  value,
+		Bucket: []byte(bucket),
+		Meta: &MetaData{
+			KeySize:    uint32(len(key)),
+			ValueSize:  uint32(len(value)),
+			Timestamp:  timestamp,
+			Flag:       flag,
+			TTL:        Persistent,
+			BucketSize: uint32(len(bucket)),
+			Ds:         ds,
+		},
+	}
+}
+
+// reWriteEntries writes the entries to the tx as a merge, from a new active file.
+func (tx *Tx) reWriteEntries(ctx context.Context, pendingMergeEntries []*Entry) error {
+	db := tx.db
+	tx.isMerge = true
+
+	db.syncer.fileMu.Lock()
+	if err := db.syncer.syncBeforeRelease(); err != nil {
+		db.syncer.fileMu.Unlock()
 		return err
 	}
 
 	dataFile, err := db.fm.getDataFile(db.getDataPath(db.MaxFileID+1), db.opt.SegmentSize)
 	if err != nil {
-		db.isMerging = false
+		db.syncer.fileMu.Unlock()
 		return err
 	}
 	db.ActiveFile = dataFile
+	db.syncer.fileMu.Unlock()
 	db.MaxFileID++
+	db.ActiveFile.fileID = db.MaxFileID
 
 	for _, e := range pendingMergeEntries {
-		err := tx.put(string(e.Bucket), e.Key, e.Value, e.Meta.TTL, e.Meta.Flag, e.Meta.Timestamp, e.Meta.Ds)
+		// the values are compressed again with the current Options.Compression.
+		e, err := decompressEntry(e)
+		if err == nil {
+			e, err = tx.collectContent(e)
+		}
+		if err == nil {
+			e, err = db.applyCompactionFilter(ctx, e)
+		}
+		if err == nil {
+			err = tx.putValue(string(e.Bucket), e.Key, e.Value, e.Meta.TTL, e.Meta.Flag, e.Meta.Timestamp, e.Meta.Ds, e.Meta.valueKind())
+		}
+		if err == nil {
+			err = tx.applyEntryRewriter(ctx)
+		}
 		if err != nil {
-			tx.Rollback()
-			db.isMerging = false
 			return err
 		}
 	}
-	tx.Commit()
+	return nil
+}
+
+// applyCompactionFilter passes the key/value entry to the CompactionFilter and
+// returns the entry that should be written into the merged file.
+func (db *DB) applyCompactionFilter(ctx context.Context, e *Entry) (*Entry, error) {
+	if db.opt.CompactionFilter == nil || e.Meta.Ds != DataStructureBPTree || e.Meta.Flag != DataSetFlag ||
+		db.isImmutable(string(e.Bucket)) || e.Meta.valueKind() != valueKindPlain {
+		return e, nil
+	}
+
+	decision, newValue := db.opt.CompactionFilter(ctx, string(e.Bucket), e.Key, e.Value, e.Meta)
+	switch decision {
+	case CompactionKeep:
+		return e, nil
+	case CompactionRemove:
+		return db.mergeDeleteEntry(e), nil
+	case CompactionChangeValue:
+		return &Entry{Key: e.Key, Value: newValue, Bucket: e.Bucket, Meta: e.Meta}, nil
+	}
+
+	return nil, ErrCompactionDecision
+}
+
+// mergeDeleteEntry returns the entry deleting the key/value entry, written by Merge in place of it.
+func (db *DB) mergeDeleteEntry(e *Entry) *Entry {
+	meta := *e.Meta
+	meta.Flag = DataDeleteFlag
+	meta.TTL = Persistent
+	meta.Timestamp = db.timestamp()
+	return &Entry{Key: e.Key, Bucket: e.Bucket, Meta: &meta}
+}
+
+// applyEntryRewriter passes the entry last written to the tx by the merge, as encoded with the current options,
+// to the EntryRewriter and writes the entry it returns in place of it with its meta as-is, only the sizes, the tx id
+// and the status are set by the tx. The rewritten entry must keep the bucket and the key, and a compressed value
+// must decompress with its codec.
+func (tx *Tx) applyEntryRewriter(ctx context.Context) error {
+	db := tx.db
+	if db.opt.EntryRewriter == nil || len(tx.pendingWrites) == 0 {
+		return nil
+	}
+	i := len(tx.pendingWrites) - 1
+	e := tx.pendingWrites[i]
+	bucket := string(e.Bucket)
+	if e.Meta.Ds == DataStructureBPTree && db.isImmutable(bucket) {
+		return nil
+	}
+
+	rewritten, err := db.opt.EntryRewriter.Rewrite(ctx, e)
+	if err != nil {
+		return err
+	}
+	if rewritten == nil || rewritten.Meta == nil ||
+		!bytes.Equal(rewritten.Bucket, e.Bucket) || !bytes.Equal(rewritten.Key, e.Key) {
+		return ErrEntryRewrite
+	}
+	if rewritten == e {
+		return nil
+	}
+
+	meta := *rewritten.Meta
+	meta.KeySize = uint32(len(rewritten.Key))
+	meta.ValueSize = uint32(len(rewritten.Value))
+	meta.TxID = e.Meta.TxID
+	meta.Status = e.Meta.Status
+	r := &Entry{Key: rewritten.Key, Value: rewritten.Value, Bucket: e.Bucket, Meta: &meta}
+	if err := r.valid(); err != nil {
+		return err
+	}
+
+	if codec := meta.codec(); codec != CompressionNone {
+		if _, err := decompress(codec, r.Value); err != nil {
+			return ErrEntryRewrite
+		}
+	}
+	bucketSize := uint32(len(bucket))
+	if meta.hasBucketID() {
+		r.bucketID = db.bucketIDs.encodedID(bucket)
+		bucketSize = uint32(len(r.bucketID))
+	}
+	meta.BucketSize = meta.BucketSize&(bucketIDFlag|bucketCodecMask|bucketSealedFlag|bucketValueKindMask) | bucketSize
+	if meta.sealed() {
+		if !db.keys.encrypt {
+			return ErrEntryRewrite
+		}
+		if err := db.keys.prepare(bucket); err != nil {
+			return err
+		}
+		r.keys = db.keys
+	}
+
+	tx.pendingWrites[i] = r
 	return nil
 }
 
@@ -1162,6 +1880,19 @@ func (db *DB) getRecordFromKey(bucket, key []byte) (record *Record, err error) {
 	return idx.Find(key)
 }
 
+// isExpired checks if the key/value entry at given meta of the bucket is expired,
+// either by its ttl or by the retention of the bucket.
+func (db *DB) isExpired(bucket string, meta *MetaData) bool {
+	if db.isTTLExpired(meta.TTL, meta.Timestamp) {
+		return true
+	}
+	retention, ok := db.opt.BucketRetention[bucket]
+	if !ok || retention <= 0 {
+		return false
+	}
+	return time.Unix(int64(meta.Timestamp), 0).Add(retention).Before(db.now())
+}
+
 func (db *DB) checkListExpired() {
 	db.Index.rangeList(func(l *list.List) {
 		for key := range l.TTL {
@@ -1170,6 +1901,11 @@ func (db *DB) checkListExpired() {
 	})
 }
 
+// Options returns the options the db was opened with.
+func (db *DB) Options() Options {
+	return db.opt
+}
+
 // IsClose return the value that represents the status of DB
 func (db *DB) IsClose() bool {
 	return db.closed
@@ -15,11 +15,15 @@
 package nutsdb
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -314,6 +318,416 @@ func Test_MergeRepeated(t *testing.T) {
 	}
 }
 
+func TestDB_Merge_CompactionFilter(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestcompactionfilter", true)
+	opt.SegmentSize = 120
+	opt.CompactionFilter = func(ctx context.Context, bucket string, key, value []byte, meta *MetaData) (CompactionDecision, []byte) {
+		assert.Equal(t, "merge", ctx.Value(txTestCtxKey{}))
+		switch string(key) {
+		case "key_remove":
+			return CompactionRemove, nil
+		case "key_change":
+			return CompactionChangeValue, []byte("new_value")
+		}
+		return CompactionKeep, nil
+	}
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	bucket := "bucket_compaction_filter"
+	for _, key := range []string{"key_keep", "key_remove", "key_change"} {
+		err = db.Update(func(tx *Tx) error {
+			return tx.Put(bucket, []byte(key), []byte("old_value"), Persistent)
+		})
+		require.NoError(t, err)
+	}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, db.MergeWithContext(canceled))
+	require.NoError(t, db.MergeWithContext(context.WithValue(context.Background(), txTestCtxKey{}, "merge")))
+
+	check := func() {
+		err = db.View(func(tx *Tx) error {
+			e, err := tx.Get(bucket, []byte("key_keep"))
+			if assert.NoError(t, err) {
+				assert.Equal(t, []byte("old_value"), e.Value)
+			}
+			e, err = tx.Get(bucket, []byte("key_change"))
+			if assert.NoError(t, err) {
+				assert.Equal(t, []byte("new_value"), e.Value)
+			}
+			_, err = tx.Get(bucket, []byte("key_remove"))
+			assert.Error(t, err)
+			return nil
+		})
+		require.NoError(t, err)
+	}
+
+	check()
+
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	check()
+	require.NoError(t, db.Close())
+}
+
+func TestDB_Merge_EntryRewriter(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestentryrewriter", true)
+	opt.SegmentSize = 120
+	opt.EntryRewriter = EntryRewriterFunc(func(ctx context.Context, e *Entry) (*Entry, error) {
+		if e.Meta.Ds != DataStructureBPTree || bytes.HasPrefix(e.Value, []byte("v2:")) {
+			return e, nil
+		}
+		return &Entry{Key: e.Key, Bucket: e.Bucket, Value: append([]byte("v2:"), e.Value...), Meta: e.Meta}, nil
+	})
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	bucket := "bucket_entry_rewriter"
+	for i := 0; i < 3; i++ {
+		err = db.Update(func(tx *Tx) error {
+			return tx.Put(bucket, []byte(fmt.Sprintf("key_%d", i)), []byte("value"), Persistent)
+		})
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, db.Merge())
+
+	err = db.View(func(tx *Tx) error {
+		for i := 0; i < 3; i++ {
+			e, err := tx.Get(bucket, []byte(fmt.Sprintf("key_%d", i)))
+			if assert.NoError(t, err) {
+				assert.Equal(t, []byte("v2:value"), e.Value)
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	InitOpt("/tmp/nutsdbtestentryrewriter", true)
+	opt.SegmentSize = 120
+	opt.EntryRewriter = EntryRewriterFunc(func(ctx context.Context, e *Entry) (*Entry, error) {
+		return &Entry{Key: []byte("other"), Bucket: e.Bucket, Value: e.Value, Meta: e.Meta}, nil
+	})
+	db, err = Open(opt)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		err = db.Update(func(tx *Tx) error {
+			return tx.Put(bucket, []byte(fmt.Sprintf("key_%d", i)), []byte("value"), Persistent)
+		})
+		require.NoError(t, err)
+	}
+	assert.Equal(t, ErrEntryRewrite, db.Merge())
+	require.NoError(t, db.Close())
+}
+
+func TestDB_Merge_EntryRewriter_Encoding(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestentryrewriterencoding", true)
+	opt.SegmentSize = 1024
+	// the values written uncompressed are compressed by the merge, the codec set by the rewriter is kept.
+	opt.EntryRewriter = EntryRewriterFunc(func(ctx context.Context, e *Entry) (*Entry, error) {
+		if e.Meta.Ds != DataStructureBPTree || e.Meta.Flag != DataSetFlag || e.Meta.codec() != CompressionNone {
+			return e, nil
+		}
+		value, err := compress(CompressionFlate, e.Value)
+		if err != nil {
+			return nil, err
+		}
+		meta := *e.Meta
+		meta.setCodec(CompressionFlate)
+		return &Entry{Key: e.Key, Bucket: e.Bucket, Value: value, Meta: &meta}, nil
+	})
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	bucket := "bucket_entry_rewriter_encoding"
+	value := bytes.Repeat([]byte("value"), 20)
+	for i := 0; i < 20; i++ {
+		err = db.Update(func(tx *Tx) error {
+			return tx.Put(bucket, []byte(fmt.Sprintf("key_%d", i)), value, Persistent)
+		})
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.Merge())
+
+	check := func() {
+		err := db.View(func(tx *Tx) error {
+			for i := 0; i < 20; i++ {
+				key := []byte(fmt.Sprintf("key_%d", i))
+				r, err := tx.db.BPTreeIdx[bucket].Find(key)
+				require.NoError(t, err)
+				assert.Equal(t, CompressionFlate, r.H.Meta.codec())
+				e, err := tx.Get(bucket, key)
+				if assert.NoError(t, err) {
+					assert.Equal(t, value, e.Value)
+				}
+			}
+			return nil
+		})
+		require.NoError(t, err)
+	}
+	check()
+
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	check()
+	require.NoError(t, db.Close())
+}
+
+func TestDB_Merge_ProgressWithConcurrentWrites(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestmergeprogress", true)
+	opt.SegmentSize = 256
+	var progress []MergeProgress
+	opt.OnMergeProgress = func(ctx context.Context, p MergeProgress) {
+		assert.Equal(t, "merge", ctx.Value(txTestCtxKey{}))
+		progress = append(progress, p)
+	}
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	bucket := "bucket_merge_progress"
+	for i := 0; i < 30; i++ {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.Put(bucket, []byte(fmt.Sprintf("key_%d", i%5)), []byte(fmt.Sprintf("value_%d", i)), Persistent)
+		}))
+	}
+	_, before := db.getMaxFileIDAndFileIDs()
+
+	// the writes go on while the files are merged.
+	started, done := make(chan struct{}), make(chan struct{})
+	writeErr := make(chan error, 1)
+	go func() {
+		defer close(writeErr)
+		for i := 0; ; i++ {
+			if err := db.Update(func(tx *Tx) error {
+				return tx.Put(bucket, []byte("concurrent"), []byte(fmt.Sprintf("value_%d", i)), Persistent)
+			}); err != nil {
+				writeErr <- err
+				return
+			}
+			if i == 0 {
+				close(started)
+			}
+			select {
+			case <-done:
+				return
+			default:
+			}
+		}
+	}()
+
+	<-started
+	require.NoError(t, db.MergeWithContext(context.WithValue(context.Background(), txTestCtxKey{}, "merge")))
+	close(done)
+	require.NoError(t, <-writeErr)
+
+	require.True(t, len(progress) >= len(before))
+	require.Len(t, progress, progress[0].Total)
+	for i, p := range progress {
+		assert.Equal(t, int64(before[0]+i), p.FileID)
+		assert.Equal(t, i+1, p.Merged)
+	}
+
+	check := func() {
+		require.NoError(t, db.View(func(tx *Tx) error {
+			for i := 25; i < 30; i++ {
+				e, err := tx.Get(bucket, []byte(fmt.Sprintf("key_%d", i%5)))
+				require.NoError(t, err)
+				assert.Equal(t, []byte(fmt.Sprintf("value_%d", i)), e.Value)
+			}
+			_, err := tx.Get(bucket, []byte("concurrent"))
+			require.NoError(t, err)
+			return nil
+		}))
+	}
+	check()
+
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	check()
+	require.NoError(t, db.Close())
+}
+
+func TestDB_Merge_CompactList(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestmergecompactlist", true)
+	opt.SegmentSize = 1024
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	bucket, key := "bucket_compact_list", []byte("list")
+	for i := 0; i < 50; i++ {
+		err = db.Update(func(tx *Tx) error {
+			return tx.RPush(bucket, key, []byte(fmt.Sprintf("v%02d", i)))
+		})
+		require.NoError(t, err)
+		if i%2 == 1 {
+			err = db.Update(func(tx *Tx) error {
+				_, err := tx.LPop(bucket, key)
+				return err
+			})
+			require.NoError(t, err)
+		}
+	}
+	err = db.Update(func(tx *Tx) error {
+		if err := tx.LSet(bucket, key, 0, []byte("first")); err != nil {
+			return err
+		}
+		return tx.ExpireList(bucket, key, 3600)
+	})
+	require.NoError(t, err)
+
+	var want [][]byte
+	err = db.View(func(tx *Tx) error {
+		want, err = tx.LRange(bucket, key, 0, -1)
+		return err
+	})
+	require.NoError(t, err)
+	require.Len(t, want, 25)
+
+	require.NoError(t, db.Merge())
+
+	assert.Equal(t, 2, countEntriesByDs(t, db)[DataStructureList])
+
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	err = db.View(func(tx *Tx) error {
+		items, err := tx.LRange(bucket, key, 0, -1)
+		assert.NoError(t, err)
+		assert.Equal(t, want, items)
+		ttl, err := tx.GetListTTL(bucket, key)
+		assert.NoError(t, err)
+		assert.True(t, ttl > 0)
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+}
+
+func TestDB_Merge_SnapshotSetAndSortedSet(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestmergesnapshotset", true)
+	opt.SegmentSize = 1024
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	setBucket, zsetBucket, key := "bucket_set", "bucket_zset", []byte("set")
+	for i := 0; i < 30; i++ {
+		err = db.Update(func(tx *Tx) error {
+			member := []byte(fmt.Sprintf("m%02d", i))
+			if err := tx.SAdd(setBucket, key, member); err != nil {
+				return err
+			}
+			return tx.ZAdd(zsetBucket, member, float64(i), nil)
+		})
+		require.NoError(t, err)
+		if i%3 == 0 {
+			err = db.Update(func(tx *Tx) error {
+				member := []byte(fmt.Sprintf("m%02d", i))
+				if err := tx.SRem(setBucket, key, member); err != nil {
+					return err
+				}
+				return tx.ZRem(zsetBucket, string(member))
+			})
+			require.NoError(t, err)
+		}
+	}
+
+	require.NoError(t, db.Merge())
+	counts := countEntriesByDs(t, db)
+	assert.Equal(t, 1, counts[DataStructureSet])
+	assert.Equal(t, 1, counts[DataStructureSortedSet])
+
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	err = db.View(func(tx *Tx) error {
+		card, err := tx.SCard(setBucket, key)
+		assert.NoError(t, err)
+		assert.Equal(t, 20, card)
+		nodes, err := tx.ZRangeByRank(zsetBucket, 1, -1)
+		assert.NoError(t, err)
+		if assert.Len(t, nodes, 20) {
+			assert.Equal(t, "m01", nodes[0].Key())
+			assert.Equal(t, "m29", nodes[19].Key())
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+}
+
+// countEntriesByDs counts the entries of the data files by data structure.
+func countEntriesByDs(t *testing.T, db *DB) map[uint16]int {
+	counts := make(map[uint16]int)
+	_, fIDs := db.getMaxFileIDAndFileIDs()
+	for _, fID := range fIDs {
+		fr, err := newFileRecovery(db.getDataPath(int64(fID)), db.opt.BufferSizeOfRecovery)
+		require.NoError(t, err)
+		for {
+			e, err := fr.readEntry()
+			if err != nil || e == nil {
+				break
+			}
+			counts[e.Meta.Ds]++
+		}
+		require.NoError(t, fr.release())
+	}
+	return counts
+}
+
+func TestDB_BucketRetention(t *testing.T) {
+	bucket := "bucket_retention"
+	InitOpt("/tmp/nutsdbtestbucketretention", true)
+	opt.SegmentSize = 120
+	WithBucketRetention(bucket, time.Hour)(&opt)
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	old := uint64(time.Now().Add(-2 * time.Hour).Unix())
+	err = db.Update(func(tx *Tx) error {
+		if err := tx.PutWithTimestamp(bucket, []byte("old"), []byte("value"), Persistent, old); err != nil {
+			return err
+		}
+		if err := tx.PutWithTimestamp("other", []byte("old"), []byte("value"), Persistent, old); err != nil {
+			return err
+		}
+		return tx.Put(bucket, []byte("new"), []byte("value"), Persistent)
+	})
+	require.NoError(t, err)
+
+	check := func() {
+		err = db.View(func(tx *Tx) error {
+			_, err := tx.Get(bucket, []byte("old"))
+			assert.Equal(t, ErrNotFoundKey, err)
+			_, err = tx.Get("other", []byte("old"))
+			assert.NoError(t, err)
+
+			entries, err := tx.GetAll(bucket)
+			if assert.NoError(t, err) && assert.Len(t, entries, 1) {
+				assert.Equal(t, []byte("new"), entries[0].Key)
+			}
+
+			it := NewIterator(tx, bucket, IteratorOptions{})
+			ok, err := it.SetNext()
+			assert.True(t, ok)
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("new"), it.Entry().Key)
+			return nil
+		})
+		require.NoError(t, err)
+	}
+
+	check()
+	require.NoError(t, db.Merge())
+	check()
+	require.NoError(t, db.Close())
+}
+
 func opSAddAndCheckForTestMerge(bucketForSet string, key []byte, t *testing.T) {
 	for i := 0; i < 100; i++ {
 		if err := db.Update(func(tx *Tx) error {
@@ -1185,3 +1599,50 @@ func withBPTSpareeIdxDB(t *testing.T, fn func(t *testing.T, db *DB)) {
 
 	withDBOption(t, opt, fn)
 }
+
+func TestDB_PanicInTransaction(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestpanic", true)
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	bucket := "bucket"
+	assert.PanicsWithValue(t, "boom", func() {
+		_ = db.Update(func(tx *Tx) error {
+			if err := tx.Put(bucket, []byte("key"), []byte("value"), Persistent); err != nil {
+				return err
+			}
+			panic("boom")
+		})
+	})
+	assert.Panics(t, func() {
+		_ = db.View(func(tx *Tx) error {
+			panic("boom")
+		})
+	})
+
+	// the lock is released and the writes of the panicking transaction are dropped.
+	err = db.Update(func(tx *Tx) error {
+		_, err := tx.Get(bucket, []byte("key"))
+		assert.Error(t, err)
+		return tx.Put(bucket, []byte("other"), []byte("value"), Persistent)
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	db, err = Open(opt, WithPanicAsError(true))
+	require.NoError(t, err)
+	err = db.Update(func(tx *Tx) error {
+		panic("boom")
+	})
+	var panicErr *PanicError
+	if assert.True(t, errors.As(err, &panicErr)) {
+		assert.Equal(t, "boom", panicErr.Value)
+		assert.NotEmpty(t, panicErr.Stack)
+	}
+	err = db.View(func(tx *Tx) error {
+		_, err := tx.Get(bucket, []byte("other"))
+		return err
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+}
@@ -0,0 +1,153 @@
+//go:build nutsdb_debug
+
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/nutsdb/nutsdb/ds/list"
+	"github.com/nutsdb/nutsdb/ds/zset"
+	"github.com/xujiajun/utils/filesystem"
+)
+
+// ErrInvariantViolated is returned by DebugCheckInvariants when the internal structures disagree.
+var ErrInvariantViolated = errors.New("invariant violated")
+
+// DebugCheckInvariants validates the internal structures of the db: every record of the
+// BPTree indexes must point to a readable entry of the data files holding the same bucket
+// and key, every list must have coherent ttl bookkeeping and every sorted set must have
+// its skiplist agreeing with its dict. It is only built with the nutsdb_debug build tag
+// and is meant to be called from integration tests.
+func (db *DB) DebugCheckInvariants() error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed {
+		return ErrDBClosed
+	}
+
+	if db.opt.EntryIdxMode == HintKeyValAndRAMIdxMode || db.opt.EntryIdxMode == HintKeyAndRAMIdxMode {
+		for bucket, idx := range db.BPTreeIdx {
+			if err := db.checkBPTreeInvariants(bucket, idx); err != nil {
+				return err
+			}
+		}
+	}
+
+	for bucket, l := range db.Index.list {
+		if err := checkListInvariants(bucket, l); err != nil {
+			return err
+		}
+	}
+
+	for bucket, ss := range db.SortedSetIdx {
+		if err := checkSortedSetInvariants(bucket, ss); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (db *DB) checkBPTreeInvariants(bucket string, idx *BPTree) error {
+	records, err := idx.All()
+	if err != nil {
+		// an empty tree has no record to check.
+		return nil
+	}
+
+	for _, r := range records {
+		if r.H == nil || r.H.Meta == nil {
+			return fmt.Errorf("%w: bucket %s has a record without hint", ErrInvariantViolated, bucket)
+		}
+
+		// deleted and expired records are never read from the data files, merge may drop their entries.
+		if r.H.Meta.Flag == DataDeleteFlag || db.isExpired(bucket, r.H.Meta) {
+			continue
+		}
+
+		path := db.getDataPath(r.H.FileID)
+		if !filesystem.PathIsExist(path) {
+			return fmt.Errorf("%w: bucket %s key %s points to missing file %d", ErrInvariantViolated, bucket, r.H.Key, r.H.FileID)
+		}
+		df, err := db.fm.getDataFile(path, db.opt.SegmentSize)
+		if err != nil {
+			return fmt.Errorf("%w: bucket %s key %s points to file %d: %s", ErrInvariantViolated, bucket, r.H.Key, r.H.FileID, err)
+		}
+		e, err := df.ReadRecord(int(r.H.DataPos), r.H.Meta.PayloadSize())
+		if err != nil || e == nil {
+			return fmt.Errorf("%w: bucket %s key %s is not readable at file %d offset %d", ErrInvariantViolated, bucket, r.H.Key, r.H.FileID, r.H.DataPos)
+		}
+
+		if string(e.Bucket) != bucket || !bytes.Equal(e.Key, r.H.Key) {
+			return fmt.Errorf("%w: bucket %s key %s points to the entry of bucket %s key %s", ErrInvariantViolated, bucket, r.H.Key, e.Bucket, e.Key)
+		}
+		if e.Meta.Flag != r.H.Meta.Flag || e.Meta.TxID != r.H.Meta.TxID {
+			return fmt.Errorf("%w: bucket %s key %s has a stale hint", ErrInvariantViolated, bucket, r.H.Key)
+		}
+		if db.opt.EntryIdxMode == HintKeyValAndRAMIdxMode && (r.E == nil || !bytes.Equal(e.Value, r.E.Value)) {
+			return fmt.Errorf("%w: bucket %s key %s has a value differing from the data file", ErrInvariantViolated, bucket, r.H.Key)
+		}
+	}
+
+	return nil
+}
+
+func checkListInvariants(bucket string, l *list.List) error {
+	for key := range l.TTL {
+		if _, ok := l.TimeStamp[key]; !ok {
+			return fmt.Errorf("%w: list %s key %s has a ttl without timestamp", ErrInvariantViolated, bucket, key)
+		}
+	}
+	for key := range l.TimeStamp {
+		if _, ok := l.TTL[key]; !ok {
+			return fmt.Errorf("%w: list %s key %s has a timestamp without ttl", ErrInvariantViolated, bucket, key)
+		}
+	}
+	for key, items := range l.Items {
+		for i, item := range items {
+			if item == nil {
+				return fmt.Errorf("%w: list %s key %s has a nil item at index %d", ErrInvariantViolated, bucket, key, i)
+			}
+		}
+	}
+
+	return nil
+}
+
+func checkSortedSetInvariants(bucket string, ss *zset.SortedSet) error {
+	nodes := ss.GetByRankRange(1, -1, false)
+	if len(nodes) != ss.Size() || len(nodes) != len(ss.Dict) {
+		return fmt.Errorf("%w: sorted set %s has %d nodes, size %d and %d dict entries", ErrInvariantViolated, bucket, len(nodes), ss.Size(), len(ss.Dict))
+	}
+
+	for i, node := range nodes {
+		if i > 0 && nodes[i-1].Score() > node.Score() {
+			return fmt.Errorf("%w: sorted set %s is out of order at rank %d", ErrInvariantViolated, bucket, i+1)
+		}
+		if ss.Dict[node.Key()] != node {
+			return fmt.Errorf("%w: sorted set %s member %s is not indexed by the dict", ErrInvariantViolated, bucket, node.Key())
+		}
+		if rank := ss.FindRank(node.Key()); rank != i+1 {
+			return fmt.Errorf("%w: sorted set %s member %s has rank %d instead of %d", ErrInvariantViolated, bucket, node.Key(), rank, i+1)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+//go:build nutsdb_debug
+
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_DebugCheckInvariants(t *testing.T) {
+	for _, mode := range []EntryIdxMode{HintKeyValAndRAMIdxMode, HintKeyAndRAMIdxMode} {
+		InitOpt("/tmp/nutsdbtestinvariants", true)
+		opt.EntryIdxMode = mode
+		opt.SegmentSize = 1024
+		db, err = Open(opt)
+		require.NoError(t, err)
+
+		err = db.Update(func(tx *Tx) error {
+			for i := 0; i < 10; i++ {
+				key := []byte(fmt.Sprintf("key_%d", i))
+				if err := tx.Put("bucket", key, []byte("value"), Persistent); err != nil {
+					return err
+				}
+				if err := tx.RPush("list", []byte("key"), key); err != nil {
+					return err
+				}
+				if err := tx.ZAdd("zset", key, float64(i), []byte("value")); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		err = db.Update(func(tx *Tx) error {
+			return tx.Delete("bucket", []byte("key_0"))
+		})
+		require.NoError(t, err)
+		require.NoError(t, db.Merge())
+		assert.NoError(t, db.DebugCheckInvariants())
+
+		db.Index.getList("list").TTL["key"] = 10
+		assert.True(t, errors.Is(db.DebugCheckInvariants(), ErrInvariantViolated))
+		delete(db.Index.getList("list").TTL, "key")
+
+		delete(db.SortedSetIdx["zset"].Dict, "key_1")
+		assert.True(t, errors.Is(db.DebugCheckInvariants(), ErrInvariantViolated))
+		require.NoError(t, db.Close())
+	}
+}
+
+func TestDB_DebugCheckInvariants_StaleHint(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestinvariants", true)
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	err = db.Update(func(tx *Tx) error {
+		if err := tx.Put("bucket", []byte("key_1"), []byte("value"), Persistent); err != nil {
+			return err
+		}
+		return tx.Put("bucket", []byte("key_2"), []byte("value"), Persistent)
+	})
+	require.NoError(t, err)
+
+	r, err := db.BPTreeIdx["bucket"].Find([]byte("key_2"))
+	require.NoError(t, err)
+	r.H.DataPos = 0
+	assert.True(t, errors.Is(db.DebugCheckInvariants(), ErrInvariantViolated))
+	require.NoError(t, db.Close())
+}
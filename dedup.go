@@ -0,0 +1,177 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"context"
+	"encoding/binary"
+)
+
+const dedupBucketKind = "dedup"
+
+// dedupValue returns the value to write for the key of the bucket with the flag and its valueKind, before the write
+// is added to the pending writes. In the DedupBuckets the value the key referred to is released, and a persistent
+// value longer than a reference is stored once in the content bucket of the bucket and replaced by a reference to it,
+// the hash of the value.
+func (tx *Tx) dedupValue(bucket string, key, value []byte, ttl uint32, flag uint16, kind valueKind) ([]byte, valueKind, error) {
+	if !tx.db.opt.DedupBuckets[bucket] || tx.isMerge || tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode ||
+		(flag != DataSetFlag && flag != DataDeleteFlag) {
+		return value, kind, nil
+	}
+
+	contentBucket := internalBucket(dedupBucketKind, bucket)
+	if old, err := tx.lookupStored(bucket, key); err == nil && old.Meta.valueKind() == valueKindRef {
+		if _, err := tx.Release(contentBucket, old.Value); err != nil && err != ErrNotRetained {
+			return nil, valueKindPlain, err
+		}
+	}
+
+	if flag != DataSetFlag || ttl != Persistent || kind != valueKindPlain || len(value) <= tx.db.contentHashSize() {
+		return value, kind, nil
+	}
+
+	hash, err := tx.PutContent(contentBucket, value)
+	if err != nil {
+		return nil, valueKindPlain, err
+	}
+	if _, err := tx.Retain(contentBucket, hash); err != nil {
+		return nil, valueKindPlain, err
+	}
+	return hash, valueKindRef, nil
+}
+
+// dropDedupBucket deletes along with the DedupBuckets bucket the values stored once for its keys.
+func (tx *Tx) dropDedupBucket(bucket string) error {
+	if !tx.db.opt.DedupBuckets[bucket] {
+		return nil
+	}
+
+	contentBucket := internalBucket(dedupBucketKind, bucket)
+	timestamp := tx.db.timestamp()
+	for _, b := range []string{contentBucket, internalBucket(refCountBucketKind, contentBucket)} {
+		if err := tx.put(b, []byte("2"), nil, Persistent, DataBPTreeBucketDeleteFlag, timestamp, DataStructureNone); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveValue returns the entry with the value it refers to when its value is a reference written
+// for the DedupBuckets, the entry itself otherwise. The references are resolved even once the bucket
+// is no longer in the DedupBuckets. The values pointing to the value log are read from it, and the values
+// stored compressed are decompressed whatever the Options.Compression.
+func (tx *Tx) resolveValue(bucket string, e *Entry) (*Entry, error) {
+	if e != nil && e.Meta.codec() != CompressionNone {
+		// a value compressed is never a reference or a pointer.
+		return decompressEntry(e)
+	}
+	if e != nil && isValuePointer(e) {
+		return tx.db.readValueLog(bucket, e)
+	}
+	if e == nil || e.Meta.valueKind() != valueKindRef {
+		return e, nil
+	}
+
+	// the content is looked up resolved, as it may be kept in the value log.
+	content, err := tx.lookup(internalBucket(dedupBucketKind, bucket), e.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := *e.Meta
+	meta.setValueKind(valueKindPlain)
+	meta.ValueSize = uint32(len(content.Value))
+	return &Entry{Key: e.Key, Value: content.Value, Bucket: e.Bucket, Meta: &meta}, nil
+}
+
+// resolveValues resolves the values of the entries in place as resolveValue does.
+func (tx *Tx) resolveValues(bucket string, es Entries) error {
+	for i, e := range es {
+		resolved, err := tx.resolveValue(bucket, e)
+		if err != nil {
+			return err
+		}
+		es[i] = resolved
+	}
+	return nil
+}
+
+// resolveWrites returns the writes of the tx with the references written for the DedupBuckets, the pointers
+// to the value log and the values compressed resolved, the writes themselves when they hold none.
+func (tx *Tx) resolveWrites(writes []*Entry) []*Entry {
+	if len(tx.db.opt.DedupBuckets) == 0 && tx.db.opt.ValueThreshold <= 0 && tx.db.opt.Compression == CompressionNone {
+		return writes
+	}
+
+	var resolved []*Entry
+	for i, e := range writes {
+		if e.Meta.Ds == DataStructureBPTree && (e.Meta.valueKind() != valueKindPlain || e.Meta.codec() != CompressionNone) {
+			if resolved == nil {
+				resolved = append([]*Entry(nil), writes...)
+			}
+			if r, err := tx.resolveValue(string(e.Bucket), e); err == nil {
+				resolved[i] = r
+			}
+		}
+	}
+	if resolved == nil {
+		return writes
+	}
+	return resolved
+}
+
+// contentHashSize returns the size of the hashes computed by contentHash.
+func (db *DB) contentHashSize() int {
+	return len(db.contentHash(nil))
+}
+
+// dedupBytesSaved returns the bytes of the values of the DedupBuckets not written again as they are
+// stored once for several keys.
+func (db *DB) dedupBytesSaved() int64 {
+	var saved int64
+	for bucket := range db.opt.DedupBuckets {
+		contentBucket := internalBucket(dedupBucketKind, bucket)
+		contentIdx, ok := db.BPTreeIdx[contentBucket]
+		if !ok {
+			continue
+		}
+		refCountBucket := internalBucket(refCountBucketKind, contentBucket)
+		refCountIdx, ok := db.BPTreeIdx[refCountBucket]
+		if !ok {
+			continue
+		}
+		records, err := refCountIdx.All()
+		if err != nil {
+			continue
+		}
+
+		for _, r := range records {
+			if r.H.Meta.Flag == DataDeleteFlag {
+				continue
+			}
+			e, err := db.readValue(context.Background(), refCountBucket, r.H.Key, r)
+			if err != nil || len(e.Value) != 8 {
+				continue
+			}
+			count := int64(binary.BigEndian.Uint64(e.Value))
+			content, err := contentIdx.Find(r.H.Key)
+			if err != nil || content == nil || count < 2 {
+				continue
+			}
+			saved += (count - 1) * int64(content.H.Meta.ValueSize)
+		}
+	}
+	return saved
+}
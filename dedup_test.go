@@ -0,0 +1,174 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTx_DedupBuckets(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestdedup", true)
+	opt.SegmentSize = 1024
+	opt.DedupBuckets = map[string]bool{"configs": true}
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	bucket := "configs"
+	contentBucket := internalBucket(dedupBucketKind, bucket)
+	shared := bytes.Repeat([]byte("default config;"), 20)
+	key := func(i int) []byte {
+		return []byte(fmt.Sprintf("user_%02d", i))
+	}
+
+	events, cancel := db.Watch(bucket, nil)
+	defer cancel()
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		for i := 0; i < 10; i++ {
+			if err := tx.Put(bucket, key(i), shared, Persistent); err != nil {
+				return err
+			}
+		}
+		e, err := tx.Get(bucket, key(0))
+		require.NoError(t, err)
+		assert.Equal(t, shared, e.Value)
+		return tx.Put(bucket, []byte("short"), []byte("value"), Persistent)
+	}))
+
+	received, _ := drainEvents(events)
+	require.Len(t, received, 11)
+	assert.Equal(t, shared, received[0].Value)
+
+	check := func(keys int) {
+		require.NoError(t, db.View(func(tx *Tx) error {
+			for i := 0; i < keys; i++ {
+				e, err := tx.Get(bucket, key(i))
+				require.NoError(t, err)
+				assert.Equal(t, shared, e.Value)
+			}
+			e, err := tx.Get(bucket, []byte("short"))
+			require.NoError(t, err)
+			assert.Equal(t, []byte("value"), e.Value)
+
+			es, _, err := tx.PrefixScan(bucket, []byte("user_"), 0, ScanNoLimit)
+			require.NoError(t, err)
+			for i, e := range es {
+				if i < keys {
+					assert.Equal(t, shared, e.Value)
+				} else {
+					assert.Equal(t, []byte("own"), e.Value)
+				}
+			}
+
+			it := NewIterator(tx, bucket, IteratorOptions{PrefetchSize: 4})
+			n := 0
+			for {
+				ok, err := it.SetNext()
+				require.NoError(t, err)
+				if !ok {
+					break
+				}
+				if bytes.Equal(it.Entry().Value, shared) {
+					n++
+				}
+			}
+			assert.Equal(t, keys, n)
+
+			hash := db.contentHash(shared)
+			count, err := tx.RefCount(contentBucket, hash)
+			require.NoError(t, err)
+			assert.Equal(t, uint64(keys), count)
+			return nil
+		}))
+	}
+	check(10)
+
+	stats, err := db.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, int64(9*len(shared)), stats.DedupBytesSaved)
+
+	// the keys overwritten or deleted release the value.
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		for i := 5; i < 10; i++ {
+			if err := tx.Delete(bucket, key(i)); err != nil {
+				return err
+			}
+		}
+		return tx.Put(bucket, key(4), []byte("own"), Persistent)
+	}))
+	check(4)
+
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	check(4)
+
+	// the value is removed by Merge once no key refers to it.
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		for i := 0; i < 4; i++ {
+			if err := tx.Delete(bucket, key(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	require.NoError(t, db.Merge())
+	require.NoError(t, db.View(func(tx *Tx) error {
+		_, err := tx.GetContent(contentBucket, db.contentHash(shared))
+		assert.Error(t, err)
+		return nil
+	}))
+	require.NoError(t, db.Close())
+}
+
+func TestTx_DedupBuckets_DeleteBucket(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestdedupbucket", true)
+	db, err = Open(opt, WithDedupBucket("configs"))
+	require.NoError(t, err)
+
+	bucket := "configs"
+	shared := bytes.Repeat([]byte("x"), 100)
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.Put(bucket, []byte("key"), shared, Persistent)
+	}))
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.DeleteBucket(DataStructureBPTree, bucket)
+	}))
+
+	require.NoError(t, db.View(func(tx *Tx) error {
+		_, err := tx.GetContent(internalBucket(dedupBucketKind, bucket), db.contentHash(shared))
+		assert.Error(t, err)
+		return nil
+	}))
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.Put(bucket, []byte("key"), shared, Persistent)
+	}))
+	require.NoError(t, db.View(func(tx *Tx) error {
+		e, err := tx.Get(bucket, []byte("key"))
+		require.NoError(t, err)
+		assert.Equal(t, shared, e.Value)
+		count, err := tx.RefCount(internalBucket(dedupBucketKind, bucket), db.contentHash(shared))
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1), count)
+		return nil
+	}))
+	require.NoError(t, db.Close())
+}
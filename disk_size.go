@@ -0,0 +1,160 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"io"
+	"os"
+)
+
+// DiskSize splits the size of the data files by what the bytes hold.
+type DiskSize struct {
+	// Live is the size of the entries a merge would keep.
+	Live int64
+
+	// Dead is the size of the entries written but overwritten, deleted or expired since.
+	Dead int64
+
+	// Reserved is the space the data files are preallocated to SegmentSize with and no entry is written to yet.
+	Reserved int64
+}
+
+// Total returns the size of the data files on disk.
+func (s DiskSize) Total() int64 {
+	return s.Live + s.Dead + s.Reserved
+}
+
+// Size returns the on-disk size of the data files split by live, dead and reserved bytes.
+func (db *DB) Size() (DiskSize, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed {
+		return DiskSize{}, ErrDBClosed
+	}
+	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return DiskSize{}, ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	stats, err := db.dataFileStats()
+	if err != nil {
+		return DiskSize{}, err
+	}
+
+	var written int64
+	for _, stat := range stats {
+		written += stat.size
+	}
+	diskBytes, err := db.dataFilesSize()
+	if err != nil {
+		return DiskSize{}, err
+	}
+
+	return splitDiskSize(db.liveBytes(), written, diskBytes), nil
+}
+
+// BucketDiskSize returns the on-disk size of the entries of the bucket split by live and dead bytes.
+// The reserved space of the data files is shared by all the buckets and is left out.
+func (tx *Tx) BucketDiskSize(bucket string) (DiskSize, error) {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return DiskSize{}, err
+	}
+	if tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return DiskSize{}, ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	stats, err := tx.db.dataFileStats()
+	if err != nil {
+		return DiskSize{}, err
+	}
+
+	var written int64
+	for _, stat := range stats {
+		written += stat.bucketSizes[bucket]
+	}
+
+	return splitDiskSize(tx.db.bucketLiveBytes(bucket), written, written), nil
+}
+
+// splitDiskSize splits the disk bytes given the live bytes and the bytes written to the data files.
+// The live bytes are estimated from the indexes and are capped at the written bytes.
+func splitDiskSize(live, written, disk int64) DiskSize {
+	if live > written {
+		live = written
+	}
+	if disk < written {
+		disk = written
+	}
+	return DiskSize{Live: live, Dead: written - live, Reserved: disk - written}
+}
+
+// dataFileStats returns the statistics of every data file. The data files skipped
+// by the recovery as covered by the checkpoints are read to build theirs.
+func (db *DB) dataFileStats() ([]*dataFileStat, error) {
+	_, dataFileIds := db.getMaxFileIDAndFileIDs()
+
+	stats := make([]*dataFileStat, 0, len(dataFileIds))
+	for _, id := range dataFileIds {
+		fID := int64(id)
+		if stat, ok := db.fileStats[fID]; ok {
+			stats = append(stats, stat)
+			continue
+		}
+
+		stat, err := db.readFileStat(fID)
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, stat)
+	}
+	return stats, nil
+}
+
+// readFileStat builds the statistics of the data file at given fID by reading its entries.
+func (db *DB) readFileStat(fID int64) (*dataFileStat, error) {
+	path := db.getDataPath(fID)
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return &dataFileStat{}, nil
+		}
+		return nil, err
+	}
+
+	f, err := newFileRecovery(path, db.opt.BufferSizeOfRecovery)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.release()
+	}()
+
+	stat := &dataFileStat{}
+	for {
+		entry, err := f.readEntry()
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF || err == ErrIndexOutOfBound {
+				return stat, nil
+			}
+			return nil, err
+		}
+		if entry == nil {
+			return stat, nil
+		}
+		if err := decodeEntry(db.keys, db.bucketIDs, entry); err != nil {
+			return nil, err
+		}
+		db.addEntryStat(stat, entry)
+	}
+}
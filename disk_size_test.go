@@ -0,0 +1,94 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_Size(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestdisksize", true)
+	opt.SegmentSize = 1024
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	bucket1, bucket2 := "bucket1", "bucket2"
+	for i := 0; i < 20; i++ {
+		err = db.Update(func(tx *Tx) error {
+			if err := tx.Put(bucket1, []byte(fmt.Sprintf("key_%d", i%5)), []byte("value"), Persistent); err != nil {
+				return err
+			}
+			return tx.Put(bucket2, []byte(fmt.Sprintf("key_%d", i)), []byte("value"), Persistent)
+		})
+		require.NoError(t, err)
+	}
+	entrySize := int64(DataEntryHeaderSize + len(bucket1) + len("key_0") + len("value"))
+	entrySize2 := func(i int) int64 {
+		return int64(DataEntryHeaderSize + len(bucket2) + len(fmt.Sprintf("key_%d", i)) + len("value"))
+	}
+	var bucket2Size int64
+	for i := 0; i < 20; i++ {
+		bucket2Size += entrySize2(i)
+	}
+
+	checkSize := func() {
+		s, err := db.Size()
+		require.NoError(t, err)
+		diskBytes, err := db.dataFilesSize()
+		require.NoError(t, err)
+		assert.Equal(t, 5*entrySize+bucket2Size, s.Live)
+		assert.Equal(t, 15*entrySize, s.Dead)
+		assert.Equal(t, diskBytes, s.Total())
+		assert.True(t, s.Reserved > 0)
+
+		err = db.View(func(tx *Tx) error {
+			s, err := tx.BucketDiskSize(bucket1)
+			require.NoError(t, err)
+			assert.Equal(t, DiskSize{Live: 5 * entrySize, Dead: 15 * entrySize}, s)
+
+			s, err = tx.BucketDiskSize(bucket2)
+			require.NoError(t, err)
+			assert.Equal(t, DiskSize{Live: bucket2Size}, s)
+
+			s, err = tx.BucketDiskSize("missing")
+			require.NoError(t, err)
+			assert.Equal(t, DiskSize{}, s)
+			return nil
+		})
+		require.NoError(t, err)
+	}
+	checkSize()
+
+	// the data files covered by the checkpoint are not read on open
+	require.NoError(t, db.Checkpoint())
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	checkSize()
+
+	require.NoError(t, db.Merge())
+	s, err := db.Size()
+	require.NoError(t, err)
+	assert.Equal(t, 5*entrySize+bucket2Size, s.Live)
+	assert.Equal(t, int64(0), s.Dead)
+	require.NoError(t, db.Close())
+
+	_, err = db.Size()
+	assert.Equal(t, ErrDBClosed, err)
+}
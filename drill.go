@@ -0,0 +1,397 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DrillCorruption is a damage done by CorruptCopy to a copy of the db, to rehearse the recovery from it.
+type DrillCorruption int
+
+const (
+	// DrillFlipCRC flips a bit of the crc of an entry picked by the seed, as a bit rot of the disk does.
+	DrillFlipCRC DrillCorruption = iota
+
+	// DrillTruncateTail truncates the last data file in the middle of its last entry, as a crash
+	// while writing it does.
+	DrillTruncateTail
+
+	// DrillDeleteHints removes the checkpoint files and the index files of the HintBPTSparseIdxMode:
+	// Open replays all the data files without the checkpoints, and fails without the index files.
+	DrillDeleteHints
+)
+
+// EntryPosition is the position of an entry in the data files.
+type EntryPosition struct {
+	FileID int64
+	Offset int64
+}
+
+// VerifyReport is the damage found by Verify in the files of a db.
+type VerifyReport struct {
+	// Entries is the number of the entries passing their crc check.
+	Entries int
+
+	// Corrupted are the positions of the entries failing their crc check.
+	Corrupted []EntryPosition
+
+	// Truncated are the ids of the data files ending in the middle of an entry. The zeros left
+	// at the end of the data files by their preallocation are not an entry.
+	Truncated []int64
+
+	// Checkpoints is the number of the checkpoint files.
+	Checkpoints int
+
+	// MissingIndexFiles are the ids of the data files of the HintBPTSparseIdxMode missing their index files.
+	MissingIndexFiles []int64
+}
+
+// drillEntry is an entry passing its crc check, found by verifyDir.
+type drillEntry struct {
+	pos  EntryPosition
+	size int64
+}
+
+// Verify reads all the entries of the data files of the closed db at dir, and reports the damage found.
+func Verify(dir string) (VerifyReport, error) {
+	report, _, err := verifyDir(dir)
+	return report, err
+}
+
+// VerifyChecksums reads all the entries of the data files of the open db and reports the entries failing
+// their crc check and the sealed data files ending in the middle of an entry, as Verify does for a closed db.
+// It runs along the transactions, reading the active file up to where it ends when VerifyChecksums starts,
+// and skipping the data files removed by a merge meanwhile. It stops with the error of ctx when ctx is done.
+func (db *DB) VerifyChecksums(ctx context.Context) (VerifyReport, error) {
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return VerifyReport{}, ErrDBClosed
+	}
+	activeID, activeOff := db.ActiveFile.fileID, db.ActiveFile.writeOff
+	_, fIDs := db.getMaxFileIDAndFileIDs()
+	db.mu.RUnlock()
+
+	var report VerifyReport
+	sort.Ints(fIDs)
+	for _, id := range fIDs {
+		fID := int64(id)
+		if fID > activeID {
+			break
+		}
+		limit := int64(-1)
+		if fID == activeID {
+			limit = activeOff
+		}
+		err := verifyDataFile(ctx, db.getDataPath(fID), fID, limit, &report, nil)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// QuarantinedEntries returns the positions of the entries failing their crc check left out of the indexes
+// by Open under the Options.QuarantineCorruptEntries, in the order of the data files.
+func (db *DB) QuarantinedEntries() []EntryPosition {
+	return append([]EntryPosition(nil), db.quarantined...)
+}
+
+// verifyDir returns the report of Verify and the entries passing their crc check, in order.
+func verifyDir(dir string) (report VerifyReport, entries []drillEntry, err error) {
+	fIDs, err := dataFileIDs(dir)
+	if err != nil {
+		return report, nil, err
+	}
+
+	for _, fID := range fIDs {
+		path := filepath.Join(dir, strconv.FormatInt(fID, 10)+DataSuffix)
+		if err := verifyDataFile(context.Background(), path, fID, -1, &report, &entries); err != nil {
+			return report, nil, err
+		}
+	}
+
+	checkpoints, err := filepath.Glob(filepath.Join(dir, checkpointDir, "*"+CheckpointSuffix))
+	if err != nil {
+		return report, nil, err
+	}
+	report.Checkpoints = len(checkpoints)
+
+	// the index files are written for the data files before the active one.
+	if _, err := os.Stat(filepath.Join(dir, bptDir)); err == nil && len(fIDs) > 1 {
+		for _, fID := range fIDs[:len(fIDs)-1] {
+			path := filepath.Join(dir, bptDir, "root", strconv.FormatInt(fID, 10)+BPTRootIndexSuffix)
+			if _, err := os.Stat(path); os.IsNotExist(err) {
+				report.MissingIndexFiles = append(report.MissingIndexFiles, fID)
+			}
+		}
+	}
+	return report, entries, nil
+}
+
+// verifyDataFile reads the entries of the data file at path up to limit, or all of them when limit is negative,
+// adds the damage found to the report, and appends the entries passing their crc check to entries unless it is nil.
+func verifyDataFile(ctx context.Context, path string, fID, limit int64, report *VerifyReport, entries *[]drillEntry) error {
+	fr, err := newFileRecovery(path, 0)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = fr.release() }()
+
+	var off int64
+	for limit < 0 || off < limit {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		e, err := fr.readEntry()
+		if err == io.EOF || (err == nil && e == nil) {
+			break
+		}
+		if err == ErrCrc || err == io.ErrUnexpectedEOF {
+			// a damaged header may give a size past the end of the file, the next entry passing its crc check
+			// tells a damaged entry from a torn tail.
+			next, found, resyncErr := nextEntryOffset(path, off)
+			if resyncErr != nil {
+				return resyncErr
+			}
+			if err == ErrCrc || found {
+				report.Corrupted = append(report.Corrupted, EntryPosition{FileID: fID, Offset: off})
+				if err := fr.seek(next); err != nil {
+					return err
+				}
+				off = next
+				continue
+			}
+
+			zero, err := isZeroTail(path, off)
+			if err != nil {
+				return err
+			}
+			if !zero {
+				report.Truncated = append(report.Truncated, fID)
+			}
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("data file %d at %d: %w", fID, off, err)
+		}
+
+		report.Entries++
+		if entries != nil {
+			*entries = append(*entries, drillEntry{pos: EntryPosition{FileID: fID, Offset: off}, size: e.Size()})
+		}
+		off += e.Size()
+	}
+	return nil
+}
+
+// isZeroTail returns whether the bytes of the file at path from off are all zeros.
+func isZeroTail(path string, off int64) (bool, error) {
+	f, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	buf, err := ioutil.ReadAll(io.NewSectionReader(f, off, math.MaxInt64-off))
+	if err != nil {
+		return false, err
+	}
+	for _, b := range buf {
+		if b != 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// dataFileIDs returns the ids of the data files in dir, in ascending order.
+func dataFileIDs(dir string) ([]int64, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*"+DataSuffix))
+	if err != nil {
+		return nil, err
+	}
+
+	var fIDs []int64
+	for _, name := range names {
+		fID, err := strconv.ParseInt(strings.TrimSuffix(filepath.Base(name), DataSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		fIDs = append(fIDs, fID)
+	}
+	sort.Slice(fIDs, func(i, j int) bool { return fIDs[i] < fIDs[j] })
+	return fIDs, nil
+}
+
+// CorruptCopy copies the db into the empty dir as CloneTo does, damages the copy as given by c,
+// and returns the report Verify is expected to give for the copy, so that the recovery procedures
+// are rehearsed against a known damage. The seed picks the entry damaged by DrillFlipCRC.
+func (db *DB) CorruptCopy(dir string, c DrillCorruption, seed int64) (VerifyReport, error) {
+	if err := db.CloneTo(dir); err != nil {
+		return VerifyReport{}, err
+	}
+
+	report, entries, err := verifyDir(dir)
+	if err != nil {
+		return report, err
+	}
+
+	switch c {
+	case DrillFlipCRC:
+		if len(entries) == 0 {
+			return report, nil
+		}
+		e := entries[rand.New(rand.NewSource(seed)).Intn(len(entries))]
+		if err := flipDataFileBit(filepath.Join(dir, strconv.FormatInt(e.pos.FileID, 10)+DataSuffix), e.pos.Offset); err != nil {
+			return report, err
+		}
+		report.Entries--
+		report.Corrupted = append(report.Corrupted, e.pos)
+		sort.Slice(report.Corrupted, func(i, j int) bool {
+			a, b := report.Corrupted[i], report.Corrupted[j]
+			return a.FileID < b.FileID || a.FileID == b.FileID && a.Offset < b.Offset
+		})
+	case DrillTruncateTail:
+		if len(entries) == 0 {
+			return report, nil
+		}
+		e := entries[len(entries)-1]
+		path := filepath.Join(dir, strconv.FormatInt(e.pos.FileID, 10)+DataSuffix)
+		if err := detachFile(path); err != nil {
+			return report, err
+		}
+		if err := os.Truncate(path, e.pos.Offset+e.size/2); err != nil {
+			return report, err
+		}
+		report.Entries--
+		if n := len(report.Truncated); n == 0 || report.Truncated[n-1] != e.pos.FileID {
+			report.Truncated = append(report.Truncated, e.pos.FileID)
+		}
+	case DrillDeleteHints:
+		if err := removeHintFiles(dir); err != nil {
+			return report, err
+		}
+		report.Checkpoints = 0
+		if _, err := os.Stat(filepath.Join(dir, bptDir)); err == nil {
+			fIDs, err := dataFileIDs(dir)
+			if err != nil {
+				return report, err
+			}
+			report.MissingIndexFiles = nil
+			if len(fIDs) > 1 {
+				report.MissingIndexFiles = fIDs[:len(fIDs)-1]
+			}
+		}
+	}
+	return report, nil
+}
+
+// flipDataFileBit flips the lowest bit of the crc of the entry at off of the data file at path.
+func flipDataFileBit(path string, off int64) error {
+	if err := detachFile(path); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Clean(path), os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	b := make([]byte, 1)
+	if _, err := f.ReadAt(b, off); err != nil {
+		_ = f.Close()
+		return err
+	}
+	b[0] ^= 1
+	if _, err := f.WriteAt(b, off); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// detachFile replaces the file at path by a copy of it, so that the sealed data files linked
+// by CloneTo are damaged in the copy only.
+func detachFile(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".detach"
+	if err := copyFile(path, tmp, info.Mode()); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// removeHintFiles removes the checkpoint files and the index files of the HintBPTSparseIdxMode in dir.
+func removeHintFiles(dir string) error {
+	for _, hintDir := range []string{checkpointDir, bptDir} {
+		err := filepath.Walk(filepath.Join(dir, hintDir), func(path string, info os.FileInfo, err error) error {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			return os.Remove(path)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GenerateDrillData writes keys keys of valueSize random bytes into each of the buckets, in transactions
+// of up to 100 keys, so that the drills of CorruptCopy damage a db holding data. The data only depends on the seed.
+func (db *DB) GenerateDrillData(buckets []string, keys, valueSize int, seed int64) error {
+	r := rand.New(rand.NewSource(seed))
+	for _, bucket := range buckets {
+		for from := 0; from < keys; from += 100 {
+			err := db.Update(func(tx *Tx) error {
+				for i := from; i < from+100 && i < keys; i++ {
+					value := make([]byte, valueSize)
+					_, _ = r.Read(value)
+					if err := tx.Put(bucket, []byte(fmt.Sprintf("key_%08d", i)), value, Persistent); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
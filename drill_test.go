@@ -0,0 +1,209 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_CorruptCopy(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestdrill", true)
+	db, err = Open(opt, WithSegmentSize(8*1024))
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.GenerateDrillData([]string{"a", "b"}, 150, 32, 1))
+	require.NoError(t, db.Checkpoint())
+	_, fIDs := db.getMaxFileIDAndFileIDs()
+	require.True(t, len(fIDs) > 1)
+
+	clean, err := Verify(opt.Dir)
+	require.NoError(t, err)
+	assert.Equal(t, 300, clean.Entries)
+	assert.Empty(t, clean.Corrupted)
+	assert.Empty(t, clean.Truncated)
+	assert.Equal(t, 2, clean.Checkpoints)
+
+	drill := func(c DrillCorruption) (string, VerifyReport) {
+		dir := fmt.Sprintf("/tmp/nutsdbtestdrill_%d", c)
+		require.NoError(t, os.RemoveAll(dir))
+		want, err := db.CorruptCopy(dir, c, 7)
+		require.NoError(t, err)
+		got, err := Verify(dir)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+		return dir, got
+	}
+	keys := func(dir string) int {
+		copyOpt := opt
+		copyOpt.Dir = dir
+		copied, err := Open(copyOpt)
+		require.NoError(t, err)
+		defer copied.Close()
+		n := 0
+		require.NoError(t, copied.View(func(tx *Tx) error {
+			for _, bucket := range []string{"a", "b"} {
+				entries, err := tx.GetAll(bucket)
+				require.NoError(t, err)
+				n += len(entries)
+			}
+			return nil
+		}))
+		return n
+	}
+
+	_, report := drill(DrillFlipCRC)
+	assert.Equal(t, 299, report.Entries)
+	assert.Len(t, report.Corrupted, 1)
+
+	_, report = drill(DrillTruncateTail)
+	assert.Equal(t, 299, report.Entries)
+	assert.Equal(t, []int64{int64(fIDs[len(fIDs)-1])}, report.Truncated)
+
+	dir, report := drill(DrillDeleteHints)
+	assert.Equal(t, 300, report.Entries)
+	assert.Equal(t, 0, report.Checkpoints)
+	assert.Equal(t, 300, keys(dir))
+
+	// the source is left intact by the damage done to the copies.
+	after, err := Verify(opt.Dir)
+	require.NoError(t, err)
+	assert.Equal(t, clean, after)
+}
+
+func TestDB_CorruptCopy_SparseIdxMode(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestdrillsparse", true)
+	db, err = Open(opt, WithSegmentSize(8*1024), WithEntryIdxMode(HintBPTSparseIdxMode))
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.GenerateDrillData([]string{"a"}, 300, 32, 1))
+	_, fIDs := db.getMaxFileIDAndFileIDs()
+	require.True(t, len(fIDs) > 1)
+
+	dir := "/tmp/nutsdbtestdrillsparse_copy"
+	require.NoError(t, os.RemoveAll(dir))
+	want, err := db.CorruptCopy(dir, DrillDeleteHints, 0)
+	require.NoError(t, err)
+	assert.Len(t, want.MissingIndexFiles, len(fIDs)-1)
+	got, err := Verify(dir)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	copyOpt := opt
+	copyOpt.Dir = dir
+	_, err = Open(copyOpt)
+	assert.Error(t, err)
+}
+
+func TestDB_VerifyChecksums(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestverifychecksums", true)
+	db, err = Open(opt, WithSegmentSize(8*1024))
+	require.NoError(t, err)
+
+	require.NoError(t, db.GenerateDrillData([]string{"a"}, 150, 32, 1))
+	report, err := db.VerifyChecksums(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 150, report.Entries)
+	assert.Empty(t, report.Corrupted)
+
+	r, err := db.BPTreeIdx["a"].Find([]byte("key_00000010"))
+	require.NoError(t, err)
+	corrupted := EntryPosition{FileID: r.H.FileID, Offset: int64(r.H.DataPos)}
+	fd, err := os.OpenFile(db.getDataPath(corrupted.FileID), os.O_RDWR, 0644)
+	require.NoError(t, err)
+	_, err = fd.WriteAt([]byte{0xff}, corrupted.Offset)
+	require.NoError(t, err)
+	require.NoError(t, fd.Close())
+
+	report, err = db.VerifyChecksums(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 149, report.Entries)
+	assert.Equal(t, []EntryPosition{corrupted}, report.Corrupted)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = db.VerifyChecksums(ctx)
+	assert.Equal(t, context.Canceled, err)
+	require.NoError(t, db.Close())
+
+	// fails by default.
+	_, err = Open(opt, WithSegmentSize(8*1024))
+	assert.Error(t, err)
+
+	db, err = Open(opt, WithSegmentSize(8*1024), WithQuarantineCorruptEntries(true))
+	require.NoError(t, err)
+	assert.Equal(t, []EntryPosition{corrupted}, db.QuarantinedEntries())
+	require.NoError(t, db.View(func(tx *Tx) error {
+		_, err := tx.Get("a", []byte("key_00000011"))
+		assert.NoError(t, err)
+		_, err = tx.Get("a", []byte("key_00000010"))
+		assert.Error(t, err)
+		return nil
+	}))
+	require.NoError(t, db.Close())
+}
+
+func TestDB_QuarantineDamagedHeader(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestquarantineheader", true)
+	db, err = Open(opt, WithSegmentSize(8*1024))
+	require.NoError(t, err)
+
+	require.NoError(t, db.GenerateDrillData([]string{"a"}, 150, 32, 1))
+	r, err := db.BPTreeIdx["a"].Find([]byte("key_00000010"))
+	require.NoError(t, err)
+	corrupted := EntryPosition{FileID: r.H.FileID, Offset: int64(r.H.DataPos)}
+	require.NoError(t, db.Close())
+
+	// the key size of the header now runs past the end of the file.
+	fd, err := os.OpenFile(db.getDataPath(corrupted.FileID), os.O_RDWR, 0644)
+	require.NoError(t, err)
+	_, err = fd.WriteAt([]byte{0xff, 0xff, 0xff, 0x0f}, corrupted.Offset+12)
+	require.NoError(t, err)
+	require.NoError(t, fd.Close())
+
+	report, err := Verify(opt.Dir)
+	require.NoError(t, err)
+	assert.Equal(t, 149, report.Entries)
+	assert.Equal(t, []EntryPosition{corrupted}, report.Corrupted)
+	assert.Empty(t, report.Truncated)
+
+	db, err = Open(opt, WithSegmentSize(8*1024), WithQuarantineCorruptEntries(true))
+	require.NoError(t, err)
+	assert.Equal(t, []EntryPosition{corrupted}, db.QuarantinedEntries())
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.Put("a", []byte("key_new"), []byte("value"), Persistent)
+	}))
+	require.NoError(t, db.Close())
+
+	db, err = Open(opt, WithSegmentSize(8*1024), WithQuarantineCorruptEntries(true))
+	require.NoError(t, err)
+	require.NoError(t, db.View(func(tx *Tx) error {
+		_, err := tx.Get("a", []byte("key_00000010"))
+		assert.Error(t, err)
+		for _, key := range []string{"key_00000011", "key_00000149", "key_new"} {
+			_, err := tx.Get("a", []byte(key))
+			assert.NoError(t, err, key)
+		}
+		return nil
+	}))
+	require.NoError(t, db.Close())
+}
@@ -0,0 +1,391 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package hnsw implements a Hierarchical Navigable Small World graph, an approximate nearest neighbor
+// index of vectors under the cosine distance.
+package hnsw
+
+import (
+	"container/heap"
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+const (
+	// defaultM is the number of neighbors of a node on the layers above the bottom one,
+	// which has twice as many.
+	defaultM = 16
+
+	// defaultEfConstruction is the number of candidates considered for the neighbors of a new node.
+	defaultEfConstruction = 100
+
+	// minCompactDeleted is the number of the deleted nodes below which the graph is never rebuilt.
+	minCompactDeleted = 64
+)
+
+var (
+	// ErrDimension is returned by Add when the vector has not the dimension of the vectors of the index.
+	ErrDimension = errors.New("the vector has not the dimension of the index")
+
+	// ErrEmptyVector is returned by Add when the vector is empty.
+	ErrEmptyVector = errors.New("the vector is empty")
+)
+
+// Result is a key found by Search, with the distance of its vector to the query.
+type Result struct {
+	Key      string
+	Distance float32
+}
+
+// Index is a HNSW graph of the vectors of keys. The removed vectors are kept in the graph to route
+// the searches, until they outnumber the live ones and the graph is rebuilt. An Index is not safe
+// for concurrent use, except for concurrent calls to Search.
+type Index struct {
+	m, efConstruction int
+	levelMult         float64
+	rng               *rand.Rand
+
+	nodes    []*node
+	ids      map[string]int
+	entry    int
+	maxLevel int
+	deleted  int
+	dim      int
+}
+
+// node is a vector of the graph, with its neighbors on every layer up to its level.
+type node struct {
+	key     string
+	vec     []float32
+	friends [][]int
+	deleted bool
+}
+
+// New returns an empty index.
+func New() *Index {
+	return &Index{
+		m:              defaultM,
+		efConstruction: defaultEfConstruction,
+		levelMult:      1 / math.Log(defaultM),
+		rng:            rand.New(rand.NewSource(1)),
+		ids:            make(map[string]int),
+		entry:          -1,
+	}
+}
+
+// Len returns the number of the vectors of the index.
+func (h *Index) Len() int {
+	return len(h.ids)
+}
+
+// Dim returns the dimension of the vectors of the index, zero when it is empty.
+func (h *Index) Dim() int {
+	if len(h.ids) == 0 {
+		return 0
+	}
+	return h.dim
+}
+
+// Add sets the vector of the key.
+func (h *Index) Add(key string, vec []float32) error {
+	if len(vec) == 0 {
+		return ErrEmptyVector
+	}
+	if d := h.Dim(); d != 0 && d != len(vec) {
+		return ErrDimension
+	}
+
+	v := normalize(vec)
+	if id, ok := h.ids[key]; ok {
+		if equal(h.nodes[id].vec, v) {
+			return nil
+		}
+		h.remove(id)
+	}
+	if len(h.ids) == 0 {
+		// the deleted nodes may have another dimension.
+		h.reset()
+	}
+	h.dim = len(vec)
+	h.insert(key, v)
+	h.compact()
+	return nil
+}
+
+// Remove removes the vector of the key, and returns whether there was one.
+func (h *Index) Remove(key string) bool {
+	id, ok := h.ids[key]
+	if !ok {
+		return false
+	}
+	h.remove(id)
+	h.compact()
+	return true
+}
+
+// Search returns the k keys whose vectors are the nearest to the query, the nearest first.
+// ef is the number of candidates considered, the larger the better the recall, at least k are.
+func (h *Index) Search(query []float32, k, ef int) []Result {
+	if h.entry < 0 || k <= 0 || len(query) != h.dim {
+		return nil
+	}
+	if ef < k {
+		ef = k
+	}
+	if h.deleted > 0 {
+		ef *= 2
+	}
+
+	q := normalize(query)
+	ep := h.entry
+	for lc := h.maxLevel; lc > 0; lc-- {
+		ep = h.greedy(q, ep, lc)
+	}
+
+	var results []Result
+	for _, c := range h.searchLayer(q, ep, ef, 0) {
+		n := h.nodes[c.id]
+		if n.deleted {
+			continue
+		}
+		results = append(results, Result{Key: n.key, Distance: c.dist})
+		if len(results) == k {
+			break
+		}
+	}
+	return results
+}
+
+// CosineDistance returns one minus the cosine similarity of the vectors of the same dimension.
+func CosineDistance(a, b []float32) float32 {
+	return distance(normalize(a), normalize(b))
+}
+
+func (h *Index) insert(key string, v []float32) {
+	level := int(math.Floor(-math.Log(1-h.rng.Float64()) * h.levelMult))
+	id := len(h.nodes)
+	n := &node{key: key, vec: v, friends: make([][]int, level+1)}
+	h.nodes = append(h.nodes, n)
+	h.ids[key] = id
+
+	if h.entry < 0 {
+		h.entry, h.maxLevel = id, level
+		return
+	}
+
+	ep := h.entry
+	for lc := h.maxLevel; lc > level; lc-- {
+		ep = h.greedy(v, ep, lc)
+	}
+	for lc := minInt(level, h.maxLevel); lc >= 0; lc-- {
+		candidates := h.searchLayer(v, ep, h.efConstruction, lc)
+		neighbors := make([]int, 0, h.m)
+		for _, c := range candidates {
+			if len(neighbors) == h.m {
+				break
+			}
+			neighbors = append(neighbors, c.id)
+		}
+		n.friends[lc] = neighbors
+		for _, f := range neighbors {
+			h.link(f, id, lc)
+		}
+		ep = candidates[0].id
+	}
+
+	if level > h.maxLevel {
+		h.entry, h.maxLevel = id, level
+	}
+}
+
+// link adds the node at id to the neighbors of the node at from on the layer, and keeps the nearest
+// of them once there are too many.
+func (h *Index) link(from, id, lc int) {
+	f := h.nodes[from]
+	f.friends[lc] = append(f.friends[lc], id)
+
+	max := h.m
+	if lc == 0 {
+		max = 2 * h.m
+	}
+	if len(f.friends[lc]) <= max {
+		return
+	}
+
+	candidates := make([]candidate, len(f.friends[lc]))
+	for i, fid := range f.friends[lc] {
+		candidates[i] = candidate{id: fid, dist: distance(f.vec, h.nodes[fid].vec)}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].dist < candidates[j].dist })
+	f.friends[lc] = f.friends[lc][:0]
+	for _, c := range candidates[:max] {
+		f.friends[lc] = append(f.friends[lc], c.id)
+	}
+}
+
+func (h *Index) remove(id int) {
+	n := h.nodes[id]
+	n.deleted = true
+	delete(h.ids, n.key)
+	h.deleted++
+}
+
+// compact rebuilds the graph from the live vectors once the deleted ones outnumber them.
+func (h *Index) compact() {
+	if h.deleted < minCompactDeleted || h.deleted <= len(h.ids) {
+		return
+	}
+
+	nodes := h.nodes
+	h.reset()
+	for _, n := range nodes {
+		if !n.deleted {
+			h.insert(n.key, n.vec)
+		}
+	}
+}
+
+// reset removes all the nodes of the graph.
+func (h *Index) reset() {
+	h.nodes, h.ids, h.entry, h.maxLevel, h.deleted = nil, make(map[string]int), -1, 0, 0
+}
+
+// greedy returns the node nearest to v reached from ep by moving to nearer neighbors on the layer.
+func (h *Index) greedy(v []float32, ep, lc int) int {
+	best := distance(v, h.nodes[ep].vec)
+	for changed := true; changed; {
+		changed = false
+		for _, f := range h.nodes[ep].friends[lc] {
+			if d := distance(v, h.nodes[f].vec); d < best {
+				ep, best, changed = f, d, true
+			}
+		}
+	}
+	return ep
+}
+
+// searchLayer returns the ef nodes nearest to v found on the layer from ep, the nearest first.
+func (h *Index) searchLayer(v []float32, ep, ef, lc int) []candidate {
+	visited := map[int]struct{}{ep: {}}
+	start := candidate{id: ep, dist: distance(v, h.nodes[ep].vec)}
+	candidates := &minHeap{start}
+	results := &maxHeap{start}
+
+	for candidates.Len() > 0 {
+		c := heap.Pop(candidates).(candidate)
+		if c.dist > (*results)[0].dist && results.Len() >= ef {
+			break
+		}
+		for _, f := range h.nodes[c.id].friends[lc] {
+			if _, ok := visited[f]; ok {
+				continue
+			}
+			visited[f] = struct{}{}
+
+			d := distance(v, h.nodes[f].vec)
+			if results.Len() < ef || d < (*results)[0].dist {
+				heap.Push(candidates, candidate{id: f, dist: d})
+				heap.Push(results, candidate{id: f, dist: d})
+				if results.Len() > ef {
+					heap.Pop(results)
+				}
+			}
+		}
+	}
+
+	nearest := make([]candidate, results.Len())
+	for i := len(nearest) - 1; i >= 0; i-- {
+		nearest[i] = heap.Pop(results).(candidate)
+	}
+	return nearest
+}
+
+// candidate is a node met by a search, with its distance to the query.
+type candidate struct {
+	id   int
+	dist float32
+}
+
+type minHeap []candidate
+
+func (q minHeap) Len() int            { return len(q) }
+func (q minHeap) Less(i, j int) bool  { return q[i].dist < q[j].dist }
+func (q minHeap) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *minHeap) Push(x interface{}) { *q = append(*q, x.(candidate)) }
+func (q *minHeap) Pop() interface{} {
+	old := *q
+	c := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return c
+}
+
+type maxHeap []candidate
+
+func (q maxHeap) Len() int            { return len(q) }
+func (q maxHeap) Less(i, j int) bool  { return q[i].dist > q[j].dist }
+func (q maxHeap) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *maxHeap) Push(x interface{}) { *q = append(*q, x.(candidate)) }
+func (q *maxHeap) Pop() interface{} {
+	old := *q
+	c := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return c
+}
+
+// normalize returns a copy of the vector scaled to the unit length, or of the zero vector.
+func normalize(vec []float32) []float32 {
+	var norm float64
+	for _, x := range vec {
+		norm += float64(x) * float64(x)
+	}
+	v := make([]float32, len(vec))
+	if norm == 0 {
+		return v
+	}
+	norm = math.Sqrt(norm)
+	for i, x := range vec {
+		v[i] = float32(float64(x) / norm)
+	}
+	return v
+}
+
+// distance returns the cosine distance of the unit vectors.
+func distance(a, b []float32) float32 {
+	var dot float32
+	for i := range a {
+		dot += a[i] * b[i]
+	}
+	return 1 - dot
+}
+
+func equal(a, b []float32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,126 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hnsw
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func randomVectors(rng *rand.Rand, n, dim int) [][]float32 {
+	vecs := make([][]float32, n)
+	for i := range vecs {
+		vecs[i] = make([]float32, dim)
+		for j := range vecs[i] {
+			vecs[i][j] = rng.Float32()*2 - 1
+		}
+	}
+	return vecs
+}
+
+// bruteForce returns the keys of the k vectors nearest to the query.
+func bruteForce(vecs map[string][]float32, query []float32, k int) []string {
+	results := make([]Result, 0, len(vecs))
+	for key, vec := range vecs {
+		results = append(results, Result{Key: key, Distance: CosineDistance(query, vec)})
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Distance < results[j].Distance })
+	keys := make([]string, 0, k)
+	for _, r := range results[:k] {
+		keys = append(keys, r.Key)
+	}
+	return keys
+}
+
+// recall returns the share of the keys found by the index among the exact k nearest ones.
+func recall(t *testing.T, h *Index, vecs map[string][]float32, queries [][]float32, k int) float64 {
+	found := 0
+	for _, q := range queries {
+		results := h.Search(q, k, 50)
+		require.Len(t, results, k)
+		exact := make(map[string]struct{})
+		for _, key := range bruteForce(vecs, q, k) {
+			exact[key] = struct{}{}
+		}
+		for _, r := range results {
+			if _, ok := exact[r.Key]; ok {
+				found++
+			}
+		}
+	}
+	return float64(found) / float64(len(queries)*k)
+}
+
+func TestIndex_Search(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	h := New()
+	vecs := make(map[string][]float32)
+	for i, vec := range randomVectors(rng, 2000, 16) {
+		key := fmt.Sprintf("key_%d", i)
+		require.NoError(t, h.Add(key, vec))
+		vecs[key] = vec
+	}
+	assert.Equal(t, 2000, h.Len())
+	assert.Equal(t, 16, h.Dim())
+
+	queries := randomVectors(rng, 50, 16)
+	assert.True(t, recall(t, h, vecs, queries, 10) >= 0.9)
+
+	results := h.Search(vecs["key_42"], 1, 50)
+	require.Len(t, results, 1)
+	assert.Equal(t, "key_42", results[0].Key)
+	assert.InDelta(t, 0, results[0].Distance, 1e-6)
+
+	// the vectors removed are not found, and the graph rebuilt once they outnumber the others.
+	for i := 0; i < 1500; i++ {
+		key := fmt.Sprintf("key_%d", i)
+		assert.True(t, h.Remove(key))
+		delete(vecs, key)
+	}
+	assert.False(t, h.Remove("key_0"))
+	assert.Equal(t, 500, h.Len())
+	assert.True(t, h.deleted < 1500)
+	assert.True(t, recall(t, h, vecs, queries, 10) >= 0.9)
+
+	// a key gets a new vector.
+	require.NoError(t, h.Add("key_1999", vecs["key_1500"]))
+	vecs["key_1999"] = vecs["key_1500"]
+	results = h.Search(vecs["key_1500"], 2, 50)
+	require.Len(t, results, 2)
+	assert.ElementsMatch(t, []string{"key_1500", "key_1999"}, []string{results[0].Key, results[1].Key})
+
+	assert.Equal(t, ErrDimension, h.Add("key_other", []float32{1, 2}))
+	assert.Equal(t, ErrEmptyVector, h.Add("key_other", nil))
+	assert.Empty(t, h.Search([]float32{1, 2}, 1, 10))
+}
+
+func TestIndex_Empty(t *testing.T) {
+	h := New()
+	assert.Empty(t, h.Search([]float32{1}, 1, 10))
+	assert.Equal(t, 0, h.Dim())
+
+	require.NoError(t, h.Add("a", []float32{1, 0}))
+	assert.True(t, h.Remove("a"))
+	assert.Equal(t, 0, h.Dim())
+	require.NoError(t, h.Add("a", []float32{1, 0, 0}))
+	results := h.Search([]float32{1, 0, 0}, 5, 10)
+	require.Len(t, results, 1)
+	assert.Equal(t, "a", results[0].Key)
+}
@@ -33,6 +33,12 @@ var (
 
 	// ErrMinInt is returned when count == math2.MinInt.
 	ErrMinInt = errors.New("err MinInt")
+
+	// ErrRank is returned when LPos is given a rank of 0.
+	ErrRank = errors.New("err rank")
+
+	// ErrPivotNotFound is returned when LInsert does not find the pivot in the list.
+	ErrPivotNotFound = errors.New("the pivot not found")
 )
 
 // List represents the list.
@@ -40,6 +46,10 @@ type List struct {
 	Items     map[string][][]byte
 	TTL       map[string]uint32
 	TimeStamp map[string]uint64
+
+	// Clock returns the unix time the expiry of the lists is checked at, the wall clock when nil.
+	// The replay of the records sets it to the time of the record being replayed.
+	Clock func() int64
 }
 
 // New returns a newly initialized List Object that implements the List.
@@ -141,6 +151,125 @@ func (l *List) LPop(key string) (item []byte, err error) {
 	return nil, errors.New("list is empty")
 }
 
+// LPopIfSize removes and returns the first element of the list stored at key if the list holds size elements.
+// Unlike LPop it does not check the expiry of the list, the caller knows the list was alive when the size was taken.
+func (l *List) LPopIfSize(key string, size int) (item []byte, ok bool) {
+	items, ok := l.LPopNIfSize(key, size, 1)
+	if !ok {
+		return nil, false
+	}
+	return items[0], true
+}
+
+// RPopIfSize removes and returns the last element of the list stored at key if the list holds size elements.
+// Unlike RPop it does not check the expiry of the list, the caller knows the list was alive when the size was taken.
+func (l *List) RPopIfSize(key string, size int) (item []byte, ok bool) {
+	items, ok := l.RPopNIfSize(key, size, 1)
+	if !ok {
+		return nil, false
+	}
+	return items[0], true
+}
+
+// LPopNIfSize removes and returns up to n elements from the head of the list stored at key
+// if the list holds size elements, as LPopIfSize does for a single element.
+func (l *List) LPopNIfSize(key string, size, n int) (popped [][]byte, ok bool) {
+	items := l.Items[key]
+	if size == 0 || len(items) != size || n < 1 {
+		return nil, false
+	}
+	if n > size {
+		n = size
+	}
+	l.Items[key] = items[n:]
+	return items[:n:n], true
+}
+
+// RPopNIfSize removes and returns up to n elements from the tail of the list stored at key
+// if the list holds size elements, the last element first, as RPopIfSize does for a single element.
+func (l *List) RPopNIfSize(key string, size, n int) (popped [][]byte, ok bool) {
+	items := l.Items[key]
+	if size == 0 || len(items) != size || n < 1 {
+		return nil, false
+	}
+	if n > size {
+		n = size
+	}
+	popped = make([][]byte, 0, n)
+	for i := size - 1; i >= size-n; i-- {
+		popped = append(popped, items[i])
+	}
+	l.Items[key] = items[: size-n : size-n]
+	return popped, true
+}
+
+// LPos returns the indexes of the elements of the list stored at key equal to value.
+// The rank selects the match to start from, 1 being the first match from the head and -1 the first
+// match from the tail, and count the number of indexes to return, 0 returning all the matches.
+func (l *List) LPos(key string, value []byte, rank, count int) ([]int, error) {
+	if rank == 0 || rank == math2.MinInt {
+		return nil, ErrRank
+	}
+	if count < 0 {
+		return nil, ErrCount
+	}
+	if l.IsExpire(key) {
+		return nil, ErrListNotFound
+	}
+	items, ok := l.Items[key]
+	if !ok {
+		return nil, ErrListNotFound
+	}
+
+	start, end, step := 0, len(items), 1
+	if rank < 0 {
+		start, end, step = len(items)-1, -1, -1
+		rank = -rank
+	}
+
+	var indexes []int
+	for i := start; i != end; i += step {
+		if !bytes.Equal(items[i], value) {
+			continue
+		}
+		if rank--; rank > 0 {
+			continue
+		}
+		indexes = append(indexes, i)
+		if len(indexes) == count {
+			break
+		}
+	}
+	return indexes, nil
+}
+
+// LInsert inserts value before or after the first element of the list stored at key equal to pivot.
+func (l *List) LInsert(key string, pivot, value []byte, before bool) (size int, err error) {
+	if l.IsExpire(key) {
+		return 0, ErrListNotFound
+	}
+	items, ok := l.Items[key]
+	if !ok {
+		return 0, ErrListNotFound
+	}
+
+	for i, item := range items {
+		if !bytes.Equal(item, pivot) {
+			continue
+		}
+		if !before {
+			i++
+		}
+		newItems := make([][]byte, 0, len(items)+1)
+		newItems = append(newItems, items[:i]...)
+		newItems = append(newItems, value)
+		l.Items[key] = append(newItems, items[i:]...)
+		return len(items) + 1, nil
+	}
+
+	return 0, ErrPivotNotFound
+}
+
 // LPeek returns the first element of the list stored at key.
 func (l *List) LPeek(key string) (item []byte, err error) {
 	if l.IsExpire(key) {
@@ -178,6 +307,19 @@ func (l *List) LRange(key string, start, end int) (list [][]byte, err error) {
 		return
 	}
 
+	start, end, err = RangeBounds(size, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	list = l.Items[key][start : end+1]
+
+	return
+}
+
+// RangeBounds returns the indexes of the first and the last elements LRange returns
+// for the given start and end in a list of the given size.
+func RangeBounds(size, start, end int) (int, int, error) {
 	if start >= 0 && end < 0 {
 		end = size + end
 	}
@@ -195,12 +337,10 @@ func (l *List) LRange(key string, start, end int) (list [][]byte, err error) {
 	}
 
 	if start > end {
-		return nil, errors.New("start or end error")
+		return 0, 0, errors.New("start or end error")
 	}
 
-	list = l.Items[key][start : end+1]
-
-	return
+	return start, end, nil
 }
 
 // LRem removes the first count occurrences of elements equal to value from the list stored at key.
@@ -421,7 +561,7 @@ func (l *List) IsExpire(key string) bool {
 	if !ok {
 		return false
 	}
-	now := time.Now().Unix()
+	now := l.now()
 	timestamp := l.TimeStamp[key]
 	if l.TTL[key] > 0 && uint64(l.TTL[key])+timestamp > uint64(now) || l.TTL[key] == uint32(0) {
 		return false
@@ -432,6 +572,14 @@ func (l *List) IsExpire(key string) bool {
 	return true
 }
 
+// now returns the unix time of the Clock.
+func (l *List) now() int64 {
+	if l.Clock != nil {
+		return l.Clock()
+	}
+	return time.Now().Unix()
+}
+
 func (l *List) IsEmpty(key string) (bool, error) {
 	size, err := l.Size(key)
 	if err != nil || size > 0 {
@@ -449,7 +597,7 @@ func (l *List) GetListTTL(key string) (uint32, error) {
 	if ttl == 0 || timestamp == 0 {
 		return 0, nil
 	}
-	now := time.Now().Unix()
+	now := l.now()
 	remain := timestamp + uint64(ttl) - uint64(now)
 
 	return uint32(remain), nil
@@ -556,3 +556,98 @@ func TestList_IsEmpty(t *testing.T) {
 	assertions.Nil(err, "TestList_IsEmpty empty err")
 	assertions.Equal(true, r, "IsEmpty failed on empty list")
 }
+
+func TestList_PopIfSize(t *testing.T) {
+	list, key := InitListData()
+
+	_, ok := list.LPopIfSize(key, 3)
+	assert.False(t, ok)
+	item, ok := list.LPopIfSize(key, 4)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("a"), item)
+	item, ok = list.RPopIfSize(key, 3)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("d"), item)
+
+	// the expiry is checked at the Clock but not by the pops.
+	list.TTL[key] = 10
+	list.TimeStamp[key] = 100
+	list.Clock = func() int64 { return 105 }
+	assert.False(t, list.IsExpire(key))
+	list.Clock = func() int64 { return 110 }
+	item, ok = list.LPopIfSize(key, 2)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("b"), item)
+	assert.True(t, list.IsExpire(key))
+}
+
+func TestList_LInsert(t *testing.T) {
+	list, key := InitListData()
+
+	size, err := list.LInsert(key, []byte("b"), []byte("x"), true)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, size)
+	size, err = list.LInsert(key, []byte("d"), []byte("y"), false)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, size)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("x"), []byte("b"), []byte("c"), []byte("d"), []byte("y")}, list.Items[key])
+
+	_, err = list.LInsert(key, []byte("z"), []byte("x"), true)
+	assert.Equal(t, ErrPivotNotFound, err)
+	_, err = list.LInsert("missing", []byte("a"), []byte("x"), true)
+	assert.Equal(t, ErrListNotFound, err)
+}
+
+func TestList_LPos(t *testing.T) {
+	list := New()
+	key := "myList"
+	_, _ = list.RPush(key, []byte("a"), []byte("b"), []byte("a"), []byte("c"), []byte("a"))
+
+	tests := []struct {
+		name        string
+		rank, count int
+		want        []int
+		wantErr     error
+	}{
+		{"first", 1, 1, []int{0}, nil},
+		{"all", 1, 0, []int{0, 2, 4}, nil},
+		{"second", 2, 1, []int{2}, nil},
+		{"from second", 2, 0, []int{2, 4}, nil},
+		{"last", -1, 1, []int{4}, nil},
+		{"from tail", -1, 2, []int{4, 2}, nil},
+		{"rank beyond matches", 4, 0, nil, nil},
+		{"rank 0", 0, 1, nil, ErrRank},
+		{"negative count", 1, -1, nil, ErrCount},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := list.LPos(key, []byte("a"), tt.rank, tt.count)
+			assert.Equal(t, tt.wantErr, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	got, err := list.LPos(key, []byte("z"), 1, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, got)
+	_, err = list.LPos("missing", []byte("a"), 1, 0)
+	assert.Equal(t, ErrListNotFound, err)
+}
+
+func TestList_PopNIfSize(t *testing.T) {
+	list, key := InitListData()
+
+	_, ok := list.LPopNIfSize(key, 3, 2)
+	assert.False(t, ok)
+	_, ok = list.LPopNIfSize(key, 4, 0)
+	assert.False(t, ok)
+
+	items, ok := list.LPopNIfSize(key, 4, 2)
+	assert.True(t, ok)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b")}, items)
+
+	items, ok = list.RPopNIfSize(key, 2, 5)
+	assert.True(t, ok)
+	assert.Equal(t, [][]byte{[]byte("d"), []byte("c")}, items)
+	assert.Empty(t, list.Items[key])
+}
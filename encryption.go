@@ -0,0 +1,541 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// sealOverhead is the size added to the payload of an entry by its encryption: the id of the data key
+// it is encrypted with, and the nonce and the tag of AES-GCM.
+const sealOverhead = 4 + 12 + 16
+
+// dataKeysFileName is the name of the file holding the data keys of the buckets, wrapped by the encryption key.
+const dataKeysFileName = "data_keys"
+
+// dataKeyRecordHeaderSize is the size of the crc, the id, the bucket size and the wrapped key size
+// of a record of the data keys file.
+const dataKeyRecordHeaderSize = 16
+
+// dataKeySize is the size of the AES keys generated for the buckets.
+const dataKeySize = 32
+
+var (
+	// ErrDecrypt is returned when an entry cannot be decrypted with the encryption keys of the options.
+	ErrDecrypt = errors.New("the entry cannot be decrypted with the encryption keys")
+
+	// ErrEncryptionKeyMismatch is returned by RotateEncryptionKey when the old key is not the current one.
+	ErrEncryptionKeyMismatch = errors.New("the old encryption key is not the current one")
+
+	// ErrNotSupportEncryption is returned when a feature keeping plaintext on disk is used along with the encryption.
+	ErrNotSupportEncryption = errors.New("not supported along with the encryption at rest")
+
+	// ErrNoEncryptionKey is returned by RotateMasterKey when the db or the new key is not encrypted.
+	ErrNoEncryptionKey = errors.New("the data keys are only wrapped by an encryption key")
+)
+
+// dataKey is the key the entries of a bucket are encrypted with, generated for the bucket.
+type dataKey struct {
+	id     uint32
+	bucket string
+	key    []byte
+	aead   cipher.AEAD
+	saved  bool
+}
+
+// keyring holds the data keys encrypting and decrypting the payloads of the entries, and the encryption keys
+// of the options wrapping them in the data keys file. Like the bucket ids, a data key is appended to the file
+// before any entry using it is written. It is shared by the db and its data files, the encryption keys only
+// change under the write lock, by RotateEncryptionKey and RotateMasterKey.
+type keyring struct {
+	mu   sync.RWMutex
+	path string
+
+	// encrypt is whether the entries written are encrypted, with the current data keys of their buckets.
+	encrypt bool
+
+	// masters are the ciphers of the encryption keys the data keys may be wrapped with, the current one first.
+	masters []cipher.AEAD
+
+	keys    map[uint32]*dataKey
+	buckets map[string]*dataKey // the current data key of every bucket
+	nextID  uint32
+}
+
+// newKeyring returns the keyring of the encryption keys of the options, with the data keys of the file in dir.
+func newKeyring(dir string, opt Options) (*keyring, error) {
+	k := &keyring{
+		path:    filepath.Join(dir, dataKeysFileName),
+		keys:    make(map[uint32]*dataKey),
+		buckets: make(map[string]*dataKey),
+		nextID:  1,
+	}
+	if opt.EncryptionKey == nil && len(opt.DecryptionKeys) == 0 {
+		return k, nil
+	}
+	if opt.EntryIdxMode == HintBPTSparseIdxMode || opt.CompactBucketIDs {
+		return nil, ErrNotSupportEncryption
+	}
+
+	if opt.EncryptionKey != nil {
+		aead, err := newAEAD(opt.EncryptionKey)
+		if err != nil {
+			return nil, err
+		}
+		k.encrypt = true
+		k.masters = append(k.masters, aead)
+	}
+	for _, key := range opt.DecryptionKeys {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		k.masters = append(k.masters, aead)
+	}
+	return k, k.load()
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// load reads the data keys file and unwraps its keys. A torn record left by a crash is truncated,
+// it was never followed by an entry using its key.
+func (k *keyring) load() error {
+	buf, err := ioutil.ReadFile(filepath.Clean(k.path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	off := 0
+	for off+dataKeyRecordHeaderSize <= len(buf) {
+		id := binary.LittleEndian.Uint32(buf[off+4 : off+8])
+		bucketSize := int(binary.LittleEndian.Uint32(buf[off+8 : off+12]))
+		wrappedSize := int(binary.LittleEndian.Uint32(buf[off+12 : off+16]))
+		start := off + dataKeyRecordHeaderSize + bucketSize
+		end := start + wrappedSize
+		if bucketSize < 0 || wrappedSize < 12 || end > len(buf) ||
+			binary.LittleEndian.Uint32(buf[off:off+4]) != crc32.ChecksumIEEE(buf[off+4:end]) {
+			break
+		}
+
+		key, err := k.unwrap(buf[off+4:start], buf[start:end])
+		if err != nil {
+			return err
+		}
+		if err := k.add(string(buf[off+dataKeyRecordHeaderSize:start]), id, key, true); err != nil {
+			return err
+		}
+		off = end
+	}
+
+	if off < len(buf) {
+		return os.Truncate(k.path, int64(off))
+	}
+	return nil
+}
+
+// unwrap returns the data key wrapped by one of the encryption keys, the header of its record authenticated along.
+func (k *keyring) unwrap(header, wrapped []byte) ([]byte, error) {
+	for _, master := range k.masters {
+		if key, err := master.Open(nil, wrapped[:12], wrapped[12:], header); err == nil {
+			return key, nil
+		}
+	}
+	return nil, ErrDecrypt
+}
+
+// add adds the data key of the bucket, which becomes its current one.
+func (k *keyring) add(bucket string, id uint32, key []byte, saved bool) error {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+	dk := &dataKey{id: id, bucket: bucket, key: key, aead: aead, saved: saved}
+	k.keys[id] = dk
+	k.buckets[bucket] = dk
+	if id >= k.nextID {
+		k.nextID = id + 1
+	}
+	return nil
+}
+
+// prepare generates the data key of the bucket unless it has one, before the entries of the bucket are sealed.
+func (k *keyring) prepare(bucket string) error {
+	k.mu.RLock()
+	_, ok := k.buckets[bucket]
+	k.mu.RUnlock()
+	if ok {
+		return nil
+	}
+
+	key := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.buckets[bucket]; ok {
+		return nil
+	}
+	return k.add(bucket, k.nextID, key, false)
+}
+
+// record returns the record of the data key in the data keys file, wrapped by the current encryption key.
+func (k *keyring) record(dk *dataKey) []byte {
+	master := k.masters[0]
+	record := make([]byte, dataKeyRecordHeaderSize+len(dk.bucket), dataKeyRecordHeaderSize+len(dk.bucket)+sealOverhead-4+len(dk.key))
+	binary.LittleEndian.PutUint32(record[4:8], dk.id)
+	binary.LittleEndian.PutUint32(record[8:12], uint32(len(dk.bucket)))
+	binary.LittleEndian.PutUint32(record[12:16], uint32(master.NonceSize()+len(dk.key)+master.Overhead()))
+	copy(record[dataKeyRecordHeaderSize:], dk.bucket)
+
+	nonce := make([]byte, master.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		// the system randomness never fails but on a broken platform, which cannot go on safely.
+		panic(err)
+	}
+	record = append(record, nonce...)
+	record = master.Seal(record, nonce, dk.key, record[4:dataKeyRecordHeaderSize+len(dk.bucket)])
+	binary.LittleEndian.PutUint32(record[0:4], crc32.ChecksumIEEE(record[4:]))
+	return record
+}
+
+// save appends the data keys generated since the last save to the data keys file and syncs it.
+func (k *keyring) save() error {
+	if k == nil {
+		return nil
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	var (
+		buf     []byte
+		pending []*dataKey
+	)
+	for _, dk := range k.keys {
+		if !dk.saved {
+			buf = append(buf, k.record(dk)...)
+			pending = append(pending, dk)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	f, err := os.OpenFile(filepath.Clean(k.path), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	for _, dk := range pending {
+		dk.saved = true
+	}
+	return nil
+}
+
+// rewrite replaces the data keys file by one holding the data keys kept, wrapped by the current encryption key,
+// and removes it when none is kept. k.mu must be held.
+func (k *keyring) rewrite(keep func(dk *dataKey) bool) error {
+	for id, dk := range k.keys {
+		if !keep(dk) {
+			delete(k.keys, id)
+			if k.buckets[dk.bucket] == dk {
+				delete(k.buckets, dk.bucket)
+			}
+		}
+	}
+	if len(k.keys) == 0 {
+		if err := os.Remove(k.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return syncDir(filepath.Dir(k.path))
+	}
+
+	// the keys are written in the order of their ids, so that the current key of a bucket is read last.
+	ids := make([]uint32, 0, len(k.keys))
+	for id := range k.keys {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	var buf []byte
+	for _, id := range ids {
+		buf = append(buf, k.record(k.keys[id])...)
+	}
+
+	tmp := k.path + ".tmp"
+	f, err := os.OpenFile(filepath.Clean(tmp), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, k.path); err != nil {
+		return err
+	}
+	for _, dk := range k.keys {
+		dk.saved = true
+	}
+	return syncDir(filepath.Dir(k.path))
+}
+
+// snapshot returns the content of the data keys file, nil when there is none.
+func (k *keyring) snapshot() ([]byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	buf, err := ioutil.ReadFile(filepath.Clean(k.path))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return buf, err
+}
+
+// enabled returns whether entries may be encrypted, in which case nothing is written in plain on disk.
+func (k *keyring) enabled() bool {
+	return k != nil && len(k.masters) > 0
+}
+
+// seal returns the payload of an entry of the bucket encrypted with a random nonce by the current data key
+// of the bucket, prefixed by the id of the key. The header of the entry is authenticated with it.
+func (k *keyring) seal(bucket string, header, payload []byte) []byte {
+	k.mu.RLock()
+	dk, ok := k.buckets[bucket]
+	k.mu.RUnlock()
+	if !ok {
+		// the data keys are generated before the entries are added to the pending writes.
+		panic("nutsdb: no data key for bucket " + bucket)
+	}
+
+	sealed := make([]byte, 4+dk.aead.NonceSize(), sealOverhead+len(payload))
+	binary.LittleEndian.PutUint32(sealed, dk.id)
+	if _, err := io.ReadFull(rand.Reader, sealed[4:]); err != nil {
+		// the system randomness never fails but on a broken platform, which cannot go on safely.
+		panic(err)
+	}
+	return dk.aead.Seal(sealed, sealed[4:], payload, append(header[:len(header):len(header)], sealed[:4]...))
+}
+
+// openEntry decrypts the payload of the entry read, which ParsePayload left whole in its Value,
+// into its bucket, key and value. The entries written in plain are left as they are.
+func (k *keyring) openEntry(e *Entry) error {
+	if e == nil || !e.Meta.sealed() {
+		return nil
+	}
+	if k == nil || len(e.Value) < sealOverhead {
+		return ErrDecrypt
+	}
+
+	k.mu.RLock()
+	dk, ok := k.keys[binary.LittleEndian.Uint32(e.Value)]
+	k.mu.RUnlock()
+	if !ok {
+		return ErrDecrypt
+	}
+
+	header := e.setEntryHeaderBuf(make([]byte, DataEntryHeaderSize))[4:]
+	nonce, sealed := e.Value[4:16], e.Value[16:]
+	payload, err := dk.aead.Open(nil, nonce, sealed, append(header, e.Value[:4]...))
+	if err != nil {
+		return ErrDecrypt
+	}
+	bucketSize, keySize := e.Meta.bucketSize(), e.Meta.KeySize
+	e.Bucket = payload[:bucketSize]
+	e.Key = payload[bucketSize : bucketSize+keySize]
+	e.Value = payload[bucketSize+keySize:]
+	return nil
+}
+
+// decodeEntry decrypts the entry read from a data file and resolves the name of its bucket.
+func decodeEntry(keys *keyring, bucketIDs *bucketIDTable, e *Entry) error {
+	if err := keys.openEntry(e); err != nil {
+		return err
+	}
+	return bucketIDs.resolve(e)
+}
+
+// RotateEncryptionKey makes newKey the key the entries are encrypted with, in place of oldKey, and rewrites
+// all the data files as Merge does, one file per transaction, so that every entry is encrypted with a new
+// data key of its bucket, wrapped by newKey, and no data key wrapped by oldKey is left once it returns.
+// A nil oldKey encrypts a db written in plain, a nil newKey decrypts it. Until it returns the data keys
+// are wrapped by newKey: should it fail, the db is opened again with newKey as Options.EncryptionKey and
+// oldKey in Options.DecryptionKeys, and RotateEncryptionKey is run again. To only change the key wrapping
+// the data keys, without rewriting the data files, use RotateMasterKey.
+func (db *DB) RotateEncryptionKey(oldKey, newKey []byte) error {
+	return db.RotateEncryptionKeyWithContext(context.Background(), oldKey, newKey)
+}
+
+// RotateEncryptionKeyWithContext is like RotateEncryptionKey, it stops before rewriting the next file once the ctx is done.
+func (db *DB) RotateEncryptionKeyWithContext(ctx context.Context, oldKey, newKey []byte) error {
+	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return ErrNotSupportHintBPTSparseIdxMode
+	}
+	if db.opt.CompactBucketIDs && newKey != nil {
+		return ErrNotSupportEncryption
+	}
+	if db.openBuckets != nil {
+		return ErrPartiallyOpen
+	}
+
+	var aead cipher.AEAD
+	if newKey != nil {
+		var err error
+		if aead, err = newAEAD(newKey); err != nil {
+			return err
+		}
+	}
+
+	db.mergeMu.Lock()
+	defer db.mergeMu.Unlock()
+
+	var (
+		fileIDs []int
+		retired = make(map[uint32]struct{})
+	)
+	err := db.Update(func(tx *Tx) error {
+		if !bytes.Equal(oldKey, db.opt.EncryptionKey) {
+			return ErrEncryptionKeyMismatch
+		}
+
+		k := db.keys
+		k.mu.Lock()
+		defer k.mu.Unlock()
+		for id := range k.keys {
+			retired[id] = struct{}{}
+		}
+		if aead != nil {
+			// the data keys in use are wrapped by the new key before any is generated,
+			// the rewritten entries are encrypted with new data keys.
+			masters := k.masters
+			k.masters = append([]cipher.AEAD{aead}, k.masters...)
+			if err := k.rewrite(func(*dataKey) bool { return true }); err != nil {
+				k.masters = masters
+				return err
+			}
+			k.buckets = make(map[string]*dataKey)
+		}
+		db.opt.EncryptionKey = newKey
+		k.encrypt = aead != nil
+
+		_, fileIDs = db.getMaxFileIDAndFileIDs()
+		db.sortMergeFileIDs(fileIDs)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	compacted := make(map[string]struct{})
+	for _, fID := range fileIDs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := db.mergeDataFile(ctx, int64(fID), compacted); err != nil {
+			return err
+		}
+	}
+
+	// the entries are all encrypted with the new data keys.
+	return db.Update(func(tx *Tx) error {
+		k := db.keys
+		k.mu.Lock()
+		defer k.mu.Unlock()
+		k.masters = nil
+		if aead != nil {
+			k.masters = []cipher.AEAD{aead}
+		}
+		return k.rewrite(func(dk *dataKey) bool {
+			_, ok := retired[dk.id]
+			return !ok
+		})
+	})
+}
+
+// RotateMasterKey makes newKey the key wrapping the data keys of the buckets, in place of oldKey, which
+// must be the current Options.EncryptionKey. Only the data keys file is rewritten, atomically, the entries
+// stay encrypted with the same data keys: the db is opened with newKey once it returns, and with oldKey
+// should it fail.
+func (db *DB) RotateMasterKey(oldKey, newKey []byte) error {
+	if newKey == nil {
+		return ErrNoEncryptionKey
+	}
+	aead, err := newAEAD(newKey)
+	if err != nil {
+		return err
+	}
+
+	return db.Update(func(tx *Tx) error {
+		if !bytes.Equal(oldKey, db.opt.EncryptionKey) {
+			return ErrEncryptionKeyMismatch
+		}
+		if oldKey == nil {
+			return ErrNoEncryptionKey
+		}
+
+		k := db.keys
+		k.mu.Lock()
+		defer k.mu.Unlock()
+		masters := k.masters
+		k.masters = []cipher.AEAD{aead}
+		if err := k.rewrite(func(*dataKey) bool { return true }); err != nil {
+			k.masters = masters
+			return err
+		}
+		db.opt.EncryptionKey = newKey
+		return nil
+	})
+}
@@ -0,0 +1,206 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// assertNoPlaintext checks that none of the data files of the db holds any of the words.
+func assertNoPlaintext(t *testing.T, dir string, words ...string) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+DataSuffix))
+	require.NoError(t, err)
+	require.NotEmpty(t, paths)
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		for _, word := range words {
+			assert.False(t, bytes.Contains(data, []byte(word)), "%s holds %q", filepath.Base(path), word)
+		}
+	}
+}
+
+func writeSecrets(t *testing.T) {
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		for i := 0; i < 10; i++ {
+			if err := tx.Put("accounts", []byte(fmt.Sprintf("account_%d", i)), []byte(fmt.Sprintf("balance_%d", i)), Persistent); err != nil {
+				return err
+			}
+		}
+		if err := tx.RPush("histories", []byte("history"), []byte("transfer_one"), []byte("transfer_two")); err != nil {
+			return err
+		}
+		return tx.SAdd("owners", []byte("owner"), []byte("alice_smith"))
+	}))
+}
+
+func checkSecrets(t *testing.T) {
+	require.NoError(t, db.View(func(tx *Tx) error {
+		for i := 0; i < 10; i++ {
+			e, err := tx.Get("accounts", []byte(fmt.Sprintf("account_%d", i)))
+			require.NoError(t, err)
+			assert.Equal(t, []byte(fmt.Sprintf("balance_%d", i)), e.Value)
+		}
+		list, err := tx.LRange("histories", []byte("history"), 0, -1)
+		require.NoError(t, err)
+		assert.Equal(t, [][]byte{[]byte("transfer_one"), []byte("transfer_two")}, list)
+		members, err := tx.SMembers("owners", []byte("owner"))
+		require.NoError(t, err)
+		assert.Equal(t, [][]byte{[]byte("alice_smith")}, members)
+		return nil
+	}))
+}
+
+func TestDB_Encryption(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	InitOpt("/tmp/nutsdbtestencryption", true)
+	opt.EncryptionKey = key
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	writeSecrets(t)
+	checkSecrets(t)
+	assert.Equal(t, ErrNotSupportEncryption, db.Checkpoint())
+
+	// every bucket is encrypted with its own data key.
+	ids := make(map[uint32]string)
+	for _, bucket := range []string{"accounts", "histories", "owners"} {
+		dk, ok := db.keys.buckets[bucket]
+		require.True(t, ok)
+		ids[dk.id] = bucket
+	}
+	assert.Len(t, ids, 3)
+	require.NoError(t, db.Close())
+	assertNoPlaintext(t, opt.Dir, "accounts", "account_", "balance_", "transfer_", "alice_smith")
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	checkSecrets(t)
+	require.NoError(t, db.Close())
+
+	opt.EncryptionKey = bytes.Repeat([]byte{2}, 32)
+	_, err = Open(opt)
+	assert.Error(t, err)
+
+	opt.EncryptionKey = key
+	opt.CompactBucketIDs = true
+	_, err = Open(opt)
+	assert.Equal(t, ErrNotSupportEncryption, err)
+}
+
+func TestDB_RotateEncryptionKey(t *testing.T) {
+	oldKey, newKey := bytes.Repeat([]byte{1}, 16), bytes.Repeat([]byte{2}, 32)
+	InitOpt("/tmp/nutsdbtestrotatekey", true)
+	opt.SegmentSize = 1024
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	// a db written in plain is encrypted by a rotation from no key.
+	writeSecrets(t)
+	assert.Equal(t, ErrEncryptionKeyMismatch, db.RotateEncryptionKey(newKey, oldKey))
+	require.NoError(t, db.RotateEncryptionKey(nil, oldKey))
+	checkSecrets(t)
+	assertNoPlaintext(t, opt.Dir, "account_", "balance_", "transfer_", "alice_smith")
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.Put("accounts", []byte("account_0"), []byte("balance_0"), Persistent)
+	}))
+	require.NoError(t, db.RotateEncryptionKey(oldKey, newKey))
+	checkSecrets(t)
+	require.NoError(t, db.Close())
+
+	// the entries are all encrypted with the new key.
+	opt.EncryptionKey = newKey
+	db, err = Open(opt)
+	require.NoError(t, err)
+	checkSecrets(t)
+	assertNoPlaintext(t, opt.Dir, "account_", "balance_", "transfer_", "alice_smith")
+
+	// and decrypted by a rotation to no key, which drops the data keys.
+	require.NoError(t, db.RotateEncryptionKey(newKey, nil))
+	require.NoError(t, db.Close())
+	_, err = os.Stat(filepath.Join(opt.Dir, dataKeysFileName))
+	assert.True(t, os.IsNotExist(err))
+	opt.EncryptionKey = nil
+	db, err = Open(opt)
+	require.NoError(t, err)
+	checkSecrets(t)
+	require.NoError(t, db.Close())
+}
+
+func TestDB_RotateMasterKey(t *testing.T) {
+	oldKey, newKey := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+	InitOpt("/tmp/nutsdbtestrotatemasterkey", true)
+	opt.EncryptionKey = oldKey
+	db, err = Open(opt)
+	require.NoError(t, err)
+	writeSecrets(t)
+
+	readDataFiles := func() map[string][]byte {
+		paths, err := filepath.Glob(filepath.Join(opt.Dir, "*"+DataSuffix))
+		require.NoError(t, err)
+		files := make(map[string][]byte)
+		for _, path := range paths {
+			files[path], err = ioutil.ReadFile(path)
+			require.NoError(t, err)
+		}
+		return files
+	}
+	before := readDataFiles()
+
+	assert.Equal(t, ErrEncryptionKeyMismatch, db.RotateMasterKey(newKey, oldKey))
+	assert.Equal(t, ErrNoEncryptionKey, db.RotateMasterKey(oldKey, nil))
+	require.NoError(t, db.RotateMasterKey(oldKey, newKey))
+	checkSecrets(t)
+	require.NoError(t, db.Close())
+
+	// only the data keys are wrapped again, the data files are left as they are.
+	assert.Equal(t, before, readDataFiles())
+	_, err = Open(opt)
+	assert.Equal(t, ErrDecrypt, err)
+
+	opt.EncryptionKey = newKey
+	db, err = Open(opt)
+	require.NoError(t, err)
+	checkSecrets(t)
+
+	// the data keys are backed up along with the data files.
+	for _, backup := range []func(w io.Writer) error{
+		db.BackupTo,
+		func(w io.Writer) error { return db.BackupBucketsTo(w, BackupFilter{}) },
+	} {
+		var buf bytes.Buffer
+		require.NoError(t, backup(&buf))
+		restoreOpt := opt
+		restoreOpt.Dir = "/tmp/nutsdbtestrotatemasterkey_restored"
+		require.NoError(t, os.RemoveAll(restoreOpt.Dir))
+		restored, err := Restore(restoreOpt, &buf)
+		require.NoError(t, err)
+		db, restored = restored, db
+		checkSecrets(t)
+		require.NoError(t, db.Close())
+		db = restored
+	}
+	require.NoError(t, db.Close())
+}
@@ -29,6 +29,12 @@ type (
 		Value  []byte
 		Bucket []byte
 		Meta   *MetaData
+
+		// bucketID is the encoded id written in place of the bucket name, see bucketIDFlag.
+		bucketID []byte
+
+		// keys encrypts the payload of the entry when it is encoded, see bucketSealedFlag.
+		keys *keyring
 	}
 
 	// Hint represents the index of the key
@@ -54,13 +60,84 @@ type (
 	}
 )
 
+// bucketIDFlag is set in the BucketSize of the entries storing the id of their bucket
+// instead of its name, the remaining bits are the size of the encoded id.
+const bucketIDFlag uint32 = 1 << 31
+
+// bucketCodecMask are the bits of the BucketSize holding the Compression of the value of the entry.
+const (
+	bucketCodecMask  uint32 = 7 << bucketCodecShift
+	bucketCodecShift        = 28
+)
+
+// bucketSealedFlag is set in the BucketSize of the entries whose payload is encrypted, see Options.EncryptionKey.
+// The sizes of the header are those of the payload in plain, which is stored after a nonce and followed by a tag.
+const bucketSealedFlag uint32 = 1 << 27
+
+// bucketValueKindMask are the bits of the BucketSize holding the valueKind of the value of the entry.
+const (
+	bucketValueKindMask  uint32 = 3 << bucketValueKindShift
+	bucketValueKindShift        = 25
+)
+
+// valueKind tells what the value of a key/value entry stands for.
+type valueKind uint8
+
+const (
+	// valueKindPlain is a value stored as it is.
+	valueKindPlain valueKind = iota
+
+	// valueKindPointer is a pointer to a value in the value log, see Options.ValueThreshold.
+	valueKindPointer
+
+	// valueKindRef is the hash of a value of a DedupBuckets bucket stored once in its content bucket.
+	valueKindRef
+)
+
+// bucketSize returns the size of the bucket as stored in the data file.
+func (meta *MetaData) bucketSize() uint32 {
+	return meta.BucketSize &^ (bucketIDFlag | bucketCodecMask | bucketSealedFlag | bucketValueKindMask)
+}
+
+// sealed returns if the payload of the entry is encrypted.
+func (meta *MetaData) sealed() bool {
+	return meta.BucketSize&bucketSealedFlag != 0
+}
+
+// codec returns the Compression the value of the entry is stored with.
+func (meta *MetaData) codec() Compression {
+	return Compression((meta.BucketSize & bucketCodecMask) >> bucketCodecShift)
+}
+
+func (meta *MetaData) setCodec(codec Compression) {
+	meta.BucketSize = meta.BucketSize&^bucketCodecMask | uint32(codec)<<bucketCodecShift
+}
+
+// valueKind returns what the value of the entry stands for.
+func (meta *MetaData) valueKind() valueKind {
+	return valueKind((meta.BucketSize & bucketValueKindMask) >> bucketValueKindShift)
+}
+
+func (meta *MetaData) setValueKind(kind valueKind) {
+	meta.BucketSize = meta.BucketSize&^bucketValueKindMask | uint32(kind)<<bucketValueKindShift
+}
+
+// hasBucketID returns if the entry stores the id of its bucket instead of its name.
+func (meta *MetaData) hasBucketID() bool {
+	return meta.BucketSize&bucketIDFlag != 0
+}
+
 func (meta *MetaData) PayloadSize() int64 {
-	return int64(meta.BucketSize) + int64(meta.KeySize) + int64(meta.ValueSize)
+	size := int64(meta.bucketSize()) + int64(meta.KeySize) + int64(meta.ValueSize)
+	if meta.sealed() {
+		size += sealOverhead
+	}
+	return size
 }
 
 // Size returns the size of the entry.
 func (e *Entry) Size() int64 {
-	return int64(DataEntryHeaderSize + e.Meta.KeySize + e.Meta.ValueSize + e.Meta.BucketSize)
+	return DataEntryHeaderSize + e.Meta.PayloadSize()
 }
 
 // Encode returns the slice after the entry be encoded.
@@ -75,18 +152,28 @@ func (e *Entry) Size() int64 {
 func (e *Entry) Encode() []byte {
 	keySize := e.Meta.KeySize
 	valueSize := e.Meta.ValueSize
-	bucketSize := e.Meta.BucketSize
+	bucketSize := e.Meta.bucketSize()
+	bucket := e.Bucket
+	if e.Meta.hasBucketID() {
+		bucket = e.bucketID
+	}
 
 	// set DataItemHeader buf
 	buf := make([]byte, e.Size())
 	buf = e.setEntryHeaderBuf(buf)
 	// set bucket\key\value
-	copy(buf[DataEntryHeaderSize:(DataEntryHeaderSize+bucketSize)], e.Bucket)
+	copy(buf[DataEntryHeaderSize:(DataEntryHeaderSize+bucketSize)], bucket)
 	copy(buf[(DataEntryHeaderSize+bucketSize):(DataEntryHeaderSize+bucketSize+keySize)], e.Key)
 	copy(buf[(DataEntryHeaderSize+bucketSize+keySize):(DataEntryHeaderSize+bucketSize+keySize+valueSize)], e.Value)
 
+	if e.Meta.sealed() {
+		plain := buf[DataEntryHeaderSize : DataEntryHeaderSize+bucketSize+keySize+valueSize]
+		copy(buf[DataEntryHeaderSize:], e.keys.seal(string(e.Bucket), buf[4:DataEntryHeaderSize], plain))
+	}
+
 	c32 := crc32.ChecksumIEEE(buf[4:])
 	binary.LittleEndian.PutUint32(buf[0:4], c32)
+	e.Meta.Crc = c32
 
 	return buf
 }
@@ -125,15 +212,22 @@ func (e *Entry) GetCrc(buf []byte) uint32 {
 	return crc
 }
 
-// ParsePayload means this function will parse a byte array to bucket, key, size of an entry
+// ParsePayload means this function will parse a byte array to bucket, key, size of an entry.
+// The payload of an encrypted entry is left whole in its Value until it is decrypted.
 func (e *Entry) ParsePayload(data []byte) error {
 	meta := e.Meta
+	if meta.sealed() {
+		e.Bucket, e.Key = nil, nil
+		e.Value = data[:meta.PayloadSize()]
+		return nil
+	}
+
 	bucketLowBound := 0
-	bucketHighBound := meta.BucketSize
+	bucketHighBound := meta.bucketSize()
 	keyLowBound := bucketHighBound
-	keyHighBound := meta.BucketSize + meta.KeySize
+	keyHighBound := meta.bucketSize() + meta.KeySize
 	valueLowBound := keyHighBound
-	valueHighBound := meta.BucketSize + meta.KeySize + meta.ValueSize
+	valueHighBound := meta.bucketSize() + meta.KeySize + meta.ValueSize
 
 	// parse bucket
 	e.Bucket = data[bucketLowBound:bucketHighBound]
@@ -168,15 +262,18 @@ func (e *Entry) ParseMeta(buf []byte) error {
 	return nil
 }
 
-// isFilter to confirm if this entry is can be filtered
-func (e *Entry) isFilter() bool {
+// isFilter to confirm if this entry is can be filtered at the unix time now
+func (e *Entry) isFilter(now uint64) bool {
 	meta := e.Meta
 	if meta.Flag == DataDeleteFlag || meta.Flag == DataRPopFlag ||
 		meta.Flag == DataLPopFlag || meta.Flag == DataLRemFlag ||
+		meta.Flag == DataLPopRefFlag || meta.Flag == DataRPopRefFlag ||
+		meta.Flag == DataLPopNFlag || meta.Flag == DataRPopNFlag ||
 		meta.Flag == DataLTrimFlag || meta.Flag == DataZRemFlag ||
-		meta.Flag == DataZRemRangeByRankFlag || meta.Flag == DataZPopMaxFlag ||
+		meta.Flag == DataZRemRangeByRankFlag || meta.Flag == DataZRemRangeByScoreFlag ||
+		meta.Flag == DataZRemRangeByLexFlag || meta.Flag == DataZPopMaxFlag ||
 		meta.Flag == DataZPopMinFlag || meta.Flag == DataLRemByIndex ||
-		IsExpired(meta.TTL, meta.Timestamp) {
+		isExpiredAt(meta.TTL, meta.Timestamp, now) {
 		return true
 	}
 
@@ -0,0 +1,292 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"time"
+)
+
+// ErrExpirationEvent is returned when an expiration event stored in the db cannot be decoded.
+var ErrExpirationEvent = errors.New("invalid expiration event")
+
+const (
+	expirationBucketKind = "expiration"
+
+	// defaultExpiredSweepBatchSize is the number of expired keys deleted by a single transaction
+	// of the reaper and of the sweeper, unless set by Options.ExpiredSweepBatchSize.
+	defaultExpiredSweepBatchSize = 256
+)
+
+var (
+	// expirationEventsBucket holds the expiration events keyed by their big-endian sequence number.
+	expirationEventsBucket = internalBucket(expirationBucketKind, "events")
+
+	// expirationSeqBucket holds the last sequence number given to an event, so that the numbers
+	// are never given again once the events are trimmed.
+	expirationSeqBucket = internalBucket(expirationBucketKind, "seq")
+	expirationSeqKey    = []byte("last")
+)
+
+// expiredKey is a key deleted by the reaper, passed to Options.OnExpired once the delete is committed.
+type expiredKey struct {
+	bucket string
+	key    []byte
+	value  []byte
+}
+
+// ExpirationEvent records a key deleted by the reaper once expired.
+type ExpirationEvent struct {
+	// Seq is the sequence number of the event, it grows with every event and is never reused.
+	Seq uint64
+
+	Bucket string
+	Key    []byte
+
+	// ExpiredAt is when the key expired, by its ttl or by the retention of its bucket.
+	ExpiredAt time.Time
+}
+
+// ReapExpired deletes the expired keys of the key/value buckets and records an ExpirationEvent for each of them,
+// in the same transaction as the delete, so that the events survive restarts along with the deletes.
+// It returns the number of keys deleted, which are passed to Options.OnExpired once their delete is committed.
+// The reaper calls it every Options.ExpirationReapInterval.
+func (db *DB) ReapExpired() (int, error) {
+	return db.ReapExpiredWithContext(context.Background())
+}
+
+// ReapExpiredWithContext is like ReapExpired, the ctx is carried by its transactions and passed to Options.OnExpired.
+func (db *DB) ReapExpiredWithContext(ctx context.Context) (int, error) {
+	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return 0, ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	reaped := 0
+	for {
+		expired, more, err := db.reapExpiredBatch(ctx)
+		reaped += len(expired)
+		if db.opt.OnExpired != nil {
+			for _, k := range expired {
+				db.opt.OnExpired(ctx, k.bucket, k.key, k.value)
+			}
+		}
+		if err != nil || !more {
+			return reaped, err
+		}
+	}
+}
+
+// reapExpiredBatch deletes up to Options.ExpiredSweepBatchSize expired keys, and reports whether more may be left.
+// The values of the keys are only read when Options.OnExpired is set.
+func (db *DB) reapExpiredBatch(ctx context.Context) (expired []expiredKey, more bool, err error) {
+	err = db.UpdateWithContext(ctx, func(tx *Tx) error {
+		seq, err := tx.lastExpirationSeq()
+		if err != nil {
+			return err
+		}
+
+		for bucket, idx := range tx.db.BPTreeIdx {
+			if isInternalBucket(bucket) {
+				continue
+			}
+			records, err := idx.All()
+			if err != nil {
+				continue
+			}
+			for _, r := range records {
+				meta := r.H.Meta
+				if meta.Flag == DataDeleteFlag || !tx.db.isExpired(bucket, meta) {
+					continue
+				}
+				if _, ok := tx.db.committedTxIds[meta.TxID]; !ok {
+					continue
+				}
+				if len(expired) == tx.db.opt.expiredSweepBatchSize() {
+					more = true
+					break
+				}
+
+				key := append([]byte(nil), r.H.Key...)
+				var value []byte
+				if tx.db.opt.OnExpired != nil {
+					// a value that cannot be read is passed as nil rather than blocking the reaper.
+					e, err := tx.db.readValue(tx.ctx, bucket, key, r)
+					if err == nil {
+						e, err = tx.resolveValue(bucket, e)
+					}
+					if err == nil {
+						value = append([]byte(nil), e.Value...)
+					}
+				}
+				expiredAt, _ := tx.db.expiresAt(bucket, meta)
+				seq++
+				if err := tx.put(bucket, key, nil, Persistent, DataDeleteFlag, tx.db.timestamp(), DataStructureBPTree); err != nil {
+					return err
+				}
+				event := encodeExpirationEvent(bucket, key, expiredAt)
+				if err := tx.put(expirationEventsBucket, encodeExpirationSeq(seq), event, Persistent, DataSetFlag, tx.db.timestamp(), DataStructureBPTree); err != nil {
+					return err
+				}
+				expired = append(expired, expiredKey{bucket: bucket, key: key, value: value})
+			}
+			if more {
+				break
+			}
+		}
+
+		if len(expired) == 0 {
+			return nil
+		}
+		return tx.put(expirationSeqBucket, expirationSeqKey, encodeExpirationSeq(seq), Persistent, DataSetFlag, tx.db.timestamp(), DataStructureBPTree)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return expired, more, nil
+}
+
+// lastExpirationSeq returns the last sequence number given to an expiration event.
+func (tx *Tx) lastExpirationSeq() (uint64, error) {
+	e, err := tx.Get(expirationSeqBucket, expirationSeqKey)
+	if err == ErrNotFoundBucket || err == ErrNotFoundKey || err == ErrKeyNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(e.Value), nil
+}
+
+// ExpirationEvents returns up to limit events with a sequence number greater than afterSeq, in order.
+// A consumer processes the events exactly once by persisting the Seq of the last event it processed
+// and passing it as afterSeq, the events stay until TrimExpirationEvents removes them. Zero limit means no limit.
+func (db *DB) ExpirationEvents(afterSeq uint64, limit int) (events []ExpirationEvent, err error) {
+	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return nil, ErrNotSupportHintBPTSparseIdxMode
+	}
+	if afterSeq == math.MaxUint64 {
+		return nil, nil
+	}
+	if limit <= 0 {
+		limit = ScanNoLimit
+	}
+
+	err = db.View(func(tx *Tx) error {
+		idx, ok := tx.db.BPTreeIdx[expirationEventsBucket]
+		if !ok {
+			return nil
+		}
+		records, err := idx.Range(encodeExpirationSeq(afterSeq+1), encodeExpirationSeq(math.MaxUint64))
+		if err != nil {
+			return nil
+		}
+		entries, err := tx.getHintIdxDataItemsWrapper(expirationEventsBucket, records, limit, nil, RangeScan)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			event, err := decodeExpirationEvent(e.Key, e.Value)
+			if err != nil {
+				return err
+			}
+			events = append(events, event)
+		}
+		return nil
+	})
+	return events, err
+}
+
+// TrimExpirationEvents removes the events with a sequence number up to uptoSeq, once all the consumers processed them.
+func (db *DB) TrimExpirationEvents(uptoSeq uint64) error {
+	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	return db.Update(func(tx *Tx) error {
+		idx, ok := tx.db.BPTreeIdx[expirationEventsBucket]
+		if !ok {
+			return nil
+		}
+		records, err := idx.Range(encodeExpirationSeq(0), encodeExpirationSeq(uptoSeq))
+		if err != nil {
+			return nil
+		}
+		for _, r := range records {
+			if r.H.Meta.Flag == DataDeleteFlag {
+				continue
+			}
+			if err := tx.put(expirationEventsBucket, r.H.Key, nil, Persistent, DataDeleteFlag, tx.db.timestamp(), DataStructureBPTree); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// reapExpired runs the reaper every interval until the db is closed, it drops the idle buckets as well.
+func (db *DB) reapExpired(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	ctx, cancel := stopContext(stop)
+	defer cancel()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			// the keys left by a failed pass are reaped by the next tick.
+			_, _ = db.ReapExpiredWithContext(ctx)
+			_, _ = db.DropIdleBuckets()
+		}
+	}
+}
+
+func encodeExpirationSeq(seq uint64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, seq)
+	return buf
+}
+
+// encodeExpirationEvent encodes the event as expiredAt(8) | bucketSize(4) | bucket | key.
+func encodeExpirationEvent(bucket string, key []byte, expiredAt time.Time) []byte {
+	buf := make([]byte, 12+len(bucket)+len(key))
+	binary.BigEndian.PutUint64(buf[0:8], uint64(expiredAt.Unix()))
+	binary.BigEndian.PutUint32(buf[8:12], uint32(len(bucket)))
+	copy(buf[12:], bucket)
+	copy(buf[12+len(bucket):], key)
+	return buf
+}
+
+func decodeExpirationEvent(seq, value []byte) (ExpirationEvent, error) {
+	if len(seq) != 8 || len(value) < 12 {
+		return ExpirationEvent{}, ErrExpirationEvent
+	}
+	bucketSize := int(binary.BigEndian.Uint32(value[8:12]))
+	if len(value) < 12+bucketSize {
+		return ExpirationEvent{}, ErrExpirationEvent
+	}
+
+	return ExpirationEvent{
+		Seq:       binary.BigEndian.Uint64(seq),
+		Bucket:    string(value[12 : 12+bucketSize]),
+		Key:       append([]byte(nil), value[12+bucketSize:]...),
+		ExpiredAt: time.Unix(int64(binary.BigEndian.Uint64(value[0:8])), 0),
+	}, nil
+}
@@ -0,0 +1,167 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func putExpired(t *testing.T, db *DB, bucket string, keys ...string) {
+	expired := uint64(time.Now().Add(-time.Minute).Unix())
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		for _, key := range keys {
+			if err := tx.PutWithTimestamp(bucket, []byte(key), []byte("value"), 10, expired); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+}
+
+func eventSeqs(t *testing.T, db *DB, afterSeq uint64, limit int) []uint64 {
+	events, err := db.ExpirationEvents(afterSeq, limit)
+	require.NoError(t, err)
+	seqs := make([]uint64, len(events))
+	for i, e := range events {
+		seqs[i] = e.Seq
+	}
+	return seqs
+}
+
+func TestDB_ReapExpired(t *testing.T) {
+	bucket := "bucket_reap"
+
+	InitOpt("/tmp/nutsdbtestreap", true)
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	putExpired(t, db, bucket, "key1", "key2")
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.Put(bucket, []byte("key3"), []byte("value"), Persistent)
+	}))
+
+	reaped, err := db.ReapExpired()
+	require.NoError(t, err)
+	assert.Equal(t, 2, reaped)
+
+	events, err := db.ExpirationEvents(0, 0)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	keys := map[string]bool{}
+	for i, e := range events {
+		assert.Equal(t, uint64(i+1), e.Seq)
+		assert.Equal(t, bucket, e.Bucket)
+		assert.WithinDuration(t, time.Now().Add(-50*time.Second), e.ExpiredAt, 2*time.Second)
+		keys[string(e.Key)] = true
+	}
+	assert.Equal(t, map[string]bool{"key1": true, "key2": true}, keys)
+	assert.Equal(t, []uint64{1}, eventSeqs(t, db, 0, 1))
+	assert.Equal(t, []uint64{2}, eventSeqs(t, db, 1, 1))
+
+	// the persistent key is kept and the expired ones are deleted.
+	require.NoError(t, db.View(func(tx *Tx) error {
+		_, err := tx.Get(bucket, []byte("key3"))
+		return err
+	}))
+	require.NoError(t, db.View(func(tx *Tx) error {
+		r, err := tx.db.BPTreeIdx[bucket].Find([]byte("key1"))
+		require.NoError(t, err)
+		assert.Equal(t, DataDeleteFlag, r.H.Meta.Flag)
+		return nil
+	}))
+	reaped, err = db.ReapExpired()
+	require.NoError(t, err)
+	assert.Equal(t, 0, reaped)
+
+	// the events and the sequence numbers survive restarts and trims.
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{1, 2}, eventSeqs(t, db, 0, 0))
+
+	require.NoError(t, db.TrimExpirationEvents(2))
+	assert.Empty(t, eventSeqs(t, db, 0, 0))
+
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	putExpired(t, db, bucket, "key4")
+	_, err = db.ReapExpired()
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{3}, eventSeqs(t, db, 0, 0))
+	assert.Empty(t, eventSeqs(t, db, 3, 0))
+	require.NoError(t, db.Close())
+}
+
+func TestDB_ExpirationReaper(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestreaper", true)
+	db, err := Open(opt, WithExpirationReapInterval(10*time.Millisecond))
+	require.NoError(t, err)
+	defer db.Close()
+
+	putExpired(t, db, "bucket_reaper", "key")
+	require.Eventually(t, func() bool {
+		events, err := db.ExpirationEvents(0, 0)
+		return err == nil && len(events) == 1
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestDB_OnExpired(t *testing.T) {
+	bucket := "bucket_on_expired"
+	expired := make(chan expiredKey, 10)
+
+	InitOpt("/tmp/nutsdbtestonexpired", true)
+	db, err := Open(opt, WithEntryIdxMode(HintKeyAndRAMIdxMode), WithOnExpired(func(ctx context.Context, bucket string, key []byte, value []byte) {
+		// the reaper passes a ctx done once the db is closed.
+		assert.NoError(t, ctx.Err())
+		expired <- expiredKey{bucket: bucket, key: key, value: value}
+	}))
+	require.NoError(t, err)
+	defer db.Close()
+
+	putExpired(t, db, bucket, "key1", "key2")
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.Put(bucket, []byte("key3"), []byte("value"), Persistent)
+	}))
+
+	keys := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case k := <-expired:
+			assert.Equal(t, bucket, k.bucket)
+			assert.Equal(t, []byte("value"), k.value)
+			keys[string(k.key)] = true
+			require.NoError(t, db.View(func(tx *Tx) error {
+				r, err := tx.db.BPTreeIdx[bucket].Find(k.key)
+				require.NoError(t, err)
+				assert.Equal(t, DataDeleteFlag, r.H.Meta.Flag)
+				return nil
+			}))
+		case <-time.After(3 * time.Second):
+			require.FailNow(t, "the expired keys were not passed to OnExpired")
+		}
+	}
+	assert.Equal(t, map[string]bool{"key1": true, "key2": true}, keys)
+
+	reaped, err := db.ReapExpired()
+	require.NoError(t, err)
+	assert.Equal(t, 0, reaped)
+	assert.Len(t, expired, 0)
+}
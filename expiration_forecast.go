@@ -0,0 +1,110 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// ErrForecastWindow is returned by ExpirationForecast when the window is not positive.
+var ErrForecastWindow = errors.New("the forecast window must be positive")
+
+// ExpirationWindow counts the keys expiring in [Start, End).
+type ExpirationWindow struct {
+	Start time.Time
+	End   time.Time
+	Keys  int
+}
+
+// ExpirationForecast returns how many keys of the key/value bucket expire in each future window of the given
+// duration, starting from now. Only the windows where keys expire are returned, in chronological order.
+// The expiration accounts for both the ttl of the keys and the retention of the bucket.
+func (db *DB) ExpirationForecast(bucket string, window time.Duration) ([]ExpirationWindow, error) {
+	if window <= 0 {
+		return nil, ErrForecastWindow
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return nil, ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	idx, ok := db.BPTreeIdx[bucket]
+	if !ok {
+		return nil, ErrNotFoundBucket
+	}
+	records, err := idx.All()
+	if err == ErrScansNoResult {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := db.now()
+	counts := make(map[int64]int)
+	for _, r := range records {
+		if _, ok := db.committedTxIds[r.H.Meta.TxID]; !ok {
+			continue
+		}
+		if r.H.Meta.Flag == DataDeleteFlag || db.isExpired(bucket, r.H.Meta) {
+			continue
+		}
+		expiresAt, ok := db.expiresAt(bucket, r.H.Meta)
+		if !ok {
+			continue
+		}
+		counts[int64(expiresAt.Sub(now)/window)]++
+	}
+
+	windows := make([]ExpirationWindow, 0, len(counts))
+	for i, keys := range counts {
+		start := now.Add(time.Duration(i) * window)
+		windows = append(windows, ExpirationWindow{Start: start, End: start.Add(window), Keys: keys})
+	}
+	sort.Slice(windows, func(i, j int) bool {
+		return windows[i].Start.Before(windows[j].Start)
+	})
+
+	return windows, nil
+}
+
+// expiresAt returns when the key/value entry at given meta of the bucket expires, false if it never does.
+func (db *DB) expiresAt(bucket string, meta *MetaData) (time.Time, bool) {
+	var (
+		expiresAt time.Time
+		expires   bool
+	)
+
+	if meta.TTL != Persistent {
+		expiresAt, expires = time.Unix(int64(meta.Timestamp)+int64(meta.TTL), 0), true
+	}
+
+	if retention := db.opt.BucketRetention[bucket]; retention > 0 {
+		retainedUntil := time.Unix(int64(meta.Timestamp), 0).Add(retention)
+		if !expires || retainedUntil.Before(expiresAt) {
+			expiresAt, expires = retainedUntil, true
+		}
+	}
+
+	return expiresAt, expires
+}
@@ -0,0 +1,75 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_ExpirationForecast(t *testing.T) {
+	bucket := "bucket_forecast"
+
+	InitOpt("/tmp/nutsdbtestexpirationforecast", true)
+	db, err = Open(opt, WithBucketRetention("bucket_retention", 30*time.Minute))
+	require.NoError(t, err)
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		ttls := []uint32{Persistent, 30, 90, 100, 3600 + 30}
+		for i, ttl := range ttls {
+			if err := tx.Put(bucket, []byte(fmt.Sprintf("key_%d", i)), []byte("value"), ttl); err != nil {
+				return err
+			}
+		}
+		if err := tx.Put(bucket, []byte("key_deleted"), []byte("value"), 30); err != nil {
+			return err
+		}
+		return tx.Put("bucket_retention", []byte("key"), []byte("value"), 3600)
+	}))
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.Delete(bucket, []byte("key_deleted"))
+	}))
+
+	windows, err := db.ExpirationForecast(bucket, time.Minute)
+	require.NoError(t, err)
+	keys := make([]int, 0, len(windows))
+	for _, w := range windows {
+		assert.Equal(t, time.Minute, w.End.Sub(w.Start))
+		keys = append(keys, w.Keys)
+	}
+	assert.Equal(t, []int{1, 2, 1}, keys)
+	assert.Equal(t, 60*time.Minute, windows[2].Start.Sub(windows[0].Start))
+
+	// the retention of the bucket comes first.
+	windows, err = db.ExpirationForecast("bucket_retention", time.Minute)
+	require.NoError(t, err)
+	require.Len(t, windows, 1)
+	expiresIn := 30 * time.Minute
+	assert.True(t, windows[0].End.After(time.Now().Add(expiresIn-2*time.Second)))
+	assert.True(t, windows[0].Start.Before(time.Now().Add(expiresIn)))
+
+	_, err = db.ExpirationForecast(bucket, 0)
+	assert.Equal(t, ErrForecastWindow, err)
+	_, err = db.ExpirationForecast("unknown", time.Minute)
+	assert.Equal(t, ErrNotFoundBucket, err)
+
+	require.NoError(t, db.Close())
+	_, err = db.ExpirationForecast(bucket, time.Minute)
+	assert.Equal(t, ErrDBClosed, err)
+}
@@ -0,0 +1,83 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import "time"
+
+// SweepExpired deletes the expired keys of the key/value buckets as ReapExpired does, then the expired lists,
+// in transactions of Options.ExpiredSweepBatchSize keys. It returns the number of keys and lists deleted.
+// The sweeper calls it every Options.ExpiredSweepInterval with ExpiredDeleteActive.
+func (db *DB) SweepExpired() (int, error) {
+	swept, err := db.ReapExpired()
+	if err != nil {
+		return swept, err
+	}
+
+	for {
+		n, more, err := db.sweepExpiredListsBatch()
+		swept += n
+		if err != nil || !more {
+			return swept, err
+		}
+	}
+}
+
+// sweepExpiredListsBatch deletes up to Options.ExpiredSweepBatchSize expired lists, and reports whether more may be left.
+func (db *DB) sweepExpiredListsBatch() (swept int, more bool, err error) {
+	err = db.Update(func(tx *Tx) error {
+		batchSize := tx.db.opt.expiredSweepBatchSize()
+		return tx.db.Index.handleListBucket(func(bucket string) error {
+			if more {
+				return nil
+			}
+			l := tx.db.Index.getList(bucket)
+			for key := range l.TTL {
+				if swept == batchSize {
+					more = true
+					return nil
+				}
+				if !l.IsExpire(key) {
+					continue
+				}
+				// the record is written after the expiry, so that the replay drops the list at it.
+				if err := tx.put(bucket, []byte(key), nil, Persistent, DataDeleteFlag, tx.db.timestamp(), DataStructureList); err != nil {
+					return err
+				}
+				swept++
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	return swept, more, nil
+}
+
+// sweepExpired runs the sweeper every interval until the db is closed.
+func (db *DB) sweepExpired(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			// the keys left by a failed pass are swept by the next tick.
+			_, _ = db.SweepExpired()
+		}
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// putExpiredLists writes lists which expired a minute ago.
+func putExpiredLists(t *testing.T, db *DB, bucket string, keys ...string) {
+	expired := uint64(time.Now().Add(-time.Minute).Unix())
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		for _, key := range keys {
+			if err := tx.put(bucket, []byte(key), []byte("value"), Persistent, DataRPushFlag, expired, DataStructureList); err != nil {
+				return err
+			}
+			if err := tx.put(bucket, []byte(key), []byte("10"), Persistent, DataExpireListFlag, expired, DataStructureList); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+}
+
+func listKeysInIndex(t *testing.T, db *DB, bucket string) map[string]bool {
+	keys := map[string]bool{}
+	require.NoError(t, db.View(func(tx *Tx) error {
+		for key := range tx.db.Index.getList(bucket).Items {
+			keys[key] = true
+		}
+		return nil
+	}))
+	return keys
+}
+
+func TestDB_SweepExpired(t *testing.T) {
+	bucket, listBucket := "bucket_sweep", "bucket_sweep_list"
+
+	InitOpt("/tmp/nutsdbtestsweep", true)
+	db, err := Open(opt, WithExpiredSweep(time.Hour, 2))
+	require.NoError(t, err)
+
+	putExpired(t, db, bucket, "key1", "key2", "key3")
+	putExpiredLists(t, db, listBucket, "list1", "list2", "list3")
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		if err := tx.Put(bucket, []byte("key4"), []byte("value"), Persistent); err != nil {
+			return err
+		}
+		return tx.RPush(listBucket, []byte("list4"), []byte("value"))
+	}))
+	assert.Len(t, listKeysInIndex(t, db, listBucket), 4)
+
+	swept, err := db.SweepExpired()
+	require.NoError(t, err)
+	assert.Equal(t, 6, swept)
+	assert.Equal(t, map[string]bool{"list4": true}, listKeysInIndex(t, db, listBucket))
+	require.NoError(t, db.View(func(tx *Tx) error {
+		for _, key := range []string{"key1", "key2", "key3"} {
+			r, err := tx.db.BPTreeIdx[bucket].Find([]byte(key))
+			require.NoError(t, err)
+			assert.Equal(t, DataDeleteFlag, r.H.Meta.Flag)
+		}
+		_, err := tx.Get(bucket, []byte("key4"))
+		return err
+	}))
+
+	swept, err = db.SweepExpired()
+	require.NoError(t, err)
+	assert.Equal(t, 0, swept)
+
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"list4": true}, listKeysInIndex(t, db, listBucket))
+	require.NoError(t, db.Close())
+}
+
+func TestDB_ExpiredDeleteActive(t *testing.T) {
+	bucket, listBucket := "bucket_sweeper", "bucket_sweeper_list"
+
+	InitOpt("/tmp/nutsdbtestsweeper", true)
+	db, err := Open(opt, WithExpiredDeleteType(ExpiredDeleteActive), WithExpiredSweep(10*time.Millisecond, 0))
+	require.NoError(t, err)
+	defer db.Close()
+
+	putExpired(t, db, bucket, "key")
+	putExpiredLists(t, db, listBucket, "list")
+	require.Eventually(t, func() bool {
+		events, err := db.ExpirationEvents(0, 0)
+		return err == nil && len(events) == 1 && len(listKeysInIndex(t, db, listBucket)) == 0
+	}, time.Second, 10*time.Millisecond)
+}
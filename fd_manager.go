@@ -66,9 +66,11 @@ func (fdm *fdManager) getFd(path string) (fd *os.File, err error) {
 			if fdm.size >= fdm.cleanThresholdNums {
 				err = fdm.cleanUselessFd()
 			}
-			// if the numbers of fd in cache larger than the max numbers of fd in config, we will not add this fd to cache
+			// if the numbers of fd in cache reach the max numbers of fd in config, we will close the least recently
+			// used fds not in use, they are opened again on demand. The fds in use are never closed, so the cache
+			// may go over the max until they are returned.
 			if fdm.size >= fdm.maxFdNums {
-				return fd, nil
+				err = fdm.closeUnusedFds(fdm.size - fdm.maxFdNums + 1)
 			}
 			// add this fd to cache
 			fdm.addToCache(fd, cleanPath)
@@ -120,6 +122,11 @@ func (fdm *fdManager) reduceUsing(path string) {
 		panic("unexpected the node is not in cache")
 	}
 	node.using--
+	// the cache went over the max numbers of fd while all the fds were in use.
+	if node.using == 0 && fdm.size > fdm.maxFdNums {
+		fdm.removeFromCache(node)
+		_ = node.fd.Close()
+	}
 }
 
 // close means the cache.
@@ -179,32 +186,41 @@ func (list *doubleLinkedList) moveNodeToFront(node *FdInfo) {
 }
 
 func (fdm *fdManager) cleanUselessFd() error {
-	cleanNums := fdm.cleanThresholdNums
+	return fdm.closeUnusedFds(fdm.cleanThresholdNums)
+}
+
+// closeUnusedFds closes up to nums fds not in use, the least recently used first.
+func (fdm *fdManager) closeUnusedFds(nums int) error {
 	node := fdm.fdList.tail.prev
-	for node != nil && node != fdm.fdList.head && cleanNums > 0 {
+	for node != nil && node != fdm.fdList.head && nums > 0 {
 		nextItem := node.prev
 		if node.using == 0 {
-			fdm.fdList.removeNode(node)
+			fdm.removeFromCache(node)
 			err := node.fd.Close()
 			if err != nil {
 				return err
 			}
-			fdm.size--
-			delete(fdm.cache, node.path)
-			cleanNums--
+			nums--
 		}
 		node = nextItem
 	}
 	return nil
 }
 
+// removeFromCache removes the node from the cache without closing its fd.
+func (fdm *fdManager) removeFromCache(node *FdInfo) {
+	fdm.fdList.removeNode(node)
+	fdm.size--
+	delete(fdm.cache, node.path)
+}
+
 func (fdm *fdManager) closeByPath(path string) error {
 	fdm.lock.Lock()
 	defer fdm.lock.Unlock()
-	fdInfo, ok := fdm.cache[path]
+	fdInfo, ok := fdm.cache[filepath.Clean(path)]
 	if !ok {
 		return nil
 	}
-	fdm.fdList.removeNode(fdInfo)
+	fdm.removeFromCache(fdInfo)
 	return fdInfo.fd.Close()
 }
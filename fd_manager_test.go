@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestFdManager_All(t *testing.T) {
@@ -255,3 +256,76 @@ func TestGetMaxNums(t *testing.T) {
 		}
 	}
 }
+
+func TestFdManager_LRU(t *testing.T) {
+	dir := "test-data-max-open"
+	testBasePath := dir + "/data-"
+	require.NoError(t, os.Mkdir(dir, os.ModePerm))
+	defer os.RemoveAll(dir)
+
+	maxFdNums := 3
+	fdm := newFdm(maxFdNums, 0.5)
+
+	// the fds released are closed past the cap.
+	for i := 0; i < 10; i++ {
+		path := testBasePath + fmt.Sprint(i)
+		_, err := fdm.getFd(path)
+		require.NoError(t, err)
+		fdm.reduceUsing(path)
+		assert.True(t, fdm.size <= maxFdNums)
+	}
+
+	// the fds in use are kept open past the cap, and closed once released.
+	var paths []string
+	for i := 10; i < 15; i++ {
+		path := testBasePath + fmt.Sprint(i)
+		_, err := fdm.getFd(path)
+		require.NoError(t, err)
+		paths = append(paths, path)
+	}
+	assert.Equal(t, len(paths), fdm.size)
+	for _, path := range paths {
+		fdm.reduceUsing(path)
+	}
+	assert.Equal(t, maxFdNums, fdm.size)
+	assert.Equal(t, maxFdNums, len(fdm.cache))
+	require.NoError(t, fdm.close())
+}
+
+func TestDB_MaxFdNumsInCache(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestmaxopenfiles", true)
+	opt.SegmentSize = 1024
+	db, err = Open(opt, WithMaxFdNumsInCache(2))
+	require.NoError(t, err)
+	assert.Equal(t, 2, db.fm.fdm.maxFdNums)
+
+	bucket := "bucket"
+	for i := 0; i < 50; i++ {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.Put(bucket, []byte(fmt.Sprintf("key_%d", i)), []byte(fmt.Sprintf("value_%d", i)), Persistent)
+		}))
+	}
+	_, fIDs := db.getMaxFileIDAndFileIDs()
+	assert.True(t, len(fIDs) > 2)
+
+	check := func() {
+		for round := 0; round < 2; round++ {
+			require.NoError(t, db.View(func(tx *Tx) error {
+				for i := 0; i < 50; i++ {
+					e, err := tx.Get(bucket, []byte(fmt.Sprintf("key_%d", i)))
+					require.NoError(t, err)
+					assert.Equal(t, fmt.Sprintf("value_%d", i), string(e.Value))
+				}
+				return nil
+			}))
+		}
+		assert.True(t, db.fm.fdm.size <= 2)
+	}
+	check()
+
+	require.NoError(t, db.Close())
+	db, err = Open(opt, WithMaxFdNumsInCache(2))
+	require.NoError(t, err)
+	check()
+	require.NoError(t, db.Close())
+}
@@ -6,8 +6,11 @@ import (
 
 // fileManager holds the fd cache and file-related operations go through the manager to obtain the file processing object
 type fileManager struct {
-	rwMode RWMode
-	fdm    *fdManager
+	rwMode    RWMode
+	fdm       *fdManager
+	bucketIDs *bucketIDTable
+	keys      *keyring
+	latency   SimulatedLatency
 }
 
 // newFileManager will create a newFileManager object
@@ -41,7 +44,14 @@ func (fm *fileManager) getDataFile(path string, capacity int64) (datafile *DataF
 		}
 	}
 
-	return NewDataFile(path, rwManager), nil
+	if fm.latency.enabled() {
+		rwManager = &slowRWManager{RWManager: rwManager, latency: fm.latency}
+	}
+
+	df := NewDataFile(path, rwManager)
+	df.bucketIDs = fm.bucketIDs
+	df.keys = fm.keys
+	return df, nil
 }
 
 // getFileRWManager will return a FileIORWManager Object
@@ -0,0 +1,181 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const fullTextBucketKind = "fulltext"
+
+// ErrNoTokenizer is returned by Search when the bucket has no tokenizer in Options.Tokenizers.
+var ErrNoTokenizer = errors.New("the bucket has no tokenizer")
+
+// Tokenizer splits a value into the terms indexed for full-text search, see Options.Tokenizers.
+type Tokenizer func(value []byte) []string
+
+// SimpleTokenizer splits the value into the lowercased runs of letters and digits.
+func SimpleTokenizer(value []byte) []string {
+	return strings.FieldsFunc(strings.ToLower(string(value)), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// SearchHit is a key matching the query of Search, with its score.
+type SearchHit struct {
+	Key   []byte
+	Score float64
+}
+
+// termFrequencies returns the number of occurrences of the terms the tokenizer finds in the value.
+// The empty terms and the terms holding a zero byte, which separates the term from the key in the
+// inverted index, are left out.
+func termFrequencies(tokenizer Tokenizer, value []byte) map[string]uint32 {
+	tf := make(map[string]uint32)
+	for _, term := range tokenizer(value) {
+		if term == "" || strings.IndexByte(term, 0) >= 0 {
+			continue
+		}
+		tf[term]++
+	}
+	return tf
+}
+
+// postingKey returns the key of the posting of the key for the term in the inverted index.
+func postingKey(term string, key []byte) []byte {
+	pk := make([]byte, 0, len(term)+1+len(key))
+	pk = append(pk, term...)
+	pk = append(pk, 0)
+	return append(pk, key...)
+}
+
+// indexText updates the inverted index of the bucket, if it has a tokenizer, for the write of the value
+// of the key with the flag, before the write is added to the pending writes: the postings of the terms
+// of the value the key had are removed, and the postings of the terms of the new value are written.
+func (tx *Tx) indexText(bucket string, key, value []byte, flag uint16) error {
+	tokenizer, ok := tx.db.opt.Tokenizers[bucket]
+	if !ok || tx.isMerge || (flag != DataSetFlag && flag != DataDeleteFlag) {
+		return nil
+	}
+
+	idxBucket := internalBucket(fullTextBucketKind, bucket)
+	timestamp := tx.db.timestamp()
+
+	var newTF map[string]uint32
+	if flag == DataSetFlag {
+		newTF = termFrequencies(tokenizer, value)
+	}
+
+	if old, err := tx.lookup(bucket, key); err == nil {
+		for term := range termFrequencies(tokenizer, old.Value) {
+			if _, ok := newTF[term]; ok {
+				continue
+			}
+			if err := tx.put(idxBucket, postingKey(term, key), nil, Persistent, DataDeleteFlag, timestamp, DataStructureBPTree); err != nil {
+				return err
+			}
+		}
+	}
+
+	for term, n := range newTF {
+		buf := make([]byte, 4)
+		binary.BigEndian.PutUint32(buf, n)
+		if err := tx.put(idxBucket, postingKey(term, key), buf, Persistent, DataSetFlag, timestamp, DataStructureBPTree); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Search returns the keys of the bucket whose value holds any of the terms the tokenizer of the bucket
+// finds in the query, the best scored first. The score of a key is the sum of the number of occurrences
+// in its value of every term of the query. The writes of the tx are seen. Limit zero returns all the keys.
+func (tx *Tx) Search(bucket string, query string, limit int) ([]SearchHit, error) {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return nil, err
+	}
+	tokenizer, ok := tx.db.opt.Tokenizers[bucket]
+	if !ok {
+		return nil, ErrNoTokenizer
+	}
+
+	idxBucket := internalBucket(fullTextBucketKind, bucket)
+	scores := make(map[string]float64)
+	for term := range termFrequencies(tokenizer, []byte(query)) {
+		postings, err := tx.postings(idxBucket, term)
+		if err != nil {
+			return nil, err
+		}
+		for key, n := range postings {
+			scores[key] += float64(n)
+		}
+	}
+
+	hits := make([]SearchHit, 0, len(scores))
+	for key, score := range scores {
+		// the postings of the keys expired or of a deleted bucket are left behind.
+		if _, err := tx.lookup(bucket, []byte(key)); err != nil {
+			continue
+		}
+		hits = append(hits, SearchHit{Key: []byte(key), Score: score})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return bytes.Compare(hits[i].Key, hits[j].Key) < 0
+	})
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits, nil
+}
+
+// postings returns the number of occurrences of the term in the value of every key of the inverted index,
+// with the pending writes of the tx applied.
+func (tx *Tx) postings(idxBucket, term string) (map[string]uint32, error) {
+	prefix := postingKey(term, nil)
+	postings := make(map[string]uint32)
+
+	es, _, err := tx.PrefixScan(idxBucket, prefix, 0, ScanNoLimit)
+	if err != nil && err != ErrPrefixScan {
+		return nil, err
+	}
+	for _, e := range es {
+		if len(e.Value) == 4 {
+			postings[string(e.Key[len(prefix):])] = binary.BigEndian.Uint32(e.Value)
+		}
+	}
+
+	if pb, ok := tx.pendingKeys[idxBucket]; ok {
+		for pk, e := range pb.keys {
+			if !strings.HasPrefix(pk, string(prefix)) {
+				continue
+			}
+			key := pk[len(prefix):]
+			if e.Meta.Flag == DataDeleteFlag || len(e.Value) != 4 {
+				delete(postings, key)
+				continue
+			}
+			postings[key] = binary.BigEndian.Uint32(e.Value)
+		}
+	}
+	return postings, nil
+}
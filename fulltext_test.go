@@ -0,0 +1,102 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTx_Search(t *testing.T) {
+	bucket := "bucket_fulltext"
+	InitOpt("/tmp/nutsdbtestfulltext", true)
+	opt.Tokenizers = map[string]Tokenizer{bucket: SimpleTokenizer}
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	hitKeys := func(hits []SearchHit) []string {
+		keys := make([]string, len(hits))
+		for i, hit := range hits {
+			keys[i] = string(hit.Key)
+		}
+		return keys
+	}
+	search := func(query string, limit int) []SearchHit {
+		var hits []SearchHit
+		require.NoError(t, db.View(func(tx *Tx) error {
+			var err error
+			hits, err = tx.Search(bucket, query, limit)
+			return err
+		}))
+		return hits
+	}
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		require.NoError(t, tx.Put(bucket, []byte("doc1"), []byte("The quick brown fox"), Persistent))
+		require.NoError(t, tx.Put(bucket, []byte("doc2"), []byte("Fox, fox and FOX!"), Persistent))
+		require.NoError(t, tx.Put(bucket, []byte("doc3"), []byte("a lazy dog"), Persistent))
+
+		// the pending writes of the tx are searched.
+		hits, err := tx.Search(bucket, "fox", 0)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"doc2", "doc1"}, hitKeys(hits))
+		return nil
+	}))
+
+	hits := search("fox dog", 0)
+	assert.Equal(t, []string{"doc2", "doc1", "doc3"}, hitKeys(hits))
+	assert.Equal(t, float64(3), hits[0].Score)
+	assert.Equal(t, []string{"doc2"}, hitKeys(search("fox dog", 1)))
+	assert.Empty(t, search("cat", 0))
+
+	// the postings follow the updates and the deletes, and are left as is by a rollback.
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		require.NoError(t, tx.Put(bucket, []byte("doc1"), []byte("a quick dog"), Persistent))
+		return tx.Delete(bucket, []byte("doc2"))
+	}))
+	require.Error(t, db.Update(func(tx *Tx) error {
+		require.NoError(t, tx.Delete(bucket, []byte("doc3")))
+		return errors.New("rolled back")
+	}))
+	assert.Empty(t, search("fox", 0))
+	assert.Equal(t, []string{"doc1", "doc3"}, hitKeys(search("dog", 0)))
+
+	// the keys of a PutBatch are indexed and found through the index of the bucket.
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.PutBatch(bucket, [][]byte{[]byte("doc4"), []byte("doc5")}, [][]byte{[]byte("red fox"), []byte("red dog")}, Persistent)
+	}))
+	assert.Equal(t, []string{"doc4", "doc5"}, hitKeys(search("red", 0)))
+	require.NoError(t, db.View(func(tx *Tx) error {
+		e, err := tx.Get(bucket, []byte("doc5"))
+		require.NoError(t, err)
+		assert.Equal(t, []byte("red dog"), e.Value)
+		return nil
+	}))
+
+	require.NoError(t, db.View(func(tx *Tx) error {
+		_, err := tx.Search("bucket_no_tokenizer", "dog", 0)
+		assert.Equal(t, ErrNoTokenizer, err)
+		return nil
+	}))
+
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"doc1", "doc3", "doc5"}, hitKeys(search("dog quick", 0)))
+	require.NoError(t, db.Close())
+}
@@ -0,0 +1,126 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrLoaderPanicked is returned by GetOrLoad to the callers waiting for a loader which panicked.
+var ErrLoaderPanicked = errors.New("loader of GetOrLoad panicked")
+
+// loadKey identifies the key a call of a loader of GetOrLoad loads.
+type loadKey struct {
+	bucket string
+	key    string
+}
+
+// loadCall is a call of a loader of GetOrLoad, shared by the callers missing the same key.
+type loadCall struct {
+	done  chan struct{}
+	value []byte
+	err   error
+}
+
+// loadCalls are the calls of the loaders of GetOrLoad in flight.
+type loadCalls struct {
+	mu    sync.Mutex
+	calls map[loadKey]*loadCall
+}
+
+func newLoadCalls() *loadCalls {
+	return &loadCalls{calls: make(map[loadKey]*loadCall)}
+}
+
+// GetOrLoad returns the value of the key in the bucket. On a miss, loader is called and the value it returns
+// is put with the ttl before it is returned. The callers missing the same key meanwhile wait for that call
+// and share its value rather than call their loaders, so that a missing key loads once. An error of the loader
+// is returned to all of them and nothing is put.
+func (db *DB) GetOrLoad(bucket string, key []byte, ttl uint32, loader func() ([]byte, error)) ([]byte, error) {
+	return db.getOrLoad(bucket, key, ttl, loader, false)
+}
+
+// getOrLoad is GetOrLoad, fromOrigin tells whether the loader gets the values from the Options.Tiering.
+func (db *DB) getOrLoad(bucket string, key []byte, ttl uint32, loader func() ([]byte, error), fromOrigin bool) ([]byte, error) {
+	value, ok, err := db.getLoaded(bucket, key)
+	if err != nil || ok {
+		return value, err
+	}
+
+	k := loadKey{bucket: bucket, key: string(key)}
+	db.loads.mu.Lock()
+	if call, ok := db.loads.calls[k]; ok {
+		db.loads.mu.Unlock()
+		<-call.done
+		if call.err != nil {
+			return nil, call.err
+		}
+		return append([]byte(nil), call.value...), nil
+	}
+	call := &loadCall{done: make(chan struct{}), err: ErrLoaderPanicked}
+	db.loads.calls[k] = call
+	db.loads.mu.Unlock()
+
+	defer func() {
+		db.loads.mu.Lock()
+		delete(db.loads.calls, k)
+		db.loads.mu.Unlock()
+		close(call.done)
+	}()
+
+	call.value, call.err = db.load(bucket, key, ttl, loader, fromOrigin)
+	if call.err != nil {
+		return nil, call.err
+	}
+	return append([]byte(nil), call.value...), nil
+}
+
+// getLoaded returns a copy of the value of the key in the bucket, and whether the key is found.
+func (db *DB) getLoaded(bucket string, key []byte) (value []byte, ok bool, err error) {
+	err = db.View(func(tx *Tx) error {
+		e, err := tx.Get(bucket, key)
+		if err == ErrNotFoundBucket || err == ErrNotFoundKey || err == ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		value, ok = append([]byte(nil), e.Value...), true
+		return nil
+	})
+	return value, ok, err
+}
+
+// load calls the loader for the missing key and puts the value it returns with the ttl. The key is looked up
+// again first, as the call of a loader finishing since the miss has put it. The values from the origin are
+// not queued for the Options.Tiering.
+func (db *DB) load(bucket string, key []byte, ttl uint32, loader func() ([]byte, error), fromOrigin bool) ([]byte, error) {
+	value, ok, err := db.getLoaded(bucket, key)
+	if err != nil || ok {
+		return value, err
+	}
+
+	if value, err = loader(); err != nil {
+		return nil, err
+	}
+	if err := db.Update(func(tx *Tx) error {
+		tx.fromOrigin = fromOrigin
+		return tx.Put(bucket, key, value, ttl)
+	}); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_GetOrLoad(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestgetorload", true)
+	db, err = Open(opt)
+	require.NoError(t, err)
+	defer db.Close()
+
+	bucket := "bucket_get_or_load"
+	key := []byte("key")
+
+	var calls int32
+	release := make(chan struct{})
+	loader := func() ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return []byte("loaded"), nil
+	}
+
+	var wg sync.WaitGroup
+	values := make([][]byte, 10)
+	errs := make([]error, 10)
+	for i := range values {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			values[i], errs[i] = db.GetOrLoad(bucket, key, 60, loader)
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	for i := range values {
+		assert.NoError(t, errs[i])
+		assert.Equal(t, []byte("loaded"), values[i])
+	}
+
+	// the value is put with the ttl.
+	require.NoError(t, db.View(func(tx *Tx) error {
+		e, err := tx.Get(bucket, key)
+		if err != nil {
+			return err
+		}
+		assert.Equal(t, []byte("loaded"), e.Value)
+		assert.Equal(t, uint32(60), e.Meta.TTL)
+		return nil
+	}))
+	value, err := db.GetOrLoad(bucket, key, 60, func() ([]byte, error) {
+		t.Fatal("loader called on a hit")
+		return nil, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("loaded"), value)
+
+	// the errors of the loader are not put.
+	errLoad := errors.New("load failed")
+	_, err = db.GetOrLoad(bucket, []byte("other"), 60, func() ([]byte, error) {
+		return nil, errLoad
+	})
+	assert.Equal(t, errLoad, err)
+	require.NoError(t, db.View(func(tx *Tx) error {
+		_, err := tx.Get(bucket, []byte("other"))
+		assert.Error(t, err)
+		return nil
+	}))
+}
@@ -0,0 +1,255 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"hash/fnv"
+	"sort"
+	"sync"
+)
+
+const (
+	// sketchDepth is the number of rows of the count-min sketch.
+	sketchDepth = 4
+
+	// sketchWidth is the number of counters of every row of the count-min sketch, shared by all the buckets.
+	sketchWidth = 8192
+)
+
+// HotKeyKind is the kind of the accesses HotKeys ranks the keys by.
+type HotKeyKind int
+
+const (
+	// HotKeyReads ranks the keys by their reads.
+	HotKeyReads HotKeyKind = iota
+
+	// HotKeyWrites ranks the keys by their writes.
+	HotKeyWrites
+)
+
+// HotKey is a key reported by HotKeys with the estimated number of reads and writes since the db was opened.
+type HotKey struct {
+	Key    []byte
+	Reads  uint64
+	Writes uint64
+}
+
+// countMinSketch estimates the number of occurrences of the keys of all the buckets in a fixed memory,
+// the estimates never undercount and overcount by a small fraction of the total.
+type countMinSketch struct {
+	counters [sketchDepth][sketchWidth]uint64
+}
+
+// add counts one more occurrence of the key of the bucket and returns its estimate.
+func (s *countMinSketch) add(bucket string, key []byte) uint64 {
+	var estimate uint64
+	for i := 0; i < sketchDepth; i++ {
+		c := &s.counters[i][sketchIndex(i, bucket, key)]
+		*c++
+		if i == 0 || *c < estimate {
+			estimate = *c
+		}
+	}
+	return estimate
+}
+
+// estimate returns the estimated number of occurrences of the key of the bucket.
+func (s *countMinSketch) estimate(bucket string, key []byte) uint64 {
+	var estimate uint64
+	for i := 0; i < sketchDepth; i++ {
+		c := s.counters[i][sketchIndex(i, bucket, key)]
+		if i == 0 || c < estimate {
+			estimate = c
+		}
+	}
+	return estimate
+}
+
+// sketchIndex hashes the bucket prefixed by its size along with the key, so that the bucket and the key
+// cannot be split otherwise.
+func sketchIndex(row int, bucket string, key []byte) uint64 {
+	var prefix [5]byte
+	prefix[0] = byte(row)
+	binary.BigEndian.PutUint32(prefix[1:], uint32(len(bucket)))
+
+	h := fnv.New64a()
+	_, _ = h.Write(prefix[:])
+	_, _ = h.Write([]byte(bucket))
+	_, _ = h.Write(key)
+	return h.Sum64() % sketchWidth
+}
+
+// hotKeyCandidate is a key tracked by the top-k heap with its estimated number of accesses.
+type hotKeyCandidate struct {
+	key      string
+	accesses uint64
+	index    int
+}
+
+// hotKeyHeap is a min-heap of the candidates, the root is the coldest of the tracked keys.
+type hotKeyHeap []*hotKeyCandidate
+
+func (h hotKeyHeap) Len() int           { return len(h) }
+func (h hotKeyHeap) Less(i, j int) bool { return h[i].accesses < h[j].accesses }
+func (h hotKeyHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *hotKeyHeap) Push(x interface{}) {
+	c := x.(*hotKeyCandidate)
+	c.index = len(*h)
+	*h = append(*h, c)
+}
+
+func (h *hotKeyHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// topKeys tracks the keys with the most accesses of a kind, up to the capacity.
+type topKeys struct {
+	top        hotKeyHeap
+	candidates map[string]*hotKeyCandidate
+}
+
+// update records the estimated accesses of the key, which replaces the coldest candidate once it gets more.
+func (t *topKeys) update(key []byte, accesses uint64, capacity int) {
+	if c, ok := t.candidates[string(key)]; ok {
+		c.accesses = accesses
+		heap.Fix(&t.top, c.index)
+		return
+	}
+
+	if len(t.top) < capacity {
+		c := &hotKeyCandidate{key: string(key), accesses: accesses}
+		heap.Push(&t.top, c)
+		t.candidates[c.key] = c
+		return
+	}
+
+	if coldest := t.top[0]; accesses > coldest.accesses {
+		delete(t.candidates, coldest.key)
+		coldest.key = string(key)
+		coldest.accesses = accesses
+		t.candidates[coldest.key] = coldest
+		heap.Fix(&t.top, 0)
+	}
+}
+
+// hottest returns up to k of the candidates, hottest first.
+func (t *topKeys) hottest(k int) []*hotKeyCandidate {
+	candidates := make([]*hotKeyCandidate, len(t.top))
+	copy(candidates, t.top)
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].accesses > candidates[j].accesses
+	})
+	if k < len(candidates) {
+		candidates = candidates[:k]
+	}
+	return candidates
+}
+
+// bucketHotKeys tracks the most read and the most written keys of a bucket apart.
+type bucketHotKeys struct {
+	reads  topKeys
+	writes topKeys
+}
+
+// hotKeys tracks the hottest keys of every bucket, it is nil when the tracking is disabled.
+// The accesses of all the buckets are counted by the same sketches, so that the memory only grows
+// by the tracked keys with the number of the buckets.
+type hotKeys struct {
+	mu       sync.Mutex
+	capacity int
+	reads    countMinSketch
+	writes   countMinSketch
+	buckets  map[string]*bucketHotKeys
+}
+
+func newHotKeys(capacity int) *hotKeys {
+	if capacity <= 0 {
+		return nil
+	}
+	return &hotKeys{capacity: capacity, buckets: make(map[string]*bucketHotKeys)}
+}
+
+func (hk *hotKeys) read(bucket string, key []byte) {
+	if hk != nil && !isInternalBucket(bucket) {
+		hk.record(bucket, key, false)
+	}
+}
+
+func (hk *hotKeys) write(bucket string, key []byte) {
+	if hk != nil && !isInternalBucket(bucket) {
+		hk.record(bucket, key, true)
+	}
+}
+
+func (hk *hotKeys) record(bucket string, key []byte, isWrite bool) {
+	hk.mu.Lock()
+	defer hk.mu.Unlock()
+
+	b, ok := hk.buckets[bucket]
+	if !ok {
+		b = &bucketHotKeys{
+			reads:  topKeys{candidates: make(map[string]*hotKeyCandidate)},
+			writes: topKeys{candidates: make(map[string]*hotKeyCandidate)},
+		}
+		hk.buckets[bucket] = b
+	}
+
+	if isWrite {
+		b.writes.update(key, hk.writes.add(bucket, key), hk.capacity)
+	} else {
+		b.reads.update(key, hk.reads.add(bucket, key), hk.capacity)
+	}
+}
+
+// HotKeys returns up to k of the most read or the most written keys of the bucket, as kind tells, hottest first.
+// The counts are estimates, and only Options.HotKeysCapacity keys of each kind are tracked per bucket.
+// It returns nil when the tracking is disabled.
+func (db *DB) HotKeys(bucket string, k int, kind HotKeyKind) []HotKey {
+	hk := db.hotKeys
+	if hk == nil {
+		return nil
+	}
+
+	hk.mu.Lock()
+	defer hk.mu.Unlock()
+
+	b, ok := hk.buckets[bucket]
+	if !ok {
+		return nil
+	}
+
+	top := &b.reads
+	if kind == HotKeyWrites {
+		top = &b.writes
+	}
+	candidates := top.hottest(k)
+
+	keys := make([]HotKey, 0, len(candidates))
+	for _, c := range candidates {
+		key := []byte(c.key)
+		keys = append(keys, HotKey{Key: key, Reads: hk.reads.estimate(bucket, key), Writes: hk.writes.estimate(bucket, key)})
+	}
+	return keys
+}
@@ -0,0 +1,96 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCountMinSketch(t *testing.T) {
+	s := &countMinSketch{}
+	for i := 0; i < 100; i++ {
+		s.add("bucket", []byte("hot"))
+	}
+	s.add("bucket", []byte("cold"))
+
+	assert.Equal(t, uint64(100), s.estimate("bucket", []byte("hot")))
+	assert.Equal(t, uint64(1), s.estimate("bucket", []byte("cold")))
+	assert.Equal(t, uint64(0), s.estimate("bucket", []byte("missing")))
+
+	// the keys of the buckets are counted apart, however the names and the keys are split.
+	assert.Equal(t, uint64(0), s.estimate("other", []byte("hot")))
+	assert.Equal(t, uint64(0), s.estimate("bucke", []byte("thot")))
+}
+
+func TestDB_HotKeys(t *testing.T) {
+	InitOpt("/tmp/nutsdbtesthotkeys", true)
+	db, err = Open(opt, WithHotKeysCapacity(4))
+	require.NoError(t, err)
+
+	bucket := "bucket"
+	err = db.Update(func(tx *Tx) error {
+		for i := 0; i < 10; i++ {
+			if err := tx.Put(bucket, []byte(fmt.Sprintf("key_%d", i)), []byte("value"), Persistent); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		err = db.Update(func(tx *Tx) error {
+			return tx.Put(bucket, []byte("key_1"), []byte("value"), Persistent)
+		})
+		require.NoError(t, err)
+	}
+	err = db.View(func(tx *Tx) error {
+		for i := 0; i < 20; i++ {
+			if _, err := tx.Get(bucket, []byte("key_2")); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	// the reads and the writes are ranked apart.
+	keys := db.HotKeys(bucket, 1, HotKeyReads)
+	require.Len(t, keys, 1)
+	assert.Equal(t, HotKey{Key: []byte("key_2"), Reads: 20, Writes: 1}, keys[0])
+
+	keys = db.HotKeys(bucket, 1, HotKeyWrites)
+	require.Len(t, keys, 1)
+	assert.Equal(t, HotKey{Key: []byte("key_1"), Reads: 0, Writes: 6}, keys[0])
+
+	assert.Len(t, db.HotKeys(bucket, 10, HotKeyReads), 1)
+	assert.Len(t, db.HotKeys(bucket, 10, HotKeyWrites), 4)
+	assert.Nil(t, db.HotKeys("missing", 10, HotKeyReads))
+	require.NoError(t, db.Close())
+
+	InitOpt("/tmp/nutsdbtesthotkeys", true)
+	db, err = Open(opt)
+	require.NoError(t, err)
+	err = db.Update(func(tx *Tx) error {
+		return tx.Put(bucket, []byte("key"), []byte("value"), Persistent)
+	})
+	require.NoError(t, err)
+	assert.Nil(t, db.HotKeys(bucket, 10, HotKeyWrites))
+	require.NoError(t, db.Close())
+}
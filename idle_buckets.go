@@ -0,0 +1,137 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+const idleBucketKind = "idle"
+
+// idleTTLBucket maps the bucket names to their idle ttl in seconds, as set by SetBucketIdleTTL.
+var idleTTLBucket = internalBucket(idleBucketKind, "ttl")
+
+// SetBucketIdleTTL makes DropIdleBuckets drop the bucket, with all its data structures, once nothing
+// was written to it for ttl, e.g. for the buckets created per session or per job and never deleted.
+// The ttl is kept across restarts until the bucket is dropped. Zero ttl keeps the bucket again.
+func (tx *Tx) SetBucketIdleTTL(bucket string, ttl time.Duration) error {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return err
+	}
+	if tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	if ttl <= 0 {
+		return tx.put(idleTTLBucket, []byte(bucket), nil, Persistent, DataDeleteFlag, tx.db.timestamp(), DataStructureBPTree)
+	}
+
+	seconds := uint64((ttl + time.Second - 1) / time.Second)
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, seconds)
+	return tx.put(idleTTLBucket, []byte(bucket), value, Persistent, DataSetFlag, tx.db.timestamp(), DataStructureBPTree)
+}
+
+// DropIdleBuckets deletes the buckets given an idle ttl by SetBucketIdleTTL that nothing was written to
+// for that ttl, and returns their number. The disk they use is reclaimed by the next Merge.
+// The reaper calls it every Options.ExpirationReapInterval.
+//
+// The last write of a bucket is known from its entries replayed on open, the entries covered by
+// a checkpoint are not, and the ttl of a bucket not written to since is counted from the first call.
+func (db *DB) DropIdleBuckets() (int, error) {
+	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return 0, ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	dropped := 0
+	err := db.Update(func(tx *Tx) error {
+		idx, ok := tx.db.BPTreeIdx[idleTTLBucket]
+		if !ok {
+			return nil
+		}
+		records, err := idx.All()
+		if err != nil {
+			return nil
+		}
+		entries, err := tx.getHintIdxDataItemsWrapper(idleTTLBucket, records, ScanNoLimit, nil, RangeScan)
+		if err != nil {
+			return err
+		}
+
+		now := tx.db.timestamp()
+		for _, e := range entries {
+			bucket := string(e.Key)
+			if len(e.Value) != 8 {
+				continue
+			}
+			lastWrite, ok := tx.db.bucketLastWrite[bucket]
+			if !ok {
+				tx.db.bucketLastWrite[bucket] = now
+				continue
+			}
+			if lastWrite+binary.BigEndian.Uint64(e.Value) > now {
+				continue
+			}
+
+			if err := tx.dropBucket(bucket); err != nil {
+				return err
+			}
+			if err := tx.put(idleTTLBucket, e.Key, nil, Persistent, DataDeleteFlag, now, DataStructureBPTree); err != nil {
+				return err
+			}
+			dropped++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return dropped, nil
+}
+
+// dropBucket deletes the bucket from all the data structures holding it.
+func (tx *Tx) dropBucket(bucket string) error {
+	db := tx.db
+	if _, ok := db.BPTreeIdx[bucket]; ok {
+		if err := tx.DeleteBucket(DataStructureBPTree, bucket); err != nil {
+			return err
+		}
+	}
+	if _, ok := db.SetIdx[bucket]; ok {
+		if err := tx.DeleteBucket(DataStructureSet, bucket); err != nil {
+			return err
+		}
+	}
+	if _, ok := db.SortedSetIdx[bucket]; ok {
+		if err := tx.DeleteBucket(DataStructureSortedSet, bucket); err != nil {
+			return err
+		}
+	}
+	if db.Index.isBucketExist(bucket) {
+		if err := tx.DeleteBucket(DataStructureList, bucket); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// touchBucket records the write of the entry as the last write of its bucket.
+func (db *DB) touchBucket(entry *Entry) {
+	bucket := string(entry.Bucket)
+	if entry.Meta.Timestamp > db.bucketLastWrite[bucket] {
+		db.bucketLastWrite[bucket] = entry.Meta.Timestamp
+	}
+}
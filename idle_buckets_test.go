@@ -0,0 +1,117 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_DropIdleBuckets(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestidlebuckets", true)
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	key := []byte("key")
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		for _, bucket := range []string{"session_1", "session_2", "keep"} {
+			if err := tx.Put(bucket, key, []byte("value"), Persistent); err != nil {
+				return err
+			}
+		}
+		if err := tx.RPush("session_1", key, []byte("a")); err != nil {
+			return err
+		}
+		if err := tx.SAdd("session_1", key, []byte("a")); err != nil {
+			return err
+		}
+		if err := tx.ZAdd("session_1", key, 1, []byte("a")); err != nil {
+			return err
+		}
+		if err := tx.SetBucketIdleTTL("session_1", time.Minute); err != nil {
+			return err
+		}
+		if err := tx.SetBucketIdleTTL("session_2", time.Minute); err != nil {
+			return err
+		}
+		return tx.SetBucketIdleTTL("never_written", time.Minute)
+	}))
+
+	// nothing was written to session_1 for an hour.
+	db.bucketLastWrite["session_1"] -= 3600
+	dropped, err := db.DropIdleBuckets()
+	require.NoError(t, err)
+	assert.Equal(t, 1, dropped)
+
+	dropped, err = db.DropIdleBuckets()
+	require.NoError(t, err)
+	assert.Equal(t, 0, dropped)
+
+	check := func() {
+		require.NoError(t, db.View(func(tx *Tx) error {
+			_, err := tx.Get("session_1", key)
+			assert.Error(t, err)
+			_, err = tx.LRange("session_1", key, 0, -1)
+			assert.Error(t, err)
+			ok, _ := tx.SIsMember("session_1", key, []byte("a"))
+			assert.False(t, ok)
+			_, err = tx.ZGetByKey("session_1", key)
+			assert.Error(t, err)
+
+			_, err = tx.Get("session_2", key)
+			assert.NoError(t, err)
+			_, err = tx.Get("keep", key)
+			assert.NoError(t, err)
+			return nil
+		}))
+	}
+	check()
+
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	check()
+
+	// the ttls outlive the restart, until they are reset.
+	db.bucketLastWrite["session_2"] -= 3600
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.SetBucketIdleTTL("session_2", 0)
+	}))
+	dropped, err = db.DropIdleBuckets()
+	require.NoError(t, err)
+	assert.Equal(t, 0, dropped)
+	require.NoError(t, db.View(func(tx *Tx) error {
+		_, err := tx.Get("session_2", key)
+		assert.NoError(t, err)
+		return nil
+	}))
+
+	// a write puts the drop off.
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.SetBucketIdleTTL("keep", time.Minute)
+	}))
+	db.bucketLastWrite["keep"] -= 3600
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.Put("keep", key, []byte("value"), Persistent)
+	}))
+	dropped, err = db.DropIdleBuckets()
+	require.NoError(t, err)
+	assert.Equal(t, 0, dropped)
+
+	require.NoError(t, db.Close())
+}
@@ -0,0 +1,89 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import "errors"
+
+// ErrImmutableBucket is returned when overwriting or deleting a key of an immutable bucket.
+var ErrImmutableBucket = errors.New("the bucket is immutable")
+
+// isImmutable reports whether the key/value bucket is write-once.
+func (db *DB) isImmutable(bucket string) bool {
+	return db.opt.ImmutableBuckets[bucket]
+}
+
+// checkImmutable returns ErrImmutableBucket if the write would overwrite or delete
+// a live key of an immutable bucket, or delete the bucket itself. The expired keys are deleted
+// as in any bucket, e.g. by the reaper.
+// The entries carried over by Merge are written again as they are, so they are not checked.
+func (tx *Tx) checkImmutable(bucket string, key []byte, flag uint16, ds uint16) error {
+	if tx.isMerge || !tx.db.isImmutable(bucket) {
+		return nil
+	}
+
+	if ds == DataStructureNone && flag == DataBPTreeBucketDeleteFlag {
+		return ErrImmutableBucket
+	}
+
+	if ds != DataStructureBPTree {
+		return nil
+	}
+
+	if flag != DataDeleteFlag {
+		if _, ok, _ := tx.pendingGet(bucket, key); ok {
+			return ErrImmutableBucket
+		}
+	}
+
+	exists, err := tx.pendingKeyExists(bucket, key)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrImmutableBucket
+	}
+
+	return nil
+}
+
+// keyExists reports whether the key is live in the key/value bucket, without reading its value.
+func (tx *Tx) keyExists(bucket string, key []byte) (bool, error) {
+	if tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		e, err := tx.getByHintBPTSparseIdx(bucket, key)
+		if err == ErrNotFoundKey || err == ErrKeyNotFound {
+			return false, nil
+		}
+		return e != nil && e.Meta.Flag != DataDeleteFlag, err
+	}
+
+	idx, ok := tx.db.BPTreeIdx[bucket]
+	if !ok {
+		return false, nil
+	}
+
+	r, err := idx.Find(key)
+	if err == ErrKeyNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if _, ok := tx.db.committedTxIds[r.H.Meta.TxID]; !ok {
+		return false, nil
+	}
+
+	return r.H.Meta.Flag != DataDeleteFlag && !tx.db.isExpired(bucket, r.H.Meta), nil
+}
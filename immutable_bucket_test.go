@@ -0,0 +1,116 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_ImmutableBucket(t *testing.T) {
+	bucket := "bucket_immutable"
+
+	InitOpt("/tmp/nutsdbtestimmutablebucket", true)
+	opt.SegmentSize = 120
+	opt.CompactionFilter = func(ctx context.Context, bucket string, key, value []byte, meta *MetaData) (CompactionDecision, []byte) {
+		return CompactionRemove, nil
+	}
+	db, err = Open(opt, WithImmutableBucket(bucket))
+	require.NoError(t, err)
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		if err := tx.Put(bucket, []byte("key_1"), []byte("value"), Persistent); err != nil {
+			return err
+		}
+		assert.Equal(t, ErrImmutableBucket, tx.Put(bucket, []byte("key_1"), []byte("other"), Persistent))
+		// an expired key is written again.
+		expired := uint64(time.Now().Add(-time.Hour).Unix())
+		return tx.PutWithTimestamp(bucket, []byte("key_expired"), []byte("value"), 1, expired)
+	}))
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		assert.Equal(t, ErrImmutableBucket, tx.Put(bucket, []byte("key_1"), []byte("other"), Persistent))
+		assert.Equal(t, ErrImmutableBucket, tx.Delete(bucket, []byte("key_1")))
+		assert.Equal(t, ErrImmutableBucket, tx.DeleteBucket(DataStructureBPTree, bucket))
+		if err := tx.Put(bucket, []byte("key_expired"), []byte("new_value"), Persistent); err != nil {
+			return err
+		}
+		if err := tx.Put(bucket, []byte("key_2"), []byte("value"), Persistent); err != nil {
+			return err
+		}
+		// the other buckets are not affected.
+		if err := tx.Put("bucket", []byte("key_1"), []byte("value"), Persistent); err != nil {
+			return err
+		}
+		return tx.Put("bucket", []byte("key_1"), []byte("other"), Persistent)
+	}))
+
+	// Merge does not pass the entries of the immutable buckets to the CompactionFilter.
+	require.NoError(t, db.Merge())
+
+	require.NoError(t, db.View(func(tx *Tx) error {
+		for key, value := range map[string]string{"key_1": "value", "key_2": "value", "key_expired": "new_value"} {
+			e, err := tx.Get(bucket, []byte(key))
+			if assert.NoError(t, err) {
+				assert.Equal(t, []byte(value), e.Value)
+			}
+		}
+		_, err := tx.Get("bucket", []byte("key_1"))
+		assert.Error(t, err)
+		return nil
+	}))
+	require.NoError(t, db.Close())
+}
+
+func TestDB_ImmutableBucketReapExpired(t *testing.T) {
+	bucket := "bucket_immutable_reap"
+
+	InitOpt("/tmp/nutsdbtestimmutablebucketreap", true)
+	db, err = Open(opt, WithImmutableBucket(bucket))
+	require.NoError(t, err)
+
+	expired := uint64(time.Now().Add(-time.Hour).Unix())
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		if err := tx.PutWithTimestamp(bucket, []byte("key_expired"), []byte("value"), 1, expired); err != nil {
+			return err
+		}
+		if err := tx.PutWithTimestamp("bucket", []byte("key_expired"), []byte("value"), 1, expired); err != nil {
+			return err
+		}
+		return tx.Put(bucket, []byte("key_live"), []byte("value"), Persistent)
+	}))
+
+	// the expired keys of the immutable buckets are reaped along with the others.
+	for i := 0; i < 2; i++ {
+		reaped, err := db.SweepExpired()
+		require.NoError(t, err)
+		assert.Equal(t, 2*(1-i), reaped)
+	}
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		assert.Equal(t, ErrImmutableBucket, tx.Delete(bucket, []byte("key_live")))
+		return nil
+	}))
+	require.NoError(t, db.View(func(tx *Tx) error {
+		_, err := tx.Get(bucket, []byte("key_live"))
+		assert.NoError(t, err)
+		return nil
+	}))
+	require.NoError(t, db.Close())
+}
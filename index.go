@@ -20,6 +20,9 @@ type ListIdx map[string]*list.List
 
 type index struct {
 	list ListIdx
+
+	// clock is the Clock of the lists added, the clock of the db.
+	clock func() int64
 }
 
 func NewIndex() *index {
@@ -41,6 +44,7 @@ func (i *index) addList(bucket string) {
 		Items:     map[string][][]byte{},
 		TTL:       map[string]uint32{},
 		TimeStamp: map[string]uint64{},
+		Clock:     i.clock,
 	}
 	i.list[bucket] = l
 }
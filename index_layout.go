@@ -0,0 +1,61 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+// keyArenaChunkSize is the size of the chunks the keys are copied into.
+const keyArenaChunkSize = 1 * MB
+
+// keyArena copies the keys into large shared chunks, so that the GC marks
+// one object per chunk instead of one per key.
+type keyArena struct {
+	chunk []byte
+}
+
+// copy returns a copy of the key stored in the arena. Large keys get their own allocation.
+func (a *keyArena) copy(key []byte) []byte {
+	if len(key) > keyArenaChunkSize/16 {
+		return append([]byte(nil), key...)
+	}
+	if cap(a.chunk)-len(a.chunk) < len(key) {
+		a.chunk = make([]byte, 0, keyArenaChunkSize)
+	}
+	start := len(a.chunk)
+	a.chunk = append(a.chunk, key...)
+	return a.chunk[start:len(a.chunk):len(a.chunk)]
+}
+
+// arenaHint allocates a hint along with its metadata.
+type arenaHint struct {
+	Hint
+	meta MetaData
+}
+
+func newKeyArena(layout IndexLayout) *keyArena {
+	if layout != IndexLayoutArena {
+		return nil
+	}
+	return &keyArena{}
+}
+
+// newHint returns the hint of the record indexing the key, laid out as set by Options.IndexLayout.
+func (db *DB) newHint(key []byte, fileID int64, meta *MetaData, dataPos uint64) *Hint {
+	if db.keyArena == nil {
+		return &Hint{Key: key, FileID: fileID, Meta: meta, DataPos: dataPos}
+	}
+
+	h := &arenaHint{meta: *meta}
+	h.Hint = Hint{Key: db.keyArena.copy(key), FileID: fileID, Meta: &h.meta, DataPos: dataPos}
+	return &h.Hint
+}
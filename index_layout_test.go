@@ -0,0 +1,88 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyArena_Copy(t *testing.T) {
+	a := &keyArena{}
+	k1 := a.copy([]byte("key_1"))
+	k2 := a.copy([]byte("key_2"))
+	assert.Equal(t, []byte("key_1"), k1)
+	assert.Equal(t, []byte("key_2"), k2)
+
+	// appending to a key must not overwrite the next one.
+	_ = append(k1, 'x')
+	assert.Equal(t, []byte("key_2"), k2)
+
+	large := make([]byte, keyArenaChunkSize)
+	assert.Equal(t, large, a.copy(large))
+}
+
+func TestDB_IndexLayoutArena(t *testing.T) {
+	for _, mode := range []EntryIdxMode{HintKeyValAndRAMIdxMode, HintKeyAndRAMIdxMode} {
+		InitOpt("/tmp/nutsdbtestindexlayout", true)
+		opt.EntryIdxMode = mode
+		db, err = Open(opt, WithIndexLayout(IndexLayoutArena))
+		require.NoError(t, err)
+
+		bucket := "bucket"
+		err = db.Update(func(tx *Tx) error {
+			for i := 0; i < 100; i++ {
+				if err := tx.Put(bucket, []byte(fmt.Sprintf("key_%03d", i)), []byte(fmt.Sprintf("value_%d", i)), Persistent); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		err = db.Update(func(tx *Tx) error {
+			return tx.Delete(bucket, []byte("key_000"))
+		})
+		require.NoError(t, err)
+
+		check := func() {
+			err := db.View(func(tx *Tx) error {
+				e, err := tx.Get(bucket, []byte("key_042"))
+				if assert.NoError(t, err) {
+					assert.Equal(t, []byte("value_42"), e.Value)
+				}
+				_, err = tx.Get(bucket, []byte("key_000"))
+				assert.Error(t, err)
+
+				entries, err := tx.RangeScan(bucket, []byte("key_010"), []byte("key_019"))
+				if assert.NoError(t, err) {
+					assert.Len(t, entries, 10)
+				}
+				return nil
+			})
+			require.NoError(t, err)
+		}
+
+		check()
+		require.NoError(t, db.Close())
+
+		db, err = Open(opt, WithIndexLayout(IndexLayoutArena))
+		require.NoError(t, err)
+		check()
+		require.NoError(t, db.Close())
+	}
+}
@@ -0,0 +1,31 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import "strings"
+
+// internalBucketPrefix is the prefix of the buckets nutsdb uses to store its own bookkeeping,
+// they are hidden from IterateBuckets.
+const internalBucketPrefix = "__nutsdb_"
+
+// internalBucket returns the internal bucket of given kind attached to the bucket.
+func internalBucket(kind, bucket string) string {
+	return internalBucketPrefix + kind + "_" + bucket
+}
+
+// isInternalBucket returns if the bucket is one of the internal buckets.
+func isInternalBucket(bucket string) bool {
+	return strings.HasPrefix(bucket, internalBucketPrefix)
+}
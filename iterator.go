@@ -14,7 +14,10 @@
 
 package nutsdb
 
-import "fmt"
+import (
+	"bytes"
+	"fmt"
+)
 
 type Iterator struct {
 	tx      *Tx
@@ -26,10 +29,36 @@ type Iterator struct {
 	bucket string
 
 	entry *Entry
+
+	// prefetched holds the entries fetched ahead of the next SetNext calls.
+	prefetched []*Entry
+
+	// pending is set by Seek and Rewind until the entry they move to is fetched by Valid, Item or Next.
+	pending bool
+	err     error
+
+	// last is the key of the last entry fetched, the pending writes of the tx are merged past it,
+	// or from the key of the last Seek, seekKey, until an entry is fetched, see mergePending.
+	last    []byte
+	seekKey []byte
 }
 
 type IteratorOptions struct {
 	Reverse bool
+
+	// PrefetchSize is the number of entries fetched per pass over the index, zero fetches them one by one.
+	PrefetchSize int
+
+	// BatchValues makes the HintKeyAndRAMIdxMode read the values of the entries prefetched together
+	// with a single acquisition of each data file holding them, instead of one per entry.
+	BatchValues bool
+
+	// Prefix bounds the iteration to the keys starting with it. Nil means all the keys of the bucket.
+	Prefix []byte
+
+	// KeysOnly makes the entries carry the keys and the meta of the index without their values,
+	// so that the data files are not read.
+	KeysOnly bool
 }
 
 func NewIterator(tx *Tx, bucket string, options IteratorOptions) *Iterator {
@@ -40,6 +69,20 @@ func NewIterator(tx *Tx, bucket string, options IteratorOptions) *Iterator {
 	}
 }
 
+// NewIterator returns an iterator over the keys of the bucket in their sorted order, see NewIterator.
+// It is positioned at the first key, in the order of the options, as after Rewind:
+//
+//	it := tx.NewIterator(bucket, IteratorOptions{Prefix: prefix})
+//	for ; it.Valid(); it.Next() {
+//		entry := it.Item()
+//	}
+//	err := it.Err()
+func (tx *Tx) NewIterator(bucket string, options IteratorOptions) *Iterator {
+	it := NewIterator(tx, bucket, options)
+	it.pending = true
+	return it
+}
+
 // SetNext would set the next Entry item, and would return (true, nil) if the next item is available
 // Otherwise if the next item is not available it would return (false, nil)
 // If it faces error it would return (false, err)
@@ -52,113 +95,346 @@ func (it *Iterator) SetNext() (bool, error) {
 		return false, err
 	}
 
-	if it.i == -2 {
-		return false, nil
+	if len(it.prefetched) == 0 {
+		if err := it.prefetch(); err != nil {
+			return false, err
+		}
+		if len(it.prefetched) == 0 {
+			it.pending = false
+			it.entry = nil
+			return false, nil
+		}
 	}
 
-	if it.current == nil && (it.tx.db.opt.EntryIdxMode == HintKeyAndRAMIdxMode ||
-		it.tx.db.opt.EntryIdxMode == HintKeyValAndRAMIdxMode) {
-		if index, ok := it.tx.db.BPTreeIdx[it.bucket]; ok {
-			if it.options.Reverse {
-				err := it.Seek(index.LastKey)
-				if err != nil {
-					return false, err
-				}
-			} else {
-				err := it.Seek(index.FirstKey)
-				if err != nil {
-					return false, err
-				}
-			}
+	it.pending = false
+	it.entry = it.prefetched[0]
+	it.prefetched[0] = nil
+	it.prefetched = it.prefetched[1:]
+	return true, nil
+}
+
+// prefetch fetches the next PrefetchSize entries, or the next one.
+func (it *Iterator) prefetch() error {
+	size := it.options.PrefetchSize
+	if size < 1 {
+		size = 1
+	}
+
+	records := make([]*Record, 0, size)
+	for len(records) < size {
+		record, err := it.nextRecord()
+		if err != nil {
+			return err
+		}
+		if record == nil {
+			break
+		}
+		records = append(records, record)
+	}
+
+	var entries Entries
+	switch {
+	case it.options.KeysOnly:
+		for _, record := range records {
+			entries = append(entries, &Entry{Key: record.H.Key, Bucket: []byte(it.bucket), Meta: record.H.Meta})
+		}
+	case it.tx.db.opt.EntryIdxMode == HintKeyValAndRAMIdxMode:
+		for _, record := range records {
+			entries = append(entries, record.E)
+		}
+	case it.tx.db.opt.EntryIdxMode == HintKeyAndRAMIdxMode:
+		var err error
+		if entries, err = it.readEntries(records); err != nil {
+			return err
+		}
+	}
+	if !it.options.KeysOnly {
+		if err := it.tx.resolveValues(it.bucket, entries); err != nil {
+			return err
 		}
 	}
 
-	if it.options.Reverse {
-		if it.i < 0 {
-			it.current, _ = it.current.pointers[order].(*Node)
+	// the pending writes up to the last record fetched are merged, or all the ones left past the last record.
+	var upTo []byte
+	if len(records) == size {
+		upTo = records[size-1].H.Key
+	}
+	entries, err := it.tx.mergePending(it.bucket, entries, it.pendingIn(upTo), it.options.Reverse, it.options.KeysOnly)
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		it.last = entries[len(entries)-1].Key
+	}
+	it.prefetched = append(it.prefetched, entries...)
+	return nil
+}
+
+// pendingIn returns whether a key written by the tx is to be merged in the entries fetched up to the key upTo,
+// nil meaning past the last record.
+func (it *Iterator) pendingIn(upTo []byte) func(key []byte) bool {
+	// after reports whether a comes after b in the order of the iteration.
+	after := func(a, b []byte) bool {
+		return (compare(a, b) > 0) != it.options.Reverse
+	}
+	return func(key []byte) bool {
+		if prefix := it.options.Prefix; prefix != nil && !bytes.HasPrefix(key, prefix) {
+			return false
+		}
+		if it.last != nil && !after(key, it.last) {
+			return false
+		}
+		if it.last == nil && it.seekKey != nil && after(it.seekKey, key) {
+			return false
+		}
+		return upTo == nil || !after(key, upTo)
+	}
+}
+
+// nextRecord moves to the next live record of the index, it returns nil past the last one
+// until the next Seek.
+func (it *Iterator) nextRecord() (*Record, error) {
+	for {
+		if it.i == -2 {
+			return nil, nil
+		}
+
+		if it.current == nil && (it.tx.db.opt.EntryIdxMode == HintKeyAndRAMIdxMode ||
+			it.tx.db.opt.EntryIdxMode == HintKeyValAndRAMIdxMode) {
+			if index, ok := it.tx.db.BPTreeIdx[it.bucket]; ok {
+				it.seek(it.firstKey(index))
+			}
+		}
+
+		if it.options.Reverse {
+			if it.i < 0 {
+				it.current, _ = it.current.pointers[order].(*Node)
+				if it.current == nil {
+					it.i = -2
+					return nil, nil
+				}
+				it.i = it.current.KeysNum - 1
+			}
+		} else {
 			if it.current == nil {
-				return false, nil
+				return nil, nil
+			}
+			if it.i >= it.current.KeysNum {
+				it.current, _ = it.current.pointers[order-1].(*Node)
+				if it.current == nil {
+					it.i = -2
+					return nil, nil
+				}
+				it.i = 0
 			}
-			it.i = it.current.KeysNum - 1
 		}
-	} else {
+
 		if it.current == nil {
-			return false, nil
+			return nil, nil
 		}
-		if it.i >= it.current.KeysNum {
-			it.current, _ = it.current.pointers[order-1].(*Node)
-			if it.current == nil {
-				return false, nil
+		pointer := it.current.pointers[it.i]
+		record := pointer.(*Record)
+		key := it.current.Keys[it.i]
+
+		if it.options.Reverse {
+			it.i--
+		} else {
+			it.i++
+		}
+
+		if prefix := it.options.Prefix; prefix != nil && !bytes.HasPrefix(key, prefix) {
+			// the keys before the prefix in the order of the iteration are skipped, the keys past it end it.
+			if (compare(key, prefix) > 0) != it.options.Reverse {
+				it.i = -2
+				return nil, nil
 			}
-			it.i = 0
+			continue
 		}
-	}
 
-	if it.current == nil {
-		return false, nil
+		if record.H.Meta.Flag == DataDeleteFlag || it.tx.db.isExpired(it.bucket, record.H.Meta) {
+			continue
+		}
+		if it.tx.pendingShadows(it.bucket, key) {
+			continue
+		}
+		return record, nil
 	}
-	pointer := it.current.pointers[it.i]
-	record := pointer.(*Record)
+}
 
-	if it.options.Reverse {
-		it.i--
-	} else {
-		it.i++
+// readEntries reads the entries of the records from the data files, in the order of the records.
+func (it *Iterator) readEntries(records []*Record) ([]*Entry, error) {
+	entries := make([]*Entry, len(records))
+	if !it.options.BatchValues {
+		for i, record := range records {
+			if err := it.readFileEntries(record.H.FileID, records[i:i+1], entries[i:i+1]); err != nil {
+				return nil, err
+			}
+		}
+		return entries, nil
 	}
 
-	if record.H.Meta.Flag == DataDeleteFlag || record.IsExpired() {
-		return it.SetNext()
+	var (
+		fileIDs []int64
+		byFile  = make(map[int64][]int)
+	)
+	for i, record := range records {
+		fID := record.H.FileID
+		if _, ok := byFile[fID]; !ok {
+			fileIDs = append(fileIDs, fID)
+		}
+		byFile[fID] = append(byFile[fID], i)
 	}
 
-	if it.tx.db.opt.EntryIdxMode == HintKeyAndRAMIdxMode {
-		path := it.tx.db.getDataPath(record.H.FileID)
-		df, err := it.tx.db.fm.getDataFile(path, it.tx.db.opt.SegmentSize)
-		if err != nil {
-			return false, err
+	for _, fID := range fileIDs {
+		idx := byFile[fID]
+		fileRecords := make([]*Record, len(idx))
+		fileEntries := make([]*Entry, len(idx))
+		for j, i := range idx {
+			fileRecords[j] = records[i]
+		}
+		if err := it.readFileEntries(fID, fileRecords, fileEntries); err != nil {
+			return nil, err
 		}
+		for j, i := range idx {
+			entries[i] = fileEntries[j]
+		}
+	}
+	return entries, nil
+}
 
-		if item, err := df.ReadAt(int(record.H.DataPos)); err == nil {
-			err = df.rwManager.Release()
-			if err != nil {
-				return false, err
-			}
+// readFileEntries reads into entries the entries of the records held by the data file at given fID.
+func (it *Iterator) readFileEntries(fID int64, records []*Record, entries []*Entry) error {
+	path := it.tx.db.getDataPath(fID)
+	df, err := it.tx.db.fm.getDataFile(path, it.tx.db.opt.SegmentSize)
+	if err != nil {
+		return err
+	}
 
-			it.entry = item
-			return true, nil
-		} else {
+	for i, record := range records {
+		item, err := df.ReadAt(int(record.H.DataPos))
+		if err != nil {
+			item, err = it.tx.db.readRepair(it.tx.ctx, it.bucket, record.H, err)
+		}
+		if err != nil {
 			releaseErr := df.rwManager.Release()
 			if releaseErr != nil {
-				return false, releaseErr
+				return releaseErr
 			}
-			return false, fmt.Errorf("HintIdx r.Hi.dataPos %d, err %s", record.H.DataPos, err)
+			return fmt.Errorf("HintIdx r.Hi.dataPos %d, err %s", record.H.DataPos, err)
 		}
+		entries[i] = item
 	}
 
-	if it.tx.db.opt.EntryIdxMode == HintKeyValAndRAMIdxMode {
-		it.entry = record.E
-		return true, nil
-	}
-
-	return false, nil
+	return df.rwManager.Release()
 }
 
 // Seek would seek to the key,
-// If the key is not available it would seek to the first smallest greater key than the input key.
+// If the key is not available it would seek to the first smallest greater key than the input key,
+// or to the first greater smaller key with the Reverse.
 func (it *Iterator) Seek(key []byte) error {
 	if it.tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode {
 		return fmt.Errorf("%s mode is not supported in iterators", "HintBPTSparseIdxMode")
 	}
 
-	it.current = it.tx.db.BPTreeIdx[it.bucket].FindLeaf(key)
+	it.prefetched = nil
+	it.entry = nil
+	it.pending = true
+	it.last, it.seekKey = nil, key
+	it.seek(key)
+	return nil
+}
+
+// seek moves to the key, see Seek.
+func (it *Iterator) seek(key []byte) {
+	index, ok := it.tx.db.BPTreeIdx[it.bucket]
+	if !ok {
+		it.i = -2
+		return
+	}
+	it.current = index.FindLeaf(key)
 	if it.current == nil {
 		it.i = -2
+		return
 	}
 
 	for it.i = 0; it.i < it.current.KeysNum && compare(it.current.Keys[it.i], key) < 0; {
 		it.i++
 	}
+	if it.options.Reverse && (it.i == it.current.KeysNum || compare(it.current.Keys[it.i], key) > 0) {
+		it.i--
+	}
+}
 
-	return nil
+// firstKey returns the key the iteration starts at, in the order of the iteration.
+func (it *Iterator) firstKey(index *BPTree) []byte {
+	prefix := it.options.Prefix
+	if !it.options.Reverse {
+		if prefix != nil {
+			return prefix
+		}
+		return index.FirstKey
+	}
+
+	// the first key past the prefix, the keys starting with it are before it.
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			end := append([]byte(nil), prefix[:i+1]...)
+			end[i]++
+			return end
+		}
+	}
+	return index.LastKey
+}
+
+// Rewind moves to the first key, in the order of the iteration.
+func (it *Iterator) Rewind() {
+	it.current = nil
+	it.i = 0
+	it.prefetched = nil
+	it.entry = nil
+	it.err = nil
+	it.pending = true
+	it.last, it.seekKey = nil, nil
+}
+
+// Valid returns whether the iterator is at an entry, false past the last one or on an error, see Err.
+func (it *Iterator) Valid() bool {
+	if it.pending {
+		it.fetch()
+	}
+	return it.entry != nil
+}
+
+// Next moves to the next entry.
+func (it *Iterator) Next() {
+	if it.pending {
+		it.fetch()
+	}
+	if it.entry != nil {
+		it.fetch()
+	}
+}
+
+// Item returns the entry the iterator is at, nil when it is not Valid.
+func (it *Iterator) Item() *Entry {
+	if it.pending {
+		it.fetch()
+	}
+	return it.entry
+}
+
+// Err returns the error which made the iterator not Valid, if any.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// fetch moves to the next entry for Valid, Item and Next.
+func (it *Iterator) fetch() {
+	if _, err := it.SetNext(); err != nil {
+		it.pending = false
+		it.entry = nil
+		it.err = err
+	}
 }
 
 // Entry would return the current Entry item after calling SetNext
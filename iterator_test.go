@@ -16,9 +16,11 @@ package nutsdb
 
 import (
 	"fmt"
+	"io/ioutil"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestIterator_SetNext(t *testing.T) {
@@ -240,3 +242,187 @@ func TestIterator_Seek(t *testing.T) {
 		})
 	})
 }
+
+func TestIterator_Prefetch(t *testing.T) {
+	bucket := "bucket_for_iterator"
+	keyAt := func(i int) []byte {
+		return []byte("key_" + fmt.Sprintf("%07d", i))
+	}
+
+	for _, mode := range []EntryIdxMode{HintKeyValAndRAMIdxMode, HintKeyAndRAMIdxMode} {
+		tmpdir, _ := ioutil.TempDir("", "nutsdb")
+		opt := DefaultOptions
+		opt.Dir = tmpdir
+		opt.EntryIdxMode = mode
+		opt.SegmentSize = 1024
+
+		withDBOption(t, opt, func(t *testing.T, db *DB) {
+			// the entries span several data files, every third one is deleted.
+			for i := 0; i < 100; i++ {
+				require.NoError(t, db.Update(func(tx *Tx) error {
+					return tx.Put(bucket, keyAt(i), []byte(fmt.Sprintf("val_%d", i)), Persistent)
+				}))
+			}
+			for i := 0; i < 100; i += 3 {
+				require.NoError(t, db.Update(func(tx *Tx) error {
+					return tx.Delete(bucket, keyAt(i))
+				}))
+			}
+
+			for _, options := range []IteratorOptions{
+				{},
+				{PrefetchSize: 7},
+				{PrefetchSize: 7, BatchValues: true},
+				{PrefetchSize: 1000, BatchValues: true},
+				{PrefetchSize: 7, BatchValues: true, Reverse: true},
+			} {
+				require.NoError(t, db.View(func(tx *Tx) error {
+					var want []int
+					for i := 0; i < 100; i++ {
+						if i%3 != 0 {
+							want = append(want, i)
+						}
+					}
+					if options.Reverse {
+						for l, r := 0, len(want)-1; l < r; l, r = l+1, r-1 {
+							want[l], want[r] = want[r], want[l]
+						}
+					}
+
+					it := NewIterator(tx, bucket, options)
+					for _, i := range want {
+						ok, err := it.SetNext()
+						require.NoError(t, err)
+						require.True(t, ok, "mode %d options %+v", mode, options)
+						assert.Equal(t, keyAt(i), it.Entry().Key)
+						assert.Equal(t, []byte(fmt.Sprintf("val_%d", i)), it.Entry().Value)
+					}
+					ok, err := it.SetNext()
+					assert.NoError(t, err)
+					assert.False(t, ok)
+
+					// seeking drops the entries prefetched.
+					if !options.Reverse {
+						require.NoError(t, it.Seek(keyAt(50)))
+						ok, err = it.SetNext()
+						require.NoError(t, err)
+						require.True(t, ok)
+						assert.Equal(t, keyAt(50), it.Entry().Key)
+					}
+					return nil
+				}))
+			}
+		})
+	}
+}
+
+func TestTx_NewIterator(t *testing.T) {
+	bucket := "bucket_for_tx_iterator"
+	withDefaultDB(t, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			for _, prefix := range []string{"a", "b", "c"} {
+				for i := 0; i < 20; i++ {
+					key := []byte(fmt.Sprintf("%s_%03d", prefix, i))
+					if err := tx.Put(bucket, key, append([]byte("val_"), key...), Persistent); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		}))
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.Delete(bucket, []byte("b_005"))
+		}))
+
+		keys := func(it *Iterator) []string {
+			var keys []string
+			for ; it.Valid(); it.Next() {
+				keys = append(keys, string(it.Item().Key))
+			}
+			require.NoError(t, it.Err())
+			return keys
+		}
+		var want []string
+		for i := 0; i < 20; i++ {
+			if i != 5 {
+				want = append(want, fmt.Sprintf("b_%03d", i))
+			}
+		}
+
+		require.NoError(t, db.View(func(tx *Tx) error {
+			it := tx.NewIterator(bucket, IteratorOptions{Prefix: []byte("b")})
+			assert.Equal(t, want, keys(it))
+
+			it.Rewind()
+			require.True(t, it.Valid())
+			assert.Equal(t, []byte("val_b_000"), it.Item().Value)
+
+			require.NoError(t, it.Seek([]byte("b_010")))
+			assert.Equal(t, want[9:], keys(it))
+
+			reversed := make([]string, len(want))
+			for i, key := range want {
+				reversed[len(want)-1-i] = key
+			}
+			it = tx.NewIterator(bucket, IteratorOptions{Prefix: []byte("b"), Reverse: true, PrefetchSize: 4})
+			assert.Equal(t, reversed, keys(it))
+
+			// seeking in reverse moves to the greatest key not past the key sought.
+			require.NoError(t, it.Seek([]byte("b_005")))
+			assert.Equal(t, reversed[14:], keys(it))
+
+			it = tx.NewIterator(bucket, IteratorOptions{KeysOnly: true})
+			require.True(t, it.Valid())
+			assert.Equal(t, []byte("a_000"), it.Item().Key)
+			assert.Nil(t, it.Item().Value)
+			assert.Len(t, keys(it), 59)
+
+			it = tx.NewIterator(bucket, IteratorOptions{Prefix: []byte("d")})
+			assert.False(t, it.Valid())
+			assert.Nil(t, it.Item())
+			return nil
+		}))
+	})
+}
+
+func TestIterator_PendingWrites(t *testing.T) {
+	bucket := "bucket_iterator_pending_writes"
+	testKey := func(i int) []byte {
+		return []byte(fmt.Sprintf("key_%07d", i))
+	}
+	withDefaultDB(t, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			for i := 0; i < 10; i += 2 {
+				require.NoError(t, tx.Put(bucket, testKey(i), testKey(i), Persistent))
+			}
+			return nil
+		}))
+
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			require.NoError(t, tx.Put(bucket, testKey(3), testKey(3), Persistent))
+			require.NoError(t, tx.Put(bucket, testKey(9), testKey(9), Persistent))
+			require.NoError(t, tx.Delete(bucket, testKey(4)))
+
+			for _, options := range []IteratorOptions{{}, {PrefetchSize: 2}, {KeysOnly: true}} {
+				var keys [][]byte
+				it := tx.NewIterator(bucket, options)
+				for ; it.Valid(); it.Next() {
+					keys = append(keys, it.Item().Key)
+					if !options.KeysOnly {
+						assert.Equal(t, it.Item().Key, it.Item().Value)
+					}
+				}
+				require.NoError(t, it.Err())
+				assert.Equal(t, [][]byte{testKey(0), testKey(2), testKey(3), testKey(6), testKey(8), testKey(9)}, keys)
+			}
+
+			it := tx.NewIterator(bucket, IteratorOptions{})
+			require.NoError(t, it.Seek(testKey(3)))
+			require.True(t, it.Valid())
+			assert.Equal(t, testKey(3), it.Item().Key)
+			it.Next()
+			assert.Equal(t, testKey(6), it.Item().Key)
+			return nil
+		}))
+	})
+}
@@ -0,0 +1,92 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"math/rand"
+	"time"
+)
+
+// LatencyDistribution returns the delay injected before a file operation.
+type LatencyDistribution func() time.Duration
+
+// SimulatedLatency delays the operations on the data files to simulate a slow disk,
+// so that the applications can test their timeouts and backpressure without slow hardware.
+// A nil distribution leaves the operation undelayed. It is meant for testing only.
+type SimulatedLatency struct {
+	Write LatencyDistribution
+	Sync  LatencyDistribution
+	Read  LatencyDistribution
+}
+
+// enabled reports whether any of the operations is delayed.
+func (l SimulatedLatency) enabled() bool {
+	return l.Write != nil || l.Sync != nil || l.Read != nil
+}
+
+// FixedLatency always delays by d.
+func FixedLatency(d time.Duration) LatencyDistribution {
+	return func() time.Duration {
+		return d
+	}
+}
+
+// UniformLatency delays by a duration picked uniformly in [min, max].
+func UniformLatency(min, max time.Duration) LatencyDistribution {
+	return func() time.Duration {
+		if max <= min {
+			return min
+		}
+		return min + time.Duration(rand.Int63n(int64(max-min)+1))
+	}
+}
+
+// SpikeLatency delays by base, and by spike instead with the given probability,
+// like a disk stalling now and then.
+func SpikeLatency(base, spike time.Duration, probability float64) LatencyDistribution {
+	return func() time.Duration {
+		if rand.Float64() < probability {
+			return spike
+		}
+		return base
+	}
+}
+
+// slowRWManager delays the operations of the wrapped RWManager.
+type slowRWManager struct {
+	RWManager
+	latency SimulatedLatency
+}
+
+func sleepFor(d LatencyDistribution) {
+	if d != nil {
+		time.Sleep(d())
+	}
+}
+
+func (m *slowRWManager) WriteAt(b []byte, off int64) (n int, err error) {
+	sleepFor(m.latency.Write)
+	return m.RWManager.WriteAt(b, off)
+}
+
+func (m *slowRWManager) ReadAt(b []byte, off int64) (n int, err error) {
+	sleepFor(m.latency.Read)
+	return m.RWManager.ReadAt(b, off)
+}
+
+func (m *slowRWManager) Sync() (err error) {
+	sleepFor(m.latency.Sync)
+	return m.RWManager.Sync()
+}
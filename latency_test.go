@@ -0,0 +1,63 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_SimulatedLatency(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	bucket, key := "bucket_latency", []byte("key")
+
+	InitOpt("/tmp/nutsdbtestlatency", true)
+	db, err := Open(opt,
+		WithEntryIdxMode(HintKeyAndRAMIdxMode),
+		WithSimulatedLatency(SimulatedLatency{Sync: FixedLatency(delay), Read: FixedLatency(delay)}),
+	)
+	require.NoError(t, err)
+	defer db.Close()
+
+	start := time.Now()
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.Put(bucket, key, []byte("value"), Persistent)
+	}))
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(delay))
+
+	start = time.Now()
+	require.NoError(t, db.View(func(tx *Tx) error {
+		_, err := tx.Get(bucket, key)
+		return err
+	}))
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(delay))
+}
+
+func TestLatencyDistributions(t *testing.T) {
+	assert.Equal(t, time.Second, FixedLatency(time.Second)())
+
+	uniform := UniformLatency(time.Millisecond, 2*time.Millisecond)
+	for i := 0; i < 100; i++ {
+		d := uniform()
+		assert.True(t, d >= time.Millisecond && d <= 2*time.Millisecond, d)
+	}
+	assert.Equal(t, time.Millisecond, UniformLatency(time.Millisecond, time.Millisecond)())
+
+	assert.Equal(t, time.Second, SpikeLatency(0, time.Second, 1)())
+	assert.Equal(t, time.Duration(0), SpikeLatency(0, time.Second, 0)())
+}
@@ -0,0 +1,278 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrLegacyLayout is returned by OpenLegacy when a data file matches none of the known layouts.
+	ErrLegacyLayout = errors.New("unknown data file layout")
+
+	// ErrTargetDirNotEmpty is returned by OpenLegacy when the target directory already holds files.
+	ErrTargetDirNotEmpty = errors.New("the target directory is not empty")
+)
+
+// legacyLayout is a layout of the entries of the data files.
+type legacyLayout int
+
+const (
+	// legacyLayoutUnknown is the layout of a file before its first entry is read.
+	legacyLayoutUnknown legacyLayout = iota
+
+	// legacyLayoutCurrent is the layout written by this version, with the data structure in the header.
+	legacyLayoutCurrent
+
+	// legacyLayoutV0 is the layout of the first releases, whose header has no data structure
+	// since they only stored key/value buckets.
+	legacyLayoutV0
+)
+
+const (
+	// legacyV0HeaderSize is the size of the header of the legacyLayoutV0:
+	// crc(4) | timestamp(8) | keySize(4) | valueSize(4) | flag(2) | ttl(4) | bucketSize(4) | status(2) | txID(8)
+	legacyV0HeaderSize = 40
+
+	// legacyBatchSize is the number of entries written to the converted db by a single transaction.
+	legacyBatchSize = 1024
+)
+
+// headerSize returns the size of the header of the entries of the layout.
+func (l legacyLayout) headerSize() int64 {
+	if l == legacyLayoutV0 {
+		return legacyV0HeaderSize
+	}
+	return DataEntryHeaderSize
+}
+
+// parseMeta decodes the header of an entry of the layout.
+func (l legacyLayout) parseMeta(buf []byte) *MetaData {
+	if l != legacyLayoutV0 {
+		e := new(Entry)
+		_ = e.ParseMeta(buf)
+		return e.Meta
+	}
+
+	return &MetaData{
+		Crc:        binary.LittleEndian.Uint32(buf[0:4]),
+		Timestamp:  binary.LittleEndian.Uint64(buf[4:12]),
+		KeySize:    binary.LittleEndian.Uint32(buf[12:16]),
+		ValueSize:  binary.LittleEndian.Uint32(buf[16:20]),
+		Flag:       binary.LittleEndian.Uint16(buf[20:22]),
+		TTL:        binary.LittleEndian.Uint32(buf[22:26]),
+		BucketSize: binary.LittleEndian.Uint32(buf[26:30]),
+		Status:     binary.LittleEndian.Uint16(buf[30:32]),
+		Ds:         DataStructureBPTree,
+		TxID:       binary.LittleEndian.Uint64(buf[32:40]),
+	}
+}
+
+// OpenLegacy converts the database in dir, written by an older release, into a new database in targetDir
+// and opens it with the options. The data files of dir are left untouched.
+// The layout of every data file is detected from its first entry. The committed transactions are replayed
+// in order into the new database, which rebuilds its own indexes and bucket metadata, so the hint and
+// metadata files of dir are not read. Like the recovery, the reading of a file stops at its first torn entry.
+func OpenLegacy(dir, targetDir string, ops ...Option) (*DB, error) {
+	if f, err := os.Open(targetDir); err == nil {
+		_, err = f.Readdirnames(1)
+		_ = f.Close()
+		if err != io.EOF {
+			return nil, ErrTargetDirNotEmpty
+		}
+	}
+
+	fileIDs, err := legacyDataFileIDs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := Open(DefaultOptions, append([]Option{WithDir(targetDir)}, ops...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.convertLegacy(dir, fileIDs); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// legacyDataFileIDs returns the ids of the data files of dir, sorted.
+func legacyDataFileIDs(dir string) ([]int64, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []int64
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != DataSuffix {
+			continue
+		}
+		id, err := strconv.ParseInt(strings.TrimSuffix(f.Name(), DataSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	return ids, nil
+}
+
+// convertLegacy replays the committed transactions of the data files into the db.
+func (db *DB) convertLegacy(dir string, fileIDs []int64) error {
+	var (
+		pending = make(map[uint64][]*Entry)
+		batch   []*Entry
+	)
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := db.Update(func(tx *Tx) error {
+			for _, e := range batch {
+				m := e.Meta
+				if err := tx.put(string(e.Bucket), e.Key, e.Value, m.TTL, m.Flag, m.Timestamp, m.Ds); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		batch = batch[:0]
+		return err
+	}
+
+	for _, id := range fileIDs {
+		path := filepath.Join(dir, strconv.FormatInt(id, 10)+DataSuffix)
+		err := readLegacyFile(path, func(e *Entry) error {
+			txID := e.Meta.TxID
+			pending[txID] = append(pending[txID], e)
+			if e.Meta.Status != Committed {
+				return nil
+			}
+
+			batch = append(batch, pending[txID]...)
+			delete(pending, txID)
+			if len(batch) >= legacyBatchSize {
+				return flush()
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return flush()
+}
+
+// readLegacyFile calls fn for every entry of the data file, decoded with the layout its first entry matches.
+func readLegacyFile(path string, fn func(e *Entry) error) error {
+	f, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	var (
+		off    int64
+		size   = fi.Size()
+		layout = legacyLayoutUnknown
+	)
+	for {
+		var e *Entry
+		if layout == legacyLayoutUnknown {
+			for _, l := range []legacyLayout{legacyLayoutCurrent, legacyLayoutV0} {
+				if e, err = readLegacyEntry(f, size, off, l); err == nil {
+					layout = l
+					break
+				}
+			}
+			if layout == legacyLayoutUnknown {
+				if err == io.EOF {
+					return nil
+				}
+				return ErrLegacyLayout
+			}
+		} else if e, err = readLegacyEntry(f, size, off, layout); err != nil {
+			if err == io.EOF || err == ErrCrc {
+				return nil
+			}
+			return err
+		}
+
+		if err := fn(e); err != nil {
+			return err
+		}
+		off += layout.headerSize() + e.Meta.PayloadSize()
+	}
+}
+
+// readLegacyEntry reads the entry of the layout at off, it returns io.EOF at the end of the entries.
+func readLegacyEntry(f *os.File, size, off int64, layout legacyLayout) (*Entry, error) {
+	header := make([]byte, layout.headerSize())
+	if _, err := f.ReadAt(header, off); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		return nil, err
+	}
+
+	e := &Entry{Meta: layout.parseMeta(header)}
+	if e.IsZero() {
+		return nil, io.EOF
+	}
+	if e.Meta.hasBucketID() {
+		return nil, ErrLegacyLayout
+	}
+
+	payloadOff := off + int64(len(header))
+	if e.Meta.PayloadSize() > size-payloadOff {
+		return nil, ErrCrc
+	}
+	payload := make([]byte, e.Meta.PayloadSize())
+	if _, err := f.ReadAt(payload, payloadOff); err != nil {
+		return nil, err
+	}
+
+	crc := crc32.ChecksumIEEE(header[4:])
+	if crc32.Update(crc, crc32.IEEETable, payload) != e.Meta.Crc {
+		return nil, ErrCrc
+	}
+
+	if err := e.ParsePayload(payload); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
@@ -0,0 +1,105 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// encodeV0Entry encodes the entry with the header of the legacyLayoutV0.
+func encodeV0Entry(bucket, key, value string, flag, status uint16, txID uint64) []byte {
+	buf := make([]byte, legacyV0HeaderSize+len(bucket)+len(key)+len(value))
+	binary.LittleEndian.PutUint64(buf[4:12], uint64(time.Now().Unix()))
+	binary.LittleEndian.PutUint32(buf[12:16], uint32(len(key)))
+	binary.LittleEndian.PutUint32(buf[16:20], uint32(len(value)))
+	binary.LittleEndian.PutUint16(buf[20:22], flag)
+	binary.LittleEndian.PutUint32(buf[22:26], Persistent)
+	binary.LittleEndian.PutUint32(buf[26:30], uint32(len(bucket)))
+	binary.LittleEndian.PutUint16(buf[30:32], status)
+	binary.LittleEndian.PutUint64(buf[32:40], txID)
+	copy(buf[legacyV0HeaderSize:], bucket+key+value)
+	binary.LittleEndian.PutUint32(buf[0:4], crc32.ChecksumIEEE(buf[4:]))
+	return buf
+}
+
+func TestOpenLegacy(t *testing.T) {
+	bucket := "bucket_legacy"
+	legacyDir, targetDir := "/tmp/nutsdbtestlegacy", "/tmp/nutsdbtestlegacytarget"
+	require.NoError(t, os.RemoveAll(legacyDir))
+	require.NoError(t, os.RemoveAll(targetDir))
+	require.NoError(t, os.MkdirAll(legacyDir, os.ModePerm))
+
+	var data []byte
+	data = append(data, encodeV0Entry(bucket, "key1", "value1", DataSetFlag, UnCommitted, 1)...)
+	data = append(data, encodeV0Entry(bucket, "key2", "value2", DataSetFlag, Committed, 1)...)
+	data = append(data, encodeV0Entry(bucket, "key1", "", DataDeleteFlag, Committed, 2)...)
+	// never committed.
+	data = append(data, encodeV0Entry(bucket, "key3", "value3", DataSetFlag, UnCommitted, 3)...)
+	data = append(data, make([]byte, 1024)...)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(legacyDir, "0"+DataSuffix), data, 0644))
+
+	// a file written by this version is converted as well.
+	InitOpt(filepath.Join(legacyDir, "current"), true)
+	current, err := Open(opt)
+	require.NoError(t, err)
+	require.NoError(t, current.Update(func(tx *Tx) error {
+		return tx.Put(bucket, []byte("key4"), []byte("value4"), Persistent)
+	}))
+	require.NoError(t, current.Close())
+	require.NoError(t, os.Rename(filepath.Join(legacyDir, "current", "0"+DataSuffix), filepath.Join(legacyDir, "1"+DataSuffix)))
+
+	db, err := OpenLegacy(legacyDir, targetDir)
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.View(func(tx *Tx) error {
+		e, err := tx.Get(bucket, []byte("key2"))
+		require.NoError(t, err)
+		assert.Equal(t, []byte("value2"), e.Value)
+
+		e, err = tx.Get(bucket, []byte("key4"))
+		require.NoError(t, err)
+		assert.Equal(t, []byte("value4"), e.Value)
+
+		_, err = tx.Get(bucket, []byte("key1"))
+		assert.Equal(t, ErrNotFoundKey, err)
+		_, err = tx.Get(bucket, []byte("key3"))
+		assert.Error(t, err)
+		return nil
+	}))
+
+	_, err = OpenLegacy(legacyDir, targetDir)
+	assert.Equal(t, ErrTargetDirNotEmpty, err)
+}
+
+func TestOpenLegacy_UnknownLayout(t *testing.T) {
+	legacyDir, targetDir := "/tmp/nutsdbtestlegacybad", "/tmp/nutsdbtestlegacybadtarget"
+	require.NoError(t, os.RemoveAll(legacyDir))
+	require.NoError(t, os.RemoveAll(targetDir))
+	require.NoError(t, os.MkdirAll(legacyDir, os.ModePerm))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(legacyDir, "0"+DataSuffix), []byte("not a nutsdb data file at all, not at all"), 0644))
+
+	_, err := OpenLegacy(legacyDir, targetDir)
+	assert.Equal(t, ErrLegacyLayout, err)
+}
@@ -0,0 +1,155 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nutsdb/nutsdb/ds/list"
+)
+
+// ErrPopTimeout is returned by BLPop and BRPop when no element is pushed to the list before the timeout.
+var ErrPopTimeout = errors.New("timed out waiting for an element of the list")
+
+// listWaiterKey identifies the list a blocking pop waits for.
+type listWaiterKey struct {
+	bucket string
+	key    string
+}
+
+// listWaiters wakes the blocking pops up when an element is pushed to the list they wait for.
+type listWaiters struct {
+	mu      sync.Mutex
+	waiters map[listWaiterKey]map[chan struct{}]struct{}
+}
+
+func newListWaiters() *listWaiters {
+	return &listWaiters{waiters: make(map[listWaiterKey]map[chan struct{}]struct{})}
+}
+
+// wait registers a waiter for the list, the returned channel receives once elements are pushed to it.
+func (w *listWaiters) wait(bucket string, key []byte) chan struct{} {
+	ch := make(chan struct{}, 1)
+	k := listWaiterKey{bucket: bucket, key: string(key)}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.waiters[k] == nil {
+		w.waiters[k] = make(map[chan struct{}]struct{})
+	}
+	w.waiters[k][ch] = struct{}{}
+	return ch
+}
+
+// cancel unregisters the waiter of the list.
+func (w *listWaiters) cancel(bucket string, key []byte, ch chan struct{}) {
+	k := listWaiterKey{bucket: bucket, key: string(key)}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.waiters[k], ch)
+	if len(w.waiters[k]) == 0 {
+		delete(w.waiters, k)
+	}
+}
+
+// notify wakes the waiters of the lists the committed entries pushed elements to.
+func (w *listWaiters) notify(entries []*Entry) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.waiters) == 0 {
+		return
+	}
+
+	for _, e := range entries {
+		if e.Meta.Ds != DataStructureList {
+			continue
+		}
+		switch e.Meta.Flag {
+		case DataLPushFlag, DataRPushFlag, DataLCompactFlag:
+			w.wakeLocked(listWaiterKey{bucket: string(e.Bucket), key: string(e.Key)})
+		}
+	}
+}
+
+// wakeAll wakes every waiter, once the db is closed.
+func (w *listWaiters) wakeAll() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for k := range w.waiters {
+		w.wakeLocked(k)
+	}
+}
+
+// wakeLocked wakes and unregisters the waiters of the list, the waiters register again before retrying.
+func (w *listWaiters) wakeLocked(k listWaiterKey) {
+	for ch := range w.waiters[k] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+	delete(w.waiters, k)
+}
+
+// BLPop removes and returns the first element of the list stored in the bucket at given bucket and key,
+// waiting up to the timeout for an element to be pushed when the list is empty or missing.
+// A timeout of zero or less waits until an element is pushed or the db is closed.
+func (db *DB) BLPop(bucket string, key []byte, timeout time.Duration) ([]byte, error) {
+	return db.blockingPop(bucket, key, timeout, func(tx *Tx) ([]byte, error) {
+		return tx.LPop(bucket, key)
+	})
+}
+
+// BRPop removes and returns the last element of the list stored in the bucket at given bucket and key,
+// waiting like BLPop when the list is empty or missing.
+func (db *DB) BRPop(bucket string, key []byte, timeout time.Duration) ([]byte, error) {
+	return db.blockingPop(bucket, key, timeout, func(tx *Tx) ([]byte, error) {
+		return tx.RPop(bucket, key)
+	})
+}
+
+func (db *DB) blockingPop(bucket string, key []byte, timeout time.Duration, pop func(tx *Tx) ([]byte, error)) ([]byte, error) {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	for {
+		// the waiter is registered before trying, so that a push committed in between is not missed.
+		ch := db.listWaiters.wait(bucket, key)
+
+		var item []byte
+		err := db.Update(func(tx *Tx) (err error) {
+			item, err = pop(tx)
+			return err
+		})
+		if err != ErrBucket && err != ErrKeyNotFound && err != list.ErrListNotFound {
+			db.listWaiters.cancel(bucket, key, ch)
+			return item, err
+		}
+
+		select {
+		case <-ch:
+		case <-expired:
+			db.listWaiters.cancel(bucket, key, ch)
+			return nil, ErrPopTimeout
+		}
+	}
+}
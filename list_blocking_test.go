@@ -0,0 +1,100 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_BLPop(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestblpop", true)
+	db, err := Open(opt)
+	require.NoError(t, err)
+	defer db.Close()
+
+	bucket, key := "bucket_blpop", []byte("queue")
+	push := func(values ...string) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			for _, v := range values {
+				if err := tx.RPush(bucket, key, []byte(v)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+	}
+
+	start := time.Now()
+	_, err = db.BRPop(bucket, key, 20*time.Millisecond)
+	assert.Equal(t, ErrPopTimeout, err)
+	assert.True(t, time.Since(start) >= 20*time.Millisecond)
+
+	push("a", "b")
+	item, err := db.BRPop(bucket, key, time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("b"), item)
+	item, err = db.BLPop(bucket, key, time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("a"), item)
+
+	// the waiting pops are woken up by the pushes, one element each.
+	items := make(chan []byte, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			item, err := db.BLPop(bucket, key, 0)
+			assert.NoError(t, err)
+			items <- item
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	push("c")
+	push("d")
+
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case item := <-items:
+			got[string(item)] = true
+		case <-time.After(time.Second):
+			t.Fatal("the blocking pop was not woken up")
+		}
+	}
+	assert.Equal(t, map[string]bool{"c": true, "d": true}, got)
+}
+
+func TestDB_BLPop_Close(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestblpopclose", true)
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := db.BLPop("bucket_blpop_close", []byte("queue"), 0)
+		errs <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, db.Close())
+
+	select {
+	case err := <-errs:
+		assert.Equal(t, ErrDBClosed, err)
+	case <-time.After(time.Second):
+		t.Fatal("the blocking pop was not woken up by Close")
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrDeadlock is returned when opening the transaction would wait for a lock
+// that can never be released, like opening a transaction inside another one with its context.
+var ErrDeadlock = errors.New("deadlock detected")
+
+// txCtxKey is the context key of the transaction, it links the transactions opened
+// with the context of another transaction to it.
+type txCtxKey struct{}
+
+// LockState describes a transaction holding or waiting for the db lock.
+type LockState struct {
+	TxID     uint64
+	Writable bool
+
+	// Since is when the transaction started holding or waiting for the lock.
+	Since time.Time
+
+	// ParentTxID is the id of the transaction whose context opened this one, zero if none.
+	ParentTxID uint64
+
+	seq uint64
+}
+
+// LockDiagnostics is a snapshot of the holders and the waiters of the db lock.
+type LockDiagnostics struct {
+	Holders []LockState
+	Waiters []LockState
+}
+
+// String dumps the holders and the waiters, oldest first.
+func (d LockDiagnostics) String() string {
+	var b strings.Builder
+	now := time.Now()
+	dump := func(title string, states []LockState) {
+		fmt.Fprintf(&b, "%s:\n", title)
+		for _, s := range states {
+			mode := "read"
+			if s.Writable {
+				mode = "write"
+			}
+			fmt.Fprintf(&b, "  tx %d %s for %s", s.TxID, mode, now.Sub(s.Since))
+			if s.ParentTxID != 0 {
+				fmt.Fprintf(&b, " opened by tx %d", s.ParentTxID)
+			}
+			b.WriteString("\n")
+		}
+	}
+	dump("holders", d.Holders)
+	dump("waiters", d.Waiters)
+	return b.String()
+}
+
+// lockGraph tracks the holders and the waiters of the db lock as a wait-for graph:
+// a waiter waits for the holders and the earlier waiting writers it conflicts with,
+// and a transaction waits for the transactions opened with its context.
+type lockGraph struct {
+	mu      sync.Mutex
+	seq     uint64
+	nested  int
+	holders map[uint64]*LockState
+	waiters map[uint64]*LockState
+}
+
+func newLockGraph() *lockGraph {
+	return &lockGraph{holders: make(map[uint64]*LockState), waiters: make(map[uint64]*LockState)}
+}
+
+// wait registers the tx as a waiter of the lock, it returns ErrDeadlock if
+// the tx would close a cycle of the wait-for graph.
+func (g *lockGraph) wait(txID uint64, writable bool, parentTxID uint64) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	// the parent only matters while it holds the lock of this db.
+	if _, ok := g.holders[parentTxID]; !ok {
+		parentTxID = 0
+	}
+
+	g.seq++
+	s := &LockState{TxID: txID, Writable: writable, Since: time.Now(), ParentTxID: parentTxID, seq: g.seq}
+	g.waiters[txID] = s
+	if parentTxID != 0 {
+		g.nested++
+	}
+
+	// without nested transactions no holder ever waits, so there cannot be any cycle.
+	if g.nested > 0 && g.reaches(s, txID, make(map[uint64]bool)) {
+		g.remove(g.waiters, txID)
+		return ErrDeadlock
+	}
+	return nil
+}
+
+// acquired moves the tx from the waiters to the holders.
+func (g *lockGraph) acquired(txID uint64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	s, ok := g.waiters[txID]
+	if !ok {
+		return
+	}
+	delete(g.waiters, txID)
+	s.Since = time.Now()
+	g.holders[txID] = s
+}
+
+// released forgets the tx holding the lock.
+func (g *lockGraph) released(txID uint64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.remove(g.holders, txID)
+}
+
+func (g *lockGraph) remove(states map[uint64]*LockState, txID uint64) {
+	if s, ok := states[txID]; ok {
+		if s.ParentTxID != 0 {
+			g.nested--
+		}
+		delete(states, txID)
+	}
+}
+
+// reaches returns if the target is reachable from s in the wait-for graph.
+func (g *lockGraph) reaches(s *LockState, target uint64, visited map[uint64]bool) bool {
+	if visited[s.TxID] {
+		return false
+	}
+	visited[s.TxID] = true
+
+	for _, next := range g.waitsFor(s) {
+		if next.TxID == target || g.reaches(next, target, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+// waitsFor returns the transactions s waits for.
+func (g *lockGraph) waitsFor(s *LockState) []*LockState {
+	var next []*LockState
+
+	if _, waiting := g.waiters[s.TxID]; waiting {
+		for _, h := range g.holders {
+			if s.Writable || h.Writable {
+				next = append(next, h)
+			}
+		}
+		// a pending writer blocks the readers and the writers coming after it.
+		for _, w := range g.waiters {
+			if w.Writable && w.seq < s.seq {
+				next = append(next, w)
+			}
+		}
+	}
+
+	for _, states := range []map[uint64]*LockState{g.holders, g.waiters} {
+		for _, child := range states {
+			if child.ParentTxID == s.TxID {
+				next = append(next, child)
+			}
+		}
+	}
+
+	return next
+}
+
+// diagnostics returns a snapshot of the graph.
+func (g *lockGraph) diagnostics() LockDiagnostics {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	snapshot := func(states map[uint64]*LockState) []LockState {
+		list := make([]LockState, 0, len(states))
+		for _, s := range states {
+			list = append(list, *s)
+		}
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].seq < list[j].seq
+		})
+		return list
+	}
+	return LockDiagnostics{Holders: snapshot(g.holders), Waiters: snapshot(g.waiters)}
+}
+
+// LockDiagnostics returns the transactions holding and waiting for the db lock,
+// to debug an application stuck on it.
+func (db *DB) LockDiagnostics() LockDiagnostics {
+	return db.locks.diagnostics()
+}
@@ -0,0 +1,109 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_Deadlock(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestdeadlock", true)
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	err = db.Update(func(tx *Tx) error {
+		return db.UpdateWithContext(tx.Context(), func(tx *Tx) error {
+			return nil
+		})
+	})
+	assert.Equal(t, ErrDeadlock, err)
+
+	err = db.View(func(tx *Tx) error {
+		return db.UpdateWithContext(tx.Context(), func(tx *Tx) error {
+			return nil
+		})
+	})
+	assert.Equal(t, ErrDeadlock, err)
+
+	// a nested read-only transaction only deadlocks behind a pending writer.
+	err = db.View(func(tx *Tx) error {
+		return db.ViewWithContext(tx.Context(), func(tx *Tx) error {
+			return nil
+		})
+	})
+	assert.NoError(t, err)
+
+	writerDone := make(chan error, 1)
+	err = db.View(func(parent *Tx) error {
+		go func() {
+			writerDone <- db.Update(func(tx *Tx) error {
+				return nil
+			})
+		}()
+		require.Eventually(t, func() bool {
+			return len(db.LockDiagnostics().Waiters) == 1
+		}, time.Second, time.Millisecond)
+
+		err := db.ViewWithContext(parent.Context(), func(tx *Tx) error {
+			return nil
+		})
+		assert.Equal(t, ErrDeadlock, err)
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, <-writerDone)
+
+	diagnostics := db.LockDiagnostics()
+	assert.Empty(t, diagnostics.Waiters)
+	require.NoError(t, db.Close())
+}
+
+func TestDB_LockDiagnostics(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestlockdiagnostics", true)
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	tx, err := db.Begin(false)
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert.NoError(t, db.Update(func(tx *Tx) error {
+			return nil
+		}))
+	}()
+	require.Eventually(t, func() bool {
+		return len(db.LockDiagnostics().Waiters) == 1
+	}, time.Second, time.Millisecond)
+
+	diagnostics := db.LockDiagnostics()
+	require.Len(t, diagnostics.Holders, 1)
+	assert.Equal(t, tx.id, diagnostics.Holders[0].TxID)
+	assert.False(t, diagnostics.Holders[0].Writable)
+	assert.True(t, diagnostics.Waiters[0].Writable)
+	assert.Contains(t, diagnostics.String(), "write")
+
+	require.NoError(t, tx.Rollback())
+	<-done
+	diagnostics = db.LockDiagnostics()
+	assert.Empty(t, diagnostics.Holders)
+	assert.Empty(t, diagnostics.Waiters)
+	require.NoError(t, db.Close())
+}
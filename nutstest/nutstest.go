@@ -0,0 +1,154 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package nutstest provides helpers for the tests of the code using nutsdb: temporary DBs removed
+// at the end of the test, the reopening of a DB as a crash of the process leaves it, the wait for
+// the expiry of a key, and a clock only moved by the test.
+//
+// On Go versions before 1.14, where testing.TB has no Cleanup, the DBs are not closed nor removed
+// at the end of the test, the test closes them and removes their Options().Dir.
+package nutstest
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nutsdb/nutsdb"
+	"github.com/xujiajun/utils/filesystem"
+)
+
+// pollInterval is the interval MustEventuallyExpire checks the key at.
+const pollInterval = 20 * time.Millisecond
+
+// OpenTemp opens a DB in a new temporary directory with the DefaultOptions and the given options,
+// and fails the test if it cannot. The DB is closed and the directory removed at the end of the test.
+func OpenTemp(t testing.TB, ops ...nutsdb.Option) *nutsdb.DB {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "nutstest")
+	if err != nil {
+		t.Fatalf("nutstest: create the temporary directory: %v", err)
+	}
+	db, err := nutsdb.Open(nutsdb.DefaultOptions, append([]nutsdb.Option{nutsdb.WithDir(dir)}, ops...)...)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		t.Fatalf("nutstest: open the db: %v", err)
+	}
+
+	cleanup(t, func() {
+		_ = closeDB(db)
+		_ = os.RemoveAll(dir)
+	})
+	return db
+}
+
+// CrashAndReopen reopens the db with its options from its files as a crash of the process would leave them:
+// the committed txs are kept, but nothing the db does on Close happens. The db must not be used afterwards,
+// the DB returned is closed at the end of the test.
+func CrashAndReopen(t testing.TB, db *nutsdb.DB) *nutsdb.DB {
+	t.Helper()
+
+	opt := db.Options()
+	dir := filepath.Clean(opt.Dir)
+	crashDir := dir + ".crash"
+
+	if err := os.RemoveAll(crashDir); err != nil {
+		t.Fatalf("nutstest: remove %s: %v", crashDir, err)
+	}
+	if err := filesystem.CopyDir(dir, crashDir); err != nil {
+		t.Fatalf("nutstest: copy the files of the db: %v", err)
+	}
+
+	// the files written by Close are thrown away along with the directory.
+	_ = closeDB(db)
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatalf("nutstest: remove %s: %v", dir, err)
+	}
+	if err := os.Rename(crashDir, dir); err != nil {
+		t.Fatalf("nutstest: move the files of the db back: %v", err)
+	}
+
+	reopened, err := nutsdb.Open(opt)
+	if err != nil {
+		t.Fatalf("nutstest: reopen the db: %v", err)
+	}
+	cleanup(t, func() {
+		_ = closeDB(reopened)
+	})
+	return reopened
+}
+
+// MustEventuallyExpire waits for the key of the bucket to expire, and fails the test if it is still there
+// after timeout. As the ttls are in seconds, the timeout should be at least a second more than the ttl.
+// When the db runs on a Clock, the clock is moved forward by the timeout instead of waiting.
+func MustEventuallyExpire(t testing.TB, db *nutsdb.DB, bucket string, key []byte, timeout time.Duration) {
+	t.Helper()
+
+	if clock, ok := db.Options().Clock.(*Clock); ok {
+		if !expired(t, db, bucket, key) {
+			clock.Advance(timeout)
+			if !expired(t, db, bucket, key) {
+				t.Fatalf("nutstest: %s of bucket %s has not expired after %s", key, bucket, timeout)
+			}
+		}
+		return
+	}
+
+	deadline := time.Now().Add(timeout)
+	for !expired(t, db, bucket, key) {
+		if time.Now().After(deadline) {
+			t.Fatalf("nutstest: %s of bucket %s has not expired after %s", key, bucket, timeout)
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// expired returns whether the key is no longer in the bucket, and fails the test if it cannot be read.
+func expired(t testing.TB, db *nutsdb.DB, bucket string, key []byte) bool {
+	t.Helper()
+
+	err := db.View(func(tx *nutsdb.Tx) error {
+		_, err := tx.Get(bucket, key)
+		return err
+	})
+	if err != nil && !isNotFound(err) {
+		t.Fatalf("nutstest: get %s of bucket %s: %v", key, bucket, err)
+	}
+	return err != nil
+}
+
+// isNotFound returns whether the error of a Get means the key is not in the bucket.
+func isNotFound(err error) bool {
+	return errors.Is(err, nutsdb.ErrNotFoundKey) || errors.Is(err, nutsdb.ErrKeyNotFound) ||
+		errors.Is(err, nutsdb.ErrNotFoundBucket) || errors.Is(err, nutsdb.ErrBucketNotFound)
+}
+
+// closeDB closes the db unless it is already closed.
+func closeDB(db *nutsdb.DB) error {
+	if db.IsClose() {
+		return nil
+	}
+	return db.Close()
+}
+
+// cleanup registers fn to run at the end of the test on the Go versions supporting it.
+func cleanup(t testing.TB, fn func()) {
+	if c, ok := t.(interface{ Cleanup(func()) }); ok {
+		c.Cleanup(fn)
+	}
+}
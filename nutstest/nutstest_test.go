@@ -0,0 +1,158 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutstest
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nutsdb/nutsdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenTemp(t *testing.T) {
+	var dir string
+	t.Run("open", func(t *testing.T) {
+		db := OpenTemp(t, nutsdb.WithSegmentSize(8*1024))
+		dir = db.Options().Dir
+		require.NoError(t, db.Update(func(tx *nutsdb.Tx) error {
+			return tx.Put("bucket", []byte("key"), []byte("value"), nutsdb.Persistent)
+		}))
+		_, err := os.Stat(dir)
+		require.NoError(t, err)
+	})
+
+	_, err := os.Stat(dir)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCrashAndReopen_CrossBucketTx(t *testing.T) {
+	db := OpenTemp(t, nutsdb.WithSegmentSize(8*1024))
+
+	get := func(db *nutsdb.DB, bucket, key string) (string, error) {
+		var value string
+		err := db.View(func(tx *nutsdb.Tx) error {
+			e, err := tx.Get(bucket, []byte(key))
+			if err != nil {
+				return err
+			}
+			value = string(e.Value)
+			return nil
+		})
+		return value, err
+	}
+
+	// a transfer updates both buckets or neither.
+	transfer := func(id, from, to string, fail bool) error {
+		return db.Update(func(tx *nutsdb.Tx) error {
+			if err := tx.Put("accounts", []byte(from), []byte("debited by "+id), nutsdb.Persistent); err != nil {
+				return err
+			}
+			if err := tx.Put("accounts", []byte(to), []byte("credited by "+id), nutsdb.Persistent); err != nil {
+				return err
+			}
+			if err := tx.RPush("ledger", []byte("transfers"), []byte(id)); err != nil {
+				return err
+			}
+			if fail {
+				return errors.New("transfer refused")
+			}
+			return nil
+		})
+	}
+	require.NoError(t, transfer("t1", "alice", "bob", false))
+	require.Error(t, transfer("t2", "bob", "carol", true))
+
+	db = CrashAndReopen(t, db)
+
+	value, err := get(db, "accounts", "alice")
+	require.NoError(t, err)
+	assert.Equal(t, "debited by t1", value)
+	value, err = get(db, "accounts", "bob")
+	require.NoError(t, err)
+	assert.Equal(t, "credited by t1", value)
+	_, err = get(db, "accounts", "carol")
+	assert.True(t, isNotFound(err))
+
+	require.NoError(t, db.View(func(tx *nutsdb.Tx) error {
+		items, err := tx.LRange("ledger", []byte("transfers"), 0, -1)
+		require.NoError(t, err)
+		assert.Equal(t, [][]byte{[]byte("t1")}, items)
+		return nil
+	}))
+}
+
+func TestMustEventuallyExpire(t *testing.T) {
+	db := OpenTemp(t, nutsdb.WithSegmentSize(8*1024))
+
+	require.NoError(t, db.Update(func(tx *nutsdb.Tx) error {
+		return tx.Put("bucket", []byte("key"), []byte("value"), 1)
+	}))
+	MustEventuallyExpire(t, db, "bucket", []byte("key"), 3*time.Second)
+	MustEventuallyExpire(t, db, "missing", []byte("key"), time.Second)
+}
+
+func TestMustEventuallyExpire_Clock(t *testing.T) {
+	clock := NewClock(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	db := OpenTemp(t, nutsdb.WithSegmentSize(8*1024), nutsdb.WithClock(clock))
+
+	require.NoError(t, db.Update(func(tx *nutsdb.Tx) error {
+		if err := tx.Put("bucket", []byte("key"), []byte("value"), 3600); err != nil {
+			return err
+		}
+		return tx.RPush("list", []byte("key"), []byte("value"))
+	}))
+	require.NoError(t, db.Update(func(tx *nutsdb.Tx) error {
+		return tx.ExpireList("list", []byte("key"), 3600)
+	}))
+
+	start := time.Now()
+	MustEventuallyExpire(t, db, "bucket", []byte("key"), time.Hour+time.Second)
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, time.Date(2023, 1, 1, 1, 0, 1, 0, time.UTC), clock.Now())
+
+	require.NoError(t, db.View(func(tx *nutsdb.Tx) error {
+		_, err := tx.LRange("list", []byte("key"), 0, -1)
+		assert.Error(t, err)
+		return nil
+	}))
+}
+
+func TestClock(t *testing.T) {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewClock(start)
+	assert.Equal(t, start, clock.Now())
+
+	fired := clock.After(time.Minute)
+	now := <-clock.After(0)
+	assert.Equal(t, start, now)
+
+	clock.Advance(59 * time.Second)
+	select {
+	case <-fired:
+		t.Fatal("fired before the minute")
+	default:
+	}
+
+	assert.Equal(t, start.Add(time.Minute), clock.Advance(time.Second))
+	assert.Equal(t, start.Add(time.Minute), <-fired)
+	assert.Equal(t, time.Minute, clock.Since(start))
+
+	clock.Set(start)
+	assert.Equal(t, start, clock.Now())
+}
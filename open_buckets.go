@@ -0,0 +1,52 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import "errors"
+
+var (
+	// ErrBucketNotOpen is returned when writing to a bucket left out of Options.OpenBuckets.
+	ErrBucketNotOpen = errors.New("the bucket is not open")
+
+	// ErrPartiallyOpen is returned by Merge and Checkpoint when the db is opened with Options.OpenBuckets,
+	// since the indexes of the other buckets they rely on are not loaded.
+	ErrPartiallyOpen = errors.New("the db is opened with only some of its buckets")
+)
+
+// setOpenBuckets restricts the buckets loaded by the db to the buckets, or loads them all when empty.
+func (db *DB) setOpenBuckets(buckets []string) error {
+	if len(buckets) == 0 {
+		return nil
+	}
+	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	db.openBuckets = make(map[string]struct{}, len(buckets))
+	for _, bucket := range buckets {
+		db.openBuckets[bucket] = struct{}{}
+	}
+	return nil
+}
+
+// isBucketOpen returns if the index of the bucket is loaded. The internal buckets are always loaded,
+// the other buckets when the db is opened with all of its buckets or with the bucket in Options.OpenBuckets.
+func (db *DB) isBucketOpen(bucket string) bool {
+	if db.openBuckets == nil || isInternalBucket(bucket) {
+		return true
+	}
+	_, ok := db.openBuckets[bucket]
+	return ok
+}
@@ -0,0 +1,83 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_OpenBuckets(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestopenbuckets", true)
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	key := []byte("key")
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		if err := tx.Put("bucket_kv", key, []byte("value"), Persistent); err != nil {
+			return err
+		}
+		if err := tx.SAdd("bucket_set", key, []byte("member")); err != nil {
+			return err
+		}
+		return tx.RPush("bucket_list", key, []byte("item"))
+	}))
+	// the checkpoints of the buckets left out are not loaded either.
+	require.NoError(t, db.Checkpoint())
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.SAdd("bucket_set", key, []byte("other"))
+	}))
+	require.NoError(t, db.Close())
+
+	db, err = Open(opt, WithOpenBuckets("bucket_kv"))
+	require.NoError(t, err)
+	assert.NotContains(t, db.SetIdx, "bucket_set")
+	assert.Nil(t, db.Index.getList("bucket_list"))
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		e, err := tx.Get("bucket_kv", key)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("value"), e.Value)
+
+		_, err = tx.SMembers("bucket_set", key)
+		assert.Error(t, err)
+		assert.Equal(t, ErrBucketNotOpen, tx.SAdd("bucket_set", key, []byte("member")))
+		assert.Equal(t, ErrBucketNotOpen, tx.Put("bucket_other", key, []byte("value"), Persistent))
+		return tx.Put("bucket_kv", key, []byte("new"), Persistent)
+	}))
+	assert.Equal(t, ErrPartiallyOpen, db.Merge())
+	assert.Equal(t, ErrPartiallyOpen, db.Checkpoint())
+	require.NoError(t, db.Close())
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	require.NoError(t, db.View(func(tx *Tx) error {
+		e, err := tx.Get("bucket_kv", key)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("new"), e.Value)
+
+		members, err := tx.SMembers("bucket_set", key)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"member", "other"}, sortedItems(members))
+
+		items, err := tx.LRange("bucket_list", key, 0, -1)
+		require.NoError(t, err)
+		assert.Equal(t, [][]byte{[]byte("item")}, items)
+		return nil
+	}))
+	require.NoError(t, db.Close())
+}
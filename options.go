@@ -14,6 +14,12 @@
 
 package nutsdb
 
+import (
+	"context"
+	"hash"
+	"time"
+)
+
 // EntryIdxMode represents entry index mode.
 type EntryIdxMode int
 
@@ -28,6 +34,19 @@ const (
 	HintBPTSparseIdxMode
 )
 
+// IndexLayout represents how the records of the BPTree indexes are laid out in memory.
+type IndexLayout int
+
+const (
+	// IndexLayoutDefault allocates the key, the hint and the metadata of every record separately.
+	IndexLayoutDefault IndexLayout = iota
+
+	// IndexLayoutArena copies the keys into large shared chunks and allocates every hint along
+	// with its metadata, which cuts the objects the GC has to mark for large indexes.
+	// A chunk is only freed once all its keys are, so it suits datasets with few overwrites and deletes.
+	IndexLayoutArena
+)
+
 // Options records params for creating DB object.
 type Options struct {
 	// Dir represents Open the database located in which dir.
@@ -52,7 +71,8 @@ type Options struct {
 	// if SyncEnable is true, slower but persistent.
 	SyncEnable bool
 
-	// MaxFdNumsInCache represents the max numbers of fd in cache.
+	// MaxFdNumsInCache represents the max numbers of fd in cache, the least recently used ones not in use
+	// are closed and opened again on demand.
 	MaxFdNumsInCache int
 
 	// CleanFdsCacheThreshold represents the maximum threshold for recycling fd, it should be between 0 and 1.
@@ -60,6 +80,368 @@ type Options struct {
 
 	// BufferSizeOfRecovery represents the buffer size of recoveryReader buffer Size
 	BufferSizeOfRecovery int
+
+	// CompactionFilter is called by Merge for every live key/value entry,
+	// it lets the application drop or rewrite entries at compaction time.
+	CompactionFilter CompactionFilter
+
+	// EntryRewriter is called by Merge for every entry carried over to the merged file,
+	// it lets the engine upgrade the encoding of the entries as the files get compacted.
+	EntryRewriter EntryRewriter
+
+	// BucketRetention maps a bucket name to the retention of its key/value entries,
+	// an entry older than the retention is treated as expired regardless of its ttl.
+	BucketRetention map[string]time.Duration
+
+	// RepairSource is queried for a healthy copy of the entries failing the crc check on read.
+	// The copy is written back to the data file by the next writable tx, or by Close.
+	RepairSource RepairSource
+
+	// OnReadRepair is called for every crc failure handed to the RepairSource, once the copy is written back
+	// or the repair failed, with the ctx of the tx whose read or commit repaired it, context.Background() for Close.
+	OnReadRepair func(ctx context.Context, incident ReadRepairIncident)
+
+	// IndexSnapshotInterval is the interval at which the indexes are checkpointed to disk,
+	// so that Open loads them instead of replaying the whole data files. Zero disables it.
+	IndexSnapshotInterval time.Duration
+
+	// HotKeysCapacity is the number of the most read and of the most written keys tracked per bucket
+	// and reported by HotKeys.
+	// Zero disables the tracking.
+	HotKeysCapacity int
+
+	// PanicAsError makes Update and View return a PanicError when the function passed panics,
+	// instead of panicking again once the transaction is rolled back.
+	PanicAsError bool
+
+	// IndexLayout represents how the records of the BPTree indexes are laid out in memory.
+	IndexLayout IndexLayout
+
+	// CompactBucketIDs makes the entries store a compact id of their bucket instead of its name,
+	// the mapping is kept in the bucket ids file of the db directory.
+	// It is not supported by the HintBPTSparseIdxMode, and older versions cannot read the entries.
+	CompactBucketIDs bool
+
+	// RecoveryMemoryLimit is the memory in bytes the records of the transactions not committed yet
+	// may take when opening the DB, beyond it their positions are spilled to a temporary file of the db directory.
+	// Zero means no limit.
+	RecoveryMemoryLimit int64
+
+	// ImmutableBuckets holds the key/value buckets whose keys are written once, they cannot be overwritten
+	// or deleted until they expire. Merge passes their entries neither to the CompactionFilter nor to the EntryRewriter.
+	ImmutableBuckets map[string]bool
+
+	// ContentHash returns the hash used by PutContent to compute the key of the values, SHA-256 if nil.
+	// It must not change for the buckets written already.
+	ContentHash func() hash.Hash
+
+	// ReadPath is the order of the stages Get tries to serve a value from, it must end up on the ReadStageDisk.
+	// Empty means the index then the disk. It is ignored by the HintBPTSparseIdxMode.
+	ReadPath []ReadStage
+
+	// ReadCacheCapacity is the number of values kept by the read cache of the ReadStageCache.
+	ReadCacheCapacity int
+
+	// SimulatedLatency delays the reads, writes and fsyncs of the data files, for testing only.
+	SimulatedLatency SimulatedLatency
+
+	// WorkloadRecorder records an anonymized trace of the key/value operations of the committed transactions.
+	WorkloadRecorder *WorkloadRecorder
+
+	// ExpirationReapInterval is the interval at which the expired keys are deleted and recorded
+	// as expiration events, see ReapExpired. Zero disables the reaper, unless OnExpired is set.
+	ExpirationReapInterval time.Duration
+
+	// BucketHints maps a bucket name to a hint about its workload. Merge compacts first the data files
+	// holding the most bytes of the BucketHintHighChurn buckets, instead of compacting in file id order.
+	BucketHints map[string]BucketHint
+
+	// OpenBuckets restricts the buckets whose index is loaded by Open, the others are skipped by the recovery
+	// and cannot be written through the db, which then refuses to Merge and to Checkpoint. Empty loads every bucket.
+	OpenBuckets []string
+
+	// TombstoneCompactionInterval is the interval at which the data file holding the most garbage left
+	// by the deletes is compacted, see CompactTombstones. Zero disables the background compaction.
+	TombstoneCompactionInterval time.Duration
+
+	// TombstoneCompactionRatio is the share of a data file that must be garbage for CompactTombstones
+	// to rewrite it. Zero means 0.5.
+	TombstoneCompactionRatio float64
+
+	// WatchBufferSize is the number of events a watcher of Watch may fall behind by before its channel
+	// is closed. Zero means 1024.
+	WatchBufferSize int
+
+	// SequenceBatchSize is the number of ids of NextSequence leased by a single write, the ids leased
+	// but not handed out are skipped after a restart. Zero means 128.
+	SequenceBatchSize uint64
+
+	// OnExpired is called by the reaper with the bucket, the key and the value of every key it deletes
+	// once expired, after the delete is committed, instead of only finding them gone on access. The reaper
+	// runs every second when ExpirationReapInterval is zero. The ctx is the one of ReapExpiredWithContext,
+	// the reaper passes one done once the db is closed. Nil means no callback.
+	OnExpired func(ctx context.Context, bucket string, key []byte, value []byte)
+
+	// ExpiredDeleteType decides when the expired keys are deleted. Zero means ExpiredDeleteLazy.
+	ExpiredDeleteType ExpiredDeleteType
+
+	// ExpiredSweepInterval is the interval at which the sweeper of ExpiredDeleteActive runs. Zero means 1 second.
+	ExpiredSweepInterval time.Duration
+
+	// ExpiredSweepBatchSize is the number of expired keys deleted by a single transaction of the sweeper
+	// and of the reaper. Zero means 256.
+	ExpiredSweepBatchSize int
+
+	// MaxQueuedWriters is the number of writable transactions allowed to wait for the write lock,
+	// Begin returns ErrTooManyWriters beyond it. Zero means no limit.
+	MaxQueuedWriters int
+
+	// OnValueMoved is called after a merge committed the rewrite of the live value of a key from the position
+	// from to the position to, which is the zero ValuePosition when the merge removed the value. The positions
+	// returned by Tx.ValuePosition before are no longer valid once the old data files are removed.
+	// The ctx is the one of the merge tx, see Tx.Context. Nil means no callback.
+	OnValueMoved func(ctx context.Context, bucket string, key []byte, from, to ValuePosition)
+
+	// WriteBatchSize is the size in bytes of the entries a WriteBatch writes in a single transaction.
+	// Zero means 4MB.
+	WriteBatchSize int64
+
+	// Tokenizers maps a bucket name to the tokenizer of the values of its key/value entries, which are then
+	// indexed by the terms it finds for Tx.Search. The index is kept in the same transactions as the writes.
+	// The values written before the tokenizer was added are not indexed.
+	Tokenizers map[string]Tokenizer
+
+	// AutoMergeEnable merges the data files in the background once enough of their bytes are garbage,
+	// one file per transaction so the writes only wait for that file, see AutoMergeRatio.
+	AutoMergeEnable bool
+
+	// AutoMergeInterval is the interval at which the auto merge looks for data files to merge. Zero means 1 minute.
+	AutoMergeInterval time.Duration
+
+	// AutoMergeRatio is the share of the bytes of the oldest data files that must be garbage, i.e. superseded,
+	// deleted or expired, for the auto merge to merge them. Zero means 0.5.
+	AutoMergeRatio float64
+
+	// AutoMergeRate is the number of bytes of data files the auto merge merges per second at most.
+	// Zero means no limit.
+	AutoMergeRate int64
+
+	// DedupBuckets holds the key/value buckets whose values are stored once however many keys hold them:
+	// a persistent value longer than a reference to it is kept under its hash, see Tx.PutContent, and the keys
+	// hold a reference counted by Merge, which removes the value once no key refers to it. The entries holding
+	// a reference are not passed to the CompactionFilter. The option is ignored in the HintBPTSparseIdxMode.
+	DedupBuckets map[string]bool
+
+	// OnMergeProgress is called by Merge after every data file it merged, from the goroutine calling Merge,
+	// with the ctx of MergeWithContext. Nil means no callback.
+	OnMergeProgress func(ctx context.Context, progress MergeProgress)
+
+	// ValueThreshold is the size above which the values of the key/value entries are kept in a value log
+	// apart from the data files, the entries holding a pointer to them: Merge then only rewrites the pointers,
+	// and RunValueLogGC reclaims the values no longer pointed to. The entries holding a pointer are not passed
+	// to the CompactionFilter. Zero disables the value log. The option is ignored in the HintBPTSparseIdxMode.
+	ValueThreshold int64
+
+	// SchemaDecoders holds the decoders of the values written by Tx.PutTyped by bucket and schema version,
+	// Tx.GetTyped refuses the values of a version without a decoder. Nil means no typed values.
+	SchemaDecoders map[string]map[uint8]SchemaDecoder
+
+	// ChangeFeedEnable records the changes sent to the watchers in the db, in the same transactions as the changes,
+	// so that the watchers of the WatchGlobalOrder get them numbered and can resume from a sequence number,
+	// until TrimChangeFeed removes them. The option is ignored in the HintBPTSparseIdxMode.
+	ChangeFeedEnable bool
+
+	// Compression is the codec compressing the values of the key/value buckets longer than CompressionThreshold
+	// when they get shorter. The codec is stored with every entry, so the values written with another codec
+	// stay readable, and Merge rewrites them with the current one. The internal buckets are never compressed, nor
+	// are the values of at least 1KB whose bytes sample as already compressed or encrypted.
+	Compression Compression
+
+	// CompressionThreshold is the size above which the values are compressed. Zero means 64 bytes.
+	CompressionThreshold int
+
+	// EncryptionKey is the AES key, of 16, 24 or 32 bytes, wrapping the data keys the payloads of the entries
+	// written to the data files are encrypted with by AES-GCM, the headers being authenticated along. Every bucket
+	// gets its own random data key, kept wrapped in the data_keys file of the Dir. The features which would keep
+	// the keys or the values in plain on disk are then refused or turned off: the checkpoints, the value log,
+	// the CompactBucketIDs and the HintBPTSparseIdxMode. Nil writes the entries in plain.
+	// The entries written before the key was set are encrypted by DB.RotateEncryptionKey,
+	// and DB.RotateMasterKey changes the key without rewriting the data files.
+	EncryptionKey []byte
+
+	// DecryptionKeys are older keys the data keys may still be wrapped with,
+	// when a DB.RotateEncryptionKey did not return. Nil means none.
+	DecryptionKeys [][]byte
+
+	// UnknownEntries decides what Open does with the entries of flags or data structures unknown to this version,
+	// e.g. written by a newer one. The zero UnknownEntryFail makes Open fail.
+	UnknownEntries UnknownEntryPolicy
+
+	// OnUnknownEntry is called by Open for every unknown entry left out of the indexes under the UnknownEntrySkip,
+	// with context.Background() as Open takes no ctx. Nil means no callback.
+	OnUnknownEntry func(ctx context.Context, entry SkippedEntry)
+
+	// QuarantineCorruptEntries makes Open leave the entries failing their crc check out of the indexes
+	// and keep their positions in DB.QuarantinedEntries, rather than fail. Their transactions are then
+	// applied without them, or not at all when the entry marking the commit is corrupt.
+	// The zero false makes Open fail.
+	QuarantineCorruptEntries bool
+
+	// Tiering makes the db the persistent local cache of a remote origin: GetThrough loads the keys missing
+	// from the origin, and the writes are pushed to it as set by the TieringMode. Nil means no origin.
+	Tiering Tiering
+
+	// TieringMode is when the writes are pushed to the Tiering. The zero TieringWriteThrough pushes them
+	// before Commit returns.
+	TieringMode TieringMode
+
+	// TieringFlushInterval is the interval the writes are pushed to the Tiering at in the TieringWriteBack.
+	// Zero means every second.
+	TieringFlushInterval time.Duration
+
+	// TieringTTL is the ttl of the values loaded from the Tiering by GetThrough. Zero means Persistent.
+	TieringTTL uint32
+
+	// CheckpointVerifySamples is the number of the entries of each checkpoint of a BPTree bucket Open re-reads
+	// from the data files, to check them against the checkpoint. The checkpoints not matching the data files,
+	// e.g. copied from another db, are removed and the data files are replayed instead. Zero means no check.
+	CheckpointVerifySamples int
+
+	// TypeConflicts decides what writing a key of a bucket does when the bucket already holds the key
+	// in another of the key/value, list and set data structures. Zero keeps the key in each of them.
+	TypeConflicts TypeConflictPolicy
+
+	// Clock is the time the entries are stamped with and their ttls and the bucket retentions checked against,
+	// e.g. a nutstest.Clock moved by the tests. Nil means the wall clock.
+	Clock Clock
+}
+
+// expirationReapInterval returns the interval of the reaper, zero when it is disabled.
+func (opt Options) expirationReapInterval() time.Duration {
+	if opt.ExpirationReapInterval == 0 && opt.OnExpired != nil {
+		return time.Second
+	}
+	return opt.ExpirationReapInterval
+}
+
+// writeBatchSize returns the size of the transactions of a WriteBatch.
+func (opt Options) writeBatchSize() int64 {
+	if opt.WriteBatchSize > 0 {
+		return opt.WriteBatchSize
+	}
+	return defaultWriteBatchSize
+}
+
+// expiredSweepInterval returns the interval of the sweeper of ExpiredDeleteActive.
+func (opt Options) expiredSweepInterval() time.Duration {
+	if opt.ExpiredSweepInterval > 0 {
+		return opt.ExpiredSweepInterval
+	}
+	return time.Second
+}
+
+// expiredSweepBatchSize returns the number of expired keys deleted by a single transaction.
+func (opt Options) expiredSweepBatchSize() int {
+	if opt.ExpiredSweepBatchSize > 0 {
+		return opt.ExpiredSweepBatchSize
+	}
+	return defaultExpiredSweepBatchSize
+}
+
+// autoMergeInterval returns the interval of the auto merge.
+func (opt Options) autoMergeInterval() time.Duration {
+	if opt.AutoMergeInterval > 0 {
+		return opt.AutoMergeInterval
+	}
+	return time.Minute
+}
+
+// autoMergeRatio returns the share of garbage of the data files merged by the auto merge.
+func (opt Options) autoMergeRatio() float64 {
+	if opt.AutoMergeRatio > 0 {
+		return opt.AutoMergeRatio
+	}
+	return defaultAutoMergeRatio
+}
+
+// compressionThreshold returns the size above which the values are compressed.
+func (opt Options) compressionThreshold() int {
+	if opt.CompressionThreshold > 0 {
+		return opt.CompressionThreshold
+	}
+	return defaultCompressionThreshold
+}
+
+// tieringFlushInterval returns the interval the writes are pushed to the Tiering at in the TieringWriteBack.
+func (opt Options) tieringFlushInterval() time.Duration {
+	if opt.TieringFlushInterval > 0 {
+		return opt.TieringFlushInterval
+	}
+	return defaultTieringFlushInterval
+}
+
+// ExpiredDeleteType decides when the expired keys are deleted.
+type ExpiredDeleteType int
+
+const (
+	// ExpiredDeleteLazy deletes the expired keys when they are accessed,
+	// and by the reaper when ExpirationReapInterval is set.
+	ExpiredDeleteLazy ExpiredDeleteType = iota
+
+	// ExpiredDeleteActive deletes the expired keys and lists in the background as well, see SweepExpired,
+	// so that the keys never accessed again do not hold memory and disk space.
+	ExpiredDeleteActive
+)
+
+// BucketHint describes the workload of a bucket.
+type BucketHint int
+
+const (
+	// BucketHintNone is the hint of the buckets without a hint.
+	BucketHintNone BucketHint = iota
+
+	// BucketHintHighChurn marks a bucket whose keys are overwritten or deleted often,
+	// so the files holding its entries are mostly garbage and are worth compacting first.
+	BucketHintHighChurn
+)
+
+// CompactionDecision represents what Merge does with an entry passed to the CompactionFilter.
+type CompactionDecision int
+
+const (
+	// CompactionKeep keeps the entry unchanged.
+	CompactionKeep CompactionDecision = iota
+
+	// CompactionRemove removes the entry, the key is deleted from the bucket.
+	CompactionRemove
+
+	// CompactionChangeValue rewrites the entry with the value returned by the filter.
+	CompactionChangeValue
+)
+
+// CompactionFilter decides the fate of a key/value entry during Merge.
+// The newValue is only used when the decision is CompactionChangeValue.
+// Only entries of the DataStructureBPTree buckets are passed to the filter.
+// The ctx is the one given to MergeWithContext.
+type CompactionFilter func(ctx context.Context, bucket string, key, value []byte, meta *MetaData) (decision CompactionDecision, newValue []byte)
+
+// EntryRewriter rewrites the entries carried over by Merge, so that the whole dataset
+// converges to a new encoding over time without downtime.
+// It gets the entry as Merge is about to write it, encoded with the current options, e.g. its value compressed
+// with the current Compression, and must not modify it. The entry it returns is written with its meta as-is,
+// e.g. with the codec it sets, only the sizes, the tx id and the status are set by Merge; returning the entry
+// given keeps it. The rewritten entry must keep the bucket and the key of the original one.
+// The ctx is the one given to MergeWithContext.
+type EntryRewriter interface {
+	Rewrite(ctx context.Context, e *Entry) (*Entry, error)
+}
+
+// EntryRewriterFunc is an adapter to allow the use of ordinary functions as EntryRewriter.
+type EntryRewriterFunc func(ctx context.Context, e *Entry) (*Entry, error)
+
+// Rewrite calls f(ctx, e).
+func (f EntryRewriterFunc) Rewrite(ctx context.Context, e *Entry) (*Entry, error) {
+	return f(ctx, e)
 }
 
 const (
@@ -141,3 +523,318 @@ func WithBufferSizeOfRecovery(size int) Option {
 		opt.BufferSizeOfRecovery = size
 	}
 }
+
+func WithCompactionFilter(filter CompactionFilter) Option {
+	return func(opt *Options) {
+		opt.CompactionFilter = filter
+	}
+}
+
+func WithEntryRewriter(rewriter EntryRewriter) Option {
+	return func(opt *Options) {
+		opt.EntryRewriter = rewriter
+	}
+}
+
+func WithBucketRetention(bucket string, retention time.Duration) Option {
+	return func(opt *Options) {
+		if opt.BucketRetention == nil {
+			opt.BucketRetention = make(map[string]time.Duration)
+		}
+		opt.BucketRetention[bucket] = retention
+	}
+}
+
+func WithRepairSource(source RepairSource) Option {
+	return func(opt *Options) {
+		opt.RepairSource = source
+	}
+}
+
+func WithOnReadRepair(onReadRepair func(ctx context.Context, incident ReadRepairIncident)) Option {
+	return func(opt *Options) {
+		opt.OnReadRepair = onReadRepair
+	}
+}
+
+func WithIndexSnapshotInterval(interval time.Duration) Option {
+	return func(opt *Options) {
+		opt.IndexSnapshotInterval = interval
+	}
+}
+
+func WithHotKeysCapacity(capacity int) Option {
+	return func(opt *Options) {
+		opt.HotKeysCapacity = capacity
+	}
+}
+
+func WithPanicAsError(enable bool) Option {
+	return func(opt *Options) {
+		opt.PanicAsError = enable
+	}
+}
+
+func WithIndexLayout(layout IndexLayout) Option {
+	return func(opt *Options) {
+		opt.IndexLayout = layout
+	}
+}
+
+func WithCompactBucketIDs(enable bool) Option {
+	return func(opt *Options) {
+		opt.CompactBucketIDs = enable
+	}
+}
+
+func WithRecoveryMemoryLimit(limit int64) Option {
+	return func(opt *Options) {
+		opt.RecoveryMemoryLimit = limit
+	}
+}
+
+func WithImmutableBucket(bucket string) Option {
+	return func(opt *Options) {
+		if opt.ImmutableBuckets == nil {
+			opt.ImmutableBuckets = make(map[string]bool)
+		}
+		opt.ImmutableBuckets[bucket] = true
+	}
+}
+
+func WithContentHash(newHash func() hash.Hash) Option {
+	return func(opt *Options) {
+		opt.ContentHash = newHash
+	}
+}
+
+func WithReadPath(stages ...ReadStage) Option {
+	return func(opt *Options) {
+		opt.ReadPath = stages
+	}
+}
+
+func WithReadCacheCapacity(capacity int) Option {
+	return func(opt *Options) {
+		opt.ReadCacheCapacity = capacity
+	}
+}
+
+func WithSimulatedLatency(latency SimulatedLatency) Option {
+	return func(opt *Options) {
+		opt.SimulatedLatency = latency
+	}
+}
+
+func WithWorkloadRecorder(recorder *WorkloadRecorder) Option {
+	return func(opt *Options) {
+		opt.WorkloadRecorder = recorder
+	}
+}
+
+func WithExpirationReapInterval(interval time.Duration) Option {
+	return func(opt *Options) {
+		opt.ExpirationReapInterval = interval
+	}
+}
+
+func WithBucketHint(bucket string, hint BucketHint) Option {
+	return func(opt *Options) {
+		if opt.BucketHints == nil {
+			opt.BucketHints = make(map[string]BucketHint)
+		}
+		opt.BucketHints[bucket] = hint
+	}
+}
+
+func WithOpenBuckets(buckets ...string) Option {
+	return func(opt *Options) {
+		opt.OpenBuckets = buckets
+	}
+}
+
+func WithTombstoneCompaction(interval time.Duration, ratio float64) Option {
+	return func(opt *Options) {
+		opt.TombstoneCompactionInterval = interval
+		opt.TombstoneCompactionRatio = ratio
+	}
+}
+
+func WithWatchBufferSize(size int) Option {
+	return func(opt *Options) {
+		opt.WatchBufferSize = size
+	}
+}
+
+func WithSequenceBatchSize(size uint64) Option {
+	return func(opt *Options) {
+		opt.SequenceBatchSize = size
+	}
+}
+
+func WithOnExpired(fn func(ctx context.Context, bucket string, key []byte, value []byte)) Option {
+	return func(opt *Options) {
+		opt.OnExpired = fn
+	}
+}
+
+func WithExpiredDeleteType(t ExpiredDeleteType) Option {
+	return func(opt *Options) {
+		opt.ExpiredDeleteType = t
+	}
+}
+
+func WithExpiredSweep(interval time.Duration, batchSize int) Option {
+	return func(opt *Options) {
+		opt.ExpiredSweepInterval = interval
+		opt.ExpiredSweepBatchSize = batchSize
+	}
+}
+
+func WithMaxQueuedWriters(max int) Option {
+	return func(opt *Options) {
+		opt.MaxQueuedWriters = max
+	}
+}
+
+func WithOnValueMoved(fn func(ctx context.Context, bucket string, key []byte, from, to ValuePosition)) Option {
+	return func(opt *Options) {
+		opt.OnValueMoved = fn
+	}
+}
+
+func WithWriteBatchSize(size int64) Option {
+	return func(opt *Options) {
+		opt.WriteBatchSize = size
+	}
+}
+
+func WithTokenizer(bucket string, tokenizer Tokenizer) Option {
+	return func(opt *Options) {
+		if opt.Tokenizers == nil {
+			opt.Tokenizers = make(map[string]Tokenizer)
+		}
+		opt.Tokenizers[bucket] = tokenizer
+	}
+}
+
+func WithAutoMerge(interval time.Duration, ratio float64) Option {
+	return func(opt *Options) {
+		opt.AutoMergeEnable = true
+		opt.AutoMergeInterval = interval
+		opt.AutoMergeRatio = ratio
+	}
+}
+
+func WithAutoMergeRate(rate int64) Option {
+	return func(opt *Options) {
+		opt.AutoMergeRate = rate
+	}
+}
+
+func WithDedupBucket(bucket string) Option {
+	return func(opt *Options) {
+		if opt.DedupBuckets == nil {
+			opt.DedupBuckets = make(map[string]bool)
+		}
+		opt.DedupBuckets[bucket] = true
+	}
+}
+
+func WithOnMergeProgress(fn func(ctx context.Context, progress MergeProgress)) Option {
+	return func(opt *Options) {
+		opt.OnMergeProgress = fn
+	}
+}
+
+func WithValueThreshold(threshold int64) Option {
+	return func(opt *Options) {
+		opt.ValueThreshold = threshold
+	}
+}
+
+func WithSchemaDecoder(bucket string, version uint8, decode SchemaDecoder) Option {
+	return func(opt *Options) {
+		if opt.SchemaDecoders == nil {
+			opt.SchemaDecoders = make(map[string]map[uint8]SchemaDecoder)
+		}
+		if opt.SchemaDecoders[bucket] == nil {
+			opt.SchemaDecoders[bucket] = make(map[uint8]SchemaDecoder)
+		}
+		opt.SchemaDecoders[bucket][version] = decode
+	}
+}
+
+func WithChangeFeed(enable bool) Option {
+	return func(opt *Options) {
+		opt.ChangeFeedEnable = enable
+	}
+}
+
+func WithCompression(codec Compression, threshold int) Option {
+	return func(opt *Options) {
+		opt.Compression = codec
+		opt.CompressionThreshold = threshold
+	}
+}
+
+func WithEncryptionKey(key []byte) Option {
+	return func(opt *Options) {
+		opt.EncryptionKey = key
+	}
+}
+
+func WithUnknownEntries(policy UnknownEntryPolicy) Option {
+	return func(opt *Options) {
+		opt.UnknownEntries = policy
+	}
+}
+
+func WithOnUnknownEntry(fn func(ctx context.Context, entry SkippedEntry)) Option {
+	return func(opt *Options) {
+		opt.OnUnknownEntry = fn
+	}
+}
+
+func WithQuarantineCorruptEntries(enable bool) Option {
+	return func(opt *Options) {
+		opt.QuarantineCorruptEntries = enable
+	}
+}
+
+func WithTiering(origin Tiering, mode TieringMode) Option {
+	return func(opt *Options) {
+		opt.Tiering = origin
+		opt.TieringMode = mode
+	}
+}
+
+func WithTieringFlushInterval(interval time.Duration) Option {
+	return func(opt *Options) {
+		opt.TieringFlushInterval = interval
+	}
+}
+
+func WithTieringTTL(ttl uint32) Option {
+	return func(opt *Options) {
+		opt.TieringTTL = ttl
+	}
+}
+
+func WithCheckpointVerifySamples(samples int) Option {
+	return func(opt *Options) {
+		opt.CheckpointVerifySamples = samples
+	}
+}
+
+func WithTypeConflicts(policy TypeConflictPolicy) Option {
+	return func(opt *Options) {
+		opt.TypeConflicts = policy
+	}
+}
+
+func WithClock(clock Clock) Option {
+	return func(opt *Options) {
+		opt.Clock = clock
+	}
+}
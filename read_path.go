@@ -0,0 +1,239 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrInvalidReadPath is returned by Open when the Options.ReadPath is not valid.
+var ErrInvalidReadPath = errors.New("invalid read path")
+
+// ReadStage is a stage of the read path, the stages are tried in order by Get until one serves the value.
+type ReadStage int
+
+const (
+	// ReadStageCache serves the values kept by the read cache, it needs a positive ReadCacheCapacity.
+	// The values read from disk are added to the cache.
+	ReadStageCache ReadStage = iota
+
+	// ReadStageIndex serves the values held by the RAM index, only the HintKeyValAndRAMIdxMode keeps them.
+	ReadStageIndex
+
+	// ReadStageDisk reads the value from its data file, it serves every read reaching it.
+	ReadStageDisk
+
+	readStageCount
+)
+
+// String returns the name of the stage.
+func (s ReadStage) String() string {
+	switch s {
+	case ReadStageCache:
+		return "cache"
+	case ReadStageIndex:
+		return "index"
+	case ReadStageDisk:
+		return "disk"
+	}
+	return fmt.Sprintf("ReadStage(%d)", int(s))
+}
+
+// defaultReadPath is the read path used when Options.ReadPath is empty.
+var defaultReadPath = []ReadStage{ReadStageIndex, ReadStageDisk}
+
+// ReadPathStats reports the number of values served by every stage of the read path since the db was opened.
+type ReadPathStats struct {
+	CacheHits uint64
+	IndexHits uint64
+	DiskHits  uint64
+}
+
+// readPathHits counts the values served by every stage, it is allocated on its own to keep the counters aligned.
+type readPathHits [readStageCount]uint64
+
+// checkReadPath returns the read path of the options, or ErrInvalidReadPath.
+func checkReadPath(opt Options) ([]ReadStage, error) {
+	if len(opt.ReadPath) == 0 {
+		return defaultReadPath, nil
+	}
+
+	var seen [readStageCount]bool
+	for _, stage := range opt.ReadPath {
+		if stage < 0 || stage >= readStageCount {
+			return nil, fmt.Errorf("%w: unknown stage %s", ErrInvalidReadPath, stage)
+		}
+		if seen[stage] {
+			return nil, fmt.Errorf("%w: duplicate stage %s", ErrInvalidReadPath, stage)
+		}
+		seen[stage] = true
+	}
+
+	if !seen[ReadStageDisk] {
+		return nil, fmt.Errorf("%w: missing stage %s", ErrInvalidReadPath, ReadStageDisk)
+	}
+	if seen[ReadStageCache] && opt.ReadCacheCapacity <= 0 {
+		return nil, fmt.Errorf("%w: stage %s needs a positive ReadCacheCapacity", ErrInvalidReadPath, ReadStageCache)
+	}
+
+	return opt.ReadPath, nil
+}
+
+// readValue resolves the value of the record found in the index of the bucket along the read path.
+func (db *DB) readValue(ctx context.Context, bucket string, key []byte, r *Record) (*Entry, error) {
+	for _, stage := range db.readPath {
+		switch stage {
+		case ReadStageCache:
+			if e := db.readCache.get(bucket, key, r.H); e != nil {
+				atomic.AddUint64(&db.readHits[ReadStageCache], 1)
+				return e, nil
+			}
+		case ReadStageIndex:
+			if r.E != nil {
+				atomic.AddUint64(&db.readHits[ReadStageIndex], 1)
+				return r.E, nil
+			}
+		case ReadStageDisk:
+			e, err := db.readValueOnDisk(ctx, bucket, key, r.H)
+			if err != nil {
+				return nil, err
+			}
+			atomic.AddUint64(&db.readHits[ReadStageDisk], 1)
+			db.readCache.add(bucket, key, r.H, e)
+			return e, nil
+		}
+	}
+
+	return nil, ErrNotFoundKey
+}
+
+// readValueOnDisk reads the entry the hint points to from its data file.
+func (db *DB) readValueOnDisk(ctx context.Context, bucket string, key []byte, h *Hint) (*Entry, error) {
+	df, err := db.fm.getDataFile(db.getDataPath(h.FileID), db.opt.SegmentSize)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rwManager RWManager) {
+		err := rwManager.Release()
+		if err != nil {
+			return
+		}
+	}(df.rwManager)
+
+	item, err := df.ReadRecord(int(h.DataPos), h.Meta.PayloadSize())
+	if err != nil {
+		item, err = db.readRepair(ctx, bucket, h, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read err. pos %d, key %s, err %s", h.DataPos, string(key), err)
+	}
+
+	return item, nil
+}
+
+// ReadPathStats returns the number of values served by every stage of the read path.
+func (db *DB) ReadPathStats() ReadPathStats {
+	return ReadPathStats{
+		CacheHits: atomic.LoadUint64(&db.readHits[ReadStageCache]),
+		IndexHits: atomic.LoadUint64(&db.readHits[ReadStageIndex]),
+		DiskHits:  atomic.LoadUint64(&db.readHits[ReadStageDisk]),
+	}
+}
+
+// readCacheKey identifies a key of a bucket in the read cache.
+type readCacheKey struct {
+	bucket string
+	key    string
+}
+
+// readCacheItem is a value of the read cache with the position of the entry it was read from.
+type readCacheItem struct {
+	k       readCacheKey
+	fileID  int64
+	dataPos uint64
+	e       *Entry
+}
+
+// readCache keeps the most recently read values, it is nil when the read path has no cache stage.
+// An item is only served while the index still points at the entry it was read from,
+// so the writes and the merges never need to invalidate it.
+type readCache struct {
+	mu       sync.Mutex
+	capacity int
+	items    map[readCacheKey]*list.Element
+	lru      *list.List
+}
+
+func newReadCache(readPath []ReadStage, capacity int) *readCache {
+	for _, stage := range readPath {
+		if stage == ReadStageCache {
+			return &readCache{capacity: capacity, items: make(map[readCacheKey]*list.Element), lru: list.New()}
+		}
+	}
+	return nil
+}
+
+func (c *readCache) get(bucket string, key []byte, h *Hint) *Entry {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[readCacheKey{bucket: bucket, key: string(key)}]
+	if !ok {
+		return nil
+	}
+
+	item := elem.Value.(*readCacheItem)
+	if item.fileID != h.FileID || item.dataPos != h.DataPos {
+		c.lru.Remove(elem)
+		delete(c.items, item.k)
+		return nil
+	}
+
+	c.lru.MoveToFront(elem)
+	return item.e
+}
+
+func (c *readCache) add(bucket string, key []byte, h *Hint, e *Entry) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	k := readCacheKey{bucket: bucket, key: string(key)}
+	if elem, ok := c.items[k]; ok {
+		item := elem.Value.(*readCacheItem)
+		item.fileID, item.dataPos, item.e = h.FileID, h.DataPos, e
+		c.lru.MoveToFront(elem)
+		return
+	}
+
+	c.items[k] = c.lru.PushFront(&readCacheItem{k: k, fileID: h.FileID, dataPos: h.DataPos, e: e})
+	if c.lru.Len() > c.capacity {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.items, oldest.Value.(*readCacheItem).k)
+	}
+}
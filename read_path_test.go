@@ -0,0 +1,122 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_ReadPath(t *testing.T) {
+	bucket, key := "bucket_read_path", []byte("key")
+
+	get := func(t *testing.T, db *DB) []byte {
+		var value []byte
+		require.NoError(t, db.View(func(tx *Tx) error {
+			e, err := tx.Get(bucket, key)
+			if err != nil {
+				return err
+			}
+			value = e.Value
+			return nil
+		}))
+		return value
+	}
+	put := func(t *testing.T, db *DB, value string) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.Put(bucket, key, []byte(value), Persistent)
+		}))
+	}
+
+	t.Run("default path serves the values from the index", func(t *testing.T) {
+		InitOpt("/tmp/nutsdbtestreadpath", true)
+		db, err := Open(opt)
+		require.NoError(t, err)
+		defer db.Close()
+
+		put(t, db, "value")
+		assert.Equal(t, []byte("value"), get(t, db))
+		assert.Equal(t, ReadPathStats{IndexHits: 1}, db.ReadPathStats())
+	})
+
+	t.Run("cache before disk", func(t *testing.T) {
+		InitOpt("/tmp/nutsdbtestreadpath", true)
+		db, err := Open(opt,
+			WithEntryIdxMode(HintKeyAndRAMIdxMode),
+			WithReadPath(ReadStageCache, ReadStageIndex, ReadStageDisk),
+			WithReadCacheCapacity(1),
+		)
+		require.NoError(t, err)
+		defer db.Close()
+
+		put(t, db, "value")
+		assert.Equal(t, []byte("value"), get(t, db))
+		assert.Equal(t, []byte("value"), get(t, db))
+		assert.Equal(t, ReadPathStats{CacheHits: 1, DiskHits: 1}, db.ReadPathStats())
+
+		// the cached value is stale once the key is written again.
+		put(t, db, "value2")
+		assert.Equal(t, []byte("value2"), get(t, db))
+		assert.Equal(t, ReadPathStats{CacheHits: 1, DiskHits: 2}, db.ReadPathStats())
+	})
+
+	t.Run("disk before index", func(t *testing.T) {
+		InitOpt("/tmp/nutsdbtestreadpath", true)
+		db, err := Open(opt, WithReadPath(ReadStageDisk, ReadStageIndex))
+		require.NoError(t, err)
+		defer db.Close()
+
+		put(t, db, "value")
+		assert.Equal(t, []byte("value"), get(t, db))
+		assert.Equal(t, ReadPathStats{DiskHits: 1}, db.ReadPathStats())
+	})
+
+	t.Run("invalid paths", func(t *testing.T) {
+		InitOpt("/tmp/nutsdbtestreadpath", true)
+		for _, ops := range [][]Option{
+			{WithReadPath(ReadStageIndex)},
+			{WithReadPath(ReadStageDisk, ReadStageDisk)},
+			{WithReadPath(ReadStage(7), ReadStageDisk)},
+			{WithReadPath(ReadStageCache, ReadStageDisk)},
+		} {
+			_, err := Open(opt, ops...)
+			assert.True(t, errors.Is(err, ErrInvalidReadPath), err)
+		}
+	})
+}
+
+func TestReadCache_Evict(t *testing.T) {
+	c := newReadCache([]ReadStage{ReadStageCache, ReadStageDisk}, 2)
+	h := &Hint{FileID: 1, DataPos: 10}
+
+	c.add("bucket", []byte("a"), h, &Entry{Value: []byte("a")})
+	c.add("bucket", []byte("b"), h, &Entry{Value: []byte("b")})
+	require.NotNil(t, c.get("bucket", []byte("a"), h))
+
+	// b is the least recently used.
+	c.add("bucket", []byte("c"), h, &Entry{Value: []byte("c")})
+	assert.Nil(t, c.get("bucket", []byte("b"), h))
+	assert.NotNil(t, c.get("bucket", []byte("a"), h))
+	assert.NotNil(t, c.get("bucket", []byte("c"), h))
+
+	// an item read from another position is dropped.
+	assert.Nil(t, c.get("bucket", []byte("a"), &Hint{FileID: 2, DataPos: 10}))
+	assert.Nil(t, c.get("bucket", []byte("a"), h))
+
+	assert.Nil(t, newReadCache(defaultReadPath, 2))
+}
@@ -0,0 +1,227 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/xujiajun/utils/strconv2"
+)
+
+// ErrRepairMismatch is returned when the RepairSource returns an entry that is not
+// the one written at the corrupted position.
+var ErrRepairMismatch = errors.New("repair source returned a mismatched entry")
+
+// RepairSource provides healthy copies of the entries failing the crc check,
+// e.g. from a backup directory or a replica.
+type RepairSource interface {
+	// Fetch returns the entry of the bucket written at the position given by the hint.
+	Fetch(bucket string, hint *Hint) (*Entry, error)
+}
+
+// ReadRepairIncident describes a crc failure met by a read and the outcome of its repair.
+type ReadRepairIncident struct {
+	Bucket string
+	Key    []byte
+	FileID int64
+	Offset uint64
+
+	// Err is nil when the entry is repaired, otherwise it is the reason the repair failed.
+	Err error
+}
+
+// dirRepairSource fetches the entries from a copy of the db directory, like the one made by Backup.
+type dirRepairSource struct {
+	dir string
+}
+
+// NewDirRepairSource returns a RepairSource reading the data files of the db copy at dir,
+// which must have been taken by Backup or by copying the db directory.
+func NewDirRepairSource(dir string) RepairSource {
+	return &dirRepairSource{dir: dir}
+}
+
+// Fetch reads the entry at the same file and offset of the db copy.
+func (s *dirRepairSource) Fetch(bucket string, hint *Hint) (*Entry, error) {
+	fd, err := os.Open(filepath.Join(s.dir, strconv2.Int64ToStr(hint.FileID)+DataSuffix))
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	payloadSize := hint.Meta.PayloadSize()
+	buf := make([]byte, DataEntryHeaderSize+payloadSize)
+	if _, err := fd.ReadAt(buf, int64(hint.DataPos)); err != nil {
+		return nil, err
+	}
+
+	e, err := decodeRecord(buf, payloadSize)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return nil, ErrNotFoundKey
+	}
+	return e, nil
+}
+
+// readRepairPos is the position of an entry repaired by a read.
+type readRepairPos struct {
+	fileID int64
+	off    uint64
+}
+
+// queuedRepair is an entry repaired by a read, waiting to be written back to its data file.
+type queuedRepair struct {
+	bucket string
+	hint   *Hint
+	e      *Entry
+}
+
+// readRepairQueue holds the entries repaired by the reads, which only hold the read lock of the db,
+// until the next writable tx or Close writes them back to the data files under the write lock.
+// The reads of a queued entry are served from the queue meanwhile.
+type readRepairQueue struct {
+	mu      sync.Mutex
+	entries map[readRepairPos]*queuedRepair
+}
+
+func newReadRepairQueue() *readRepairQueue {
+	return &readRepairQueue{entries: make(map[readRepairPos]*queuedRepair)}
+}
+
+// get returns a copy of the entry queued at pos, nil when there is none.
+func (q *readRepairQueue) get(pos readRepairPos) *Entry {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	r, ok := q.entries[pos]
+	if !ok {
+		return nil
+	}
+	e := *r.e
+	e.Key = append([]byte(nil), r.e.Key...)
+	e.Value = append([]byte(nil), r.e.Value...)
+	return &e
+}
+
+// add queues the repaired entry at pos.
+func (q *readRepairQueue) add(pos readRepairPos, r *queuedRepair) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.entries[pos] = r
+}
+
+// drain removes and returns the queued entries.
+func (q *readRepairQueue) drain() map[readRepairPos]*queuedRepair {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	entries := q.entries
+	q.entries = make(map[readRepairPos]*queuedRepair)
+	return entries
+}
+
+// readRepair tries to repair the entry at given hint after the read failed with readErr. The repaired entry
+// is returned in place of the corrupted one, and queued to be written back to the data file, see readRepairQueue.
+func (db *DB) readRepair(ctx context.Context, bucket string, hint *Hint, readErr error) (*Entry, error) {
+	if readErr != ErrCrc {
+		return nil, readErr
+	}
+
+	pos := readRepairPos{fileID: hint.FileID, off: hint.DataPos}
+	if e := db.readRepairs.get(pos); e != nil {
+		return e, nil
+	}
+	if db.opt.RepairSource == nil {
+		return nil, readErr
+	}
+
+	e, err := db.opt.RepairSource.Fetch(bucket, hint)
+	if err == nil {
+		err = db.bucketIDs.resolve(e)
+	}
+	if err == nil && (string(e.Bucket) != bucket || !bytes.Equal(e.Key, hint.Key) ||
+		e.Meta.TxID != hint.Meta.TxID || e.Meta.PayloadSize() != hint.Meta.PayloadSize()) {
+		err = ErrRepairMismatch
+	}
+	if err != nil {
+		db.reportReadRepair(ctx, bucket, hint, err)
+		return nil, readErr
+	}
+
+	db.readRepairs.add(pos, &queuedRepair{bucket: bucket, hint: hint, e: e})
+	return db.readRepairs.get(pos), nil
+}
+
+// writeReadRepairs writes the queued repaired entries back to their data files. It is called under the write lock,
+// ctx is the one of the tx or of the call writing them. The entries of the data files removed since, e.g. by Merge,
+// are no longer there to repair.
+func (db *DB) writeReadRepairs(ctx context.Context) {
+	for pos, r := range db.readRepairs.drain() {
+		err := db.writeReadRepair(pos, r)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		db.reportReadRepair(ctx, r.bucket, r.hint, err)
+	}
+}
+
+// writeReadRepair writes the repaired entry at its position in its data file.
+func (db *DB) writeReadRepair(pos readRepairPos, r *queuedRepair) error {
+	path := db.getDataPath(pos.fileID)
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+
+	if r.e.Meta.sealed() {
+		// the entry is encrypted again with the current data key of its bucket, durable before the entry.
+		if err := db.keys.prepare(r.bucket); err != nil {
+			return err
+		}
+		if err := db.keys.save(); err != nil {
+			return err
+		}
+	}
+
+	df, err := db.fm.getDataFile(path, db.opt.SegmentSize)
+	if err != nil {
+		return err
+	}
+	r.e.keys = db.keys
+	_, err = df.WriteAt(r.e.Encode(), int64(pos.off))
+	if releaseErr := df.rwManager.Release(); err == nil {
+		err = releaseErr
+	}
+	return err
+}
+
+// reportReadRepair passes the outcome of the repair of the entry at given hint to the OnReadRepair.
+func (db *DB) reportReadRepair(ctx context.Context, bucket string, hint *Hint, err error) {
+	if db.opt.OnReadRepair != nil {
+		db.opt.OnReadRepair(ctx, ReadRepairIncident{
+			Bucket: bucket,
+			Key:    hint.Key,
+			FileID: hint.FileID,
+			Offset: hint.DataPos,
+			Err:    err,
+		})
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_ReadRepair(t *testing.T) {
+	withRAMIdxDB(t, func(t *testing.T, db *DB) {
+		bucket := "bucket_read_repair"
+		key := []byte("key")
+		err := db.Update(func(tx *Tx) error {
+			return tx.Put(bucket, key, []byte("value"), Persistent)
+		})
+		require.NoError(t, err)
+
+		backupDir, _ := ioutil.TempDir("", "nutsdb_backup")
+		defer os.RemoveAll(backupDir)
+		require.NoError(t, db.Backup(backupDir))
+
+		// corrupt the first byte of the value.
+		r, err := db.BPTreeIdx[bucket].Find(key)
+		require.NoError(t, err)
+		fd, err := os.OpenFile(db.getDataPath(r.H.FileID), os.O_RDWR, 0644)
+		require.NoError(t, err)
+		valueOff := int64(r.H.DataPos) + DataEntryHeaderSize + int64(len(bucket)+len(key))
+		_, err = fd.WriteAt([]byte("X"), valueOff)
+		require.NoError(t, err)
+		require.NoError(t, fd.Close())
+
+		get := func() (*Entry, error) {
+			var e *Entry
+			err := db.View(func(tx *Tx) error {
+				var err error
+				e, err = tx.Get(bucket, key)
+				return err
+			})
+			return e, err
+		}
+
+		_, err = get()
+		assert.Error(t, err)
+
+		var incidents []ReadRepairIncident
+		db.opt.RepairSource = NewDirRepairSource(backupDir)
+		db.opt.OnReadRepair = func(ctx context.Context, incident ReadRepairIncident) {
+			incidents = append(incidents, incident)
+		}
+
+		e, err := get()
+		if assert.NoError(t, err) {
+			assert.Equal(t, []byte("value"), e.Value)
+		}
+		assert.Empty(t, incidents)
+
+		// the repaired entry is served from the queue until a writable tx writes it back.
+		db.opt.RepairSource = nil
+		e, err = get()
+		if assert.NoError(t, err) {
+			assert.Equal(t, []byte("value"), e.Value)
+		}
+		require.NoError(t, db.Update(func(tx *Tx) error { return nil }))
+		if assert.Len(t, incidents, 1) {
+			assert.Equal(t, key, incidents[0].Key)
+			assert.NoError(t, incidents[0].Err)
+		}
+
+		// the data file is repaired in place.
+		_, err = get()
+		assert.NoError(t, err)
+		assert.Len(t, incidents, 1)
+	})
+}
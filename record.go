@@ -30,8 +30,12 @@ func (r *Record) IsExpired() bool {
 
 // IsExpired checks the ttl if expired or not.
 func IsExpired(ttl uint32, timestamp uint64) bool {
-	now := time.Now().Unix()
-	if ttl > 0 && uint64(ttl)+timestamp > uint64(now) || ttl == Persistent {
+	return isExpiredAt(ttl, timestamp, uint64(time.Now().Unix()))
+}
+
+// isExpiredAt checks the ttl if expired or not at the unix time now.
+func isExpiredAt(ttl uint32, timestamp uint64, now uint64) bool {
+	if ttl > 0 && uint64(ttl)+timestamp > now || ttl == Persistent {
 		return false
 	}
 
@@ -3,13 +3,17 @@ package nutsdb
 import (
 	"bufio"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 )
 
 // fileRecovery use bufio.Reader to read entry
 type fileRecovery struct {
 	fd     *os.File
 	reader *bufio.Reader
+	size   int64 // the size of the file
+	off    int64 // the offset of the next entry to read
 }
 
 func newFileRecovery(path string, bufSize int) (fr *fileRecovery, err error) {
@@ -17,20 +21,27 @@ func newFileRecovery(path string, bufSize int) (fr *fileRecovery, err error) {
 	if err != nil {
 		return nil, err
 	}
+	info, err := fd.Stat()
+	if err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
 	bufSize = calBufferSize(bufSize)
 	return &fileRecovery{
 		fd:     fd,
 		reader: bufio.NewReaderSize(fd, bufSize),
+		size:   info.Size(),
 	}, nil
 }
 
-// readEntry will read an Entry from disk.
+// readEntry will read an Entry from disk, an entry failing its crc check is returned along with ErrCrc.
 func (fr *fileRecovery) readEntry() (e *Entry, err error) {
 	buf := make([]byte, DataEntryHeaderSize)
 	_, err = io.ReadFull(fr.reader, buf)
 	if err != nil {
 		return nil, err
 	}
+	fr.off += DataEntryHeaderSize
 
 	e = new(Entry)
 	err = e.ParseMeta(buf)
@@ -44,11 +55,16 @@ func (fr *fileRecovery) readEntry() (e *Entry, err error) {
 
 	meta := e.Meta
 	dataSize := meta.PayloadSize()
+	// a damaged header may give any size.
+	if fr.off+dataSize > fr.size {
+		return nil, io.ErrUnexpectedEOF
+	}
 	dataBuf := make([]byte, dataSize)
 	_, err = io.ReadFull(fr.reader, dataBuf)
 	if err != nil {
 		return nil, err
 	}
+	fr.off += dataSize
 	err = e.ParsePayload(dataBuf)
 	if err != nil {
 		return nil, err
@@ -56,12 +72,77 @@ func (fr *fileRecovery) readEntry() (e *Entry, err error) {
 
 	crc := e.GetCrc(buf)
 	if crc != e.Meta.Crc {
-		return nil, ErrCrc
+		return e, ErrCrc
 	}
 
 	return e, nil
 }
 
+// seek moves the reader to the given off(offset) of the file.
+func (fr *fileRecovery) seek(off int64) error {
+	if _, err := fr.fd.Seek(off, io.SeekStart); err != nil {
+		return err
+	}
+	fr.reader.Reset(fr.fd)
+	fr.off = off
+	return nil
+}
+
+// nextEntryOffset returns the offset of the first entry passing its crc check in the data file at path
+// after the damaged entry at given off, and whether there is one. The end of the data of the file is
+// returned when there is none.
+func nextEntryOffset(path string, off int64) (int64, bool, error) {
+	buf, err := ioutil.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return 0, false, err
+	}
+
+	// the data files may be zero filled past their data.
+	end := int64(len(buf))
+	for end > off && buf[end-1] == 0 {
+		end--
+	}
+
+	// the end given by the header is tried first as the payload alone may be damaged.
+	if off+DataEntryHeaderSize <= end {
+		e := new(Entry)
+		_ = e.ParseMeta(buf[off : off+DataEntryHeaderSize])
+		if next := off + e.Size(); next < end && isEntryAt(buf, next) {
+			return next, true, nil
+		}
+	}
+	for next := off + 1; next < end; next++ {
+		if isEntryAt(buf, next) {
+			return next, true, nil
+		}
+	}
+	if end < off {
+		end = off
+	}
+	return end, false, nil
+}
+
+// isEntryAt returns whether an entry passing its crc check starts at given off of buf.
+func isEntryAt(buf []byte, off int64) bool {
+	if off+DataEntryHeaderSize > int64(len(buf)) {
+		return false
+	}
+	header := buf[off : off+DataEntryHeaderSize]
+	e := new(Entry)
+	_ = e.ParseMeta(header)
+	if e.IsZero() {
+		return false
+	}
+	dataEnd := off + e.Size()
+	if dataEnd > int64(len(buf)) {
+		return false
+	}
+	if err := e.ParsePayload(buf[off+DataEntryHeaderSize : dataEnd]); err != nil {
+		return false
+	}
+	return e.GetCrc(header) == e.Meta.Crc
+}
+
 // calBufferSize calculates the buffer size of bufio.Reader
 // if the size < 4 * KB, use 4 * KB as the size of buffer in bufio.Reader
 // if the size > 4 * KB, use the nearly blockSize buffer as the size of buffer in bufio.Reader
@@ -0,0 +1,215 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+const (
+	// recoverySpillPattern is the pattern of the temporary files the pending records are spilled to.
+	recoverySpillPattern = "recovery_*.spill"
+
+	// recoverySpillRecordSize is the size of a spilled record: the txID, the fileID and the offset of the entry.
+	recoverySpillRecordSize = 24
+
+	// pendingRecordOverhead approximates the memory taken by a pending record besides its bucket, key and value.
+	pendingRecordOverhead = 128
+)
+
+// recoveryStream applies the records read when opening the DB to the indexes as soon as
+// their transaction is known to be committed, instead of collecting the records of all the data files first.
+// The records of the transactions not committed yet are buffered in memory up to the RecoveryMemoryLimit,
+// beyond it their positions are spilled to a temporary file and read back from the data files on commit.
+type recoveryStream struct {
+	db      *DB
+	limit   int64
+	size    int64
+	pending map[uint64][]*Record
+
+	spill       *os.File
+	spillWriter *bufio.Writer
+	spillSize   int64
+	spilled     map[uint64]struct{}
+}
+
+func newRecoveryStream(db *DB) *recoveryStream {
+	return &recoveryStream{
+		db:      db,
+		limit:   db.opt.RecoveryMemoryLimit,
+		pending: make(map[uint64][]*Record),
+		spilled: make(map[uint64]struct{}),
+	}
+}
+
+// add applies the record if its transaction is committed, or buffers it until it is.
+func (rs *recoveryStream) add(r *Record) error {
+	txID := r.H.Meta.TxID
+	if _, ok := rs.db.committedTxIds[txID]; ok {
+		return rs.db.applyRecord(r)
+	}
+
+	rs.pending[txID] = append(rs.pending[txID], r)
+	rs.size += rs.recordSize(r)
+
+	if r.H.Meta.Status == Committed {
+		return rs.commit(txID)
+	}
+
+	if rs.limit > 0 && rs.size > rs.limit {
+		return rs.spillPending()
+	}
+
+	return nil
+}
+
+// commit marks the transaction as committed and applies its records in the order they were written.
+func (rs *recoveryStream) commit(txID uint64) error {
+	rs.db.committedTxIds[txID] = struct{}{}
+
+	if _, ok := rs.spilled[txID]; ok {
+		if err := rs.applySpilled(txID); err != nil {
+			return err
+		}
+		delete(rs.spilled, txID)
+	}
+
+	for _, r := range rs.pending[txID] {
+		if err := rs.db.applyRecord(r); err != nil {
+			return err
+		}
+		rs.size -= rs.recordSize(r)
+	}
+	delete(rs.pending, txID)
+
+	return nil
+}
+
+// recordSize returns the memory accounted for the buffered record.
+func (rs *recoveryStream) recordSize(r *Record) int64 {
+	size := int64(DataEntryHeaderSize+len(r.Bucket)+len(r.H.Key)) + pendingRecordOverhead
+	if r.E != nil {
+		size += int64(len(r.E.Value))
+	}
+	return size
+}
+
+// spillPending writes the positions of all the buffered records to the spill file.
+func (rs *recoveryStream) spillPending() error {
+	if rs.spill == nil {
+		f, err := ioutil.TempFile(rs.db.opt.Dir, recoverySpillPattern)
+		if err != nil {
+			return err
+		}
+		rs.spill = f
+		rs.spillWriter = bufio.NewWriter(f)
+	}
+
+	buf := make([]byte, recoverySpillRecordSize)
+	for txID, records := range rs.pending {
+		for _, r := range records {
+			binary.LittleEndian.PutUint64(buf[0:8], txID)
+			binary.LittleEndian.PutUint64(buf[8:16], uint64(r.H.FileID))
+			binary.LittleEndian.PutUint64(buf[16:24], r.H.DataPos)
+			if _, err := rs.spillWriter.Write(buf); err != nil {
+				return err
+			}
+			rs.spillSize += recoverySpillRecordSize
+		}
+		rs.spilled[txID] = struct{}{}
+		delete(rs.pending, txID)
+	}
+	rs.size = 0
+
+	return nil
+}
+
+// applySpilled reads back the spilled records of the transaction and applies them.
+func (rs *recoveryStream) applySpilled(txID uint64) error {
+	if err := rs.spillWriter.Flush(); err != nil {
+		return err
+	}
+
+	var (
+		df     *DataFile
+		dfID   int64
+		reader = bufio.NewReader(io.NewSectionReader(rs.spill, 0, rs.spillSize))
+		buf    = make([]byte, recoverySpillRecordSize)
+	)
+	defer func() {
+		if df != nil {
+			_ = df.Release()
+		}
+	}()
+
+	for {
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if binary.LittleEndian.Uint64(buf[0:8]) != txID {
+			continue
+		}
+
+		fID := int64(binary.LittleEndian.Uint64(buf[8:16]))
+		off := int64(binary.LittleEndian.Uint64(buf[16:24]))
+		if df == nil || dfID != fID {
+			if df != nil {
+				_ = df.Release()
+				df = nil
+			}
+			f, err := rs.db.fm.getDataFile(rs.db.getDataPath(fID), rs.db.opt.SegmentSize)
+			if err != nil {
+				return err
+			}
+			df, dfID = f, fID
+		}
+
+		entry, err := df.ReadAt(int(off))
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			return io.ErrUnexpectedEOF
+		}
+
+		if err := rs.db.applyRecord(rs.db.newRecoveryRecord(entry, fID, off)); err != nil {
+			return err
+		}
+	}
+}
+
+// close drops the records of the transactions never committed and removes the spill file.
+func (rs *recoveryStream) close() error {
+	rs.pending = nil
+	rs.spilled = nil
+	if rs.spill == nil {
+		return nil
+	}
+
+	name := rs.spill.Name()
+	if err := rs.spill.Close(); err != nil {
+		return err
+	}
+	rs.spill = nil
+
+	return os.Remove(name)
+}
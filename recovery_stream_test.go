@@ -0,0 +1,124 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_RecoveryMemoryLimit(t *testing.T) {
+	for _, mode := range []EntryIdxMode{HintKeyValAndRAMIdxMode, HintKeyAndRAMIdxMode} {
+		InitOpt("/tmp/nutsdbtestrecoverymemorylimit", true)
+		opt.EntryIdxMode = mode
+		opt.SegmentSize = 8 * KB
+		db, err = Open(opt)
+		require.NoError(t, err)
+
+		bucket := "bucket"
+		// a single transaction spanning several data files.
+		err = db.Update(func(tx *Tx) error {
+			for i := 0; i < 200; i++ {
+				if err := tx.Put(bucket, []byte(fmt.Sprintf("key_%03d", i)), []byte(fmt.Sprintf("value_%03d", i)), Persistent); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		err = db.Update(func(tx *Tx) error {
+			if err := tx.Delete(bucket, []byte("key_000")); err != nil {
+				return err
+			}
+			if mode != HintKeyValAndRAMIdxMode {
+				return nil
+			}
+			if err := tx.SAdd(bucket, []byte("set"), []byte("a"), []byte("b")); err != nil {
+				return err
+			}
+			return tx.RPush(bucket, []byte("list"), []byte("a"), []byte("b"))
+		})
+		require.NoError(t, err)
+		require.NoError(t, db.Close())
+
+		db, err = Open(opt, WithRecoveryMemoryLimit(1*KB))
+		require.NoError(t, err)
+		err = db.View(func(tx *Tx) error {
+			entries, err := tx.GetAll(bucket)
+			assert.NoError(t, err)
+			assert.Len(t, entries, 199)
+
+			e, err := tx.Get(bucket, []byte("key_199"))
+			if assert.NoError(t, err) {
+				assert.Equal(t, []byte("value_199"), e.Value)
+			}
+			_, err = tx.Get(bucket, []byte("key_000"))
+			assert.Error(t, err)
+
+			if mode != HintKeyValAndRAMIdxMode {
+				return nil
+			}
+			ok, err := tx.SIsMember(bucket, []byte("set"), []byte("b"))
+			assert.NoError(t, err)
+			assert.True(t, ok)
+
+			size, err := tx.LSize(bucket, []byte("list"))
+			assert.NoError(t, err)
+			assert.Equal(t, 2, size)
+			return nil
+		})
+		require.NoError(t, err)
+
+		spills, err := filepath.Glob(filepath.Join(opt.Dir, recoverySpillPattern))
+		require.NoError(t, err)
+		assert.Empty(t, spills)
+		require.NoError(t, db.Close())
+	}
+}
+
+func TestRecoveryStream_Spill(t *testing.T) {
+	withDefaultDB(t, func(t *testing.T, db *DB) {
+		db.opt.RecoveryMemoryLimit = 1
+		rs := newRecoveryStream(db)
+
+		record := func(txID uint64, status uint16) *Record {
+			return &Record{
+				H:      &Hint{Key: []byte("key"), Meta: &MetaData{TxID: txID, Status: status, Ds: DataStructureBPTree}},
+				E:      &Entry{Key: []byte("key"), Value: []byte("value")},
+				Bucket: "bucket",
+			}
+		}
+
+		// the records of a transaction never committed are dropped.
+		require.NoError(t, rs.add(record(1, UnCommitted)))
+		require.NotNil(t, rs.spill)
+		assert.Empty(t, rs.pending)
+		assert.Contains(t, rs.spilled, uint64(1))
+
+		// a committed transaction is applied straight away.
+		require.NoError(t, rs.add(record(2, Committed)))
+		assert.Contains(t, db.committedTxIds, uint64(2))
+		assert.NotContains(t, db.committedTxIds, uint64(1))
+		assert.Equal(t, int64(0), rs.size)
+
+		name := rs.spill.Name()
+		require.NoError(t, rs.close())
+		assert.NoFileExists(t, name)
+	})
+}
@@ -0,0 +1,143 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// RepairOptions are the options of Repair.
+type RepairOptions struct {
+	// DryRun makes Repair report what it would repair without changing the files.
+	DryRun bool
+}
+
+// RepairReport is what Repair found and repaired in the files of a db.
+type RepairReport struct {
+	// FileID is the id of the last data file, the one written when the db crashed.
+	FileID int64
+
+	// TruncatedAt is the offset the last data file is truncated at, past its last committed transaction.
+	// It is -1 when the last data file is left as it is.
+	TruncatedAt int64
+
+	// DiscardedEntries is the number of the entries discarded from the tail of the last data file:
+	// the entries of the transaction written when the db crashed, e.g. by a merge, and the entry
+	// written partially.
+	DiscardedEntries int
+
+	// RemovedFiles are the paths of the temporary files left by the crash, removed.
+	RemovedFiles []string
+}
+
+// Repair repairs the files of the closed db at dir after a crash, so that it can be opened again:
+// the tail of the last data file past its last committed transaction is truncated, and the temporary
+// files of the checkpoints and of the recovery are removed. A merge interrupted leaves the entries
+// rewritten at that tail, and the data file it was merging whole. The entries failing their crc check
+// before the tail are left, see Options.QuarantineCorruptEntries.
+func Repair(dir string, opts RepairOptions) (RepairReport, error) {
+	report := RepairReport{TruncatedAt: -1}
+
+	fIDs, err := dataFileIDs(dir)
+	if err != nil {
+		return report, err
+	}
+	if len(fIDs) > 0 {
+		report.FileID = fIDs[len(fIDs)-1]
+		path := filepath.Join(dir, strconv.FormatInt(report.FileID, 10)+DataSuffix)
+		cut, discarded, err := tornTail(path)
+		if err != nil {
+			return report, err
+		}
+		if discarded > 0 {
+			report.TruncatedAt, report.DiscardedEntries = cut, discarded
+			if !opts.DryRun {
+				// the data file is preallocated again by Open.
+				if err := os.Truncate(path, cut); err != nil {
+					return report, err
+				}
+			}
+		}
+	}
+
+	for _, pattern := range []string{
+		filepath.Join(dir, checkpointDir, "*"+CheckpointSuffix+".tmp"),
+		filepath.Join(dir, checkpointTmpDir, "*"+CheckpointSuffix+".tmp"),
+		filepath.Join(dir, recoverySpillPattern),
+	} {
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			return report, err
+		}
+		for _, path := range paths {
+			if !opts.DryRun {
+				if err := os.Remove(path); err != nil {
+					return report, err
+				}
+			}
+			report.RemovedFiles = append(report.RemovedFiles, path)
+		}
+	}
+	return report, nil
+}
+
+// tornTail returns the offset past the last entry marking a transaction committed in the data file at path,
+// and the number of the entries after it, including the entry written partially.
+func tornTail(path string) (cut int64, discarded int, err error) {
+	fr, err := newFileRecovery(path, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() { _ = fr.release() }()
+
+	var off int64
+	for {
+		e, err := fr.readEntry()
+		if err == io.EOF || (err == nil && e == nil) {
+			return cut, discarded, nil
+		}
+		if err == ErrCrc {
+			discarded++
+			next, _, err := nextEntryOffset(path, off)
+			if err != nil {
+				return 0, 0, err
+			}
+			if err := fr.seek(next); err != nil {
+				return 0, 0, err
+			}
+			off = next
+			continue
+		}
+		if err != nil {
+			zero, zeroErr := isZeroTail(path, off)
+			if zeroErr != nil {
+				return 0, 0, zeroErr
+			}
+			if !zero {
+				discarded++
+			}
+			return cut, discarded, nil
+		}
+
+		discarded++
+		off += e.Size()
+		if e.Meta.Status == Committed {
+			cut, discarded = off, 0
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepair(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestrepair", true)
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	bucket := "bucket_repair"
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.Put(bucket, []byte("key"), []byte("value"), Persistent)
+	}))
+	fID, off := db.ActiveFile.fileID, db.ActiveFile.writeOff
+	require.NoError(t, db.Close())
+
+	// a transaction written up to the middle of its second entry.
+	uncommitted := func(key string) []byte {
+		e := &Entry{
+			Key:    []byte(key),
+			Value:  []byte("lost"),
+			Bucket: []byte(bucket),
+			Meta: &MetaData{
+				KeySize:    uint32(len(key)),
+				ValueSize:  4,
+				Timestamp:  uint64(time.Now().Unix()),
+				Flag:       DataSetFlag,
+				BucketSize: uint32(len(bucket)),
+				Status:     UnCommitted,
+				Ds:         DataStructureBPTree,
+				TxID:       1,
+			},
+		}
+		return e.Encode()
+	}
+	torn := append(uncommitted("lost_1"), uncommitted("lost_2")[:20]...)
+	path := filepath.Join(opt.Dir, "0"+DataSuffix)
+	fd, err := os.OpenFile(path, os.O_RDWR, 0644)
+	require.NoError(t, err)
+	_, err = fd.WriteAt(torn, off)
+	require.NoError(t, err)
+	require.NoError(t, fd.Close())
+	spill := filepath.Join(opt.Dir, "recovery_1.spill")
+	require.NoError(t, ioutil.WriteFile(spill, []byte("spill"), 0644))
+
+	_, err = Open(opt)
+	require.Error(t, err)
+
+	want := RepairReport{FileID: fID, TruncatedAt: off, DiscardedEntries: 2, RemovedFiles: []string{spill}}
+	report, err := Repair(opt.Dir, RepairOptions{DryRun: true})
+	require.NoError(t, err)
+	assert.Equal(t, want, report)
+	_, err = os.Stat(spill)
+	assert.NoError(t, err)
+
+	report, err = Repair(opt.Dir, RepairOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, want, report)
+	_, err = os.Stat(spill)
+	assert.True(t, os.IsNotExist(err))
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	require.NoError(t, db.View(func(tx *Tx) error {
+		e, err := tx.Get(bucket, []byte("key"))
+		if err != nil {
+			return err
+		}
+		assert.Equal(t, []byte("value"), e.Value)
+		return nil
+	}))
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.Put(bucket, []byte("key"), []byte("value2"), Persistent)
+	}))
+	require.NoError(t, db.Close())
+
+	// nothing left to repair.
+	report, err = Repair(opt.Dir, RepairOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, RepairReport{FileID: fID, TruncatedAt: -1}, report)
+}
@@ -0,0 +1,115 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	sequenceBucketKind = "sequence"
+
+	// defaultSequenceBatchSize is the SequenceBatchSize used when the option is zero.
+	defaultSequenceBatchSize = 128
+)
+
+// sequenceBucket maps the bucket names to the last id of their sequence leased.
+var sequenceBucket = internalBucket(sequenceBucketKind, "leased")
+
+// ErrSequenceCorrupted is returned by NextSequence when the lease of the sequence stored in the db cannot be decoded.
+var ErrSequenceCorrupted = errors.New("invalid sequence lease")
+
+// sequence is the state of the sequence of a bucket.
+type sequence struct {
+	last   uint64 // the last id handed out
+	leased uint64 // the last id leased by the db
+}
+
+// NextSequence returns the next id of the sequence of the bucket, starting at 1. The ids only grow,
+// and the ids of a committed tx are never handed out again, even after a crash.
+// The ids are leased by batches of Options.SequenceBatchSize, so that a single write every batch
+// makes them durable: the ids leased but not handed out before a restart are skipped, and the ids
+// of a tx rolled back are handed out again.
+func (tx *Tx) NextSequence(bucket string) (uint64, error) {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return 0, err
+	}
+	if !tx.writable {
+		return 0, ErrTxNotWritable
+	}
+	if tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return 0, ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	seq, err := tx.sequence(bucket)
+	if err != nil {
+		return 0, err
+	}
+
+	if seq.last == seq.leased {
+		batch := tx.db.opt.SequenceBatchSize
+		if batch == 0 {
+			batch = defaultSequenceBatchSize
+		}
+		leased := make([]byte, 8)
+		binary.BigEndian.PutUint64(leased, seq.leased+batch)
+		if err := tx.put(sequenceBucket, []byte(bucket), leased, Persistent, DataSetFlag, tx.db.timestamp(), DataStructureBPTree); err != nil {
+			return 0, err
+		}
+		seq.leased += batch
+	}
+
+	seq.last++
+	return seq.last, nil
+}
+
+// sequence returns the state of the sequence of the bucket as seen by the tx.
+func (tx *Tx) sequence(bucket string) (*sequence, error) {
+	if seq, ok := tx.sequences[bucket]; ok {
+		return seq, nil
+	}
+
+	seq := new(sequence)
+	if cur, ok := tx.db.sequences[bucket]; ok {
+		*seq = *cur
+	} else {
+		e, err := tx.Get(sequenceBucket, []byte(bucket))
+		switch {
+		case err == ErrNotFoundBucket || err == ErrNotFoundKey || err == ErrKeyNotFound:
+		case err != nil:
+			return nil, err
+		case len(e.Value) != 8:
+			return nil, ErrSequenceCorrupted
+		default:
+			// the ids leased before the restart may have been handed out.
+			seq.leased = binary.BigEndian.Uint64(e.Value)
+			seq.last = seq.leased
+		}
+	}
+
+	if tx.sequences == nil {
+		tx.sequences = make(map[string]*sequence)
+	}
+	tx.sequences[bucket] = seq
+	return seq, nil
+}
+
+// commitSequences makes the states of the sequences of the tx those of the db, once it is committed.
+func (tx *Tx) commitSequences() {
+	for bucket, seq := range tx.sequences {
+		tx.db.sequences[bucket] = seq
+	}
+}
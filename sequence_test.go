@@ -0,0 +1,82 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTx_NextSequence(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestsequence", true)
+	db, err = Open(opt, WithSequenceBatchSize(3))
+	require.NoError(t, err)
+
+	bucket := "bucket"
+	next := func(db *DB, bucket string) uint64 {
+		var id uint64
+		require.NoError(t, db.Update(func(tx *Tx) (err error) {
+			id, err = tx.NextSequence(bucket)
+			return err
+		}))
+		return id
+	}
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		for want := uint64(1); want <= 5; want++ {
+			id, err := tx.NextSequence(bucket)
+			require.NoError(t, err)
+			assert.Equal(t, want, id)
+		}
+		return nil
+	}))
+	assert.Equal(t, uint64(6), next(db, bucket))
+	assert.Equal(t, uint64(1), next(db, "other"))
+
+	// the ids of a tx rolled back are handed out again.
+	require.Error(t, db.Update(func(tx *Tx) error {
+		id, err := tx.NextSequence(bucket)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(7), id)
+		return errors.New("rolled back")
+	}))
+	assert.Equal(t, uint64(7), next(db, bucket))
+
+	require.NoError(t, db.View(func(tx *Tx) error {
+		_, err := tx.NextSequence(bucket)
+		assert.Equal(t, ErrTxNotWritable, err)
+		return nil
+	}))
+
+	// the ids leased by the crashed db are skipped.
+	crashed := replayAfterCrash(t, db)
+	assert.Equal(t, uint64(10), next(crashed, bucket))
+	require.NoError(t, crashed.Close())
+
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	// a single write leases the ids of a batch.
+	assert.Equal(t, uint64(10), next(db, bucket))
+	writeOff := db.ActiveFile.writeOff
+	for want := uint64(11); want < 138; want++ {
+		assert.Equal(t, want, next(db, bucket))
+	}
+	assert.Equal(t, writeOff, db.ActiveFile.writeOff)
+	require.NoError(t, db.Close())
+}
@@ -0,0 +1,157 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"os"
+)
+
+// Stats reports the write and space amplification of the db.
+// The written bytes are counted since the db was opened.
+type Stats struct {
+	// UserBytesWritten is the number of bytes appended to the data files by the user transactions.
+	UserBytesWritten int64
+
+	// MergeBytesWritten is the number of bytes appended to the data files by Merge.
+	MergeBytesWritten int64
+
+	// LiveBytes is the size of the entries still reachable from the indexes.
+	LiveBytes int64
+
+	// DiskBytes is the size of the data files on disk, including the space preallocated to SegmentSize.
+	DiskBytes int64
+
+	// WriteAmplification is the ratio of all the bytes written to the bytes written by the user transactions.
+	WriteAmplification float64
+
+	// SpaceAmplification is the ratio of DiskBytes to LiveBytes.
+	SpaceAmplification float64
+
+	// DedupBytesSaved is the size of the values of the DedupBuckets not stored again for the keys
+	// sharing them with another key.
+	DedupBytesSaved int64
+}
+
+// Stats returns the write and space amplification of the db.
+func (db *DB) Stats() (Stats, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed {
+		return Stats{}, ErrDBClosed
+	}
+	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return Stats{}, ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	diskBytes, err := db.dataFilesSize()
+	if err != nil {
+		return Stats{}, err
+	}
+
+	s := Stats{
+		UserBytesWritten:  db.userBytesWritten,
+		MergeBytesWritten: db.mergeBytesWritten,
+		LiveBytes:         db.liveBytes(),
+		DiskBytes:         diskBytes,
+		DedupBytesSaved:   db.dedupBytesSaved(),
+	}
+	if s.UserBytesWritten > 0 {
+		s.WriteAmplification = float64(s.UserBytesWritten+s.MergeBytesWritten) / float64(s.UserBytesWritten)
+	}
+	if s.LiveBytes > 0 {
+		s.SpaceAmplification = float64(s.DiskBytes) / float64(s.LiveBytes)
+	}
+	return s, nil
+}
+
+// dataFilesSize returns the total size of the data files.
+func (db *DB) dataFilesSize() (int64, error) {
+	_, dataFileIds := db.getMaxFileIDAndFileIDs()
+
+	var size int64
+	for _, id := range dataFileIds {
+		fi, err := os.Stat(db.getDataPath(int64(id)))
+		if err != nil {
+			return 0, err
+		}
+		size += fi.Size()
+	}
+	return size, nil
+}
+
+// liveBytes returns the size of the entries a merge would keep, that is
+// the latest entry of every live key and one entry per list item and per set or sorted set member.
+func (db *DB) liveBytes() int64 {
+	buckets := make(map[string]struct{})
+	for bucket := range db.BPTreeIdx {
+		buckets[bucket] = struct{}{}
+	}
+	for bucket := range db.SetIdx {
+		buckets[bucket] = struct{}{}
+	}
+	for bucket := range db.SortedSetIdx {
+		buckets[bucket] = struct{}{}
+	}
+	for bucket := range db.Index.list {
+		buckets[bucket] = struct{}{}
+	}
+
+	var size int64
+	for bucket := range buckets {
+		size += db.bucketLiveBytes(bucket)
+	}
+	return size
+}
+
+// bucketLiveBytes returns the part of liveBytes held by the bucket.
+func (db *DB) bucketLiveBytes(bucket string) int64 {
+	var size int64
+
+	if idx, ok := db.BPTreeIdx[bucket]; ok {
+		if records, err := idx.All(); err == nil {
+			for _, r := range records {
+				if r.H.Meta.Flag == DataDeleteFlag || db.isExpired(bucket, r.H.Meta) {
+					continue
+				}
+				size += DataEntryHeaderSize + r.H.Meta.PayloadSize()
+			}
+		}
+	}
+
+	if s, ok := db.SetIdx[bucket]; ok {
+		for key, members := range s.M {
+			for member := range members {
+				size += int64(DataEntryHeaderSize + len(bucket) + len(key) + len(member))
+			}
+		}
+	}
+
+	if ss, ok := db.SortedSetIdx[bucket]; ok {
+		for _, node := range ss.GetByRankRange(1, -1, false) {
+			size += int64(DataEntryHeaderSize + len(bucket) + len(node.Key()) + len(node.Value))
+		}
+	}
+
+	if l, ok := db.Index.list[bucket]; ok {
+		for key, items := range l.Items {
+			for _, item := range items {
+				size += int64(DataEntryHeaderSize + len(bucket) + len(key) + len(item))
+			}
+		}
+	}
+
+	return size
+}
@@ -0,0 +1,58 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_Stats(t *testing.T) {
+	InitOpt("/tmp/nutsdbteststats", true)
+	opt.SegmentSize = 1024
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	bucket := "bucket"
+	for i := 0; i < 20; i++ {
+		err = db.Update(func(tx *Tx) error {
+			return tx.Put(bucket, []byte(fmt.Sprintf("key_%d", i%5)), []byte("value"), Persistent)
+		})
+		require.NoError(t, err)
+	}
+
+	s, err := db.Stats()
+	require.NoError(t, err)
+	entrySize := int64(DataEntryHeaderSize + len(bucket) + len("key_0") + len("value"))
+	assert.Equal(t, 20*entrySize, s.UserBytesWritten)
+	assert.Equal(t, int64(0), s.MergeBytesWritten)
+	assert.Equal(t, 5*entrySize, s.LiveBytes)
+	assert.Equal(t, float64(1), s.WriteAmplification)
+	assert.True(t, s.DiskBytes >= s.UserBytesWritten)
+	assert.Equal(t, float64(s.DiskBytes)/float64(s.LiveBytes), s.SpaceAmplification)
+
+	require.NoError(t, db.Merge())
+	s, err = db.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, 5*entrySize, s.MergeBytesWritten)
+	assert.Equal(t, float64(25)/float64(20), s.WriteAmplification)
+	require.NoError(t, db.Close())
+
+	_, err = db.Stats()
+	assert.Equal(t, ErrDBClosed, err)
+}
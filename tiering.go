@@ -0,0 +1,250 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+const (
+	tieringBucketKind = "tiering"
+
+	// defaultTieringFlushInterval is the TieringFlushInterval used when the option is zero.
+	defaultTieringFlushInterval = time.Second
+)
+
+// tieringBucket holds the keys written since they were last pushed to the Options.Tiering, keyed by
+// encodeTieringKey, with the id of the transaction writing them last as value.
+var tieringBucket = internalBucket(tieringBucketKind, "dirty")
+
+var (
+	// ErrTieringDisabled is returned by GetThrough and FlushTiering when Options.Tiering is not set.
+	ErrTieringDisabled = errors.New("the tiering is disabled")
+
+	// ErrTieringKey is returned when a key queued for the Options.Tiering stored in the db cannot be decoded.
+	ErrTieringKey = errors.New("invalid tiering key")
+)
+
+// Tiering is the remote origin, e.g. S3 or Redis, the db caches under the Options.Tiering.
+type Tiering interface {
+	// Get returns the value of the key in the bucket in the origin, or ErrKeyNotFound when it has none.
+	Get(bucket string, key []byte) ([]byte, error)
+
+	// Put stores the value of the key in the bucket in the origin.
+	Put(bucket string, key, value []byte) error
+
+	// Delete removes the key in the bucket from the origin.
+	Delete(bucket string, key []byte) error
+}
+
+// TieringMode is when the writes are pushed to the Options.Tiering.
+type TieringMode int
+
+const (
+	// TieringWriteThrough pushes the writes of a tx to the origin before Commit returns.
+	// Commit returns a CommitError with Committed set when the origin fails, the writes are
+	// then pushed again by the next commit or FlushTiering.
+	TieringWriteThrough TieringMode = iota
+
+	// TieringWriteBack pushes the writes to the origin every Options.TieringFlushInterval.
+	TieringWriteBack
+)
+
+// tieringKey is a key written since it was last pushed to the origin.
+type tieringKey struct {
+	bucket string
+	key    []byte
+	txID   []byte // the id of the tx writing the key last, as stored in the tieringBucket
+}
+
+// recordTiering queues the keys written by the tx in the tieringBucket, so that they are pushed to the origin
+// even after a crash. The values loaded from the origin by GetThrough are not queued.
+func (tx *Tx) recordTiering() error {
+	if tx.db.opt.Tiering == nil || tx.isMerge || tx.fromOrigin {
+		return nil
+	}
+
+	var txID []byte
+	for _, e := range tx.pendingWrites {
+		bucket := string(e.Bucket)
+		if e.Meta.Ds != DataStructureBPTree || isInternalBucket(bucket) {
+			continue
+		}
+		if e.Meta.Flag != DataSetFlag && e.Meta.Flag != DataDeleteFlag {
+			continue
+		}
+
+		if txID == nil {
+			txID = make([]byte, 8)
+			binary.BigEndian.PutUint64(txID, tx.id)
+		}
+		if err := tx.put(tieringBucket, encodeTieringKey(bucket, e.Key), txID, Persistent, DataSetFlag, tx.db.timestamp(), DataStructureBPTree); err != nil {
+			return err
+		}
+		tx.tieringQueued = true
+	}
+	return nil
+}
+
+// GetThrough returns the value of the key in the bucket as GetOrLoad does with the Options.Tiering as loader:
+// a miss gets the value from the origin and puts it with the Options.TieringTTL. The keys deleted locally
+// and not pushed to the origin yet are missing rather than loaded again.
+func (db *DB) GetThrough(bucket string, key []byte) ([]byte, error) {
+	origin := db.opt.Tiering
+	if origin == nil {
+		return nil, ErrTieringDisabled
+	}
+
+	return db.getOrLoad(bucket, key, db.opt.TieringTTL, func() ([]byte, error) {
+		var queued bool
+		if err := db.View(func(tx *Tx) error {
+			_, err := tx.lookupStored(tieringBucket, encodeTieringKey(bucket, key))
+			queued = err == nil
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+		if queued {
+			return nil, ErrKeyNotFound
+		}
+		return origin.Get(bucket, key)
+	}, true)
+}
+
+// FlushTiering pushes the keys written since they were last pushed to the Options.Tiering: the live keys are put
+// with their values, the others deleted, including the keys expired meanwhile. The keys are pushed in the order of
+// their bucket and key, and the keys pushed before the origin fails are not pushed again.
+func (db *DB) FlushTiering() error {
+	origin := db.opt.Tiering
+	if origin == nil {
+		return ErrTieringDisabled
+	}
+
+	// a key pushed by two flushes at once could get an older value last.
+	db.tieringMu.Lock()
+	defer db.tieringMu.Unlock()
+
+	var (
+		keys   []tieringKey
+		values [][]byte
+	)
+	err := db.View(func(tx *Tx) error {
+		idx, ok := tx.db.BPTreeIdx[tieringBucket]
+		if !ok {
+			return nil
+		}
+		records, err := idx.All()
+		if err != nil {
+			return nil
+		}
+		entries, err := tx.getHintIdxDataItemsWrapper(tieringBucket, records, ScanNoLimit, nil, RangeScan)
+		if err != nil {
+			return err
+		}
+
+		for _, e := range entries {
+			k, err := decodeTieringKey(e.Key)
+			if err != nil {
+				return err
+			}
+			k.txID = e.Value
+
+			var value []byte
+			live, err := tx.lookup(k.bucket, k.key)
+			switch {
+			case err == ErrNotFoundBucket || err == ErrNotFoundKey || err == ErrKeyNotFound:
+			case err != nil:
+				return err
+			default:
+				value = append([]byte{}, live.Value...)
+			}
+			keys = append(keys, k)
+			values = append(values, value)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var pushed int
+	for i, k := range keys {
+		if values[i] != nil {
+			err = origin.Put(k.bucket, k.key, values[i])
+		} else {
+			err = origin.Delete(k.bucket, k.key)
+		}
+		if err != nil {
+			break
+		}
+		pushed++
+	}
+	if pushed == 0 {
+		return err
+	}
+
+	// the keys written again meanwhile are left for the next flush.
+	if updateErr := db.Update(func(tx *Tx) error {
+		for _, k := range keys[:pushed] {
+			tk := encodeTieringKey(k.bucket, k.key)
+			e, err := tx.lookupStored(tieringBucket, tk)
+			if err != nil || !bytes.Equal(e.Value, k.txID) {
+				continue
+			}
+			if err := tx.put(tieringBucket, tk, nil, Persistent, DataDeleteFlag, tx.db.timestamp(), DataStructureBPTree); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); updateErr != nil && err == nil {
+		err = updateErr
+	}
+	return err
+}
+
+// flushTiering calls FlushTiering every interval until stop is closed.
+func (db *DB) flushTiering(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			// a failed flush is retried by the next tick.
+			_ = db.FlushTiering()
+		}
+	}
+}
+
+func encodeTieringKey(bucket string, key []byte) []byte {
+	buf := make([]byte, 4+len(bucket)+len(key))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(bucket)))
+	copy(buf[4:], bucket)
+	copy(buf[4+len(bucket):], key)
+	return buf
+}
+
+func decodeTieringKey(buf []byte) (tieringKey, error) {
+	if len(buf) < 4 || int(binary.BigEndian.Uint32(buf[0:4])) > len(buf)-4 {
+		return tieringKey{}, ErrTieringKey
+	}
+	bucketSize := int(binary.BigEndian.Uint32(buf[0:4]))
+	return tieringKey{bucket: string(buf[4 : 4+bucketSize]), key: append([]byte(nil), buf[4+bucketSize:]...)}, nil
+}
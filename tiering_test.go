@@ -0,0 +1,158 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testOrigin is an in-memory Tiering failing while err is set.
+type testOrigin struct {
+	mu     sync.Mutex
+	values map[string]string
+	gets   int
+	err    error
+}
+
+func newTestOrigin() *testOrigin {
+	return &testOrigin{values: make(map[string]string)}
+}
+
+func (o *testOrigin) Get(bucket string, key []byte) ([]byte, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.gets++
+	if o.err != nil {
+		return nil, o.err
+	}
+	value, ok := o.values[bucket+"/"+string(key)]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return []byte(value), nil
+}
+
+func (o *testOrigin) Put(bucket string, key, value []byte) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.err != nil {
+		return o.err
+	}
+	o.values[bucket+"/"+string(key)] = string(value)
+	return nil
+}
+
+func (o *testOrigin) Delete(bucket string, key []byte) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.err != nil {
+		return o.err
+	}
+	delete(o.values, bucket+"/"+string(key))
+	return nil
+}
+
+func (o *testOrigin) value(key string) (string, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	value, ok := o.values[key]
+	return value, ok
+}
+
+func (o *testOrigin) setErr(err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.err = err
+}
+
+func TestDB_Tiering_WriteThrough(t *testing.T) {
+	bucket := "bucket_tiering"
+	origin := newTestOrigin()
+	origin.values[bucket+"/remote"] = "remote value"
+
+	InitOpt("/tmp/nutsdbtesttiering", true)
+	db, err = Open(opt, WithTiering(origin, TieringWriteThrough))
+	require.NoError(t, err)
+
+	put := func(key, value string) error {
+		return db.Update(func(tx *Tx) error {
+			return tx.Put(bucket, []byte(key), []byte(value), Persistent)
+		})
+	}
+	require.NoError(t, put("key", "value"))
+	value, ok := origin.value(bucket + "/key")
+	assert.True(t, ok)
+	assert.Equal(t, "value", value)
+
+	// the misses are loaded from the origin once, and not pushed back.
+	for i := 0; i < 2; i++ {
+		loaded, err := db.GetThrough(bucket, []byte("remote"))
+		require.NoError(t, err)
+		assert.Equal(t, []byte("remote value"), loaded)
+	}
+	assert.Equal(t, 1, origin.gets)
+	_, err = db.GetThrough(bucket, []byte("missing"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// the writes failing to reach the origin are committed, and pushed by the next flush.
+	errOrigin := errors.New("origin down")
+	origin.setErr(errOrigin)
+	err = db.Update(func(tx *Tx) error {
+		return tx.Delete(bucket, []byte("remote"))
+	})
+	var commitErr *CommitError
+	require.True(t, errors.As(err, &commitErr))
+	assert.True(t, commitErr.Committed)
+	assert.Equal(t, errOrigin, commitErr.Err)
+
+	// the key deleted locally is not loaded again while the delete is not pushed.
+	_, err = db.GetThrough(bucket, []byte("remote"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, 2, origin.gets)
+
+	// the queue survives a restart.
+	require.NoError(t, db.Close())
+	origin.setErr(nil)
+	db, err = Open(opt, WithTiering(origin, TieringWriteThrough))
+	require.NoError(t, err)
+	require.NoError(t, db.FlushTiering())
+	_, ok = origin.value(bucket + "/remote")
+	assert.False(t, ok)
+	require.NoError(t, db.Close())
+}
+
+func TestDB_Tiering_WriteBack(t *testing.T) {
+	bucket := "bucket_tiering"
+	origin := newTestOrigin()
+
+	InitOpt("/tmp/nutsdbtesttiering", true)
+	db, err = Open(opt, WithTiering(origin, TieringWriteBack), WithTieringFlushInterval(10*time.Millisecond))
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.Put(bucket, []byte("key"), []byte("value"), Persistent)
+	}))
+	assert.Eventually(t, func() bool {
+		value, ok := origin.value(bucket + "/key")
+		return ok && value == "value"
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,217 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"io"
+	"sort"
+	"time"
+)
+
+// defaultTombstoneCompactionRatio is the TombstoneCompactionRatio used when the option is zero.
+const defaultTombstoneCompactionRatio = 0.5
+
+// CompactTombstones rewrites the data file holding the most garbage left by the deletes and the overwrites of keys,
+// among the files of which at least Options.TombstoneCompactionRatio is garbage, and removes it. Unlike Merge it
+// rewrites a single file in a single tx, so the writes only wait for that file. It returns whether a file was rewritten.
+//
+// The entries overwritten or deleted since are dropped. The deletes and the expired entries still shadowing
+// the key are carried over, as an older file may hold the key, unless the file is the oldest one.
+// The files holding entries of the lists, sets or sorted sets, or of bucket deletes, are left to Merge.
+func (db *DB) CompactTombstones() (bool, error) {
+	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return false, ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	db.tombstoneMu.Lock()
+	defer db.tombstoneMu.Unlock()
+
+	for {
+		tx, err := db.Begin(true)
+		if err != nil {
+			return false, err
+		}
+		if db.openBuckets != nil {
+			_ = tx.Rollback()
+			return false, ErrPartiallyOpen
+		}
+
+		fID, ok, err := db.pickTombstoneFile()
+		if err != nil || !ok {
+			_ = tx.Rollback()
+			return false, err
+		}
+
+		compacted, err := tx.compactTombstoneFile(fID)
+		if err != nil {
+			_ = tx.Rollback()
+			return false, err
+		}
+		if !compacted {
+			_ = tx.Rollback()
+			continue
+		}
+
+		if err := tx.Commit(); err != nil {
+			return false, err
+		}
+
+		// the statistics of the data files are shared with the commits.
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		return true, db.removeDataFile(fID)
+	}
+}
+
+// tombstoneCompactionRatio returns the TombstoneCompactionRatio, or its default when the option is zero.
+func (db *DB) tombstoneCompactionRatio() float64 {
+	if db.opt.TombstoneCompactionRatio <= 0 {
+		return defaultTombstoneCompactionRatio
+	}
+	return db.opt.TombstoneCompactionRatio
+}
+
+// pickTombstoneFile returns the data file with the most garbage worth a look by CompactTombstones.
+func (db *DB) pickTombstoneFile() (int64, bool, error) {
+	ratio := db.tombstoneCompactionRatio()
+
+	_, dataFileIds := db.getMaxFileIDAndFileIDs()
+
+	var (
+		candidates []int64
+		ratios     = make(map[int64]float64)
+	)
+	for i, id := range dataFileIds {
+		fID := int64(id)
+		if fID == db.ActiveFile.fileID {
+			continue
+		}
+
+		stat, err := db.loadFileStat(fID)
+		if err != nil {
+			return 0, false, err
+		}
+		ratios[fID] = stat.garbageRatio(i == 0)
+		if stat.compactionSkipped || stat.otherSize > 0 || ratios[fID] < ratio {
+			continue
+		}
+		candidates = append(candidates, fID)
+	}
+	if len(candidates) == 0 {
+		return 0, false, nil
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return ratios[candidates[i]] > ratios[candidates[j]]
+	})
+	return candidates[0], true, nil
+}
+
+// compactTombstoneFile writes to the tx the entries of the data file at given fID that must be carried over.
+// When too little of the file would be reclaimed it writes nothing, marks the file to be skipped
+// by the next passes and returns false.
+func (tx *Tx) compactTombstoneFile(fID int64) (bool, error) {
+	db := tx.db
+	stat := db.fileStats[fID]
+
+	_, dataFileIds := db.getMaxFileIDAndFileIDs()
+	oldest := len(dataFileIds) > 0 && int64(dataFileIds[0]) == fID
+
+	fr, err := newFileRecovery(db.getDataPath(fID), db.opt.BufferSizeOfRecovery)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = fr.release()
+	}()
+
+	var (
+		off       int64
+		reclaimed int64
+		kept      []*Entry
+	)
+	for {
+		entry, err := fr.readEntry()
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF || err == ErrIndexOutOfBound {
+				break
+			}
+			return false, err
+		}
+		if entry == nil {
+			break
+		}
+		if err := decodeEntry(db.keys, db.bucketIDs, entry); err != nil {
+			return false, err
+		}
+		if !isKnownEntry(entry.Meta) {
+			return false, unknownEntryError(fID, off, entry.Meta)
+		}
+
+		if db.carryOverTombstoneEntry(entry, fID, off, oldest) {
+			kept = append(kept, entry)
+		} else {
+			reclaimed += entry.Size()
+		}
+		off += entry.Size()
+	}
+
+	if reclaimed == 0 || float64(reclaimed) < db.tombstoneCompactionRatio()*float64(stat.size) {
+		stat.compactionSkipped = true
+		return false, nil
+	}
+
+	tx.isMerge = true
+	for _, e := range kept {
+		e, err := decompressEntry(e)
+		if err != nil {
+			return false, err
+		}
+		if err := tx.putValue(string(e.Bucket), e.Key, e.Value, e.Meta.TTL, e.Meta.Flag, e.Meta.Timestamp, e.Meta.Ds, e.Meta.valueKind()); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// carryOverTombstoneEntry returns whether the entry read at the given position must be rewritten
+// by CompactTombstones. Only the latest entry of a key is, and a delete or an expired entry only
+// while an older file may still hold the key.
+func (db *DB) carryOverTombstoneEntry(entry *Entry, fID, off int64, oldest bool) bool {
+	r, _ := db.getRecordFromKey(entry.Bucket, entry.Key)
+	if r == nil || r.H.FileID != fID || r.H.DataPos != uint64(off) {
+		return false
+	}
+	if entry.Meta.Flag == DataSetFlag && !db.isExpired(string(entry.Bucket), entry.Meta) {
+		return true
+	}
+	return !oldest
+}
+
+// compactTombstones runs CompactTombstones every interval until the db is closed.
+func (db *DB) compactTombstones(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			// a failed pass is retried by the next tick.
+			_, _ = db.CompactTombstones()
+		}
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_CompactTombstones(t *testing.T) {
+	InitOpt("/tmp/nutsdbtesttombstones", true)
+	opt.SegmentSize = 1024
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	bucket := "bucket"
+	key := func(prefix string, i int) []byte {
+		return []byte(fmt.Sprintf("%s_%d", prefix, i))
+	}
+	for i := 0; i < 20; i++ {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.Put(bucket, key("key", i), []byte("value"), Persistent)
+		}))
+	}
+	for i := 0; i < 20; i++ {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.Delete(bucket, key("key", i))
+		}))
+	}
+	for i := 0; i < 20; i++ {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.Put(bucket, key("live", i), []byte("value"), Persistent)
+		}))
+	}
+
+	_, before := db.getMaxFileIDAndFileIDs()
+	sizeBefore, err := db.Size()
+	require.NoError(t, err)
+
+	compactions := 0
+	for {
+		compacted, err := db.CompactTombstones()
+		require.NoError(t, err)
+		if !compacted {
+			break
+		}
+		compactions++
+	}
+	assert.True(t, compactions >= 2)
+
+	_, after := db.getMaxFileIDAndFileIDs()
+	assert.True(t, len(after) < len(before))
+	sizeAfter, err := db.Size()
+	require.NoError(t, err)
+	assert.Equal(t, sizeBefore.Live, sizeAfter.Live)
+	assert.True(t, sizeAfter.Dead < sizeBefore.Dead)
+
+	check := func() {
+		require.NoError(t, db.View(func(tx *Tx) error {
+			for i := 0; i < 20; i++ {
+				_, err := tx.Get(bucket, key("key", i))
+				assert.Error(t, err)
+				e, err := tx.Get(bucket, key("live", i))
+				require.NoError(t, err)
+				assert.Equal(t, []byte("value"), e.Value)
+			}
+			return nil
+		}))
+	}
+	check()
+
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	check()
+	require.NoError(t, db.Close())
+}
+
+func TestDB_CompactTombstones_KeepsShadowingDeletes(t *testing.T) {
+	InitOpt("/tmp/nutsdbtesttombstonesshadow", true)
+	opt.SegmentSize = 1024
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	bucket := "bucket"
+	put := func(key string) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.Put(bucket, []byte(key), []byte("value"), Persistent)
+		}))
+	}
+
+	// the oldest file holds live keys and the key deleted later.
+	put("deleted")
+	for i := 0; i < 20; i++ {
+		put(fmt.Sprintf("live_%d", i))
+	}
+
+	// the next file is mostly overwrites along with the delete.
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.Delete(bucket, []byte("deleted"))
+	}))
+	for i := 0; i < 40; i++ {
+		put("churn")
+	}
+
+	for {
+		compacted, err := db.CompactTombstones()
+		require.NoError(t, err)
+		if !compacted {
+			break
+		}
+	}
+	_, fIDs := db.getMaxFileIDAndFileIDs()
+	assert.Equal(t, 0, fIDs[0])
+
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	require.NoError(t, db.View(func(tx *Tx) error {
+		_, err := tx.Get(bucket, []byte("deleted"))
+		assert.Error(t, err)
+		_, err = tx.Get(bucket, []byte("churn"))
+		assert.NoError(t, err)
+		_, err = tx.Get(bucket, []byte("live_0"))
+		assert.NoError(t, err)
+		return nil
+	}))
+	require.NoError(t, db.Close())
+}
+
+func TestDB_CompactTombstones_Background(t *testing.T) {
+	InitOpt("/tmp/nutsdbtesttombstonesbackground", true)
+	opt.SegmentSize = 1024
+	db, err = Open(opt, WithTombstoneCompaction(10*time.Millisecond, 0))
+	require.NoError(t, err)
+
+	bucket := "bucket"
+	for i := 0; i < 40; i++ {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.Put(bucket, []byte("churn"), []byte("value"), Persistent)
+		}))
+	}
+	_, before := db.getMaxFileIDAndFileIDs()
+
+	assert.Eventually(t, func() bool {
+		db.mu.RLock()
+		defer db.mu.RUnlock()
+		_, after := db.getMaxFileIDAndFileIDs()
+		return len(after) < len(before)
+	}, time.Second, 10*time.Millisecond)
+	require.NoError(t, db.Close())
+}
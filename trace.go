@@ -0,0 +1,234 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"io"
+	"sync"
+	"time"
+)
+
+// ErrInvalidTrace is returned when reading a workload trace not written by a WorkloadRecorder.
+var ErrInvalidTrace = errors.New("invalid workload trace")
+
+const (
+	// traceMagic starts every workload trace.
+	traceMagic = "NUTSTRC1"
+
+	// traceRecordSize is the size of an encoded TraceRecord:
+	// op(1) | writable(1) | bucketHash(8) | keyHash(8) | valueSize(4) | offset(8) | duration(8)
+	traceRecordSize = 38
+)
+
+// TraceOp is the type of an operation of a workload trace.
+type TraceOp uint8
+
+const (
+	// TraceOpGet is a Get of a key/value bucket.
+	TraceOpGet TraceOp = iota + 1
+
+	// TraceOpPut is a write of a key/value bucket.
+	TraceOpPut
+
+	// TraceOpDelete is a delete of a key/value bucket.
+	TraceOpDelete
+
+	// TraceOpCommit ends the operations of a transaction.
+	TraceOpCommit
+)
+
+// TraceRecord is an operation of a workload trace. The buckets and the keys are only known by their hash,
+// salted per recording so that the same key gets the same hash within a trace only.
+type TraceRecord struct {
+	Op         TraceOp
+	BucketHash uint64
+	KeyHash    uint64
+	ValueSize  uint32
+
+	// Offset is the time from the start of the recording to the operation, or to the Begin of the transaction
+	// for the commit records.
+	Offset time.Duration
+
+	// Writable and Duration are only set on the commit records, the Duration runs from Begin to the end of Commit.
+	Writable bool
+	Duration time.Duration
+}
+
+// WorkloadRecorder writes the trace of the key/value operations of the transactions committed by a DB.
+// The operations of a transaction are written once it is committed, the rolled back ones are not recorded.
+// The operations of the other data structures and of Merge are not recorded.
+type WorkloadRecorder struct {
+	mu     sync.Mutex
+	w      *bufio.Writer
+	salt   [8]byte
+	start  time.Time
+	header bool
+	err    error
+}
+
+// NewWorkloadRecorder returns a recorder writing the trace to w, it is passed to Open with WithWorkloadRecorder.
+// Flush must be called once the DB is closed.
+func NewWorkloadRecorder(w io.Writer) *WorkloadRecorder {
+	r := &WorkloadRecorder{w: bufio.NewWriter(w), start: time.Now()}
+	_, _ = rand.Read(r.salt[:])
+	return r
+}
+
+// Flush writes the buffered records and returns the first error met while writing the trace.
+func (r *WorkloadRecorder) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err == nil {
+		r.err = r.writeHeader()
+	}
+	if r.err == nil {
+		r.err = r.w.Flush()
+	}
+	return r.err
+}
+
+func (r *WorkloadRecorder) hash(b []byte) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write(r.salt[:])
+	_, _ = h.Write(b)
+	return h.Sum64()
+}
+
+func (r *WorkloadRecorder) writeHeader() error {
+	if r.header {
+		return nil
+	}
+	r.header = true
+	_, err := r.w.WriteString(traceMagic)
+	return err
+}
+
+// record writes the records of a transaction.
+func (r *WorkloadRecorder) record(records []TraceRecord) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err == nil {
+		r.err = r.writeHeader()
+	}
+
+	buf := make([]byte, traceRecordSize)
+	for _, rec := range records {
+		if r.err != nil {
+			return
+		}
+		encodeTraceRecord(buf, rec)
+		_, r.err = r.w.Write(buf)
+	}
+}
+
+func encodeTraceRecord(buf []byte, rec TraceRecord) {
+	buf[0] = byte(rec.Op)
+	buf[1] = 0
+	if rec.Writable {
+		buf[1] = 1
+	}
+	binary.LittleEndian.PutUint64(buf[2:10], rec.BucketHash)
+	binary.LittleEndian.PutUint64(buf[10:18], rec.KeyHash)
+	binary.LittleEndian.PutUint32(buf[18:22], rec.ValueSize)
+	binary.LittleEndian.PutUint64(buf[22:30], uint64(rec.Offset))
+	binary.LittleEndian.PutUint64(buf[30:38], uint64(rec.Duration))
+}
+
+func decodeTraceRecord(buf []byte) TraceRecord {
+	return TraceRecord{
+		Op:         TraceOp(buf[0]),
+		Writable:   buf[1] == 1,
+		BucketHash: binary.LittleEndian.Uint64(buf[2:10]),
+		KeyHash:    binary.LittleEndian.Uint64(buf[10:18]),
+		ValueSize:  binary.LittleEndian.Uint32(buf[18:22]),
+		Offset:     time.Duration(binary.LittleEndian.Uint64(buf[22:30])),
+		Duration:   time.Duration(binary.LittleEndian.Uint64(buf[30:38])),
+	}
+}
+
+// TraceReader reads the records of a workload trace.
+type TraceReader struct {
+	r      *bufio.Reader
+	header bool
+	buf    []byte
+}
+
+// NewTraceReader returns a reader of the trace written by a WorkloadRecorder.
+func NewTraceReader(r io.Reader) *TraceReader {
+	return &TraceReader{r: bufio.NewReader(r), buf: make([]byte, traceRecordSize)}
+}
+
+// Next returns the next record of the trace, or io.EOF at the end of the trace.
+func (tr *TraceReader) Next() (TraceRecord, error) {
+	if !tr.header {
+		magic := make([]byte, len(traceMagic))
+		if _, err := io.ReadFull(tr.r, magic); err != nil || !bytes.Equal(magic, []byte(traceMagic)) {
+			return TraceRecord{}, ErrInvalidTrace
+		}
+		tr.header = true
+	}
+
+	if _, err := io.ReadFull(tr.r, tr.buf); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = ErrInvalidTrace
+		}
+		return TraceRecord{}, err
+	}
+
+	rec := decodeTraceRecord(tr.buf)
+	if rec.Op < TraceOpGet || rec.Op > TraceOpCommit {
+		return TraceRecord{}, ErrInvalidTrace
+	}
+	return rec, nil
+}
+
+// traceOp adds the operation to the trace of the tx when the db records its workload.
+func (tx *Tx) traceOp(op TraceOp, bucket string, key []byte, valueSize int) {
+	r := tx.db.opt.WorkloadRecorder
+	if r == nil || tx.isMerge || isInternalBucket(bucket) {
+		return
+	}
+
+	tx.trace = append(tx.trace, TraceRecord{
+		Op:         op,
+		BucketHash: r.hash([]byte(bucket)),
+		KeyHash:    r.hash(key),
+		ValueSize:  uint32(valueSize),
+		Offset:     time.Since(r.start),
+	})
+}
+
+// recordTrace writes the trace of the committed tx.
+func (tx *Tx) recordTrace(r *WorkloadRecorder) {
+	if r == nil || len(tx.trace) == 0 {
+		return
+	}
+
+	r.record(append(tx.trace, TraceRecord{
+		Op:       TraceOpCommit,
+		Offset:   tx.traceStart.Sub(r.start),
+		Writable: tx.writable,
+		Duration: time.Since(tx.traceStart),
+	}))
+	tx.trace = nil
+}
@@ -0,0 +1,96 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkloadRecorder(t *testing.T) {
+	bucket := "bucket_trace"
+	var buf bytes.Buffer
+	recorder := NewWorkloadRecorder(&buf)
+
+	InitOpt("/tmp/nutsdbtesttrace", true)
+	db, err := Open(opt, WithWorkloadRecorder(recorder))
+	require.NoError(t, err)
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		if err := tx.Put(bucket, []byte("key1"), []byte("value"), Persistent); err != nil {
+			return err
+		}
+		if err := tx.Put(bucket, []byte("key2"), []byte("value2"), Persistent); err != nil {
+			return err
+		}
+		return tx.SAdd(bucket, []byte("set"), []byte("member"))
+	}))
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.Delete(bucket, []byte("key1"))
+	}))
+	// the rolled back transactions are not recorded.
+	assert.Error(t, db.Update(func(tx *Tx) error {
+		if err := tx.Put(bucket, []byte("key3"), []byte("value"), Persistent); err != nil {
+			return err
+		}
+		return errors.New("rollback")
+	}))
+	require.NoError(t, db.View(func(tx *Tx) error {
+		_, err := tx.Get(bucket, []byte("key2"))
+		return err
+	}))
+
+	require.NoError(t, db.Close())
+	require.NoError(t, recorder.Flush())
+	assert.NotContains(t, buf.String(), "key")
+
+	var records []TraceRecord
+	tr := NewTraceReader(&buf)
+	for {
+		rec, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		records = append(records, rec)
+	}
+
+	ops := make([]TraceOp, len(records))
+	for i, rec := range records {
+		ops[i] = rec.Op
+	}
+	assert.Equal(t, []TraceOp{
+		TraceOpPut, TraceOpPut, TraceOpCommit,
+		TraceOpDelete, TraceOpCommit,
+		TraceOpGet, TraceOpCommit,
+	}, ops)
+
+	assert.Equal(t, uint32(6), records[1].ValueSize)
+	assert.Equal(t, records[0].KeyHash, records[3].KeyHash)
+	assert.Equal(t, records[1].KeyHash, records[5].KeyHash)
+	assert.NotEqual(t, records[0].KeyHash, records[1].KeyHash)
+	assert.Equal(t, records[0].BucketHash, records[5].BucketHash)
+	assert.True(t, records[2].Writable)
+	assert.False(t, records[6].Writable)
+	assert.True(t, records[4].Offset >= records[2].Offset)
+
+	_, err = NewTraceReader(bytes.NewReader([]byte("not a trace"))).Next()
+	assert.Equal(t, ErrInvalidTrace, err)
+}
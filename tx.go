@@ -16,7 +16,9 @@ package nutsdb
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"os"
 	"strings"
 	"sync"
@@ -24,6 +26,7 @@ import (
 	"time"
 
 	"github.com/bwmarrin/snowflake"
+	"github.com/nutsdb/nutsdb/ds/list"
 	"github.com/nutsdb/nutsdb/ds/set"
 	"github.com/nutsdb/nutsdb/ds/zset"
 	"github.com/xujiajun/utils/strconv2"
@@ -78,6 +81,31 @@ var (
 	ErrNotFoundBucket = errors.New("bucket not found")
 )
 
+// CommitError is returned by Commit when writing the entries of the transaction fails.
+type CommitError struct {
+	// Written is the number of entries of the transaction written to the data files before the failure.
+	Written int
+
+	// Total is the number of entries of the transaction.
+	Total int
+
+	// Committed reports if the transaction is durable. When false, the written entries are
+	// never replayed and the in-memory indexes are unchanged. When true, the transaction is
+	// replayed on the next open but the indexes of the HintBPTSparseIdxMode may be stale until then.
+	Committed bool
+
+	Err error
+}
+
+func (e *CommitError) Error() string {
+	return fmt.Sprintf("commit failed after writing %d of %d entries (committed: %t): %s", e.Written, e.Total, e.Committed, e.Err)
+}
+
+// Unwrap returns the error which made the commit fail.
+func (e *CommitError) Unwrap() error {
+	return e.Err
+}
+
 var cachePool = sync.Pool{
 	New: func() interface{} {
 		return new(bytes.Buffer)
@@ -91,7 +119,24 @@ type Tx struct {
 	writable               bool
 	status                 atomic.Value
 	pendingWrites          []*Entry
+	pendingKeys            map[string]*pendingBucket             // the write buffer of the KV reads, see bufferWrite
+	pendingLists           map[string]map[string]*pendingListKey // the pending writes of the tx on the lists, see bufferListWrite
+	pendingSets            map[string]*pendingSetBucket          // the sets written by the tx, see bufferSetWrite
+	pendingSortedSets      map[string]*pendingSortedSetBucket    // the pending writes of the tx on the sorted sets, see bufferSortedSetWrite
 	ReservedStoreTxIDIdxes map[int64]*BPTree
+	ctx                    context.Context
+	isMerge                bool
+	valueLogWritten        bool           // whether values were appended to the value log, see separateValue
+	changeSeqs             map[int]uint64 // the sequence numbers of the pending writes in the change feed, see recordChanges
+	fromOrigin             bool           // the writes are values loaded from the Options.Tiering, see recordTiering
+	tieringQueued          bool           // keys are queued for the Options.Tiering, see recordTiering
+	async                  bool
+	trace                  []TraceRecord
+	traceStart             time.Time
+	tempBuckets            []string
+	putBatches             map[int]int // the start to the end of the pending writes of every PutBatch
+	sequences              map[string]*sequence
+	valueMoves             []valueMove // the values moved by a merge tx, see Options.OnValueMoved
 }
 
 // Begin opens a new transaction.
@@ -101,12 +146,29 @@ type Tx struct {
 // the current read/write transaction is completed.
 // All transactions must be closed by calling Commit() or Rollback() when done.
 func (db *DB) Begin(writable bool) (tx *Tx, err error) {
+	return db.BeginWithContext(context.Background(), writable)
+}
+
+// BeginWithContext opens a new transaction carrying the ctx, see Begin.
+// The ctx is returned by tx.Context and passed down to the hooks run on behalf of the transaction.
+func (db *DB) BeginWithContext(ctx context.Context, writable bool) (tx *Tx, err error) {
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	tx, err = newTx(db, writable)
 	if err != nil {
 		return nil, err
 	}
+	tx.ctx = context.WithValue(ctx, txCtxKey{}, tx)
 
-	tx.lock()
+	var parentTxID uint64
+	if parent, ok := ctx.Value(txCtxKey{}).(*Tx); ok {
+		parentTxID = parent.id
+	}
+	if err = tx.lock(parentTxID); err != nil {
+		return nil, err
+	}
 	tx.setStatusRunning()
 	if db.closed {
 		tx.unlock()
@@ -126,6 +188,10 @@ func newTx(db *DB, writable bool) (tx *Tx, err error) {
 		writable:               writable,
 		pendingWrites:          []*Entry{},
 		ReservedStoreTxIDIdxes: make(map[int64]*BPTree),
+		ctx:                    context.Background(),
+	}
+	if db.opt.WorkloadRecorder != nil {
+		tx.traceStart = time.Now()
 	}
 
 	txID, err = tx.getTxID()
@@ -138,9 +204,15 @@ func newTx(db *DB, writable bool) (tx *Tx, err error) {
 	return
 }
 
+// Context returns the context the transaction was opened with. It carries the transaction,
+// so opening another transaction with it fails with ErrDeadlock when it would block forever.
+func (tx *Tx) Context() context.Context {
+	return tx.ctx
+}
+
 // getTxID returns the tx id.
 func (tx *Tx) getTxID() (id uint64, err error) {
-	node, err := snowflake.NewNode(tx.db.opt.NodeNum)
+	node, err := tx.db.getTxIDNode()
 	if err != nil {
 		return 0, err
 	}
@@ -150,21 +222,38 @@ func (tx *Tx) getTxID() (id uint64, err error) {
 	return
 }
 
+// getTxIDNode returns the snowflake node generating the tx ids. It is shared by all the
+// transactions, distinct nodes would hand out the same ids within the same millisecond.
+func (db *DB) getTxIDNode() (*snowflake.Node, error) {
+	db.txIDNodeOnce.Do(func() {
+		db.txIDNode, db.txIDNodeErr = snowflake.NewNode(db.opt.NodeNum)
+	})
+	return db.txIDNode, db.txIDNodeErr
+}
+
 // Commit commits the transaction, following these steps:
 //
 // 1. check the length of pendingWrites.If there are no writes, return immediately.
 //
 // 2. check if the ActiveFile has not enough space to store entry. if not, call rotateActiveFile function.
 //
-// 3. write pendingWrites to disk, if a non-nil error,return the error.
+// 3. write pendingWrites to disk, if a non-nil error,return a CommitError.
 //
 // 4. build Hint index.
 //
 // 5. Unlock the database and clear the db field.
+//
+// 6. wait for the entries to be fsynced if SyncEnable is set.
+//
+// The in-memory indexes are only updated once all the entries are written, so a commit
+// failing partway leaves them untouched and returns a CommitError describing what reached the data files.
+// The fsync happens once the lock is released, so the other transactions may see the changes before they
+// are durable; a crash before it loses them as a whole since the last entry marks the transaction committed.
 func (tx *Tx) Commit() error {
 	var (
 		e              *Entry
 		bucketMetaTemp BucketMeta
+		written        int
 	)
 
 	if tx.isClosed() {
@@ -176,20 +265,56 @@ func (tx *Tx) Commit() error {
 		return ErrDBClosed
 	}
 
+	// the events and the keys for the tiering are recorded while the tx can still be rolled back.
+	if err := tx.recordChanges(); err != nil {
+		return err
+	}
+	if err := tx.recordTiering(); err != nil {
+		return err
+	}
+
 	tx.setStatusCommitting()
 	defer tx.setStatusClosed()
 
+	// the entries repaired by the reads are written back under the write lock.
+	if tx.writable {
+		tx.db.writeReadRepairs(tx.ctx)
+	}
+
 	writesLen := len(tx.pendingWrites)
 
 	if writesLen == 0 {
+		tx.commitSequences()
 		tx.unlock()
+		tx.recordTrace(tx.db.opt.WorkloadRecorder)
 		tx.db = nil
 		return nil
 	}
 
+	for _, entry := range tx.pendingWrites {
+		if entry.Size() > tx.db.opt.SegmentSize {
+			return ErrDataSizeExceed
+		}
+	}
+
+	// the ids of the new buckets and their data keys must be durable before the entries using them.
+	if err := tx.db.bucketIDs.save(); err != nil {
+		return &CommitError{Total: writesLen, Err: err}
+	}
+	if err := tx.db.keys.save(); err != nil {
+		return &CommitError{Total: writesLen, Err: err}
+	}
+
+	// the values in the value log must be as durable as the entries pointing to them.
+	if tx.valueLogWritten && (tx.async || tx.db.opt.SyncEnable) {
+		if err := tx.db.vlog.sync(); err != nil {
+			return &CommitError{Total: writesLen, Err: err}
+		}
+	}
+
 	lastIndex := writesLen - 1
 	countFlag := CountFlagEnabled
-	if tx.db.isMerging {
+	if tx.isMerge {
 		countFlag = CountFlagDisabled
 	}
 
@@ -199,90 +324,161 @@ func (tx *Tx) Commit() error {
 		cachePool.Put(buff)
 	}()
 
+	fileIDs := make([]int64, writesLen)
+	offsets := make([]int64, writesLen)
+
 	for i := 0; i < writesLen; i++ {
 		entry := tx.pendingWrites[i]
-		entrySize := entry.Size()
-		if entrySize > tx.db.opt.SegmentSize {
-			return ErrDataSizeExceed
-		}
-
 		bucket := string(entry.Bucket)
 
-		if tx.db.ActiveFile.ActualSize+int64(buff.Len())+entrySize > tx.db.opt.SegmentSize {
+		if tx.db.ActiveFile.ActualSize+int64(buff.Len())+entry.Size() > tx.db.opt.SegmentSize {
 			if _, err := tx.writeData(buff.Bytes()); err != nil {
-				return err
+				return &CommitError{Written: written, Total: writesLen, Err: err}
 			}
+			tx.buildSparseIdx(written, i, fileIDs, offsets, countFlag)
+			written = i
 			buff.Reset()
 
 			if err := tx.rotateActiveFile(); err != nil {
-				return err
+				return &CommitError{Written: written, Total: writesLen, Err: err}
 			}
 		}
 
-		offset := tx.db.ActiveFile.writeOff + int64(buff.Len())
+		fileIDs[i] = tx.db.ActiveFile.fileID
+		offsets[i] = tx.db.ActiveFile.writeOff + int64(buff.Len())
 
-		if entry.Meta.Ds == DataStructureBPTree {
-			tx.db.BPTreeKeyEntryPosMap[string(getNewKey(string(entry.Bucket), entry.Key))] = offset
-		}
+		tx.db.addFileStat(fileIDs[i], entry)
+		tx.db.touchBucket(entry)
 
 		if i == lastIndex {
 			entry.Meta.Status = Committed
 		}
 
 		if _, err := buff.Write(entry.Encode()); err != nil {
-			return err
-		}
-
-		if i == lastIndex {
-			if _, err := tx.writeData(buff.Bytes()); err != nil {
-				return err
-			}
+			return &CommitError{Written: written, Total: writesLen, Err: err}
 		}
 
 		if tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode {
 			bucketMetaTemp = tx.buildTempBucketMetaIdx(bucket, entry.Key, bucketMetaTemp)
 		}
+	}
 
-		if i == lastIndex {
-			txID := entry.Meta.TxID
-			if tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode {
-				if err := tx.buildTxIDRootIdx(txID, countFlag); err != nil {
-					return err
-				}
+	if _, err := tx.writeData(buff.Bytes()); err != nil {
+		return &CommitError{Written: written, Total: writesLen, Err: err}
+	}
+	tx.buildSparseIdx(written, writesLen, fileIDs, offsets, countFlag)
 
-				if err := tx.buildBucketMetaIdx(bucket, entry.Key, bucketMetaTemp); err != nil {
-					return err
-				}
-			} else {
-				tx.db.committedTxIds[txID] = struct{}{}
-			}
+	// from now on the transaction is durable, it is replayed on the next open.
+	lastEntry := tx.pendingWrites[lastIndex]
+	txID := lastEntry.Meta.TxID
+	if tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		if err := tx.buildTxIDRootIdx(txID, countFlag); err != nil {
+			return &CommitError{Written: writesLen, Total: writesLen, Committed: true, Err: err}
 		}
 
+		if err := tx.buildBucketMetaIdx(string(lastEntry.Bucket), lastEntry.Key, bucketMetaTemp); err != nil {
+			return &CommitError{Written: writesLen, Total: writesLen, Committed: true, Err: err}
+		}
+	} else {
+		tx.db.committedTxIds[txID] = struct{}{}
+	}
+
+	batchEnd := 0
+	for i, entry := range tx.pendingWrites {
+		bucket := string(entry.Bucket)
+
 		e = nil
 		if tx.db.opt.EntryIdxMode == HintKeyValAndRAMIdxMode {
 			e = entry
 		}
 
-		if entry.Meta.Ds == DataStructureBPTree {
-			tx.buildBPTreeIdx(bucket, entry, e, offset, countFlag)
+		if entry.Meta.Ds == DataStructureBPTree && tx.db.opt.EntryIdxMode != HintBPTSparseIdxMode {
+			if end, ok := tx.putBatches[i]; ok {
+				tx.buildBPTreeBatchIdx(bucket, i, end, fileIDs, offsets, countFlag)
+				batchEnd = end
+			}
+			if i >= batchEnd {
+				if tx.isMerge && tx.db.opt.OnValueMoved != nil {
+					tx.recordValueMove(bucket, entry, fileIDs[i], offsets[i])
+				}
+				tx.buildBPTreeIdx(bucket, entry, e, fileIDs[i], offsets[i], countFlag)
+			}
 		}
 		if entry.Meta.Ds == DataStructureNone && entry.Meta.Flag == DataBPTreeBucketDeleteFlag {
 			tx.db.deleteBucket(DataStructureBPTree, bucket)
 		}
+		tx.db.applyVectorEntry(bucket, entry)
+
+		if entry.Meta.Ds != DataStructureNone {
+			tx.db.hotKeys.write(bucket, entry.Key)
+		}
 	}
 
 	tx.buildIdxes()
+	tx.commitSequences()
+
+	db := tx.db
+	waitSync := !tx.async && db.opt.SyncEnable
+	writeThrough := tx.tieringQueued && db.opt.TieringMode == TieringWriteThrough
+	writes := tx.pendingWrites
+
+	// the watchers are notified before the next commit, so that they get the changes in order.
+	if !tx.isMerge {
+		db.watchers.notify(tx.ctx, tx.resolveWrites(writes), tx.changeSeqs)
+	}
 
 	tx.unlock()
+	db.listWaiters.notify(writes)
+	db.notifyValueMoves(tx.ctx, tx.valueMoves)
 
 	tx.db = nil
 
 	tx.pendingWrites = nil
+	tx.pendingKeys = nil
+	tx.pendingLists = nil
+	tx.pendingSets = nil
+	tx.pendingSortedSets = nil
 	tx.ReservedStoreTxIDIdxes = nil
 
+	// the fsync is awaited once the lock is released, so that it does not block the other transactions.
+	if waitSync {
+		f := newDurabilityFuture()
+		db.syncer.add(f)
+		if err := f.Wait(); err != nil {
+			return &CommitError{Written: writesLen, Total: writesLen, Committed: true, Err: err}
+		}
+	}
+
+	// the writes are pushed to the origin once they are durable.
+	if writeThrough {
+		if err := db.FlushTiering(); err != nil {
+			return &CommitError{Written: writesLen, Total: writesLen, Committed: true, Err: err}
+		}
+	}
+
+	tx.recordTrace(db.opt.WorkloadRecorder)
+
 	return nil
 }
 
+// buildSparseIdx adds to the sparse index of the active file the BPTree entries of the pending writes
+// from start to end, once they are written. The sparse index of the active file is persisted when
+// it rotates, so the entries written to it are indexed before it rotates, the others once all are written.
+func (tx *Tx) buildSparseIdx(start, end int, fileIDs, offsets []int64, countFlag bool) {
+	if tx.db.opt.EntryIdxMode != HintBPTSparseIdxMode {
+		return
+	}
+	for i := start; i < end; i++ {
+		entry := tx.pendingWrites[i]
+		if entry.Meta.Ds != DataStructureBPTree {
+			continue
+		}
+		bucket := string(entry.Bucket)
+		tx.db.BPTreeKeyEntryPosMap[string(getNewKey(bucket, entry.Key))] = offsets[i]
+		tx.buildBPTreeIdx(bucket, entry, nil, fileIDs[i], offsets[i], countFlag)
+	}
+}
+
 func (tx *Tx) buildTempBucketMetaIdx(bucket string, key []byte, bucketMetaTemp BucketMeta) BucketMeta {
 	keySize := uint32(len(key))
 	if bucketMetaTemp.start == nil {
@@ -386,43 +582,45 @@ func (tx *Tx) buildTxIDRootIdx(txID uint64, countFlag bool) error {
 func (tx *Tx) buildIdxes() {
 	writesLen := len(tx.pendingWrites)
 	for i := 0; i < writesLen; i++ {
-		entry := tx.pendingWrites[i]
+		tx.buildIdx(tx.pendingWrites[i])
+		tx.db.KeyCount++
+	}
+}
 
-		bucket := string(entry.Bucket)
+// buildIdx applies the entry to the index of the set, sorted set or list it writes to.
+func (tx *Tx) buildIdx(entry *Entry) {
+	bucket := string(entry.Bucket)
 
-		if entry.Meta.Ds == DataStructureSet {
-			tx.buildSetIdx(bucket, entry)
-		}
+	if entry.Meta.Ds == DataStructureSet {
+		tx.buildSetIdx(bucket, entry)
+	}
 
-		if entry.Meta.Ds == DataStructureSortedSet {
-			tx.buildSortedSetIdx(bucket, entry)
-		}
+	if entry.Meta.Ds == DataStructureSortedSet {
+		tx.buildSortedSetIdx(bucket, entry)
+	}
 
-		if entry.Meta.Ds == DataStructureList {
-			tx.buildListIdx(bucket, entry)
-		}
+	if entry.Meta.Ds == DataStructureList {
+		tx.buildListIdx(bucket, entry)
+	}
 
-		if entry.Meta.Ds == DataStructureNone {
-			if entry.Meta.Flag == DataSetBucketDeleteFlag {
-				tx.db.deleteBucket(DataStructureSet, bucket)
-			}
-			if entry.Meta.Flag == DataSortedSetBucketDeleteFlag {
-				tx.db.deleteBucket(DataStructureSortedSet, bucket)
-			}
-			if entry.Meta.Flag == DataListBucketDeleteFlag {
-				tx.db.deleteBucket(DataStructureList, bucket)
-			}
+	if entry.Meta.Ds == DataStructureNone {
+		if entry.Meta.Flag == DataSetBucketDeleteFlag {
+			tx.db.deleteBucket(DataStructureSet, bucket)
+		}
+		if entry.Meta.Flag == DataSortedSetBucketDeleteFlag {
+			tx.db.deleteBucket(DataStructureSortedSet, bucket)
+		}
+		if entry.Meta.Flag == DataListBucketDeleteFlag {
+			tx.db.deleteBucket(DataStructureList, bucket)
 		}
-
-		tx.db.KeyCount++
 	}
 }
 
-func (tx *Tx) buildBPTreeIdx(bucket string, entry, e *Entry, offset int64, countFlag bool) {
+func (tx *Tx) buildBPTreeIdx(bucket string, entry, e *Entry, fileID int64, offset int64, countFlag bool) {
 	if tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode {
 		newKey := getNewKey(bucket, entry.Key)
 		_ = tx.db.ActiveBPTreeIdx.Insert(newKey, e, &Hint{
-			FileID:  tx.db.ActiveFile.fileID,
+			FileID:  fileID,
 			Key:     newKey,
 			Meta:    entry.Meta,
 			DataPos: uint64(offset),
@@ -435,12 +633,9 @@ func (tx *Tx) buildBPTreeIdx(bucket string, entry, e *Entry, offset int64, count
 		if tx.db.BPTreeIdx[bucket] == nil {
 			tx.db.BPTreeIdx[bucket] = NewTree()
 		}
-		_ = tx.db.BPTreeIdx[bucket].Insert(entry.Key, e, &Hint{
-			FileID:  tx.db.ActiveFile.fileID,
-			Key:     entry.Key,
-			Meta:    entry.Meta,
-			DataPos: uint64(offset),
-		}, countFlag)
+		tx.db.addDeadStat(tx.db.BPTreeIdx[bucket], entry.Key)
+		h := tx.db.newHint(entry.Key, fileID, entry.Meta, uint64(offset))
+		_ = tx.db.BPTreeIdx[bucket].Insert(h.Key, e, h, countFlag)
 	}
 }
 
@@ -449,12 +644,27 @@ func (tx *Tx) buildSetIdx(bucket string, entry *Entry) {
 		tx.db.SetIdx[bucket] = set.New()
 	}
 
+	applySetEntry(tx.db.SetIdx[bucket], entry)
+}
+
+// applySetEntry applies the set write of the entry to s.
+func applySetEntry(s *set.Set, entry *Entry) {
 	if entry.Meta.Flag == DataDeleteFlag {
-		_ = tx.db.SetIdx[bucket].SRem(string(entry.Key), entry.Value)
+		_ = s.SRem(string(entry.Key), entry.Value)
 	}
 
 	if entry.Meta.Flag == DataSetFlag {
-		_ = tx.db.SetIdx[bucket].SAdd(string(entry.Key), entry.Value)
+		_ = s.SAdd(string(entry.Key), entry.Value)
+	}
+
+	if entry.Meta.Flag == DataSetSnapshotFlag {
+		members, _ := UnmarshalListItems(entry.Value)
+		setMembers(s, string(entry.Key), members)
+	}
+
+	if entry.Meta.Flag == DataSAddBatchFlag {
+		members, _ := UnmarshalListItems(entry.Value)
+		_ = s.SAdd(string(entry.Key), members...)
 	}
 }
 
@@ -463,23 +673,48 @@ func (tx *Tx) buildSortedSetIdx(bucket string, entry *Entry) {
 		tx.db.SortedSetIdx[bucket] = zset.New()
 	}
 
+	tx.db.SortedSetIdx[bucket] = applySortedSetEntry(tx.db.SortedSetIdx[bucket], entry)
+}
+
+// applySortedSetEntry applies the sorted set write of the entry to ss, and returns the sorted set
+// holding the result, which is a new one for a snapshot.
+func applySortedSetEntry(ss *zset.SortedSet, entry *Entry) *zset.SortedSet {
 	switch entry.Meta.Flag {
 	case DataZAddFlag:
-		keyAndScore := strings.Split(string(entry.Key), SeparatorForZSetKey)
-		key := keyAndScore[0]
-		score, _ := strconv2.StrToFloat64(keyAndScore[1])
-		_ = tx.db.SortedSetIdx[bucket].Put(key, zset.SCORE(score), entry.Value)
+		key, score := splitZAddKey(entry.Key)
+		_ = ss.Put(key, score, entry.Value)
 	case DataZRemFlag:
-		_ = tx.db.SortedSetIdx[bucket].Remove(string(entry.Key))
+		_ = ss.Remove(string(entry.Key))
 	case DataZRemRangeByRankFlag:
 		start, _ := strconv2.StrToInt(string(entry.Key))
 		end, _ := strconv2.StrToInt(string(entry.Value))
-		_ = tx.db.SortedSetIdx[bucket].GetByRankRange(start, end, true)
+		_ = ss.GetByRankRange(start, end, true)
+	case DataZRemRangeByScoreFlag:
+		start, end, opts := decodeZScoreRange(entry.Key, entry.Value)
+		for _, n := range ss.GetByScoreRange(start, end, opts) {
+			_ = ss.Remove(n.Key())
+		}
+	case DataZRemRangeByLexFlag:
+		for _, n := range ss.GetByRankRange(1, -1, false) {
+			if inZLexRange(n.Key(), entry.Key, entry.Value) {
+				_ = ss.Remove(n.Key())
+			}
+		}
 	case DataZPopMaxFlag:
-		_ = tx.db.SortedSetIdx[bucket].PopMax()
+		_ = ss.PopMax()
 	case DataZPopMinFlag:
-		_ = tx.db.SortedSetIdx[bucket].PopMin()
+		_ = ss.PopMin()
+	case DataZSetSnapshotFlag:
+		if snapshot, err := UnmarshalSortedSetNodes(entry.Value); err == nil {
+			return snapshot
+		}
+	case DataZAddBatchFlag:
+		members, _ := unmarshalZMembers(entry.Value)
+		for _, m := range members {
+			_ = ss.Put(string(m.Key), zset.SCORE(m.Score), m.Value)
+		}
 	}
+	return ss
 }
 
 func (tx *Tx) buildListIdx(bucket string, entry *Entry) {
@@ -488,10 +723,20 @@ func (tx *Tx) buildListIdx(bucket string, entry *Entry) {
 	}
 	l := tx.db.Index.getList(bucket)
 
-	key, value := entry.Key, entry.Value
-	if IsExpired(entry.Meta.TTL, entry.Meta.Timestamp) {
+	if tx.db.isTTLExpired(entry.Meta.TTL, entry.Meta.Timestamp) {
 		return
 	}
+
+	l.Clock = recordClock(entry.Meta.Timestamp)
+	defer func() { l.Clock = tx.db.Index.clock }()
+	applyListEntry(l, entry)
+}
+
+// applyListEntry applies the list record of the entry to l.
+func applyListEntry(l *list.List, entry *Entry) {
+	dropExpiredList(l, entry)
+
+	key, value := entry.Key, entry.Value
 	switch entry.Meta.Flag {
 	case DataExpireListFlag:
 		t, _ := strconv2.StrToInt64(string(value))
@@ -513,6 +758,8 @@ func (tx *Tx) buildListIdx(bucket string, entry *Entry) {
 		_, _ = l.LPop(string(key))
 	case DataRPopFlag:
 		_, _ = l.RPop(string(key))
+	case DataLPopRefFlag, DataRPopRefFlag, DataLPopNFlag, DataRPopNFlag:
+		applyListPopRef(l, entry)
 	case DataLSetFlag:
 		keyAndIndex := strings.Split(string(key), SeparatorForListKey)
 		newKey := keyAndIndex[0]
@@ -527,6 +774,11 @@ func (tx *Tx) buildListIdx(bucket string, entry *Entry) {
 	case DataLRemByIndex:
 		indexes, _ := UnmarshalInts(value)
 		_, _ = l.LRemByIndex(string(key), indexes)
+	case DataLCompactFlag:
+		items, _ := UnmarshalListItems(value)
+		setListItems(l, string(key), items)
+	case DataLInsertBeforeFlag, DataLInsertAfterFlag:
+		_ = applyListInsert(l, entry)
 	}
 }
 
@@ -534,14 +786,23 @@ func (tx *Tx) buildListIdx(bucket string, entry *Entry) {
 func (tx *Tx) rotateActiveFile() error {
 	var err error
 	fID := tx.db.MaxFileID
+
+	// the active file must not be fsynced in the background while it is released.
+	tx.db.syncer.fileMu.Lock()
+	defer tx.db.syncer.fileMu.Unlock()
 	tx.db.MaxFileID++
 
-	if !tx.db.opt.SyncEnable && tx.db.opt.RWMode == MMap {
+	// the data files before the active one are durable, the checkpoints rely on it.
+	if !tx.db.opt.SyncEnable {
 		if err := tx.db.ActiveFile.rwManager.Sync(); err != nil {
 			return err
 		}
 	}
 
+	if err := tx.db.syncer.syncBeforeRelease(); err != nil {
+		return err
+	}
+
 	if err := tx.db.ActiveFile.rwManager.Release(); err != nil {
 		return err
 	}
@@ -618,10 +879,14 @@ func (tx *Tx) writeData(data []byte) (n int, err error) {
 	tx.db.ActiveFile.writeOff += int64(l)
 	tx.db.ActiveFile.ActualSize += int64(l)
 
-	if tx.db.opt.SyncEnable {
-		if err := tx.db.ActiveFile.rwManager.Sync(); err != nil {
-			return 0, err
-		}
+	if tx.isMerge {
+		tx.db.mergeBytesWritten += int64(l)
+	} else {
+		tx.db.userBytesWritten += int64(l)
+	}
+
+	if tx.async || tx.db.opt.SyncEnable {
+		tx.db.syncer.markUnsynced()
 	}
 
 	return
@@ -641,21 +906,42 @@ func (tx *Tx) Rollback() error {
 
 	tx.db = nil
 	tx.pendingWrites = nil
+	tx.pendingKeys = nil
+	tx.pendingLists = nil
+	tx.pendingSets = nil
+	tx.pendingSortedSets = nil
 
 	return nil
 }
 
 // lock locks the database based on the transaction type.
-func (tx *Tx) lock() {
+// It returns ErrDeadlock instead of blocking forever on the lock held by the parent transaction.
+func (tx *Tx) lock(parentTxID uint64) error {
+	dequeue := func() {}
+	if tx.writable {
+		var err error
+		if dequeue, err = tx.db.queueWriter(); err != nil {
+			return err
+		}
+	}
+	if err := tx.db.locks.wait(tx.id, tx.writable, parentTxID); err != nil {
+		dequeue()
+		return err
+	}
 	if tx.writable {
 		tx.db.mu.Lock()
+		dequeue()
 	} else {
 		tx.db.mu.RLock()
 	}
+	tx.db.locks.acquired(tx.id)
+	return nil
 }
 
 // unlock unlocks the database based on the transaction type.
 func (tx *Tx) unlock() {
+	tx.dropTempBuckets()
+	tx.db.locks.released(tx.id)
 	if tx.writable {
 		tx.db.mu.Unlock()
 	} else {
@@ -670,7 +956,7 @@ func (tx *Tx) PutWithTimestamp(bucket string, key, value []byte, ttl uint32, tim
 // Put sets the value for a key in the bucket.
 // a wrapper of the function put.
 func (tx *Tx) Put(bucket string, key, value []byte, ttl uint32) error {
-	return tx.put(bucket, key, value, ttl, DataSetFlag, uint64(time.Now().Unix()), DataStructureBPTree)
+	return tx.put(bucket, key, value, ttl, DataSetFlag, tx.db.timestamp(), DataStructureBPTree)
 }
 
 func (tx *Tx) checkTxIsClosed() error {
@@ -683,6 +969,11 @@ func (tx *Tx) checkTxIsClosed() error {
 // put sets the value for a key in the bucket.
 // Returns an error if tx is closed, if performing a write operation on a read-only transaction, if the key is empty.
 func (tx *Tx) put(bucket string, key, value []byte, ttl uint32, flag uint16, timestamp uint64, ds uint16) error {
+	return tx.putValue(bucket, key, value, ttl, flag, timestamp, ds, valueKindPlain)
+}
+
+// putValue is put for a value of given valueKind, the values stored indirectly are rewritten as they are.
+func (tx *Tx) putValue(bucket string, key, value []byte, ttl uint32, flag uint16, timestamp uint64, ds uint16, kind valueKind) error {
 	if err := tx.checkTxIsClosed(); err != nil {
 		return err
 	}
@@ -691,10 +982,22 @@ func (tx *Tx) put(bucket string, key, value []byte, ttl uint32, flag uint16, tim
 		return ErrTxNotWritable
 	}
 
+	if !tx.db.isBucketOpen(bucket) {
+		return ErrBucketNotOpen
+	}
+
+	if err := tx.checkImmutable(bucket, key, flag, ds); err != nil {
+		return err
+	}
+
+	if err := tx.checkTypeConflict(bucket, key, flag, ds); err != nil {
+		return err
+	}
+
 	e := &Entry{
 		Key:    key,
 		Value:  value,
-		Bucket: []byte(bucket),
+		Bucket: tx.bucketBytes(bucket),
 		Meta: &MetaData{
 			KeySize:    uint32(len(key)),
 			ValueSize:  uint32(len(value)),
@@ -712,7 +1015,58 @@ func (tx *Tx) put(bucket string, key, value []byte, ttl uint32, flag uint16, tim
 	if err != nil {
 		return err
 	}
+
+	if tx.isTempBucket(bucket) {
+		return tx.putTemp(e)
+	}
+
+	codec := CompressionNone
+	if ds == DataStructureBPTree {
+		if err := tx.indexText(bucket, key, value, flag); err != nil {
+			return err
+		}
+		if e.Value, kind, err = tx.dedupValue(bucket, key, value, ttl, flag, kind); err != nil {
+			return err
+		}
+		if e.Value, codec, err = tx.compressValue(bucket, e.Value, flag, kind); err != nil {
+			return err
+		}
+		// the codec of a value kept in the value log is held by the pointer to it.
+		if e.Value, codec, kind, err = tx.separateValue(bucket, key, e.Value, flag, codec, kind); err != nil {
+			return err
+		}
+		e.Meta.ValueSize = uint32(len(e.Value))
+	}
+	if ds == DataStructureNone && flag == DataBPTreeBucketDeleteFlag {
+		if err := tx.dropDedupBucket(bucket); err != nil {
+			return err
+		}
+	}
+
+	if tx.db.opt.CompactBucketIDs && tx.db.opt.EntryIdxMode != HintBPTSparseIdxMode {
+		e.bucketID = tx.db.bucketIDs.encodedID(bucket)
+		e.Meta.BucketSize = bucketIDFlag | uint32(len(e.bucketID))
+	}
+	e.Meta.setCodec(codec)
+	e.Meta.setValueKind(kind)
+	if tx.db.keys.encrypt {
+		if err := tx.db.keys.prepare(bucket); err != nil {
+			return err
+		}
+		e.Meta.BucketSize |= bucketSealedFlag
+		e.keys = tx.db.keys
+	}
+
 	tx.pendingWrites = append(tx.pendingWrites, e)
+	tx.bufferWrite(e)
+
+	if ds == DataStructureBPTree {
+		if flag == DataDeleteFlag {
+			tx.traceOp(TraceOpDelete, bucket, key, 0)
+		} else {
+			tx.traceOp(TraceOpPut, bucket, key, len(value))
+		}
+	}
 
 	return nil
 }
@@ -0,0 +1,131 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"strconv"
+)
+
+// ErrNoNumericValue is returned by Min and Max when no value matching the prefix is a number.
+var ErrNoNumericValue = errors.New("no numeric value matches the prefix")
+
+// Aggregate holds the aggregates of the numeric values of the keys matching a prefix.
+type Aggregate struct {
+	// Count is the number of numeric values, the other values are skipped.
+	Count int
+	Sum   float64
+	Min   float64
+	Max   float64
+}
+
+// Count returns the number of keys of the bucket with the prefix, an empty prefix counts all the keys.
+// Only the index is walked, the values are not read. The writes of the tx itself are not counted.
+func (tx *Tx) Count(bucket string, prefix []byte) (int, error) {
+	records, err := tx.prefixRecords(bucket, prefix)
+	if err != nil {
+		return 0, err
+	}
+	return len(records), nil
+}
+
+// Aggregate returns the count, sum, min and max of the values of the keys of the bucket with the prefix
+// which parse as decimal numbers, the other values are skipped. The writes of the tx itself are not seen.
+func (tx *Tx) Aggregate(bucket string, prefix []byte) (Aggregate, error) {
+	var agg Aggregate
+
+	records, err := tx.prefixRecords(bucket, prefix)
+	if err != nil {
+		return agg, err
+	}
+
+	for _, r := range records {
+		e, err := tx.db.readValue(tx.ctx, bucket, r.H.Key, r)
+		if err != nil {
+			return Aggregate{}, err
+		}
+		n, err := strconv.ParseFloat(string(e.Value), 64)
+		if err != nil {
+			continue
+		}
+
+		if agg.Count == 0 || n < agg.Min {
+			agg.Min = n
+		}
+		if agg.Count == 0 || n > agg.Max {
+			agg.Max = n
+		}
+		agg.Sum += n
+		agg.Count++
+	}
+
+	return agg, nil
+}
+
+// Sum returns the sum of the numeric values of the keys of the bucket with the prefix.
+func (tx *Tx) Sum(bucket string, prefix []byte) (float64, error) {
+	agg, err := tx.Aggregate(bucket, prefix)
+	return agg.Sum, err
+}
+
+// Min returns the smallest numeric value of the keys of the bucket with the prefix.
+func (tx *Tx) Min(bucket string, prefix []byte) (float64, error) {
+	agg, err := tx.Aggregate(bucket, prefix)
+	if err == nil && agg.Count == 0 {
+		err = ErrNoNumericValue
+	}
+	return agg.Min, err
+}
+
+// Max returns the largest numeric value of the keys of the bucket with the prefix.
+func (tx *Tx) Max(bucket string, prefix []byte) (float64, error) {
+	agg, err := tx.Aggregate(bucket, prefix)
+	if err == nil && agg.Count == 0 {
+		err = ErrNoNumericValue
+	}
+	return agg.Max, err
+}
+
+// prefixRecords returns the records of the live keys of the bucket with the prefix.
+func (tx *Tx) prefixRecords(bucket string, prefix []byte) (Records, error) {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return nil, err
+	}
+	if tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return nil, ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	idx, ok := tx.db.BPTreeIdx[bucket]
+	if !ok {
+		return nil, ErrNotFoundBucket
+	}
+
+	records, _, err := idx.PrefixScan(prefix, 0, ScanNoLimit)
+	if err != nil {
+		return nil, nil
+	}
+
+	live := records[:0]
+	for _, r := range records {
+		if r.H.Meta.Flag == DataDeleteFlag || tx.db.isExpired(bucket, r.H.Meta) {
+			continue
+		}
+		if _, ok := tx.db.committedTxIds[r.H.Meta.TxID]; !ok {
+			continue
+		}
+		live = append(live, r)
+	}
+	return live, nil
+}
@@ -0,0 +1,85 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTx_CountAndAggregate(t *testing.T) {
+	bucket := "bucket_aggregate"
+
+	for _, mode := range []EntryIdxMode{HintKeyValAndRAMIdxMode, HintKeyAndRAMIdxMode} {
+		InitOpt("/tmp/nutsdbtestaggregate", true)
+		db, err := Open(opt, WithEntryIdxMode(mode))
+		require.NoError(t, err)
+
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			values := map[string]string{
+				"cpu:1": "10", "cpu:2": "-2.5", "cpu:3": "7", "cpu:4": "n/a", "cpu:5": "100",
+				"mem:1": "1000",
+			}
+			for k, v := range values {
+				if err := tx.Put(bucket, []byte(k), []byte(v), Persistent); err != nil {
+					return err
+				}
+			}
+			expired := uint64(time.Now().Add(-time.Minute).Unix())
+			return tx.PutWithTimestamp(bucket, []byte("cpu:6"), []byte("1"), 1, expired)
+		}))
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.Delete(bucket, []byte("cpu:5"))
+		}))
+
+		require.NoError(t, db.View(func(tx *Tx) error {
+			n, err := tx.Count(bucket, []byte("cpu:"))
+			require.NoError(t, err)
+			assert.Equal(t, 4, n)
+
+			n, err = tx.Count(bucket, nil)
+			require.NoError(t, err)
+			assert.Equal(t, 5, n)
+
+			n, err = tx.Count(bucket, []byte("disk:"))
+			require.NoError(t, err)
+			assert.Equal(t, 0, n)
+
+			agg, err := tx.Aggregate(bucket, []byte("cpu:"))
+			require.NoError(t, err)
+			assert.Equal(t, Aggregate{Count: 3, Sum: 14.5, Min: -2.5, Max: 10}, agg)
+
+			sum, err := tx.Sum(bucket, nil)
+			require.NoError(t, err)
+			assert.Equal(t, 1014.5, sum)
+
+			max, err := tx.Max(bucket, []byte("mem:"))
+			require.NoError(t, err)
+			assert.Equal(t, float64(1000), max)
+
+			_, err = tx.Min(bucket, []byte("disk:"))
+			assert.Equal(t, ErrNoNumericValue, err)
+
+			_, err = tx.Count("bucket_missing", nil)
+			assert.Equal(t, ErrNotFoundBucket, err)
+			return nil
+		}))
+
+		require.NoError(t, db.Close())
+	}
+}
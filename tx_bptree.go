@@ -18,7 +18,6 @@ import (
 	"bytes"
 	"fmt"
 	"regexp"
-	"time"
 
 	"github.com/xujiajun/utils/strconv2"
 )
@@ -83,7 +82,7 @@ func (tx *Tx) getByHintBPTSparseIdxOnDisk(bucket string, key []byte) (e *Entry,
 
 			e, err = tx.FindOnDisk(fID, rootOff, key, newKey)
 			if err == nil && e != nil {
-				if e.Meta.Flag == DataDeleteFlag || IsExpired(e.Meta.TTL, e.Meta.Timestamp) {
+				if e.Meta.Flag == DataDeleteFlag || tx.db.isExpired(bucket, e.Meta) {
 					return nil, ErrNotFoundKey
 				}
 
@@ -109,7 +108,7 @@ func (tx *Tx) getByHintBPTSparseIdx(bucket string, key []byte) (e *Entry, err er
 
 	entry, err := tx.getByHintBPTSparseIdxInMem(newKey)
 	if entry != nil && err == nil {
-		if entry.Meta.Flag == DataDeleteFlag || IsExpired(entry.Meta.TTL, entry.Meta.Timestamp) {
+		if entry.Meta.Flag == DataDeleteFlag || tx.db.isExpired(bucket, entry.Meta) {
 			return nil, ErrNotFoundKey
 		}
 		return entry, err
@@ -129,16 +128,38 @@ func (tx *Tx) getAllByHintBPTSparseIdx(bucket string) (entries Entries, err erro
 		return nil, err
 	}
 
-	return tx.RangeScan(bucket, bucketMeta.start, bucketMeta.end)
+	return tx.rangeScan(bucket, bucketMeta.start, bucketMeta.end)
 }
 
 // Get retrieves the value for a key in the bucket.
 // The returned value is only valid for the life of the transaction.
+// The value is resolved along the Options.ReadPath, except in the HintBPTSparseIdxMode.
 func (tx *Tx) Get(bucket string, key []byte) (e *Entry, err error) {
 	if err := tx.checkTxIsClosed(); err != nil {
 		return nil, err
 	}
 
+	tx.db.hotKeys.read(bucket, key)
+	tx.traceOp(TraceOpGet, bucket, key, 0)
+
+	return tx.lookup(bucket, key)
+}
+
+// lookup returns the value of the key in the bucket as Get does, without counting the read.
+func (tx *Tx) lookup(bucket string, key []byte) (*Entry, error) {
+	e, err := tx.lookupStored(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return tx.resolveValue(bucket, e)
+}
+
+// lookupStored returns the value of the key in the bucket as it is stored, see resolveValue.
+func (tx *Tx) lookupStored(bucket string, key []byte) (*Entry, error) {
+	if e, ok, err := tx.pendingGet(bucket, key); ok {
+		return e, err
+	}
+
 	idxMode := tx.db.opt.EntryIdxMode
 
 	if idxMode == HintBPTSparseIdxMode {
@@ -146,59 +167,52 @@ func (tx *Tx) Get(bucket string, key []byte) (e *Entry, err error) {
 	}
 
 	if idxMode == HintKeyValAndRAMIdxMode || idxMode == HintKeyAndRAMIdxMode {
-		if idx, ok := tx.db.BPTreeIdx[bucket]; ok {
-			r, err := idx.Find(key)
-			if err != nil {
-				return nil, err
-			}
-
-			if _, ok := tx.db.committedTxIds[r.H.Meta.TxID]; !ok {
-				return nil, ErrNotFoundKey
-			}
+		r, err := tx.findRecord(bucket, key)
+		if err != nil {
+			return nil, err
+		}
+		return tx.db.readValue(tx.ctx, bucket, key, r)
+	}
 
-			if r.H.Meta.Flag == DataDeleteFlag || r.IsExpired() {
-				return nil, ErrNotFoundKey
-			}
+	return nil, ErrBucketAndKey(bucket, key)
+}
 
-			if idxMode == HintKeyValAndRAMIdxMode {
-				return r.E, nil
-			}
+// findRecord returns the record of the live value of the key in the bucket from the in-memory index.
+func (tx *Tx) findRecord(bucket string, key []byte) (*Record, error) {
+	idx, ok := tx.db.BPTreeIdx[bucket]
+	if !ok {
+		return nil, ErrNotFoundBucket
+	}
 
-			if idxMode == HintKeyAndRAMIdxMode {
-				path := tx.db.getDataPath(r.H.FileID)
-				df, err := tx.db.fm.getDataFile(path, tx.db.opt.SegmentSize)
-				if err != nil {
-					return nil, err
-				}
-				defer func(rwManager RWManager) {
-					err := rwManager.Release()
-					if err != nil {
-						return
-					}
-				}(df.rwManager)
+	r, err := idx.Find(key)
+	if err != nil {
+		return nil, err
+	}
 
-				payloadSize := r.H.Meta.PayloadSize()
-				item, err := df.ReadRecord(int(r.H.DataPos), payloadSize)
-				if err != nil {
-					return nil, fmt.Errorf("read err. pos %d, key %s, err %s", r.H.DataPos, string(key), err)
-				}
+	if _, ok := tx.db.committedTxIds[r.H.Meta.TxID]; !ok {
+		return nil, ErrNotFoundKey
+	}
 
-				return item, nil
-			}
-		} else {
-			return nil, ErrNotFoundBucket
-		}
+	if r.H.Meta.Flag == DataDeleteFlag || tx.db.isExpired(bucket, r.H.Meta) {
+		return nil, ErrNotFoundKey
 	}
 
-	return nil, ErrBucketAndKey(bucket, key)
+	return r, nil
 }
 
-// GetAll returns all keys and values of the bucket stored at given bucket.
-func (tx *Tx) GetAll(bucket string) (entries Entries, err error) {
+// GetAll returns all keys and values of the bucket stored at given bucket,
+// with the pending writes of the tx.
+func (tx *Tx) GetAll(bucket string) (Entries, error) {
 	if err := tx.checkTxIsClosed(); err != nil {
 		return nil, err
 	}
 
+	entries, err := tx.getAll(bucket)
+	return tx.scanPending(bucket, entries, err, ErrBucketEmpty, nil, false)
+}
+
+// getAll returns all keys and values of the bucket as GetAll does, without the pending writes of the tx.
+func (tx *Tx) getAll(bucket string) (entries Entries, err error) {
 	entries = Entries{}
 
 	idxMode := tx.db.opt.EntryIdxMode
@@ -214,7 +228,7 @@ func (tx *Tx) GetAll(bucket string) (entries Entries, err error) {
 				return nil, ErrBucketEmpty
 			}
 
-			entries, err = tx.getHintIdxDataItemsWrapper(records, ScanNoLimit, entries, RangeScan)
+			entries, err = tx.getHintIdxDataItemsWrapper(bucket, records, ScanNoLimit, entries, RangeScan)
 			if err != nil {
 				return nil, ErrBucketEmpty
 			}
@@ -228,12 +242,25 @@ func (tx *Tx) GetAll(bucket string) (entries Entries, err error) {
 	return
 }
 
-// RangeScan query a range at given bucket, start and end slice.
-func (tx *Tx) RangeScan(bucket string, start, end []byte) (es Entries, err error) {
+// RangeScan query a range at given bucket, start and end slice, with the pending writes of the tx.
+func (tx *Tx) RangeScan(bucket string, start, end []byte) (Entries, error) {
 	if err := tx.checkTxIsClosed(); err != nil {
 		return nil, err
 	}
 
+	es, err := tx.rangeScan(bucket, start, end)
+	return tx.scanPending(bucket, es, err, ErrRangeScan, inRange(start, end), false)
+}
+
+// inRange returns whether the key is in the range from start to end, both included.
+func inRange(start, end []byte) func(key []byte) bool {
+	return func(key []byte) bool {
+		return compare(key, start) >= 0 && compare(key, end) <= 0
+	}
+}
+
+// rangeScan query a range as RangeScan does, without the pending writes of the tx.
+func (tx *Tx) rangeScan(bucket string, start, end []byte) (es Entries, err error) {
 	if tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode {
 		newStart, newEnd := getNewKey(bucket, start), getNewKey(bucket, end)
 		records, err := tx.db.ActiveBPTreeIdx.Range(newStart, newEnd)
@@ -273,7 +300,7 @@ func (tx *Tx) RangeScan(bucket string, start, end []byte) (es Entries, err error
 		if len(es) == 0 {
 			return nil, ErrRangeScan
 		}
-		return processEntriesScanOnDisk(es), nil
+		return tx.processEntriesScanOnDisk(es), nil
 	}
 
 	if index, ok := tx.db.BPTreeIdx[bucket]; ok {
@@ -282,7 +309,7 @@ func (tx *Tx) RangeScan(bucket string, start, end []byte) (es Entries, err error
 			return nil, ErrRangeScan
 		}
 
-		es, err = tx.getHintIdxDataItemsWrapper(records, ScanNoLimit, es, RangeScan)
+		es, err = tx.getHintIdxDataItemsWrapper(bucket, records, ScanNoLimit, es, RangeScan)
 		if err != nil {
 			return nil, ErrRangeScan
 		}
@@ -295,6 +322,58 @@ func (tx *Tx) RangeScan(bucket string, start, end []byte) (es Entries, err error
 	return
 }
 
+// RangeScanReverse query a range at given bucket, start and end slice as RangeScan does,
+// with the entries in the descending order of their keys, e.g. the latest first for time prefixed keys.
+// The leaves of the index are walked backwards from end as the iterator of the IteratorOptions.Reverse does,
+// down to start. The HintBPTSparseIdxMode reads the range as RangeScan does and reverses it.
+func (tx *Tx) RangeScanReverse(bucket string, start, end []byte) (Entries, error) {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return nil, err
+	}
+
+	es, err := tx.rangeScanReverse(bucket, start, end)
+	return tx.scanPending(bucket, es, err, ErrRangeScan, inRange(start, end), true)
+}
+
+// rangeScanReverse query a range as RangeScanReverse does, without the pending writes of the tx.
+func (tx *Tx) rangeScanReverse(bucket string, start, end []byte) (Entries, error) {
+	if tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		es, err := tx.rangeScan(bucket, start, end)
+		if err != nil {
+			return nil, err
+		}
+		for l, r := 0, len(es)-1; l < r; l, r = l+1, r-1 {
+			es[l], es[r] = es[r], es[l]
+		}
+		return es, nil
+	}
+
+	if _, ok := tx.db.BPTreeIdx[bucket]; !ok || compare(start, end) > 0 {
+		// fails as RangeScan does.
+		return tx.rangeScan(bucket, start, end)
+	}
+
+	it := NewIterator(tx, bucket, IteratorOptions{Reverse: true})
+	it.seek(end)
+	var records Records
+	for {
+		record, err := it.nextRecord()
+		if err != nil {
+			return nil, err
+		}
+		if record == nil || compare(record.H.Key, start) < 0 {
+			break
+		}
+		records = append(records, record)
+	}
+
+	es, err := tx.getHintIdxDataItemsWrapper(bucket, records, ScanNoLimit, nil, RangeScan)
+	if err != nil || len(es) == 0 {
+		return nil, ErrRangeScan
+	}
+	return es, nil
+}
+
 func (tx *Tx) rangeScanOnDisk(bucket string, start, end []byte) ([]*Entry, error) {
 	var result []*Entry
 
@@ -401,7 +480,7 @@ func (tx *Tx) prefixSearchScanOnDisk(bucket string, prefix []byte, reg string, o
 	return result, off, nil
 }
 
-func processEntriesScanOnDisk(entriesTemp []*Entry) (result []*Entry) {
+func (tx *Tx) processEntriesScanOnDisk(entriesTemp []*Entry) (result []*Entry) {
 	entriesMap := make(map[string]*Entry)
 
 	for _, entry := range entriesTemp {
@@ -412,7 +491,7 @@ func processEntriesScanOnDisk(entriesTemp []*Entry) (result []*Entry) {
 
 	keys, es := SortedEntryKeys(entriesMap)
 	for _, key := range keys {
-		if !IsExpired(es[key].Meta.TTL, es[key].Meta.Timestamp) && es[key].Meta.Flag != DataDeleteFlag {
+		if !tx.db.isTTLExpired(es[key].Meta.TTL, es[key].Meta.Timestamp) && es[key].Meta.Flag != DataDeleteFlag {
 			result = append(result, es[key])
 		}
 	}
@@ -740,7 +819,7 @@ func (tx *Tx) prefixScanByHintBPTSparseIdx(bucket string, prefix []byte, offsetN
 		return nil, off, ErrPrefixScan
 	}
 
-	return processEntriesScanOnDisk(es), off, nil
+	return tx.processEntriesScanOnDisk(es), off, nil
 }
 
 func (tx *Tx) prefixSearchScanByHintBPTSparseIdx(bucket string, prefix []byte, reg string, offsetNum int, limitNum int) (es Entries, off int, err error) {
@@ -793,17 +872,57 @@ func (tx *Tx) prefixSearchScanByHintBPTSparseIdx(bucket string, prefix []byte, r
 		return nil, off, ErrPrefixSearchScan
 	}
 
-	return processEntriesScanOnDisk(es), off, nil
+	return tx.processEntriesScanOnDisk(es), off, nil
 }
 
 // PrefixScan iterates over a key prefix at given bucket, prefix and limitNum.
 // LimitNum will limit the number of entries return.
+// With pending writes in the bucket, the offset skips the entries merged with them rather than the records of the index.
 func (tx *Tx) PrefixScan(bucket string, prefix []byte, offsetNum int, limitNum int) (es Entries, off int, err error) {
 
 	if err := tx.checkTxIsClosed(); err != nil {
 		return nil, off, err
 	}
 
+	pb, ok := tx.pendingKeys[bucket]
+	if !ok {
+		return tx.prefixScan(bucket, prefix, offsetNum, limitNum)
+	}
+	es, _, err = tx.prefixScan(bucket, prefix, 0, pb.scanLimit(offsetNum, limitNum))
+	es, err = tx.scanPending(bucket, es, err, ErrPrefixScan, hasPrefix(prefix), false)
+	return pagePending(es, err, offsetNum, limitNum, ErrPrefixScan)
+}
+
+// hasPrefix returns whether the key starts with the prefix.
+func hasPrefix(prefix []byte) func(key []byte) bool {
+	return func(key []byte) bool {
+		return bytes.HasPrefix(key, prefix)
+	}
+}
+
+// pagePending applies the offset and the limit of a prefix scan to its entries merged with the pending writes of
+// the tx, see scanPending, and returns them with the number of entries skipped, or notFound if none is left.
+func pagePending(es Entries, err error, offsetNum, limitNum int, notFound error) (Entries, int, error) {
+	if err != nil {
+		return nil, 0, err
+	}
+
+	off := offsetNum
+	if off > len(es) {
+		off = len(es)
+	}
+	es = es[off:]
+	if limitNum > 0 && len(es) > limitNum {
+		es = es[:limitNum]
+	}
+	if len(es) == 0 {
+		return nil, off, notFound
+	}
+	return es, off, nil
+}
+
+// prefixScan iterates over a key prefix as PrefixScan does, without the pending writes of the tx.
+func (tx *Tx) prefixScan(bucket string, prefix []byte, offsetNum int, limitNum int) (es Entries, off int, err error) {
 	if tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode {
 		return tx.prefixScanByHintBPTSparseIdx(bucket, prefix, offsetNum, limitNum)
 	}
@@ -815,7 +934,7 @@ func (tx *Tx) PrefixScan(bucket string, prefix []byte, offsetNum int, limitNum i
 			return nil, off, ErrPrefixScan
 		}
 
-		es, err = tx.getHintIdxDataItemsWrapper(records, limitNum, es, PrefixScan)
+		es, err = tx.getHintIdxDataItemsWrapper(bucket, records, limitNum, es, PrefixScan)
 		if err != nil {
 			off = voff
 			return nil, off, ErrPrefixScan
@@ -832,14 +951,96 @@ func (tx *Tx) PrefixScan(bucket string, prefix []byte, offsetNum int, limitNum i
 	return
 }
 
+// PrefixScanKeys iterates over a key prefix at given bucket, prefix and limitNum like PrefixScan,
+// but returns the keys only, taken from the index without reading the values from the data files.
+// In the HintBPTSparseIdxMode, whose index is on disk, the entries are read as by PrefixScan.
+func (tx *Tx) PrefixScanKeys(bucket string, prefix []byte, offsetNum int, limitNum int) (keys [][]byte, off int, err error) {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return nil, off, err
+	}
+
+	pb, ok := tx.pendingKeys[bucket]
+	if !ok {
+		return tx.prefixScanKeys(bucket, prefix, offsetNum, limitNum)
+	}
+	keys, _, err = tx.prefixScanKeys(bucket, prefix, 0, pb.scanLimit(offsetNum, limitNum))
+	if err != nil && err != ErrPrefixScan {
+		return nil, 0, err
+	}
+	es := make(Entries, len(keys))
+	for i, key := range keys {
+		es[i] = &Entry{Key: key}
+	}
+	es, err = tx.mergePending(bucket, es, hasPrefix(prefix), false, true)
+	es, off, err = pagePending(es, err, offsetNum, limitNum, ErrPrefixScan)
+	keys = nil
+	for _, e := range es {
+		keys = append(keys, e.Key)
+	}
+	return keys, off, err
+}
+
+// prefixScanKeys iterates over a key prefix as PrefixScanKeys does, without the pending writes of the tx.
+func (tx *Tx) prefixScanKeys(bucket string, prefix []byte, offsetNum int, limitNum int) (keys [][]byte, off int, err error) {
+	if tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		es, off, err := tx.prefixScanByHintBPTSparseIdx(bucket, prefix, offsetNum, limitNum)
+		for _, e := range es {
+			keys = append(keys, e.Key)
+		}
+		return keys, off, err
+	}
+
+	if idx, ok := tx.db.BPTreeIdx[bucket]; ok {
+		records, voff, err := idx.PrefixScan(prefix, offsetNum, limitNum)
+		off = voff
+		if err != nil {
+			return nil, off, ErrPrefixScan
+		}
+
+		for _, r := range records {
+			if r.H.Meta.Flag == DataDeleteFlag || tx.db.isExpired(bucket, r.H.Meta) {
+				continue
+			}
+			if limitNum > 0 && len(keys) >= limitNum {
+				break
+			}
+			keys = append(keys, r.H.Key)
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, off, ErrPrefixScan
+	}
+
+	return
+}
+
 // PrefixSearchScan iterates over a key prefix at given bucket, prefix, match regular expression and limitNum.
 // LimitNum will limit the number of entries return.
+// With pending writes in the bucket, the offset skips the entries merged with them rather than the records of the index.
 func (tx *Tx) PrefixSearchScan(bucket string, prefix []byte, reg string, offsetNum int, limitNum int) (es Entries, off int, err error) {
 
 	if err := tx.checkTxIsClosed(); err != nil {
 		return nil, off, err
 	}
 
+	pb, ok := tx.pendingKeys[bucket]
+	if !ok {
+		return tx.prefixSearchScan(bucket, prefix, reg, offsetNum, limitNum)
+	}
+	rgx, err := regexp.Compile(reg)
+	if err != nil {
+		return nil, 0, ErrBadRegexp
+	}
+	es, _, err = tx.prefixSearchScan(bucket, prefix, reg, 0, pb.scanLimit(offsetNum, limitNum))
+	es, err = tx.scanPending(bucket, es, err, ErrPrefixSearchScan, func(key []byte) bool {
+		return bytes.HasPrefix(key, prefix) && rgx.Match(bytes.TrimPrefix(key, prefix))
+	}, false)
+	return pagePending(es, err, offsetNum, limitNum, ErrPrefixSearchScan)
+}
+
+// prefixSearchScan iterates over a key prefix as PrefixSearchScan does, without the pending writes of the tx.
+func (tx *Tx) prefixSearchScan(bucket string, prefix []byte, reg string, offsetNum int, limitNum int) (es Entries, off int, err error) {
 	if tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode {
 		return tx.prefixSearchScanByHintBPTSparseIdx(bucket, prefix, reg, offsetNum, limitNum)
 	}
@@ -851,7 +1052,7 @@ func (tx *Tx) PrefixSearchScan(bucket string, prefix []byte, reg string, offsetN
 			return nil, off, ErrPrefixSearchScan
 		}
 
-		es, err = tx.getHintIdxDataItemsWrapper(records, limitNum, es, PrefixSearchScan)
+		es, err = tx.getHintIdxDataItemsWrapper(bucket, records, limitNum, es, PrefixSearchScan)
 		if err != nil {
 			off = voff
 			return nil, off, ErrPrefixSearchScan
@@ -895,7 +1096,7 @@ func (tx *Tx) Delete(bucket string, key []byte) error {
 				return ErrNotFoundKey
 			}
 
-			if r.H.Meta.Flag == DataDeleteFlag || r.IsExpired() {
+			if r.H.Meta.Flag == DataDeleteFlag || tx.db.isExpired(bucket, r.H.Meta) {
 				return ErrNotFoundKey
 			}
 		} else {
@@ -903,13 +1104,13 @@ func (tx *Tx) Delete(bucket string, key []byte) error {
 		}
 	}
 
-	return tx.put(bucket, key, nil, Persistent, DataDeleteFlag, uint64(time.Now().Unix()), DataStructureBPTree)
+	return tx.put(bucket, key, nil, Persistent, DataDeleteFlag, tx.db.timestamp(), DataStructureBPTree)
 }
 
 // getHintIdxDataItemsWrapper returns wrapped entries when prefix scanning or range scanning.
-func (tx *Tx) getHintIdxDataItemsWrapper(records Records, limitNum int, es Entries, scanMode string) (Entries, error) {
+func (tx *Tx) getHintIdxDataItemsWrapper(bucket string, records Records, limitNum int, es Entries, scanMode string) (Entries, error) {
 	for _, r := range records {
-		if r.H.Meta.Flag == DataDeleteFlag || r.IsExpired() {
+		if r.H.Meta.Flag == DataDeleteFlag || tx.db.isExpired(bucket, r.H.Meta) {
 			continue
 		}
 
@@ -922,7 +1123,14 @@ func (tx *Tx) getHintIdxDataItemsWrapper(records Records, limitNum int, es Entri
 					return nil, err
 				}
 				payloadSize := r.H.Meta.PayloadSize()
-				if item, err := df.ReadRecord(int(r.H.DataPos), payloadSize); err == nil {
+				item, err := df.ReadRecord(int(r.H.DataPos), payloadSize)
+				if err != nil {
+					item, err = tx.db.readRepair(tx.ctx, bucket, r.H, err)
+				}
+				if err == nil {
+					item, err = tx.resolveValue(bucket, item)
+				}
+				if err == nil {
 					es = append(es, item)
 				} else {
 					releaseErr := df.rwManager.Release()
@@ -938,7 +1146,11 @@ func (tx *Tx) getHintIdxDataItemsWrapper(records Records, limitNum int, es Entri
 			}
 
 			if idxMode == HintKeyValAndRAMIdxMode {
-				es = append(es, r.E)
+				e, err := tx.resolveValue(bucket, r.E)
+				if err != nil {
+					return nil, err
+				}
+				es = append(es, e)
 			}
 		}
 	}
@@ -15,6 +15,7 @@
 package nutsdb
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -267,6 +268,77 @@ func TestTx_RangeScan(t *testing.T) {
 
 }
 
+func TestTx_RangeScanReverse(t *testing.T) {
+	bucket := "bucket_for_range_reverse"
+
+	for _, with := range []func(t *testing.T, fn func(t *testing.T, db *DB)){withDefaultDB, withBPTSpareeIdxDB} {
+		with(t, func(t *testing.T, db *DB) {
+			require.NoError(t, db.Update(func(tx *Tx) error {
+				for i := 0; i < 10; i++ {
+					if err := tx.Put(bucket, []byte(fmt.Sprintf("key_%07d", i)), []byte(fmt.Sprintf("val_%d", i)), Persistent); err != nil {
+						return err
+					}
+				}
+				return nil
+			}))
+
+			require.NoError(t, db.View(func(tx *Tx) error {
+				entries, err := tx.RangeScanReverse(bucket, []byte("key_0000005"), []byte("key_0000008"))
+				require.NoError(t, err)
+				require.Len(t, entries, 4)
+				for i, e := range entries {
+					assert.Equal(t, []byte(fmt.Sprintf("key_%07d", 8-i)), e.Key)
+					assert.Equal(t, []byte(fmt.Sprintf("val_%d", 8-i)), e.Value)
+				}
+
+				_, err = tx.RangeScanReverse(bucket, []byte("key_0010001"), []byte("key_0010010"))
+				assert.Error(t, err)
+				return nil
+			}))
+		})
+	}
+
+	// the range spans many leaves of the index, walked backwards from end down to start.
+	withDefaultDB(t, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			for i := 0; i < 1000; i++ {
+				if err := tx.Put(bucket, []byte(fmt.Sprintf("key_%07d", i)), []byte(fmt.Sprintf("val_%d", i)), Persistent); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.Delete(bucket, []byte("key_0000500"))
+		}))
+
+		require.NoError(t, db.View(func(tx *Tx) error {
+			entries, err := tx.RangeScanReverse(bucket, []byte("key_0000100"), []byte("key_0000900"))
+			require.NoError(t, err)
+			require.Len(t, entries, 800)
+			assert.Equal(t, []byte("key_0000900"), entries[0].Key)
+			assert.Equal(t, []byte("key_0000501"), entries[399].Key)
+			assert.Equal(t, []byte("key_0000499"), entries[400].Key)
+			assert.Equal(t, []byte("key_0000100"), entries[799].Key)
+
+			entries, err = tx.RangeScanReverse(bucket, []byte("key_0000995"), []byte("key_9"))
+			require.NoError(t, err)
+			require.Len(t, entries, 5)
+			assert.Equal(t, []byte("key_0000999"), entries[0].Key)
+
+			_, err = tx.RangeScanReverse(bucket, []byte("key_0000900"), []byte("key_0000100"))
+			assert.Equal(t, ErrRangeScan, err)
+
+			// a missing bucket fails as RangeScan does.
+			_, want := tx.RangeScan("bucket_range_scan_reverse_missing", []byte("key_0000100"), []byte("key_0000900"))
+			require.Error(t, want)
+			_, err = tx.RangeScanReverse("bucket_range_scan_reverse_missing", []byte("key_0000100"), []byte("key_0000900"))
+			assert.Equal(t, want, err)
+			return nil
+		}))
+	})
+}
+
 func TestTx_PrefixScan(t *testing.T) {
 
 	bucket := "bucket_for_prefix_scan"
@@ -319,6 +391,45 @@ func TestTx_PrefixScan(t *testing.T) {
 	})
 }
 
+func TestTx_PrefixScanKeys(t *testing.T) {
+	bucket := "bucket_for_prefix_scan_keys"
+
+	for _, with := range []func(t *testing.T, fn func(t *testing.T, db *DB)){withDefaultDB, withRAMIdxDB} {
+		with(t, func(t *testing.T, db *DB) {
+			require.NoError(t, db.Update(func(tx *Tx) error {
+				for _, prefix := range []string{"key1_", "key2_"} {
+					for i := 0; i < 10; i++ {
+						if err := tx.Put(bucket, []byte(prefix+fmt.Sprintf("%07d", i)), []byte("foobar"), Persistent); err != nil {
+							return err
+						}
+					}
+				}
+				return nil
+			}))
+			require.NoError(t, db.Update(func(tx *Tx) error {
+				return tx.Delete(bucket, []byte("key1_0000000"))
+			}))
+
+			require.NoError(t, db.View(func(tx *Tx) error {
+				keys, _, err := tx.PrefixScanKeys(bucket, []byte("key1_"), 0, ScanNoLimit)
+				require.NoError(t, err)
+				require.Len(t, keys, 9)
+				for i, key := range keys {
+					assert.Equal(t, []byte("key1_"+fmt.Sprintf("%07d", i+1)), key)
+				}
+
+				keys, _, err = tx.PrefixScanKeys(bucket, []byte("key2_"), 5, 3)
+				require.NoError(t, err)
+				assert.Equal(t, [][]byte{[]byte("key2_0000005"), []byte("key2_0000006"), []byte("key2_0000007")}, keys)
+
+				_, _, err = tx.PrefixScanKeys(bucket, []byte("key3_"), 0, ScanNoLimit)
+				assert.Equal(t, ErrPrefixScan, err)
+				return nil
+			}))
+		})
+	}
+}
+
 func TestTx_PrefixSearchScan(t *testing.T) {
 	bucket := "bucket_for_prefix_search_scan"
 
@@ -816,3 +927,134 @@ func TestTx_Notfound_For_BPTSparseIdxMode(t *testing.T) {
 		}
 	})
 }
+
+func TestTx_GetReadYourWrites(t *testing.T) {
+	withDefaultDB(t, func(t *testing.T, db *DB) {
+		bucket := "bucket_get_read_your_writes"
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.Put(bucket, []byte("committed"), []byte("v1"), Persistent)
+		}))
+
+		require.Error(t, db.Update(func(tx *Tx) error {
+			require.NoError(t, tx.Put(bucket, []byte("new"), []byte("n1"), Persistent))
+			require.NoError(t, tx.Put(bucket, []byte("committed"), []byte("v2"), Persistent))
+
+			e, err := tx.Get(bucket, []byte("new"))
+			require.NoError(t, err)
+			assert.Equal(t, []byte("n1"), e.Value)
+			e, err = tx.Get(bucket, []byte("committed"))
+			require.NoError(t, err)
+			assert.Equal(t, []byte("v2"), e.Value)
+
+			require.NoError(t, tx.Delete(bucket, []byte("committed")))
+			_, err = tx.Get(bucket, []byte("committed"))
+			assert.Equal(t, ErrNotFoundKey, err)
+
+			require.NoError(t, tx.Put(bucket, []byte("committed"), []byte("v3"), Persistent))
+			e, err = tx.Get(bucket, []byte("committed"))
+			require.NoError(t, err)
+			assert.Equal(t, []byte("v3"), e.Value)
+			return errors.New("rolled back")
+		}))
+
+		require.NoError(t, db.View(func(tx *Tx) error {
+			e, err := tx.Get(bucket, []byte("committed"))
+			require.NoError(t, err)
+			assert.Equal(t, []byte("v1"), e.Value)
+			_, err = tx.Get(bucket, []byte("new"))
+			assert.Equal(t, ErrKeyNotFound, err)
+			return nil
+		}))
+
+		// the keys of a bucket deleted by the tx are not found, unless written after.
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			require.NoError(t, tx.DeleteBucket(DataStructureBPTree, bucket))
+			_, err := tx.Get(bucket, []byte("committed"))
+			assert.Equal(t, ErrNotFoundBucket, err)
+
+			require.NoError(t, tx.Put(bucket, []byte("new"), []byte("n2"), Persistent))
+			e, err := tx.Get(bucket, []byte("new"))
+			require.NoError(t, err)
+			assert.Equal(t, []byte("n2"), e.Value)
+			return nil
+		}))
+	})
+}
+
+func TestTx_ScanReadYourWrites(t *testing.T) {
+	withDefaultDB(t, func(t *testing.T, db *DB) {
+		bucket := "bucket_scan_read_your_writes"
+		keys := func(es Entries) []string {
+			res := make([]string, len(es))
+			for i, e := range es {
+				res[i] = string(e.Key)
+			}
+			return res
+		}
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			for _, key := range []string{"k1", "k3", "k5", "k7"} {
+				require.NoError(t, tx.Put(bucket, []byte(key), []byte("v"+key), Persistent))
+			}
+			return nil
+		}))
+
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			require.NoError(t, tx.Put(bucket, []byte("k2"), []byte("vk2"), Persistent))
+			require.NoError(t, tx.Put(bucket, []byte("k5"), []byte("vk5'"), Persistent))
+			require.NoError(t, tx.Put(bucket, []byte("k8"), []byte("vk8"), Persistent))
+			require.NoError(t, tx.Delete(bucket, []byte("k3")))
+
+			es, err := tx.GetAll(bucket)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"k1", "k2", "k5", "k7", "k8"}, keys(es))
+			assert.Equal(t, []byte("vk5'"), es[2].Value)
+
+			es, err = tx.RangeScan(bucket, []byte("k2"), []byte("k7"))
+			require.NoError(t, err)
+			assert.Equal(t, []string{"k2", "k5", "k7"}, keys(es))
+			es, err = tx.RangeScanReverse(bucket, []byte("k2"), []byte("k8"))
+			require.NoError(t, err)
+			assert.Equal(t, []string{"k8", "k7", "k5", "k2"}, keys(es))
+
+			es, off, err := tx.PrefixScan(bucket, []byte("k"), 1, 2)
+			require.NoError(t, err)
+			assert.Equal(t, 1, off)
+			assert.Equal(t, []string{"k2", "k5"}, keys(es))
+			es, _, err = tx.PrefixSearchScan(bucket, []byte("k"), "[2-5]", 0, ScanNoLimit)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"k2", "k5"}, keys(es))
+			ks, _, err := tx.PrefixScanKeys(bucket, []byte("k"), 0, ScanNoLimit)
+			require.NoError(t, err)
+			assert.Equal(t, [][]byte{[]byte("k1"), []byte("k2"), []byte("k5"), []byte("k7"), []byte("k8")}, ks)
+
+			var iterated []string
+			it := tx.NewIterator(bucket, IteratorOptions{Reverse: true})
+			for ; it.Valid(); it.Next() {
+				iterated = append(iterated, string(it.Item().Key))
+			}
+			require.NoError(t, it.Err())
+			assert.Equal(t, []string{"k8", "k7", "k5", "k2", "k1"}, iterated)
+
+			// a scan of a range holding pending writes only.
+			es, err = tx.RangeScan(bucket, []byte("k8"), []byte("k9"))
+			require.NoError(t, err)
+			assert.Equal(t, []string{"k8"}, keys(es))
+			_, err = tx.RangeScan(bucket, []byte("k3"), []byte("k4"))
+			assert.Equal(t, ErrRangeScan, err)
+			return nil
+		}))
+
+		// the scans of a bucket deleted by the tx see only the keys written after.
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			require.NoError(t, tx.DeleteBucket(DataStructureBPTree, bucket))
+			_, err := tx.GetAll(bucket)
+			assert.Equal(t, ErrBucketEmpty, err)
+
+			require.NoError(t, tx.Put(bucket, []byte("k4"), []byte("vk4"), Persistent))
+			es, err := tx.GetAll(bucket)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"k4"}, keys(es))
+			return nil
+		}))
+	})
+}
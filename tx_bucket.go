@@ -14,8 +14,6 @@
 
 package nutsdb
 
-import "time"
-
 // IterateBuckets iterate over all the bucket depends on ds (represents the data structure)
 func (tx *Tx) IterateBuckets(ds uint16, pattern string, f func(key string) bool) error {
 	if err := tx.checkTxIsClosed(); err != nil {
@@ -52,6 +50,9 @@ func (tx *Tx) IterateBuckets(ds uint16, pattern string, f func(key string) bool)
 	}
 	if ds == DataStructureBPTree {
 		for bucket := range tx.db.BPTreeIdx {
+			if isInternalBucket(bucket) {
+				continue
+			}
 			if end, err := MatchForRange(pattern, bucket, f); end || err != nil {
 				return err
 			}
@@ -69,16 +70,16 @@ func (tx *Tx) DeleteBucket(ds uint16, bucket string) error {
 		return ErrNotSupportHintBPTSparseIdxMode
 	}
 	if ds == DataStructureSet {
-		return tx.put(bucket, []byte("0"), nil, Persistent, DataSetBucketDeleteFlag, uint64(time.Now().Unix()), DataStructureNone)
+		return tx.put(bucket, []byte("0"), nil, Persistent, DataSetBucketDeleteFlag, tx.db.timestamp(), DataStructureNone)
 	}
 	if ds == DataStructureSortedSet {
-		return tx.put(bucket, []byte("1"), nil, Persistent, DataSortedSetBucketDeleteFlag, uint64(time.Now().Unix()), DataStructureNone)
+		return tx.put(bucket, []byte("1"), nil, Persistent, DataSortedSetBucketDeleteFlag, tx.db.timestamp(), DataStructureNone)
 	}
 	if ds == DataStructureBPTree {
-		return tx.put(bucket, []byte("2"), nil, Persistent, DataBPTreeBucketDeleteFlag, uint64(time.Now().Unix()), DataStructureNone)
+		return tx.put(bucket, []byte("2"), nil, Persistent, DataBPTreeBucketDeleteFlag, tx.db.timestamp(), DataStructureNone)
 	}
 	if ds == DataStructureList {
-		return tx.put(bucket, []byte("3"), nil, Persistent, DataListBucketDeleteFlag, uint64(time.Now().Unix()), DataStructureNone)
+		return tx.put(bucket, []byte("3"), nil, Persistent, DataListBucketDeleteFlag, tx.db.timestamp(), DataStructureNone)
 	}
 	return nil
 }
@@ -0,0 +1,178 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+// ErrNotRetained is returned by Release when the content is not retained.
+var ErrNotRetained = errors.New("content not retained")
+
+const refCountBucketKind = "refcount"
+
+// contentHash returns the hash of the value used as its key by PutContent.
+func (db *DB) contentHash(value []byte) []byte {
+	newHash := db.opt.ContentHash
+	if newHash == nil {
+		newHash = sha256.New
+	}
+
+	h := newHash()
+	_, _ = h.Write(value)
+	return h.Sum(nil)
+}
+
+// PutContent stores the value in the bucket under its hash and returns the hash.
+// The value is not written again if the bucket holds it already, so that identical values are stored once.
+// The hash is computed by Options.ContentHash, SHA-256 by default.
+func (tx *Tx) PutContent(bucket string, value []byte) ([]byte, error) {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return nil, err
+	}
+
+	key := tx.db.contentHash(value)
+
+	exists, err := tx.pendingKeyExists(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return key, nil
+	}
+
+	if err := tx.put(bucket, key, value, Persistent, DataSetFlag, tx.db.timestamp(), DataStructureBPTree); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// GetContent retrieves the value stored by PutContent under the hash in the bucket.
+// The returned value is only valid for the life of the transaction.
+func (tx *Tx) GetContent(bucket string, hash []byte) ([]byte, error) {
+	e, err := tx.Get(bucket, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.Value, nil
+}
+
+// Retain increments the reference count of the content stored under the hash in the bucket and returns it.
+// Once the content was retained, Merge removes it as soon as its reference count drops back to zero.
+// The content never retained is kept until it is deleted.
+func (tx *Tx) Retain(bucket string, hash []byte) (uint64, error) {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return 0, err
+	}
+
+	exists, err := tx.pendingKeyExists(bucket, hash)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, ErrNotFoundKey
+	}
+
+	count, err := tx.getRefCount(bucket, hash)
+	if err != nil {
+		return 0, err
+	}
+
+	return count + 1, tx.putRefCount(bucket, hash, count+1)
+}
+
+// Release decrements the reference count of the content stored under the hash in the bucket and returns it.
+// It returns ErrNotRetained if the reference count is zero.
+func (tx *Tx) Release(bucket string, hash []byte) (uint64, error) {
+	count, err := tx.getRefCount(bucket, hash)
+	if err != nil {
+		return 0, err
+	}
+	if count == 0 {
+		return 0, ErrNotRetained
+	}
+
+	return count - 1, tx.putRefCount(bucket, hash, count-1)
+}
+
+// RefCount returns the reference count of the content stored under the hash in the bucket.
+func (tx *Tx) RefCount(bucket string, hash []byte) (uint64, error) {
+	return tx.getRefCount(bucket, hash)
+}
+
+func (tx *Tx) getRefCount(bucket string, hash []byte) (uint64, error) {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return 0, err
+	}
+
+	e, err := tx.lookup(internalBucket(refCountBucketKind, bucket), hash)
+	if err == ErrNotFoundBucket || err == ErrNotFoundKey || err == ErrKeyNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if len(e.Value) != 8 {
+		return 0, nil
+	}
+	return binary.BigEndian.Uint64(e.Value), nil
+}
+
+func (tx *Tx) putRefCount(bucket string, hash []byte, count uint64) error {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, count)
+	return tx.put(internalBucket(refCountBucketKind, bucket), hash, buf, Persistent, DataSetFlag, tx.db.timestamp(), DataStructureBPTree)
+}
+
+// collectContent is called by Merge for the entries carried over, it turns the content whose reference count
+// dropped to zero into a delete entry. The reference count is removed as well once the content is gone.
+func (tx *Tx) collectContent(e *Entry) (*Entry, error) {
+	if e.Meta.Ds != DataStructureBPTree || e.Meta.Flag != DataSetFlag {
+		return e, nil
+	}
+
+	refCountPrefix := internalBucket(refCountBucketKind, "")
+	if bucket := string(e.Bucket); strings.HasPrefix(bucket, refCountPrefix) {
+		if len(e.Value) != 8 || binary.BigEndian.Uint64(e.Value) != 0 {
+			return e, nil
+		}
+
+		contentBucket := strings.TrimPrefix(bucket, refCountPrefix)
+		exists, err := tx.pendingKeyExists(contentBucket, e.Key)
+		if err != nil || exists {
+			return e, err
+		}
+		return tx.db.mergeDeleteEntry(e), nil
+	}
+
+	r, err := tx.Get(internalBucket(refCountBucketKind, string(e.Bucket)), e.Key)
+	if err == ErrNotFoundBucket || err == ErrNotFoundKey || err == ErrKeyNotFound {
+		return e, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(r.Value) == 8 && binary.BigEndian.Uint64(r.Value) == 0 {
+		return tx.db.mergeDeleteEntry(e), nil
+	}
+
+	return e, nil
+}
@@ -0,0 +1,159 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTx_PutContent(t *testing.T) {
+	bucket := "bucket_content"
+	blob := []byte("blob")
+
+	t.Run("deduplicates identical values", func(t *testing.T) {
+		InitOpt("/tmp/nutsdbtestputcontent", true)
+		db, err = Open(opt, WithImmutableBucket(bucket))
+		require.NoError(t, err)
+
+		sum := sha256.Sum256(blob)
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			for i := 0; i < 2; i++ {
+				hash, err := tx.PutContent(bucket, blob)
+				require.NoError(t, err)
+				assert.Equal(t, sum[:], hash)
+			}
+			assert.Len(t, tx.pendingWrites, 1)
+			return nil
+		}))
+
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			hash, err := tx.PutContent(bucket, blob)
+			require.NoError(t, err)
+			assert.Equal(t, sum[:], hash)
+			assert.Empty(t, tx.pendingWrites)
+			return nil
+		}))
+
+		require.NoError(t, db.View(func(tx *Tx) error {
+			value, err := tx.GetContent(bucket, sum[:])
+			assert.NoError(t, err)
+			assert.Equal(t, blob, value)
+
+			_, err = tx.GetContent(bucket, []byte("unknown"))
+			assert.Error(t, err)
+			return nil
+		}))
+		require.NoError(t, db.Close())
+	})
+
+	t.Run("uses the configured hash", func(t *testing.T) {
+		InitOpt("/tmp/nutsdbtestputcontent", true)
+		db, err = Open(opt, WithContentHash(md5.New))
+		require.NoError(t, err)
+
+		sum := md5.Sum(blob)
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			hash, err := tx.PutContent(bucket, blob)
+			assert.Equal(t, sum[:], hash)
+			return err
+		}))
+		require.NoError(t, db.Close())
+	})
+}
+
+func TestTx_RetainRelease(t *testing.T) {
+	bucket := "bucket_content"
+
+	InitOpt("/tmp/nutsdbtestretainrelease", true)
+	opt.SegmentSize = 256
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	hashes := make(map[string][]byte)
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		for _, blob := range []string{"kept", "released", "untracked"} {
+			hash, err := tx.PutContent(bucket, []byte(blob))
+			require.NoError(t, err)
+			hashes[blob] = hash
+		}
+		_, err := tx.Retain(bucket, []byte("unknown"))
+		assert.Equal(t, ErrNotFoundKey, err)
+		return nil
+	}))
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		for _, blob := range []string{"kept", "released"} {
+			count, err := tx.Retain(bucket, hashes[blob])
+			require.NoError(t, err)
+			assert.Equal(t, uint64(1), count)
+		}
+		count, err := tx.Retain(bucket, hashes["kept"])
+		assert.Equal(t, uint64(2), count)
+		return err
+	}))
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		count, err := tx.Release(bucket, hashes["released"])
+		require.NoError(t, err)
+		assert.Equal(t, uint64(0), count)
+		_, err = tx.Release(bucket, hashes["released"])
+		assert.Equal(t, ErrNotRetained, err)
+		_, err = tx.Release(bucket, hashes["untracked"])
+		assert.Equal(t, ErrNotRetained, err)
+		return nil
+	}))
+
+	// moves the content out of the active file.
+	for i := 0; i < 5; i++ {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.Put("bucket", []byte("key"), []byte("value"), Persistent)
+		}))
+	}
+
+	require.NoError(t, db.Merge())
+
+	check := func() {
+		require.NoError(t, db.View(func(tx *Tx) error {
+			for _, blob := range []string{"kept", "untracked"} {
+				value, err := tx.GetContent(bucket, hashes[blob])
+				if assert.NoError(t, err) {
+					assert.Equal(t, []byte(blob), value)
+				}
+			}
+			_, err := tx.GetContent(bucket, hashes["released"])
+			assert.Error(t, err)
+
+			count, err := tx.RefCount(bucket, hashes["kept"])
+			assert.NoError(t, err)
+			assert.Equal(t, uint64(2), count)
+
+			_, err = tx.Get(internalBucket(refCountBucketKind, bucket), hashes["released"])
+			assert.Error(t, err)
+			return nil
+		}))
+	}
+
+	check()
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	check()
+	require.NoError(t, db.Close())
+}
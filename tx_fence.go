@@ -0,0 +1,86 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// ErrStaleFence is returned by PutIfFence when the token is not the current fencing token of the key.
+var ErrStaleFence = errors.New("stale fencing token")
+
+const fenceBucketKind = "fence"
+
+// PutWithFence sets the value for a key in the bucket and returns the new fencing token of the key.
+// The tokens of a key increase monotonically, even across deletes of the key, so that a lease holder
+// can pass its token to PutIfFence and get rejected once a newer holder took the lease over.
+func (tx *Tx) PutWithFence(bucket string, key, value []byte, ttl uint32) (token uint64, err error) {
+	token, err = tx.getFence(bucket, key)
+	if err != nil {
+		return 0, err
+	}
+	token++
+
+	if err := tx.Put(bucket, key, value, ttl); err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, token)
+	if err := tx.put(internalBucket(fenceBucketKind, bucket), key, buf, Persistent, DataSetFlag, tx.db.timestamp(), DataStructureBPTree); err != nil {
+		return 0, err
+	}
+
+	return token, nil
+}
+
+// PutIfFence sets the value and the ttl for a key in the bucket only if the token is the current fencing token
+// of the key, it returns ErrStaleFence otherwise.
+func (tx *Tx) PutIfFence(bucket string, key, value []byte, ttl uint32, token uint64) error {
+	current, err := tx.getFence(bucket, key)
+	if err != nil {
+		return err
+	}
+	if current != token {
+		return ErrStaleFence
+	}
+
+	return tx.Put(bucket, key, value, ttl)
+}
+
+// GetFence returns the current fencing token of the key in the bucket, 0 if none was issued.
+func (tx *Tx) GetFence(bucket string, key []byte) (uint64, error) {
+	return tx.getFence(bucket, key)
+}
+
+func (tx *Tx) getFence(bucket string, key []byte) (uint64, error) {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return 0, err
+	}
+
+	e, err := tx.lookup(internalBucket(fenceBucketKind, bucket), key)
+	if err == ErrNotFoundBucket || err == ErrNotFoundKey || err == ErrKeyNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if len(e.Value) != 8 {
+		return 0, nil
+	}
+	return binary.BigEndian.Uint64(e.Value), nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTx_PutWithFence(t *testing.T) {
+	withDefaultDB(t, func(t *testing.T, db *DB) {
+		bucket := "bucket_fence"
+		key := []byte("lease")
+
+		var first, second uint64
+		err := db.Update(func(tx *Tx) error {
+			var err error
+			first, err = tx.PutWithFence(bucket, key, []byte("owner_1"), 10)
+			return err
+		})
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1), first)
+
+		err = db.Update(func(tx *Tx) error {
+			if err := tx.Delete(bucket, key); err != nil {
+				return err
+			}
+			var err error
+			second, err = tx.PutWithFence(bucket, key, []byte("owner_2"), 10)
+			return err
+		})
+		require.NoError(t, err)
+		assert.Equal(t, uint64(2), second)
+
+		err = db.Update(func(tx *Tx) error {
+			return tx.PutIfFence(bucket, key, []byte("stale"), 10, first)
+		})
+		assert.Equal(t, ErrStaleFence, err)
+
+		err = db.Update(func(tx *Tx) error {
+			return tx.PutIfFence(bucket, key, []byte("fresh"), 10, second)
+		})
+		assert.NoError(t, err)
+
+		err = db.View(func(tx *Tx) error {
+			e, err := tx.Get(bucket, key)
+			if assert.NoError(t, err) {
+				assert.Equal(t, []byte("fresh"), e.Value)
+				assert.Equal(t, uint32(10), e.Meta.TTL)
+			}
+			token, err := tx.GetFence(bucket, key)
+			assert.NoError(t, err)
+			assert.Equal(t, second, token)
+
+			token, err = tx.GetFence(bucket, []byte("none"))
+			assert.NoError(t, err)
+			assert.Equal(t, uint64(0), token)
+
+			return tx.IterateBuckets(DataStructureBPTree, "*", func(b string) bool {
+				assert.Equal(t, bucket, b)
+				return true
+			})
+		})
+		assert.NoError(t, err)
+	})
+}
+
+func TestTx_PutWithFence_SameTx(t *testing.T) {
+	withDefaultDB(t, func(t *testing.T, db *DB) {
+		err := db.Update(func(tx *Tx) error {
+			for i := uint64(1); i <= 3; i++ {
+				token, err := tx.PutWithFence("bucket_fence", []byte("lease"), []byte("value"), Persistent)
+				assert.NoError(t, err)
+				assert.Equal(t, i, token)
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+	})
+}
@@ -0,0 +1,227 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ErrJSONFieldNotFound is returned when the path does not match any field of the JSON value.
+var ErrJSONFieldNotFound = errors.New("json field not found")
+
+// JSONPathSeparator separates the segments of the paths passed to GetJSONField.
+const JSONPathSeparator = "."
+
+// GetJSONField retrieves the JSON value for a key in the bucket and returns the raw JSON of the field at the path.
+// The path is made of object keys and array indexes joined by JSONPathSeparator, e.g. "user.emails.0",
+// an empty path returns the whole value.
+// Only the levels along the path are parsed, the other fields are skipped without being unmarshalled.
+func (tx *Tx) GetJSONField(bucket string, key []byte, path string) ([]byte, error) {
+	fields, err := tx.GetJSONFields(bucket, key, path)
+	if err != nil {
+		return nil, err
+	}
+	if fields[0] == nil {
+		return nil, ErrJSONFieldNotFound
+	}
+
+	return fields[0], nil
+}
+
+// GetJSONFields is like GetJSONField for several paths at once, the value is read a single time.
+// The fields are returned in the order of the paths, the field of a path not found is nil.
+func (tx *Tx) GetJSONFields(bucket string, key []byte, paths ...string) ([][]byte, error) {
+	e, err := tx.Get(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make([][]byte, len(paths))
+	for i, path := range paths {
+		field, err := jsonField(e.Value, path)
+		if err == ErrJSONFieldNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		fields[i] = field
+	}
+
+	return fields, nil
+}
+
+// jsonField walks the value down the path, unmarshalling a single level at a time.
+func jsonField(value []byte, path string) ([]byte, error) {
+	field := json.RawMessage(value)
+	if path == "" {
+		if err := json.Unmarshal(field, new(json.RawMessage)); err != nil {
+			return nil, err
+		}
+		return field, nil
+	}
+
+	for _, segment := range strings.Split(path, JSONPathSeparator) {
+		trimmed := bytes.TrimLeft(field, " \t\r\n")
+		if len(trimmed) == 0 {
+			return nil, ErrJSONFieldNotFound
+		}
+
+		switch trimmed[0] {
+		case '{':
+			var obj map[string]json.RawMessage
+			if err := json.Unmarshal(field, &obj); err != nil {
+				return nil, err
+			}
+			next, ok := obj[segment]
+			if !ok {
+				return nil, ErrJSONFieldNotFound
+			}
+			field = next
+		case '[':
+			index, err := strconv.Atoi(segment)
+			if err != nil {
+				return nil, ErrJSONFieldNotFound
+			}
+			var arr []json.RawMessage
+			if err := json.Unmarshal(field, &arr); err != nil {
+				return nil, err
+			}
+			if index < 0 || index >= len(arr) {
+				return nil, ErrJSONFieldNotFound
+			}
+			field = arr[index]
+		default:
+			if err := json.Unmarshal(field, new(json.RawMessage)); err != nil {
+				return nil, err
+			}
+			return nil, ErrJSONFieldNotFound
+		}
+	}
+
+	return field, nil
+}
+
+// SetJSONField sets the field at the path of the JSON value for a key in the bucket to the given raw JSON.
+// The value is read, modified and written back as a regular put of the whole document within the tx,
+// so that the recovery replays it like any other write and the updates to the same document serialize.
+// The updates made earlier in the tx are seen. The ttl and the timestamp of the document are kept.
+// The last segment of the path may name a new object key or the index right after the end of an array,
+// the objects along the path are re-encoded with their keys sorted.
+// An empty path replaces the whole value, it is the only way to create the document.
+func (tx *Tx) SetJSONField(bucket string, key []byte, path string, value []byte) error {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return err
+	}
+
+	if !tx.writable {
+		return ErrTxNotWritable
+	}
+
+	if err := json.Unmarshal(value, new(json.RawMessage)); err != nil {
+		return err
+	}
+
+	ttl, timestamp := uint32(Persistent), tx.db.timestamp()
+	doc, meta, err := tx.getJSONDocument(bucket, key)
+	if err != nil && (err != ErrKeyNotFound || path != "") {
+		return err
+	}
+	if meta != nil {
+		ttl, timestamp = meta.TTL, meta.Timestamp
+	}
+
+	if path != "" {
+		if value, err = setJSONField(doc, strings.Split(path, JSONPathSeparator), value); err != nil {
+			return err
+		}
+	}
+
+	return tx.put(bucket, key, value, ttl, DataSetFlag, timestamp, DataStructureBPTree)
+}
+
+// getJSONDocument returns the value for a key in the bucket, which Get reads as written last by the tx if it did.
+func (tx *Tx) getJSONDocument(bucket string, key []byte) ([]byte, *MetaData, error) {
+	e, err := tx.Get(bucket, key)
+	if err != nil {
+		if err == ErrNotFoundKey || err == ErrNotFoundBucket {
+			err = ErrKeyNotFound
+		}
+		return nil, nil, err
+	}
+
+	return e.Value, e.Meta, nil
+}
+
+// setJSONField returns the document with the field at the path set to the value.
+func setJSONField(doc []byte, segments []string, value []byte) ([]byte, error) {
+	if len(segments) == 0 {
+		return value, nil
+	}
+
+	segment, last := segments[0], len(segments) == 1
+	trimmed := bytes.TrimLeft(doc, " \t\r\n")
+	if len(trimmed) == 0 {
+		return nil, ErrJSONFieldNotFound
+	}
+
+	switch trimmed[0] {
+	case '{':
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(doc, &obj); err != nil {
+			return nil, err
+		}
+		child, ok := obj[segment]
+		if !ok && !last {
+			return nil, ErrJSONFieldNotFound
+		}
+		child, err := setJSONField(child, segments[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		obj[segment] = child
+		return json.Marshal(obj)
+	case '[':
+		index, err := strconv.Atoi(segment)
+		if err != nil {
+			return nil, ErrJSONFieldNotFound
+		}
+		var arr []json.RawMessage
+		if err := json.Unmarshal(doc, &arr); err != nil {
+			return nil, err
+		}
+		if last && index == len(arr) {
+			arr = append(arr, nil)
+		}
+		if index < 0 || index >= len(arr) {
+			return nil, ErrJSONFieldNotFound
+		}
+		child, err := setJSONField(arr[index], segments[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		arr[index] = child
+		return json.Marshal(arr)
+	default:
+		if err := json.Unmarshal(doc, new(json.RawMessage)); err != nil {
+			return nil, err
+		}
+		return nil, ErrJSONFieldNotFound
+	}
+}
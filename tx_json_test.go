@@ -0,0 +1,109 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTx_GetJSONField(t *testing.T) {
+	withDefaultDB(t, func(t *testing.T, db *DB) {
+		bucket := "bucket_json"
+		doc := `{"name": "nuts", "user": {"age": 3, "emails": ["a@nuts.db", "b@nuts.db"]}, "tags": null}`
+
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			if err := tx.Put(bucket, []byte("doc"), []byte(doc), Persistent); err != nil {
+				return err
+			}
+			return tx.Put(bucket, []byte("invalid"), []byte(`{"name": `), Persistent)
+		}))
+
+		require.NoError(t, db.View(func(tx *Tx) error {
+			field, err := tx.GetJSONField(bucket, []byte("doc"), "name")
+			assert.NoError(t, err)
+			assert.Equal(t, `"nuts"`, string(field))
+
+			field, err = tx.GetJSONField(bucket, []byte("doc"), "user.emails.1")
+			assert.NoError(t, err)
+			assert.Equal(t, `"b@nuts.db"`, string(field))
+
+			field, err = tx.GetJSONField(bucket, []byte("doc"), "")
+			assert.NoError(t, err)
+			assert.Equal(t, doc, string(field))
+
+			for _, path := range []string{"missing", "user.emails.2", "user.emails.x", "name.first", "tags.0"} {
+				_, err = tx.GetJSONField(bucket, []byte("doc"), path)
+				assert.Equal(t, ErrJSONFieldNotFound, err, path)
+			}
+
+			fields, err := tx.GetJSONFields(bucket, []byte("doc"), "user.age", "missing", "user.emails")
+			assert.NoError(t, err)
+			assert.Equal(t, [][]byte{[]byte("3"), nil, []byte(`["a@nuts.db", "b@nuts.db"]`)}, fields)
+
+			_, err = tx.GetJSONField(bucket, []byte("invalid"), "name")
+			assert.Error(t, err)
+			assert.NotEqual(t, ErrJSONFieldNotFound, err)
+
+			_, err = tx.GetJSONField(bucket, []byte("none"), "name")
+			assert.Error(t, err)
+			return nil
+		}))
+	})
+}
+
+func TestTx_SetJSONField(t *testing.T) {
+	withDefaultDB(t, func(t *testing.T, db *DB) {
+		bucket := "bucket_json"
+		key := []byte("doc")
+
+		require.Equal(t, ErrKeyNotFound, db.Update(func(tx *Tx) error {
+			return tx.SetJSONField(bucket, key, "name", []byte(`"nuts"`))
+		}))
+
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			if err := tx.SetJSONField(bucket, key, "", []byte(`{"user": {"emails": ["a@nuts.db"]}}`)); err != nil {
+				return err
+			}
+			// the updates of the tx are seen by the next ones.
+			if err := tx.SetJSONField(bucket, key, "name", []byte(`"nuts"`)); err != nil {
+				return err
+			}
+			return tx.SetJSONField(bucket, key, "user.emails.1", []byte(`"b@nuts.db"`))
+		}))
+
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			assert.Equal(t, ErrJSONFieldNotFound, tx.SetJSONField(bucket, key, "user.emails.3", []byte(`1`)))
+			assert.Equal(t, ErrJSONFieldNotFound, tx.SetJSONField(bucket, key, "missing.field", []byte(`1`)))
+			assert.Equal(t, ErrJSONFieldNotFound, tx.SetJSONField(bucket, key, "name.first", []byte(`1`)))
+			assert.Error(t, tx.SetJSONField(bucket, key, "name", []byte(`{`)))
+			return tx.SetJSONField(bucket, key, "user.emails.0", []byte(`"c@nuts.db"`))
+		}))
+
+		require.NoError(t, db.View(func(tx *Tx) error {
+			e, err := tx.Get(bucket, key)
+			assert.NoError(t, err)
+			assert.JSONEq(t, `{"name": "nuts", "user": {"emails": ["c@nuts.db", "b@nuts.db"]}}`, string(e.Value))
+			return nil
+		}))
+
+		require.NoError(t, db.View(func(tx *Tx) error {
+			assert.Equal(t, ErrTxNotWritable, tx.SetJSONField(bucket, key, "name", []byte(`"nuts"`)))
+			return nil
+		}))
+	})
+}
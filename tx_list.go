@@ -18,7 +18,6 @@ import (
 	"bytes"
 	"sort"
 	"strings"
-	"time"
 
 	"github.com/nutsdb/nutsdb/ds/list"
 	"github.com/pkg/errors"
@@ -28,6 +27,13 @@ import (
 var (
 	// ErrSeparatorForListKey returns when list key contains the SeparatorForListKey.
 	ErrSeparatorForListKey = errors.Errorf("contain separator (%s) for List key", SeparatorForListKey)
+
+	// ErrListItemsCorrupted is returned when the snapshot of a list cannot be decoded.
+	ErrListItemsCorrupted = errors.New("list snapshot corrupted")
+
+	// ErrCompactPending is returned by LCompact, SCompact and ZCompact when the tx
+	// already has pending writes on the data structure to compact.
+	ErrCompactPending = errors.New("pending writes in the tx on the data structure to compact")
 )
 
 // SeparatorForListKey represents separator for listKey
@@ -35,12 +41,7 @@ const SeparatorForListKey = "|"
 
 // RPop removes and returns the last element of the list stored in the bucket at given bucket and key.
 func (tx *Tx) RPop(bucket string, key []byte) (item []byte, err error) {
-	item, err = tx.RPeek(bucket, key)
-	if err != nil {
-		return
-	}
-
-	return item, tx.push(bucket, key, DataRPopFlag, item)
+	return tx.pop(bucket, key, DataRPopRefFlag)
 }
 
 // RPeek returns the last element of the list stored in the bucket at given bucket and key.
@@ -49,24 +50,137 @@ func (tx *Tx) RPeek(bucket string, key []byte) (item []byte, err error) {
 		return nil, err
 	}
 
-	l := tx.db.Index.getList(bucket)
-	if l == nil {
-		return nil, ErrBucket
-	}
-
 	if tx.CheckExpire(bucket, key) {
 		return nil, ErrKeyNotFound
 	}
+	l, err := tx.pendingList(bucket, key)
+	if err != nil {
+		return nil, err
+	}
 
-	item, _, err = l.RPeek(string(key))
+	item, err = l.RPeek()
 
 	return
 }
 
+// pop writes the record of an LPop or an RPop given by flag and returns the popped element.
+// The record carries the size of the list the pop applies to rather than the element: the pop
+// is applied, by the commit and by the replay alike, only when the list holds that many elements,
+// and without checking the expiry of the list since the tx saw it alive. The size and the element
+// are taken from the list as the pending writes of the tx leave it, so that several pops of a tx
+// remove and return successive elements, and a list pushed by the tx can be popped by it.
+func (tx *Tx) pop(bucket string, key []byte, flag uint16) (item []byte, err error) {
+	v, size, err := tx.popList(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if flag == DataRPopRefFlag {
+		item, _ = v.RPeek()
+	} else {
+		item, _ = v.LPeek()
+	}
+	return item, tx.push(bucket, key, flag, []byte(strconv2.IntToStr(size)))
+}
+
+// popN writes the record of an LPopN or an RPopN given by flag and returns the popped elements.
+// The record carries the size of the list and the count, it applies as the record of pop does.
+func (tx *Tx) popN(bucket string, key []byte, n int, flag uint16) ([][]byte, error) {
+	if n < 1 {
+		return nil, list.ErrCount
+	}
+	v, size, err := tx.popList(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	count := n
+	if count > size {
+		count = size
+	}
+	var popped [][]byte
+	if flag == DataLPopNFlag {
+		popped, _ = v.LRange(0, count-1)
+	} else {
+		items, _ := v.LRange(size-count, size-1)
+		popped = make([][]byte, 0, count)
+		for i := count - 1; i >= 0; i-- {
+			popped = append(popped, items[i])
+		}
+	}
+
+	value := strconv2.IntToStr(size) + SeparatorForListKey + strconv2.IntToStr(n)
+	return popped, tx.push(bucket, key, flag, []byte(value))
+}
+
+// popList returns the view and the size of the list a pop applies to, as the pending writes of the tx leave it.
+func (tx *Tx) popList(bucket string, key []byte) (*listView, int, error) {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return nil, 0, err
+	}
+	l := tx.db.Index.getList(bucket)
+	if l != nil && tx.CheckExpire(bucket, key) {
+		return nil, 0, ErrKeyNotFound
+	}
+
+	v, err := tx.pendingList(bucket, key)
+	if err != nil {
+		return nil, 0, err
+	}
+	size, _ := v.Size()
+	if size == 0 {
+		if l == nil {
+			return nil, 0, ErrBucket
+		}
+		return nil, 0, list.ErrListNotFound
+	}
+	return v, size, nil
+}
+
+// applyListPopRef applies the DataLPopRefFlag, DataRPopRefFlag, DataLPopNFlag or DataRPopNFlag record of the entry to l.
+// A list which does not hold the size recorded by the pop is left as is.
+func applyListPopRef(l *list.List, entry *Entry) {
+	sizeAndCount := strings.Split(string(entry.Value), SeparatorForListKey)
+	size, err := strconv2.StrToInt(sizeAndCount[0])
+	if err != nil {
+		return
+	}
+	n := 1
+	if len(sizeAndCount) == 2 {
+		if n, err = strconv2.StrToInt(sizeAndCount[1]); err != nil {
+			return
+		}
+	}
+
+	switch entry.Meta.Flag {
+	case DataLPopRefFlag, DataLPopNFlag:
+		_, _ = l.LPopNIfSize(string(entry.Key), size, n)
+	default:
+		_, _ = l.RPopNIfSize(string(entry.Key), size, n)
+	}
+}
+
+// dropExpiredList drops the list the entry applies to if it is expired, as the tx writing the entry did
+// before writing it. The pops apply to the list the tx saw alive, and ExpireList sets the ttl of the list as is.
+func dropExpiredList(l *list.List, entry *Entry) {
+	switch entry.Meta.Flag {
+	case DataExpireListFlag, DataLPopRefFlag, DataRPopRefFlag, DataLPopNFlag, DataRPopNFlag:
+		return
+	}
+	l.IsExpire(listKeyOfEntry(entry))
+}
+
+// recordClock returns the list clock of a record written at the timestamp.
+func recordClock(timestamp uint64) func() int64 {
+	return func() int64 {
+		return int64(timestamp)
+	}
+}
+
 // push sets values for list stored in the bucket at given bucket, key, flag and values.
 func (tx *Tx) push(bucket string, key []byte, flag uint16, values ...[]byte) error {
 	for _, value := range values {
-		err := tx.put(bucket, key, value, Persistent, flag, uint64(time.Now().Unix()), DataStructureList)
+		err := tx.put(bucket, key, value, Persistent, flag, tx.db.timestamp(), DataStructureList)
 		if err != nil {
 			return err
 		}
@@ -108,12 +222,50 @@ func (tx *Tx) LPush(bucket string, key []byte, values ...[]byte) error {
 
 // LPop removes and returns the first element of the list stored in the bucket at given bucket and key.
 func (tx *Tx) LPop(bucket string, key []byte) (item []byte, err error) {
-	item, err = tx.LPeek(bucket, key)
-	if err != nil {
-		return
+	return tx.pop(bucket, key, DataLPopRefFlag)
+}
+
+// LPopN removes and returns up to n elements from the head of the list stored in the bucket at given bucket and key,
+// writing a single record for all of them.
+func (tx *Tx) LPopN(bucket string, key []byte, n int) ([][]byte, error) {
+	return tx.popN(bucket, key, n, DataLPopNFlag)
+}
+
+// RPopN removes and returns up to n elements from the tail of the list stored in the bucket at given bucket and key,
+// the last element first, writing a single record for all of them.
+func (tx *Tx) RPopN(bucket string, key []byte, n int) ([][]byte, error) {
+	return tx.popN(bucket, key, n, DataRPopNFlag)
+}
+
+// LMove pops an element from the head, when srcLeft is set, or else the tail of the list stored in the
+// bucket at given srcBucket and srcKey and pushes it onto the head, when dstLeft is set, or else the tail
+// of the list stored in the bucket at given dstBucket and dstKey, like LMOVE of Redis. It returns the moved element.
+// The pop and the push are records of the tx, so a crash loses both of them or none. The source and the
+// destination may be the same list, which rotates it.
+func (tx *Tx) LMove(srcBucket string, srcKey []byte, dstBucket string, dstKey []byte, srcLeft, dstLeft bool) ([]byte, error) {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return nil, err
+	}
+	if strings.Contains(string(dstKey), SeparatorForListKey) {
+		return nil, ErrSeparatorForListKey
+	}
+	if tx.CheckExpire(dstBucket, dstKey) {
+		return nil, ErrKeyNotFound
 	}
 
-	return item, tx.push(bucket, key, DataLPopFlag, item)
+	popFlag, pushFlag := DataRPopRefFlag, DataRPushFlag
+	if srcLeft {
+		popFlag = DataLPopRefFlag
+	}
+	if dstLeft {
+		pushFlag = DataLPushFlag
+	}
+
+	item, err := tx.pop(srcBucket, srcKey, popFlag)
+	if err != nil {
+		return nil, err
+	}
+	return item, tx.push(dstBucket, dstKey, pushFlag, item)
 }
 
 // LPeek returns the first element of the list stored in the bucket at given bucket and key.
@@ -121,14 +273,14 @@ func (tx *Tx) LPeek(bucket string, key []byte) (item []byte, err error) {
 	if err := tx.checkTxIsClosed(); err != nil {
 		return nil, err
 	}
-	l := tx.db.Index.getList(bucket)
-	if l == nil {
-		return nil, ErrBucket
-	}
 	if tx.CheckExpire(bucket, key) {
 		return nil, ErrKeyNotFound
 	}
-	item, err = l.LPeek(string(key))
+	l, err := tx.pendingList(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	item, err = l.LPeek()
 
 	return
 }
@@ -138,14 +290,14 @@ func (tx *Tx) LSize(bucket string, key []byte) (int, error) {
 	if err := tx.checkTxIsClosed(); err != nil {
 		return 0, err
 	}
-	l := tx.db.Index.getList(bucket)
-	if l == nil {
-		return 0, ErrBucket
-	}
 	if tx.CheckExpire(bucket, key) {
 		return 0, ErrKeyNotFound
 	}
-	return l.Size(string(key))
+	l, err := tx.pendingList(bucket, key)
+	if err != nil {
+		return 0, err
+	}
+	return l.Size()
 }
 
 // LRange returns the specified elements of the list stored in the bucket at given bucket,key, start and end.
@@ -154,6 +306,24 @@ func (tx *Tx) LSize(bucket string, key []byte) (int, error) {
 // Start and end can also be negative numbers indicating offsets from the end of the list,
 // where -1 is the last element of the list, -2 the penultimate element and so on.
 func (tx *Tx) LRange(bucket string, key []byte, start, end int) (list [][]byte, err error) {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return nil, err
+	}
+	if tx.CheckExpire(bucket, key) {
+		return nil, ErrKeyNotFound
+	}
+	l, err := tx.pendingList(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return l.LRange(start, end)
+}
+
+// LPos returns the indexes of the elements equal to value of the list stored in the bucket at given bucket and key.
+// The rank selects the match to start from: 1 is the first match from the head, 2 the second and so on,
+// negative ranks count the matches from the tail, -1 being the last match. The count limits the number
+// of indexes returned, 0 returns all the matches. The indexes are in the order the matches are met.
+func (tx *Tx) LPos(bucket string, key []byte, value []byte, rank, count int) ([]int, error) {
 	if err := tx.checkTxIsClosed(); err != nil {
 		return nil, err
 	}
@@ -164,7 +334,7 @@ func (tx *Tx) LRange(bucket string, key []byte, start, end int) (list [][]byte,
 	if tx.CheckExpire(bucket, key) {
 		return nil, ErrKeyNotFound
 	}
-	return l.LRange(string(key), start, end)
+	return l.LPos(string(key), value, rank, count)
 }
 
 // LRem removes the first count occurrences of elements equal to value from the list stored in the bucket at given bucket,key,count.
@@ -305,6 +475,62 @@ func (tx *Tx) LRemByIndex(bucket string, key []byte, indexes ...int) (removedNum
 	return
 }
 
+// LInsertBefore inserts value before the first element equal to pivot of the list stored in the bucket at given bucket and key.
+func (tx *Tx) LInsertBefore(bucket string, key, pivot, value []byte) error {
+	return tx.insert(bucket, key, pivot, value, DataLInsertBeforeFlag)
+}
+
+// LInsertAfter inserts value after the first element equal to pivot of the list stored in the bucket at given bucket and key.
+func (tx *Tx) LInsertAfter(bucket string, key, pivot, value []byte) error {
+	return tx.insert(bucket, key, pivot, value, DataLInsertAfterFlag)
+}
+
+// insert writes the record of an LInsertBefore or an LInsertAfter given by flag. The pivot is
+// looked up in the list as the pending writes of the tx leave it, the commit and the replay
+// look it up again in the list the record applies to.
+func (tx *Tx) insert(bucket string, key, pivot, value []byte, flag uint16) error {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return err
+	}
+	l := tx.db.Index.getList(bucket)
+	if l != nil && tx.CheckExpire(bucket, key) {
+		return ErrKeyNotFound
+	}
+
+	items := tx.pendingListItems(bucket, string(key))
+	if len(items) == 0 {
+		if l == nil {
+			return ErrBucket
+		}
+		return ErrKeyNotFound
+	}
+	found := false
+	for _, item := range items {
+		if bytes.Equal(item, pivot) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return list.ErrPivotNotFound
+	}
+
+	return tx.push(bucket, key, flag, MarshalListItems([][]byte{pivot, value}))
+}
+
+// applyListInsert applies the DataLInsertBeforeFlag or DataLInsertAfterFlag record of the entry to l.
+func applyListInsert(l *list.List, entry *Entry) error {
+	pivotAndValue, err := UnmarshalListItems(entry.Value)
+	if err != nil {
+		return err
+	}
+	if len(pivotAndValue) != 2 {
+		return ErrListItemsCorrupted
+	}
+	_, err = l.LInsert(string(entry.Key), pivotAndValue[0], pivotAndValue[1], entry.Meta.Flag == DataLInsertBeforeFlag)
+	return err
+}
+
 // LKeys find all keys matching a given pattern
 func (tx *Tx) LKeys(bucket, pattern string, f func(key string) bool) error {
 	if err := tx.checkTxIsClosed(); err != nil {
@@ -325,6 +551,52 @@ func (tx *Tx) LKeys(bucket, pattern string, f func(key string) bool) error {
 	return nil
 }
 
+// LCompact replaces the records of the list stored in the bucket at given bucket and key by
+// a single snapshot of its current content, so that the next merge reclaims the replay records
+// and the recovery does not have to replay them. It must be called before any other write
+// to the list in the tx.
+func (tx *Tx) LCompact(bucket string, key []byte) error {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return err
+	}
+	l := tx.db.Index.getList(bucket)
+	if l == nil {
+		return ErrBucket
+	}
+	if tx.CheckExpire(bucket, key) {
+		return ErrKeyNotFound
+	}
+	for _, e := range tx.pendingWrites {
+		if e.Meta.Ds == DataStructureList && string(e.Bucket) == bucket && listKeyOfEntry(e) == string(key) {
+			return ErrCompactPending
+		}
+	}
+
+	items, err := l.LRange(string(key), 0, -1)
+	if err != nil {
+		return err
+	}
+
+	return tx.push(bucket, key, DataLCompactFlag, MarshalListItems(items))
+}
+
+// listKeyOfEntry returns the key of the list the entry belongs to.
+func listKeyOfEntry(e *Entry) string {
+	if e.Meta.Flag == DataLSetFlag || e.Meta.Flag == DataLTrimFlag {
+		return strings.Split(string(e.Key), SeparatorForListKey)[0]
+	}
+	return string(e.Key)
+}
+
+// setListItems replaces the content of the list at given key by the items.
+func setListItems(l *list.List, key string, items [][]byte) {
+	if len(items) == 0 {
+		delete(l.Items, key)
+		return
+	}
+	l.Items[key] = items
+}
+
 func (tx *Tx) ExpireList(bucket string, key []byte, ttl uint32) error {
 	if err := tx.checkTxIsClosed(); err != nil {
 		return err
@@ -334,7 +606,7 @@ func (tx *Tx) ExpireList(bucket string, key []byte, ttl uint32) error {
 		return ErrBucket
 	}
 	l.TTL[string(key)] = ttl
-	l.TimeStamp[string(key)] = uint64(time.Now().Unix())
+	l.TimeStamp[string(key)] = tx.db.timestamp()
 	ttls := strconv2.Int64ToStr(int64(ttl))
 	err := tx.push(bucket, key, DataExpireListFlag, []byte(ttls))
 	if err != nil {
@@ -15,7 +15,11 @@
 package nutsdb
 
 import (
+	"errors"
+	"github.com/nutsdb/nutsdb/ds/list"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xujiajun/utils/filesystem"
 	"io/ioutil"
 	"os"
 	"testing"
@@ -845,3 +849,494 @@ func TestTx_GetListTTL(t *testing.T) {
 	tx.Commit()
 
 }
+
+func TestTx_LCompact(t *testing.T) {
+	InitForList()
+	db, err = Open(opt)
+	assert.NoError(t, err)
+
+	bucket := "myBucket"
+	key := []byte("myList")
+	err = db.Update(func(tx *Tx) error {
+		return tx.RPush(bucket, key, []byte("a"), []byte("b"), []byte("c"), []byte("d"))
+	})
+	assert.NoError(t, err)
+
+	err = db.Update(func(tx *Tx) error {
+		if _, err := tx.LPop(bucket, key); err != nil {
+			return err
+		}
+		return tx.LSet(bucket, key, 0, []byte("B"))
+	})
+	assert.NoError(t, err)
+
+	err = db.Update(func(tx *Tx) error {
+		if err := tx.LCompact(bucket, key); err != nil {
+			return err
+		}
+		assert.Equal(t, ErrCompactPending, tx.LCompact(bucket, key))
+		return tx.RPush(bucket, key, []byte("e"))
+	})
+	assert.NoError(t, err)
+
+	check := func() {
+		err = db.View(func(tx *Tx) error {
+			items, err := tx.LRange(bucket, key, 0, -1)
+			assert.NoError(t, err)
+			assert.Equal(t, [][]byte{[]byte("B"), []byte("c"), []byte("d"), []byte("e")}, items)
+			return nil
+		})
+		assert.NoError(t, err)
+	}
+	check()
+
+	assert.NoError(t, db.Close())
+	db, err = Open(opt)
+	assert.NoError(t, err)
+	check()
+	assert.NoError(t, db.Close())
+}
+
+// listContents returns the items of the lists at given keys of the bucket, nil for a missing list.
+func listContents(t *testing.T, db *DB, bucket string, keys ...string) map[string][][]byte {
+	contents := make(map[string][][]byte)
+	require.NoError(t, db.View(func(tx *Tx) error {
+		for _, key := range keys {
+			items, _ := tx.LRange(bucket, []byte(key), 0, -1)
+			contents[key] = items
+		}
+		return nil
+	}))
+	return contents
+}
+
+// replayAfterCrash opens a copy of the data files of the open db, as they are left by a crash.
+func replayAfterCrash(t *testing.T, db *DB) *DB {
+	dir := db.opt.Dir + "_crash"
+	require.NoError(t, os.RemoveAll(dir))
+	require.NoError(t, filesystem.CopyDir(db.opt.Dir, dir))
+	crashed, err := Open(db.opt, WithDir(dir))
+	require.NoError(t, err)
+	return crashed
+}
+
+func TestTx_ListCrashReplay(t *testing.T) {
+	InitForList()
+	db, err := Open(opt)
+	require.NoError(t, err)
+	defer db.Close()
+
+	bucket := "bucket_list_replay"
+	keys := []string{"push", "pops", "rem", "set", "trim", "remidx", "ttl", "compact"}
+	ops := []func(tx *Tx) error{
+		func(tx *Tx) error {
+			for _, key := range keys {
+				if err := tx.RPush(bucket, []byte(key), []byte("a"), []byte("b"), []byte("c"), []byte("b")); err != nil {
+					return err
+				}
+			}
+			return tx.LPush(bucket, []byte("push"), []byte("z"))
+		},
+		func(tx *Tx) error {
+			// the pops of a tx see the writes of the tx before them.
+			if err := tx.LPush(bucket, []byte("pops"), []byte("y")); err != nil {
+				return err
+			}
+			for _, want := range []string{"y", "a"} {
+				item, err := tx.LPop(bucket, []byte("pops"))
+				if err != nil {
+					return err
+				}
+				assert.Equal(t, want, string(item))
+			}
+			item, err := tx.RPop(bucket, []byte("pops"))
+			assert.Equal(t, "b", string(item))
+			return err
+		},
+		func(tx *Tx) error {
+			_, err := tx.LRem(bucket, []byte("rem"), 1, []byte("b"))
+			return err
+		},
+		func(tx *Tx) error {
+			return tx.LSet(bucket, []byte("set"), 1, []byte("B"))
+		},
+		func(tx *Tx) error {
+			return tx.LTrim(bucket, []byte("trim"), 1, 2)
+		},
+		func(tx *Tx) error {
+			_, err := tx.LRemByIndex(bucket, []byte("remidx"), 0, 2)
+			return err
+		},
+		func(tx *Tx) error {
+			return tx.ExpireList(bucket, []byte("ttl"), 100)
+		},
+		func(tx *Tx) error {
+			return tx.LCompact(bucket, []byte("compact"))
+		},
+		func(tx *Tx) error {
+			_, err := tx.RPop(bucket, []byte("compact"))
+			return err
+		},
+	}
+
+	for _, op := range ops {
+		require.NoError(t, db.Update(op))
+	}
+	want := listContents(t, db, bucket, keys...)
+	assert.Equal(t, [][]byte{[]byte("b"), []byte("c")}, want["pops"])
+
+	crashed := replayAfterCrash(t, db)
+	assert.Equal(t, want, listContents(t, crashed, bucket, keys...))
+	require.NoError(t, crashed.Close())
+}
+
+func TestTx_ListPopReplayAfterExpiry(t *testing.T) {
+	InitForList()
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	bucket, key := "bucket_list_pop_expiry", []byte("list")
+	now := uint64(time.Now().Unix())
+	listPut := func(tx *Tx, value string, flag uint16, timestamp uint64) error {
+		return tx.put(bucket, key, []byte(value), Persistent, flag, timestamp, DataStructureList)
+	}
+
+	// the list expires at now-90: the pop raced the expiry, the push came after it.
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		for _, value := range []string{"a", "b"} {
+			if err := listPut(tx, value, DataRPushFlag, now-100); err != nil {
+				return err
+			}
+		}
+		if err := listPut(tx, "10", DataExpireListFlag, now-100); err != nil {
+			return err
+		}
+		if err := listPut(tx, "2", DataLPopRefFlag, now-90); err != nil {
+			return err
+		}
+		return listPut(tx, "c", DataRPushFlag, now)
+	}))
+
+	want := listContents(t, db, bucket, string(key))
+	assert.Equal(t, [][]byte{[]byte("c")}, want[string(key)])
+
+	crashed := replayAfterCrash(t, db)
+	assert.Equal(t, want, listContents(t, crashed, bucket, string(key)))
+	require.NoError(t, crashed.Close())
+
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	assert.Equal(t, want, listContents(t, db, bucket, string(key)))
+	require.NoError(t, db.Close())
+}
+
+func TestTx_LMove(t *testing.T) {
+	InitForList()
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	src, dst := "bucket_lmove_src", "bucket_lmove_dst"
+	key := []byte("list")
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.RPush(src, key, []byte("a"), []byte("b"), []byte("c"))
+	}))
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		item, err := tx.LMove(src, key, dst, key, true, false)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("a"), item)
+
+		item, err = tx.LMove(src, key, dst, key, false, true)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("c"), item)
+
+		// the same list is rotated.
+		item, err = tx.LMove(dst, key, dst, key, true, false)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("c"), item)
+		return nil
+	}))
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		_, err := tx.LMove(src, []byte("missing"), dst, key, true, true)
+		assert.Error(t, err)
+		_, err = tx.LMove(src, key, dst, []byte("bad|key"), true, true)
+		assert.Equal(t, ErrSeparatorForListKey, err)
+		return nil
+	}))
+
+	want := map[string]map[string][][]byte{
+		src: {"list": {[]byte("b")}},
+		dst: {"list": {[]byte("a"), []byte("c")}},
+	}
+	check := func(db *DB) {
+		for bucket, contents := range want {
+			assert.Equal(t, contents, listContents(t, db, bucket, "list"))
+		}
+	}
+	check(db)
+
+	crashed := replayAfterCrash(t, db)
+	check(crashed)
+	require.NoError(t, crashed.Close())
+	require.NoError(t, db.Close())
+}
+
+func TestTx_LInsert(t *testing.T) {
+	InitForList()
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	bucket := "bucket_linsert"
+	key := []byte("list")
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.RPush(bucket, key, []byte("a"), []byte("b"), []byte("a"))
+	}))
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		require.NoError(t, tx.LInsertBefore(bucket, key, []byte("a"), []byte("x")))
+		require.NoError(t, tx.LInsertAfter(bucket, key, []byte("b"), []byte("y")))
+
+		// the pivot inserted earlier in the tx is found.
+		require.NoError(t, tx.LInsertAfter(bucket, key, []byte("y"), []byte("z")))
+
+		assert.Equal(t, list.ErrPivotNotFound, tx.LInsertBefore(bucket, key, []byte("missing"), []byte("x")))
+		assert.Equal(t, ErrKeyNotFound, tx.LInsertBefore(bucket, []byte("missing"), []byte("a"), []byte("x")))
+		assert.Equal(t, ErrBucket, tx.LInsertBefore("missing", key, []byte("a"), []byte("x")))
+		return nil
+	}))
+
+	want := map[string][][]byte{
+		"list": {[]byte("x"), []byte("a"), []byte("b"), []byte("y"), []byte("z"), []byte("a")},
+	}
+	assert.Equal(t, want, listContents(t, db, bucket, "list"))
+
+	crashed := replayAfterCrash(t, db)
+	assert.Equal(t, want, listContents(t, crashed, bucket, "list"))
+	require.NoError(t, crashed.Close())
+	require.NoError(t, db.Close())
+}
+
+func TestTx_LPos(t *testing.T) {
+	InitForList()
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	bucket := "bucket_lpos"
+	key := []byte("list")
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.RPush(bucket, key, []byte("a"), []byte("b"), []byte("a"))
+	}))
+
+	require.NoError(t, db.View(func(tx *Tx) error {
+		indexes, err := tx.LPos(bucket, key, []byte("a"), 1, 0)
+		require.NoError(t, err)
+		assert.Equal(t, []int{0, 2}, indexes)
+
+		indexes, err = tx.LPos(bucket, key, []byte("a"), -1, 1)
+		require.NoError(t, err)
+		assert.Equal(t, []int{2}, indexes)
+
+		_, err = tx.LPos(bucket, key, []byte("a"), 0, 1)
+		assert.Equal(t, list.ErrRank, err)
+		_, err = tx.LPos("missing", key, []byte("a"), 1, 1)
+		assert.Equal(t, ErrBucket, err)
+		return nil
+	}))
+	require.NoError(t, db.Close())
+}
+
+func TestTx_PopN(t *testing.T) {
+	InitForList()
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	bucket := "bucket_popn"
+	key := []byte("list")
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.RPush(bucket, key, []byte("a"), []byte("b"), []byte("c"), []byte("d"), []byte("e"))
+	}))
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		items, err := tx.LPopN(bucket, key, 2)
+		require.NoError(t, err)
+		assert.Equal(t, [][]byte{[]byte("a"), []byte("b")}, items)
+
+		items, err = tx.RPopN(bucket, key, 1)
+		require.NoError(t, err)
+		assert.Equal(t, [][]byte{[]byte("e")}, items)
+
+		// a single record is written per call.
+		assert.Len(t, tx.pendingWrites, 2)
+
+		_, err = tx.LPopN(bucket, key, 0)
+		assert.Equal(t, list.ErrCount, err)
+		return nil
+	}))
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		items, err := tx.RPopN(bucket, key, 10)
+		require.NoError(t, err)
+		assert.Equal(t, [][]byte{[]byte("d"), []byte("c")}, items)
+		return tx.RPush(bucket, key, []byte("f"))
+	}))
+
+	want := map[string][][]byte{"list": {[]byte("f")}}
+	assert.Equal(t, want, listContents(t, db, bucket, "list"))
+
+	crashed := replayAfterCrash(t, db)
+	assert.Equal(t, want, listContents(t, crashed, bucket, "list"))
+	require.NoError(t, crashed.Close())
+	require.NoError(t, db.Close())
+}
+
+func TestTx_ListReadYourWrites(t *testing.T) {
+	InitForList()
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	bucket, key := "bucket_list_read_your_writes", []byte("list")
+	bs := func(items ...string) [][]byte {
+		res := make([][]byte, len(items))
+		for i, item := range items {
+			res[i] = []byte(item)
+		}
+		return res
+	}
+
+	// the list of a bucket created by the tx.
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		require.NoError(t, tx.RPush(bucket, key, []byte("a"), []byte("b")))
+		require.NoError(t, tx.LPush(bucket, key, []byte("z")))
+
+		items, err := tx.LRange(bucket, key, 0, -1)
+		require.NoError(t, err)
+		assert.Equal(t, bs("z", "a", "b"), items)
+		size, err := tx.LSize(bucket, key)
+		require.NoError(t, err)
+		assert.Equal(t, 3, size)
+		item, err := tx.LPeek(bucket, key)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("z"), item)
+		item, err = tx.RPeek(bucket, key)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("b"), item)
+
+		_, err = tx.LRange(bucket, []byte("other"), 0, -1)
+		assert.Error(t, err)
+		return nil
+	}))
+
+	// the pending writes apply over the committed list, which is left as is by a rollback.
+	require.Error(t, db.Update(func(tx *Tx) error {
+		item, err := tx.LPop(bucket, key)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("z"), item)
+		require.NoError(t, tx.RPush(bucket, key, []byte("c")))
+
+		items, err := tx.LRange(bucket, key, 0, -1)
+		require.NoError(t, err)
+		assert.Equal(t, bs("a", "b", "c"), items)
+		size, err := tx.LSize(bucket, key)
+		require.NoError(t, err)
+		assert.Equal(t, 3, size)
+		item, err = tx.LPeek(bucket, key)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("a"), item)
+		item, err = tx.RPeek(bucket, key)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("c"), item)
+		return errors.New("rolled back")
+	}))
+	assert.Equal(t, bs("z", "a", "b"), listContents(t, db, bucket, string(key))[string(key)])
+
+	// the pops and the pushes of a tx follow each other on the list it holds for the key.
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		for i := 0; i < 3; i++ {
+			require.NoError(t, tx.RPush(bucket, key, []byte{'c' + byte(i)}))
+			item, err := tx.LPop(bucket, key)
+			require.NoError(t, err)
+			assert.Equal(t, bs("z", "a", "b")[i], item)
+		}
+		item, err := tx.RPop(bucket, key)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("e"), item)
+		items, err := tx.LPopN(bucket, key, 1)
+		require.NoError(t, err)
+		assert.Equal(t, bs("c"), items)
+		return tx.RPush(bucket, key, []byte("f"))
+	}))
+	assert.Equal(t, bs("d", "f"), listContents(t, db, bucket, string(key))[string(key)])
+
+	require.NoError(t, db.View(func(tx *Tx) error {
+		_, err := tx.LRange("bucket_list_missing", key, 0, -1)
+		assert.Equal(t, ErrBucket, err)
+		return nil
+	}))
+	require.NoError(t, db.Close())
+}
+
+func TestTx_ListReadYourWrites_PopsAcrossPushes(t *testing.T) {
+	InitForList()
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	bucket, key := "bucket_list_read_your_writes_pops", []byte("list")
+	bs := func(items ...string) [][]byte {
+		res := make([][]byte, len(items))
+		for i, item := range items {
+			res[i] = []byte(item)
+		}
+		return res
+	}
+	lrange := func(tx *Tx) [][]byte {
+		items, err := tx.LRange(bucket, key, 0, -1)
+		require.NoError(t, err)
+		return items
+	}
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.RPush(bucket, key, []byte("c1"), []byte("c2"), []byte("c3"))
+	}))
+
+	// the pops drain the items pushed at their end, then the committed ones, then the ones pushed at the other end.
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		require.NoError(t, tx.LPush(bucket, key, []byte("h1"), []byte("h2")))
+		require.NoError(t, tx.RPush(bucket, key, []byte("t1"), []byte("t2")))
+		assert.Equal(t, bs("h2", "h1", "c1", "c2", "c3", "t1", "t2"), lrange(tx))
+
+		popped, err := tx.RPopN(bucket, key, 3)
+		require.NoError(t, err)
+		assert.Equal(t, bs("t2", "t1", "c3"), popped)
+		popped, err = tx.LPopN(bucket, key, 3)
+		require.NoError(t, err)
+		assert.Equal(t, bs("h2", "h1", "c1"), popped)
+		assert.Equal(t, bs("c2"), lrange(tx))
+
+		require.NoError(t, tx.RPush(bucket, key, []byte("t3")))
+		item, err := tx.LPop(bucket, key)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("c2"), item)
+		item, err = tx.LPop(bucket, key)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("t3"), item)
+		size, err := tx.LSize(bucket, key)
+		require.NoError(t, err)
+		assert.Equal(t, 0, size)
+		_, err = tx.LPop(bucket, key)
+		assert.Equal(t, list.ErrListNotFound, err)
+
+		// a write in the middle of the list copies it, the later pushes and pops follow the copy.
+		require.NoError(t, tx.RPush(bucket, key, []byte("a"), []byte("b"), []byte("c")))
+		require.NoError(t, tx.LSet(bucket, key, 1, []byte("B")))
+		require.NoError(t, tx.LPush(bucket, key, []byte("z")))
+		item, err = tx.RPop(bucket, key)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("c"), item)
+		assert.Equal(t, bs("z", "a", "B"), lrange(tx))
+		return nil
+	}))
+	assert.Equal(t, bs("z", "a", "B"), listContents(t, db, bucket, string(key))[string(key)])
+	require.NoError(t, db.Close())
+}
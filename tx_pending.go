@@ -0,0 +1,793 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/nutsdb/nutsdb/ds/list"
+	"github.com/nutsdb/nutsdb/ds/set"
+	"github.com/nutsdb/nutsdb/ds/zset"
+	"github.com/xujiajun/utils/strconv2"
+)
+
+// pendingBucket holds the last pending write of every key of a bucket written by the tx.
+type pendingBucket struct {
+	dropped bool // the bucket was deleted by the tx, before the writes of the keys
+	keys    map[string]*Entry
+	sorted  []string // the keys in their order, nil until a scan needs it, see sortedKeys
+}
+
+// sortedKeys returns the keys written in their order.
+func (pb *pendingBucket) sortedKeys() []string {
+	if pb.sorted == nil {
+		pb.sorted = make([]string, 0, len(pb.keys))
+		for key := range pb.keys {
+			pb.sorted = append(pb.sorted, key)
+		}
+		sort.Strings(pb.sorted)
+	}
+	return pb.sorted
+}
+
+// bufferWrite records the pending write of the entry in the write buffer of the tx, which the reads
+// of the tx consult before the index so that they see its own writes.
+func (tx *Tx) bufferWrite(e *Entry) {
+	if tx.isMerge {
+		return
+	}
+
+	bucket := string(e.Bucket)
+	switch {
+	case e.Meta.Ds == DataStructureBPTree && (e.Meta.Flag == DataSetFlag || e.Meta.Flag == DataDeleteFlag):
+		if tx.pendingKeys == nil {
+			tx.pendingKeys = make(map[string]*pendingBucket)
+		}
+		pb, ok := tx.pendingKeys[bucket]
+		if !ok {
+			pb = &pendingBucket{keys: make(map[string]*Entry)}
+			tx.pendingKeys[bucket] = pb
+		}
+		if _, ok := pb.keys[string(e.Key)]; !ok {
+			pb.sorted = nil
+		}
+		pb.keys[string(e.Key)] = e
+	case e.Meta.Ds == DataStructureNone && e.Meta.Flag == DataBPTreeBucketDeleteFlag:
+		if tx.pendingKeys == nil {
+			tx.pendingKeys = make(map[string]*pendingBucket)
+		}
+		tx.pendingKeys[bucket] = &pendingBucket{dropped: true, keys: make(map[string]*Entry)}
+	case e.Meta.Ds == DataStructureList:
+		tx.bufferListWrite(bucket, e)
+	case e.Meta.Ds == DataStructureSet:
+		tx.bufferSetWrite(bucket, e)
+	case e.Meta.Ds == DataStructureNone && e.Meta.Flag == DataSetBucketDeleteFlag:
+		if tx.pendingSets == nil {
+			tx.pendingSets = make(map[string]*pendingSetBucket)
+		}
+		tx.pendingSets[bucket] = &pendingSetBucket{dropped: true, sets: set.New(), keys: make(map[string]struct{})}
+	case e.Meta.Ds == DataStructureSortedSet:
+		tx.bufferSortedSetWrite(bucket, e)
+	case e.Meta.Ds == DataStructureNone && e.Meta.Flag == DataSortedSetBucketDeleteFlag:
+		if tx.pendingSortedSets == nil {
+			tx.pendingSortedSets = make(map[string]*pendingSortedSetBucket)
+		}
+		p := newPendingSortedSetBucket(nil)
+		p.deleted = true
+		tx.pendingSortedSets[bucket] = p
+	}
+}
+
+// pendingListKey holds the pending writes of the tx on the list at a key. The items pushed to the head
+// and to the tail and the count of committed items popped from either end apply on top of the committed
+// items when reading, so that a push or a pop does not copy the list. The other writes, which may change
+// any item, copy the list with the pending writes applied into merged, which the later writes then follow.
+type pendingListKey struct {
+	exists    bool       // the list exists, committed or pushed by the tx
+	committed [][]byte   // the items of the committed list
+	heads     [][]byte   // the items pushed to the head, the last pushed last
+	tails     [][]byte   // the items pushed to the tail, the last pushed last
+	lpops     int        // the committed items popped from the head
+	rpops     int        // the committed items popped from the tail
+	merged    *list.List // the list with the pending writes applied, nil until a write needs it
+}
+
+// size returns the number of items of the list.
+func (p *pendingListKey) size() int {
+	return len(p.heads) + len(p.committed) - p.lpops - p.rpops + len(p.tails)
+}
+
+// item returns the item at the index i, 0 being the head of the list.
+func (p *pendingListKey) item(i int) []byte {
+	if i < len(p.heads) {
+		return p.heads[len(p.heads)-1-i]
+	}
+	i -= len(p.heads)
+	n := len(p.committed) - p.lpops - p.rpops
+	if i < n {
+		return p.committed[p.lpops+i]
+	}
+	return p.tails[i-n]
+}
+
+// items returns the items from the index start to end, both included.
+func (p *pendingListKey) items(start, end int) [][]byte {
+	items := make([][]byte, 0, end-start+1)
+	for i := start; i <= end; i++ {
+		items = append(items, p.item(i))
+	}
+	return items
+}
+
+// lpop pops n items from the head of the list.
+func (p *pendingListKey) lpop(n int) {
+	for ; n > 0; n-- {
+		switch {
+		case len(p.heads) > 0:
+			p.heads = p.heads[:len(p.heads)-1]
+		case p.lpops+p.rpops < len(p.committed):
+			p.lpops++
+		case len(p.tails) > 0:
+			p.tails = p.tails[1:]
+		}
+	}
+}
+
+// rpop pops n items from the tail of the list.
+func (p *pendingListKey) rpop(n int) {
+	for ; n > 0; n-- {
+		switch {
+		case len(p.tails) > 0:
+			p.tails = p.tails[:len(p.tails)-1]
+		case p.lpops+p.rpops < len(p.committed):
+			p.rpops++
+		case len(p.heads) > 0:
+			p.heads = p.heads[1:]
+		}
+	}
+}
+
+// apply applies the list record of the entry, and reports whether it only pushes or pops items at the ends.
+func (p *pendingListKey) apply(e *Entry) bool {
+	switch e.Meta.Flag {
+	case DataLPushFlag:
+		p.heads = append(p.heads, e.Value)
+	case DataRPushFlag:
+		p.tails = append(p.tails, e.Value)
+	case DataLPopFlag:
+		p.lpop(1)
+	case DataRPopFlag:
+		p.rpop(1)
+	case DataLPopRefFlag, DataRPopRefFlag, DataLPopNFlag, DataRPopNFlag:
+		sizeAndCount := strings.Split(string(e.Value), SeparatorForListKey)
+		size, err := strconv2.StrToInt(sizeAndCount[0])
+		if err != nil || size == 0 || size != p.size() {
+			return true
+		}
+		n := 1
+		if len(sizeAndCount) == 2 {
+			if n, err = strconv2.StrToInt(sizeAndCount[1]); err != nil || n < 1 {
+				return true
+			}
+		}
+		if e.Meta.Flag == DataLPopRefFlag || e.Meta.Flag == DataLPopNFlag {
+			p.lpop(n)
+		} else {
+			p.rpop(n)
+		}
+	default:
+		return false
+	}
+	p.exists = p.exists || e.Meta.Flag == DataLPushFlag || e.Meta.Flag == DataRPushFlag
+	return true
+}
+
+// bufferListWrite applies the list record of the entry to the pending writes the tx holds for its key.
+func (tx *Tx) bufferListWrite(bucket string, e *Entry) {
+	if tx.pendingLists == nil {
+		tx.pendingLists = make(map[string]map[string]*pendingListKey)
+	}
+	lists, ok := tx.pendingLists[bucket]
+	if !ok {
+		lists = make(map[string]*pendingListKey)
+		tx.pendingLists[bucket] = lists
+	}
+
+	key := listKeyOfEntry(e)
+	p, ok := lists[key]
+	if !ok {
+		p = &pendingListKey{}
+		if committed := tx.db.Index.getList(bucket); committed != nil {
+			p.committed, p.exists = committed.Items[key]
+		}
+		lists[key] = p
+	}
+
+	if p.merged == nil && p.apply(e) {
+		return
+	}
+	if p.merged == nil {
+		p.merged = list.New()
+		if p.exists {
+			p.merged.Items[key] = p.items(0, p.size()-1)
+		}
+	}
+	applyListEntry(p.merged, e)
+}
+
+// listView reads the list at a key as the pending writes of the tx leave it.
+type listView struct {
+	key     string
+	l       *list.List      // the list to read, unless pending is set
+	pending *pendingListKey // the pending pushes and pops to read
+}
+
+// pendingList returns the view of the list at given key, with the pending writes of the tx, so that
+// the reads of the tx see its own writes.
+func (tx *Tx) pendingList(bucket string, key []byte) (*listView, error) {
+	if p, ok := tx.pendingLists[bucket][string(key)]; ok {
+		if p.merged != nil {
+			return &listView{key: string(key), l: p.merged}, nil
+		}
+		return &listView{key: string(key), pending: p}, nil
+	}
+
+	l := tx.db.Index.getList(bucket)
+	if l == nil {
+		return nil, ErrBucket
+	}
+	return &listView{key: string(key), l: l}, nil
+}
+
+// Size returns the number of items of the list, see list.Size.
+func (v *listView) Size() (int, error) {
+	if v.pending == nil {
+		return v.l.Size(v.key)
+	}
+	if !v.pending.exists {
+		return 0, list.ErrListNotFound
+	}
+	return v.pending.size(), nil
+}
+
+// LPeek returns the first item of the list, see list.LPeek.
+func (v *listView) LPeek() ([]byte, error) {
+	if v.pending == nil {
+		return v.l.LPeek(v.key)
+	}
+	if v.pending.size() == 0 {
+		return nil, list.ErrListNotFound
+	}
+	return v.pending.item(0), nil
+}
+
+// RPeek returns the last item of the list, see list.RPeek.
+func (v *listView) RPeek() ([]byte, error) {
+	if v.pending == nil {
+		item, _, err := v.l.RPeek(v.key)
+		return item, err
+	}
+	size := v.pending.size()
+	if size == 0 {
+		return nil, list.ErrListNotFound
+	}
+	return v.pending.item(size - 1), nil
+}
+
+// LRange returns the items of the list from start to end, see list.LRange.
+func (v *listView) LRange(start, end int) ([][]byte, error) {
+	if v.pending == nil {
+		return v.l.LRange(v.key, start, end)
+	}
+	size, err := v.Size()
+	if err != nil || size == 0 {
+		return nil, err
+	}
+	start, end, err = list.RangeBounds(size, start, end)
+	if err != nil {
+		return nil, err
+	}
+	return v.pending.items(start, end), nil
+}
+
+// Items returns all the items of the list, which must not be modified.
+func (v *listView) Items() [][]byte {
+	if v.pending == nil {
+		return v.l.Items[v.key]
+	}
+	return v.pending.items(0, v.pending.size()-1)
+}
+
+// pendingGet returns the entry the tx last wrote for the key in the bucket, and whether the tx decided
+// the value of the key: a pending delete, an expired pending write or a pending delete of the bucket
+// make the key not found.
+func (tx *Tx) pendingGet(bucket string, key []byte) (*Entry, bool, error) {
+	pb, ok := tx.pendingKeys[bucket]
+	if !ok {
+		return nil, false, nil
+	}
+	if e, ok := pb.keys[string(key)]; ok {
+		if e.Meta.Flag == DataDeleteFlag || tx.db.isExpired(bucket, e.Meta) {
+			return nil, true, ErrNotFoundKey
+		}
+		return e, true, nil
+	}
+	if pb.dropped {
+		return nil, true, ErrNotFoundBucket
+	}
+	return nil, false, nil
+}
+
+// scanLimit returns the number of records of the index a prefix scan from the first one reads for the entries
+// from the offset up to the limit once merged with the pending writes, which replace at most as many records.
+func (pb *pendingBucket) scanLimit(offsetNum, limitNum int) int {
+	if limitNum <= 0 {
+		return ScanNoLimit
+	}
+	return offsetNum + limitNum + len(pb.keys)
+}
+
+// pendingShadows reports whether the tx has a pending write on the key of the bucket, or deleted the bucket.
+func (tx *Tx) pendingShadows(bucket string, key []byte) bool {
+	pb, ok := tx.pendingKeys[bucket]
+	if !ok {
+		return false
+	}
+	_, ok = pb.keys[string(key)]
+	return ok || pb.dropped
+}
+
+// pendingEntries returns the live pending writes of the tx on the keys of the bucket accepted by in, a nil in
+// accepting all of them, in the order of their keys, or in the descending order if reverse. Their values are
+// resolved, or left out if keysOnly.
+func (tx *Tx) pendingEntries(bucket string, in func(key []byte) bool, reverse, keysOnly bool) (Entries, error) {
+	pb, ok := tx.pendingKeys[bucket]
+	if !ok {
+		return nil, nil
+	}
+
+	keys := pb.sortedKeys()
+	var es Entries
+	for i := range keys {
+		if reverse {
+			i = len(keys) - 1 - i
+		}
+		e := pb.keys[keys[i]]
+		if e.Meta.Flag == DataDeleteFlag || tx.db.isExpired(bucket, e.Meta) || (in != nil && !in(e.Key)) {
+			continue
+		}
+		if keysOnly {
+			e = &Entry{Key: e.Key, Bucket: e.Bucket, Meta: e.Meta}
+		} else {
+			var err error
+			if e, err = tx.resolveValue(bucket, e); err != nil {
+				return nil, err
+			}
+		}
+		es = append(es, e)
+	}
+	return es, nil
+}
+
+// mergePending merges the pending writes of the tx on the keys of the bucket accepted by in into the entries es
+// of a scan, sorted by key, or in the descending order if reverse, so that the scans of the tx see its own writes:
+// a pending write replaces the entry of its key, and a pending delete or the pending delete of the bucket removes it.
+func (tx *Tx) mergePending(bucket string, es Entries, in func(key []byte) bool, reverse, keysOnly bool) (Entries, error) {
+	pb, ok := tx.pendingKeys[bucket]
+	if !ok {
+		return es, nil
+	}
+	pending, err := tx.pendingEntries(bucket, in, reverse, keysOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := make(Entries, 0, len(es)+len(pending))
+	for _, e := range es {
+		if _, ok := pb.keys[string(e.Key)]; ok || pb.dropped {
+			continue
+		}
+		for len(pending) > 0 && (compare(pending[0].Key, e.Key) < 0) != reverse {
+			merged, pending = append(merged, pending[0]), pending[1:]
+		}
+		merged = append(merged, e)
+	}
+	return append(merged, pending...), nil
+}
+
+// scanPending returns the entries of a scan, which failed with err, merged with the pending writes of the tx
+// as mergePending does. The scan failing with notFound when it finds no entries is merged as one finding none.
+func (tx *Tx) scanPending(bucket string, es Entries, err, notFound error, in func(key []byte) bool, reverse bool) (Entries, error) {
+	if _, ok := tx.pendingKeys[bucket]; !ok || (err != nil && err != notFound) {
+		return es, err
+	}
+
+	if es, err = tx.mergePending(bucket, es, in, reverse, false); err != nil {
+		return nil, err
+	}
+	if len(es) == 0 {
+		return nil, notFound
+	}
+	return es, nil
+}
+
+// pendingKeyExists reports whether the key is live in the key/value bucket, with the pending writes of the tx.
+func (tx *Tx) pendingKeyExists(bucket string, key []byte) (bool, error) {
+	if e, ok, _ := tx.pendingGet(bucket, key); ok {
+		return e != nil, nil
+	}
+	return tx.keyExists(bucket, key)
+}
+
+// pendingSetBucket holds the sets of the keys of a bucket written by the tx, with its pending writes applied.
+type pendingSetBucket struct {
+	dropped bool                // the bucket was deleted by the tx, before the writes of the keys
+	sets    *set.Set            // the sets of the keys written
+	keys    map[string]struct{} // the keys written
+}
+
+// bufferSetWrite applies the set record of the entry to the set the tx holds for its key, which
+// starts as a copy of the committed set and then follows the pending writes of the tx as they are buffered.
+func (tx *Tx) bufferSetWrite(bucket string, e *Entry) {
+	if tx.pendingSets == nil {
+		tx.pendingSets = make(map[string]*pendingSetBucket)
+	}
+	pb, ok := tx.pendingSets[bucket]
+	if !ok {
+		pb = &pendingSetBucket{sets: set.New(), keys: make(map[string]struct{})}
+		tx.pendingSets[bucket] = pb
+	}
+
+	key := string(e.Key)
+	if _, ok := pb.keys[key]; !ok {
+		pb.keys[key] = struct{}{}
+		if s, ok := tx.db.SetIdx[bucket]; ok && !pb.dropped {
+			if members, ok := s.M[key]; ok {
+				pb.sets.M[key] = make(map[string]struct{}, len(members))
+				for member := range members {
+					pb.sets.M[key][member] = struct{}{}
+				}
+			}
+		}
+	}
+	applySetEntry(pb.sets, e)
+}
+
+// pendingSet returns the set index of the bucket and whether it exists, or when the tx has pending
+// writes on the set at the key or a pending delete of the bucket, the sets the tx holds with them applied.
+// The set returned must not be modified.
+func (tx *Tx) pendingSet(bucket string, key []byte) (*set.Set, bool) {
+	if pb, ok := tx.pendingSets[bucket]; ok {
+		if _, ok := pb.keys[string(key)]; ok {
+			return pb.sets, true
+		}
+		if pb.dropped {
+			return set.New(), false
+		}
+	}
+
+	s, ok := tx.db.SetIdx[bucket]
+	return s, ok
+}
+
+// pendingSortedSetBucket holds the pending writes of the tx on a sorted set bucket. The members added or
+// updated and the committed members removed apply on top of the committed sorted set when reading, so that
+// a write does not copy it. The writes removing members by rank, score or key range, and the reads by rank,
+// copy the sorted set with the pending writes applied into merged, which the later writes then follow.
+type pendingSortedSetBucket struct {
+	committed *zset.SortedSet     // the committed sorted set, nil if none or deleted by the tx
+	deleted   bool                // the bucket is deleted by the tx and not written since
+	added     *zset.SortedSet     // the members added or updated by the tx
+	removed   map[string]struct{} // the committed members removed by the tx
+	merged    *zset.SortedSet     // the sorted set with the pending writes applied, nil until needed
+}
+
+func newPendingSortedSetBucket(committed *zset.SortedSet) *pendingSortedSetBucket {
+	return &pendingSortedSetBucket{
+		committed: committed,
+		added:     zset.New(),
+		removed:   make(map[string]struct{}),
+	}
+}
+
+// shadowed reports whether the pending writes replace or remove the committed member at the key.
+func (p *pendingSortedSetBucket) shadowed(key string) bool {
+	if _, ok := p.added.Dict[key]; ok {
+		return true
+	}
+	_, ok := p.removed[key]
+	return ok
+}
+
+func (p *pendingSortedSetBucket) add(key string, score zset.SCORE, value []byte) {
+	delete(p.removed, key)
+	_ = p.added.Put(key, score, value)
+}
+
+func (p *pendingSortedSetBucket) remove(key string) {
+	_ = p.added.Remove(key)
+	if p.committed != nil && p.committed.GetByKey(key) != nil {
+		p.removed[key] = struct{}{}
+	}
+}
+
+// apply applies the sorted set record of the entry, and reports whether it only adds or removes given members.
+func (p *pendingSortedSetBucket) apply(e *Entry) bool {
+	switch e.Meta.Flag {
+	case DataZAddFlag:
+		key, score := splitZAddKey(e.Key)
+		p.add(key, score, e.Value)
+	case DataZAddBatchFlag:
+		members, _ := unmarshalZMembers(e.Value)
+		for _, m := range members {
+			p.add(string(m.Key), zset.SCORE(m.Score), m.Value)
+		}
+	case DataZRemFlag:
+		p.remove(string(e.Key))
+	case DataZPopMaxFlag, DataZPopMinFlag:
+		if n := p.peek(e.Meta.Flag == DataZPopMaxFlag); n != nil {
+			p.remove(n.Key())
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// sortedSet returns the sorted set with the pending writes applied, which it copies on the first call.
+func (p *pendingSortedSetBucket) sortedSet() *zset.SortedSet {
+	if p.merged != nil {
+		return p.merged
+	}
+
+	p.merged = zset.New()
+	if p.committed != nil {
+		for _, n := range p.committed.GetByRankRange(1, -1, false) {
+			if _, ok := p.removed[n.Key()]; !ok {
+				_ = p.merged.Put(n.Key(), n.Score(), n.Value)
+			}
+		}
+	}
+	for _, n := range p.added.GetByRankRange(1, -1, false) {
+		_ = p.merged.Put(n.Key(), n.Score(), n.Value)
+	}
+	p.added, p.removed = nil, nil
+	return p.merged
+}
+
+// zsetNodeLess reports whether the node a comes before b in a sorted set.
+func zsetNodeLess(a, b *zset.SortedSetNode) bool {
+	return a.Score() < b.Score() || (a.Score() == b.Score() && a.Key() < b.Key())
+}
+
+// peek returns the member with the highest score if max, or else the lowest, nil if there is none.
+func (p *pendingSortedSetBucket) peek(max bool) *zset.SortedSetNode {
+	var committed *zset.SortedSetNode
+	if p.committed != nil {
+		for r := 1; r <= p.committed.Size(); r++ {
+			rank := r
+			if max {
+				rank = -r
+			}
+			if n := p.committed.GetByRank(rank, false); !p.shadowed(n.Key()) {
+				committed = n
+				break
+			}
+		}
+	}
+
+	added := p.added.PeekMin()
+	if max {
+		added = p.added.PeekMax()
+	}
+	switch {
+	case committed == nil:
+		return added
+	case added == nil:
+		return committed
+	case zsetNodeLess(added, committed) != max:
+		return added
+	}
+	return committed
+}
+
+// bufferSortedSetWrite applies the sorted set record of the entry to the pending writes the tx holds for the bucket.
+func (tx *Tx) bufferSortedSetWrite(bucket string, e *Entry) {
+	if tx.pendingSortedSets == nil {
+		tx.pendingSortedSets = make(map[string]*pendingSortedSetBucket)
+	}
+	p, ok := tx.pendingSortedSets[bucket]
+	if !ok {
+		p = newPendingSortedSetBucket(tx.db.SortedSetIdx[bucket])
+		tx.pendingSortedSets[bucket] = p
+	}
+
+	p.deleted = false
+	if p.merged == nil && p.apply(e) {
+		return
+	}
+	p.merged = applySortedSetEntry(p.sortedSet(), e)
+}
+
+// sortedSetView reads a sorted set bucket as the pending writes of the tx leave it.
+// The nodes returned must not be modified.
+type sortedSetView struct {
+	ss      *zset.SortedSet         // the sorted set to read, unless pending is set
+	pending *pendingSortedSetBucket // the pending writes to read on top of their committed sorted set
+}
+
+// pendingSortedSet returns the view of the sorted set of the bucket and whether it exists, with the
+// pending writes of the tx, so that the reads of the tx see its own writes.
+func (tx *Tx) pendingSortedSet(bucket string) (*sortedSetView, bool) {
+	if p, ok := tx.pendingSortedSets[bucket]; ok {
+		switch {
+		case p.deleted:
+			return &sortedSetView{ss: zset.New()}, false
+		case p.merged != nil:
+			return &sortedSetView{ss: p.merged}, true
+		}
+		return &sortedSetView{pending: p}, true
+	}
+
+	ss, ok := tx.db.SortedSetIdx[bucket]
+	return &sortedSetView{ss: ss}, ok
+}
+
+// sortedSet returns the sorted set to read, copying it with the pending writes applied if needed.
+func (v *sortedSetView) sortedSet() *zset.SortedSet {
+	if v.pending != nil {
+		return v.pending.sortedSet()
+	}
+	return v.ss
+}
+
+// Members returns the members of the sorted set by key, which must not be modified.
+func (v *sortedSetView) Members() map[string]*zset.SortedSetNode {
+	p := v.pending
+	if p == nil {
+		return v.ss.Dict
+	}
+
+	members := make(map[string]*zset.SortedSetNode, v.Size())
+	if p.committed != nil {
+		for key, n := range p.committed.Dict {
+			if _, ok := p.removed[key]; !ok {
+				members[key] = n
+			}
+		}
+	}
+	for key, n := range p.added.Dict {
+		members[key] = n
+	}
+	return members
+}
+
+// Size returns the number of members of the sorted set.
+func (v *sortedSetView) Size() int {
+	p := v.pending
+	if p == nil {
+		return v.ss.Size()
+	}
+
+	size := p.added.Size()
+	if p.committed != nil {
+		size += p.committed.Size() - len(p.removed)
+		for key := range p.added.Dict {
+			if p.committed.GetByKey(key) != nil {
+				size--
+			}
+		}
+	}
+	return size
+}
+
+// GetByKey returns the member at the key, see zset.GetByKey.
+func (v *sortedSetView) GetByKey(key string) *zset.SortedSetNode {
+	p := v.pending
+	if p == nil {
+		return v.ss.GetByKey(key)
+	}
+
+	if n := p.added.GetByKey(key); n != nil {
+		return n
+	}
+	if _, ok := p.removed[key]; ok || p.committed == nil {
+		return nil
+	}
+	return p.committed.GetByKey(key)
+}
+
+// PeekMin returns the member with the lowest score, see zset.PeekMin.
+func (v *sortedSetView) PeekMin() *zset.SortedSetNode {
+	if v.pending == nil {
+		return v.ss.PeekMin()
+	}
+	return v.pending.peek(false)
+}
+
+// PeekMax returns the member with the highest score, see zset.PeekMax.
+func (v *sortedSetView) PeekMax() *zset.SortedSetNode {
+	if v.pending == nil {
+		return v.ss.PeekMax()
+	}
+	return v.pending.peek(true)
+}
+
+// GetByScoreRange returns the members with a score within the range, see zset.GetByScoreRange.
+func (v *sortedSetView) GetByScoreRange(start, end zset.SCORE, opts *zset.GetByScoreRangeOptions) []*zset.SortedSetNode {
+	p := v.pending
+	if p == nil {
+		return v.ss.GetByScoreRange(start, end, opts)
+	}
+
+	o := zset.GetByScoreRangeOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	added := p.added.GetByScoreRange(start, end, &o)
+	var committed []*zset.SortedSetNode
+	if p.committed != nil {
+		co := o
+		if co.Limit > 0 {
+			// the shadowed members met in the range do not count in the limit.
+			co.Limit += p.added.Size() + len(p.removed)
+		}
+		for _, n := range p.committed.GetByScoreRange(start, end, &co) {
+			if !p.shadowed(n.Key()) {
+				committed = append(committed, n)
+			}
+		}
+	}
+
+	reverse := start > end
+	nodes := make([]*zset.SortedSetNode, 0, len(added)+len(committed))
+	for len(added) > 0 || len(committed) > 0 {
+		if len(committed) == 0 || (len(added) > 0 && zsetNodeLess(added[0], committed[0]) != reverse) {
+			nodes, added = append(nodes, added[0]), added[1:]
+		} else {
+			nodes, committed = append(nodes, committed[0]), committed[1:]
+		}
+	}
+	if o.Limit > 0 && len(nodes) > o.Limit {
+		nodes = nodes[:o.Limit]
+	}
+	return nodes
+}
+
+// GetByRankRange returns the members with a rank within the range, see zset.GetByRankRange.
+func (v *sortedSetView) GetByRankRange(start, end int) []*zset.SortedSetNode {
+	return v.sortedSet().GetByRankRange(start, end, false)
+}
+
+// FindRank returns the rank of the member at the key, see zset.FindRank.
+func (v *sortedSetView) FindRank(key string) int {
+	return v.sortedSet().FindRank(key)
+}
+
+// FindRevRank returns the reverse rank of the member at the key, see zset.FindRevRank.
+func (v *sortedSetView) FindRevRank(key string) int {
+	return v.sortedSet().FindRevRank(key)
+}
+
+// pendingListItems returns the items of the list at given key as the pending writes of the tx leave it.
+// The items returned must not be modified.
+func (tx *Tx) pendingListItems(bucket string, key string) [][]byte {
+	v, err := tx.pendingList(bucket, []byte(key))
+	if err != nil {
+		return nil
+	}
+	return v.Items()
+}
@@ -0,0 +1,91 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+)
+
+var (
+	// ErrPutBatchNotSorted is returned by PutBatch when the keys are not in strictly ascending order.
+	ErrPutBatchNotSorted = errors.New("the keys of the batch are not sorted in ascending order")
+
+	// ErrPutBatchLength is returned by PutBatch when there are not as many values as keys.
+	ErrPutBatchLength = errors.New("the batch has not as many values as keys")
+)
+
+// PutBatch sets the values for the keys in the bucket, as Put does for each of them. The keys must be sorted
+// in strictly ascending order by bytes.Compare, which the index of the bucket sorts them by: on commit the
+// index of an empty bucket, or of a bucket holding fewer keys than the batch, is built from the sorted keys
+// in a single pass instead of inserting them one by one, which makes the initial loads much faster.
+func (tx *Tx) PutBatch(bucket string, keys, values [][]byte, ttl uint32) error {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return err
+	}
+	if len(keys) != len(values) {
+		return ErrPutBatchLength
+	}
+	for i := 1; i < len(keys); i++ {
+		if compare(keys[i-1], keys[i]) >= 0 {
+			return ErrPutBatchNotSorted
+		}
+	}
+
+	start := len(tx.pendingWrites)
+	timestamp := tx.db.timestamp()
+	for i, key := range keys {
+		if err := tx.put(bucket, key, values[i], ttl, DataSetFlag, timestamp, DataStructureBPTree); err != nil {
+			return err
+		}
+	}
+
+	// the postings of a bucket with a tokenizer are written among the keys, which are then not contiguous.
+	if _, ok := tx.db.opt.Tokenizers[bucket]; len(keys) > 1 && !ok {
+		if tx.putBatches == nil {
+			tx.putBatches = make(map[int]int)
+		}
+		tx.putBatches[start] = len(tx.pendingWrites)
+	}
+	return nil
+}
+
+// buildBPTreeBatchIdx applies the pending writes from start to end of a PutBatch to the index of the bucket.
+func (tx *Tx) buildBPTreeBatchIdx(bucket string, start, end int, fileIDs, offsets []int64, countFlag bool) {
+	if _, ok := tx.db.BPTreeIdx[bucket]; !ok || tx.db.BPTreeIdx[bucket] == nil {
+		tx.db.BPTreeIdx[bucket] = NewTree()
+	}
+	idx := tx.db.BPTreeIdx[bucket]
+
+	n := end - start
+	keys := make([][]byte, 0, n)
+	es := make([]*Entry, 0, n)
+	hs := make([]*Hint, 0, n)
+	for i := start; i < end; i++ {
+		entry := tx.pendingWrites[i]
+		if idx.root != nil {
+			tx.db.addDeadStat(idx, entry.Key)
+		}
+
+		var e *Entry
+		if tx.db.opt.EntryIdxMode == HintKeyValAndRAMIdxMode {
+			e = entry
+		}
+		keys = append(keys, entry.Key)
+		es = append(es, e)
+		hs = append(hs, tx.db.newHint(entry.Key, fileIDs[i], entry.Meta, uint64(offsets[i])))
+	}
+
+	_ = idx.BulkInsert(keys, es, hs, countFlag)
+}
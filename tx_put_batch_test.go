@@ -0,0 +1,128 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func putBatchRun(from, to int, valueFormat string) (keys, values [][]byte) {
+	for i := from; i < to; i++ {
+		keys = append(keys, []byte(fmt.Sprintf("key_%05d", i)))
+		values = append(values, []byte(fmt.Sprintf(valueFormat, i)))
+	}
+	return
+}
+
+func TestTx_PutBatch(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestputbatch", true)
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	bucket := "bucket"
+	keys, values := putBatchRun(0, 1000, "val_%05d")
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.PutBatch(bucket, keys, values, Persistent)
+	}))
+
+	// a batch overlapping the keys of the bucket, followed by writes of the same tx.
+	keys, values = putBatchRun(500, 1500, "new_%05d")
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		if err := tx.PutBatch(bucket, keys, values, Persistent); err != nil {
+			return err
+		}
+		if err := tx.Delete(bucket, []byte("key_00600")); err != nil {
+			return err
+		}
+		return tx.Put(bucket, []byte("key_00700"), []byte("last"), Persistent)
+	}))
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		assert.Equal(t, ErrPutBatchNotSorted, tx.PutBatch(bucket, [][]byte{[]byte("b"), []byte("a")}, [][]byte{nil, nil}, Persistent))
+		assert.Equal(t, ErrPutBatchNotSorted, tx.PutBatch(bucket, [][]byte{[]byte("a"), []byte("a")}, [][]byte{nil, nil}, Persistent))
+		assert.Equal(t, ErrPutBatchLength, tx.PutBatch(bucket, [][]byte{[]byte("a")}, nil, Persistent))
+		return nil
+	}))
+
+	check := func() {
+		require.NoError(t, db.View(func(tx *Tx) error {
+			for i := 0; i < 1500; i++ {
+				key := []byte(fmt.Sprintf("key_%05d", i))
+				e, err := tx.Get(bucket, key)
+				switch {
+				case i == 600:
+					assert.Error(t, err)
+				case i == 700:
+					require.NoError(t, err)
+					assert.Equal(t, []byte("last"), e.Value)
+				case i < 500:
+					require.NoError(t, err)
+					assert.Equal(t, fmt.Sprintf("val_%05d", i), string(e.Value))
+				default:
+					require.NoError(t, err)
+					assert.Equal(t, fmt.Sprintf("new_%05d", i), string(e.Value))
+				}
+			}
+
+			entries, err := tx.GetAll(bucket)
+			require.NoError(t, err)
+			assert.Len(t, entries, 1499)
+			return nil
+		}))
+	}
+	check()
+
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	check()
+	require.NoError(t, db.Close())
+}
+
+func BenchmarkTx_PutBatch(b *testing.B) {
+	keys, values := putBatchRun(0, 10000, "val_%05d")
+
+	for _, batch := range []bool{false, true} {
+		b.Run(fmt.Sprintf("batch=%t", batch), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				InitOpt("/tmp/nutsdbbenchputbatch", true)
+				db, err := Open(opt, WithSyncEnable(false))
+				require.NoError(b, err)
+				b.StartTimer()
+
+				err = db.Update(func(tx *Tx) error {
+					if batch {
+						return tx.PutBatch("bucket", keys, values, Persistent)
+					}
+					for j, key := range keys {
+						if err := tx.Put("bucket", key, values[j], Persistent); err != nil {
+							return err
+						}
+					}
+					return nil
+				})
+				require.NoError(b, err)
+
+				b.StopTimer()
+				require.NoError(b, db.Close())
+			}
+		})
+	}
+}
@@ -15,8 +15,6 @@
 package nutsdb
 
 import (
-	"time"
-
 	"github.com/nutsdb/nutsdb/ds/set"
 	"github.com/pkg/errors"
 )
@@ -25,22 +23,19 @@ func (tx *Tx) sPut(bucket string, key []byte, dataFlag uint16, items ...[]byte)
 
 	if dataFlag == DataSetFlag {
 
-		filter := make(map[string]struct{})
-
-		if set, ok := tx.db.SetIdx[bucket]; ok {
-
-			if _, ok := set.M[string(key)]; ok {
-				for item := range set.M[string(key)] {
-					filter[item] = struct{}{}
-				}
-			}
-
+		var members map[string]struct{}
+		if set, ok := tx.pendingSet(bucket, key); ok {
+			members = set.M[string(key)]
 		}
 
+		filter := make(map[string]struct{})
 		for _, item := range items {
+			if _, ok := members[string(item)]; ok {
+				continue
+			}
 			if _, ok := filter[string(item)]; !ok {
 				filter[string(item)] = struct{}{}
-				err := tx.put(bucket, key, item, Persistent, dataFlag, uint64(time.Now().Unix()), DataStructureSet)
+				err := tx.put(bucket, key, item, Persistent, dataFlag, tx.db.timestamp(), DataStructureSet)
 				if err != nil {
 					return err
 				}
@@ -50,7 +45,7 @@ func (tx *Tx) sPut(bucket string, key []byte, dataFlag uint16, items ...[]byte)
 	} else {
 		for _, item := range items {
 
-			err := tx.put(bucket, key, item, Persistent, dataFlag, uint64(time.Now().Unix()), DataStructureSet)
+			err := tx.put(bucket, key, item, Persistent, dataFlag, tx.db.timestamp(), DataStructureSet)
 			if err != nil {
 				return err
 			}
@@ -66,6 +61,36 @@ func (tx *Tx) SAdd(bucket string, key []byte, items ...[]byte) error {
 	return tx.sPut(bucket, key, DataSetFlag, items...)
 }
 
+// SAddBatch adds the specified members to the set stored int the bucket at given bucket,key and items,
+// like SAdd but with all the new members written in a single record, which is cheaper to store and to replay.
+func (tx *Tx) SAddBatch(bucket string, key []byte, items ...[]byte) error {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return err
+	}
+
+	var current map[string]struct{}
+	if set, ok := tx.pendingSet(bucket, key); ok {
+		current = set.M[string(key)]
+	}
+
+	filter := make(map[string]struct{})
+	members := make([][]byte, 0, len(items))
+	for _, item := range items {
+		if _, ok := current[string(item)]; ok {
+			continue
+		}
+		if _, ok := filter[string(item)]; !ok {
+			filter[string(item)] = struct{}{}
+			members = append(members, item)
+		}
+	}
+	if len(members) == 0 {
+		return nil
+	}
+
+	return tx.put(bucket, key, MarshalListItems(members), Persistent, DataSAddBatchFlag, tx.db.timestamp(), DataStructureSet)
+}
+
 // SRem removes the specified members from the set stored int the bucket at given bucket,key and items.
 func (tx *Tx) SRem(bucket string, key []byte, items ...[]byte) error {
 	return tx.sPut(bucket, key, DataDeleteFlag, items...)
@@ -77,7 +102,7 @@ func (tx *Tx) SAreMembers(bucket string, key []byte, items ...[]byte) (bool, err
 		return false, err
 	}
 
-	if sets, ok := tx.db.SetIdx[bucket]; ok {
+	if sets, ok := tx.pendingSet(bucket, key); ok {
 		return sets.SAreMembers(string(key), items...)
 	}
 
@@ -90,7 +115,7 @@ func (tx *Tx) SIsMember(bucket string, key, item []byte) (bool, error) {
 		return false, err
 	}
 
-	if set, ok := tx.db.SetIdx[bucket]; ok {
+	if set, ok := tx.pendingSet(bucket, key); ok {
 		if !set.SIsMember(string(key), item) {
 			return false, ErrBucketNotFound
 		}
@@ -106,7 +131,7 @@ func (tx *Tx) SMembers(bucket string, key []byte) (list [][]byte, err error) {
 		return nil, err
 	}
 
-	if set, ok := tx.db.SetIdx[bucket]; ok {
+	if set, ok := tx.pendingSet(bucket, key); ok {
 		return set.SMembers(string(key))
 	}
 
@@ -120,7 +145,7 @@ func (tx *Tx) SHasKey(bucket string, key []byte) (bool, error) {
 		return false, err
 	}
 
-	if set, ok := tx.db.SetIdx[bucket]; ok {
+	if set, ok := tx.pendingSet(bucket, key); ok {
 		return set.SHasKey(string(key)), nil
 	}
 
@@ -133,8 +158,8 @@ func (tx *Tx) SPop(bucket string, key []byte) ([]byte, error) {
 		return nil, err
 	}
 
-	if _, ok := tx.db.SetIdx[bucket]; ok {
-		for item := range tx.db.SetIdx[bucket].M[string(key)] {
+	if set, ok := tx.pendingSet(bucket, key); ok {
+		for item := range set.M[string(key)] {
 			return []byte(item), tx.sPut(bucket, key, DataDeleteFlag, []byte(item))
 		}
 	}
@@ -148,7 +173,7 @@ func (tx *Tx) SCard(bucket string, key []byte) (int, error) {
 		return 0, err
 	}
 
-	if set, ok := tx.db.SetIdx[bucket]; ok {
+	if set, ok := tx.pendingSet(bucket, key); ok {
 		return set.SCard(string(key)), nil
 	}
 
@@ -325,3 +350,41 @@ func ErrBucketAndKey(bucket string, key []byte) error {
 func ErrNotFoundKeyInBucket(bucket string, key []byte) error {
 	return errors.Wrapf(ErrKeyNotFound, "%s is not found in %s", key, bucket)
 }
+
+// SCompact replaces the records of the set stored in the bucket at given bucket and key by
+// a single snapshot of its current members, so that the next merge reclaims the replay records.
+// It must be called before any other write to the set in the tx.
+func (tx *Tx) SCompact(bucket string, key []byte) error {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return err
+	}
+
+	sets, ok := tx.db.SetIdx[bucket]
+	if !ok {
+		return ErrBucketNotFound
+	}
+	for _, e := range tx.pendingWrites {
+		if e.Meta.Ds == DataStructureSet && string(e.Bucket) == bucket && string(e.Key) == string(key) {
+			return ErrCompactPending
+		}
+	}
+
+	members, err := sets.SMembers(string(key))
+	if err != nil {
+		return err
+	}
+
+	return tx.put(bucket, key, MarshalListItems(members), Persistent, DataSetSnapshotFlag, tx.db.timestamp(), DataStructureSet)
+}
+
+// setMembers replaces the members of the set at given key.
+func setMembers(s *set.Set, key string, members [][]byte) {
+	if len(members) == 0 {
+		delete(s.M, key)
+		return
+	}
+	s.M[key] = make(map[string]struct{}, len(members))
+	for _, member := range members {
+		s.M[key][string(member)] = struct{}{}
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func InitForSet() {
@@ -1028,3 +1029,163 @@ func TestErrNotFoundKeyInBucket(t *testing.T) {
 	assert.True(t,
 		errors.Is(got, ErrKeyNotFound))
 }
+
+func TestTx_SCompact(t *testing.T) {
+	InitForSet()
+	db, err = Open(opt)
+	assert.NoError(t, err)
+
+	bucket, key := "bucket1", []byte("key1")
+	err = db.Update(func(tx *Tx) error {
+		return tx.SAdd(bucket, key, []byte("a"), []byte("b"), []byte("c"))
+	})
+	assert.NoError(t, err)
+	err = db.Update(func(tx *Tx) error {
+		return tx.SRem(bucket, key, []byte("b"))
+	})
+	assert.NoError(t, err)
+
+	err = db.Update(func(tx *Tx) error {
+		if err := tx.SCompact(bucket, key); err != nil {
+			return err
+		}
+		assert.Equal(t, ErrCompactPending, tx.SCompact(bucket, key))
+		return tx.SAdd(bucket, key, []byte("d"))
+	})
+	assert.NoError(t, err)
+
+	check := func() {
+		err = db.View(func(tx *Tx) error {
+			ok, err := tx.SAreMembers(bucket, key, []byte("a"), []byte("c"), []byte("d"))
+			assert.NoError(t, err)
+			assert.True(t, ok)
+			card, err := tx.SCard(bucket, key)
+			assert.NoError(t, err)
+			assert.Equal(t, 3, card)
+			return nil
+		})
+		assert.NoError(t, err)
+	}
+	check()
+
+	assert.NoError(t, db.Close())
+	db, err = Open(opt)
+	assert.NoError(t, err)
+	check()
+	assert.NoError(t, db.Close())
+}
+
+func TestTx_SAddBatch(t *testing.T) {
+	InitForSet()
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	bucket, key := "bucket_sadd_batch", []byte("key")
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.SAdd(bucket, key, []byte("a"))
+	}))
+
+	tx, err := db.Begin(true)
+	require.NoError(t, err)
+	require.NoError(t, tx.SAddBatch(bucket, key, []byte("a"), []byte("b"), []byte("c"), []byte("b")))
+	// the members are written in a single record, without the ones already in the set.
+	require.Len(t, tx.pendingWrites, 1)
+	items, err := UnmarshalListItems(tx.pendingWrites[0].Value)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("b"), []byte("c")}, items)
+	require.NoError(t, tx.Commit())
+
+	check := func() {
+		require.NoError(t, db.View(func(tx *Tx) error {
+			num, err := tx.SCard(bucket, key)
+			assert.NoError(t, err)
+			assert.Equal(t, 3, num)
+			ok, err := tx.SAreMembers(bucket, key, []byte("a"), []byte("b"), []byte("c"))
+			assert.NoError(t, err)
+			assert.True(t, ok)
+			return nil
+		}))
+	}
+
+	check()
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	check()
+	require.NoError(t, db.Close())
+}
+
+func TestTx_SetReadYourWrites(t *testing.T) {
+	InitForSet()
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	bucket, key := "bucket_set_read_your_writes", []byte("set")
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		require.NoError(t, tx.SAdd(bucket, key, []byte("a"), []byte("b")))
+
+		ok, err := tx.SIsMember(bucket, key, []byte("a"))
+		require.NoError(t, err)
+		assert.True(t, ok)
+		card, err := tx.SCard(bucket, key)
+		require.NoError(t, err)
+		assert.Equal(t, 2, card)
+		return nil
+	}))
+
+	require.Error(t, db.Update(func(tx *Tx) error {
+		require.NoError(t, tx.SRem(bucket, key, []byte("a")))
+		require.NoError(t, tx.SAdd(bucket, key, []byte("c")))
+
+		members, err := tx.SMembers(bucket, key)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, [][]byte{[]byte("b"), []byte("c")}, members)
+		_, err = tx.SIsMember(bucket, key, []byte("a"))
+		assert.Error(t, err)
+		ok, err := tx.SAreMembers(bucket, key, []byte("b"), []byte("c"))
+		require.NoError(t, err)
+		assert.True(t, ok)
+
+		// a member removed by the tx is written again by SAdd.
+		require.NoError(t, tx.SAdd(bucket, key, []byte("a")))
+		card, err := tx.SCard(bucket, key)
+		require.NoError(t, err)
+		assert.Equal(t, 3, card)
+		return errors.New("rolled back")
+	}))
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		members, err := tx.SMembers(bucket, key)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, [][]byte{[]byte("a"), []byte("b")}, members)
+
+		require.NoError(t, tx.SRem(bucket, key, []byte("a")))
+		return tx.SAdd(bucket, key, []byte("a"))
+	}))
+	require.NoError(t, db.View(func(tx *Tx) error {
+		ok, err := tx.SIsMember(bucket, key, []byte("a"))
+		require.NoError(t, err)
+		assert.True(t, ok)
+		return nil
+	}))
+
+	// the sets of a bucket deleted by the tx are gone but for the keys written since.
+	require.Error(t, db.Update(func(tx *Tx) error {
+		require.NoError(t, tx.DeleteBucket(DataStructureSet, bucket))
+		require.NoError(t, tx.SAdd(bucket, []byte("other"), []byte("x")))
+
+		_, err := tx.SMembers(bucket, key)
+		assert.Equal(t, ErrBucketNotFound, err)
+		members, err := tx.SMembers(bucket, []byte("other"))
+		require.NoError(t, err)
+		assert.Equal(t, [][]byte{[]byte("x")}, members)
+		return errors.New("rolled back")
+	}))
+	require.NoError(t, db.View(func(tx *Tx) error {
+		card, err := tx.SCard(bucket, key)
+		require.NoError(t, err)
+		assert.Equal(t, 2, card)
+		return nil
+	}))
+	require.NoError(t, db.Close())
+}
@@ -0,0 +1,82 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+
+	"github.com/xujiajun/utils/strconv2"
+)
+
+// ErrTempBucketDataStructure is returned when a key/value is written to a temporary bucket.
+var ErrTempBucketDataStructure = errors.New("temporary buckets only hold sets, sorted sets and lists")
+
+const tempBucketKind = "temp"
+
+// TempBucket returns the name of a new scratch bucket living as long as the tx, for instance to hold
+// the intermediate sets of a computation. The sets, sorted sets and lists written to it are visible
+// to the tx right away, they are never written to the data files and are dropped once the tx is
+// committed or rolled back. Only a writable tx has temporary buckets.
+func (tx *Tx) TempBucket() (string, error) {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return "", err
+	}
+	if !tx.writable {
+		return "", ErrTxNotWritable
+	}
+
+	name := strconv2.Int64ToStr(int64(tx.id)) + "_" + strconv2.IntToStr(len(tx.tempBuckets))
+	bucket := internalBucket(tempBucketKind, name)
+	tx.tempBuckets = append(tx.tempBuckets, bucket)
+	return bucket, nil
+}
+
+// isTempBucket returns if the bucket is one of the temporary buckets of the tx.
+func (tx *Tx) isTempBucket(bucket string) bool {
+	for _, b := range tx.tempBuckets {
+		if b == bucket {
+			return true
+		}
+	}
+	return false
+}
+
+// bucketBytes returns the bytes of the bucket name of an entry written by the tx. The names of the
+// temporary buckets are not interned, since they are dropped with the tx.
+func (tx *Tx) bucketBytes(bucket string) []byte {
+	if tx.isTempBucket(bucket) {
+		return []byte(bucket)
+	}
+	return tx.db.bucketNames.bytes(bucket)
+}
+
+// putTemp applies the entry written to a temporary bucket to the indexes instead of writing it.
+func (tx *Tx) putTemp(e *Entry) error {
+	if e.Meta.Ds == DataStructureBPTree {
+		return ErrTempBucketDataStructure
+	}
+	tx.buildIdx(e)
+	return nil
+}
+
+// dropTempBuckets removes the indexes of the temporary buckets of the tx.
+func (tx *Tx) dropTempBuckets() {
+	for _, bucket := range tx.tempBuckets {
+		tx.db.deleteBucket(DataStructureSet, bucket)
+		tx.db.deleteBucket(DataStructureSortedSet, bucket)
+		tx.db.deleteBucket(DataStructureList, bucket)
+	}
+	tx.tempBuckets = nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sortedItems(items [][]byte) []string {
+	s := make([]string, len(items))
+	for i, item := range items {
+		s[i] = string(item)
+	}
+	sort.Strings(s)
+	return s
+}
+
+func TestTx_TempBucket(t *testing.T) {
+	InitOpt("/tmp/nutsdbtesttempbucket", true)
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	bucket, key := "bucket_temp", []byte("set")
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.SAdd(bucket, key, []byte("a"), []byte("b"), []byte("c"))
+	}))
+
+	var temps []string
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		tmp, err := tx.TempBucket()
+		require.NoError(t, err)
+		other, err := tx.TempBucket()
+		require.NoError(t, err)
+		assert.NotEqual(t, tmp, other)
+		temps = append(temps, tmp, other)
+
+		// the writes to a temporary bucket are visible right away, unlike the pending ones.
+		require.NoError(t, tx.SAdd(tmp, key, []byte("b"), []byte("d")))
+		diff, err := tx.SDiffByTwoBuckets(tmp, key, bucket, key)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"d"}, sortedItems(diff))
+
+		require.NoError(t, tx.RPush(other, key, []byte("x"), []byte("y")))
+		item, err := tx.LPop(other, key)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("x"), item)
+		items, err := tx.LRange(other, key, 0, -1)
+		require.NoError(t, err)
+		assert.Equal(t, [][]byte{[]byte("y")}, items)
+
+		require.NoError(t, tx.ZAdd(other, []byte("member"), 1, []byte("value")))
+		n, err := tx.ZCard(other)
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+
+		assert.Equal(t, ErrTempBucketDataStructure, tx.Put(tmp, []byte("key"), []byte("value"), Persistent))
+		return nil
+	}))
+
+	check := func() {
+		for _, tmp := range temps {
+			assert.NotContains(t, db.SetIdx, tmp)
+			assert.NotContains(t, db.SortedSetIdx, tmp)
+			assert.Nil(t, db.Index.getList(tmp))
+		}
+		require.NoError(t, db.View(func(tx *Tx) error {
+			members, err := tx.SMembers(bucket, key)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"a", "b", "c"}, sortedItems(members))
+			return nil
+		}))
+	}
+	check()
+
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	check()
+
+	// the temporary buckets are dropped by a rollback as well, and only writable txs have them.
+	tx, err := db.Begin(true)
+	require.NoError(t, err)
+	tmp, err := tx.TempBucket()
+	require.NoError(t, err)
+	require.NoError(t, tx.SAdd(tmp, key, []byte("a")))
+	require.NoError(t, tx.Rollback())
+	assert.NotContains(t, db.SetIdx, tmp)
+
+	require.NoError(t, db.View(func(tx *Tx) error {
+		_, err := tx.TempBucket()
+		assert.Equal(t, ErrTxNotWritable, err)
+		return nil
+	}))
+	require.NoError(t, db.Close())
+}
@@ -15,11 +15,14 @@
 package nutsdb
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestTx_Rollback(t *testing.T) {
@@ -225,3 +228,223 @@ func TestTx_PutWithTimestamp(t *testing.T) {
 		}
 	})
 }
+
+type txTestCtxKey struct{}
+
+func TestTx_Context(t *testing.T) {
+	withDefaultDB(t, func(t *testing.T, db *DB) {
+		bucket := "bucket_tx_context"
+		ctx := context.WithValue(context.Background(), txTestCtxKey{}, "trace-id")
+
+		err := db.UpdateWithContext(ctx, func(tx *Tx) error {
+			assert.Equal(t, "trace-id", tx.Context().Value(txTestCtxKey{}))
+			return tx.Put(bucket, []byte("key"), []byte("value"), Persistent)
+		})
+		assert.NoError(t, err)
+
+		cancelCtx, cancel := context.WithCancel(context.Background())
+		err = db.UpdateWithContext(cancelCtx, func(tx *Tx) error {
+			cancel()
+			return tx.Put(bucket, []byte("canceled"), []byte("value"), Persistent)
+		})
+		assert.Equal(t, context.Canceled, err)
+
+		_, err = db.BeginWithContext(cancelCtx, false)
+		assert.Equal(t, context.Canceled, err)
+
+		err = db.ViewWithContext(ctx, func(tx *Tx) error {
+			assert.Equal(t, "trace-id", tx.Context().Value(txTestCtxKey{}))
+			_, err := tx.Get(bucket, []byte("key"))
+			assert.NoError(t, err)
+			_, err = tx.Get(bucket, []byte("canceled"))
+			assert.Error(t, err)
+			return nil
+		})
+		assert.NoError(t, err)
+
+		tx, err := db.Begin(false)
+		assert.NoError(t, err)
+		// the context carries the tx, so that the transactions opened with it are linked to it.
+		assert.Equal(t, tx, tx.Context().Value(txCtxKey{}))
+		assert.NoError(t, tx.Context().Err())
+		assert.NoError(t, tx.Rollback())
+	})
+}
+
+// failingRWManager fails the calls selected by the test.
+type failingRWManager struct {
+	RWManager
+	failWrite   bool
+	failRelease bool
+}
+
+var errInjected = errors.New("injected error")
+
+func (m *failingRWManager) WriteAt(b []byte, off int64) (int, error) {
+	if m.failWrite {
+		return 0, errInjected
+	}
+	return m.RWManager.WriteAt(b, off)
+}
+
+func (m *failingRWManager) Release() error {
+	if m.failRelease {
+		return errInjected
+	}
+	return m.RWManager.Release()
+}
+
+func TestTx_CommitError(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestcommiterror", true)
+	opt.SegmentSize = 256
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	bucket := "bucket"
+	err = db.Update(func(tx *Tx) error {
+		return tx.Put(bucket, []byte("key_0"), []byte("old"), Persistent)
+	})
+	require.NoError(t, err)
+
+	assertOldValues := func() {
+		err := db.View(func(tx *Tx) error {
+			e, err := tx.Get(bucket, []byte("key_0"))
+			if assert.NoError(t, err) {
+				assert.Equal(t, []byte("old"), e.Value)
+			}
+			_, err = tx.Get(bucket, []byte("key_1"))
+			assert.Error(t, err)
+			return nil
+		})
+		require.NoError(t, err)
+	}
+
+	update := func() error {
+		return db.Update(func(tx *Tx) error {
+			for i := 0; i < 4; i++ {
+				if err := tx.Put(bucket, []byte(fmt.Sprintf("key_%d", i)), []byte("new"), Persistent); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	t.Run("write fails", func(t *testing.T) {
+		rwManager := db.ActiveFile.rwManager
+		db.ActiveFile.rwManager = &failingRWManager{RWManager: rwManager, failWrite: true}
+
+		var commitErr *CommitError
+		err := update()
+		require.True(t, errors.As(err, &commitErr))
+		assert.Equal(t, 0, commitErr.Written)
+		assert.Equal(t, 4, commitErr.Total)
+		assert.False(t, commitErr.Committed)
+		assert.True(t, errors.Is(err, errInjected))
+
+		db.ActiveFile.rwManager = rwManager
+		assertOldValues()
+	})
+
+	t.Run("rotation fails", func(t *testing.T) {
+		rwManager := db.ActiveFile.rwManager
+		db.ActiveFile.rwManager = &failingRWManager{RWManager: rwManager, failRelease: true}
+
+		var commitErr *CommitError
+		err := update()
+		require.True(t, errors.As(err, &commitErr))
+		assert.True(t, commitErr.Written > 0)
+		assert.False(t, commitErr.Committed)
+
+		db.ActiveFile.rwManager = rwManager
+		assertOldValues()
+	})
+
+	require.NoError(t, db.Close())
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	assertOldValues()
+	require.NoError(t, db.Close())
+}
+
+func TestTx_CommitError_SparseIdxMode(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestcommiterrorsparse", true)
+	opt.EntryIdxMode = HintBPTSparseIdxMode
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	rwManager := db.ActiveFile.rwManager
+	db.ActiveFile.rwManager = &failingRWManager{RWManager: rwManager, failWrite: true}
+
+	var commitErr *CommitError
+	err = db.Update(func(tx *Tx) error {
+		return tx.Put("bucket", []byte("key"), []byte("value"), Persistent)
+	})
+	require.True(t, errors.As(err, &commitErr))
+	assert.False(t, commitErr.Committed)
+
+	// the sparse index is left untouched by the entries which were not written.
+	newKey := getNewKey("bucket", []byte("key"))
+	assert.NotContains(t, db.BPTreeKeyEntryPosMap, string(newKey))
+	_, err = db.ActiveBPTreeIdx.Find(newKey)
+	assert.Error(t, err)
+
+	db.ActiveFile.rwManager = rwManager
+	require.NoError(t, db.Close())
+}
+
+// blockingSyncRWManager blocks the fsyncs until the test releases them.
+type blockingSyncRWManager struct {
+	RWManager
+	syncing chan struct{}
+	release chan struct{}
+}
+
+func (m *blockingSyncRWManager) Sync() error {
+	m.syncing <- struct{}{}
+	<-m.release
+	return m.RWManager.Sync()
+}
+
+func TestTx_CommitDoesNotBlockReadsDuringSync(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestcommitsync", true)
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	rwManager := &blockingSyncRWManager{
+		RWManager: db.ActiveFile.rwManager,
+		syncing:   make(chan struct{}),
+		release:   make(chan struct{}),
+	}
+	db.ActiveFile.rwManager = rwManager
+
+	bucket := "bucket"
+	committed := make(chan error, 1)
+	go func() {
+		committed <- db.Update(func(tx *Tx) error {
+			return tx.Put(bucket, []byte("key"), []byte("value"), Persistent)
+		})
+	}()
+
+	<-rwManager.syncing
+	// the reads go on, and see the changes, while the commit waits for the fsync.
+	require.NoError(t, db.View(func(tx *Tx) error {
+		e, err := tx.Get(bucket, []byte("key"))
+		if assert.NoError(t, err) {
+			assert.Equal(t, []byte("value"), e.Value)
+		}
+		return nil
+	}))
+	select {
+	case <-committed:
+		t.Fatal("the commit returned before the fsync")
+	default:
+	}
+
+	close(rwManager.release)
+	require.NoError(t, <-committed)
+
+	db.ActiveFile.rwManager = rwManager.RWManager
+	require.NoError(t, db.Close())
+}
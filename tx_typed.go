@@ -0,0 +1,78 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+)
+
+// ErrSchemaVersion is returned by GetTyped and PutTyped when the schema version of the value has no decoder
+// registered for the bucket in Options.SchemaDecoders.
+var ErrSchemaVersion = errors.New("no decoder registered for the schema version of the value")
+
+// SchemaDecoder decodes into v the encoded value written with the schema version it is registered for,
+// migrating it to the current format of v when the version is an older one.
+type SchemaDecoder func(data []byte, v interface{}) error
+
+// GobDecoder is the SchemaDecoder of the values written by PutTyped in the current format of v.
+func GobDecoder(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// PutTyped sets the value for a key in the bucket to v encoded with encoding/gob, after a byte holding
+// the schema version of the encoding. The version must have a decoder registered for the bucket,
+// so that the value can be read back by GetTyped.
+func (tx *Tx) PutTyped(bucket string, key []byte, version uint8, v interface{}, ttl uint32) error {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return err
+	}
+	if tx.schemaDecoder(bucket, version) == nil {
+		return ErrSchemaVersion
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(version)
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return err
+	}
+	return tx.Put(bucket, key, buf.Bytes(), ttl)
+}
+
+// GetTyped decodes into v the value for a key in the bucket written by PutTyped, with the decoder registered
+// for the bucket and the schema version of the value, which it returns. The values of a version without
+// a decoder are never returned, ErrSchemaVersion is returned instead.
+func (tx *Tx) GetTyped(bucket string, key []byte, v interface{}) (uint8, error) {
+	e, err := tx.Get(bucket, key)
+	if err != nil {
+		return 0, err
+	}
+	if len(e.Value) == 0 {
+		return 0, ErrSchemaVersion
+	}
+
+	version := e.Value[0]
+	decode := tx.schemaDecoder(bucket, version)
+	if decode == nil {
+		return version, ErrSchemaVersion
+	}
+	return version, decode(e.Value[1:], v)
+}
+
+// schemaDecoder returns the decoder registered for the bucket and the schema version, nil if none is.
+func (tx *Tx) schemaDecoder(bucket string, version uint8) SchemaDecoder {
+	return tx.db.opt.SchemaDecoders[bucket][version]
+}
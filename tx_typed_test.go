@@ -0,0 +1,109 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type userV1 struct {
+	Name string
+}
+
+type userV2 struct {
+	First string
+	Last  string
+}
+
+func TestTx_Typed(t *testing.T) {
+	InitOpt("/tmp/nutsdbtesttyped", true)
+	opt.SchemaDecoders = map[string]map[uint8]SchemaDecoder{
+		"users": {1: GobDecoder},
+	}
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	bucket := "users"
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		if err := tx.PutTyped(bucket, []byte("ada"), 1, userV1{Name: "Ada Lovelace"}, Persistent); err != nil {
+			return err
+		}
+		assert.Equal(t, ErrSchemaVersion, tx.PutTyped(bucket, []byte("alan"), 2, userV2{First: "Alan"}, Persistent))
+		return nil
+	}))
+
+	require.NoError(t, db.View(func(tx *Tx) error {
+		var u userV1
+		version, err := tx.GetTyped(bucket, []byte("ada"), &u)
+		require.NoError(t, err)
+		assert.Equal(t, uint8(1), version)
+		assert.Equal(t, "Ada Lovelace", u.Name)
+
+		_, err = tx.GetTyped(bucket, []byte("alan"), &u)
+		assert.Equal(t, ErrKeyNotFound, err)
+		return nil
+	}))
+	require.NoError(t, db.Close())
+
+	// the format evolves to version 2, the values of version 1 are migrated when read.
+	opt.SchemaDecoders = nil
+	WithSchemaDecoder(bucket, 1, func(data []byte, v interface{}) error {
+		var old userV1
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&old); err != nil {
+			return err
+		}
+		u := v.(*userV2)
+		u.First = old.Name[:3]
+		u.Last = old.Name[4:]
+		return nil
+	})(&opt)
+	WithSchemaDecoder(bucket, 2, GobDecoder)(&opt)
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		var u userV2
+		version, err := tx.GetTyped(bucket, []byte("ada"), &u)
+		require.NoError(t, err)
+		assert.Equal(t, uint8(1), version)
+		assert.Equal(t, userV2{First: "Ada", Last: "Lovelace"}, u)
+		return tx.PutTyped(bucket, []byte("alan"), 2, userV2{First: "Alan", Last: "Turing"}, Persistent)
+	}))
+	require.NoError(t, db.Close())
+
+	// a version no longer registered is refused.
+	delete(opt.SchemaDecoders[bucket], 1)
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	require.NoError(t, db.View(func(tx *Tx) error {
+		var u userV2
+		version, err := tx.GetTyped(bucket, []byte("ada"), &u)
+		assert.Equal(t, ErrSchemaVersion, err)
+		assert.Equal(t, uint8(1), version)
+
+		version, err = tx.GetTyped(bucket, []byte("alan"), &u)
+		require.NoError(t, err)
+		assert.Equal(t, uint8(2), version)
+		assert.Equal(t, userV2{First: "Alan", Last: "Turing"}, u)
+		return nil
+	}))
+	require.NoError(t, db.Close())
+}
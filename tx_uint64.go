@@ -0,0 +1,58 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Uint64KeySize is the size of the keys encoded by EncodeUint64Key.
+const Uint64KeySize = 8
+
+// ErrUint64Key is returned by DecodeUint64Key when the key is not Uint64KeySize bytes long.
+var ErrUint64Key = errors.New("the key is not an encoded uint64")
+
+// EncodeUint64Key encodes n as a key in big-endian, so that the keys sort like the numbers.
+func EncodeUint64Key(n uint64) []byte {
+	key := make([]byte, Uint64KeySize)
+	binary.BigEndian.PutUint64(key, n)
+	return key
+}
+
+// DecodeUint64Key decodes a key encoded by EncodeUint64Key, e.g. the key of an entry returned by RangeScanUint64.
+func DecodeUint64Key(key []byte) (uint64, error) {
+	if len(key) != Uint64KeySize {
+		return 0, ErrUint64Key
+	}
+	return binary.BigEndian.Uint64(key), nil
+}
+
+// PutUint64 sets the value for the uint64 key in the bucket, as Put does for the key encoded by EncodeUint64Key.
+// The keys of a bucket should either all be uint64 keys or none of them, for the scans to sort them as numbers.
+func (tx *Tx) PutUint64(bucket string, key uint64, value []byte, ttl uint32) error {
+	return tx.Put(bucket, EncodeUint64Key(key), value, ttl)
+}
+
+// GetUint64 returns the entry of the uint64 key in the bucket, as Get does for the key encoded by EncodeUint64Key.
+func (tx *Tx) GetUint64(bucket string, key uint64) (*Entry, error) {
+	return tx.Get(bucket, EncodeUint64Key(key))
+}
+
+// RangeScanUint64 returns the entries of the uint64 keys of the bucket from start to end included,
+// in the numeric order of the keys, as RangeScan does for the keys encoded by EncodeUint64Key.
+func (tx *Tx) RangeScanUint64(bucket string, start, end uint64) (Entries, error) {
+	return tx.RangeScan(bucket, EncodeUint64Key(start), EncodeUint64Key(end))
+}
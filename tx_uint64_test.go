@@ -0,0 +1,63 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTx_Uint64Keys(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestuint64keys", true)
+	db, err = Open(opt)
+	require.NoError(t, err)
+	defer db.Close()
+
+	bucket := "bucket"
+	// the decimal strings of these keys would sort 1, 1099511627776, 255, 256, 9.
+	keys := []uint64{256, 9, 1 << 40, 1, 255}
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		for _, key := range keys {
+			if err := tx.PutUint64(bucket, key, EncodeUint64Key(key*2), Persistent); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	require.NoError(t, db.View(func(tx *Tx) error {
+		e, err := tx.GetUint64(bucket, 255)
+		require.NoError(t, err)
+		assert.Equal(t, EncodeUint64Key(510), e.Value)
+		_, err = tx.GetUint64(bucket, 2)
+		assert.Error(t, err)
+
+		entries, err := tx.RangeScanUint64(bucket, 2, 1<<40)
+		require.NoError(t, err)
+		var got []uint64
+		for _, e := range entries {
+			key, err := DecodeUint64Key(e.Key)
+			require.NoError(t, err)
+			got = append(got, key)
+		}
+		assert.Equal(t, []uint64{9, 255, 256, 1 << 40}, got)
+		return nil
+	}))
+
+	_, err = DecodeUint64Key([]byte("key"))
+	assert.Equal(t, ErrUint64Key, err)
+}
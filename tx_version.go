@@ -0,0 +1,95 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNotModified is returned by GetIfChanged when the value of the key still has the given version.
+	ErrNotModified = errors.New("the value is not modified")
+
+	// ErrInvalidVersion is returned by ParseEntryVersion when the string is not a version.
+	ErrInvalidVersion = errors.New("invalid entry version")
+)
+
+// EntryVersion identifies a write of a key/value entry. Every write of the key, even of the same value,
+// gives a new version, and so does the merge rewriting the entry to another data file.
+type EntryVersion struct {
+	// FileID and DataPos locate the entry in the data files, no two writes share them.
+	FileID  int64
+	DataPos uint64
+
+	// Crc is the checksum of the entry as written in the data file.
+	Crc uint32
+}
+
+// String returns the version as an opaque token suitable for an ETag, ParseEntryVersion decodes it.
+func (v EntryVersion) String() string {
+	return fmt.Sprintf("%x-%x-%08x", v.FileID, v.DataPos, v.Crc)
+}
+
+// ParseEntryVersion decodes the version returned by EntryVersion.String.
+func ParseEntryVersion(s string) (EntryVersion, error) {
+	var v EntryVersion
+	if n, err := fmt.Sscanf(s, "%x-%x-%x", &v.FileID, &v.DataPos, &v.Crc); err != nil || n != 3 || v.String() != s {
+		return EntryVersion{}, ErrInvalidVersion
+	}
+	return v, nil
+}
+
+// GetWithVersion is like Get and also returns the version of the value.
+func (tx *Tx) GetWithVersion(bucket string, key []byte) (*Entry, EntryVersion, error) {
+	return tx.getIfChanged(bucket, key, nil)
+}
+
+// GetIfChanged returns the value of the key and its version unless the version is still lastVersion,
+// in which case it returns ErrNotModified along with the version without reading the value.
+func (tx *Tx) GetIfChanged(bucket string, key []byte, lastVersion EntryVersion) (*Entry, EntryVersion, error) {
+	return tx.getIfChanged(bucket, key, &lastVersion)
+}
+
+func (tx *Tx) getIfChanged(bucket string, key []byte, lastVersion *EntryVersion) (*Entry, EntryVersion, error) {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return nil, EntryVersion{}, err
+	}
+	if tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return nil, EntryVersion{}, ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	tx.db.hotKeys.read(bucket, key)
+	tx.traceOp(TraceOpGet, bucket, key, 0)
+
+	r, err := tx.findRecord(bucket, key)
+	if err != nil {
+		return nil, EntryVersion{}, err
+	}
+
+	version := EntryVersion{FileID: r.H.FileID, DataPos: r.H.DataPos, Crc: r.H.Meta.Crc}
+	if lastVersion != nil && *lastVersion == version {
+		return nil, version, ErrNotModified
+	}
+
+	e, err := tx.db.readValue(tx.ctx, bucket, key, r)
+	if err == nil {
+		e, err = tx.resolveValue(bucket, e)
+	}
+	if err != nil {
+		return nil, EntryVersion{}, err
+	}
+	return e, version, nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTx_GetIfChanged(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestversion", true)
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	bucket, key := "bucket_version", []byte("key")
+	put := func(value string) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.Put(bucket, key, []byte(value), Persistent)
+		}))
+	}
+	getVersion := func() EntryVersion {
+		var version EntryVersion
+		require.NoError(t, db.View(func(tx *Tx) error {
+			e, v, err := tx.GetWithVersion(bucket, key)
+			require.NoError(t, err)
+			assert.Equal(t, []byte("value"), e.Value)
+			version = v
+			return nil
+		}))
+		return version
+	}
+
+	put("value")
+	v1 := getVersion()
+	assert.NotZero(t, v1.Crc)
+
+	require.NoError(t, db.View(func(tx *Tx) error {
+		e, v, err := tx.GetIfChanged(bucket, key, v1)
+		assert.Equal(t, ErrNotModified, err)
+		assert.Nil(t, e)
+		assert.Equal(t, v1, v)
+		return nil
+	}))
+
+	// the version survives a restart.
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	assert.Equal(t, v1, getVersion())
+
+	// writing the same value again gives a new version.
+	put("value")
+	v2 := getVersion()
+	assert.NotEqual(t, v1, v2)
+	require.NoError(t, db.View(func(tx *Tx) error {
+		e, v, err := tx.GetIfChanged(bucket, key, v1)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("value"), e.Value)
+		assert.Equal(t, v2, v)
+		return nil
+	}))
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.Delete(bucket, key)
+	}))
+	require.NoError(t, db.View(func(tx *Tx) error {
+		_, _, err := tx.GetIfChanged(bucket, key, v2)
+		assert.Equal(t, ErrNotFoundKey, err)
+		return nil
+	}))
+	require.NoError(t, db.Close())
+}
+
+func TestParseEntryVersion(t *testing.T) {
+	v := EntryVersion{FileID: 12, DataPos: 4096, Crc: 0xdeadbeef}
+	parsed, err := ParseEntryVersion(v.String())
+	require.NoError(t, err)
+	assert.Equal(t, v, parsed)
+
+	for _, s := range []string{"", "c-1000", "c-1000-deadbeef-1", "x-1000-deadbeef", "c-1000-0000000001"} {
+		_, err := ParseEntryVersion(s)
+		assert.Equal(t, ErrInvalidVersion, err, s)
+	}
+}
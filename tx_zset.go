@@ -19,7 +19,6 @@ import (
 	"errors"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/nutsdb/nutsdb/ds/zset"
 	"github.com/xujiajun/utils/strconv2"
@@ -28,6 +27,19 @@ import (
 // SeparatorForZSetKey represents separator for zSet key.
 const SeparatorForZSetKey = "|"
 
+// zsetSnapshotKey is the key of the snapshot records of the sorted set buckets.
+const zsetSnapshotKey = "snapshot"
+
+// zsetBatchKey is the key of the records written by ZAddBatch.
+const zsetBatchKey = "batch"
+
+// ZMember represents a member of a sorted set added by ZAddBatch.
+type ZMember struct {
+	Key   []byte
+	Score float64
+	Value []byte
+}
+
 // ZAdd adds the specified member key with the specified score and specified val to the sorted set stored at bucket.
 func (tx *Tx) ZAdd(bucket string, key []byte, score float64, val []byte) error {
 	var buffer bytes.Buffer
@@ -42,7 +54,26 @@ func (tx *Tx) ZAdd(bucket string, key []byte, score float64, val []byte) error {
 	buffer.Write(scoreBytes)
 	newKey := buffer.Bytes()
 
-	return tx.put(bucket, newKey, val, Persistent, DataZAddFlag, uint64(time.Now().Unix()), DataStructureSortedSet)
+	return tx.put(bucket, newKey, val, Persistent, DataZAddFlag, tx.db.timestamp(), DataStructureSortedSet)
+}
+
+// ZAddBatch adds the specified members to the sorted set stored at bucket, like ZAdd
+// but with all the members written in a single record, which is cheaper to store and to replay.
+func (tx *Tx) ZAddBatch(bucket string, members ...ZMember) error {
+	if len(members) == 0 {
+		return nil
+	}
+
+	for _, m := range members {
+		if len(m.Key) == 0 {
+			return ErrKeyEmpty
+		}
+		if strings.Contains(string(m.Key), SeparatorForZSetKey) {
+			return ErrSeparatorForZSetKey()
+		}
+	}
+
+	return tx.put(bucket, []byte(zsetBatchKey), marshalZMembers(members), Persistent, DataZAddBatchFlag, tx.db.timestamp(), DataStructureSortedSet)
 }
 
 // ZMembers returns all the members of the set value stored at bucket.
@@ -51,21 +82,26 @@ func (tx *Tx) ZMembers(bucket string) (map[string]*zset.SortedSetNode, error) {
 		return nil, err
 	}
 
-	if _, ok := tx.db.SortedSetIdx[bucket]; !ok {
+	ss, ok := tx.pendingSortedSet(bucket)
+	if !ok {
 		return nil, ErrBucket
 	}
 
-	return tx.db.SortedSetIdx[bucket].Dict, nil
+	return ss.Members(), nil
 }
 
 // ZCard returns the sorted set cardinality (number of elements) of the sorted set stored at bucket.
 func (tx *Tx) ZCard(bucket string) (int, error) {
-	members, err := tx.ZMembers(bucket)
-	if err != nil {
+	if err := tx.checkTxIsClosed(); err != nil {
 		return 0, err
 	}
 
-	return len(members), nil
+	ss, ok := tx.pendingSortedSet(bucket)
+	if !ok {
+		return 0, ErrBucket
+	}
+
+	return ss.Size(), nil
 }
 
 // ZCount returns the number of elements in the sorted set at bucket with a score between min and max and opts.
@@ -89,7 +125,7 @@ func (tx *Tx) ZPopMax(bucket string) (*zset.SortedSetNode, error) {
 		return nil, err
 	}
 
-	return item, tx.put(bucket, []byte(" "), []byte(""), Persistent, DataZPopMaxFlag, uint64(time.Now().Unix()), DataStructureSortedSet)
+	return item, tx.put(bucket, []byte(" "), []byte(""), Persistent, DataZPopMaxFlag, tx.db.timestamp(), DataStructureSortedSet)
 }
 
 // ZPopMin removes and returns the member with the lowest score in the sorted set stored at bucket.
@@ -99,7 +135,7 @@ func (tx *Tx) ZPopMin(bucket string) (*zset.SortedSetNode, error) {
 		return nil, err
 	}
 
-	return item, tx.put(bucket, []byte(" "), []byte(""), Persistent, DataZPopMinFlag, uint64(time.Now().Unix()), DataStructureSortedSet)
+	return item, tx.put(bucket, []byte(" "), []byte(""), Persistent, DataZPopMinFlag, tx.db.timestamp(), DataStructureSortedSet)
 }
 
 // ZPeekMax returns the member with the highest score in the sorted set stored at bucket.
@@ -108,11 +144,12 @@ func (tx *Tx) ZPeekMax(bucket string) (*zset.SortedSetNode, error) {
 		return nil, err
 	}
 
-	if _, ok := tx.db.SortedSetIdx[bucket]; !ok {
+	ss, ok := tx.pendingSortedSet(bucket)
+	if !ok {
 		return nil, ErrBucket
 	}
 
-	return tx.db.SortedSetIdx[bucket].PeekMax(), nil
+	return ss.PeekMax(), nil
 }
 
 // ZPeekMin returns the member with the lowest score in the sorted set stored at bucket.
@@ -121,11 +158,12 @@ func (tx *Tx) ZPeekMin(bucket string) (*zset.SortedSetNode, error) {
 		return nil, err
 	}
 
-	if _, ok := tx.db.SortedSetIdx[bucket]; !ok {
+	ss, ok := tx.pendingSortedSet(bucket)
+	if !ok {
 		return nil, ErrBucket
 	}
 
-	return tx.db.SortedSetIdx[bucket].PeekMin(), nil
+	return ss.PeekMin(), nil
 }
 
 // ZRangeByScore returns all the elements in the sorted set at bucket with a score between min and max.
@@ -134,11 +172,12 @@ func (tx *Tx) ZRangeByScore(bucket string, start, end float64, opts *zset.GetByS
 		return nil, err
 	}
 
-	if _, ok := tx.db.SortedSetIdx[bucket]; !ok {
+	ss, ok := tx.pendingSortedSet(bucket)
+	if !ok {
 		return nil, ErrBucket
 	}
 
-	return tx.db.SortedSetIdx[bucket].GetByScoreRange(zset.SCORE(start), zset.SCORE(end), opts), nil
+	return ss.GetByScoreRange(zset.SCORE(start), zset.SCORE(end), opts), nil
 }
 
 // ZRangeByRank returns all the elements in the sorted set in one bucket and key
@@ -148,11 +187,12 @@ func (tx *Tx) ZRangeByRank(bucket string, start, end int) ([]*zset.SortedSetNode
 		return nil, err
 	}
 
-	if _, ok := tx.db.SortedSetIdx[bucket]; !ok {
+	ss, ok := tx.pendingSortedSet(bucket)
+	if !ok {
 		return nil, ErrBucket
 	}
 
-	return tx.db.SortedSetIdx[bucket].GetByRankRange(start, end, false), nil
+	return ss.GetByRankRange(start, end), nil
 }
 
 // ZRem removes the specified members from the sorted set stored in one bucket at given bucket and key.
@@ -165,7 +205,7 @@ func (tx *Tx) ZRem(bucket, key string) error {
 		return ErrBucket
 	}
 
-	return tx.put(bucket, []byte(key), []byte(""), Persistent, DataZRemFlag, uint64(time.Now().Unix()), DataStructureSortedSet)
+	return tx.put(bucket, []byte(key), []byte(""), Persistent, DataZRemFlag, tx.db.timestamp(), DataStructureSortedSet)
 }
 
 // ZRemRangeByRank removes all elements in the sorted set stored in one bucket at given bucket with rank between start and end.
@@ -181,7 +221,98 @@ func (tx *Tx) ZRemRangeByRank(bucket string, start, end int) error {
 
 	newKey := strconv2.IntToStr(start)
 	newVal := strconv2.IntToStr(end)
-	return tx.put(bucket, []byte(newKey), []byte(newVal), Persistent, DataZRemRangeByRankFlag, uint64(time.Now().Unix()), DataStructureSortedSet)
+	return tx.put(bucket, []byte(newKey), []byte(newVal), Persistent, DataZRemRangeByRankFlag, tx.db.timestamp(), DataStructureSortedSet)
+}
+
+// ZRemRangeByScore removes all elements in the sorted set stored in one bucket at given bucket with a score
+// between start and end, within the bounds and up to the limit of opts as ZRangeByScore does, in a single record.
+func (tx *Tx) ZRemRangeByScore(bucket string, start, end float64, opts *zset.GetByScoreRangeOptions) error {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return err
+	}
+
+	if _, ok := tx.db.SortedSetIdx[bucket]; !ok {
+		return ErrBucket
+	}
+
+	if opts == nil {
+		opts = &zset.GetByScoreRangeOptions{}
+	}
+	newKey := encodeZScoreBound(start, opts.ExcludeStart)
+	newVal := encodeZScoreBound(end, opts.ExcludeEnd)
+	if opts.Limit > 0 {
+		newVal += SeparatorForZSetKey + strconv2.IntToStr(opts.Limit)
+	}
+	return tx.put(bucket, []byte(newKey), []byte(newVal), Persistent, DataZRemRangeByScoreFlag, tx.db.timestamp(), DataStructureSortedSet)
+}
+
+// ZRemRangeByLex removes all elements in the sorted set stored in one bucket at given bucket with a key
+// between start and end in the lexicographical order, both included, in a single record.
+// A nil start or end leaves the range unbounded on that side.
+func (tx *Tx) ZRemRangeByLex(bucket string, start, end []byte) error {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return err
+	}
+
+	if _, ok := tx.db.SortedSetIdx[bucket]; !ok {
+		return ErrBucket
+	}
+
+	return tx.put(bucket, encodeZLexBound(start, "-"), encodeZLexBound(end, "+"), Persistent, DataZRemRangeByLexFlag, tx.db.timestamp(), DataStructureSortedSet)
+}
+
+// splitZAddKey returns the key and the score of the member a DataZAddFlag record adds.
+func splitZAddKey(key []byte) (string, zset.SCORE) {
+	keyAndScore := strings.Split(string(key), SeparatorForZSetKey)
+	score, _ := strconv2.StrToFloat64(keyAndScore[1])
+	return keyAndScore[0], zset.SCORE(score)
+}
+
+// encodeZScoreBound encodes the score bound of ZRemRangeByScore, an excluded bound starts with "(".
+func encodeZScoreBound(score float64, exclude bool) string {
+	bound := strconv.FormatFloat(score, 'f', -1, 64)
+	if exclude {
+		return "(" + bound
+	}
+	return bound
+}
+
+// decodeZScoreRange decodes the range of scores of a DataZRemRangeByScoreFlag record.
+func decodeZScoreRange(key, value []byte) (start, end zset.SCORE, opts *zset.GetByScoreRangeOptions) {
+	opts = &zset.GetByScoreRangeOptions{}
+	decodeBound := func(bound string) (zset.SCORE, bool) {
+		exclude := strings.HasPrefix(bound, "(")
+		score, _ := strconv2.StrToFloat64(strings.TrimPrefix(bound, "("))
+		return zset.SCORE(score), exclude
+	}
+
+	endAndLimit := strings.SplitN(string(value), SeparatorForZSetKey, 2)
+	if len(endAndLimit) == 2 {
+		opts.Limit, _ = strconv2.StrToInt(endAndLimit[1])
+	}
+	start, opts.ExcludeStart = decodeBound(string(key))
+	end, opts.ExcludeEnd = decodeBound(endAndLimit[0])
+	return start, end, opts
+}
+
+// encodeZLexBound encodes the key bound of ZRemRangeByLex, the included bounds start with "[" and
+// the unbounded side is given by unbounded.
+func encodeZLexBound(bound []byte, unbounded string) []byte {
+	if bound == nil {
+		return []byte(unbounded)
+	}
+	return append([]byte("["), bound...)
+}
+
+// inZLexRange returns whether the key is within the bounds of a DataZRemRangeByLexFlag record.
+func inZLexRange(key string, start, end []byte) bool {
+	if start[0] == '[' && key < string(start[1:]) {
+		return false
+	}
+	if end[0] == '[' && key > string(end[1:]) {
+		return false
+	}
+	return true
 }
 
 // ZRank returns the rank of member in the sorted set stored in the bucket at given bucket and key,
@@ -191,11 +322,12 @@ func (tx *Tx) ZRank(bucket string, key []byte) (int, error) {
 		return 0, err
 	}
 
-	if _, ok := tx.db.SortedSetIdx[bucket]; !ok {
+	ss, ok := tx.pendingSortedSet(bucket)
+	if !ok {
 		return 0, ErrBucket
 	}
 
-	return tx.db.SortedSetIdx[bucket].FindRank(string(key)), nil
+	return ss.FindRank(string(key)), nil
 }
 
 // ZRevRank returns the rank of member in the sorted set stored in the bucket at given bucket and key,
@@ -205,11 +337,12 @@ func (tx *Tx) ZRevRank(bucket string, key []byte) (int, error) {
 		return 0, err
 	}
 
-	if _, ok := tx.db.SortedSetIdx[bucket]; !ok {
+	ss, ok := tx.pendingSortedSet(bucket)
+	if !ok {
 		return 0, ErrBucket
 	}
 
-	return tx.db.SortedSetIdx[bucket].FindRevRank(string(key)), nil
+	return ss.FindRevRank(string(key)), nil
 }
 
 // ZScore returns the score of member in the sorted set in the bucket at given bucket and key.
@@ -218,11 +351,12 @@ func (tx *Tx) ZScore(bucket string, key []byte) (float64, error) {
 		return 0, err
 	}
 
-	if _, ok := tx.db.SortedSetIdx[bucket]; !ok {
+	ss, ok := tx.pendingSortedSet(bucket)
+	if !ok {
 		return 0, ErrBucket
 	}
 
-	if node := tx.db.SortedSetIdx[bucket].GetByKey(string(key)); node != nil {
+	if node := ss.GetByKey(string(key)); node != nil {
 		return float64(node.Score()), nil
 	}
 
@@ -235,11 +369,12 @@ func (tx *Tx) ZGetByKey(bucket string, key []byte) (*zset.SortedSetNode, error)
 		return nil, err
 	}
 
-	if _, ok := tx.db.SortedSetIdx[bucket]; !ok {
+	ss, ok := tx.pendingSortedSet(bucket)
+	if !ok {
 		return nil, ErrBucket
 	}
 
-	if node := tx.db.SortedSetIdx[bucket].GetByKey(string(key)); node != nil {
+	if node := ss.GetByKey(string(key)); node != nil {
 		return node, nil
 	}
 
@@ -251,10 +386,11 @@ func (tx *Tx) ZKeys(bucket, pattern string, f func(key string) bool) error {
 	if err := tx.checkTxIsClosed(); err != nil {
 		return err
 	}
-	if _, ok := tx.db.SortedSetIdx[bucket]; !ok {
+	ss, ok := tx.pendingSortedSet(bucket)
+	if !ok {
 		return ErrBucket
 	}
-	for key := range tx.db.SortedSetIdx[bucket].Dict {
+	for key := range ss.Members() {
 		if end, err := MatchForRange(pattern, key, f); end || err != nil {
 			return err
 		}
@@ -262,6 +398,112 @@ func (tx *Tx) ZKeys(bucket, pattern string, f func(key string) bool) error {
 	return nil
 }
 
+// ZCompact replaces the records of the sorted set stored in the bucket by a single snapshot
+// of its current members, so that the next merge reclaims the replay records.
+// It must be called before any other write to the sorted set in the tx.
+func (tx *Tx) ZCompact(bucket string) error {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return err
+	}
+
+	sortedSet, ok := tx.db.SortedSetIdx[bucket]
+	if !ok {
+		return ErrBucket
+	}
+	for _, e := range tx.pendingWrites {
+		if e.Meta.Ds == DataStructureSortedSet && string(e.Bucket) == bucket {
+			return ErrCompactPending
+		}
+	}
+
+	nodes := sortedSet.GetByRankRange(1, -1, false)
+
+	return tx.put(bucket, []byte(zsetSnapshotKey), MarshalSortedSetNodes(nodes), Persistent, DataZSetSnapshotFlag, tx.db.timestamp(), DataStructureSortedSet)
+}
+
+// MarshalSortedSetNodes encodes the key, the score and the value of the nodes of a sorted set.
+func MarshalSortedSetNodes(nodes []*zset.SortedSetNode) []byte {
+	items := make([][]byte, 0, 3*len(nodes))
+	for _, n := range nodes {
+		items = appendSortedSetMember(items, n.Key(), n.Score(), n.Value)
+	}
+	return MarshalListItems(items)
+}
+
+// sortedSetMember is the copy of a node of a sorted set.
+type sortedSetMember struct {
+	key   string
+	score zset.SCORE
+	value []byte
+}
+
+// marshalSortedSetMembers encodes the members as MarshalSortedSetNodes encodes the nodes they were copied from.
+func marshalSortedSetMembers(members []sortedSetMember) []byte {
+	items := make([][]byte, 0, 3*len(members))
+	for _, m := range members {
+		items = appendSortedSetMember(items, m.key, m.score, m.value)
+	}
+	return MarshalListItems(items)
+}
+
+// appendSortedSetMember appends to items the key, the score and the value of a member of a sorted set.
+func appendSortedSetMember(items [][]byte, key string, score zset.SCORE, value []byte) [][]byte {
+	return append(items, []byte(key), []byte(strconv.FormatFloat(float64(score), 'f', -1, 64)), value)
+}
+
+// UnmarshalSortedSetNodes decodes the nodes encoded by MarshalSortedSetNodes into a new sorted set.
+func UnmarshalSortedSetNodes(data []byte) (*zset.SortedSet, error) {
+	items, err := UnmarshalListItems(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(items)%3 != 0 {
+		return nil, ErrListItemsCorrupted
+	}
+
+	ss := zset.New()
+	for i := 0; i < len(items); i += 3 {
+		score, err := strconv.ParseFloat(string(items[i+1]), 64)
+		if err != nil {
+			return nil, err
+		}
+		if err := ss.Put(string(items[i]), zset.SCORE(score), items[i+2]); err != nil {
+			return nil, err
+		}
+	}
+	return ss, nil
+}
+
+// marshalZMembers encodes the members added by ZAddBatch.
+func marshalZMembers(members []ZMember) []byte {
+	items := make([][]byte, 0, 3*len(members))
+	for _, m := range members {
+		items = append(items, m.Key, []byte(strconv.FormatFloat(m.Score, 'f', -1, 64)), m.Value)
+	}
+	return MarshalListItems(items)
+}
+
+// unmarshalZMembers decodes the members encoded by marshalZMembers.
+func unmarshalZMembers(data []byte) ([]ZMember, error) {
+	items, err := UnmarshalListItems(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(items)%3 != 0 {
+		return nil, ErrListItemsCorrupted
+	}
+
+	members := make([]ZMember, 0, len(items)/3)
+	for i := 0; i < len(items); i += 3 {
+		score, err := strconv.ParseFloat(string(items[i+1]), 64)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, ZMember{Key: items[i], Score: score, Value: items[i+2]})
+	}
+	return members, nil
+}
+
 // ErrSeparatorForZSetKey returns when zSet key contains the SeparatorForZSetKey flag.
 func ErrSeparatorForZSetKey() error {
 	return errors.New("contain separator (" + SeparatorForZSetKey + ") for ZSet key")
@@ -15,11 +15,13 @@
 package nutsdb
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"testing"
 
+	"github.com/nutsdb/nutsdb/ds/zset"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -477,6 +479,101 @@ func TestTx_ZRemRangeByRank(t *testing.T) {
 	assertions.Error(err, "TestTx_ZRemRangeByRank err")
 }
 
+func TestTx_ZRemRangeByScore(t *testing.T) {
+	InitForZSet()
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	bucket := "bucket_zrem_range_by_score"
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		for i, key := range []string{"a", "b", "c", "d", "e"} {
+			require.NoError(t, tx.ZAdd(bucket, []byte(key), float64(i+1), []byte("v"+key)))
+		}
+		return nil
+	}))
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		assert.Equal(t, ErrBucket, tx.ZRemRangeByScore("bucket_fake", 1, 2, nil))
+
+		require.NoError(t, tx.ZRemRangeByScore(bucket, 1, 3, &zset.GetByScoreRangeOptions{ExcludeStart: true, Limit: 1}))
+		card, err := tx.ZCard(bucket)
+		require.NoError(t, err)
+		assert.Equal(t, 4, card)
+		return tx.ZRemRangeByScore(bucket, 4, 5, &zset.GetByScoreRangeOptions{ExcludeEnd: true})
+	}))
+
+	check := func() {
+		require.NoError(t, db.View(func(tx *Tx) error {
+			nodes, err := tx.ZRangeByRank(bucket, 1, -1)
+			require.NoError(t, err)
+			require.Len(t, nodes, 3)
+			assert.Equal(t, "a", nodes[0].Key())
+			assert.Equal(t, "c", nodes[1].Key())
+			assert.Equal(t, "e", nodes[2].Key())
+			return nil
+		}))
+	}
+	check()
+
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	check()
+	require.NoError(t, db.Close())
+}
+
+func TestTx_ZRemRangeByLex(t *testing.T) {
+	InitForZSet()
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	bucket := "bucket_zrem_range_by_lex"
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		for _, key := range []string{"a", "b", "c", "d", "e"} {
+			require.NoError(t, tx.ZAdd(bucket, []byte(key), 0, []byte("v"+key)))
+		}
+		return nil
+	}))
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		assert.Equal(t, ErrBucket, tx.ZRemRangeByLex("bucket_fake", nil, nil))
+
+		require.NoError(t, tx.ZRemRangeByLex(bucket, []byte("b"), []byte("c")))
+		card, err := tx.ZCard(bucket)
+		require.NoError(t, err)
+		assert.Equal(t, 3, card)
+		return tx.ZRemRangeByLex(bucket, []byte("e"), nil)
+	}))
+
+	check := func() {
+		require.NoError(t, db.View(func(tx *Tx) error {
+			members, err := tx.ZMembers(bucket)
+			require.NoError(t, err)
+			require.Len(t, members, 2)
+			assert.Contains(t, members, "a")
+			assert.Contains(t, members, "d")
+			return nil
+		}))
+	}
+	check()
+
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	check()
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.ZRemRangeByLex(bucket, nil, nil)
+	}))
+	require.NoError(t, db.View(func(tx *Tx) error {
+		card, err := tx.ZCard(bucket)
+		require.NoError(t, err)
+		assert.Equal(t, 0, card)
+		return nil
+	}))
+	require.NoError(t, db.Close())
+}
+
 func TestTx_ZRank(t *testing.T) {
 	bucket, key1, key2, key3 := InitDataForZSet(t)
 	assertions := assert.New(t)
@@ -659,3 +756,236 @@ func TestTx_ZKeys(t *testing.T) {
 
 	tx.Commit()
 }
+
+func TestTx_ZCompact(t *testing.T) {
+	InitForZSet()
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	bucket := "myZSet"
+	err = db.Update(func(tx *Tx) error {
+		for i := 0; i < 5; i++ {
+			if err := tx.ZAdd(bucket, []byte(fmt.Sprintf("key%d", i)), float64(i), []byte(fmt.Sprintf("val%d", i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	err = db.Update(func(tx *Tx) error {
+		if err := tx.ZRem(bucket, "key1"); err != nil {
+			return err
+		}
+		return tx.ZAdd(bucket, []byte("key2"), 10.5, []byte("val2"))
+	})
+	require.NoError(t, err)
+
+	err = db.Update(func(tx *Tx) error {
+		if err := tx.ZCompact(bucket); err != nil {
+			return err
+		}
+		assert.Equal(t, ErrCompactPending, tx.ZCompact(bucket))
+		return nil
+	})
+	require.NoError(t, err)
+
+	check := func() {
+		err = db.View(func(tx *Tx) error {
+			nodes, err := tx.ZRangeByRank(bucket, 1, -1)
+			require.NoError(t, err)
+			var keys []string
+			for _, n := range nodes {
+				keys = append(keys, n.Key())
+			}
+			assert.Equal(t, []string{"key0", "key3", "key4", "key2"}, keys)
+			n, err := tx.ZGetByKey(bucket, []byte("key2"))
+			require.NoError(t, err)
+			assert.Equal(t, 10.5, float64(n.Score()))
+			assert.Equal(t, []byte("val2"), n.Value)
+			return nil
+		})
+		require.NoError(t, err)
+	}
+	check()
+
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	check()
+	require.NoError(t, db.Close())
+}
+
+func TestTx_ZAddBatch(t *testing.T) {
+	InitForZSet()
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	bucket := "bucket_zadd_batch"
+	tx, err := db.Begin(true)
+	require.NoError(t, err)
+	assert.Error(t, tx.ZAddBatch(bucket, ZMember{Key: []byte("a" + SeparatorForZSetKey), Score: 1}))
+	assert.Equal(t, ErrKeyEmpty, tx.ZAddBatch(bucket, ZMember{Score: 1}))
+	require.NoError(t, tx.ZAddBatch(bucket,
+		ZMember{Key: []byte("a"), Score: 1, Value: []byte("va")},
+		ZMember{Key: []byte("b"), Score: 2.5, Value: []byte("vb")},
+		ZMember{Key: []byte("c"), Score: -1, Value: []byte("vc")},
+	))
+	require.Len(t, tx.pendingWrites, 1)
+	require.NoError(t, tx.Commit())
+
+	check := func() {
+		require.NoError(t, db.View(func(tx *Tx) error {
+			num, err := tx.ZCard(bucket)
+			assert.NoError(t, err)
+			assert.Equal(t, 3, num)
+			n, err := tx.ZGetByKey(bucket, []byte("b"))
+			if assert.NoError(t, err) {
+				assert.Equal(t, 2.5, float64(n.Score()))
+				assert.Equal(t, []byte("vb"), n.Value)
+			}
+			n, err = tx.ZPeekMin(bucket)
+			if assert.NoError(t, err) {
+				assert.Equal(t, "c", n.Key())
+			}
+			return nil
+		}))
+	}
+
+	check()
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	check()
+	require.NoError(t, db.Close())
+}
+
+func TestTx_ZSetReadYourWrites(t *testing.T) {
+	InitForZSet()
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	bucket := "bucket_zset_read_your_writes"
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		require.NoError(t, tx.ZAdd(bucket, []byte("a"), 1, []byte("va")))
+		require.NoError(t, tx.ZAdd(bucket, []byte("b"), 2, []byte("vb")))
+
+		card, err := tx.ZCard(bucket)
+		require.NoError(t, err)
+		assert.Equal(t, 2, card)
+		node, err := tx.ZPeekMax(bucket)
+		require.NoError(t, err)
+		assert.Equal(t, "b", node.Key())
+		return nil
+	}))
+
+	require.Error(t, db.Update(func(tx *Tx) error {
+		require.NoError(t, tx.ZAdd(bucket, []byte("c"), 3, []byte("vc")))
+		require.NoError(t, tx.ZRem(bucket, "a"))
+
+		score, err := tx.ZScore(bucket, []byte("c"))
+		require.NoError(t, err)
+		assert.Equal(t, float64(3), score)
+		_, err = tx.ZGetByKey(bucket, []byte("a"))
+		assert.Equal(t, ErrNotFoundKey, err)
+		rank, err := tx.ZRank(bucket, []byte("c"))
+		require.NoError(t, err)
+		assert.Equal(t, 2, rank)
+
+		node, err := tx.ZPopMax(bucket)
+		require.NoError(t, err)
+		assert.Equal(t, "c", node.Key())
+		node, err = tx.ZPopMax(bucket)
+		require.NoError(t, err)
+		assert.Equal(t, "b", node.Key())
+		return errors.New("rolled back")
+	}))
+
+	require.NoError(t, db.View(func(tx *Tx) error {
+		nodes, err := tx.ZRangeByRank(bucket, 1, -1)
+		require.NoError(t, err)
+		require.Len(t, nodes, 2)
+		assert.Equal(t, "a", nodes[0].Key())
+		assert.Equal(t, "b", nodes[1].Key())
+		return nil
+	}))
+	require.NoError(t, db.Close())
+}
+
+func TestTx_ZSetReadYourWrites_OverCommitted(t *testing.T) {
+	InitForZSet()
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	bucket := "bucket_zset_read_your_writes_over_committed"
+	keys := func(nodes []*zset.SortedSetNode) []string {
+		res := make([]string, len(nodes))
+		for i, n := range nodes {
+			res[i] = n.Key()
+		}
+		return res
+	}
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		for i, key := range []string{"c1", "c2", "c3", "c4", "c5"} {
+			require.NoError(t, tx.ZAdd(bucket, []byte(key), float64(10*(i+1)), []byte("v"+key)))
+		}
+		return nil
+	}))
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		require.NoError(t, tx.ZAdd(bucket, []byte("p1"), 5, []byte("vp1")))
+		require.NoError(t, tx.ZAdd(bucket, []byte("p2"), 25, []byte("vp2")))
+		require.NoError(t, tx.ZAdd(bucket, []byte("c3"), 60, []byte("vc3'")))
+		require.NoError(t, tx.ZRem(bucket, "c2"))
+
+		card, err := tx.ZCard(bucket)
+		require.NoError(t, err)
+		assert.Equal(t, 6, card)
+		members, err := tx.ZMembers(bucket)
+		require.NoError(t, err)
+		assert.Len(t, members, 6)
+		assert.Equal(t, []byte("vc3'"), members["c3"].Value)
+		assert.NotContains(t, members, "c2")
+
+		nodes, err := tx.ZRangeByScore(bucket, 0, 100, nil)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"p1", "c1", "p2", "c4", "c5", "c3"}, keys(nodes))
+		nodes, err = tx.ZRangeByScore(bucket, 100, 0, &zset.GetByScoreRangeOptions{Limit: 3})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"c3", "c5", "c4"}, keys(nodes))
+		nodes, err = tx.ZRangeByScore(bucket, 10, 40, &zset.GetByScoreRangeOptions{Limit: 2, ExcludeStart: true})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"p2", "c4"}, keys(nodes))
+
+		node, err := tx.ZPopMin(bucket)
+		require.NoError(t, err)
+		assert.Equal(t, "p1", node.Key())
+		node, err = tx.ZPopMax(bucket)
+		require.NoError(t, err)
+		assert.Equal(t, "c3", node.Key())
+		node, err = tx.ZPeekMax(bucket)
+		require.NoError(t, err)
+		assert.Equal(t, "c5", node.Key())
+		_, err = tx.ZScore(bucket, []byte("c3"))
+		assert.Equal(t, ErrNotFoundKey, err)
+
+		// a read by rank copies the sorted set, the later writes follow the copy.
+		rank, err := tx.ZRank(bucket, []byte("p2"))
+		require.NoError(t, err)
+		assert.Equal(t, 2, rank)
+		require.NoError(t, tx.ZAdd(bucket, []byte("p3"), 45, []byte("vp3")))
+		require.NoError(t, tx.ZRemRangeByRank(bucket, 1, 1))
+		nodes, err = tx.ZRangeByRank(bucket, 1, -1)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"p2", "c4", "p3", "c5"}, keys(nodes))
+		return nil
+	}))
+
+	require.NoError(t, db.View(func(tx *Tx) error {
+		nodes, err := tx.ZRangeByRank(bucket, 1, -1)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"p2", "c4", "p3", "c5"}, keys(nodes))
+		return nil
+	}))
+	require.NoError(t, db.Close())
+}
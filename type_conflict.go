@@ -0,0 +1,121 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+)
+
+// ErrWrongType is returned when writing a key of a bucket already holding the key in another data structure
+// under the TypeConflictReject.
+var ErrWrongType = errors.New("the key holds a value of another data structure")
+
+// TypeConflictPolicy decides what a write does to a key of a bucket already holding the key
+// in another data structure.
+type TypeConflictPolicy uint8
+
+const (
+	// TypeConflictIgnore keeps the key in every data structure, each of them being independent.
+	TypeConflictIgnore TypeConflictPolicy = iota
+
+	// TypeConflictReject makes the write fail with ErrWrongType.
+	TypeConflictReject
+
+	// TypeConflictReplace removes the key from the other data structures in the tx of the write,
+	// so that the recovery replays the removal along with the write.
+	TypeConflictReplace
+)
+
+// keyedDataStructures are the data structures holding values by key, unlike the sorted sets
+// whose members belong to the bucket.
+var keyedDataStructures = []uint16{DataStructureBPTree, DataStructureList, DataStructureSet}
+
+// createsKey returns whether the write of given flag may create the key in the data structure ds.
+func createsKey(flag uint16, ds uint16) bool {
+	switch ds {
+	case DataStructureBPTree:
+		return flag == DataSetFlag
+	case DataStructureList:
+		return flag == DataLPushFlag || flag == DataRPushFlag
+	case DataStructureSet:
+		return flag == DataSetFlag || flag == DataSAddBatchFlag
+	}
+	return false
+}
+
+// checkTypeConflict applies the Options.TypeConflicts to a write of given flag creating the key
+// in the data structure ds. The entries carried over by Merge are written again as they are, so they are not checked.
+func (tx *Tx) checkTypeConflict(bucket string, key []byte, flag uint16, ds uint16) error {
+	policy := tx.db.opt.TypeConflicts
+	if policy == TypeConflictIgnore || tx.isMerge || !createsKey(flag, ds) {
+		return nil
+	}
+
+	for _, other := range keyedDataStructures {
+		if other == ds {
+			continue
+		}
+		exists, err := tx.hasKeyIn(bucket, key, other)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		if policy == TypeConflictReject {
+			return ErrWrongType
+		}
+		if err := tx.removeKeyFrom(bucket, key, other); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasKeyIn reports whether the key is live in the data structure ds of the bucket, with the pending writes of the tx.
+func (tx *Tx) hasKeyIn(bucket string, key []byte, ds uint16) (bool, error) {
+	switch ds {
+	case DataStructureBPTree:
+		return tx.pendingKeyExists(bucket, key)
+	case DataStructureList:
+		l, err := tx.pendingList(bucket, key)
+		if err == ErrBucket {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		size, _ := l.Size()
+		return size > 0, nil
+	case DataStructureSet:
+		s, ok := tx.pendingSet(bucket, key)
+		return ok && s.SHasKey(string(key)), nil
+	}
+	return false, nil
+}
+
+// removeKeyFrom writes the removal of the key from the data structure ds of the bucket.
+func (tx *Tx) removeKeyFrom(bucket string, key []byte, ds uint16) error {
+	now := tx.db.timestamp()
+	switch ds {
+	case DataStructureBPTree:
+		return tx.put(bucket, key, nil, Persistent, DataDeleteFlag, now, DataStructureBPTree)
+	case DataStructureList:
+		return tx.put(bucket, key, nil, Persistent, DataLCompactFlag, now, DataStructureList)
+	case DataStructureSet:
+		return tx.put(bucket, key, nil, Persistent, DataSetSnapshotFlag, now, DataStructureSet)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_TypeConflicts(t *testing.T) {
+	bucket := "bucket_type_conflicts"
+	key := []byte("key")
+
+	t.Run("ignore", func(t *testing.T) {
+		InitOpt("/tmp/nutsdbtesttypeconflicts", true)
+		db, err = Open(opt)
+		require.NoError(t, err)
+
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			require.NoError(t, tx.RPush(bucket, key, []byte("item")))
+			return tx.Put(bucket, key, []byte("value"), Persistent)
+		}))
+		require.NoError(t, db.View(func(tx *Tx) error {
+			size, err := tx.LSize(bucket, key)
+			require.NoError(t, err)
+			assert.Equal(t, 1, size)
+			return nil
+		}))
+		require.NoError(t, db.Close())
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		InitOpt("/tmp/nutsdbtesttypeconflicts", true)
+		db, err = Open(opt, WithTypeConflicts(TypeConflictReject))
+		require.NoError(t, err)
+
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.SAdd(bucket, key, []byte("member"))
+		}))
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			assert.Equal(t, ErrWrongType, tx.Put(bucket, key, []byte("value"), Persistent))
+			assert.Equal(t, ErrWrongType, tx.RPush(bucket, key, []byte("item")))
+			// the set itself is written as usual.
+			require.NoError(t, tx.SAdd(bucket, key, []byte("other")))
+			// the pending writes of the tx are seen.
+			require.NoError(t, tx.Put(bucket, []byte("other_key"), []byte("value"), Persistent))
+			assert.Equal(t, ErrWrongType, tx.LPush(bucket, []byte("other_key"), []byte("item")))
+			return nil
+		}))
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			require.NoError(t, tx.Delete(bucket, []byte("other_key")))
+			return tx.LPush(bucket, []byte("other_key"), []byte("item"))
+		}))
+		require.NoError(t, db.Close())
+	})
+
+	t.Run("replace", func(t *testing.T) {
+		InitOpt("/tmp/nutsdbtesttypeconflicts", true)
+		db, err = Open(opt, WithTypeConflicts(TypeConflictReplace))
+		require.NoError(t, err)
+
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			require.NoError(t, tx.RPush(bucket, key, []byte("item")))
+			return tx.SAdd(bucket, []byte("set_key"), []byte("member"))
+		}))
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			require.NoError(t, tx.Put(bucket, key, []byte("value"), Persistent))
+			return tx.Put(bucket, []byte("set_key"), []byte("value"), Persistent)
+		}))
+
+		check := func() {
+			require.NoError(t, db.View(func(tx *Tx) error {
+				e, err := tx.Get(bucket, key)
+				require.NoError(t, err)
+				assert.Equal(t, []byte("value"), e.Value)
+				_, err = tx.LSize(bucket, key)
+				assert.Error(t, err)
+				ok, err := tx.SHasKey(bucket, []byte("set_key"))
+				require.NoError(t, err)
+				assert.False(t, ok)
+				return nil
+			}))
+		}
+		check()
+
+		require.NoError(t, db.Close())
+		db, err = Open(opt, WithTypeConflicts(TypeConflictReplace))
+		require.NoError(t, err)
+		check()
+
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.LPush(bucket, key, []byte("item"))
+		}))
+		require.NoError(t, db.View(func(tx *Tx) error {
+			_, err := tx.Get(bucket, key)
+			assert.Error(t, err)
+			size, err := tx.LSize(bucket, key)
+			require.NoError(t, err)
+			assert.Equal(t, 1, size)
+			return nil
+		}))
+		require.NoError(t, db.Close())
+	})
+}
@@ -0,0 +1,89 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrUnknownEntry is returned when an entry of a data file has a flag or a data structure this version
+// does not know, e.g. as it was written by a newer one.
+var ErrUnknownEntry = errors.New("unknown entry flag or data structure")
+
+// UnknownEntryPolicy decides what Open does with the entries of unknown flags or data structures.
+type UnknownEntryPolicy uint8
+
+const (
+	// UnknownEntryFail makes Open fail with ErrUnknownEntry.
+	UnknownEntryFail UnknownEntryPolicy = iota
+
+	// UnknownEntrySkip leaves the entries out of the indexes, reports them to Options.OnUnknownEntry
+	// and keeps them in DB.SkippedEntries. The data files holding them are then never rewritten,
+	// Merge and CompactTombstones fail with ErrUnknownEntry rather than drop them.
+	UnknownEntrySkip
+)
+
+// lastDataFlag is the greatest flag known to this version.
+const lastDataFlag = DataZRemRangeByLexFlag
+
+// SkippedEntry is an entry of unknown flag or data structure left out of the indexes by Open.
+type SkippedEntry struct {
+	FileID int64
+	Offset int64
+
+	Bucket string
+	Key    []byte
+	Flag   uint16
+	Ds     uint16
+}
+
+// isKnownEntry returns whether the flag and the data structure of the entry are known to this version.
+func isKnownEntry(meta *MetaData) bool {
+	return meta.Flag <= lastDataFlag && meta.Ds <= DataStructureNone
+}
+
+// unknownEntryError returns the error of the unknown entry at given fID and off.
+func unknownEntryError(fID, off int64, meta *MetaData) error {
+	return fmt.Errorf("%w: data file %d at %d: flag %d, data structure %d", ErrUnknownEntry, fID, off, meta.Flag, meta.Ds)
+}
+
+// skipUnknownEntry applies the Options.UnknownEntries to the unknown entry read by Open at given fID and off.
+func (db *DB) skipUnknownEntry(entry *Entry, fID, off int64) error {
+	if db.opt.UnknownEntries != UnknownEntrySkip {
+		return unknownEntryError(fID, off, entry.Meta)
+	}
+
+	skipped := SkippedEntry{
+		FileID: fID,
+		Offset: off,
+		Bucket: string(entry.Bucket),
+		Key:    append([]byte(nil), entry.Key...),
+		Flag:   entry.Meta.Flag,
+		Ds:     entry.Meta.Ds,
+	}
+	db.skippedEntries = append(db.skippedEntries, skipped)
+	if db.opt.OnUnknownEntry != nil {
+		db.opt.OnUnknownEntry(context.Background(), skipped)
+	}
+	return nil
+}
+
+// SkippedEntries returns the entries of unknown flags or data structures Open left out of the indexes
+// under the UnknownEntrySkip, in the order of the data files.
+func (db *DB) SkippedEntries() []SkippedEntry {
+	return append([]SkippedEntry(nil), db.skippedEntries...)
+}
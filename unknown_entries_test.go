@@ -0,0 +1,87 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_UnknownEntries(t *testing.T) {
+	bucket := "bucket_unknown_entries"
+	unknownFlag := lastDataFlag + 1
+
+	InitOpt("/tmp/nutsdbtestunknownentries", true)
+	opt.SegmentSize = 1024
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		if err := tx.Put(bucket, []byte("known"), []byte("value"), Persistent); err != nil {
+			return err
+		}
+		// as written by a newer version.
+		return tx.put(bucket, []byte("unknown"), []byte("value"), Persistent, unknownFlag, uint64(time.Now().Unix()), DataStructureBPTree)
+	}))
+	for i := 0; i < 20; i++ {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.Put(bucket, []byte("filler"), make([]byte, 100), Persistent)
+		}))
+	}
+	require.NoError(t, db.Close())
+
+	// fails by default.
+	_, err = Open(opt)
+	assert.True(t, errors.Is(err, ErrUnknownEntry))
+
+	var reported []SkippedEntry
+	opt.UnknownEntries = UnknownEntrySkip
+	opt.OnUnknownEntry = func(ctx context.Context, entry SkippedEntry) {
+		reported = append(reported, entry)
+	}
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	skipped := db.SkippedEntries()
+	require.Len(t, skipped, 1)
+	assert.Equal(t, reported, skipped)
+	assert.Equal(t, bucket, skipped[0].Bucket)
+	assert.Equal(t, []byte("unknown"), skipped[0].Key)
+	assert.Equal(t, unknownFlag, skipped[0].Flag)
+	assert.Equal(t, DataStructureBPTree, skipped[0].Ds)
+
+	require.NoError(t, db.View(func(tx *Tx) error {
+		e, err := tx.Get(bucket, []byte("known"))
+		if err != nil {
+			return err
+		}
+		assert.Equal(t, []byte("value"), e.Value)
+		_, err = tx.Get(bucket, []byte("unknown"))
+		assert.Error(t, err)
+		return nil
+	}))
+
+	// the data file holding the unknown entry is never rewritten.
+	assert.True(t, errors.Is(db.Merge(), ErrUnknownEntry))
+	_, err = os.Stat(db.getDataPath(skipped[0].FileID))
+	assert.NoError(t, err)
+	require.NoError(t, db.Close())
+}
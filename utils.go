@@ -72,6 +72,33 @@ func UnmarshalInts(data []byte) ([]int, error) {
 	return ints, nil
 }
 
+// MarshalListItems encodes the items of a list, each item is prefixed by its length.
+func MarshalListItems(items [][]byte) []byte {
+	buf := make([]byte, 0, len(items)*binary.MaxVarintLen64)
+	lenBuf := make([]byte, binary.MaxVarintLen64)
+	for _, item := range items {
+		n := binary.PutUvarint(lenBuf, uint64(len(item)))
+		buf = append(buf, lenBuf[:n]...)
+		buf = append(buf, item...)
+	}
+	return buf
+}
+
+// UnmarshalListItems decodes the items encoded by MarshalListItems.
+func UnmarshalListItems(data []byte) ([][]byte, error) {
+	var items [][]byte
+	for len(data) > 0 {
+		size, n := binary.Uvarint(data)
+		if n <= 0 || uint64(len(data)-n) < size {
+			return nil, ErrListItemsCorrupted
+		}
+		data = data[n:]
+		items = append(items, data[:size:size])
+		data = data[size:]
+	}
+	return items, nil
+}
+
 func MatchForRange(pattern, key string, f func(key string) bool) (end bool, err error) {
 	match, err := filepath.Match(pattern, key)
 	if err != nil {
@@ -57,6 +57,20 @@ func TestMarshalInts(t *testing.T) {
 	assertions.Equal(3, ints[1], "TestMarshalInts")
 }
 
+func TestMarshalListItems(t *testing.T) {
+	items := [][]byte{[]byte("a"), {}, []byte("ccc")}
+	decoded, err := UnmarshalListItems(MarshalListItems(items))
+	assert.NoError(t, err)
+	assert.Equal(t, items, decoded)
+
+	decoded, err = UnmarshalListItems(MarshalListItems(nil))
+	assert.NoError(t, err)
+	assert.Len(t, decoded, 0)
+
+	_, err = UnmarshalListItems([]byte{5, 'a'})
+	assert.Equal(t, ErrListItemsCorrupted, err)
+}
+
 func TestMatchForRange(t *testing.T) {
 	assertions := assert.New(t)
 
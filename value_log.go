@@ -0,0 +1,433 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrValueLogCorrupted is returned when a value read from the value log fails its checksum,
+// or is not the one of the key pointing to it.
+var ErrValueLogCorrupted = errors.New("the value log is corrupted")
+
+const (
+	// valueLogSuffix is the suffix of the value log files.
+	valueLogSuffix = ".vlog"
+
+	// valueLogHeaderSize is the size of the header of a value log record.
+	valueLogHeaderSize = 16
+)
+
+// valuePointerSize is the size of a pointer to a value in the value log: the id of the value log file,
+// the offset of the record, the size of the value and the Compression of the value.
+const valuePointerSize = 8 + 8 + 4 + 1
+
+// valuePointer is the position of a value in the value log.
+type valuePointer struct {
+	fileID int64
+	offset int64
+	size   uint32
+	codec  Compression
+}
+
+func (p valuePointer) encode() []byte {
+	buf := make([]byte, valuePointerSize)
+	binary.BigEndian.PutUint64(buf, uint64(p.fileID))
+	binary.BigEndian.PutUint64(buf[8:], uint64(p.offset))
+	binary.BigEndian.PutUint32(buf[16:], p.size)
+	buf[20] = byte(p.codec)
+	return buf
+}
+
+// isValuePointer returns whether the value of the entry is a pointer to a value in the value log.
+func isValuePointer(e *Entry) bool {
+	return e.Meta.valueKind() == valueKindPointer && len(e.Value) == valuePointerSize
+}
+
+func decodeValuePointer(value []byte) valuePointer {
+	return valuePointer{
+		fileID: int64(binary.BigEndian.Uint64(value)),
+		offset: int64(binary.BigEndian.Uint64(value[8:])),
+		size:   binary.BigEndian.Uint32(value[16:]),
+		codec:  Compression(value[20]),
+	}
+}
+
+// valueLog holds the values larger than Options.ValueThreshold apart from the data files, so that Merge only
+// rewrites the pointers to them. Its files are only appended to, a record is
+//
+//	| crc uint32 | bucketSize uint32 | keySize uint32 | valueSize uint32 | bucket | key | value |
+//
+// the bucket and the key let RunValueLogGC find whether the value is still pointed to.
+type valueLog struct {
+	dir     string
+	maxSize int64
+
+	mu        sync.Mutex
+	files     map[int64]*os.File
+	loaded    bool // whether the active file was looked up
+	activeID  int64
+	activeOff int64
+}
+
+func newValueLog(dir string, maxSize int64) *valueLog {
+	return &valueLog{dir: dir, maxSize: maxSize, files: make(map[int64]*os.File)}
+}
+
+func (vl *valueLog) path(fID int64) string {
+	return filepath.Join(vl.dir, strconv.FormatInt(fID, 10)+valueLogSuffix)
+}
+
+// ids returns the ids of the value log files in order.
+func (vl *valueLog) ids() []int64 {
+	files, _ := ioutil.ReadDir(vl.dir)
+
+	var ids []int64
+	for _, f := range files {
+		name := f.Name()
+		if !strings.HasSuffix(name, valueLogSuffix) {
+			continue
+		}
+		if id, err := strconv.ParseInt(strings.TrimSuffix(name, valueLogSuffix), 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
+// file returns the opened value log file at given fID, vl.mu must be held.
+func (vl *valueLog) file(fID int64, create bool) (*os.File, error) {
+	if f, ok := vl.files[fID]; ok {
+		return f, nil
+	}
+
+	flag := os.O_RDWR
+	if create {
+		flag |= os.O_CREATE
+	}
+	f, err := os.OpenFile(vl.path(fID), flag, 0644)
+	if err != nil {
+		return nil, err
+	}
+	vl.files[fID] = f
+	return f, nil
+}
+
+// loadActive looks up the last value log file to append to, vl.mu must be held.
+func (vl *valueLog) loadActive() error {
+	if vl.loaded {
+		return nil
+	}
+
+	if ids := vl.ids(); len(ids) > 0 {
+		vl.activeID = ids[len(ids)-1]
+		fi, err := os.Stat(vl.path(vl.activeID))
+		if err != nil {
+			return err
+		}
+		vl.activeOff = fi.Size()
+	}
+	vl.loaded = true
+	return nil
+}
+
+// activeFileID returns the id of the value log file appended to.
+func (vl *valueLog) activeFileID() (int64, error) {
+	vl.mu.Lock()
+	defer vl.mu.Unlock()
+
+	return vl.activeID, vl.loadActive()
+}
+
+// append appends the value of the key of the bucket and returns the pointer to it.
+func (vl *valueLog) append(bucket string, key, value []byte) (valuePointer, error) {
+	vl.mu.Lock()
+	defer vl.mu.Unlock()
+
+	if err := vl.loadActive(); err != nil {
+		return valuePointer{}, err
+	}
+
+	size := int64(valueLogHeaderSize + len(bucket) + len(key) + len(value))
+	if vl.activeOff > 0 && vl.activeOff+size > vl.maxSize {
+		// only the file appended to is synced by the commits.
+		if f, ok := vl.files[vl.activeID]; ok {
+			if err := f.Sync(); err != nil {
+				return valuePointer{}, err
+			}
+		}
+		vl.activeID++
+		vl.activeOff = 0
+	}
+	f, err := vl.file(vl.activeID, true)
+	if err != nil {
+		return valuePointer{}, err
+	}
+
+	buf := make([]byte, size)
+	binary.LittleEndian.PutUint32(buf[4:8], uint32(len(bucket)))
+	binary.LittleEndian.PutUint32(buf[8:12], uint32(len(key)))
+	binary.LittleEndian.PutUint32(buf[12:16], uint32(len(value)))
+	n := valueLogHeaderSize + copy(buf[valueLogHeaderSize:], bucket)
+	n += copy(buf[n:], key)
+	copy(buf[n:], value)
+	binary.LittleEndian.PutUint32(buf[0:4], crc32.ChecksumIEEE(buf[4:]))
+
+	if _, err := f.WriteAt(buf, vl.activeOff); err != nil {
+		return valuePointer{}, err
+	}
+	p := valuePointer{fileID: vl.activeID, offset: vl.activeOff, size: uint32(len(value))}
+	vl.activeOff += size
+	return p, nil
+}
+
+// read returns the value of the key of the bucket the pointer points to, and false when the record
+// pointed to is not the one of the key.
+func (vl *valueLog) read(bucket string, key []byte, p valuePointer) ([]byte, bool, error) {
+	vl.mu.Lock()
+	f, err := vl.file(p.fileID, false)
+	vl.mu.Unlock()
+	if err != nil {
+		return nil, false, err
+	}
+
+	buf := make([]byte, valueLogHeaderSize+len(bucket)+len(key)+int(p.size))
+	if _, err := f.ReadAt(buf, p.offset); err != nil {
+		if err == io.EOF {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	if int(binary.LittleEndian.Uint32(buf[4:8])) != len(bucket) || int(binary.LittleEndian.Uint32(buf[8:12])) != len(key) ||
+		binary.LittleEndian.Uint32(buf[12:16]) != p.size {
+		return nil, false, nil
+	}
+	if crc32.ChecksumIEEE(buf[4:]) != binary.LittleEndian.Uint32(buf[0:4]) {
+		return nil, false, ErrValueLogCorrupted
+	}
+	n := valueLogHeaderSize + len(bucket)
+	if string(buf[valueLogHeaderSize:n]) != bucket || string(buf[n:n+len(key)]) != string(key) {
+		return nil, false, nil
+	}
+	return buf[n+len(key):], true, nil
+}
+
+// sync syncs the value log file appended to.
+func (vl *valueLog) sync() error {
+	vl.mu.Lock()
+	defer vl.mu.Unlock()
+
+	if f, ok := vl.files[vl.activeID]; ok {
+		return f.Sync()
+	}
+	return nil
+}
+
+// remove removes the value log file at given fID.
+func (vl *valueLog) remove(fID int64) error {
+	vl.mu.Lock()
+	defer vl.mu.Unlock()
+
+	if f, ok := vl.files[fID]; ok {
+		_ = f.Close()
+		delete(vl.files, fID)
+	}
+	return os.Remove(vl.path(fID))
+}
+
+// close syncs and closes the value log files.
+func (vl *valueLog) close() error {
+	vl.mu.Lock()
+	defer vl.mu.Unlock()
+
+	var err error
+	for fID, f := range vl.files {
+		if fID == vl.activeID {
+			err = f.Sync()
+		}
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		delete(vl.files, fID)
+	}
+	return err
+}
+
+// separateValue returns the value to write for the key of the bucket with the flag, its codec and its valueKind:
+// a plain value larger than Options.ValueThreshold is appended to the value log and replaced by a pointer to it,
+// which holds the codec.
+func (tx *Tx) separateValue(bucket string, key, value []byte, flag uint16, codec Compression, kind valueKind) ([]byte, Compression, valueKind, error) {
+	threshold := tx.db.opt.ValueThreshold
+	if threshold <= 0 || tx.isMerge || kind != valueKindPlain || flag != DataSetFlag || tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode ||
+		tx.db.keys.enabled() || int64(len(value)) <= threshold || len(value) <= valuePointerSize {
+		return value, codec, kind, nil
+	}
+
+	p, err := tx.db.vlog.append(bucket, key, value)
+	if err != nil {
+		return nil, CompressionNone, valueKindPlain, err
+	}
+	tx.valueLogWritten = true
+	p.codec = codec
+	return p.encode(), CompressionNone, valueKindPointer, nil
+}
+
+// readValueLog returns the entry with the value it points to in the value log.
+func (db *DB) readValueLog(bucket string, e *Entry) (*Entry, error) {
+	p := decodeValuePointer(e.Value)
+	value, ok, err := db.vlog.read(bucket, e.Key, p)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrValueLogCorrupted
+	}
+	if value, err = decompress(p.codec, value); err != nil {
+		return nil, err
+	}
+
+	meta := *e.Meta
+	meta.setValueKind(valueKindPlain)
+	meta.ValueSize = uint32(len(value))
+	return &Entry{Key: e.Key, Value: value, Bucket: e.Bucket, Meta: &meta}, nil
+}
+
+// RunValueLogGC rewrites the oldest value log file, before the one appended to, of which at least discardRatio
+// of the bytes are values no longer pointed to: the values still pointed to are appended again to the value log
+// and the file is removed. Like CompactTombstones it rewrites a single file in a single tx, so the writes only
+// wait for that file. It returns whether a file was rewritten.
+func (db *DB) RunValueLogGC(discardRatio float64) (bool, error) {
+	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return false, ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	for _, fID := range db.vlog.ids() {
+		tx, err := db.Begin(true)
+		if err != nil {
+			return false, err
+		}
+
+		activeID, err := db.vlog.activeFileID()
+		if err != nil || fID >= activeID {
+			_ = tx.Rollback()
+			return false, err
+		}
+
+		rewritten, err := tx.rewriteValueLogFile(fID, discardRatio)
+		if err != nil {
+			_ = tx.Rollback()
+			return false, err
+		}
+		if !rewritten {
+			_ = tx.Rollback()
+			continue
+		}
+
+		if err := tx.Commit(); err != nil {
+			return false, err
+		}
+		return true, db.vlog.remove(fID)
+	}
+	return false, nil
+}
+
+// valueLogRecord is a value of a value log file still pointed to.
+type valueLogRecord struct {
+	bucket string
+	key    []byte
+	value  []byte
+	meta   *MetaData
+	codec  Compression
+}
+
+// rewriteValueLogFile writes to the tx the values of the value log file at given fID still pointed to,
+// when at least discardRatio of the bytes of the file are not. It returns whether it wrote them.
+func (tx *Tx) rewriteValueLogFile(fID int64, discardRatio float64) (bool, error) {
+	f, err := os.Open(filepath.Clean(tx.db.vlog.path(fID)))
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	var (
+		r         = bufio.NewReader(f)
+		off, live int64
+		records   []valueLogRecord
+		header    = make([]byte, valueLogHeaderSize)
+	)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return false, err
+		}
+		bucketSize := int(binary.LittleEndian.Uint32(header[4:8]))
+		keySize := int(binary.LittleEndian.Uint32(header[8:12]))
+		valueSize := binary.LittleEndian.Uint32(header[12:16])
+		payload := make([]byte, bucketSize+keySize+int(valueSize))
+		if _, err := io.ReadFull(r, payload); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return false, err
+		}
+		size := int64(valueLogHeaderSize + len(payload))
+
+		bucket, key := string(payload[:bucketSize]), payload[bucketSize:bucketSize+keySize]
+		e, err := tx.lookupStored(bucket, key)
+		if err == nil && isValuePointer(e) {
+			if p := decodeValuePointer(e.Value); p.fileID == fID && p.offset == off && p.size == valueSize {
+				records = append(records, valueLogRecord{bucket: bucket, key: key, value: payload[bucketSize+keySize:], meta: e.Meta, codec: p.codec})
+				live += size
+			}
+		}
+		off += size
+	}
+
+	if off == 0 || float64(off-live) < discardRatio*float64(off) {
+		return false, nil
+	}
+
+	tx.isMerge = true
+	for _, rec := range records {
+		p, err := tx.db.vlog.append(rec.bucket, rec.key, rec.value)
+		if err != nil {
+			return false, err
+		}
+		tx.valueLogWritten = true
+		p.codec = rec.codec
+		if err := tx.putValue(rec.bucket, rec.key, p.encode(), rec.meta.TTL, DataSetFlag, rec.meta.Timestamp, DataStructureBPTree, valueKindPointer); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,167 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_ValueThreshold(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestvlog", true)
+	opt.SegmentSize = 1024
+	opt.ValueThreshold = 64
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	bucket := "blobs"
+	key := func(i int) []byte {
+		return []byte(fmt.Sprintf("blob_%02d", i))
+	}
+	value := func(i, version int) []byte {
+		return bytes.Repeat([]byte(fmt.Sprintf("%02d-%d;", i, version)), 100)
+	}
+	put := func(version int) {
+		for i := 0; i < 20; i++ {
+			require.NoError(t, db.Update(func(tx *Tx) error {
+				return tx.Put(bucket, key(i), value(i, version), Persistent)
+			}))
+		}
+	}
+	check := func(version int) {
+		require.NoError(t, db.View(func(tx *Tx) error {
+			for i := 0; i < 20; i++ {
+				e, err := tx.Get(bucket, key(i))
+				require.NoError(t, err)
+				assert.Equal(t, value(i, version), e.Value)
+			}
+			e, err := tx.Get(bucket, []byte("small"))
+			require.NoError(t, err)
+			assert.Equal(t, []byte("value"), e.Value)
+
+			es, _, err := tx.PrefixScan(bucket, []byte("blob_"), 0, 100)
+			require.NoError(t, err)
+			require.Len(t, es, 20)
+			assert.Equal(t, value(0, version), es[0].Value)
+
+			it := NewIterator(tx, bucket, IteratorOptions{PrefetchSize: 4})
+			n := 0
+			for {
+				ok, err := it.SetNext()
+				require.NoError(t, err)
+				if !ok {
+					break
+				}
+				if bytes.HasPrefix(it.Entry().Key, []byte("blob_")) {
+					assert.Equal(t, value(n, version), it.Entry().Value)
+					n++
+				}
+			}
+			assert.Equal(t, 20, n)
+			return nil
+		}))
+	}
+
+	put(0)
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.Put(bucket, []byte("small"), []byte("value"), Persistent)
+	}))
+	check(0)
+
+	// the data files only hold the pointers.
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		e, err := tx.lookupStored(bucket, key(0))
+		require.NoError(t, err)
+		assert.True(t, isValuePointer(e))
+		return tx.Put(bucket, []byte("lookalike"), e.Value, Persistent)
+	}))
+	// a value with the bytes of a pointer is not one.
+	require.NoError(t, db.View(func(tx *Tx) error {
+		stored, err := tx.lookupStored(bucket, key(0))
+		require.NoError(t, err)
+		e, err := tx.Get(bucket, []byte("lookalike"))
+		require.NoError(t, err)
+		assert.Equal(t, stored.Value, e.Value)
+		return nil
+	}))
+	ids := db.vlog.ids()
+	require.True(t, len(ids) > 1)
+
+	require.NoError(t, db.Merge())
+	check(0)
+
+	// the sealed value log files only hold values no longer pointed to once all the keys are overwritten.
+	put(1)
+	rewritten, err := db.RunValueLogGC(0.5)
+	require.NoError(t, err)
+	assert.True(t, rewritten)
+	_, err = os.Stat(db.vlog.path(ids[0]))
+	assert.True(t, os.IsNotExist(err))
+	check(1)
+
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	check(1)
+
+	for {
+		rewritten, err := db.RunValueLogGC(0.5)
+		require.NoError(t, err)
+		if !rewritten {
+			break
+		}
+	}
+	check(1)
+	require.NoError(t, db.Close())
+}
+
+func TestDB_RunValueLogGC_KeepsLiveValues(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestvloggc", true)
+	opt.SegmentSize = 2 * 1024
+	opt.ValueThreshold = 64
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	bucket := "blobs"
+	live := bytes.Repeat([]byte("live;"), 100)
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.Put(bucket, []byte("live"), live, Persistent)
+	}))
+	for i := 0; i < 10; i++ {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.Put(bucket, []byte("dead"), bytes.Repeat([]byte{byte(i)}, 500), Persistent)
+		}))
+	}
+
+	rewritten, err := db.RunValueLogGC(0.5)
+	require.NoError(t, err)
+	assert.True(t, rewritten)
+
+	require.NoError(t, db.View(func(tx *Tx) error {
+		e, err := tx.Get(bucket, []byte("live"))
+		require.NoError(t, err)
+		assert.Equal(t, live, e.Value)
+		ptr, err := tx.lookupStored(bucket, []byte("live"))
+		require.NoError(t, err)
+		assert.NotEqual(t, int64(0), decodeValuePointer(ptr.Value).fileID)
+		return nil
+	}))
+	require.NoError(t, db.Close())
+}
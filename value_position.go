@@ -0,0 +1,101 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrValuePending is returned by ValuePosition when the value of the key is written by the tx,
+// and so is not in the data files yet.
+var ErrValuePending = errors.New("the value is not written to the data files yet")
+
+// ValuePosition locates the entry holding the value of a key in the data files, for external tools
+// reading the values from there. The entry is encoded as documented by Entry.Encode, its value is
+// its last ValueSize bytes.
+type ValuePosition struct {
+	FileID    int64
+	Offset    int64
+	Size      int64
+	ValueSize int64
+}
+
+// valueMove is the rewrite of the value of a key by a merge, reported to Options.OnValueMoved.
+type valueMove struct {
+	bucket   string
+	key      []byte
+	from, to ValuePosition
+}
+
+// hintPosition returns the position of the entry the hint points to.
+func hintPosition(h *Hint) ValuePosition {
+	return ValuePosition{
+		FileID:    h.FileID,
+		Offset:    int64(h.DataPos),
+		Size:      DataEntryHeaderSize + h.Meta.PayloadSize(),
+		ValueSize: int64(h.Meta.ValueSize),
+	}
+}
+
+// ValuePosition returns the position in the data files of the live value of the key in the bucket.
+// The position stays valid until a merge moves the value, which Options.OnValueMoved reports.
+func (tx *Tx) ValuePosition(bucket string, key []byte) (ValuePosition, error) {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return ValuePosition{}, err
+	}
+	if tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return ValuePosition{}, ErrNotSupportHintBPTSparseIdxMode
+	}
+
+	if _, ok, err := tx.pendingGet(bucket, key); ok {
+		if err == nil {
+			err = ErrValuePending
+		}
+		return ValuePosition{}, err
+	}
+
+	r, err := tx.findRecord(bucket, key)
+	if err != nil {
+		return ValuePosition{}, err
+	}
+	return hintPosition(r.H), nil
+}
+
+// recordValueMove records the move of the live value of the key the merge rewrites with the entry
+// written at offset in the data file at fileID, before the index points to the new entry.
+func (tx *Tx) recordValueMove(bucket string, entry *Entry, fileID, offset int64) {
+	idx, ok := tx.db.BPTreeIdx[bucket]
+	if !ok || idx == nil {
+		return
+	}
+	r, err := idx.Find(entry.Key)
+	if err != nil || r.H.Meta.Flag == DataDeleteFlag {
+		return
+	}
+
+	var to ValuePosition
+	if entry.Meta.Flag != DataDeleteFlag {
+		to = ValuePosition{FileID: fileID, Offset: offset, Size: entry.Size(), ValueSize: int64(entry.Meta.ValueSize)}
+	}
+	tx.valueMoves = append(tx.valueMoves, valueMove{bucket: bucket, key: entry.Key, from: hintPosition(r.H), to: to})
+}
+
+// notifyValueMoves passes the moves committed by a merge to Options.OnValueMoved, along with the ctx of the merge tx.
+func (db *DB) notifyValueMoves(ctx context.Context, moves []valueMove) {
+	for _, m := range moves {
+		db.opt.OnValueMoved(ctx, m.bucket, m.key, m.from, m.to)
+	}
+}
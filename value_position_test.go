@@ -0,0 +1,89 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readPositionValue reads the value of the entry at the position from the data files.
+func readPositionValue(t *testing.T, db *DB, pos ValuePosition) []byte {
+	data, err := ioutil.ReadFile(db.getDataPath(pos.FileID))
+	require.NoError(t, err)
+	entry := data[pos.Offset : pos.Offset+pos.Size]
+	return entry[pos.Size-pos.ValueSize:]
+}
+
+func TestTx_ValuePosition(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestvalueposition", true)
+	opt.SegmentSize = 120
+	opt.CompactionFilter = func(ctx context.Context, bucket string, key, value []byte, meta *MetaData) (CompactionDecision, []byte) {
+		if string(key) == "key_remove" {
+			return CompactionRemove, nil
+		}
+		return CompactionKeep, nil
+	}
+	moves := make(map[string][2]ValuePosition)
+	opt.OnValueMoved = func(ctx context.Context, bucket string, key []byte, from, to ValuePosition) {
+		// the moves are reported with the ctx of the merge tx.
+		assert.NotNil(t, ctx.Value(txCtxKey{}))
+		moves[string(key)] = [2]ValuePosition{from, to}
+	}
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	bucket := "bucket_value_position"
+	positions := make(map[string]ValuePosition)
+	for _, key := range []string{"key_keep", "key_remove"} {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			require.NoError(t, tx.Put(bucket, []byte(key), []byte("value_of_"+key), Persistent))
+			_, err := tx.ValuePosition(bucket, []byte(key))
+			assert.Equal(t, ErrValuePending, err)
+			return nil
+		}))
+		require.NoError(t, db.View(func(tx *Tx) error {
+			pos, err := tx.ValuePosition(bucket, []byte(key))
+			require.NoError(t, err)
+			assert.Equal(t, []byte("value_of_"+key), readPositionValue(t, db, pos))
+			positions[key] = pos
+			return nil
+		}))
+	}
+
+	require.NoError(t, db.Merge())
+
+	require.Contains(t, moves, "key_keep")
+	assert.Equal(t, positions["key_keep"], moves["key_keep"][0])
+	require.Contains(t, moves, "key_remove")
+	assert.Equal(t, positions["key_remove"], moves["key_remove"][0])
+	assert.Equal(t, ValuePosition{}, moves["key_remove"][1])
+
+	require.NoError(t, db.View(func(tx *Tx) error {
+		pos, err := tx.ValuePosition(bucket, []byte("key_keep"))
+		require.NoError(t, err)
+		assert.Equal(t, moves["key_keep"][1], pos)
+		assert.Equal(t, []byte("value_of_key_keep"), readPositionValue(t, db, pos))
+
+		_, err = tx.ValuePosition(bucket, []byte("key_remove"))
+		assert.Error(t, err)
+		return nil
+	}))
+	require.NoError(t, db.Close())
+}
@@ -0,0 +1,239 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/nutsdb/nutsdb/ds/hnsw"
+)
+
+const vectorBucketKind = "vector"
+
+// vectorSearchEf is the number of candidates considered by the searches of the vector indexes.
+const vectorSearchEf = 64
+
+var (
+	// ErrVectorDimension is returned by VAdd and VSearch when the vector has not the dimension
+	// of the vectors of the bucket.
+	ErrVectorDimension = errors.New("the vector has not the dimension of the vectors of the bucket")
+
+	// ErrVectorEmpty is returned by VAdd and VSearch when the vector is empty.
+	ErrVectorEmpty = errors.New("the vector is empty")
+)
+
+// VectorHit is a key found by VSearch, with the cosine distance of its vector to the query.
+type VectorHit struct {
+	Key      []byte
+	Distance float32
+}
+
+// vectorBucketPrefix is the prefix of the internal buckets storing the vectors of the buckets.
+var vectorBucketPrefix = internalBucket(vectorBucketKind, "")
+
+// VAdd sets the vector of the key in the vector bucket, all the vectors of a bucket have the same dimension.
+// The vectors are stored in an internal bucket, and indexed in memory by a HNSW graph for VSearch,
+// which is rebuilt from them on Open. The vector buckets are experimental.
+func (tx *Tx) VAdd(bucket string, key []byte, vector []float32) error {
+	if err := tx.checkVectors(); err != nil {
+		return err
+	}
+	if len(vector) == 0 {
+		return ErrVectorEmpty
+	}
+	if dim := tx.vectorDim(bucket); dim != 0 && dim != len(vector) {
+		return ErrVectorDimension
+	}
+
+	return tx.put(internalBucket(vectorBucketKind, bucket), key, encodeVector(vector), Persistent, DataSetFlag,
+		tx.db.timestamp(), DataStructureBPTree)
+}
+
+// VRem removes the vector of the key from the vector bucket.
+func (tx *Tx) VRem(bucket string, key []byte) error {
+	if err := tx.checkVectors(); err != nil {
+		return err
+	}
+
+	vectorBucket := internalBucket(vectorBucketKind, bucket)
+	if _, err := tx.lookup(vectorBucket, key); err != nil {
+		return err
+	}
+	return tx.put(vectorBucket, key, nil, Persistent, DataDeleteFlag, tx.db.timestamp(), DataStructureBPTree)
+}
+
+// VSearch returns the k keys of the vector bucket whose vectors are the nearest to the query by
+// the cosine distance, the nearest first. The search is approximate, a few of the nearest keys may be
+// missed. The writes of the tx are seen.
+func (tx *Tx) VSearch(bucket string, query []float32, k int) ([]VectorHit, error) {
+	if err := tx.checkVectors(); err != nil {
+		return nil, err
+	}
+	if len(query) == 0 {
+		return nil, ErrVectorEmpty
+	}
+
+	idx := tx.db.vectors[bucket]
+	pending := tx.pendingKeys[internalBucket(vectorBucketKind, bucket)]
+	if pending != nil && pending.dropped {
+		idx = nil
+	}
+	dim := tx.vectorDim(bucket)
+	if dim == 0 {
+		return nil, ErrNotFoundBucket
+	}
+	if dim != len(query) {
+		return nil, ErrVectorDimension
+	}
+
+	var hits []VectorHit
+	if idx != nil {
+		n := k
+		if pending != nil {
+			n += len(pending.keys)
+		}
+		for _, r := range idx.Search(query, n, vectorSearchEf) {
+			if pending != nil {
+				if _, ok := pending.keys[r.Key]; ok {
+					continue
+				}
+			}
+			hits = append(hits, VectorHit{Key: []byte(r.Key), Distance: r.Distance})
+		}
+	}
+	if pending != nil {
+		for key, e := range pending.keys {
+			if e.Meta.Flag != DataSetFlag {
+				continue
+			}
+			hits = append(hits, VectorHit{Key: []byte(key), Distance: hnsw.CosineDistance(query, decodeVector(e.Value))})
+		}
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Distance != hits[j].Distance {
+			return hits[i].Distance < hits[j].Distance
+		}
+		return string(hits[i].Key) < string(hits[j].Key)
+	})
+	if len(hits) > k {
+		hits = hits[:k]
+	}
+	return hits, nil
+}
+
+func (tx *Tx) checkVectors() error {
+	if err := tx.checkTxIsClosed(); err != nil {
+		return err
+	}
+	if tx.db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return ErrNotSupportHintBPTSparseIdxMode
+	}
+	return nil
+}
+
+// vectorDim returns the dimension of the vectors of the bucket as seen by the tx, zero when it has none.
+func (tx *Tx) vectorDim(bucket string) int {
+	if pb, ok := tx.pendingKeys[internalBucket(vectorBucketKind, bucket)]; ok {
+		for _, e := range pb.keys {
+			if e.Meta.Flag == DataSetFlag {
+				return len(e.Value) / 4
+			}
+		}
+		if pb.dropped {
+			return 0
+		}
+	}
+	if idx, ok := tx.db.vectors[bucket]; ok {
+		return idx.Dim()
+	}
+	return 0
+}
+
+// buildVectorIndexes builds the vector indexes from the vectors of the internal buckets, when opening the DB.
+func (db *DB) buildVectorIndexes() error {
+	db.vectors = make(map[string]*hnsw.Index)
+	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return nil
+	}
+
+	for bucket, idx := range db.BPTreeIdx {
+		if !strings.HasPrefix(bucket, vectorBucketPrefix) || idx == nil || idx.root == nil {
+			continue
+		}
+		_, keys, pointers := idx.getAll()
+		for i, key := range keys {
+			r := pointers[i].(*Record)
+			if _, ok := db.committedTxIds[r.H.Meta.TxID]; !ok || r.H.Meta.Flag == DataDeleteFlag {
+				continue
+			}
+			e, err := db.readValue(context.Background(), bucket, key, r)
+			if err != nil {
+				return err
+			}
+			db.applyVectorEntry(bucket, e)
+		}
+	}
+	return nil
+}
+
+// applyVectorEntry applies the committed entry to the vector index of its bucket, if it writes a vector.
+func (db *DB) applyVectorEntry(bucket string, entry *Entry) {
+	if !strings.HasPrefix(bucket, vectorBucketPrefix) || db.vectors == nil {
+		return
+	}
+	name := strings.TrimPrefix(bucket, vectorBucketPrefix)
+
+	if entry.Meta.Ds == DataStructureNone && entry.Meta.Flag == DataBPTreeBucketDeleteFlag {
+		delete(db.vectors, name)
+		return
+	}
+	if entry.Meta.Ds != DataStructureBPTree {
+		return
+	}
+
+	idx, ok := db.vectors[name]
+	if !ok {
+		idx = hnsw.New()
+		db.vectors[name] = idx
+	}
+	if entry.Meta.Flag == DataDeleteFlag {
+		idx.Remove(string(entry.Key))
+		return
+	}
+	_ = idx.Add(string(entry.Key), decodeVector(entry.Value))
+}
+
+// encodeVector encodes the vector as the little endian bits of its components.
+func encodeVector(vector []float32) []byte {
+	buf := make([]byte, 4*len(vector))
+	for i, x := range vector {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(x))
+	}
+	return buf
+}
+
+func decodeVector(buf []byte) []float32 {
+	vector := make([]float32, len(buf)/4)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:]))
+	}
+	return vector
+}
@@ -0,0 +1,99 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTx_VSearch(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestvector", true)
+	opt.SegmentSize = 256
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	bucket := "bucket_vector"
+	hitKeys := func(hits []VectorHit) []string {
+		keys := make([]string, len(hits))
+		for i, hit := range hits {
+			keys[i] = string(hit.Key)
+		}
+		return keys
+	}
+	search := func(query []float32, k int) []string {
+		var hits []VectorHit
+		require.NoError(t, db.View(func(tx *Tx) error {
+			var err error
+			hits, err = tx.VSearch(bucket, query, k)
+			return err
+		}))
+		return hitKeys(hits)
+	}
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		require.NoError(t, tx.VAdd(bucket, []byte("east"), []float32{1, 0}))
+		require.NoError(t, tx.VAdd(bucket, []byte("north"), []float32{0, 1}))
+		require.NoError(t, tx.VAdd(bucket, []byte("north_east"), []float32{1, 1}))
+		assert.Equal(t, ErrVectorDimension, tx.VAdd(bucket, []byte("up"), []float32{0, 0, 1}))
+
+		// the pending vectors of the tx are searched.
+		hits, err := tx.VSearch(bucket, []float32{2, 0.1}, 2)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"east", "north_east"}, hitKeys(hits))
+		return nil
+	}))
+
+	assert.Equal(t, []string{"north", "north_east"}, search([]float32{0.1, 2}, 2))
+	assert.Equal(t, []string{"north_east", "east", "north"}, search([]float32{1, 1}, 10))
+
+	// the vectors follow the updates and the removals, and are left as is by a rollback.
+	require.Error(t, db.Update(func(tx *Tx) error {
+		require.NoError(t, tx.VRem(bucket, []byte("north")))
+		require.NoError(t, tx.VAdd(bucket, []byte("east"), []float32{-1, 0}))
+		hits, err := tx.VSearch(bucket, []float32{0.1, 2}, 2)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"north_east", "east"}, hitKeys(hits))
+		return errors.New("rolled back")
+	}))
+	assert.Equal(t, []string{"north", "north_east"}, search([]float32{0.1, 2}, 2))
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		require.NoError(t, tx.VRem(bucket, []byte("north")))
+		assert.Error(t, tx.VRem(bucket, []byte("north")))
+		return tx.VAdd(bucket, []byte("west"), []float32{-1, 0})
+	}))
+	assert.Equal(t, []string{"north_east", "east", "west"}, search([]float32{1, 1}, 10))
+
+	require.NoError(t, db.View(func(tx *Tx) error {
+		_, err := tx.VSearch(bucket, []float32{1, 1, 1}, 1)
+		assert.Equal(t, ErrVectorDimension, err)
+		_, err = tx.VSearch("bucket_vector_missing", []float32{1, 1}, 1)
+		assert.Equal(t, ErrNotFoundBucket, err)
+		return nil
+	}))
+
+	// the index is rebuilt from the vectors on open.
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"north_east", "east", "west"}, search([]float32{1, 1}, 10))
+	require.NoError(t, db.Merge())
+	assert.Equal(t, []string{"west", "north_east"}, search([]float32{-1, 0.5}, 2))
+	require.NoError(t, db.Close())
+}
@@ -0,0 +1,112 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"sync/atomic"
+)
+
+// warmupBatchSize is the number of keys loaded by a single read-only transaction during warmup,
+// so that the warmup never holds the db lock for long.
+const warmupBatchSize = 128
+
+// Warmup tracks the preloading of keys running in the background.
+type Warmup struct {
+	total  int
+	loaded int64
+	done   chan struct{}
+	err    error
+}
+
+// Progress returns the number of keys already loaded and the total number of keys to load.
+func (w *Warmup) Progress() (loaded, total int) {
+	return int(atomic.LoadInt64(&w.loaded)), w.total
+}
+
+// Done returns a channel closed when the warmup is finished.
+func (w *Warmup) Done() <-chan struct{} {
+	return w.done
+}
+
+// Wait blocks until the warmup is finished and returns the error which stopped it, if any.
+func (w *Warmup) Wait() error {
+	<-w.done
+	return w.err
+}
+
+// Warmup preloads the values of the keys in the bucket in the background, so that
+// the data files pages are cached before the first requests come in.
+// Keys not found in the bucket are skipped.
+func (db *DB) Warmup(bucket string, keys [][]byte) *Warmup {
+	w := &Warmup{total: len(keys), done: make(chan struct{})}
+	go db.warmup(w, bucket, keys)
+	return w
+}
+
+// WarmupPrefix preloads the values of the keys with the prefix in the bucket in the background.
+func (db *DB) WarmupPrefix(bucket string, prefix []byte) *Warmup {
+	var keys [][]byte
+
+	err := db.View(func(tx *Tx) error {
+		if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+			return ErrNotSupportHintBPTSparseIdxMode
+		}
+		idx, ok := db.BPTreeIdx[bucket]
+		if !ok {
+			return nil
+		}
+		records, _, err := idx.PrefixScan(prefix, 0, ScanNoLimit)
+		if err != nil {
+			return nil
+		}
+		for _, r := range records {
+			keys = append(keys, r.H.Key)
+		}
+		return nil
+	})
+	if err != nil {
+		w := &Warmup{done: make(chan struct{}), err: err}
+		close(w.done)
+		return w
+	}
+
+	return db.Warmup(bucket, keys)
+}
+
+func (db *DB) warmup(w *Warmup, bucket string, keys [][]byte) {
+	defer close(w.done)
+
+	for start := 0; start < len(keys); start += warmupBatchSize {
+		end := start + warmupBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		err := db.View(func(tx *Tx) error {
+			for _, key := range keys[start:end] {
+				_, err := tx.Get(bucket, key)
+				if err != nil && err != ErrNotFoundKey && err != ErrKeyNotFound && err != ErrNotFoundBucket {
+					return err
+				}
+				atomic.AddInt64(&w.loaded, 1)
+			}
+			return nil
+		})
+		if err != nil {
+			w.err = err
+			return
+		}
+	}
+}
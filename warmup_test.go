@@ -0,0 +1,59 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_Warmup(t *testing.T) {
+	withRAMIdxDB(t, func(t *testing.T, db *DB) {
+		bucket := "bucket_warmup"
+		var keys [][]byte
+		err := db.Update(func(tx *Tx) error {
+			for i := 0; i < 300; i++ {
+				key := []byte(fmt.Sprintf("hot_%03d", i))
+				keys = append(keys, key)
+				if err := tx.Put(bucket, key, []byte("value"), Persistent); err != nil {
+					return err
+				}
+			}
+			return tx.Put(bucket, []byte("cold"), []byte("value"), Persistent)
+		})
+		require.NoError(t, err)
+
+		w := db.Warmup(bucket, append(keys, []byte("missing")))
+		assert.NoError(t, w.Wait())
+		loaded, total := w.Progress()
+		assert.Equal(t, 301, loaded)
+		assert.Equal(t, 301, total)
+
+		w = db.WarmupPrefix(bucket, []byte("hot_"))
+		<-w.Done()
+		assert.NoError(t, w.Wait())
+		loaded, total = w.Progress()
+		assert.Equal(t, 300, loaded)
+		assert.Equal(t, 300, total)
+
+		w = db.WarmupPrefix("bucket_none", []byte("hot_"))
+		assert.NoError(t, w.Wait())
+		_, total = w.Progress()
+		assert.Equal(t, 0, total)
+	})
+}
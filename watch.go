@@ -0,0 +1,389 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"bytes"
+	"context"
+	"sync"
+)
+
+// defaultWatchBufferSize is the WatchBufferSize used when the option is zero.
+const defaultWatchBufferSize = 1024
+
+// EventType is the kind of change of an Event.
+type EventType uint8
+
+const (
+	// EventPut is the set of a key of a key/value bucket.
+	EventPut EventType = iota
+
+	// EventDelete is the delete of a key of a key/value bucket.
+	EventDelete
+
+	// EventList is a change of a list, its Flag gives the operation.
+	EventList
+
+	// EventSet is a change of a set, its Flag gives the operation.
+	EventSet
+
+	// EventZSet is a change of a sorted set bucket, its Flag gives the operation.
+	EventZSet
+
+	// EventBucketDelete is the delete of a bucket, its Ds gives the data structure deleted.
+	EventBucketDelete
+)
+
+// WatchOrder is the ordering guarantee of the events received by a watcher.
+type WatchOrder uint8
+
+const (
+	// WatchKeyOrder sends the events of every key in the order of its commits, without a sequence number.
+	WatchKeyOrder WatchOrder = iota
+
+	// WatchGlobalOrder sends the events of all the keys in the order of the commits, numbered by their Seq
+	// in the change feed, so that a watcher can resume from the last one it processed. It needs Options.ChangeFeedEnable.
+	WatchGlobalOrder
+)
+
+// WatchOptions are the options of WatchWithOptions.
+type WatchOptions struct {
+	Order WatchOrder
+
+	// AfterSeq makes a watcher of the WatchGlobalOrder receive first the events of the change feed with a greater Seq,
+	// then the next ones without a gap. Zero resumes from the first event left in the feed, it is ignored by the WatchKeyOrder.
+	AfterSeq uint64
+
+	// Handler, when set, receives the events instead of a channel: it is called by the committing transaction once
+	// its changes are visible and before its Commit returns, so that e.g. a cache of the same process it invalidates
+	// never serves a value older than the ones written by the calls returned. It is called under the write lock of
+	// the db, in the order of the commits: it must neither block nor begin a transaction of the db. The ctx is the one
+	// of the committing tx, see Tx.Context, context.Background() for the events replayed by WatchWithOptions.
+	// Nil sends the events to the channel returned.
+	Handler func(ctx context.Context, e Event)
+}
+
+// Event is a committed change of a bucket sent to the watchers of the bucket.
+type Event struct {
+	// Seq is the sequence number of the event in the change feed, it grows with every event and is never reused.
+	// It is only set for the watchers of the WatchGlobalOrder.
+	Seq uint64
+
+	Type   EventType
+	Bucket string
+	Key    []byte
+
+	// Value is the value of the entry written, e.g. the value set by a put or the members added to a set.
+	Value []byte
+
+	// Ds and Flag are the data structure and the flag of the entry written.
+	Ds   uint16
+	Flag uint16
+
+	TTL       uint32
+	Timestamp uint64
+}
+
+// CancelFunc stops a watch and closes its channel.
+type CancelFunc func()
+
+// watcher is a subscriber of the changes of a bucket.
+type watcher struct {
+	bucket  string
+	prefix  []byte
+	global  bool
+	ch      chan Event
+	handler func(context.Context, Event) // see WatchOptions.Handler
+
+	// replaying is set while the events of the change feed are replayed to the watcher, the commits skip it
+	// until the replay caught up. stop is closed once it is removed meanwhile, the replay then closes ch.
+	replaying bool
+	stop      chan struct{}
+}
+
+// watchers sends the committed changes to the watchers of the buckets.
+type watchers struct {
+	mu      sync.Mutex
+	closed  bool
+	size    int
+	entries map[*watcher]struct{}
+}
+
+func newWatchers(size int) *watchers {
+	if size <= 0 {
+		size = defaultWatchBufferSize
+	}
+	return &watchers{size: size, entries: make(map[*watcher]struct{})}
+}
+
+// Watch returns a channel receiving the changes committed to the keys of the bucket starting with prefix,
+// in the WatchKeyOrder, once they are visible to the next transactions. An empty bucket watches
+// all the buckets, an empty prefix all the keys; the deletes of the bucket are sent whatever the prefix.
+//
+// The channel is closed by the CancelFunc, by Close, or once the watcher falls behind by more than
+// Options.WatchBufferSize events, the changes missed must then be read again from the db.
+// The merges and the internal buckets send no events.
+func (db *DB) Watch(bucket string, prefix []byte) (<-chan Event, CancelFunc) {
+	return db.watchers.add(newWatcher(bucket, prefix, false))
+}
+
+// WatchWithOptions is like Watch with the ordering guarantee of the options. A watcher of the WatchGlobalOrder
+// receives first the events of the change feed after opts.AfterSeq, read by pages of Options.WatchBufferSize
+// events as the channel is drained, then the next ones without a gap; the channel is also closed should the replay
+// fail. A consumer storing the Seq of the last event it processed along with its own writes processes every change
+// exactly once across reconnects and restarts.
+// With a WatchOptions.Handler the channel returned is nil, the events of the change feed are given to the handler
+// before WatchWithOptions returns, and the CancelFunc stops the calls.
+func (db *DB) WatchWithOptions(bucket string, prefix []byte, opts WatchOptions) (<-chan Event, CancelFunc, error) {
+	if opts.Order != WatchGlobalOrder {
+		w := newWatcher(bucket, prefix, false)
+		w.handler = opts.Handler
+		ch, cancel := db.watchers.add(w)
+		return ch, cancel, nil
+	}
+	if db.opt.EntryIdxMode == HintBPTSparseIdxMode {
+		return nil, nil, ErrNotSupportHintBPTSparseIdxMode
+	}
+	if !db.opt.ChangeFeedEnable {
+		return nil, nil, ErrChangeFeedDisabled
+	}
+
+	w := newWatcher(bucket, prefix, true)
+	w.handler = opts.Handler
+	w.replaying = true
+	w.stop = make(chan struct{})
+	ch, cancel := db.watchers.add(w)
+
+	if w.handler != nil {
+		if live, err := db.replayChanges(w, opts.AfterSeq); !live {
+			cancel()
+			return nil, nil, err
+		}
+		return nil, cancel, nil
+	}
+
+	go func() {
+		if live, _ := db.replayChanges(w, opts.AfterSeq); !live {
+			cancel()
+			close(w.ch)
+		}
+	}()
+	return ch, cancel, nil
+}
+
+// replayChanges gives w the events of the change feed after afterSeq, a page per read tx, until none is left:
+// w then gets the next events from the commits, which notify the watchers under the write lock, so that none
+// is missed between the replay and them. It returns whether w caught up, false once it is removed meanwhile.
+func (db *DB) replayChanges(w *watcher, afterSeq uint64) (bool, error) {
+	for {
+		var (
+			page []Event
+			live bool
+		)
+		err := db.View(func(tx *Tx) error {
+			var (
+				lastSeq uint64
+				err     error
+			)
+			page, lastSeq, err = tx.changeEvents(w, afterSeq, db.watchers.size)
+			if err != nil {
+				return err
+			}
+			if lastSeq == afterSeq {
+				live = db.watchers.catchUp(w)
+			}
+			afterSeq = lastSeq
+			return nil
+		})
+		if err != nil || live {
+			return live, err
+		}
+		for _, event := range page {
+			if w.handler != nil {
+				w.handler(context.Background(), event)
+				continue
+			}
+			select {
+			case w.ch <- event:
+			case <-w.stop:
+				return false, nil
+			}
+		}
+		select {
+		case <-w.stop:
+			return false, nil
+		default:
+		}
+	}
+}
+
+func newWatcher(bucket string, prefix []byte, global bool) *watcher {
+	return &watcher{bucket: bucket, prefix: append([]byte(nil), prefix...), global: global}
+}
+
+// add adds the watcher, with a channel unless it has a handler.
+func (ws *watchers) add(w *watcher) (<-chan Event, CancelFunc) {
+	if w.handler == nil {
+		w.ch = make(chan Event, ws.size)
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.closed {
+		if w.stop != nil {
+			close(w.stop)
+		} else if w.ch != nil {
+			close(w.ch)
+		}
+		return w.ch, func() {}
+	}
+	ws.entries[w] = struct{}{}
+
+	return w.ch, func() {
+		ws.mu.Lock()
+		defer ws.mu.Unlock()
+		ws.removeLocked(w)
+	}
+}
+
+// catchUp makes the watcher replaying the change feed get the events of the commits, unless it was removed.
+func (ws *watchers) catchUp(w *watcher) bool {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if _, ok := ws.entries[w]; !ok {
+		return false
+	}
+	w.replaying = false
+	return true
+}
+
+// notify sends the committed entries to the watchers of their buckets, along with the sequence numbers
+// given in the change feed to the entries at their indexes. The handlers get the ctx of the committing tx.
+func (ws *watchers) notify(ctx context.Context, entries []*Entry, seqs map[int]uint64) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if len(ws.entries) == 0 {
+		return
+	}
+
+	for i, e := range entries {
+		bucket := string(e.Bucket)
+		if isInternalBucket(bucket) {
+			continue
+		}
+
+		var event *Event
+		for w := range ws.entries {
+			if w.replaying || !w.matches(bucket, e) {
+				continue
+			}
+			if event == nil {
+				event = newEvent(bucket, e)
+			}
+			sent := *event
+			if w.global {
+				sent.Seq = seqs[i]
+			}
+			if w.handler != nil {
+				w.handler(ctx, sent)
+				continue
+			}
+			select {
+			case w.ch <- sent:
+			default:
+				// the watcher fell behind.
+				ws.removeLocked(w)
+			}
+		}
+	}
+}
+
+// closeAll closes the channels of all the watchers, once the db is closed.
+func (ws *watchers) closeAll() {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.closed = true
+	for w := range ws.entries {
+		ws.removeLocked(w)
+	}
+}
+
+func (ws *watchers) removeLocked(w *watcher) {
+	if _, ok := ws.entries[w]; !ok {
+		return
+	}
+	delete(ws.entries, w)
+	if w.replaying {
+		close(w.stop)
+	} else if w.ch != nil {
+		close(w.ch)
+	}
+}
+
+// matches returns whether the entry written to the bucket is watched by w.
+func (w *watcher) matches(bucket string, e *Entry) bool {
+	if w.bucket != "" && w.bucket != bucket {
+		return false
+	}
+	return e.Meta.Ds == DataStructureNone || bytes.HasPrefix(e.Key, w.prefix)
+}
+
+// matchesEvent returns whether the event of the change feed is watched by w.
+func (w *watcher) matchesEvent(event *Event) bool {
+	if w.bucket != "" && w.bucket != event.Bucket {
+		return false
+	}
+	return event.Type == EventBucketDelete || bytes.HasPrefix(event.Key, w.prefix)
+}
+
+func newEvent(bucket string, e *Entry) *Event {
+	event := &Event{
+		Bucket:    bucket,
+		Key:       append([]byte(nil), e.Key...),
+		Value:     append([]byte(nil), e.Value...),
+		Ds:        e.Meta.Ds,
+		Flag:      e.Meta.Flag,
+		TTL:       e.Meta.TTL,
+		Timestamp: e.Meta.Timestamp,
+	}
+
+	switch e.Meta.Ds {
+	case DataStructureBPTree:
+		event.Type = EventPut
+		if e.Meta.Flag == DataDeleteFlag {
+			event.Type = EventDelete
+		}
+	case DataStructureList:
+		event.Type = EventList
+	case DataStructureSet:
+		event.Type = EventSet
+	case DataStructureSortedSet:
+		event.Type = EventZSet
+	case DataStructureNone:
+		event.Type = EventBucketDelete
+		event.Key = nil
+		switch e.Meta.Flag {
+		case DataSetBucketDeleteFlag:
+			event.Ds = DataStructureSet
+		case DataSortedSetBucketDeleteFlag:
+			event.Ds = DataStructureSortedSet
+		case DataBPTreeBucketDeleteFlag:
+			event.Ds = DataStructureBPTree
+		case DataListBucketDeleteFlag:
+			event.Ds = DataStructureList
+		}
+	}
+	return event
+}
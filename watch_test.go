@@ -0,0 +1,328 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// drainEvents returns the events received until the channel is empty, and whether it is closed.
+func drainEvents(ch <-chan Event) (events []Event, closed bool) {
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				return events, true
+			}
+			events = append(events, e)
+		default:
+			return events, false
+		}
+	}
+}
+
+// receiveEvents returns the n next events of the channel, or the ones received within a second.
+func receiveEvents(ch <-chan Event, n int) (events []Event) {
+	timeout := time.After(time.Second)
+	for len(events) < n {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				return events
+			}
+			events = append(events, e)
+		case <-timeout:
+			return events
+		}
+	}
+	return events
+}
+
+func TestDB_Watch(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestwatch", true)
+	db, err = Open(opt)
+	require.NoError(t, err)
+
+	bucket := "bucket"
+	users, cancelUsers := db.Watch(bucket, []byte("user_"))
+	all, cancelAll := db.Watch("", nil)
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		if err := tx.Put(bucket, []byte("user_1"), []byte("v1"), Persistent); err != nil {
+			return err
+		}
+		return tx.Put(bucket, []byte("other"), []byte("v1"), Persistent)
+	}))
+	require.Error(t, db.Update(func(tx *Tx) error {
+		if err := tx.Put(bucket, []byte("user_2"), []byte("v1"), Persistent); err != nil {
+			return err
+		}
+		return errors.New("rolled back")
+	}))
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		if err := tx.Delete(bucket, []byte("user_1")); err != nil {
+			return err
+		}
+		if err := tx.RPush(bucket, []byte("user_list"), []byte("a")); err != nil {
+			return err
+		}
+		if err := tx.SAdd(bucket, []byte("user_set"), []byte("a")); err != nil {
+			return err
+		}
+		if err := tx.ZAdd(bucket, []byte("user_zset"), 1, []byte("a")); err != nil {
+			return err
+		}
+		return tx.DeleteBucket(DataStructureBPTree, bucket)
+	}))
+
+	events, closed := drainEvents(users)
+	assert.False(t, closed)
+	require.Len(t, events, 6)
+	assert.Equal(t, Event{Type: EventPut, Bucket: bucket, Key: []byte("user_1"), Value: []byte("v1"),
+		Ds: DataStructureBPTree, Flag: DataSetFlag, Timestamp: events[0].Timestamp}, events[0])
+	assert.Equal(t, EventDelete, events[1].Type)
+	assert.Equal(t, []byte("user_1"), events[1].Key)
+	assert.Equal(t, EventList, events[2].Type)
+	assert.Equal(t, DataRPushFlag, events[2].Flag)
+	assert.Equal(t, []byte("a"), events[2].Value)
+	assert.Equal(t, EventSet, events[3].Type)
+	assert.Equal(t, EventZSet, events[4].Type)
+	assert.Equal(t, EventBucketDelete, events[5].Type)
+	assert.Equal(t, DataStructureBPTree, events[5].Ds)
+
+	events, _ = drainEvents(all)
+	require.Len(t, events, 7)
+	assert.Equal(t, []byte("other"), events[1].Key)
+
+	cancelUsers()
+	_, closed = drainEvents(users)
+	assert.True(t, closed)
+	cancelUsers()
+
+	require.NoError(t, db.Close())
+	_, closed = drainEvents(all)
+	assert.True(t, closed)
+	cancelAll()
+
+	ch, _ := db.Watch(bucket, nil)
+	_, closed = drainEvents(ch)
+	assert.True(t, closed)
+}
+
+func TestDB_Watch_FallingBehind(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestwatchbehind", true)
+	db, err = Open(opt, WithWatchBufferSize(2))
+	require.NoError(t, err)
+
+	ch, cancel := db.Watch("bucket", nil)
+	defer cancel()
+	for i := 0; i < 3; i++ {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.Put("bucket", []byte("key"), []byte("value"), Persistent)
+		}))
+	}
+
+	events, closed := drainEvents(ch)
+	assert.Len(t, events, 2)
+	assert.True(t, closed)
+	require.NoError(t, db.Close())
+}
+
+func TestDB_WatchWithOptions_GlobalOrder(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestwatchglobal", true)
+	db, err = Open(opt)
+	require.NoError(t, err)
+	_, _, err = db.WatchWithOptions("", nil, WatchOptions{Order: WatchGlobalOrder})
+	assert.Equal(t, ErrChangeFeedDisabled, err)
+	require.NoError(t, db.Close())
+
+	db, err = Open(opt, WithChangeFeed(true))
+	require.NoError(t, err)
+
+	put := func(bucket, key string) {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.Put(bucket, []byte(key), []byte("value"), Persistent)
+		}))
+	}
+
+	ch, cancel, err := db.WatchWithOptions("", nil, WatchOptions{Order: WatchGlobalOrder})
+	require.NoError(t, err)
+	keyOrder, cancelKeyOrder := db.Watch("", nil)
+	defer cancelKeyOrder()
+
+	put("a", "k1")
+	put("b", "k2")
+	put("a", "k3")
+
+	events := receiveEvents(ch, 3)
+	require.Len(t, events, 3)
+	for i := 1; i < len(events); i++ {
+		assert.True(t, events[i].Seq > events[i-1].Seq)
+	}
+	assert.Equal(t, "b", events[1].Bucket)
+	last := events[1].Seq
+	cancel()
+
+	unnumbered, _ := drainEvents(keyOrder)
+	require.Len(t, unnumbered, 3)
+	assert.Equal(t, uint64(0), unnumbered[0].Seq)
+
+	// the consumer reconnects after a restart from the last event it processed.
+	require.NoError(t, db.Close())
+	db, err = Open(opt, WithChangeFeed(true))
+	require.NoError(t, err)
+	put("a", "k4")
+
+	ch, cancel, err = db.WatchWithOptions("a", nil, WatchOptions{Order: WatchGlobalOrder, AfterSeq: last})
+	require.NoError(t, err)
+	defer cancel()
+	put("a", "k5")
+	put("b", "k6")
+
+	events = receiveEvents(ch, 3)
+	require.Len(t, events, 3)
+	assert.Equal(t, []byte("k3"), events[0].Key)
+	assert.Equal(t, []byte("k4"), events[1].Key)
+	assert.Equal(t, []byte("k5"), events[2].Key)
+	assert.True(t, events[1].Seq > events[0].Seq && events[2].Seq > events[1].Seq)
+
+	require.NoError(t, db.TrimChangeFeed(events[1].Seq))
+	replayed, cancelReplayed, err := db.WatchWithOptions("a", nil, WatchOptions{Order: WatchGlobalOrder})
+	require.NoError(t, err)
+	defer cancelReplayed()
+	events = receiveEvents(replayed, 1)
+	require.Len(t, events, 1)
+	assert.Equal(t, []byte("k5"), events[0].Key)
+	put("b", "k7")
+	put("a", "k8")
+	events = receiveEvents(replayed, 1)
+	require.Len(t, events, 1)
+	assert.Equal(t, []byte("k8"), events[0].Key)
+	require.NoError(t, db.Close())
+}
+
+func TestDB_WatchWithOptions_ReplayPages(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestwatchpages", true)
+	db, err = Open(opt, WithChangeFeed(true), WithWatchBufferSize(4))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+
+	for i := 0; i < 50; i++ {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.Put("bucket", []byte(fmt.Sprintf("key_%02d", i)), []byte("value"), Persistent)
+		}))
+	}
+
+	// the replay of many more events than the buffer is sent as the channel is drained,
+	// followed by the events of the commits it caught up with.
+	ch, cancel, err := db.WatchWithOptions("bucket", nil, WatchOptions{Order: WatchGlobalOrder})
+	require.NoError(t, err)
+	defer cancel()
+	assert.True(t, cap(ch) == 4)
+
+	events := receiveEvents(ch, 40)
+	require.Len(t, events, 40)
+	for i := 50; i < 60; i++ {
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			return tx.Put("bucket", []byte(fmt.Sprintf("key_%02d", i)), []byte("value"), Persistent)
+		}))
+	}
+	events = append(events, receiveEvents(ch, 20)...)
+	require.Len(t, events, 60)
+	for i, event := range events {
+		assert.Equal(t, fmt.Sprintf("key_%02d", i), string(event.Key))
+		if i > 0 {
+			assert.True(t, event.Seq > events[i-1].Seq)
+		}
+	}
+
+	// the replay stops once the watch is canceled.
+	ch, cancelStopped, err := db.WatchWithOptions("bucket", nil, WatchOptions{Order: WatchGlobalOrder})
+	require.NoError(t, err)
+	cancelStopped()
+	events = receiveEvents(ch, 60)
+	assert.True(t, len(events) < 60)
+}
+
+func TestDB_WatchWithOptions_Handler(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestwatchhandler", true)
+	db, err = Open(opt, WithChangeFeed(true))
+	require.NoError(t, err)
+
+	// a cache of the same process is invalidated before the writes return.
+	var (
+		mu    sync.Mutex
+		cache = make(map[string][]byte)
+	)
+	ch, cancel, err := db.WatchWithOptions("bucket", nil, WatchOptions{Handler: func(ctx context.Context, event Event) {
+		// the events are given with the ctx of the committing tx.
+		assert.NotNil(t, ctx.Value(txCtxKey{}))
+		mu.Lock()
+		defer mu.Unlock()
+		delete(cache, string(event.Key))
+	}})
+	require.NoError(t, err)
+	assert.Nil(t, ch)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				mu.Lock()
+				cache[key] = []byte("stale")
+				mu.Unlock()
+				assert.NoError(t, db.Update(func(tx *Tx) error {
+					return tx.Put("bucket", []byte(key), []byte("value"), Persistent)
+				}))
+				mu.Lock()
+				_, ok := cache[key]
+				mu.Unlock()
+				assert.False(t, ok)
+			}
+		}(fmt.Sprintf("key_%d", i))
+	}
+	wg.Wait()
+	cancel()
+
+	// a handler of the WatchGlobalOrder gets the events replayed first.
+	var seqs []uint64
+	_, cancel, err = db.WatchWithOptions("bucket", nil, WatchOptions{Order: WatchGlobalOrder, Handler: func(ctx context.Context, event Event) {
+		seqs = append(seqs, event.Seq)
+	}})
+	require.NoError(t, err)
+	require.Len(t, seqs, 200)
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.Put("bucket", []byte("key"), []byte("value"), Persistent)
+	}))
+	require.Len(t, seqs, 201)
+	assert.True(t, seqs[200] > seqs[199])
+
+	cancel()
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.Put("bucket", []byte("key"), []byte("value"), Persistent)
+	}))
+	assert.Len(t, seqs, 201)
+	require.NoError(t, db.Close())
+}
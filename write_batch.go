@@ -0,0 +1,127 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+// defaultWriteBatchSize is the WriteBatchSize used when it is zero.
+const defaultWriteBatchSize = 4 << 20
+
+// WriteBatch buffers writes and commits them in transactions of up to Options.WriteBatchSize bytes each,
+// without waiting for each of them to be fsynced, which is much faster than an Update per write for
+// bulk loads. The write lock is only held while a transaction of the batch commits. The writes are not
+// atomic as a whole, and a WriteBatch is not safe for concurrent use.
+type WriteBatch struct {
+	db      *DB
+	writes  []batchWrite
+	size    int64
+	futures []*DurabilityFuture
+	err     error
+}
+
+// batchWrite is a write buffered by a WriteBatch.
+type batchWrite struct {
+	bucket     string
+	key, value []byte
+	ttl        uint32
+	flag       uint16
+	timestamp  uint64
+}
+
+// NewWriteBatch returns an empty WriteBatch writing to the db.
+func (db *DB) NewWriteBatch() *WriteBatch {
+	return &WriteBatch{db: db}
+}
+
+// Put sets the value for a key in the bucket, as Tx.Put does. The key and the value must not be modified
+// until Flush returns.
+func (wb *WriteBatch) Put(bucket string, key, value []byte, ttl uint32) error {
+	return wb.add(batchWrite{bucket: bucket, key: key, value: value, ttl: ttl, flag: DataSetFlag, timestamp: wb.db.timestamp()})
+}
+
+// Delete deletes a key from the bucket, as Tx.Delete does.
+func (wb *WriteBatch) Delete(bucket string, key []byte) error {
+	return wb.add(batchWrite{bucket: bucket, key: key, ttl: Persistent, flag: DataDeleteFlag, timestamp: wb.db.timestamp()})
+}
+
+// add buffers the write, and commits the buffered writes once they reach the size of a transaction.
+// Once a transaction of the batch failed, its writes are dropped and the error is returned by all
+// the next calls.
+func (wb *WriteBatch) add(w batchWrite) error {
+	if wb.err != nil {
+		return wb.err
+	}
+	if len(w.key) == 0 {
+		return ErrKeyEmpty
+	}
+	if len(w.bucket) > MAX_SIZE || len(w.key) > MAX_SIZE || len(w.value) > MAX_SIZE {
+		return ErrDataSizeExceed
+	}
+
+	wb.writes = append(wb.writes, w)
+	wb.size += int64(DataEntryHeaderSize + len(w.bucket) + len(w.key) + len(w.value))
+	if wb.size >= wb.db.opt.writeBatchSize() {
+		return wb.commit()
+	}
+	return nil
+}
+
+// commit writes the buffered writes in a transaction, without waiting for the fsync.
+func (wb *WriteBatch) commit() error {
+	if len(wb.writes) == 0 {
+		return nil
+	}
+	writes := wb.writes
+	wb.writes, wb.size = nil, 0
+
+	tx, err := wb.db.Begin(true)
+	if err != nil {
+		wb.err = err
+		return err
+	}
+	for _, w := range writes {
+		if err := tx.put(w.bucket, w.key, w.value, w.ttl, w.flag, w.timestamp, DataStructureBPTree); err != nil {
+			_ = tx.Rollback()
+			wb.err = err
+			return err
+		}
+	}
+	f, err := tx.CommitAsync()
+	if err != nil {
+		wb.err = err
+		return err
+	}
+	wb.futures = append(wb.futures, f)
+	return nil
+}
+
+// Flush commits the writes still buffered and waits until all the writes of the batch are fsynced.
+// It returns the first error met by the batch, after which the batch is empty and can be used again.
+func (wb *WriteBatch) Flush() error {
+	err := wb.err
+	if err == nil {
+		err = wb.commit()
+	}
+	for _, f := range wb.futures {
+		if ferr := f.Wait(); ferr != nil && err == nil {
+			err = ferr
+		}
+	}
+	wb.writes, wb.size, wb.futures, wb.err = nil, 0, nil, nil
+	return err
+}
+
+// Cancel drops the writes still buffered, the transactions already committed by the batch are kept.
+func (wb *WriteBatch) Cancel() {
+	wb.writes, wb.size = nil, 0
+}
@@ -0,0 +1,76 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteBatch(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestwritebatch", true)
+	opt.WriteBatchSize = 512
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	bucket := "bucket_write_batch"
+	key := func(i int) []byte { return []byte(fmt.Sprintf("key_%04d", i)) }
+
+	wb := db.NewWriteBatch()
+	for i := 0; i < 100; i++ {
+		require.NoError(t, wb.Put(bucket, key(i), []byte(fmt.Sprintf("value_%04d", i)), Persistent))
+	}
+	for i := 0; i < 100; i += 10 {
+		require.NoError(t, wb.Delete(bucket, key(i)))
+	}
+	assert.Equal(t, ErrKeyEmpty, wb.Put(bucket, nil, []byte("value"), Persistent))
+	assert.True(t, len(wb.futures) > 1)
+	require.NoError(t, wb.Flush())
+
+	check := func() {
+		require.NoError(t, db.View(func(tx *Tx) error {
+			for i := 0; i < 100; i++ {
+				e, err := tx.Get(bucket, key(i))
+				if i%10 == 0 {
+					assert.Error(t, err)
+					continue
+				}
+				require.NoError(t, err)
+				assert.Equal(t, []byte(fmt.Sprintf("value_%04d", i)), e.Value)
+			}
+			return nil
+		}))
+	}
+	check()
+
+	// the writes still buffered are dropped by Cancel.
+	require.NoError(t, wb.Put(bucket, []byte("canceled"), []byte("value"), Persistent))
+	wb.Cancel()
+	require.NoError(t, wb.Flush())
+	require.NoError(t, db.View(func(tx *Tx) error {
+		_, err := tx.Get(bucket, []byte("canceled"))
+		assert.Error(t, err)
+		return nil
+	}))
+
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	check()
+	require.NoError(t, db.Close())
+}
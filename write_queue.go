@@ -0,0 +1,56 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ErrTooManyWriters is returned by Begin when Options.MaxQueuedWriters writable transactions
+// are already waiting for the write lock.
+var ErrTooManyWriters = errors.New("too many writable transactions waiting for the write lock")
+
+// WriteQueue is the number of the transactions waiting to write.
+type WriteQueue struct {
+	// Writers is the number of the writable transactions waiting for the write lock.
+	Writers int
+
+	// Syncs is the number of the committed transactions waiting for the fsync of the background syncer,
+	// see CommitAsync.
+	Syncs int
+}
+
+// WriteQueue returns the number of the transactions waiting to write, so that an overload shows
+// before it builds latency.
+func (db *DB) WriteQueue() WriteQueue {
+	return WriteQueue{
+		Writers: int(atomic.LoadInt32(&db.queuedWriters)),
+		Syncs:   int(atomic.LoadInt32(&db.syncer.waiting)),
+	}
+}
+
+// queueWriter counts a writable transaction waiting for the write lock, unless Options.MaxQueuedWriters
+// are already waiting. The transaction calls the func returned once it holds the lock.
+func (db *DB) queueWriter() (func(), error) {
+	n := atomic.AddInt32(&db.queuedWriters, 1)
+	if max := db.opt.MaxQueuedWriters; max > 0 && int(n) > max {
+		atomic.AddInt32(&db.queuedWriters, -1)
+		return nil, ErrTooManyWriters
+	}
+	return func() {
+		atomic.AddInt32(&db.queuedWriters, -1)
+	}, nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2023 The nutsdb Author. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutsdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_WriteQueue(t *testing.T) {
+	InitOpt("/tmp/nutsdbtestwritequeue", true)
+	db, err := Open(opt, WithMaxQueuedWriters(2), WithSimulatedLatency(SimulatedLatency{Sync: FixedLatency(100 * time.Millisecond)}))
+	require.NoError(t, err)
+	defer db.Close()
+
+	holder, err := db.Begin(true)
+	require.NoError(t, err)
+	assert.Equal(t, WriteQueue{}, db.WriteQueue())
+
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			done <- db.Update(func(tx *Tx) error {
+				return tx.Put("bucket", []byte("key"), []byte("value"), Persistent)
+			})
+		}()
+	}
+	require.Eventually(t, func() bool {
+		return db.WriteQueue().Writers == 2
+	}, time.Second, time.Millisecond)
+
+	// the writers beyond the limit are turned down, the readers are not.
+	_, err = db.Begin(true)
+	assert.Equal(t, ErrTooManyWriters, err)
+	assert.Equal(t, 2, db.WriteQueue().Writers)
+
+	require.NoError(t, holder.Rollback())
+	for i := 0; i < 2; i++ {
+		require.NoError(t, <-done)
+	}
+	assert.Equal(t, WriteQueue{}, db.WriteQueue())
+
+	// the fsync of the syncer is delayed by the simulated latency.
+	tx, err := db.Begin(true)
+	require.NoError(t, err)
+	require.NoError(t, tx.Put("bucket", []byte("key"), []byte("value"), Persistent))
+	future, err := tx.CommitAsync()
+	require.NoError(t, err)
+	assert.Equal(t, WriteQueue{Syncs: 1}, db.WriteQueue())
+	require.NoError(t, future.Wait())
+	require.Eventually(t, func() bool {
+		return db.WriteQueue() == WriteQueue{}
+	}, time.Second, time.Millisecond)
+}